@@ -0,0 +1,730 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// backoff describes how long to wait between successive attempts to connect to the AMQP broker.
+type backoff struct {
+	initialDelay time.Duration
+	maxDelay     time.Duration
+	maxAttempts  int
+}
+
+// getBackoff extracts the AMQP reconnection settings from the configuration.
+func getBackoff(cfg *viper.Viper) *backoff {
+	return &backoff{
+		initialDelay: cfg.GetDuration("amqp.reconnect.initial-delay"),
+		maxDelay:     cfg.GetDuration("amqp.reconnect.max-delay"),
+		maxAttempts:  cfg.GetInt("amqp.reconnect.max-attempts"),
+	}
+}
+
+// exhausted returns true if the given number of attempts meets or exceeds the maximum number of attempts. A maximum
+// of zero or less means that there's no limit.
+func (b *backoff) exhausted(attempts int) bool {
+	return b.maxAttempts > 0 && attempts >= b.maxAttempts
+}
+
+// delay returns the amount of time to wait before the given retry attempt. The base delay doubles with each attempt
+// until it reaches the maximum delay, and random jitter is applied so that multiple instances don't retry in lockstep.
+// The jittered delay never exceeds the maximum delay.
+func (b *backoff) delay(attempt int) time.Duration {
+	d := b.initialDelay
+	if d <= 0 {
+		d = time.Millisecond
+	}
+	for i := 1; i < attempt && (b.maxDelay <= 0 || d < b.maxDelay); i++ {
+		d *= 2
+	}
+	if b.maxDelay > 0 && d > b.maxDelay {
+		d = b.maxDelay
+	}
+	d = d/2 + time.Duration(rand.Int63n(int64(d)))
+	if b.maxDelay > 0 && d > b.maxDelay {
+		d = b.maxDelay
+	}
+	return d
+}
+
+// deadLetterSettings describes where messages that can't be processed are sent. Dead lettering is disabled if the
+// exchange name is empty.
+type deadLetterSettings struct {
+	exchange   string
+	routingKey string
+	queue      string
+}
+
+// getDeadLetterSettings extracts the dead-letter settings from the configuration.
+func getDeadLetterSettings(cfg *viper.Viper) *deadLetterSettings {
+	return &deadLetterSettings{
+		exchange:   cfg.GetString("amqp.dead-letter.exchange"),
+		routingKey: cfg.GetString("amqp.dead-letter.routing-key"),
+		queue:      cfg.GetString("amqp.dead-letter.queue"),
+	}
+}
+
+// enabled returns true if dead lettering is enabled.
+func (dl *deadLetterSettings) enabled() bool {
+	return dl.exchange != ""
+}
+
+// queueSettings describes how the queue used to receive messages is declared.
+type queueSettings struct {
+	name                 string
+	durable              bool
+	autoDelete           bool
+	singleActiveConsumer bool
+	passive              bool
+	bind                 bool
+	args                 amqp.Table
+}
+
+// coerceQueueArgument converts a queue argument value from the configuration to a type that can be included in an
+// AMQP table. Strings and booleans are passed through unchanged, integers are widened to int64, and floating point
+// numbers are converted to int64 if they're integral, which covers settings such as x-max-length and x-message-ttl.
+// Non-integral floating point numbers are passed through unchanged. No other types are supported.
+func coerceQueueArgument(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string, bool:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v), nil
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported type %T; only strings, booleans, and numbers are supported", value)
+}
+
+// getQueueArguments converts the queue arguments in the configuration to an AMQP table.
+func getQueueArguments(cfg *viper.Viper) (amqp.Table, error) {
+	args := amqp.Table{}
+	for name, value := range cfg.GetStringMap("amqp.queue.arguments") {
+		coerced, err := coerceQueueArgument(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for queue argument %s (%v): %s", name, value, err)
+		}
+		args[name] = coerced
+	}
+	return args, nil
+}
+
+// getQueueSettings extracts the queue settings from the configuration. An error is returned if any of the queue
+// arguments can't be converted to a type supported by AMQP.
+func getQueueSettings(cfg *viper.Viper) (*queueSettings, error) {
+	args, err := getQueueArguments(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rejected messages are routed to the dead-letter exchange if one is configured.
+	deadLetter := getDeadLetterSettings(cfg)
+	if deadLetter.enabled() {
+		args["x-dead-letter-exchange"] = deadLetter.exchange
+		if deadLetter.routingKey != "" {
+			args["x-dead-letter-routing-key"] = deadLetter.routingKey
+		}
+	}
+
+	// RabbitMQ delivers messages to only one consumer at a time if single active consumer is enabled, which allows
+	// standby replicas to take over if the active replica goes away.
+	singleActiveConsumer := cfg.GetBool("amqp.queue.single-active-consumer")
+	if singleActiveConsumer {
+		args["x-single-active-consumer"] = true
+	}
+
+	return &queueSettings{
+		name:                 cfg.GetString("amqp.queue.name"),
+		durable:              cfg.GetBool("amqp.queue.durable"),
+		autoDelete:           cfg.GetBool("amqp.queue.auto-delete"),
+		singleActiveConsumer: singleActiveConsumer,
+		passive:              cfg.GetBool("amqp.queue.passive"),
+		bind:                 cfg.GetBool("amqp.queue.bind"),
+		args:                 args,
+	}, nil
+}
+
+// declareDeadLetterTopology declares the dead-letter exchange along with a queue that holds dead-lettered messages
+// so that operators can inspect and replay them.
+func declareDeadLetterTopology(ch *amqp.Channel, dl *deadLetterSettings) error {
+	err := ch.ExchangeDeclare(
+		dl.exchange, // exchange name
+		"direct",    // exchange type
+		true,        // durable
+		false,       // auto-delete flag
+		false,       // internal flag
+		false,       // no-wait flag
+		nil,         // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("unable to declare the dead-letter exchange '%s': %s", dl.exchange, err)
+	}
+
+	// The companion queue is optional; operators may prefer to bind their own queues to the exchange.
+	if dl.queue == "" {
+		return nil
+	}
+
+	_, err = ch.QueueDeclare(
+		dl.queue, // queue name
+		true,     // queue durable
+		false,    // queue auto-delete flag
+		false,    // queue exclusive flag
+		false,    // queue no-wait flag
+		nil,      // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("unable to declare the dead-letter queue '%s': %s", dl.queue, err)
+	}
+
+	logger.Log.Infof("binding key '%s' in exchange '%s' to queue '%s'", dl.routingKey, dl.exchange, dl.queue)
+	err = ch.QueueBind(
+		dl.queue,      // queue name
+		dl.routingKey, // routing key
+		dl.exchange,   // exchange name
+		false,         // no-wait flag
+		nil,           // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("unable to bind the dead-letter queue '%s': %s", dl.queue, err)
+	}
+
+	return nil
+}
+
+// queueDeclarer describes the AMQP channel operations required to declare a queue.
+type queueDeclarer interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+}
+
+// declareQueue declares the queue used to receive messages. If the queue already exists with different settings then
+// the broker rejects the declaration with PRECONDITION_FAILED, which is reported with an explanation because the
+// raw AMQP error doesn't make the cause obvious.
+func declareQueue(ch queueDeclarer, settings *queueSettings) (amqp.Queue, error) {
+	if settings.passive {
+		return declareQueuePassive(ch, settings)
+	}
+
+	queue, err := ch.QueueDeclare(
+		settings.name,       // queue name
+		settings.durable,    // queue durable
+		settings.autoDelete, // queue auto-delete flag
+		false,               // queue exclusive flag
+		false,               // queue no-wait flag
+		settings.args,       // arguments
+	)
+	if err == nil {
+		return queue, nil
+	}
+
+	amqpErr, ok := err.(*amqp.Error)
+	if ok && amqpErr.Code == amqp.PreconditionFailed && strings.Contains(amqpErr.Reason, "x-single-active-consumer") {
+		return queue, fmt.Errorf(
+			"the queue '%s' already exists with a different single active consumer setting, which can't be "+
+				"changed on an existing queue; delete the queue so that it can be recreated or set "+
+				"amqp.queue.single-active-consumer to %t: %s",
+			settings.name, !settings.singleActiveConsumer, err,
+		)
+	}
+	if ok && amqpErr.Code == amqp.PreconditionFailed {
+		return queue, fmt.Errorf(
+			"the queue '%s' already exists with settings that differ from the configured settings "+
+				"(durable: %t, auto-delete: %t, arguments: %v); delete the queue or update the configuration "+
+				"to match: %s",
+			settings.name, settings.durable, settings.autoDelete, settings.args, err,
+		)
+	}
+	return queue, fmt.Errorf("unable to declare the queue '%s': %s", settings.name, err)
+}
+
+// declareQueuePassive verifies that a queue that was provisioned outside of the service exists. The broker doesn't
+// compare the queue's settings in this case, so the service doesn't need permission to configure the queue.
+func declareQueuePassive(ch queueDeclarer, settings *queueSettings) (amqp.Queue, error) {
+	queue, err := ch.QueueDeclarePassive(
+		settings.name, // queue name
+		false,         // queue durable
+		false,         // queue auto-delete flag
+		false,         // queue exclusive flag
+		false,         // queue no-wait flag
+		nil,           // arguments
+	)
+	if err == nil {
+		return queue, nil
+	}
+
+	if amqpErr, ok := err.(*amqp.Error); ok && amqpErr.Code == amqp.NotFound {
+		return queue, fmt.Errorf(
+			"the queue '%s' has not been provisioned; create the queue or disable amqp.queue.passive: %s",
+			settings.name, err,
+		)
+	}
+	return queue, fmt.Errorf("unable to verify that the queue '%s' exists: %s", settings.name, err)
+}
+
+// amqpSession represents an AMQP connection along with the channel used to consume messages.
+type amqpSession struct {
+	conn        *amqp.Connection
+	ch          *amqp.Channel
+	queue       string
+	consumerTag string
+	messages    <-chan amqp.Delivery
+	connClosed  chan *amqp.Error
+	chClosed    chan *amqp.Error
+	cancelled   chan string
+	publisher   amqpPublisher
+}
+
+// cancel cancels the session's consumer. The broker stops sending deliveries, and the delivery channel is closed once
+// the deliveries that have already been received are consumed.
+func (s *amqpSession) cancel() error {
+	if s.ch == nil {
+		return nil
+	}
+	return s.ch.Cancel(s.consumerTag, false)
+}
+
+// purge removes all of the messages that are waiting in the session's queue and returns the number of messages that
+// were removed. Messages that have been delivered but not acknowledged aren't affected.
+func (s *amqpSession) purge() (int, error) {
+	if s.ch == nil {
+		return 0, nil
+	}
+	return s.ch.QueuePurge(s.queue, false)
+}
+
+// close closes the AMQP connection associated with a session, which also closes the channel.
+func (s *amqpSession) close() {
+	if s.conn != nil {
+		closeAmqpConnection(s.conn)
+	}
+}
+
+// getTLSConfig builds the TLS configuration used to connect to the AMQP broker. If none of the TLS settings are
+// present then nil is returned, in which case the AMQP library's defaults are used for amqps URIs.
+func getTLSConfig(cfg *viper.Viper) (*tls.Config, error) {
+	caCert := cfg.GetString("amqp.tls.ca-cert")
+	clientCert := cfg.GetString("amqp.tls.client-cert")
+	clientKey := cfg.GetString("amqp.tls.client-key")
+	insecureSkipVerify := cfg.GetBool("amqp.tls.insecure-skip-verify")
+
+	// Don't build a TLS configuration if none of the settings are present.
+	if caCert == "" && clientCert == "" && clientKey == "" && !insecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+
+	// Load the CA certificate if one was specified.
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the AMQP CA certificate %s: %s", caCert, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificates found in the AMQP CA certificate %s", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	// Load the client certificate if one was specified. The certificate and key must be specified together.
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" || clientKey == "" {
+			return nil, fmt.Errorf("amqp.tls.client-cert and amqp.tls.client-key must be specified together")
+		}
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to load the AMQP client certificate %s or key %s: %s", clientCert, clientKey, err,
+			)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// Default AMQP connection settings. These match the defaults used by the AMQP library.
+const (
+	defaultHeartbeat   = 10 * time.Second
+	defaultDialTimeout = 30 * time.Second
+	defaultLocale      = "en_US"
+)
+
+// getPositiveDuration returns a duration setting from the configuration, substituting the given default value if the
+// setting is zero. An error is returned if the setting is negative.
+func getPositiveDuration(cfg *viper.Viper, key string, defaultValue time.Duration) (time.Duration, error) {
+	d := cfg.GetDuration(key)
+	if d < 0 {
+		return 0, fmt.Errorf("%s must not be negative: %s", key, d)
+	}
+	if d == 0 {
+		return defaultValue, nil
+	}
+	return d, nil
+}
+
+// amqpDialer establishes connections to the AMQP broker. If several broker URIs are configured, each connection attempt
+// uses the next URI in the list so that the service fails over to another node in the cluster when one node is down.
+type amqpDialer struct {
+	identity    *clientIdentity
+	uris        []string
+	next        uint32
+	tlsConfig   *tls.Config
+	heartbeat   time.Duration
+	dialTimeout time.Duration
+	backoff     *backoff
+}
+
+// newAmqpDialer creates a new AMQP dialer from the configuration. An error is returned if the TLS settings can't be
+// loaded or if TLS settings are present for a URI that doesn't use the amqps scheme.
+func newAmqpDialer(cfg *viper.Viper, identity *clientIdentity) (*amqpDialer, error) {
+
+	// The list of broker URIs takes precedence over the single URI.
+	uris := getStringList(cfg, "amqp.uris")
+	if len(uris) == 0 {
+		uris = []string{cfg.GetString("amqp.uri")}
+	}
+
+	// Load the TLS configuration.
+	tlsConfig, err := getTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// The AMQP library only uses TLS for amqps URIs, so TLS settings for any other scheme are almost certainly a
+	// configuration error.
+	for _, uri := range uris {
+		parsed, err := url.Parse(uri)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the AMQP URI: %s", err)
+		}
+		if tlsConfig != nil && parsed.Scheme != "amqps" {
+			return nil, fmt.Errorf("AMQP TLS settings require an amqps URI, but the URI scheme is %s", parsed.Scheme)
+		}
+	}
+
+	// Load the heartbeat interval and dial timeout.
+	heartbeat, err := getPositiveDuration(cfg, "amqp.heartbeat", defaultHeartbeat)
+	if err != nil {
+		return nil, err
+	}
+	dialTimeout, err := getPositiveDuration(cfg, "amqp.dial-timeout", defaultDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log.Infof("AMQP heartbeat interval: %s, dial timeout: %s", heartbeat, dialTimeout)
+
+	return &amqpDialer{
+		identity:    identity,
+		uris:        uris,
+		tlsConfig:   tlsConfig,
+		heartbeat:   heartbeat,
+		dialTimeout: dialTimeout,
+		backoff:     getBackoff(cfg),
+	}, nil
+}
+
+// dial establishes the network connection to the AMQP broker. The dial timeout is also used as the deadline for the
+// TLS and AMQP handshakes; the AMQP library clears the deadline once the connection is established.
+func (d *amqpDialer) dial(network, addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, addr, d.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(d.dialTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// dialOnce makes a single attempt to connect to the AMQP broker using the next broker URI in the list. The host name
+// in the URI is resolved again for every attempt.
+func (d *amqpDialer) dialOnce() (*amqp.Connection, error) {
+	uri := d.uris[int(atomic.AddUint32(&d.next, 1)-1)%len(d.uris)]
+	conn, err := amqp.DialConfig(uri, amqp.Config{
+		Heartbeat:       d.heartbeat,
+		TLSClientConfig: d.tlsConfig,
+		Properties:      d.identity.clientProperties(),
+		Locale:          defaultLocale,
+		Dial:            d.dial,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", redactURI(uri), err)
+	}
+
+	logger.Log.Infof("connected to AMQP broker at %s", redactURI(uri))
+	return conn, nil
+}
+
+// redactURI removes the credentials from an AMQP URI so that it can be logged.
+func redactURI(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "an unparseable URI"
+	}
+	parsed.User = nil
+	return parsed.String()
+}
+
+// getAmqpConnection establishes a connection to the AMQP broker.
+func getAmqpConnection(d *amqpDialer) (*amqp.Connection, error) {
+	b := d.backoff
+	conn, err := d.dialOnce()
+	if err == nil {
+		return conn, nil
+	}
+
+	// The first attempt failed. Keep trying until we connect or reach the maximum number of retries.
+	for attempt := 1; !b.exhausted(attempt); attempt++ {
+		delay := b.delay(attempt)
+		logger.Log.Errorf("failed to connect to AMQP: %s", err)
+		logger.Log.Infof("connection attempt %d: trying to connect again in %s", attempt, delay)
+		time.Sleep(delay)
+		conn, err = d.dialOnce()
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	// We've reached the maximum number of connection attempts.
+	logger.Log.Error("failed to connect after maximum number of attempts - giving up")
+	return nil, err
+}
+
+// closeAmqpConnection closes an AMQP connection and logs a warning if it can't be closed.
+func closeAmqpConnection(conn *amqp.Connection) {
+	err := conn.Close()
+	if err != nil && err != amqp.ErrClosed {
+		logger.Log.Warnf("failed to close the AMQP connection: %s", err)
+	}
+}
+
+// bindExchange binds a queue to an exchange using each of the exchange's routing keys. The exchange is declared first
+// if it's configured to be declared; otherwise, it must already exist.
+func bindExchange(ch *amqp.Channel, exchange *exchangeBinding, queue string) error {
+	if exchange.declare {
+		logger.Log.Infof("declaring %s exchange '%s'", exchange.kind, exchange.name)
+		err := ch.ExchangeDeclare(
+			exchange.name, // exchange name
+			exchange.kind, // exchange type
+			true,          // durable
+			false,         // auto-delete flag
+			false,         // internal flag
+			false,         // no-wait flag
+			nil,           // arguments
+		)
+		if err != nil {
+			return fmt.Errorf("unable to declare the exchange '%s': %s", exchange.name, err)
+		}
+	}
+
+	for _, routingKey := range exchange.routingKeys {
+		logger.Log.Infof("binding key '%s' in exchange '%s' to queue '%s'", routingKey, exchange.name, queue)
+		err := ch.QueueBind(
+			queue,         // queue name
+			routingKey,    // routing key
+			exchange.name, // exchange name
+			false,         // no-wait flag
+			nil,           // arguments
+		)
+		if err != nil {
+			return bindError(exchange, routingKey, err)
+		}
+	}
+
+	return nil
+}
+
+// bindError returns the error to report when a queue can't be bound to an exchange. The broker reports a missing
+// exchange as a NOT_FOUND channel exception, which is translated into a more helpful message.
+func bindError(exchange *exchangeBinding, routingKey string, err error) error {
+	if amqpErr, ok := err.(*amqp.Error); ok && amqpErr.Code == amqp.NotFound {
+		return fmt.Errorf(
+			"the exchange '%s' does not exist; create the exchange or enable declare in its amqp.exchange "+
+				"settings: %s",
+			exchange.name, err,
+		)
+	}
+	return fmt.Errorf("unable to bind %s in exchange '%s' to the AMQP queue: %s", routingKey, exchange.name, err)
+}
+
+// consumerSettings describes the flags used to create the consumer.
+type consumerSettings struct {
+	tag       string
+	exclusive bool
+	noLocal   bool
+	noWait    bool
+}
+
+// getConsumerSettings returns the consumer settings from the configuration. A tag derived from the client identity is
+// used if no tag is configured.
+func getConsumerSettings(cfg *viper.Viper, identity *clientIdentity) *consumerSettings {
+	tag := strings.TrimSpace(cfg.GetString("amqp.consumer.tag"))
+	if tag == "" {
+		tag = identity.consumerTag()
+	}
+	return &consumerSettings{
+		tag:       tag,
+		exclusive: cfg.GetBool("amqp.consumer.exclusive"),
+		noLocal:   cfg.GetBool("amqp.consumer.no-local"),
+		noWait:    cfg.GetBool("amqp.consumer.no-wait"),
+	}
+}
+
+// consumeError returns the error to report when the consumer can't be created. The broker refuses access to the queue
+// if an exclusive consumer is requested while another consumer exists, which is translated into a more helpful message.
+func consumeError(queue string, consumer *consumerSettings, err error) error {
+	if amqpErr, ok := err.(*amqp.Error); ok && consumer.exclusive && amqpErr.Code == amqp.AccessRefused {
+		return fmt.Errorf(
+			"unable to obtain exclusive access to the queue '%s' because another consumer is using it; stop the "+
+				"other consumer or disable amqp.consumer.exclusive: %s",
+			queue, err,
+		)
+	}
+	return fmt.Errorf("unable to consume AMQP messages: %s", err)
+}
+
+// getMsgChannel establishes a connection to the AMQP Broker and returns a session to use for receiving messages.
+func getMsgChannel(
+	cfg *viper.Viper,
+	dialer *amqpDialer,
+	exchanges []*exchangeBinding,
+	queueSettings *queueSettings,
+	retry *retrySettings,
+) (*amqpSession, error) {
+	manualAck := cfg.GetBool("amqp.manual-ack")
+	prefetchCount := cfg.GetInt("amqp.prefetch-count")
+
+	// Establish the AMQP connection.
+	conn, err := getAmqpConnection(dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the AMQP channel.
+	ch, err := conn.Channel()
+	if err != nil {
+		closeAmqpConnection(conn)
+		return nil, err
+	}
+
+	// Nothing is declared in passive mode. The dead-letter and retry topology must have been provisioned along with the
+	// queue.
+	if queueSettings.passive {
+		logger.Log.Infof("passive mode enabled; assuming that queue '%s' has been provisioned", queueSettings.name)
+	}
+
+	// Declare the dead-letter exchange and queue if dead lettering is enabled.
+	if deadLetter := getDeadLetterSettings(cfg); deadLetter.enabled() && !queueSettings.passive {
+		if err = declareDeadLetterTopology(ch, deadLetter); err != nil {
+			closeAmqpConnection(conn)
+			return nil, err
+		}
+	}
+
+	// Declare the queue.
+	queue, err := declareQueue(ch, queueSettings)
+	if err != nil {
+		closeAmqpConnection(conn)
+		return nil, err
+	}
+	if queueSettings.singleActiveConsumer && queue.Consumers > 0 {
+		logger.Log.Infof(
+			"queue '%s' already has %d consumers; standing by until this replica becomes the active consumer",
+			queue.Name, queue.Consumers,
+		)
+	}
+
+	// Bind the queue to each of the exchanges unless the bindings have been provisioned separately.
+	if queueSettings.bind {
+		for _, exchange := range exchanges {
+			if err = bindExchange(ch, exchange, queue.Name); err != nil {
+				closeAmqpConnection(conn)
+				return nil, err
+			}
+		}
+	} else {
+		logger.Log.Infof("queue bindings disabled; assuming that queue '%s' has been bound", queue.Name)
+	}
+
+	// Declare the retry queue if delayed retries are enabled.
+	if retry != nil && !queueSettings.passive {
+		if err = declareRetryTopology(ch, retry, queue.Name); err != nil {
+			closeAmqpConnection(conn)
+			return nil, err
+		}
+	}
+
+	// Limit the number of unacknowledged messages the broker will deliver at once. A prefetch count of zero means
+	// that there's no limit. Note that the prefetch count only has an effect when manual acknowledgements are enabled.
+	if prefetchCount > 0 {
+		logger.Log.Infof("setting the AMQP prefetch count to %d", prefetchCount)
+		if err = ch.Qos(prefetchCount, 0, false); err != nil {
+			closeAmqpConnection(conn)
+			return nil, fmt.Errorf("unable to set the AMQP prefetch count: %s", err)
+		}
+	} else {
+		logger.Log.Info("no AMQP prefetch count configured; the broker will not limit unacknowledged deliveries")
+	}
+
+	// Register for consumer cancellation and close notifications on both the connection and the channel before
+	// consuming so that no notifications are missed. The notification channels are buffered because the AMQP library
+	// blocks until each notification is received.
+	cancelled := ch.NotifyCancel(make(chan string, 1))
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	// Create the consumer channel. A consumer tag is always assigned explicitly so that the consumer can be cancelled
+	// and identified in the RabbitMQ management interface.
+	consumer := getConsumerSettings(cfg, dialer.identity)
+	messages, err := ch.Consume(
+		queue.Name,         // queue name
+		consumer.tag,       // consumer name,
+		!manualAck,         // auto-ack flag
+		consumer.exclusive, // exclusive flag
+		consumer.noLocal,   // no-local flag
+		consumer.noWait,    // no-wait flag
+		nil,                // args
+	)
+	if err != nil {
+		closeAmqpConnection(conn)
+		return nil, consumeError(queue.Name, consumer, err)
+	}
+
+	return &amqpSession{
+		conn:        conn,
+		ch:          ch,
+		queue:       queue.Name,
+		consumerTag: consumer.tag,
+		messages:    messages,
+		connClosed:  connClosed,
+		chClosed:    chClosed,
+		cancelled:   cancelled,
+		publisher:   ch,
+	}, nil
+}
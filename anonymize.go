@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/spf13/viper"
+)
+
+// defaultAnonymizationBatchSize is the number of events rewritten by each statement if no batch size is configured.
+const defaultAnonymizationBatchSize = 1000
+
+// anonymizationBatchTimeout is the amount of time allowed for rewriting each batch of events.
+const anonymizationBatchTimeout = time.Minute
+
+// pseudonymPrefix is the prefix of the pseudonyms that replace the names of anonymized users.
+const pseudonymPrefix = "anon-"
+
+// anonymizationSettings describes how users are anonymized in the event log.
+type anonymizationSettings struct {
+	key       string
+	batchSize int
+}
+
+// getAnonymizationSettings loads the settings used to anonymize users. The key is only required if events are going to
+// be rewritten, so that dry runs can be performed without it.
+func getAnonymizationSettings(cfg *viper.Viper, requireKey bool) (*anonymizationSettings, error) {
+	key, source, err := getCredential(cfg, "db.anonymization.key", "DATAONE_ANONYMIZATION_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if requireKey && key == "" {
+		return nil, fmt.Errorf("db.anonymization.key must be set to anonymize users")
+	}
+	if key != "" {
+		logger.Log.Infof("loaded db.anonymization.key from %s", source)
+	}
+
+	batchSize := cfg.GetInt("db.anonymization.batch-size")
+	if batchSize < 0 {
+		return nil, fmt.Errorf("db.anonymization.batch-size must not be negative: %d", batchSize)
+	}
+	if batchSize == 0 {
+		batchSize = defaultAnonymizationBatchSize
+	}
+	return &anonymizationSettings{key: key, batchSize: batchSize}, nil
+}
+
+// parseSubject parses the user to anonymize, which is identified by name and zone in the same form that's used in
+// idempotency keys, as in ipcdev#iplant.
+func parseSubject(subject string) (*model.User, error) {
+	i := strings.LastIndex(subject, "#")
+	if i <= 0 || i == len(subject)-1 {
+		return nil, fmt.Errorf("invalid user '%s': expected NAME#ZONE", subject)
+	}
+	return &model.User{Name: subject[:i], Zone: subject[i+1:]}, nil
+}
+
+// pseudonym returns the pseudonym that replaces a user's name. The pseudonym is derived from the user with an HMAC, so
+// the same user always receives the same pseudonym from the same key, but the user can't be determined from the
+// pseudonym without the key.
+func pseudonym(key string, user *model.User) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(user.String()))
+	return pseudonymPrefix + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// anonymizeEvents replaces a user with a pseudonym in every event in the event log that identifies the user, one batch
+// at a time, logging its progress after each batch. It returns the number of events that were rewritten. Each batch is
+// committed separately, so an interrupted run can be resumed by running it again.
+func anonymizeEvents(
+	ctx context.Context, db *sql.DB, user *model.User, pseudonym string, batchSize int,
+) (int64, error) {
+	var total int64
+	for {
+		batchCtx, cancel := context.WithTimeout(ctx, anonymizationBatchTimeout)
+		rewritten, err := database.AnonymizeUserEvents(batchCtx, db, user, pseudonym, batchSize)
+		cancel()
+		total += rewritten
+		if err != nil {
+			return total, err
+		}
+		if rewritten > 0 {
+			logger.Log.Infof("anonymized %d events for %s so far", total, user)
+		}
+		if rewritten < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// anonymizeUser replaces the user named on the command line with a pseudonym in the event log, or reports the number
+// of events that identify the user if this is a dry run.
+func anonymizeUser() {
+	cfg := initConfig()
+	user, err := parseSubject(*anonymizeSubject)
+	if err != nil {
+		logger.Log.Fatalf("%s", err)
+	}
+	settings, err := getAnonymizationSettings(cfg, !*anonymizeDryRun)
+	if err != nil {
+		logger.Log.Fatalf("invalid anonymization settings: %s", err)
+	}
+
+	db := initDatabase(cfg)
+	defer db.Close()
+
+	ctx := context.Background()
+	if *anonymizeDryRun {
+		count, err := database.CountUserEvents(ctx, db, user)
+		if err != nil {
+			logger.Log.Fatalf("unable to count the events for %s: %s", user, err)
+		}
+		logger.Log.Infof("dry run: %d events identify %s", count, user)
+		return
+	}
+
+	start := time.Now()
+	total, err := anonymizeEvents(ctx, db, user, pseudonym(settings.key, user), settings.batchSize)
+	if err != nil {
+		logger.Log.Fatalf("unable to anonymize %s after rewriting %d events: %s", user, total, err)
+	}
+	logger.Log.Infof("anonymized %s in %d events in %s", user, total, time.Since(start))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/spf13/viper"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestParseSubject verifies that the user to anonymize is parsed correctly.
+func TestParseSubject(t *testing.T) {
+	tests := []struct {
+		subject   string
+		expected  model.User
+		expectErr bool
+	}{
+		{"ipcdev#iplant", model.User{Name: "ipcdev", Zone: "iplant"}, false},
+		{"odd#name#iplant", model.User{Name: "odd#name", Zone: "iplant"}, false},
+		{"ipcdev", model.User{}, true},
+		{"#iplant", model.User{}, true},
+		{"ipcdev#", model.User{}, true},
+	}
+
+	for _, test := range tests {
+		user, err := parseSubject(test.subject)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.subject, test.expectErr, err)
+			continue
+		}
+		if err == nil && *user != test.expected {
+			t.Errorf("%s: unexpected user: %+v", test.subject, user)
+		}
+	}
+}
+
+// TestPseudonym verifies that pseudonyms are consistent for each user and key, and that they don't reveal the user.
+func TestPseudonym(t *testing.T) {
+	user := &model.User{Name: "ipcdev", Zone: "iplant"}
+	p := pseudonym("secret", user)
+	if !strings.HasPrefix(p, pseudonymPrefix) || strings.Contains(p, user.Name) {
+		t.Errorf("unexpected pseudonym: %s", p)
+	}
+	if other := pseudonym("secret", user); other != p {
+		t.Errorf("expected the pseudonym to be consistent: %s != %s", other, p)
+	}
+	if other := pseudonym("other", user); other == p {
+		t.Error("expected different keys to produce different pseudonyms")
+	}
+	if other := pseudonym("secret", &model.User{Name: "other", Zone: "iplant"}); other == p {
+		t.Error("expected different users to receive different pseudonyms")
+	}
+}
+
+// TestGetAnonymizationSettings verifies that the anonymization settings are loaded and validated correctly.
+func TestGetAnonymizationSettings(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		batchSize  int
+		requireKey bool
+		expected   int
+		expectErr  bool
+	}{
+		{"defaults", "secret", 1000, true, 1000, false},
+		{"default batch size", "secret", 0, true, defaultAnonymizationBatchSize, false},
+		{"dry run without a key", "", 1000, false, 1000, false},
+		{"missing key", "", 1000, true, 0, true},
+		{"negative batch size", "secret", -1, true, 0, true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("db.anonymization.key", test.key)
+		cfg.Set("db.anonymization.batch-size", test.batchSize)
+
+		settings, err := getAnonymizationSettings(cfg, test.requireKey)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+			continue
+		}
+		if err == nil && (settings.key != test.key || settings.batchSize != test.expected) {
+			t.Errorf("%s: unexpected settings: %+v", test.name, settings)
+		}
+	}
+}
+
+// TestAnonymizeEvents verifies that a user's events are rewritten in batches until a batch isn't full.
+func TestAnonymizeEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	mock.ExpectExec("UPDATE event_log SET").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE event_log SET").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	user := &model.User{Name: "ipcdev", Zone: "iplant"}
+	total, err := anonymizeEvents(context.Background(), db, user, "anon-fake", 2)
+	if err != nil {
+		t.Fatalf("error encountered while anonymizing events: %s", err)
+	}
+	if total != 3 {
+		t.Errorf("expected 3 events to be rewritten, got %d", total)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/metrics"
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/spf13/viper"
+)
+
+// The kinds of users whose reads are counted in the periodic summary.
+const (
+	readerAnonymous     = "anonymous"
+	readerAuthenticated = "authenticated"
+)
+
+// readUsers counts recorded reads by the kind of user who made them, since usage reports distinguish public downloads
+// from downloads by users who have logged in.
+var readUsers = metrics.NewCounters("read_users", "recorded reads by kind of user")
+
+// getAnonymousSubject returns the subject under which reads by anonymous users are recorded. The DataONE public
+// subject is used if no subject is configured.
+func getAnonymousSubject(cfg *viper.Viper) string {
+	if subject := strings.TrimSpace(cfg.GetString("dataone.anonymous-subject")); subject != "" {
+		return subject
+	}
+	return model.PublicSubject
+}
+
+// getReadKeys returns the routing keys of the messages that describe reads of data objects, including previews.
+func getReadKeys(cfg *viper.Viper) []string {
+	keyNames := getRoutingKeys(cfg)
+	return append(append([]string{}, keyNames.Read...), keyNames.Preview...)
+}
+
+// isReadKey determines whether or not a routing key is used for messages that describe reads of data objects.
+func (svc *DataoneIndexer) isReadKey(key string) bool {
+	for _, pattern := range svc.readKeys {
+		if database.MatchRoutingKey(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveAnonymousReader replaces the user in a read message with the anonymous subject if the user is anonymous or
+// missing, so that public downloads are recorded under a subject that per-user reports can recognize rather than
+// being rejected. Messages other than reads are left alone.
+func (svc *DataoneIndexer) resolveAnonymousReader(key string, msg *model.Message) {
+	if !svc.isReadKey(key) || !msg.Author.IsAnonymous() {
+		return
+	}
+	subject := svc.anonymousSubject
+	if subject == "" {
+		subject = model.PublicSubject
+	}
+	msg.Author = model.NewAnonymousUser(subject)
+}
+
+// countReader counts a recorded read under the kind of user who made it.
+func countReader(msg *model.Message) {
+	if msg.Author.IsAnonymous() {
+		readUsers.Inc(readerAnonymous)
+	} else {
+		readUsers.Inc(readerAuthenticated)
+	}
+}
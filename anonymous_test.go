@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// TestGetAnonymousSubject verifies that the DataONE public subject is used if no anonymous subject is configured.
+func TestGetAnonymousSubject(t *testing.T) {
+	for configured, expected := range map[string]string{"": model.PublicSubject, " guest ": "guest"} {
+		cfg := viper.New()
+		cfg.Set("dataone.anonymous-subject", configured)
+		if subject := getAnonymousSubject(cfg); subject != expected {
+			t.Errorf("%q: expected %s but got %s", configured, expected, subject)
+		}
+	}
+}
+
+// TestAnonymousReads verifies that reads by missing or anonymous users are accepted and attributed to the anonymous
+// subject even when authors are required, and that other messages without authors are still rejected.
+func TestAnonymousReads(t *testing.T) {
+	const path = `"path": "/iplant/home/shared/commons_repo/curated/foo.txt"`
+	tests := []struct {
+		name     string
+		key      string
+		body     string
+		expected string
+	}{
+		{"missing user", "data-object.open", `{"entity": "fakeid", ` + path + `}`, "guest"},
+		{
+			"anonymous user",
+			"data-object.open",
+			`{"author": {"name": "anonymous", "zone": "iplant"}, "entity": "fakeid", ` + path + `}`,
+			"guest",
+		},
+		{
+			"authenticated user",
+			"data-object.open",
+			`{"author": {"name": "ipcdev", "zone": "iplant"}, "entity": "fakeid", ` + path + `}`,
+			"ipcdev#iplant",
+		},
+		{"missing user in a move", "data-object.mv", `{"entity": "fakeid", ` + path + `}`, ""},
+	}
+
+	for _, test := range tests {
+		svc := newTestService(&fakeRecorder{})
+		svc.validation = []*keyRequirements{
+			{[]string{"data-object.open", "data-object.preview", "data-object.mv"}, model.Requirements{Author: true}},
+		}
+		svc.readKeys = []string{"data-object.open", "data-object.preview"}
+		svc.anonymousSubject = "guest"
+
+		_, msg, err := svc.prepareMessage(amqp.Delivery{RoutingKey: test.key, Body: []byte(test.body)})
+		if test.expected == "" {
+			if err == nil || !shouldQuarantine(err) {
+				t.Errorf("%s: expected an invalid message error but got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		} else if msg.Author.String() != test.expected {
+			t.Errorf("%s: expected the read to be attributed to %s but got %s", test.name, test.expected, msg.Author)
+		}
+	}
+}
+
+// TestReadUserSummary verifies that recorded reads are counted separately for anonymous and authenticated users.
+func TestReadUserSummary(t *testing.T) {
+	svc := newTestService(&fakeRecorder{})
+	svc.readKeys = []string{"data-object.open"}
+	anonymous, authenticated := readUsers.Get(readerAnonymous), readUsers.Get(readerAuthenticated)
+
+	for _, body := range [][]byte{testBody, testAuthorBody, testBody} {
+		if err := svc.processMessage(amqp.Delivery{RoutingKey: "data-object.open", Body: body}); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+	if count := readUsers.Get(readerAnonymous) - anonymous; count != 2 {
+		t.Errorf("expected 2 anonymous reads but got %d", count)
+	}
+	if count := readUsers.Get(readerAuthenticated) - authenticated; count != 1 {
+		t.Errorf("expected 1 authenticated read but got %d", count)
+	}
+}
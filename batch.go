@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// Batch modes.
+const (
+	batchModeOff    = "off"
+	batchModeAuto   = "auto"
+	batchModeAlways = "always"
+)
+
+// Default batch settings.
+const (
+	defaultBatchSize       = 100
+	defaultBatchMaxLatency = time.Second
+)
+
+// autoBatchWait is the amount of time that a worker waits for each additional delivery in auto batch mode. Deliveries
+// that are already buffered arrive well within this time, so batches only form when there's a backlog.
+const autoBatchWait = 5 * time.Millisecond
+
+// batchSettings describes how deliveries are grouped into batches that are recorded in a single transaction. In auto
+// mode, a batch only contains the deliveries that are available immediately, so batches only form while a backlog is
+// being drained. In always mode, a worker waits up to the maximum latency for a batch to fill.
+type batchSettings struct {
+	mode       string
+	size       int
+	maxLatency time.Duration
+}
+
+// getBatchSettings returns the batch settings described by the configuration, or nil if batching is disabled.
+func getBatchSettings(cfg *viper.Viper) (*batchSettings, error) {
+	mode := cfg.GetString("dataone.batch.mode")
+	switch mode {
+	case "", batchModeOff:
+		return nil, nil
+	case batchModeAuto, batchModeAlways:
+	default:
+		return nil, fmt.Errorf("unsupported dataone.batch.mode: %s", mode)
+	}
+
+	// Load the batch size.
+	size := cfg.GetInt("dataone.batch.size")
+	if size < 0 {
+		return nil, fmt.Errorf("dataone.batch.size must not be negative: %d", size)
+	}
+	if size == 0 {
+		size = defaultBatchSize
+	}
+
+	// Load the maximum latency.
+	maxLatency, err := getPositiveDuration(cfg, "dataone.batch.max-latency", defaultBatchMaxLatency)
+	if err != nil {
+		return nil, err
+	}
+
+	return &batchSettings{mode: mode, size: size, maxLatency: maxLatency}, nil
+}
+
+// Default event buffer settings.
+const (
+	defaultBufferSize          = 100
+	defaultBufferFlushInterval = 250 * time.Millisecond
+)
+
+// bufferSettings describes how events are buffered in the database package before they're recorded. Unlike batches
+// of deliveries, which are collected by each worker, the buffer is shared by all workers and deliveries are
+// acknowledged asynchronously once their events are recorded.
+type bufferSettings struct {
+	size          int
+	flushInterval time.Duration
+}
+
+// getBufferSettings returns the event buffer settings described by the configuration, or nil if buffering is disabled.
+func getBufferSettings(cfg *viper.Viper) (*bufferSettings, error) {
+	if !cfg.GetBool("db.buffer.enabled") {
+		return nil, nil
+	}
+
+	// Load the buffer size.
+	size := cfg.GetInt("db.buffer.size")
+	if size < 0 {
+		return nil, fmt.Errorf("db.buffer.size must not be negative: %d", size)
+	}
+	if size == 0 {
+		size = defaultBufferSize
+	}
+
+	// Load the flush interval.
+	flushInterval, err := getPositiveDuration(cfg, "db.buffer.flush-interval", defaultBufferFlushInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bufferSettings{size: size, flushInterval: flushInterval}, nil
+}
+
+// collect builds a batch beginning with the given delivery from the deliveries that arrive on the channel. The batch
+// is complete when it reaches the maximum size, when the maximum latency expires, when no delivery arrives within the
+// auto batch wait in auto mode, or when the channel is closed.
+func (bs *batchSettings) collect(first amqp.Delivery, deliveries <-chan amqp.Delivery) []amqp.Delivery {
+	batch := []amqp.Delivery{first}
+	deadline := time.NewTimer(bs.maxLatency)
+	defer deadline.Stop()
+
+	for len(batch) < bs.size {
+		var idle <-chan time.Time
+		if bs.mode == batchModeAuto {
+			idle = time.After(autoBatchWait)
+		}
+
+		select {
+		case delivery, ok := <-deliveries:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, delivery)
+		case <-idle:
+			return batch
+		case <-deadline.C:
+			return batch
+		}
+	}
+	return batch
+}
+
+// handleBatch processes a batch of AMQP deliveries. Deliveries that don't need to be recorded and batch messages, which
+// are already recorded in a transaction of their own, are handled individually, and the events for the rest are
+// recorded in a single transaction. If the batch can't be recorded, each of the
+// remaining deliveries is processed individually so that one bad message doesn't affect the others.
+func (svc *DataoneIndexer) handleBatch(session *amqpSession, batch []amqp.Delivery) {
+	if len(batch) == 1 {
+		svc.handleDelivery(session, batch[0])
+		return
+	}
+
+	// Determine which deliveries need to be recorded.
+	var pending []amqp.Delivery
+	var requests []*database.EventRequest
+	for _, delivery := range batch {
+		key, msg, err := svc.prepareMessage(delivery)
+		if err != nil || msg == nil {
+			svc.finishDelivery(session, delivery, err)
+			continue
+		}
+		if len(msg.Batch) > 0 {
+			svc.finishDelivery(session, delivery, svc.recordMessage(delivery, key, msg))
+			continue
+		}
+		pending = append(pending, delivery)
+		requests = append(requests, &database.EventRequest{Key: key, Msg: msg})
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	// Wait until the rate limit allows the events to be recorded.
+	if svc.limiter != nil {
+		for range requests {
+			svc.limiter.wait()
+		}
+	}
+
+	// Record the events, falling back to processing the deliveries individually if the batch fails.
+	ctx, cancel := svc.messageContext()
+	events, err := svc.recorder.RecordEvents(ctx, requests)
+	cancel()
+	if err != nil {
+		logger.Log.Warnf(
+			"unable to record a batch of %d events; processing them individually: %s", len(requests), err,
+		)
+		for i, delivery := range pending {
+			svc.finishDelivery(session, delivery, svc.recordMessage(delivery, requests[i].Key, requests[i].Msg))
+		}
+		return
+	}
+
+	// Remember and announce the recorded events.
+	for i, delivery := range pending {
+		svc.eventRecorded(delivery, requests[i].Msg, events[i])
+	}
+
+	// Acknowledge the deliveries. With a single worker, every earlier delivery on the channel has already been
+	// acknowledged or rejected, so one acknowledgement covers the whole batch. Otherwise, acknowledging multiple
+	// deliveries could acknowledge deliveries that other workers are still processing.
+	if svc.manualAck {
+		if svc.workerCount() == 1 {
+			if ackErr := pending[len(pending)-1].Ack(true); ackErr != nil {
+				logger.Log.Warnf("unable to acknowledge AMQP messages: %s", ackErr)
+			}
+		} else {
+			for _, delivery := range pending {
+				acknowledge(delivery, nil)
+			}
+		}
+	}
+	atomic.AddInt64(&svc.processed, int64(len(pending)))
+}
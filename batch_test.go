@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// TestGetBatchSettings verifies that batch settings are loaded and validated correctly.
+func TestGetBatchSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		size      int
+		expectErr bool
+		expectNil bool
+		expected  int
+	}{
+		{"disabled", "off", 10, false, true, 0},
+		{"unset", "", 10, false, true, 0},
+		{"auto", "auto", 10, false, false, 10},
+		{"always with default size", "always", 0, false, false, defaultBatchSize},
+		{"negative size", "auto", -1, true, false, 0},
+		{"unknown mode", "sometimes", 10, true, false, 0},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("dataone.batch.mode", test.mode)
+		cfg.Set("dataone.batch.size", test.size)
+		bs, err := getBatchSettings(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+			continue
+		}
+		if test.expectErr {
+			continue
+		}
+		if (bs == nil) != test.expectNil {
+			t.Errorf("%s: expected nil settings: %t, got: %+v", test.name, test.expectNil, bs)
+			continue
+		}
+		if bs != nil && bs.size != test.expected {
+			t.Errorf("%s: expected batch size %d, got %d", test.name, test.expected, bs.size)
+		}
+		if bs != nil && bs.maxLatency != defaultBatchMaxLatency {
+			t.Errorf("%s: expected the default maximum latency, got %s", test.name, bs.maxLatency)
+		}
+	}
+}
+
+// TestCollectBatch verifies that batches are limited by size, availability and latency.
+func TestCollectBatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		available int
+		expected  int
+	}{
+		{"auto mode with a backlog", batchModeAuto, 10, 4},
+		{"auto mode with a short backlog", batchModeAuto, 1, 2},
+		{"always mode with a backlog", batchModeAlways, 10, 4},
+		{"always mode waits for the maximum latency", batchModeAlways, 2, 3},
+	}
+
+	for _, test := range tests {
+		deliveries := make(chan amqp.Delivery, test.available)
+		for i := 0; i < test.available; i++ {
+			deliveries <- amqp.Delivery{}
+		}
+
+		bs := &batchSettings{mode: test.mode, size: 4, maxLatency: 50 * time.Millisecond}
+		if actual := len(bs.collect(amqp.Delivery{}, deliveries)); actual != test.expected {
+			t.Errorf("%s: expected a batch of %d deliveries, got %d", test.name, test.expected, actual)
+		}
+	}
+}
+
+// TestBufferedDelivery verifies that deliveries whose events are buffered aren't acknowledged until the buffer is
+// flushed, while deliveries that don't need to be recorded are acknowledged immediately.
+func TestBufferedDelivery(t *testing.T) {
+	recorder := &fakeRecorder{}
+	svc := newTestService(recorder)
+	svc.manualAck = true
+	svc.buffer = database.NewEventBuffer(recorder, 10, time.Hour, 0)
+	session, _ := newFakeSession()
+
+	acknowledger := &fakeAcknowledger{}
+	for _, body := range [][]byte{testBody, outOfRootTestBody} {
+		delivery := amqp.Delivery{Acknowledger: acknowledger, RoutingKey: "data-object.open", Body: body}
+		svc.handleDelivery(session, delivery)
+	}
+	if acknowledger.acks != 1 {
+		t.Errorf("expected 1 acknowledgement before the buffer was flushed, got %d", acknowledger.acks)
+	}
+
+	svc.buffer.Flush()
+	if acknowledger.acks != 2 {
+		t.Errorf("expected 2 acknowledgements after the buffer was flushed, got %d", acknowledger.acks)
+	}
+	if batches := atomic.LoadInt64(&recorder.batches); batches != 1 {
+		t.Errorf("expected 1 batch but got %d", batches)
+	}
+}
+
+// TestHandleBatch verifies that a batch is recorded in one transaction and acknowledged with a single
+// acknowledgement, and that deliveries are processed individually if the batch can't be recorded.
+func TestHandleBatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		workers   int
+		batchErr  error
+		acks      int
+		multiples int
+	}{
+		{"single worker", 1, nil, 2, 1},
+		{"multiple workers", 2, nil, 4, 0},
+		{"batch failure", 1, fmt.Errorf("something bad happened"), 4, 0},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{batchErr: test.batchErr}
+		svc := newTestService(recorder)
+		svc.manualAck = true
+		svc.workers = test.workers
+		session, _ := newFakeSession()
+
+		// Build a batch containing one delivery that doesn't need to be recorded.
+		acknowledger := &fakeAcknowledger{}
+		batch := []amqp.Delivery{
+			{Acknowledger: acknowledger, RoutingKey: "data-object.open", Body: testBody},
+			{Acknowledger: acknowledger, RoutingKey: "data-object.open", Body: outOfRootTestBody},
+			{Acknowledger: acknowledger, RoutingKey: "data-object.open", Body: testBody},
+			{Acknowledger: acknowledger, RoutingKey: "data-object.open", Body: testBody},
+		}
+		svc.handleBatch(session, batch)
+
+		if batches := atomic.LoadInt64(&recorder.batches); batches != 1 {
+			t.Errorf("%s: expected 1 batch but got %d", test.name, batches)
+		}
+		if events := atomic.LoadInt64(&recorder.events); events != 3 {
+			t.Errorf("%s: expected 3 recorded events but got %d", test.name, events)
+		}
+		if acknowledger.acks != test.acks || acknowledger.multiples != test.multiples {
+			t.Errorf(
+				"%s: expected %d acks (%d multiple), got %d (%d multiple)",
+				test.name, test.acks, test.multiples, acknowledger.acks, acknowledger.multiples,
+			)
+		}
+		if processed := atomic.LoadInt64(&svc.processed); processed != 4 {
+			t.Errorf("%s: expected 4 processed deliveries but got %d", test.name, processed)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/streadway/amqp"
+)
+
+// prepareBatch determines which of the messages in a batch message should have events recorded for them. Each message
+// in the batch is accepted or discarded in the same way as a message on its own, so some of the paths in a batch may
+// be recorded while others are ignored because they aren't in the repository. The batch message is returned with the
+// accepted messages, or nil if none of them were accepted. If any message in the batch can't be processed, the whole
+// batch is rejected so that nothing is recorded for it until the problem is resolved.
+func (svc *DataoneIndexer) prepareBatch(
+	delivery amqp.Delivery, key string, msg *model.Message,
+) (*model.Message, error) {
+	var accepted []*model.Message
+	for i, m := range msg.Batch {
+
+		// Every message in the batch needs its own message ID so that the events aren't mistaken for duplicates.
+		if msg.MessageID != "" {
+			m.MessageID = fmt.Sprintf("%s/%d", msg.MessageID, i+1)
+		}
+
+		prepared, err := svc.prepareEvent(delivery, key, m)
+		if err != nil {
+			return nil, err
+		}
+		if prepared != nil {
+			accepted = append(accepted, prepared)
+		}
+	}
+	if len(accepted) == 0 {
+		return nil, nil
+	}
+	msg.Batch = accepted
+	return msg, nil
+}
+
+// recordEvents records the events for the messages that a message accepted by prepareMessage expands to. The events
+// for the messages in a batch are recorded in a single transaction.
+func (svc *DataoneIndexer) recordEvents(
+	ctx context.Context, key string, msgs []*model.Message,
+) ([]*database.Event, error) {
+	if len(msgs) == 1 {
+		event, err := svc.recorder.RecordEvent(ctx, key, msgs[0])
+		if err != nil {
+			return nil, err
+		}
+		return []*database.Event{event}, nil
+	}
+
+	requests := make([]*database.EventRequest, len(msgs))
+	for i, m := range msgs {
+		requests[i] = &database.EventRequest{Key: key, Msg: m}
+	}
+	return svc.recorder.RecordEvents(ctx, requests)
+}
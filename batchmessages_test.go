@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/streadway/amqp"
+)
+
+// Batch message bodies for testing. The mixed batch contains two paths in the repository and one outside of it.
+var (
+	mixedBatchTestBody = []byte(`{"entities": [
+  {"entity": "fakeid1", "path": "/iplant/home/shared/commons_repo/curated/foo.txt"},
+  {"entity": "fakeid2", "path": "/iplant/home/shared/foo.txt"},
+  {"entity": "fakeid3", "path": "/iplant/home/shared/commons_repo/curated/bar.txt"}
+]}`)
+	outOfRootBatchTestBody = []byte(`{"entities": [
+  {"entity": "fakeid1", "path": "/iplant/home/shared/foo.txt"},
+  {"entity": "fakeid2", "path": "/iplant/home/shared/bar.txt"}
+]}`)
+	invalidBatchTestBody = []byte(`{"entities": [
+  {"entity": "fakeid1", "path": "/iplant/home/shared/commons_repo/curated/foo.txt"},
+  {"entity": "fake id", "path": "foo.txt"}
+]}`)
+)
+
+// TestPrepareMixedBatch verifies that only the messages in a batch whose paths are in the repository are accepted and
+// that each of them is given its own message ID.
+func TestPrepareMixedBatch(t *testing.T) {
+	svc := newTestService(&fakeRecorder{})
+	key := "data-object.open"
+	before := messageOutcomes.Get(key + "/" + outcomeOutOfRoot)
+
+	_, msg, err := svc.prepareMessage(amqp.Delivery{RoutingKey: key, MessageId: "batch", Body: mixedBatchTestBody})
+	if err != nil || msg == nil {
+		t.Fatalf("expected the batch to be accepted (error: %v)", err)
+	}
+	if len(msg.Batch) != 2 {
+		t.Fatalf("expected 2 accepted messages but got %d", len(msg.Batch))
+	}
+	expected := []struct{ path, id string }{
+		{"/iplant/home/shared/commons_repo/curated/foo.txt", "batch/1"},
+		{"/iplant/home/shared/commons_repo/curated/bar.txt", "batch/3"},
+	}
+	for i, m := range msg.Batch {
+		if m.Path != expected[i].path || m.MessageID != expected[i].id || !m.InRepository {
+			t.Errorf("expected path %s with ID %s but got %+v", expected[i].path, expected[i].id, m)
+		}
+	}
+	if count := messageOutcomes.Get(key+"/"+outcomeOutOfRoot) - before; count != 1 {
+		t.Errorf("expected 1 path outside of the repository but got %d", count)
+	}
+}
+
+// TestProcessBatchMessages verifies that the events for the accepted messages in a batch are recorded together and
+// that nothing is recorded for batches that contain no paths in the repository or that contain an invalid message.
+func TestProcessBatchMessages(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       []byte
+		events     int64
+		batches    int64
+		quarantine bool
+	}{
+		{"mixed", mixedBatchTestBody, 2, 1, false},
+		{"outside of repository", outOfRootBatchTestBody, 0, 0, false},
+		{"invalid message", invalidBatchTestBody, 0, 0, true},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{}
+		err := newTestService(recorder).processMessage(amqp.Delivery{RoutingKey: "data-object.open", Body: test.body})
+		if test.quarantine != (err != nil && shouldQuarantine(err)) {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if events := atomic.LoadInt64(&recorder.events); events != test.events {
+			t.Errorf("%s: expected %d recorded events but got %d", test.name, test.events, events)
+		}
+		if batches := atomic.LoadInt64(&recorder.batches); batches != test.batches {
+			t.Errorf("%s: expected %d batches but got %d", test.name, test.batches, batches)
+		}
+	}
+}
+
+// TestBatchMessageAcknowledgement verifies that batch messages are only acknowledged once all of their events have
+// been recorded or the message has been quarantined, and that batches that can't be recorded are requeued.
+func TestBatchMessageAcknowledgement(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     []byte
+		batchErr error
+		events   int64
+		acks     int
+		requeues int
+	}{
+		{"recorded", mixedBatchTestBody, nil, 2, 1, 0},
+		{"quarantined", invalidBatchTestBody, nil, 0, 1, 0},
+		{"transient failure", mixedBatchTestBody, &database.RetryableError{Err: fmt.Errorf("deadlock")}, 0, 0, 1},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{batchErr: test.batchErr}
+		svc := newTestService(recorder)
+		svc.manualAck = true
+		svc.quarantine = true
+
+		acknowledger := &fakeAcknowledger{}
+		session, _ := newFakeSession()
+		svc.handleDelivery(session, amqp.Delivery{
+			Acknowledger: acknowledger,
+			RoutingKey:   "data-object.open",
+			Body:         test.body,
+		})
+
+		if events := atomic.LoadInt64(&recorder.events); events != test.events {
+			t.Errorf("%s: expected %d recorded events but got %d", test.name, test.events, events)
+		}
+		if acknowledger.acks != test.acks || acknowledger.requeues != test.requeues {
+			t.Errorf(
+				"%s: expected %d acks and %d requeues but got %d and %d",
+				test.name, test.acks, test.requeues, acknowledger.acks, acknowledger.requeues,
+			)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/streadway/amqp"
+)
+
+// bulkLoader is implemented by recorders that can load large numbers of events at once.
+type bulkLoader interface {
+	BulkLoad(ctx context.Context, source database.BulkSource) (*database.BulkLoadResult, error)
+}
+
+// bulkMessages supplies the events for the messages in a newline-delimited JSON stream to the bulk loader. Each line
+// contains the body of a message with the given routing key. Messages are accepted or discarded in the same way as
+// AMQP messages are, and lines that don't produce events are counted as rejected. Blank lines are ignored.
+type bulkMessages struct {
+	svc      *DataoneIndexer
+	reader   *bufio.Reader
+	key      string
+	line     int
+	rejected int64
+	pending  []*database.EventRequest
+}
+
+// newBulkMessages returns a source of events for the messages in a newline-delimited JSON stream.
+func newBulkMessages(svc *DataoneIndexer, r io.Reader, key string) *bulkMessages {
+	return &bulkMessages{svc: svc, reader: bufio.NewReader(r), key: key}
+}
+
+// Next returns the event request for the next message in the stream that should be recorded. Batch messages produce
+// an event request for each of the messages in the batch that should be recorded.
+func (b *bulkMessages) Next() (*database.EventRequest, error) {
+	for {
+		if len(b.pending) > 0 {
+			request := b.pending[0]
+			b.pending = b.pending[1:]
+			return request, nil
+		}
+
+		line, err := b.reader.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("unable to read line %d: %s", b.line+1, err)
+		}
+		b.line++
+
+		body := bytes.TrimSpace(line)
+		if len(body) == 0 {
+			continue
+		}
+		key, msg, err := b.svc.prepareMessage(amqp.Delivery{RoutingKey: b.key, Body: body})
+		if err != nil {
+			logger.Log.Warnf("rejecting line %d: %s", b.line, err)
+		}
+		if msg == nil {
+			b.rejected++
+			continue
+		}
+		for _, m := range msg.Expand() {
+			b.pending = append(b.pending, &database.EventRequest{Key: key, Msg: m})
+		}
+	}
+}
+
+// openBulkInput opens the file containing the messages to bulk load. A path of "-" refers to the standard input.
+func openBulkInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(path)
+}
+
+// bulkLoad loads the events for the messages in a newline-delimited JSON file or the standard input in a single
+// transaction, bypassing the message queue.
+func bulkLoad() {
+	svc := initService()
+	defer svc.db.Close()
+
+	loader, ok := svc.recorder.(bulkLoader)
+	if !ok {
+		logger.Log.Fatalf("the event recorder doesn't support bulk loads")
+	}
+	input, err := openBulkInput(*bulkFile)
+	if err != nil {
+		logger.Log.Fatalf("unable to open the messages to load: %s", err)
+	}
+	defer input.Close()
+
+	start := time.Now()
+	source := newBulkMessages(svc, input, *bulkKey)
+	result, err := loader.BulkLoad(svc.ctx, source)
+	if err != nil {
+		logger.Log.Fatalf("unable to load the events: %s", err)
+	}
+	logger.Log.Infof(
+		"bulk loaded %d lines in %s: %d rows loaded, %d deduplicated, %d rejected",
+		source.line, time.Since(start), result.Loaded, result.Deduplicated, result.Rejected+source.rejected,
+	)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// TestBulkMessages verifies that the events for the messages in a newline-delimited JSON stream are supplied to the
+// bulk loader, and that lines that don't produce events are counted as rejected.
+func TestBulkMessages(t *testing.T) {
+	svc := newTestService(&fakeRecorder{})
+	author := &model.User{Name: "nobody", Zone: "nowhere"}
+	encoded, err := model.Encode(model.NewReadMessage(
+		author, "fakeid", "/iplant/home/shared/commons_repo/curated/bar.txt", time.Now(),
+	))
+	if err != nil {
+		t.Fatalf("unable to encode the message: %s", err)
+	}
+	lines := [][]byte{testBody, outOfRootTestBody, malformedTestBody, {}, testAuthorBody, encoded}
+	source := newBulkMessages(svc, bytes.NewReader(bytes.Join(lines, []byte("\n"))), "data-object.open")
+
+	var paths []string
+	for {
+		request, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if request.Key != "data-object.open" {
+			t.Errorf("unexpected routing key: %s", request.Key)
+		}
+		paths = append(paths, request.Msg.Path)
+	}
+
+	if len(paths) != 3 || !strings.HasSuffix(paths[0], "/curated/foo.txt") || !strings.HasSuffix(paths[2], "/bar.txt") {
+		t.Errorf("unexpected messages: %v", paths)
+	}
+	if source.line != 6 || source.rejected != 2 {
+		t.Errorf("expected 2 of 6 lines to be rejected, got %d of %d", source.rejected, source.line)
+	}
+}
+
+// TestBulkBatchMessages verifies that batch messages produce an event request for each of the messages in the batch
+// that should be recorded.
+func TestBulkBatchMessages(t *testing.T) {
+	svc := newTestService(&fakeRecorder{})
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, mixedBatchTestBody); err != nil {
+		t.Fatalf("unable to compact the message: %s", err)
+	}
+	lines := [][]byte{compacted.Bytes(), testBody}
+	source := newBulkMessages(svc, bytes.NewReader(bytes.Join(lines, []byte("\n"))), "data-object.open")
+
+	var paths []string
+	for {
+		request, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		paths = append(paths, request.Msg.Path)
+	}
+
+	if len(paths) != 3 || !strings.HasSuffix(paths[1], "/curated/bar.txt") || !strings.HasSuffix(paths[2], "/foo.txt") {
+		t.Errorf("unexpected messages: %v", paths)
+	}
+	if source.rejected != 0 {
+		t.Errorf("expected no rejected lines but got %d", source.rejected)
+	}
+}
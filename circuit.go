@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/spf13/viper"
+)
+
+// Default circuit breaker settings.
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// The states of the circuit breaker.
+const (
+	// circuitClosed allows database operations to proceed.
+	circuitClosed = "closed"
+
+	// circuitOpen rejects database operations without attempting them.
+	circuitOpen = "open"
+
+	// circuitHalfOpen allows a single database operation to proceed as a probe. Other operations wait for the outcome
+	// of the probe.
+	circuitHalfOpen = "half-open"
+)
+
+// circuitOpened is published via expvar so that the state of the circuit breaker can be monitored. It's 1 while the
+// circuit is open and 0 otherwise.
+var circuitOpened = expvar.NewInt("database_circuit_open")
+
+// errCircuitOpen indicates that a database operation wasn't attempted because the circuit breaker is open.
+var errCircuitOpen = fmt.Errorf("the database circuit breaker is open")
+
+// isCircuitOpen determines whether or not an error indicates that a database operation was rejected by the circuit
+// breaker.
+func isCircuitOpen(err error) bool {
+	if e, ok := err.(*database.RetryableError); ok {
+		return e.Err == errCircuitOpen
+	}
+	return false
+}
+
+// isContextExpired determines whether or not an error indicates that an operation stopped because its context expired.
+func isContextExpired(err error) bool {
+	if e, ok := err.(*database.RetryableError); ok {
+		err = e.Err
+	}
+	return err == context.DeadlineExceeded || err == context.Canceled
+}
+
+// breakerSettings describes when the circuit breaker opens and how long it stays open.
+type breakerSettings struct {
+	threshold int
+	cooldown  time.Duration
+	pause     string
+}
+
+// getBreakerSettings extracts the circuit breaker settings from the configuration. The return value is nil if the
+// circuit breaker is disabled. While the circuit is open, message consumption is paused in the same ways as it is
+// while the database is unhealthy.
+func getBreakerSettings(cfg *viper.Viper, manualAck bool) (*breakerSettings, error) {
+	if !cfg.GetBool("db.circuit-breaker.enabled") {
+		return nil, nil
+	}
+
+	// Load the failure threshold.
+	threshold := cfg.GetInt("db.circuit-breaker.threshold")
+	if threshold < 0 {
+		return nil, fmt.Errorf("db.circuit-breaker.threshold must not be negative: %d", threshold)
+	}
+	if threshold == 0 {
+		threshold = defaultBreakerThreshold
+	}
+
+	// Load the cooldown period.
+	cooldown, err := getPositiveDuration(cfg, "db.circuit-breaker.cooldown", defaultBreakerCooldown)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the way in which message consumption is paused.
+	pause := cfg.GetString("db.circuit-breaker.pause")
+	switch pause {
+	case healthPauseStop:
+	case healthPauseNack:
+		if !manualAck {
+			return nil, fmt.Errorf(
+				"db.circuit-breaker.pause can only be %s when amqp.manual-ack is true", healthPauseNack,
+			)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported db.circuit-breaker.pause value: %s", pause)
+	}
+
+	return &breakerSettings{threshold: threshold, cooldown: cooldown, pause: pause}, nil
+}
+
+// circuitBreaker stops database operations from being attempted for a cooldown period after several consecutive
+// operations fail because the database can't be reached, so that each message doesn't have to wait for the connection
+// to time out. Only the final outcome of each operation is counted, so operations that the recorder retries in-process
+// count as a single failure. It's safe for concurrent use by multiple goroutines.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	pause     string
+	mutex     sync.Mutex
+	state     string
+	failures  int
+	probing   bool
+	changed   chan struct{}
+}
+
+// newCircuitBreaker creates a circuit breaker, which is closed initially.
+func newCircuitBreaker(bs *breakerSettings) *circuitBreaker {
+	circuitOpened.Set(0)
+	return &circuitBreaker{
+		threshold: bs.threshold,
+		cooldown:  bs.cooldown,
+		pause:     bs.pause,
+		state:     circuitClosed,
+		changed:   make(chan struct{}),
+	}
+}
+
+// status returns the state of the circuit breaker along with a channel that's closed when the state changes.
+func (b *circuitBreaker) status() (string, <-chan struct{}) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state, b.changed
+}
+
+// setState changes the state of the circuit breaker and notifies anything waiting for the state to change. The caller
+// must hold the mutex.
+func (b *circuitBreaker) setState(state string) {
+	b.state = state
+	if state == circuitOpen {
+		circuitOpened.Set(1)
+	} else {
+		circuitOpened.Set(0)
+	}
+	b.endProbe()
+}
+
+// endProbe allows another caller to probe the database and notifies anything waiting for the outcome of the probe.
+// The caller must hold the mutex.
+func (b *circuitBreaker) endProbe() {
+	b.probing = false
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// allow determines whether or not a database operation may be attempted. If the circuit is half-open, the first
+// caller is allowed to probe the database and other callers wait for the outcome of the probe. A retryable error is
+// returned if the operation may not be attempted.
+func (b *circuitBreaker) allow(ctx context.Context) error {
+	for {
+		b.mutex.Lock()
+		switch {
+		case b.state == circuitClosed:
+			b.mutex.Unlock()
+			return nil
+		case b.state == circuitOpen:
+			b.mutex.Unlock()
+			return &database.RetryableError{Err: errCircuitOpen}
+		case !b.probing:
+			b.probing = true
+			b.mutex.Unlock()
+			return nil
+		}
+		changed := b.changed
+		b.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return &database.RetryableError{Err: ctx.Err()}
+		}
+	}
+}
+
+// record records the outcome of a database operation that was allowed to proceed. The circuit opens once the number
+// of consecutive operations that failed because the database couldn't be reached reaches the threshold, or as soon as
+// a probe fails for that reason. Operations that stopped because their contexts expired say nothing about whether
+// or not the database is reachable, so they're ignored. Any other outcome shows that the database is reachable, so it
+// closes the circuit.
+func (b *circuitBreaker) record(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if isContextExpired(err) {
+		if b.probing {
+			b.endProbe()
+		}
+		return
+	}
+	if err == nil || !database.IsConnectionError(err) {
+		b.failures = 0
+		if b.state != circuitClosed {
+			logger.Log.Warn("the database is reachable again; closing the circuit breaker")
+			b.setState(circuitClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || (b.state == circuitClosed && b.failures >= b.threshold) {
+		logger.Log.Warnf(
+			"opening the circuit breaker for %s after %d consecutive database connection failures: %s",
+			b.cooldown, b.failures, err,
+		)
+		b.setState(circuitOpen)
+		time.AfterFunc(b.cooldown, b.halfOpen)
+	}
+}
+
+// halfOpen allows the database to be probed once the cooldown period has elapsed.
+func (b *circuitBreaker) halfOpen() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == circuitOpen {
+		logger.Log.Warn("the circuit breaker cooldown has elapsed; probing the database")
+		b.setState(circuitHalfOpen)
+	}
+}
+
+// breakerRecorder is an event recorder that attempts database operations only when the circuit breaker allows them.
+// Operations that don't affect the database are passed through to the underlying recorder.
+type breakerRecorder struct {
+	database.Recorder
+	breaker *circuitBreaker
+}
+
+// RecordEvent records the event for a message if the circuit breaker allows it.
+func (r *breakerRecorder) RecordEvent(ctx context.Context, key string, msg *model.Message) (*database.Event, error) {
+	if err := r.breaker.allow(ctx); err != nil {
+		return nil, err
+	}
+	event, err := r.Recorder.RecordEvent(ctx, key, msg)
+	r.breaker.record(err)
+	return event, err
+}
+
+// RecordEvents records the events for a batch of messages if the circuit breaker allows it.
+func (r *breakerRecorder) RecordEvents(
+	ctx context.Context, requests []*database.EventRequest,
+) ([]*database.Event, error) {
+	if err := r.breaker.allow(ctx); err != nil {
+		return nil, err
+	}
+	events, err := r.Recorder.RecordEvents(ctx, requests)
+	r.breaker.record(err)
+	return events, err
+}
+
+// RecordQuarantine quarantines a message if the circuit breaker allows it.
+func (r *breakerRecorder) RecordQuarantine(ctx context.Context, key string, body []byte, reason string) error {
+	if err := r.breaker.allow(ctx); err != nil {
+		return err
+	}
+	err := r.Recorder.RecordQuarantine(ctx, key, body, reason)
+	r.breaker.record(err)
+	return err
+}
+
+// RecordFailure stores a message whose event could not be recorded if the circuit breaker allows it.
+func (r *breakerRecorder) RecordFailure(
+	ctx context.Context, key string, body []byte, reason string, attempts int,
+) error {
+	if err := r.breaker.allow(ctx); err != nil {
+		return err
+	}
+	err := r.Recorder.RecordFailure(ctx, key, body, reason, attempts)
+	r.breaker.record(err)
+	return err
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/spf13/viper"
+)
+
+// connectionErr is the error returned by the fake recorder when the database can't be reached.
+var connectionErr = &database.RetryableError{Err: io.ErrUnexpectedEOF}
+
+// newTestBreaker returns a circuit breaker that opens after two consecutive failures, along with a recorder that uses
+// it.
+func newTestBreaker(cooldown time.Duration) (*circuitBreaker, *fakeRecorder, database.Recorder) {
+	breaker := newCircuitBreaker(&breakerSettings{threshold: 2, cooldown: cooldown, pause: healthPauseStop})
+	recorder := &fakeRecorder{}
+	return breaker, recorder, &breakerRecorder{Recorder: recorder, breaker: breaker}
+}
+
+// waitForState waits for the circuit breaker to reach a state, returning false if it doesn't within a second.
+func waitForState(breaker *circuitBreaker, expected string) bool {
+	timeout := time.After(time.Second)
+	for {
+		state, changed := breaker.status()
+		if state == expected {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// TestGetBreakerSettings verifies that circuit breaker settings are loaded and validated correctly.
+func TestGetBreakerSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		threshold int
+		pause     string
+		manualAck bool
+		expectErr bool
+		expectNil bool
+		expected  int
+	}{
+		{"disabled", false, 5, "stop", true, false, true, 0},
+		{"stop", true, 3, "stop", false, false, false, 3},
+		{"nack", true, 3, "nack", true, false, false, 3},
+		{"default threshold", true, 0, "stop", true, false, false, defaultBreakerThreshold},
+		{"negative threshold", true, -1, "stop", true, true, false, 0},
+		{"nack without manual acks", true, 3, "nack", false, true, false, 0},
+		{"unknown pause", true, 3, "sometimes", true, true, false, 0},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("db.circuit-breaker.enabled", test.enabled)
+		cfg.Set("db.circuit-breaker.threshold", test.threshold)
+		cfg.Set("db.circuit-breaker.pause", test.pause)
+		bs, err := getBreakerSettings(cfg, test.manualAck)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+			continue
+		}
+		if test.expectErr {
+			continue
+		}
+		if (bs == nil) != test.expectNil {
+			t.Errorf("%s: expected nil settings: %t, got: %+v", test.name, test.expectNil, bs)
+			continue
+		}
+		if bs != nil && bs.threshold != test.expected {
+			t.Errorf("%s: expected threshold %d, got %d", test.name, test.expected, bs.threshold)
+		}
+		if bs != nil && bs.cooldown != defaultBreakerCooldown {
+			t.Errorf("%s: expected the default cooldown, got %s", test.name, bs.cooldown)
+		}
+	}
+}
+
+// TestCircuitBreaker verifies that the circuit opens after consecutive connection failures, rejects operations while
+// it's open, and closes again once a probe succeeds.
+func TestCircuitBreaker(t *testing.T) {
+	breaker, recorder, r := newTestBreaker(10 * time.Millisecond)
+	ctx := context.Background()
+	msg := &model.Message{Path: "/iplant/home/shared/commons_repo/curated/foo.txt"}
+
+	// Failures that aren't caused by lost connections don't count towards the threshold.
+	recorder.err = connectionErr
+	r.RecordEvent(ctx, "data-object.open", msg)
+	recorder.err = fmt.Errorf("value too long")
+	r.RecordEvent(ctx, "data-object.open", msg)
+	recorder.err = connectionErr
+	r.RecordEvent(ctx, "data-object.open", msg)
+	if state, _ := breaker.status(); state != circuitClosed {
+		t.Fatalf("expected the circuit to be closed but it's %s", state)
+	}
+
+	// The second consecutive connection failure opens the circuit.
+	r.RecordEvent(ctx, "data-object.open", msg)
+	if state, _ := breaker.status(); state != circuitOpen {
+		t.Fatalf("expected the circuit to be open but it's %s", state)
+	}
+	if _, err := r.RecordEvent(ctx, "data-object.open", msg); !isCircuitOpen(err) || !database.IsRetryable(err) {
+		t.Errorf("expected a retryable circuit breaker error but got: %v", err)
+	}
+	if recorder.events != 4 {
+		t.Errorf("expected 4 events to reach the recorder but got %d", recorder.events)
+	}
+
+	// A successful probe closes the circuit once the cooldown has elapsed.
+	if !waitForState(breaker, circuitHalfOpen) {
+		t.Fatal("the circuit didn't become half-open after the cooldown")
+	}
+	recorder.err = nil
+	if _, err := r.RecordEvent(ctx, "data-object.open", msg); err != nil {
+		t.Errorf("unexpected error from the probe: %s", err)
+	}
+	if state, _ := breaker.status(); state != circuitClosed {
+		t.Errorf("expected the circuit to be closed but it's %s", state)
+	}
+}
+
+// TestCircuitProbeFailure verifies that the circuit opens again immediately if a probe fails, and that operations that
+// arrive during a probe wait for its outcome.
+func TestCircuitProbeFailure(t *testing.T) {
+	breaker, recorder, r := newTestBreaker(10 * time.Millisecond)
+	ctx := context.Background()
+	msg := &model.Message{Path: "/iplant/home/shared/commons_repo/curated/foo.txt"}
+
+	recorder.err = connectionErr
+	r.RecordEvent(ctx, "data-object.open", msg)
+	r.RecordEvent(ctx, "data-object.open", msg)
+	if !waitForState(breaker, circuitHalfOpen) {
+		t.Fatal("the circuit didn't become half-open after the cooldown")
+	}
+
+	// Start a probe, and make sure that another operation waits for it.
+	if err := breaker.allow(ctx); err != nil {
+		t.Fatalf("the probe wasn't allowed: %s", err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := breaker.allow(waitCtx); !isContextExpired(err) {
+		t.Errorf("expected the second operation to wait for the probe but got: %v", err)
+	}
+
+	// The failed probe opens the circuit again.
+	breaker.record(connectionErr)
+	if state, _ := breaker.status(); state != circuitOpen {
+		t.Errorf("expected the circuit to be open but it's %s", state)
+	}
+}
+
+// TestCircuitIgnoresExpiredContexts verifies that operations that time out don't affect the circuit breaker.
+func TestCircuitIgnoresExpiredContexts(t *testing.T) {
+	breaker, _, _ := newTestBreaker(time.Minute)
+	for i := 0; i < 3; i++ {
+		breaker.record(&database.RetryableError{Err: context.DeadlineExceeded})
+	}
+	if state, _ := breaker.status(); state != circuitClosed {
+		t.Errorf("expected the circuit to be closed but it's %s", state)
+	}
+}
+
+// TestCircuitPausesConsumption verifies that deliveries aren't received while the circuit is open.
+func TestCircuitPausesConsumption(t *testing.T) {
+	for _, pause := range []string{healthPauseStop, healthPauseNack} {
+		breaker := newCircuitBreaker(&breakerSettings{threshold: 1, cooldown: time.Minute, pause: pause})
+		svc := newTestService(&fakeRecorder{})
+		svc.breaker = breaker
+		session, _ := newFakeSession()
+
+		if messages, _, _, reject := svc.deliverySource(session); messages == nil || reject {
+			t.Errorf("%s: deliveries should be received while the circuit is closed", pause)
+		}
+		breaker.record(connectionErr)
+		messages, _, changed, reject := svc.deliverySource(session)
+		if changed == nil {
+			t.Errorf("%s: no channel was provided for circuit breaker state changes", pause)
+		}
+		if pause == healthPauseStop && messages != nil {
+			t.Errorf("%s: deliveries should not be received while the circuit is open", pause)
+		}
+		if pause == healthPauseNack && (messages == nil || !reject) {
+			t.Errorf("%s: deliveries should be rejected while the circuit is open", pause)
+		}
+	}
+}
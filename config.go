@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// getStringList returns a configuration setting that may be specified either as a list of strings or as a single
+// comma- or newline-separated string. Leading and trailing whitespace is removed from each element and empty elements
+// are discarded.
+func getStringList(cfg *viper.Viper, key string) []string {
+	return toStringList(cfg.Get(key))
+}
+
+// toStringList converts a value that may be either a list of strings or a single comma- or newline-separated string to
+// a list of strings. Leading and trailing whitespace is removed from each element and empty elements are discarded.
+func toStringList(value interface{}) []string {
+	var values []string
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		values = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == '\n' })
+	default:
+		values = cast.ToStringSlice(v)
+	}
+
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if trimmed := strings.TrimSpace(value); trimmed != "" {
+			result = append(result, trimmed)
+		}
+	}
+	return result
+}
+
+// getRepositoryRoots returns the paths of the repository roots, along with the member node identifiers assigned to
+// roots whose events aren't recorded under the default node identifier. Each entry in dataone.repository-roots may be
+// either a path or a map containing a path and an optional node-id. The paths are converted to canonical form so that
+// they can be compared with the paths in messages.
+func getRepositoryRoots(cfg *viper.Viper) ([]string, map[string]string, error) {
+	var entries []interface{}
+	switch v := cfg.Get("dataone.repository-roots").(type) {
+	case []interface{}:
+		entries = v
+	default:
+		for _, root := range toStringList(v) {
+			entries = append(entries, root)
+		}
+	}
+
+	roots := make([]string, 0, len(entries))
+	nodeIDs := make(map[string]string)
+	for i, entry := range entries {
+		var path, nodeID string
+		if root, ok := entry.(string); ok {
+			path = strings.TrimSpace(root)
+		} else {
+			settings, err := cast.ToStringMapE(entry)
+			if err != nil {
+				return nil, nil, fmt.Errorf("dataone.repository-roots[%d]: invalid repository root: %v", i, entry)
+			}
+			path = strings.TrimSpace(cast.ToString(settings["path"]))
+			nodeID = strings.TrimSpace(cast.ToString(settings["node-id"]))
+		}
+		if path == "" {
+			return nil, nil, fmt.Errorf("dataone.repository-roots[%d]: the path is required", i)
+		}
+		path = model.CanonicalPath(path)
+		roots = append(roots, path)
+		if nodeID != "" {
+			nodeIDs[path] = nodeID
+		}
+	}
+	return roots, nodeIDs, nil
+}
+
+// getIgnoredGrantees returns the names of the users and groups whose permission changes are ignored. Each name is
+// either a user or group name, as in rodsadmin, or a qualified name, as in rodsadmin#iplant.
+func getIgnoredGrantees(cfg *viper.Viper) map[string]bool {
+	ignored := make(map[string]bool)
+	for _, name := range getStringList(cfg, "dataone.permissions.ignored-grantees") {
+		ignored[name] = true
+	}
+	return ignored
+}
+
+// getSubscriptionKeys returns the routing keys to bind to the queue. This includes the keys listed in the subscription
+// setting along with every routing key that the recorder knows how to handle. Duplicate keys are removed.
+func getSubscriptionKeys(cfg *viper.Viper) []string {
+	keys := getStringList(cfg, "amqp.routing-key.subscription")
+
+	// Add the routing keys used by the recorder, sorted so that the bindings are established in a consistent order.
+	var recorderKeys []string
+	for _, value := range cfg.GetStringMap("dataone.amqp-routing-keys") {
+		recorderKeys = append(recorderKeys, toStringList(value)...)
+	}
+	sort.Strings(recorderKeys)
+	keys = append(keys, recorderKeys...)
+
+	// Remove duplicates while preserving the order.
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// The levels at which recorded events may be logged.
+const (
+	recordedLogOff   = "off"
+	recordedLogDebug = "debug"
+	recordedLogInfo  = "info"
+)
+
+// getRecordedLogLevel returns the level at which each recorded event is logged. Recorded events are logged at the
+// debug level by default so that production deployments can keep them quiet.
+func getRecordedLogLevel(cfg *viper.Viper) (string, error) {
+	level := cfg.GetString("dataone.recorded-log-level")
+	switch level {
+	case "":
+		return recordedLogDebug, nil
+	case recordedLogOff, recordedLogDebug, recordedLogInfo:
+		return level, nil
+	}
+	return "", fmt.Errorf("unsupported dataone.recorded-log-level: %s", level)
+}
+
+// getCredential returns a setting that contains a secret, along with a description of where the setting came from. An
+// environment variable takes precedence over a file named by the setting with the suffix "-file", which takes
+// precedence over the setting itself. The contents of the file are trimmed so that trailing newlines don't become part
+// of the secret.
+func getCredential(cfg *viper.Viper, key, envVar string) (string, string, error) {
+	if value := strings.TrimSpace(os.Getenv(envVar)); value != "" {
+		return value, fmt.Sprintf("environment variable %s", envVar), nil
+	}
+
+	fileKey := key + "-file"
+	if path := cfg.GetString(fileKey); path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("unable to read %s (%s): %s", fileKey, path, err)
+		}
+		value := strings.TrimSpace(string(contents))
+		if value == "" {
+			return "", "", fmt.Errorf("%s (%s) is empty", fileKey, path)
+		}
+		return value, fmt.Sprintf("file %s", path), nil
+	}
+
+	return cfg.GetString(key), fmt.Sprintf("configuration setting %s", key), nil
+}
+
+// loadCredentials resolves the settings that contain secrets and stores the resolved values in the configuration so
+// that the rest of the service can read them normally. The source of each secret is logged, but the secret isn't.
+func loadCredentials(cfg *viper.Viper) error {
+	credentials := []struct {
+		key    string
+		envVar string
+	}{
+		{"amqp.uri", "DATAONE_AMQP_URI"},
+		{"amqp.uris", "DATAONE_AMQP_URIS"},
+		{"db.uri", "DATAONE_DB_URI"},
+	}
+
+	for _, credential := range credentials {
+		value, source, err := getCredential(cfg, credential.key, credential.envVar)
+		if err != nil {
+			return err
+		}
+
+		// Settings that aren't strings, such as lists of broker URIs, are only replaced if they came from another
+		// source.
+		if value != cfg.GetString(credential.key) {
+			cfg.Set(credential.key, value)
+		}
+		logger.Log.Infof("loaded %s from %s", credential.key, source)
+	}
+	return nil
+}
+
+// The default exchange settings. The defaults aren't included in the default configuration because a list of exchanges
+// in the configuration file couldn't replace them.
+const (
+	defaultExchangeName = "de"
+	defaultExchangeType = "topic"
+)
+
+// exchangeBinding describes an exchange that the queue is bound to, along with the routing keys to bind. The exchange
+// is declared before it's bound if declare is true.
+type exchangeBinding struct {
+	name        string
+	kind        string
+	declare     bool
+	routingKeys []string
+}
+
+// parseExchangeBinding parses the settings for a single exchange. The default routing keys are used if the settings
+// don't list any routing keys.
+func parseExchangeBinding(value interface{}, defaultKeys []string) (*exchangeBinding, error) {
+	settings, err := cast.ToStringMapE(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exchange settings: %v", value)
+	}
+
+	name := strings.TrimSpace(cast.ToString(settings["name"]))
+	if name == "" {
+		return nil, fmt.Errorf("the exchange name is required")
+	}
+
+	routingKeys := toStringList(settings["routing-keys"])
+	if len(routingKeys) == 0 {
+		routingKeys = defaultKeys
+	}
+
+	kind := strings.TrimSpace(cast.ToString(settings["type"]))
+	if kind == "" {
+		kind = defaultExchangeType
+	}
+
+	declare, err := cast.ToBoolE(settings["declare"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid declare setting: %v", settings["declare"])
+	}
+
+	return &exchangeBinding{
+		name:        name,
+		kind:        kind,
+		declare:     declare,
+		routingKeys: routingKeys,
+	}, nil
+}
+
+// getExchangeBindings returns the exchanges that the queue should be bound to. The amqp.exchange setting may be
+// either a single exchange or a list of exchanges. Each exchange has a name, an optional type, an optional flag
+// indicating whether the exchange should be declared and an optional list of routing keys. Exchanges that don't list
+// any routing keys are bound using the subscription keys.
+func getExchangeBindings(cfg *viper.Viper) ([]*exchangeBinding, error) {
+	defaultKeys := getSubscriptionKeys(cfg)
+
+	switch v := cfg.Get("amqp.exchange").(type) {
+	case nil:
+		return []*exchangeBinding{{name: defaultExchangeName, kind: defaultExchangeType, routingKeys: defaultKeys}}, nil
+
+	case []interface{}:
+		if len(v) == 0 {
+			return nil, fmt.Errorf("amqp.exchange must list at least one exchange")
+		}
+		bindings := make([]*exchangeBinding, len(v))
+		for i, entry := range v {
+			binding, err := parseExchangeBinding(entry, defaultKeys)
+			if err != nil {
+				return nil, fmt.Errorf("amqp.exchange[%d]: %s", i, err)
+			}
+			bindings[i] = binding
+		}
+		return bindings, nil
+
+	default:
+		binding, err := parseExchangeBinding(v, defaultKeys)
+		if err != nil {
+			return nil, fmt.Errorf("amqp.exchange: %s", err)
+		}
+		return []*exchangeBinding{binding}, nil
+	}
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The beginning and end of the statement used to update the times at which objects were last accessed. The time is
+// never moved backwards, so replaying an old read event doesn't change it.
+const (
+	updateLastAccessedPrefix = `
+INSERT INTO object_access (permanent_id, last_accessed) VALUES `
+	updateLastAccessedSuffix = `
+ON CONFLICT (permanent_id) DO UPDATE
+SET last_accessed = GREATEST(object_access.last_accessed, EXCLUDED.last_accessed);
+`
+)
+
+// objectAccess is the most recent time at which an object was read within a set of events.
+type objectAccess struct {
+	entity string
+	time   time.Time
+}
+
+// lastAccesses returns the most recent time at which each object was read in a set of rows, sorted by object so that
+// concurrent transactions update the same rows of the object_access table in the same order. Events of other types,
+// events without timestamps and events that don't identify an object are skipped.
+func lastAccesses(rows []*eventRow) []*objectAccess {
+	latest := make(map[string]time.Time)
+	for _, row := range rows {
+		if row.event.Type != ETRead || row.event.Timestamp == nil || row.entity == "" {
+			continue
+		}
+		if t, ok := latest[row.entity]; !ok || row.event.Timestamp.After(t) {
+			latest[row.entity] = *row.event.Timestamp
+		}
+	}
+
+	result := make([]*objectAccess, 0, len(latest))
+	for entity, t := range latest {
+		result = append(result, &objectAccess{entity: entity, time: t})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].entity < result[j].entity
+	})
+	return result
+}
+
+// updateLastAccessedQuery returns the statement used to update the times at which the given objects were last
+// accessed, along with its arguments.
+func updateLastAccessedQuery(accesses []*objectAccess) (string, []interface{}) {
+	values := make([]string, len(accesses))
+	args := make([]interface{}, 0, len(accesses)*2)
+	for i, a := range accesses {
+		values[i] = fmt.Sprintf("($%d, $%d)", i*2+1, i*2+2)
+		args = append(args, a.entity, a.time)
+	}
+	return updateLastAccessedPrefix + strings.Join(values, ", ") + updateLastAccessedSuffix, args
+}
+
+// updateLastAccessed records the times at which the objects read in a set of rows were last accessed within a
+// transaction.
+func updateLastAccessed(ctx context.Context, exec execFunc, rows []*eventRow) error {
+	accesses := lastAccesses(rows)
+	for len(accesses) > 0 {
+		n := len(accesses)
+		if n > maxEventsPerInsert {
+			n = maxEventsPerInsert
+		}
+		query, args := updateLastAccessedQuery(accesses[:n])
+		if err := exec(ctx, query, args...); err != nil {
+			return err
+		}
+		accesses = accesses[n:]
+	}
+	return nil
+}
+
+// SetTrackLastAccessed enables or disables recording the time at which each object was last read in the
+// object_access table. When it's enabled, the table is updated in the same transaction that records the read events.
+// Events that are recorded by custom handlers aren't tracked.
+func (r *DefaultRecorder) SetTrackLastAccessed(enabled bool) {
+	r.lastAccessed = enabled
+}
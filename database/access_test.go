@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestLastAccesses verifies that the most recent read of each object is found and that other events are skipped.
+func TestLastAccesses(t *testing.T) {
+	older := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	rows := []*eventRow{
+		{entity: "b", event: &Event{Type: ETRead, Timestamp: &older}},
+		{entity: "a", event: &Event{Type: ETRead, Timestamp: &newer}},
+		{entity: "a", event: &Event{Type: ETRead, Timestamp: &older}},
+		{entity: "c", event: &Event{Type: "create", Timestamp: &newer}},
+		{entity: "d", event: &Event{Type: ETRead}},
+		{entity: "", event: &Event{Type: ETRead, Timestamp: &newer}},
+	}
+	expected := []objectAccess{{"a", newer}, {"b", older}}
+
+	accesses := lastAccesses(rows)
+	if len(accesses) != len(expected) {
+		t.Fatalf("expected %d accesses, got %d", len(expected), len(accesses))
+	}
+	for i, a := range accesses {
+		if a.entity != expected[i].entity || !a.time.Equal(expected[i].time) {
+			t.Errorf("access %d: expected %+v, got %+v", i, expected[i], *a)
+		}
+	}
+}
+
+// getTimestampedMessage returns a test message for a read event that occurred at the given time.
+func getTimestampedMessage(t time.Time) *model.Message {
+	msg := getTestMessage()
+	msg.Timestamp = (*model.Timestamp)(&t)
+	return msg
+}
+
+// TestRecordLastAccessed verifies that the time at which an object was last accessed is updated in the same
+// transaction as the event insert, and that replaying an older read event can't move the time backwards.
+func TestRecordLastAccessed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetTrackLastAccessed(true)
+	newer := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	older := newer.Add(-24 * time.Hour)
+
+	// Record the newer event followed by a replay of the older event. The insert is only prepared once.
+	for i, timestamp := range []time.Time{newer, older} {
+		msg := getTimestampedMessage(timestamp)
+		expectCurrentVersions(mock)
+		if i == 0 {
+			mock.ExpectPrepare("INSERT INTO event_log")
+		}
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO event_log").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+		mock.ExpectExec(`INSERT INTO object_access .* GREATEST\(object_access.last_accessed, EXCLUDED.last_accessed\)`).
+			WithArgs(msg.Entity, timestamp).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		if _, err := r.RecordEvent(context.Background(), ReadKey, msg); err != nil {
+			t.Fatalf("error encountered while recording event: %s", err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestLastAccessedDrivers verifies that replaying an older read event doesn't move the time at which an object was
+// last accessed backwards in a real database with each of the supported drivers.
+func TestLastAccessedDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		r.SetTrackLastAccessed(true)
+		ctx := context.Background()
+
+		newer := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+		older := newer.Add(-24 * time.Hour)
+		requests := []*EventRequest{
+			{Key: ReadKey, Msg: getTimestampedMessage(newer)},
+			{Key: LegacyReadKey, Msg: getTimestampedMessage(older)},
+		}
+		if _, err := r.RecordEvents(ctx, requests); err != nil {
+			t.Fatalf("%s: error encountered while recording events: %s", driver, err)
+		}
+		if _, err := r.RecordEvent(ctx, ReadKey, getTimestampedMessage(older)); err != nil {
+			t.Fatalf("%s: error encountered while replaying event: %s", driver, err)
+		}
+
+		var lastAccessed time.Time
+		query := "SELECT last_accessed FROM object_access WHERE permanent_id = $1"
+		if err := db.QueryRow(query, getTestMessage().Entity).Scan(&lastAccessed); err != nil {
+			t.Fatalf("%s: unable to look up the last access time: %s", driver, err)
+		}
+		if !lastAccessed.Equal(newer) {
+			t.Errorf("%s: expected the object to have been last accessed at %s, got %s", driver, newer, lastAccessed)
+		}
+		db.Close()
+	}
+}
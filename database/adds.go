@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// messageSystemMetadata returns the system metadata described by a message for a data object that was added or
+// modified. The permanent identifier is the entity in the message, the submitter is the author of the message, and
+// the rights holder and authoritative member node are the recorder's defaults.
+func messageSystemMetadata(r Recorder, msg *model.Message) *SystemMetadata {
+	details := messageDetails(msg)
+	rightsHolder, authoritativeNode := systemMetadataDefaults(r)
+	sm := &SystemMetadata{
+		PermanentID:       msg.Entity,
+		EntityUUID:        uuidArg(msg.UUID),
+		Path:              msg.Path,
+		NodeID:            messageNodeID(r, msg),
+		Size:              details.size,
+		Checksum:          details.checksum,
+		ChecksumAlgorithm: checksumAlgorithm(msg),
+		Submitter:         details.subject,
+		RightsHolder:      details.subject,
+	}
+	if rightsHolder != "" {
+		sm.RightsHolder = &rightsHolder
+	}
+	if authoritativeNode == "" {
+		authoritativeNode = sm.NodeID
+	}
+	sm.AuthoritativeNode = &authoritativeNode
+	return sm
+}
+
+// recordAdd is the function that DefaultRecorder uses to record data objects that were added to the repository. The
+// system metadata of a new object is created, so that it's known as soon as it arrives, and a create event is
+// recorded. The permanent identifier is the entity in the message, or a new identifier if the message doesn't identify
+// the object. If system metadata already exists for the object or for its path, the addition overwrote the object: a
+// new version that obsoletes the current one is created and an update event is recorded for it. Archived objects and
+// objects whose checksums aren't known yet are updated in place instead. Nothing is recorded for additions that are
+// older than the last update of the object or that don't change its checksum. An addition of an archived object,
+// or of an object under the path of an archived object, registers a new object with a new permanent identifier and
+// leaves the archived object as a tombstone, unless the recorder resurrects archived objects. A resurrected object is
+// restored and updated in place.
+func recordAdd(ctx context.Context, tx *sql.Tx, r Recorder, key string, msg *model.Message) (*Event, error) {
+	sm := messageSystemMetadata(r, msg)
+	resurrect := resurrectsArchived(r)
+	existing, err := findSystemMetadata(ctx, tx, msg.UUID, msg.Entity, msg.Path, resurrect)
+	if err != nil {
+		return nil, err
+	}
+	event := newEvent(r, ETCreate, msg)
+	writtenAt := *event.Timestamp
+
+	// Leave archived objects alone unless they're resurrected.
+	if existing != nil && existing.Archived && !resurrect {
+		if writtenAt.Before(existing.UpdatedAt) {
+			logger.Log.Debugf("skipping an addition that precedes the archiving of data object %s at '%s'",
+				existing.PermanentID, msg.Path)
+			return nil, nil
+		}
+		if err := buryTombstone(ctx, tx, existing); err != nil {
+			return nil, err
+		}
+		existing, sm.PermanentID = nil, ""
+	}
+
+	change := updateVersion
+	if existing != nil {
+		event.Type = ETUpdate
+		change = contentChange(existing, sm.Checksum, writtenAt)
+	}
+	switch {
+	case change == keepVersion:
+		logger.Log.Debugf("skipping an addition that doesn't change data object %s at '%s'", existing.PermanentID,
+			msg.Path)
+		return nil, nil
+	case change == obsoleteVersion:
+		sm = successorOf(existing, sm)
+		if err := supersede(ctx, tx, existing, sm, writtenAt); err != nil {
+			return nil, err
+		}
+	default:
+		if existing != nil {
+			sm.PermanentID = existing.PermanentID
+		} else if sm.PermanentID == "" {
+			if sm.PermanentID, err = newPermanentID(); err != nil {
+				return nil, err
+			}
+		}
+		permanentID, stored, err := putSystemMetadata(ctx, tx, sm, writtenAt)
+		if err != nil {
+			return nil, err
+		}
+		if !stored {
+			logger.Log.Debugf("skipping the addition of more recently updated data object %s at '%s'",
+				sm.PermanentID, msg.Path)
+			return nil, nil
+		}
+		sm.PermanentID = permanentID
+	}
+
+	rows := []*eventRow{{entity: sm.PermanentID, event: event}}
+	if err := insertEvents(ctx, tx, statementsFor(r), rows); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// buryTombstone removes the UUID from an archived data object that's being replaced by a new object, so that the new
+// object can take it. The archived object keeps its permanent identifier and its events.
+func buryTombstone(ctx context.Context, q queryer, tombstone *SystemMetadata) error {
+	if tombstone.EntityUUID == nil {
+		return nil
+	}
+	_, err := execNamed(ctx, q, releaseEntityUUID, namedArgs{"permanent_id": tombstone.PermanentID})
+	if err != nil {
+		return err
+	}
+	tombstone.EntityUUID = nil
+	return nil
+}
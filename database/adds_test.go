@@ -0,0 +1,323 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// getAddMessage returns a message describing an addition that can be used for testing.
+func getAddMessage(added time.Time, size int64, checksum string) *model.Message {
+	msg := getTimestampedMessage(added)
+	msg.Size = &size
+	msg.Checksum = checksum
+	msg.Algorithm, msg.Digest = model.ParseChecksum(checksum)
+	return msg
+}
+
+// expectSupersede sets the expectations for the statements that obsolete a registered data object with a new version.
+func expectSupersede(mock sqlmock.Sqlmock, predecessor string) {
+	mock.ExpectExec("UPDATE data_objects SET entity_uuid = NULL").
+		WithArgs(predecessor).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO data_objects").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE data_objects SET obsoleted_by").
+		WithArgs(&generatedPermanentID{}, sqlmock.AnyArg(), predecessor).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO access_policies").
+		WithArgs(&generatedPermanentID{}, predecessor).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// TestRecordAdd verifies that system metadata is created for a data object that's added to the repository along with
+// a create event, that an addition that overwrites a registered object creates a new version that obsoletes it, that
+// objects whose checksums aren't known yet are updated in place, and that nothing is recorded for additions that are
+// older than the last update or don't change the checksum.
+func TestRecordAdd(t *testing.T) {
+	added := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	path := "/iplant/home/shared/commons-repo/curated/foo.txt"
+	oldChecksum, sameChecksum := "md5:oldchecksum", "sha2:fakechecksum"
+	tests := []struct {
+		name      string
+		existing  *SystemMetadata
+		stored    bool
+		eventType string
+	}{
+		{"new", nil, true, ETCreate},
+		{"pending", registeredSystemMetadata("fakepid", path, nil, added.Add(-time.Hour)), true, ETUpdate},
+		{"stale pending", registeredSystemMetadata("fakepid", path, nil, added.Add(time.Hour)), false, ""},
+		{"overwrite", registeredSystemMetadata("fakepid", path, &oldChecksum, added.Add(-time.Hour)), true, ETUpdate},
+		{"older overwrite", registeredSystemMetadata("fakepid", path, &oldChecksum, added.Add(time.Hour)), false, ""},
+		{"unchanged", registeredSystemMetadata("fakepid", path, &sameChecksum, added.Add(-time.Hour)), false, ""},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("%s: error opening stub database connection: %s", test.name, err)
+		}
+		r := getTestRecorder(db)
+		msg := getAddMessage(added, 1024, "sha2:fakechecksum")
+		size, checksum, algorithm, creator := int64(1024), "sha2:fakechecksum", model.ChecksumSHA256, "ipcdev#iplant"
+		node := "fakenode"
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM data_objects").
+			WithArgs(msg.UUID, msg.Entity, msg.Path, false).
+			WillReturnRows(systemMetadataRows(test.existing))
+		var permanentID interface{} = msg.Entity
+		switch {
+		case test.existing != nil && test.existing.Checksum != nil:
+			if test.stored {
+				expectSupersede(mock, test.existing.PermanentID)
+				permanentID = &generatedPermanentID{}
+			}
+		default:
+			if test.existing != nil {
+				permanentID = test.existing.PermanentID
+			}
+			stored := sqlmock.NewRows([]string{"permanent_id"})
+			if test.stored {
+				stored.AddRow(permanentID)
+			}
+			mock.ExpectQuery("INSERT INTO data_objects").
+				WithArgs(
+					msg.UUID, permanentID, msg.Path, node, &size, &checksum, &algorithm, &creator, &creator, &node,
+					added,
+				).
+				WillReturnRows(stored)
+		}
+		if test.stored {
+			// The insert is prepared on the connection pool and then again on the transaction's connection.
+			mock.ExpectPrepare("INSERT INTO event_log")
+			mock.ExpectPrepare("INSERT INTO event_log")
+			mock.ExpectQuery("INSERT INTO event_log").
+				WithArgs(permanentID, msg.Path, test.eventType, &added, node).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+		}
+		mock.ExpectCommit()
+
+		event, err := r.RecordEvent(context.Background(), AddKey, msg)
+		if err != nil {
+			t.Errorf("%s: error encountered while recording addition: %s", test.name, err)
+		}
+		switch {
+		case test.stored && (event == nil || event.Type != test.eventType || event.ID != 42):
+			t.Errorf("%s: unexpected event: %+v", test.name, event)
+		case !test.stored && event != nil:
+			t.Errorf("%s: expected no event but got %+v", test.name, event)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+		db.Close()
+	}
+}
+
+// TestAddSystemMetadataDefaults verifies that a permanent identifier is generated for an addition that doesn't
+// identify the data object, that the configured rights holder and authoritative member node are recorded, and that
+// the system metadata is pending if the size and checksum aren't known.
+func TestAddSystemMetadataDefaults(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetSystemMetadataDefaults("CN=repository", "urn:node:authority")
+	added := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	msg := getTimestampedMessage(added)
+	msg.Entity, msg.UUID = "", ""
+
+	creator, rightsHolder, authority := "ipcdev#iplant", "CN=repository", "urn:node:authority"
+	generated := generatedPermanentID{}
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM data_objects").
+		WithArgs(nil, "", msg.Path, false).
+		WillReturnRows(systemMetadataRows(nil))
+	mock.ExpectQuery("INSERT INTO data_objects").
+		WithArgs(
+			nil, &generated, msg.Path, "fakenode", nil, nil, nil, &creator, &rightsHolder, &authority, added,
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"permanent_id"}).AddRow("urn:uuid:generated"))
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectQuery("INSERT INTO event_log").
+		WithArgs("urn:uuid:generated", msg.Path, ETCreate, &added, "fakenode").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectCommit()
+
+	event, err := r.RecordEvent(context.Background(), AddKey, msg)
+	if err != nil {
+		t.Errorf("error encountered while recording addition: %s", err)
+	}
+	if event == nil || event.Type != ETCreate {
+		t.Errorf("expected a create event but got %+v", event)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestAddArchived verifies that an addition of an archived data object registers a new object with a new permanent
+// identifier by default, that the archived object is restored instead if archived objects are resurrected, and that
+// additions that precede the archiving of the object are skipped.
+func TestAddArchived(t *testing.T) {
+	archivedAt := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		resurrect bool
+		added     time.Time
+		eventType string
+	}{
+		{"new object", false, archivedAt.Add(time.Hour), ETCreate},
+		{"resurrected", true, archivedAt.Add(time.Hour), ETUpdate},
+		{"stale", false, archivedAt.Add(-time.Hour), ""},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("%s: error opening stub database connection: %s", test.name, err)
+		}
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		r.SetResurrectArchived(test.resurrect)
+		msg := getAddMessage(test.added, 1024, "sha2:fakechecksum")
+		oldChecksum := "sha2:oldchecksum"
+		tombstone := registeredSystemMetadata("fakepid", msg.Path, &oldChecksum, archivedAt)
+		tombstone.EntityUUID, tombstone.Archived = &msg.UUID, true
+		size, checksum, algorithm, creator := int64(1024), "sha2:fakechecksum", model.ChecksumSHA256, "ipcdev#iplant"
+		node := "fakenode"
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM data_objects").
+			WithArgs(msg.UUID, msg.Entity, msg.Path, test.resurrect).
+			WillReturnRows(systemMetadataRows(tombstone))
+		var permanentID interface{} = &generatedPermanentID{}
+		stored := "urn:uuid:8a5505bc-4a9c-4d4b-9d8e-1f2b3c4d5e6f"
+		if test.resurrect {
+			permanentID, stored = "fakepid", "fakepid"
+		}
+		if test.eventType != "" {
+			if !test.resurrect {
+				mock.ExpectExec("UPDATE data_objects SET entity_uuid = NULL").
+					WithArgs("fakepid").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectQuery("INSERT INTO data_objects").
+				WithArgs(
+					msg.UUID, permanentID, msg.Path, node, &size, &checksum, &algorithm, &creator, &creator, &node,
+					test.added,
+				).
+				WillReturnRows(sqlmock.NewRows([]string{"permanent_id"}).AddRow(stored))
+			// The insert is prepared on the connection pool and then again on the transaction's connection.
+			mock.ExpectPrepare("INSERT INTO event_log")
+			mock.ExpectPrepare("INSERT INTO event_log")
+			mock.ExpectQuery("INSERT INTO event_log").
+				WithArgs(stored, msg.Path, test.eventType, &test.added, node).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+		}
+		mock.ExpectCommit()
+
+		event, err := r.RecordEvent(context.Background(), AddKey, msg)
+		if err != nil {
+			t.Errorf("%s: error encountered while recording addition: %s", test.name, err)
+		}
+		switch {
+		case test.eventType != "" && (event == nil || event.Type != test.eventType):
+			t.Errorf("%s: unexpected event: %+v", test.name, event)
+		case test.eventType == "" && event != nil:
+			t.Errorf("%s: expected no event but got %+v", test.name, event)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+		db.Close()
+	}
+}
+
+// TestAddDrivers verifies that additions are recorded with each of the supported drivers, and that adding an object
+// whose checksum wasn't known again updates its size, checksum and modification time without changing its creator or
+// creation time.
+func TestAddDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := getTestRecorder(db)
+		ctx := context.Background()
+
+		created := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+		modified := created.Add(time.Hour)
+		overwrite := getAddMessage(modified, 2048, "sha2:newchecksum")
+		overwrite.Author = &model.User{Name: "someone", Zone: "iplant"}
+		for _, msg := range []*model.Message{getAddMessage(created, 1024, ""), overwrite} {
+			if _, err := r.RecordEvent(ctx, AddKey, msg); err != nil {
+				t.Fatalf("%s: error encountered while recording addition: %s", driver, err)
+			}
+		}
+
+		var size int64
+		var checksum, creator string
+		var createdAt time.Time
+		var modifiedAt *time.Time
+		query := "SELECT file_size, checksum, creator, created_at, modified_at FROM data_objects" +
+			" WHERE permanent_id = $1"
+		err := db.QueryRow(query, overwrite.Entity).Scan(&size, &checksum, &creator, &createdAt, &modifiedAt)
+		switch {
+		case err != nil:
+			t.Errorf("%s: unable to look up the data object: %s", driver, err)
+		case size != 2048 || checksum != "sha2:newchecksum":
+			t.Errorf("%s: unexpected size and checksum: %d and %s", driver, size, checksum)
+		case creator != "ipcdev#iplant" || !createdAt.Equal(created):
+			t.Errorf("%s: unexpected creator and creation time: %s and %s", driver, creator, createdAt)
+		case modifiedAt == nil || !modifiedAt.Equal(modified):
+			t.Errorf("%s: unexpected modification time: %v", driver, modifiedAt)
+		}
+		db.Close()
+	}
+}
+
+// TestNormalizedPathDrivers verifies that a data object added under a path written with combining characters is
+// registered once under the composed path, and that a later message that identifies the object by the composed path
+// finds it.
+func TestNormalizedPathDrivers(t *testing.T) {
+	composed := "/iplant/home/shared/commons-repo/curated/caf\u00e9.txt"
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := getTestRecorder(db)
+		ctx := context.Background()
+
+		addition, err := model.Decode([]byte(`{
+			"entity": "fakeid", "path": "/iplant/home/shared/commons-repo/curated/cafe\u0301.txt",
+			"size": 1024, "timestamp": "2019-03-01.12:00:00"
+		}`))
+		if err != nil {
+			t.Fatalf("%s: unable to decode the addition: %s", driver, err)
+		}
+		change, err := model.Decode([]byte(`{"path": "` + composed + `", "timestamp": "2019-03-01.13:00:00"}`))
+		if err != nil {
+			t.Fatalf("%s: unable to decode the metadata change: %s", driver, err)
+		}
+		if _, err := r.RecordEvent(ctx, AddKey, addition); err != nil {
+			t.Fatalf("%s: error encountered while recording addition: %s", driver, err)
+		}
+		if _, err := r.RecordEvent(ctx, "data-object.metadata.add", change); err != nil {
+			t.Fatalf("%s: error encountered while recording metadata change: %s", driver, err)
+		}
+
+		var count int
+		var needsResync bool
+		query := "SELECT count(*), bool_and(needs_resync) FROM data_objects WHERE irods_path = $1"
+		if err := db.QueryRow(query, composed).Scan(&count, &needsResync); err != nil {
+			t.Errorf("%s: unable to look up the data object: %s", driver, err)
+		} else if count != 1 || !needsResync {
+			t.Errorf("%s: expected one stale data object but got %d (needs resync: %t)", driver, count, needsResync)
+		}
+		if err := db.QueryRow("SELECT count(*) FROM data_objects").Scan(&count); err != nil || count != 1 {
+			t.Errorf("%s: expected one data object but got %d (error: %v)", driver, count, err)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// The condition that selects the events in the event log that identify a user, either in the message stored in the
+// raw_payload column or in the idempotency key. Stored messages in version 2 of the message format name the user in
+// the username field of the author. Idempotency keys that are derived from message contents end with the user, so the
+// suffix is compared directly rather than with a pattern that could be affected by special characters.
+const userEventsCondition = `
+(
+    coalesce(raw_payload->'body'->'author'->>'name', raw_payload->'body'->'author'->>'username') = :name
+    AND raw_payload->'body'->'author'->>'zone' = :zone
+)
+OR (idempotency_key LIKE 'event/%' AND right(idempotency_key, length(:key_suffix::text)) = :key_suffix::text)
+`
+
+// The statement used to count the events in the event log that identify a user.
+var countUserEvents = named(`
+SELECT count(*) FROM event_log WHERE ` + userEventsCondition + `;
+`)
+
+// The statement used to replace the user in a batch of the events that identify the user with a pseudonym. The user's
+// name is replaced in stored messages that can be decoded. Stored messages that were truncated can't be rewritten, so
+// their bodies are removed. The user is replaced at the end of idempotency keys, which keeps the keys unique because
+// each user has a distinct pseudonym. Events are identified by both identifier and date so that the same statement
+// works whether or not the event log is partitioned.
+var anonymizeUserEvents = named(`
+UPDATE event_log SET
+    raw_payload = CASE
+        WHEN jsonb_typeof(raw_payload->'body'->'author'->'username') = 'string'
+            THEN jsonb_set(raw_payload, '{body,author,username}', to_jsonb(:pseudonym::text))
+        WHEN jsonb_typeof(raw_payload->'body'->'author') = 'object'
+            THEN jsonb_set(raw_payload, '{body,author,name}', to_jsonb(:pseudonym::text))
+        WHEN jsonb_typeof(raw_payload->'body') = 'string'
+            THEN jsonb_set(raw_payload, '{body}', 'null'::jsonb)
+        ELSE raw_payload
+    END,
+    idempotency_key = CASE
+        WHEN idempotency_key LIKE 'event/%' AND right(idempotency_key, length(:key_suffix::text)) = :key_suffix::text
+            THEN left(idempotency_key, length(idempotency_key) - length(:key_suffix::text)) || :pseudonym_suffix::text
+        ELSE idempotency_key
+    END
+WHERE (id, date_logged) IN (
+    SELECT id, date_logged FROM event_log WHERE ` + userEventsCondition + `
+    ORDER BY date_logged, id
+    LIMIT :batch_size
+);
+`)
+
+// userEventArgs returns the arguments that identify a user's events in the event log.
+func userEventArgs(user *model.User) namedArgs {
+	return namedArgs{"name": user.Name, "zone": user.Zone, "key_suffix": "/" + user.String()}
+}
+
+// CountUserEvents returns the number of events in the event log that identify the given user.
+func CountUserEvents(ctx context.Context, db *sql.DB, user *model.User) (int64, error) {
+	rows, err := queryNamed(ctx, db, countUserEvents, userEventArgs(user))
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	defer rows.Close()
+
+	var count int64
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return 0, classifyError(err)
+		}
+	}
+	return count, classifyError(rows.Err())
+}
+
+// AnonymizeUserEvents replaces the given user with a pseudonym in up to the given number of events in the event log,
+// returning the number of events that were rewritten. The pseudonym takes the place of the user's name, and the zone
+// is retained. Rewritten events no longer identify the user, so callers rewrite all of the user's events by calling
+// AnonymizeUserEvents until it rewrites fewer events than the batch size, and an interrupted run can simply be
+// repeated. The events themselves are retained, so event counts are unaffected.
+func AnonymizeUserEvents(
+	ctx context.Context, db *sql.DB, user *model.User, pseudonym string, batchSize int,
+) (int64, error) {
+	args := userEventArgs(user)
+	args["pseudonym"] = pseudonym
+	args["pseudonym_suffix"] = "/" + (&model.User{Name: pseudonym, Zone: user.Zone}).String()
+	args["batch_size"] = batchSize
+
+	result, err := execNamed(ctx, db, anonymizeUserEvents, args)
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	return result.RowsAffected()
+}
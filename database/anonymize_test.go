@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestCountUserEvents verifies that the events that identify a user are counted.
+func TestCountUserEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	user := &model.User{Name: "ipcdev", Zone: "iplant"}
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM event_log").
+		WithArgs("ipcdev", "iplant", "/ipcdev#iplant").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+
+	count, err := CountUserEvents(context.Background(), db, user)
+	if err != nil {
+		t.Fatalf("error encountered while counting events: %s", err)
+	}
+	if count != 42 {
+		t.Errorf("expected 42 events, got %d", count)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestAnonymizeUserEvents verifies that a batch of the events that identify a user is rewritten with a pseudonym.
+func TestAnonymizeUserEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	user := &model.User{Name: "ipcdev", Zone: "iplant"}
+	mock.ExpectExec("UPDATE event_log SET").
+		WithArgs("anon-fake", "/ipcdev#iplant", "/anon-fake#iplant", "ipcdev", "iplant", 100).
+		WillReturnResult(sqlmock.NewResult(0, 7))
+
+	rewritten, err := AnonymizeUserEvents(context.Background(), db, user, "anon-fake", 100)
+	if err != nil {
+		t.Fatalf("error encountered while anonymizing events: %s", err)
+	}
+	if rewritten != 7 {
+		t.Errorf("expected 7 events to be rewritten, got %d", rewritten)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestAnonymizeUserEventsDrivers verifies that a user's events are rewritten in a real database with each of the
+// supported drivers, and that the rewritten events no longer identify the user.
+func TestAnonymizeUserEventsDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		r.SetIdempotencyKeys(true)
+		r.SetRawPayloads(true, 65536)
+		ctx := context.Background()
+
+		user := getTestMessage().Author
+		for i := 0; i < 3; i++ {
+			if _, err := r.RecordEvent(ctx, ReadKey, getTestMessage()); err != nil {
+				t.Fatalf("%s: error encountered while recording event: %s", driver, err)
+			}
+		}
+
+		for _, expected := range []int64{2, 1, 0} {
+			rewritten, err := AnonymizeUserEvents(ctx, db, user, "anon-fake", 2)
+			if err != nil {
+				t.Fatalf("%s: error encountered while anonymizing events: %s", driver, err)
+			}
+			if rewritten != expected {
+				t.Errorf("%s: expected %d events to be rewritten, got %d", driver, expected, rewritten)
+			}
+		}
+		count, err := CountUserEvents(ctx, db, user)
+		if err != nil {
+			t.Fatalf("%s: error encountered while counting events: %s", driver, err)
+		}
+		if count != 0 {
+			t.Errorf("%s: expected no events to identify the user, got %d", driver, count)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// EventCallback is called once the outcome of recording a buffered event is known. The event is nil if there was no
+// handler for the routing key or if the event couldn't be recorded.
+type EventCallback func(*Event, error)
+
+// bufferedEvent is an event that is waiting to be recorded.
+type bufferedEvent struct {
+	request *EventRequest
+	done    EventCallback
+}
+
+// EventBuffer accumulates events and records them in batches, which requires far fewer round trips to the database
+// than recording each event separately. A batch is recorded when it reaches the maximum size or when the flush
+// interval has elapsed since the first event was added to it, whichever comes first. An EventBuffer is safe for
+// concurrent use by multiple goroutines.
+type EventBuffer struct {
+	recorder Recorder
+	size     int
+	interval time.Duration
+	timeout  time.Duration
+	mutex    sync.Mutex
+	pending  []*bufferedEvent
+	timer    *time.Timer
+}
+
+// NewEventBuffer creates an event buffer that records events using the given recorder. Each batch is abandoned if it
+// can't be recorded within the timeout. A timeout of zero or less means that there's no limit.
+func NewEventBuffer(recorder Recorder, size int, interval, timeout time.Duration) *EventBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &EventBuffer{
+		recorder: recorder,
+		size:     size,
+		interval: interval,
+		timeout:  timeout,
+	}
+}
+
+// Add adds an event to the buffer. The callback is called after the batch containing the event is recorded, so callers
+// should defer any acknowledgement of the message until then. If adding the event fills the batch, the batch is
+// recorded before Add returns.
+func (b *EventBuffer) Add(key string, msg *model.Message, done EventCallback) {
+	b.mutex.Lock()
+	b.pending = append(b.pending, &bufferedEvent{request: &EventRequest{Key: key, Msg: msg}, done: done})
+	if len(b.pending) == 1 && len(b.pending) < b.size {
+		b.timer = time.AfterFunc(b.interval, b.Flush)
+	}
+	var batch []*bufferedEvent
+	if len(b.pending) >= b.size {
+		batch = b.take()
+	}
+	b.mutex.Unlock()
+
+	b.record(batch)
+}
+
+// Flush records all of the events in the buffer. It should be called during shutdown so that no events are stranded.
+func (b *EventBuffer) Flush() {
+	b.mutex.Lock()
+	batch := b.take()
+	b.mutex.Unlock()
+
+	b.record(batch)
+}
+
+// take removes and returns the events in the buffer. The caller must hold the mutex.
+func (b *EventBuffer) take() []*bufferedEvent {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	batch := b.pending
+	b.pending = nil
+	return batch
+}
+
+// context returns the context used to record a batch of events.
+func (b *EventBuffer) context() (context.Context, context.CancelFunc) {
+	if b.timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), b.timeout)
+}
+
+// record records a batch of events and reports the outcome to each callback. If the batch can't be recorded, each of
+// the events is recorded individually so that one bad event doesn't prevent the others from being recorded.
+func (b *EventBuffer) record(batch []*bufferedEvent) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// Record the batch.
+	requests := make([]*EventRequest, len(batch))
+	for i, e := range batch {
+		requests[i] = e.request
+	}
+	ctx, cancel := b.context()
+	events, err := b.recorder.RecordEvents(ctx, requests)
+	cancel()
+
+	// Report the outcome.
+	if err == nil {
+		for i, e := range batch {
+			e.done(events[i], nil)
+		}
+		return
+	}
+	if len(batch) == 1 {
+		batch[0].done(nil, err)
+		return
+	}
+
+	// Fall back to recording the events individually.
+	for _, e := range batch {
+		ctx, cancel := b.context()
+		event, err := b.recorder.RecordEvent(ctx, e.request.Key, e.request.Msg)
+		cancel()
+		e.done(event, err)
+	}
+}
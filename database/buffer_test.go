@@ -0,0 +1,219 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// eventOutcomes records the outcomes reported to event buffer callbacks.
+type eventOutcomes struct {
+	mutex    sync.Mutex
+	events   []*Event
+	failures int
+	done     chan struct{}
+}
+
+// newEventOutcomes creates a set of outcomes that signals when the expected number of outcomes has been reported.
+func newEventOutcomes() *eventOutcomes {
+	return &eventOutcomes{done: make(chan struct{}, 100)}
+}
+
+// callback returns an event buffer callback that records the outcome.
+func (o *eventOutcomes) callback(event *Event, err error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if err != nil {
+		o.failures++
+	} else {
+		o.events = append(o.events, event)
+	}
+	o.done <- struct{}{}
+}
+
+// count returns the number of outcomes that have been reported.
+func (o *eventOutcomes) count() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return len(o.events) + o.failures
+}
+
+// expectBatch describes the database actions expected when a batch of read events is recorded. The insert statement is
+// only prepared the first time that a batch of the same size is recorded.
+func expectBatch(mock sqlmock.Sqlmock, firstID, n int, prepare bool) {
+	rows := sqlmock.NewRows([]string{"id"})
+	for i := 0; i < n; i++ {
+		rows.AddRow(firstID + i)
+	}
+	expectCurrentVersions(mock)
+	if prepare {
+		mock.ExpectPrepare("INSERT INTO event_log")
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").WillReturnRows(rows)
+	mock.ExpectCommit()
+}
+
+// TestEventBufferSize verifies that events are recorded in a single batch once the buffer fills, and that callbacks
+// aren't called until the batch is recorded.
+func TestEventBufferSize(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	expectBatch(mock, 1, 3, true)
+
+	outcomes := newEventOutcomes()
+	buffer := NewEventBuffer(getTestRecorder(db), 3, time.Hour, 0)
+	for i := 0; i < 3; i++ {
+		if count := outcomes.count(); count != 0 {
+			t.Fatalf("%d events were reported before the batch was recorded", count)
+		}
+		buffer.Add(ReadKey, getTestMessage(), outcomes.callback)
+	}
+
+	if len(outcomes.events) != 3 || outcomes.events[0].ID != 1 || outcomes.events[2].ID != 3 {
+		t.Errorf("unexpected events reported: %+v", outcomes.events)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestEventBufferInterval verifies that a partial batch is recorded once the flush interval elapses.
+func TestEventBufferInterval(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	expectBatch(mock, 1, 2, true)
+
+	outcomes := newEventOutcomes()
+	buffer := NewEventBuffer(getTestRecorder(db), 100, 10*time.Millisecond, 0)
+	buffer.Add(ReadKey, getTestMessage(), outcomes.callback)
+	buffer.Add(ReadKey, getTestMessage(), outcomes.callback)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-outcomes.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the buffer wasn't flushed after the flush interval")
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestEventBufferFlush verifies that flushing the buffer records the events that remain in it.
+func TestEventBufferFlush(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	expectBatch(mock, 1, 1, true)
+
+	outcomes := newEventOutcomes()
+	buffer := NewEventBuffer(getTestRecorder(db), 100, time.Hour, 0)
+	buffer.Add(ReadKey, getTestMessage(), outcomes.callback)
+	buffer.Flush()
+	buffer.Flush()
+
+	if count := outcomes.count(); count != 1 {
+		t.Errorf("expected 1 reported event but got %d", count)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestEventBufferFallback verifies that the events in a batch are recorded individually if the batch fails, so that
+// only the event that can't be recorded fails.
+func TestEventBufferFallback(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	// The batch fails, followed by one of the individual events.
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").WillReturnError(fmt.Errorf("something bad happened"))
+	mock.ExpectRollback()
+	expectBatch(mock, 1, 1, true)
+	expectCurrentVersions(mock)
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").WillReturnError(fmt.Errorf("something bad happened"))
+	mock.ExpectRollback()
+
+	outcomes := newEventOutcomes()
+	buffer := NewEventBuffer(getTestRecorder(db), 2, time.Hour, 0)
+	buffer.Add(ReadKey, getTestMessage(), outcomes.callback)
+	buffer.Add(ReadKey, getTestMessage(), outcomes.callback)
+
+	if len(outcomes.events) != 1 || outcomes.failures != 1 {
+		t.Errorf("expected 1 recorded event and 1 failure, got %d and %d", len(outcomes.events), outcomes.failures)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// benchmarkRoundTrip is the simulated latency of each round trip to the database in the benchmarks.
+const benchmarkRoundTrip = 200 * time.Microsecond
+
+// benchmarkEventBuffer measures the throughput of recording events with the given batch size. Each database round
+// trip is delayed to simulate network latency, which dominates the cost of recording an event.
+func benchmarkEventBuffer(b *testing.B, batchSize int) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		b.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	// Describe the expected database actions. The insert statement is prepared once for each distinct batch size.
+	prepared := make(map[int]bool)
+	for recorded := 0; recorded < b.N; recorded += batchSize {
+		n := batchSize
+		if remaining := b.N - recorded; remaining < n {
+			n = remaining
+		}
+		rows := sqlmock.NewRows([]string{"id"})
+		for i := 0; i < n; i++ {
+			rows.AddRow(recorded + i + 1)
+		}
+		if !prepared[n] {
+			mock.ExpectPrepare("INSERT INTO event_log")
+			prepared[n] = true
+		}
+		mock.ExpectBegin().WillDelayFor(benchmarkRoundTrip)
+		mock.ExpectQuery("INSERT INTO event_log").WillReturnRows(rows).WillDelayFor(benchmarkRoundTrip)
+		mock.ExpectCommit()
+	}
+
+	// Record the events.
+	msg := getTestMessage()
+	buffer := NewEventBuffer(getTestRecorder(db), batchSize, time.Hour, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buffer.Add(ReadKey, msg, func(event *Event, err error) {
+			if err != nil {
+				b.Fatalf("unable to record event: %s", err)
+			}
+		})
+	}
+	buffer.Flush()
+}
+
+// BenchmarkSingleRowInserts measures the throughput of recording each event in its own transaction.
+func BenchmarkSingleRowInserts(b *testing.B) {
+	benchmarkEventBuffer(b, 1)
+}
+
+// BenchmarkBatchedInserts measures the throughput of recording events in batches of 100.
+func BenchmarkBatchedInserts(b *testing.B) {
+	benchmarkEventBuffer(b, 100)
+}
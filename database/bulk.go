@@ -0,0 +1,319 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/stdlib"
+	"github.com/lib/pq"
+)
+
+// The name of the table in which bulk loaded events are staged, and the columns that are copied into it. The staging
+// table is a temporary table that's dropped when the transaction that loads the events ends.
+const stagingTable = "event_log_staging"
+
+var stagingColumns = []string{
+	"seq", "permanent_id", "irods_path", "event", "date_logged", "node_identifier", "raw_payload", "idempotency_key",
+}
+
+// The statement used to create the staging table, along with the table that records the events that were merged into
+// the event log so that the summary tables can be updated.
+const createStagingTables = `
+CREATE TEMPORARY TABLE event_log_staging (
+    seq bigint NOT NULL,
+    permanent_id text,
+    irods_path text NOT NULL,
+    event text NOT NULL,
+    date_logged timestamp with time zone NOT NULL,
+    node_identifier text NOT NULL,
+    raw_payload jsonb,
+    idempotency_key text
+) ON COMMIT DROP;
+
+CREATE TEMPORARY TABLE event_log_merged (
+    permanent_id text,
+    event text NOT NULL,
+    date_logged timestamp with time zone NOT NULL
+) ON COMMIT DROP;
+`
+
+// The statement used to create the event log partitions for the months in which the staged events occurred.
+const createStagedPartitions = `
+SELECT create_event_log_partition(month)
+FROM (SELECT DISTINCT date_trunc('month', date_logged AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS month
+      FROM event_log_staging) AS months;
+`
+
+// The statement used to merge the staged events into the event log. Only the first staged copy of each event with an
+// idempotency key is merged, and events whose keys match events that have already been recorded are skipped. The
+// merged events are recorded so that the summary tables can be updated.
+const mergeStagedEvents = `
+WITH merged AS (
+    INSERT INTO event_log (permanent_id, irods_path, event, date_logged, node_identifier, raw_payload, idempotency_key)
+    SELECT permanent_id, irods_path, event, date_logged, node_identifier, raw_payload, idempotency_key
+    FROM (
+        SELECT DISTINCT ON (coalesce(idempotency_key, seq::text)) *
+        FROM event_log_staging
+        ORDER BY coalesce(idempotency_key, seq::text), seq
+    ) AS staged
+    ORDER BY seq
+    ON CONFLICT (idempotency_key, date_logged) DO NOTHING
+    RETURNING permanent_id, event, date_logged
+)
+INSERT INTO event_log_merged SELECT * FROM merged;
+`
+
+// The statement used to add the merged events to the daily event counts.
+const mergeRollups = `
+INSERT INTO event_rollups (permanent_id, event, day, count)
+SELECT coalesce(permanent_id, ''), event, (date_logged AT TIME ZONE 'UTC')::date, count(*)
+FROM event_log_merged
+GROUP BY 1, 2, 3
+ORDER BY 1, 2, 3
+ON CONFLICT (permanent_id, event, day) DO UPDATE SET count = event_rollups.count + EXCLUDED.count;
+`
+
+// The statement used to update the times at which the objects read by the merged events were last accessed.
+const mergeLastAccessed = `
+INSERT INTO object_access (permanent_id, last_accessed)
+SELECT permanent_id, max(date_logged)
+FROM event_log_merged
+WHERE event = 'READ' AND permanent_id <> ''
+GROUP BY permanent_id
+ORDER BY permanent_id
+ON CONFLICT (permanent_id) DO UPDATE
+SET last_accessed = GREATEST(object_access.last_accessed, EXCLUDED.last_accessed);
+`
+
+// BulkSource supplies the events to bulk load. Next returns io.EOF once there are no more events.
+type BulkSource interface {
+	Next() (*EventRequest, error)
+}
+
+// BulkLoadResult describes the outcome of a bulk load. Loaded events were added to the event log, deduplicated events
+// were skipped because they duplicated other events in the load or events that had already been recorded, and
+// rejected events were skipped because they couldn't be recorded.
+type BulkLoadResult struct {
+	Loaded       int64
+	Deduplicated int64
+	Rejected     int64
+}
+
+// stagedRows converts the events supplied by a bulk source to rows of the staging table. It implements the pgx
+// CopyFromSource interface so that the rows can be copied using either driver.
+type stagedRows struct {
+	r        DefaultRecorder
+	source   BulkSource
+	values   []interface{}
+	staged   int64
+	rejected int64
+	err      error
+}
+
+// Next advances to the next event that can be staged, skipping and counting the events that can't be recorded by the
+// recorder because their routing keys have no event types or their messages have no timestamps. It returns false once
+// the source is exhausted or an error occurs.
+func (s *stagedRows) Next() bool {
+	for {
+		request, err := s.source.Next()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			s.err = err
+			return false
+		}
+
+		pattern, _ := s.r.handlers.find(request.Key)
+		eventType, ok := s.r.eventTypeFor(pattern, request.Msg)
+		if !ok || request.Msg.Timestamp == nil {
+			s.rejected++
+			continue
+		}
+		payload, err := s.r.rawPayloadFor(request.Key, request.Msg)
+		if err != nil {
+			s.err = err
+			return false
+		}
+
+		event := newEvent(s.r, eventType, request.Msg)
+		s.staged++
+		s.values = []interface{}{
+			s.staged, request.Msg.Entity, event.Path, event.Type, *event.Timestamp, event.NodeID, nil, nil,
+		}
+		if payload != nil {
+			s.values[6] = *payload
+		}
+		if s.r.idempotencyKeys {
+			if key := idempotencyKey(eventType, request.Msg); key != nil {
+				s.values[7] = *key
+			}
+		}
+		return true
+	}
+}
+
+// Values returns the values of the current row of the staging table.
+func (s *stagedRows) Values() ([]interface{}, error) {
+	return s.values, nil
+}
+
+// Err returns the error that stopped the rows from being staged, if any.
+func (s *stagedRows) Err() error {
+	return s.err
+}
+
+// bulkTx is a transaction in which events are bulk loaded. It hides the differences between the drivers.
+type bulkTx interface {
+	exec(ctx context.Context, query string) (int64, error)
+	copy(ctx context.Context, rows *stagedRows) error
+}
+
+// sqlBulkTx is a bulk load transaction that uses the lib/pq driver, which supports COPY through pq.CopyIn.
+type sqlBulkTx struct {
+	tx *sql.Tx
+}
+
+// exec executes a statement, returning the number of rows that it affected.
+func (t sqlBulkTx) exec(ctx context.Context, query string) (int64, error) {
+	result, err := execContext(ctx, t.tx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// copy copies rows into the staging table.
+func (t sqlBulkTx) copy(ctx context.Context, rows *stagedRows) error {
+	query := pq.CopyIn(stagingTable, stagingColumns...)
+	stmt, err := t.tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// The rows are buffered by the driver, so the statement is logged once all of them have been sent.
+	start := time.Now()
+	err = copyRows(ctx, stmt, rows)
+	logQuery(fmt.Sprintf("%s (%d rows)", query, rows.staged), nil, time.Since(start), err)
+	return err
+}
+
+// copyRows sends rows to the server using a prepared COPY statement.
+func copyRows(ctx context.Context, stmt *sql.Stmt, rows *stagedRows) error {
+	for rows.Next() {
+		if _, err := stmt.ExecContext(ctx, rows.values...); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err := stmt.ExecContext(ctx)
+	return err
+}
+
+// pgxBulkTx is a bulk load transaction that uses the pgx driver, which supports COPY through CopyFrom.
+type pgxBulkTx struct {
+	tx *pgx.Tx
+}
+
+// exec executes a statement, returning the number of rows that it affected.
+func (t pgxBulkTx) exec(ctx context.Context, query string) (int64, error) {
+	start := time.Now()
+	tag, err := t.tx.ExecEx(ctx, query, nil)
+	logQuery(query, nil, time.Since(start), err)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// copy copies rows into the staging table.
+func (t pgxBulkTx) copy(ctx context.Context, rows *stagedRows) error {
+	start := time.Now()
+	n, err := t.tx.CopyFrom(pgx.Identifier{stagingTable}, stagingColumns, rows)
+	logQuery(fmt.Sprintf("COPY %s (%d rows)", stagingTable, n), nil, time.Since(start), err)
+	return err
+}
+
+// BulkLoad loads all of the events supplied by a source in a single transaction. The events are copied into a staging
+// table and then merged into the event log and the summary tables that are enabled, using a few set-based statements
+// rather than one statement per event. Events with idempotency keys are deduplicated if idempotency keys are enabled,
+// and missing partitions of the event log are created if that's enabled. Nothing is loaded if an error occurs. Events
+// that duplicate recent events aren't suppressed, events are never recorded by custom handlers, and the operation
+// timeout doesn't apply.
+func (r DefaultRecorder) BulkLoad(ctx context.Context, source BulkSource) (*BulkLoadResult, error) {
+	rows := &stagedRows{r: r, source: source}
+	var merged int64
+	err := r.withBulkTx(ctx, func(tx bulkTx) error {
+		if _, err := tx.exec(ctx, createStagingTables); err != nil {
+			return err
+		}
+		if err := tx.copy(ctx, rows); err != nil {
+			return err
+		}
+		if r.createPartitions {
+			if _, err := tx.exec(ctx, createStagedPartitions); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if merged, err = tx.exec(ctx, mergeStagedEvents); err != nil {
+			return err
+		}
+		if r.rollups {
+			if _, err := tx.exec(ctx, mergeRollups); err != nil {
+				return err
+			}
+		}
+		if r.lastAccessed {
+			if _, err := tx.exec(ctx, mergeLastAccessed); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, classifyError(err)
+	}
+
+	return &BulkLoadResult{Loaded: merged, Deduplicated: rows.staged - merged, Rejected: rows.rejected}, nil
+}
+
+// withBulkTx calls a function within a bulk load transaction, committing the transaction if the function succeeds and
+// rolling it back otherwise. The transaction uses the driver that the database connection pool was opened with.
+func (r DefaultRecorder) withBulkTx(ctx context.Context, f func(bulkTx) error) error {
+	if r.pgx {
+		conn, err := stdlib.AcquireConn(r.db)
+		if err != nil {
+			return err
+		}
+		defer stdlib.ReleaseConn(r.db, conn)
+
+		tx, err := conn.BeginEx(ctx, pgxTxOptions(r.isolation))
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if err := f(pgxBulkTx{tx: tx}); err != nil {
+			return err
+		}
+		return tx.CommitEx(ctx)
+	}
+
+	tx, err := r.db.BeginTx(ctx, r.txOptions())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := f(sqlBulkTx{tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
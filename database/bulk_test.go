@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// requestSource is a bulk source that supplies a fixed list of event requests, optionally followed by an error.
+type requestSource struct {
+	requests []*EventRequest
+	err      error
+}
+
+// Next returns the next request in the list.
+func (s *requestSource) Next() (*EventRequest, error) {
+	if len(s.requests) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	request := s.requests[0]
+	s.requests = s.requests[1:]
+	return request, nil
+}
+
+// TestBulkLoad verifies that events are copied into the staging table and merged into the event log and the summary
+// tables, and that the events that couldn't be recorded or were deduplicated are counted.
+func TestBulkLoad(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetIdempotencyKeys(true)
+	r.SetRollups(true)
+	timestamp := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	msg := getTimestampedMessage(timestamp)
+	untimed := getTestMessage()
+	untimed.Timestamp = nil
+	source := &requestSource{requests: []*EventRequest{
+		{Key: ReadKey, Msg: msg},
+		{Key: "data-object.unknown", Msg: msg},
+		{Key: LegacyReadKey, Msg: msg},
+		{Key: ReadKey, Msg: untimed},
+	}}
+	key := *idempotencyKey(ETRead, msg)
+
+	// The second copy of the event is removed by the merge.
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMPORARY TABLE event_log_staging").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("COPY")
+	for _, seq := range []int64{1, 2} {
+		mock.ExpectExec("COPY").
+			WithArgs(seq, msg.Entity, msg.Path, ETRead, timestamp, "fakenode", nil, key).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("COPY").WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO event_log ").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO event_rollups").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := r.BulkLoad(context.Background(), source)
+	if err != nil {
+		t.Fatalf("error encountered while loading events: %s", err)
+	}
+	if result.Loaded != 1 || result.Deduplicated != 1 || result.Rejected != 2 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestErroneousBulkLoad verifies that nothing is loaded if the source of the events fails.
+func TestErroneousBulkLoad(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	source := &requestSource{
+		requests: []*EventRequest{{Key: ReadKey, Msg: getTestMessage()}},
+		err:      fmt.Errorf("something bad happened"),
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMPORARY TABLE event_log_staging").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare("COPY")
+	mock.ExpectExec("COPY").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if _, err := r.BulkLoad(context.Background(), source); err == nil {
+		t.Error("an error was expected but none was encountered")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestBulkLoadDrivers verifies that events are bulk loaded into a real database with each of the supported drivers,
+// and that events that had already been recorded aren't loaded again.
+func TestBulkLoadDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		r.SetIdempotencyKeys(true)
+		ctx := context.Background()
+
+		recorded := getTimestampedMessage(time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC))
+		if _, err := r.RecordEvent(ctx, ReadKey, recorded); err != nil {
+			t.Fatalf("%s: error encountered while recording event: %s", driver, err)
+		}
+		loaded := getTimestampedMessage(time.Date(2019, 3, 2, 12, 0, 0, 0, time.UTC))
+		source := &requestSource{requests: []*EventRequest{
+			{Key: ReadKey, Msg: recorded},
+			{Key: ReadKey, Msg: loaded},
+			{Key: LegacyReadKey, Msg: loaded},
+		}}
+
+		result, err := r.BulkLoad(ctx, source)
+		if err != nil {
+			t.Fatalf("%s: error encountered while loading events: %s", driver, err)
+		}
+		if result.Loaded != 1 || result.Deduplicated != 2 || result.Rejected != 0 {
+			t.Errorf("%s: unexpected result: %+v", driver, result)
+		}
+
+		var count int
+		if err := db.QueryRow("SELECT count(*) FROM event_log").Scan(&count); err != nil {
+			t.Fatalf("%s: unable to count events: %s", driver, err)
+		}
+		if count != 2 {
+			t.Errorf("%s: expected 2 events to be recorded, got %d", driver, count)
+		}
+		db.Close()
+	}
+}
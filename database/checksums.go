@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// The statement used to store the new checksum of a registered data object whose content changed. Nothing is updated
+// if the stored checksum is the same. Otherwise, the serial version of the object is incremented and it's flagged so
+// that its system metadata is synchronized again. The size is retained if the message doesn't include it, and the
+// system metadata is no longer pending once the size is known. The permanent identifier of the updated object is
+// returned.
+var updateChecksum = named(`
+UPDATE data_objects SET
+    checksum = :checksum::text,
+    checksum_algorithm = :checksum_algorithm::text,
+    file_size = coalesce(:file_size::bigint, file_size),
+    system_metadata_pending = coalesce(:file_size::bigint, file_size) IS NULL,
+    serial_version = serial_version + 1,
+    modified_at = greatest(modified_at, :changed_at),
+    needs_resync = true
+WHERE ` + dataObjectCondition + `
+AND checksum IS DISTINCT FROM :checksum::text
+RETURNING permanent_id;
+`)
+
+// checksumAlgorithm returns the algorithm that produced the checksum in a message, or nil if the message doesn't
+// include a checksum.
+func checksumAlgorithm(msg *model.Message) *string {
+	if msg.Algorithm == "" {
+		return nil
+	}
+	algorithm := msg.Algorithm
+	return &algorithm
+}
+
+// changeChecksum stores the new checksum of a registered data object if it differs from the stored checksum. It
+// returns the permanent identifier of the object and true if the checksum changed.
+func changeChecksum(ctx context.Context, q queryer, msg *model.Message, changedAt time.Time) (string, bool, error) {
+	if err := syncPath(ctx, q, msg.UUID, msg.Path, changedAt); err != nil {
+		return "", false, err
+	}
+	values := namedArgs{
+		"entity_uuid":        uuidArg(msg.UUID),
+		"permanent_id":       msg.Entity,
+		"irods_path":         msg.Path,
+		"checksum":           msg.Checksum,
+		"checksum_algorithm": checksumAlgorithm(msg),
+		"file_size":          msg.Size,
+		"changed_at":         changedAt,
+	}
+	rows, err := queryNamed(ctx, q, updateChecksum, values)
+	if err != nil {
+		return "", false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", false, rows.Err()
+	}
+	var permanentID string
+	if err := rows.Scan(&permanentID); err != nil {
+		return "", false, err
+	}
+	return permanentID, true, rows.Close()
+}
+
+// recordChecksumChange is the function that DefaultRecorder uses to record changes to the checksums of data objects in
+// the repository. If the checksum of a registered object differs from the stored checksum, the object was overwritten:
+// a new version that obsoletes the current one is created and an update event is recorded for it. The checksum of an
+// archived object or one whose checksum wasn't known yet is stored in place instead, and an update event is recorded
+// for the object. Nothing is recorded if the checksum is the same, the change is older than the last update of the
+// object or the object was never registered. Checksums produced by algorithms that aren't recognized are stored
+// verbatim.
+func recordChecksumChange(ctx context.Context, tx *sql.Tx, r Recorder, key string, msg *model.Message) (*Event, error) {
+	if msg.Checksum == "" {
+		return nil, fmt.Errorf("modification message for path '%s' doesn't include a checksum", msg.Path)
+	}
+	if !model.IsKnownChecksumAlgorithm(msg.Algorithm) {
+		logger.Log.Warnf("storing the checksum of '%s' with the unknown algorithm '%s'", msg.Path, msg.Algorithm)
+	}
+
+	event := newEvent(r, ETUpdate, msg)
+	existing, err := findSystemMetadata(ctx, tx, msg.UUID, msg.Entity, msg.Path, false)
+	if err != nil {
+		return nil, err
+	}
+	change := keepVersion
+	if existing != nil {
+		change = contentChange(existing, &msg.Checksum, *event.Timestamp)
+	}
+
+	var permanentID string
+	switch change {
+	case obsoleteVersion:
+		successor := successorOf(existing, messageSystemMetadata(r, msg))
+		if err := supersede(ctx, tx, existing, successor, *event.Timestamp); err != nil {
+			return nil, err
+		}
+		permanentID = successor.PermanentID
+	case updateVersion:
+		var changed bool
+		if permanentID, changed, err = changeChecksum(ctx, tx, msg, *event.Timestamp); err != nil {
+			return nil, err
+		}
+		if !changed {
+			change = keepVersion
+		}
+	}
+	if change == keepVersion {
+		logger.Log.Debugf("the checksum of '%s' is unchanged or the data object isn't registered", msg.Path)
+		return nil, nil
+	}
+
+	rows := []*eventRow{{entity: permanentID, event: event}}
+	if err := insertEvents(ctx, tx, statementsFor(r), rows); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestRecordChecksumChange verifies that a checksum change for a registered data object creates a new version that
+// obsoletes it and records an update event, that the checksum of an object whose checksum wasn't known is stored in
+// place, and that nothing is recorded when the checksum is the same or the object isn't registered.
+func TestRecordChecksumChange(t *testing.T) {
+	changed := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	path := "/iplant/home/shared/commons-repo/curated/foo.txt"
+	oldChecksum, newChecksum := "md5:oldchecksum", "sha2:newchecksum"
+	tests := []struct {
+		name     string
+		existing *SystemMetadata
+		recorded bool
+	}{
+		{"pending", registeredSystemMetadata("fakepid", path, nil, changed.Add(-time.Hour)), true},
+		{"overwritten", registeredSystemMetadata("fakepid", path, &oldChecksum, changed.Add(-time.Hour)), true},
+		{"unchanged", registeredSystemMetadata("fakepid", path, &newChecksum, changed.Add(-time.Hour)), false},
+		{"unregistered", nil, false},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("%s: error opening stub database connection: %s", test.name, err)
+		}
+		r := getTestRecorder(db)
+		msg := getAddMessage(changed, 2048, "sha2:newchecksum")
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM data_objects").
+			WithArgs(msg.UUID, msg.Entity, msg.Path, false).
+			WillReturnRows(systemMetadataRows(test.existing))
+		var permanentID interface{} = msg.Entity
+		switch test.name {
+		case "pending":
+			mock.ExpectExec("UPDATE data_objects SET irods_path").
+				WithArgs(msg.Path, changed, msg.UUID).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("UPDATE data_objects SET checksum").
+				WithArgs("sha2:newchecksum", model.ChecksumSHA256, msg.Size, &changed, msg.UUID, msg.Entity, msg.Path).
+				WillReturnRows(sqlmock.NewRows([]string{"permanent_id"}).AddRow(msg.Entity))
+		case "overwritten":
+			expectSupersede(mock, test.existing.PermanentID)
+			permanentID = &generatedPermanentID{}
+		}
+		if test.recorded {
+			// The insert is prepared on the connection pool and then again on the transaction's connection.
+			mock.ExpectPrepare("INSERT INTO event_log")
+			mock.ExpectPrepare("INSERT INTO event_log")
+			mock.ExpectQuery("INSERT INTO event_log").
+				WithArgs(permanentID, msg.Path, ETUpdate, &changed, r.GetNodeID()).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+		}
+		mock.ExpectCommit()
+
+		event, err := r.RecordEvent(context.Background(), ModifyKey, msg)
+		if err != nil {
+			t.Errorf("%s: error encountered while recording checksum change: %s", test.name, err)
+		}
+		switch {
+		case test.recorded && (event == nil || event.Type != ETUpdate || event.ID != 42):
+			t.Errorf("%s: unexpected event: %+v", test.name, event)
+		case !test.recorded && event != nil:
+			t.Errorf("%s: expected no event but got %+v", test.name, event)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+		db.Close()
+	}
+}
+
+// TestRecordChecksumChangeWithoutChecksum verifies that modification messages without checksums are rejected.
+func TestRecordChecksumChangeWithoutChecksum(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+	r := getTestRecorder(db)
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	if _, err := r.RecordEvent(context.Background(), ModifyKey, getTestMessage()); err == nil {
+		t.Error("expected an error for a modification without a checksum")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestChecksumDrivers verifies that checksum changes are recorded with each of the supported drivers: a checksum that
+// matches the stored one doesn't change anything, and a new checksum is stored in a new version of the object that
+// obsoletes the original.
+func TestChecksumDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := getTestRecorder(db)
+		ctx := context.Background()
+
+		added := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+		addition := getAddMessage(added, 1024, "sha2:oldchecksum")
+		if _, err := r.RecordEvent(ctx, AddKey, addition); err != nil {
+			t.Fatalf("%s: error encountered while recording addition: %s", driver, err)
+		}
+		tests := []struct {
+			msg     *model.Message
+			changed bool
+		}{
+			{getAddMessage(added.Add(time.Hour), 1024, "sha2:oldchecksum"), false},
+			{getAddMessage(added.Add(2*time.Hour), 2048, "md5sum:newchecksum"), true},
+		}
+		for _, test := range tests {
+			event, err := r.RecordEvent(ctx, ModifyKey, test.msg)
+			if err != nil {
+				t.Fatalf("%s: error encountered while recording checksum change: %s", driver, err)
+			}
+			if (event != nil) != test.changed {
+				t.Errorf("%s: %s: unexpected event: %+v", driver, test.msg.Checksum, event)
+			}
+		}
+
+		var checksum, algorithm string
+		var size int64
+		var obsoletes *string
+		query := "SELECT checksum, checksum_algorithm, file_size, obsoletes FROM data_objects" +
+			" WHERE entity_uuid = $1"
+		err := db.QueryRow(query, addition.UUID).Scan(&checksum, &algorithm, &size, &obsoletes)
+		switch {
+		case err != nil:
+			t.Errorf("%s: unable to look up the data object: %s", driver, err)
+		case checksum != "md5sum:newchecksum" || algorithm != "md5sum" || size != 2048:
+			t.Errorf("%s: unexpected data object: %s (%s), %d bytes", driver, checksum, algorithm, size)
+		case obsoletes == nil || *obsoletes != addition.Entity:
+			t.Errorf("%s: expected the new version to obsolete %s but got %v", driver, addition.Entity, obsoletes)
+		}
+		db.Close()
+	}
+}
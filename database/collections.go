@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// defaultCollectionBatchSize is the number of data objects updated by each statement that applies a collection
+// operation if no batch size is configured.
+const defaultCollectionBatchSize = 1000
+
+// The condition that selects a batch of the unarchived data objects below a collection that weren't updated after the
+// collection was changed. The beginning of each path is compared with the collection path directly, so that characters
+// in the path that have special meanings in patterns don't matter, and the trailing slash ensures that objects in other
+// collections whose names begin with the same characters aren't selected. The objects in each batch are updated at
+// the time of the change, so they aren't selected again.
+const collectionBatchCondition = `
+permanent_id IN (
+    SELECT permanent_id FROM data_objects
+    WHERE left(irods_path, length(:collection::text) + 1) = :collection::text || '/'
+    AND NOT archived
+    AND updated_at < :changed_at
+    ORDER BY permanent_id
+    LIMIT :batch_size
+)
+`
+
+// The statement used to rewrite the paths of a batch of the data objects in a collection that was moved or renamed.
+var moveCollectionBatch = named(`
+UPDATE data_objects SET
+    irods_path = :destination::text || substr(irods_path, length(:collection::text) + 1),
+    node_identifier = :node_identifier,
+    updated_at = :changed_at
+WHERE ` + collectionBatchCondition + `;
+`)
+
+// The statement used to archive a batch of the data objects in a collection that was removed from the repository.
+var archiveCollectionBatch = named(`
+UPDATE data_objects SET
+    archived = true,
+    archived_at = coalesce(archived_at, :changed_at),
+    updated_at = :changed_at
+WHERE ` + collectionBatchCondition + `;
+`)
+
+// The kinds of collection messages.
+const (
+	collectionMove    = "move"
+	collectionRemoval = "removal"
+)
+
+// collectionRequest describes a collection operation to apply as part of a batch, along with the number of data objects
+// that it updated.
+type collectionRequest struct {
+	op      *collectionOperation
+	updated int64
+}
+
+// collectionOperation is an operation that's applied to every registered data object in a collection.
+type collectionOperation struct {
+	description string
+	statement   *namedStatement
+	collection  string
+	values      namedArgs
+}
+
+// collectionOperationFor returns the operation that applies a collection message of the given kind to the data objects
+// in the collection. The data objects in a collection that's moved within the repository or into it have their paths
+// rewritten, and those in a collection that's moved out of the repository or removed are archived. Objects outside of
+// the repository are never registered, so the objects below the source of a move into the repository are the ones that
+// were already registered. A nil operation is returned for collections moved entirely outside of the repository.
+func collectionOperationFor(r Recorder, kind string, msg *model.Message) (*collectionOperation, error) {
+	values := namedArgs{"changed_at": messageTime(msg)}
+	if kind == collectionRemoval {
+		return &collectionOperation{"archived", archiveCollectionBatch, msg.Path, values}, nil
+	}
+	if msg.Source == "" {
+		return nil, fmt.Errorf("collection move message for path '%s' doesn't identify the source", msg.Path)
+	}
+	switch {
+	case msg.InRepository:
+		values["destination"] = msg.Path
+		values["node_identifier"] = messageNodeID(r, msg)
+		return &collectionOperation{"moved", moveCollectionBatch, msg.Source, values}, nil
+	case msg.SourceInRepository:
+		return &collectionOperation{"archived", archiveCollectionBatch, msg.Source, values}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// applyBatch applies a collection operation to a single batch of data objects, returning the number of objects that
+// were updated.
+func (op *collectionOperation) applyBatch(ctx context.Context, q queryer, batchSize int) (int64, error) {
+	values := namedArgs{"collection": op.collection, "batch_size": batchSize}
+	for name, value := range op.values {
+		values[name] = value
+	}
+	result, err := execNamed(ctx, q, op.statement, values)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// apply applies a collection operation to one batch of data objects after another by calling the given function until
+// a batch is smaller than the batch size. The total number of objects that were updated is returned.
+func (op *collectionOperation) apply(batchSize int, applyBatch func() (int64, error)) (int64, error) {
+	var total int64
+	for {
+		updated, err := applyBatch()
+		total += updated
+		if err != nil || updated < int64(batchSize) {
+			return total, err
+		}
+	}
+}
+
+// collectionHandler returns the handler function for collection messages of the given kind, which applies the message
+// to every registered data object in the collection within the transaction that it's given. DefaultRecorder applies
+// collection messages itself, one batch at a time, so the handler is only used when another recorder dispatches the
+// message. Collection messages aren't recorded in the event log, so no event is returned.
+func collectionHandler(kind string) HandlerFunction {
+	return func(ctx context.Context, tx *sql.Tx, r Recorder, key string, msg *model.Message) (*Event, error) {
+		op, err := collectionOperationFor(r, kind, msg)
+		if err != nil || op == nil {
+			return nil, err
+		}
+		count, err := op.apply(defaultCollectionBatchSize, func() (int64, error) {
+			return op.applyBatch(ctx, tx, defaultCollectionBatchSize)
+		})
+		if err != nil {
+			return nil, err
+		}
+		logger.Log.Infof("%s %d data objects in the collection '%s'", op.description, count, op.collection)
+		return nil, nil
+	}
+}
+
+// recordCollection applies a collection operation to every registered data object in the collection within the
+// transaction that records the rest of the batch, so the objects are only updated if the events in the batch are
+// recorded as well. The objects are updated in batches, one statement after another, so that no single statement has
+// to update every object in a very large collection. The number of objects that were updated is stored in the request.
+func (r DefaultRecorder) recordCollection(ctx context.Context, tx *sql.Tx, c *collectionRequest) error {
+	batchSize := r.collectionBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultCollectionBatchSize
+	}
+	var err error
+	c.updated, err = c.op.apply(batchSize, func() (int64, error) {
+		return c.op.applyBatch(ctx, tx, batchSize)
+	})
+	return err
+}
+
+// logCollections logs the number of data objects that were updated by each collection operation in a batch.
+func logCollections(collections []*collectionRequest) {
+	for _, c := range collections {
+		logger.Log.Infof("%s %d data objects in the collection '%s'", c.op.description, c.updated, c.op.collection)
+	}
+}
+
+// SetCollectionBatchSize sets the maximum number of data objects that are updated by each statement when a collection
+// is moved or removed. A batch size of zero or less selects the default batch size.
+func (r *DefaultRecorder) SetCollectionBatchSize(batchSize int) {
+	r.collectionBatchSize = batchSize
+}
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// The collections used to test collection messages.
+const (
+	collectionInside  = "/iplant/home/shared/commons_repo/curated/dataset"
+	collectionRenamed = "/iplant/home/shared/commons_repo/curated/renamed"
+	collectionOutside = "/iplant/home/ipcdev/dataset"
+)
+
+// TestRecordCollectionMove verifies that the data objects in a collection that's moved within the repository have
+// their paths rewritten in batches within a single transaction until a batch is smaller than the batch size.
+func TestRecordCollectionMove(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetCollectionBatchSize(2)
+
+	msg := getMoveMessage(collectionInside, collectionRenamed, true, true)
+	moved := *msg.Timestamp.ToTime()
+	mock.ExpectBegin()
+	for _, updated := range []int64{2, 2, 1} {
+		mock.ExpectExec("UPDATE data_objects SET irods_path").
+			WithArgs(collectionRenamed, collectionInside, "fakenode", &moved, 2).
+			WillReturnResult(sqlmock.NewResult(0, updated))
+	}
+	mock.ExpectCommit()
+
+	event, err := r.RecordEvent(context.Background(), CollectionMoveKey, msg)
+	if err != nil {
+		t.Errorf("error encountered while recording collection move: %s", err)
+	}
+	if event != nil {
+		t.Errorf("expected no event but got %+v", event)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestRecordCollectionArchive verifies that the data objects in a collection that's removed or moved out of the
+// repository are archived, and that nothing is updated for a collection that's moved outside of the repository.
+func TestRecordCollectionArchive(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		msg        *model.Message
+		collection string
+	}{
+		{"removal", CollectionDeleteKey, getMoveMessage("", collectionInside, false, true), collectionInside},
+		{"move out", CollectionMoveKey, getMoveMessage(collectionInside, collectionOutside, true, false),
+			collectionInside},
+		{"move outside", CollectionMoveKey, getMoveMessage(collectionOutside, collectionOutside+"2", false, false),
+			""},
+	}
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("%s: error opening stub database connection: %s", test.name, err)
+		}
+		r := getTestRecorder(db)
+
+		if test.collection != "" {
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE data_objects SET archived = true").
+				WithArgs(test.msg.Timestamp.ToTime(), test.collection, defaultCollectionBatchSize).
+				WillReturnResult(sqlmock.NewResult(0, 3))
+			mock.ExpectCommit()
+		}
+		if _, err := r.RecordEvent(context.Background(), test.key, test.msg); err != nil {
+			t.Errorf("%s: error encountered while recording collection message: %s", test.name, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+		db.Close()
+	}
+}
+
+// TestCollectionRollback verifies that a collection message is applied in the same transaction as the other events in
+// its batch, so that the data objects in the collection aren't updated if the events can't be recorded.
+func TestCollectionRollback(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+	r := getTestRecorder(db)
+
+	requests := []*EventRequest{
+		{Key: CollectionMoveKey, Msg: getMoveMessage(collectionInside, collectionRenamed, true, true)},
+		{Key: ReadKey, Msg: getTestMessage()},
+	}
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE data_objects SET irods_path").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectQuery("INSERT INTO event_log").WillReturnError(fmt.Errorf("something bad happened"))
+	mock.ExpectRollback()
+
+	if _, err := r.RecordEvents(context.Background(), requests); err == nil {
+		t.Error("an error was expected but none was encountered")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestRecordCollectionMoveWithoutSource verifies that collection moves that don't identify their sources are rejected.
+func TestRecordCollectionMoveWithoutSource(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+	r := getTestRecorder(db)
+
+	msg := getMoveMessage("", collectionRenamed, false, true)
+	if _, err := r.RecordEvent(context.Background(), CollectionMoveKey, msg); err == nil {
+		t.Error("expected an error for a collection move without a source")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestCollectionDrivers verifies that collection messages are applied with each of the supported drivers: only the
+// data objects below the collection are updated, including those in nested collections, and a collection that holds
+// more objects than the batch size is updated completely.
+func TestCollectionDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		r.SetCollectionBatchSize(1)
+		ctx := context.Background()
+
+		// Register objects in the collection, in a nested collection and in a collection with a similar name.
+		added := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+		paths := map[string]string{
+			"69F1A4C4-0F4B-4A32-8DB1-3CB52D8D9C01": collectionInside + "/foo.txt",
+			"69F1A4C4-0F4B-4A32-8DB1-3CB52D8D9C02": collectionInside + "/nested/bar.txt",
+			"69F1A4C4-0F4B-4A32-8DB1-3CB52D8D9C03": collectionInside + "-2/baz.txt",
+		}
+		for entity, path := range paths {
+			msg := getAddMessage(added, 1024, "sha2:checksum")
+			msg.Entity, msg.Path = entity, path
+			if _, err := r.RecordEvent(ctx, AddKey, msg); err != nil {
+				t.Fatalf("%s: error encountered while recording addition: %s", driver, err)
+			}
+		}
+
+		// Rename the collection.
+		msg := getMoveMessage(collectionInside, collectionRenamed, true, true)
+		moved := added.Add(time.Hour)
+		msg.Timestamp = (*model.Timestamp)(&moved)
+		if _, err := r.RecordEvent(ctx, CollectionMoveKey, msg); err != nil {
+			t.Fatalf("%s: error encountered while recording collection move: %s", driver, err)
+		}
+		expected := map[string]string{
+			"69F1A4C4-0F4B-4A32-8DB1-3CB52D8D9C01": collectionRenamed + "/foo.txt",
+			"69F1A4C4-0F4B-4A32-8DB1-3CB52D8D9C02": collectionRenamed + "/nested/bar.txt",
+			"69F1A4C4-0F4B-4A32-8DB1-3CB52D8D9C03": collectionInside + "-2/baz.txt",
+		}
+		for entity, path := range expected {
+			var actual string
+			query := "SELECT irods_path FROM data_objects WHERE permanent_id = $1"
+			if err := db.QueryRow(query, entity).Scan(&actual); err != nil {
+				t.Errorf("%s: unable to look up the data object: %s", driver, err)
+			} else if actual != path {
+				t.Errorf("%s: expected %s to be at %s but it's at %s", driver, entity, path, actual)
+			}
+		}
+
+		// Remove the renamed collection.
+		msg = getMoveMessage("", collectionRenamed, false, true)
+		removed := moved.Add(time.Hour)
+		msg.Timestamp = (*model.Timestamp)(&removed)
+		if _, err := r.RecordEvent(ctx, CollectionDeleteKey, msg); err != nil {
+			t.Fatalf("%s: error encountered while recording collection removal: %s", driver, err)
+		}
+		var archived int
+		query := "SELECT count(*) FROM data_objects WHERE archived"
+		if err := db.QueryRow(query).Scan(&archived); err != nil {
+			t.Errorf("%s: unable to count the archived data objects: %s", driver, err)
+		} else if archived != 2 {
+			t.Errorf("%s: expected 2 archived data objects but found %d", driver, archived)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,226 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// The statement used to archive a data object that was removed from the repository. The object remains in the table
+// as a tombstone, and its events remain in the event log, so that its identifier and history remain resolvable. The
+// time of the removal is stored, its serial version is incremented and it's flagged so that its system metadata is
+// synchronized again. Objects that are already archived aren't archived again, and objects that have been updated more
+// recently than the removal aren't archived, so that a removal that's processed out of order doesn't archive an object
+// that was restored later. The object is identified by its UUID if the permanent identifier is one. The permanent
+// identifier of the archived object is returned.
+var archiveDataObject = named(`
+UPDATE data_objects SET
+    archived = true,
+    archived_at = :archived_at,
+    serial_version = serial_version + 1,
+    needs_resync = true,
+    modified_at = :archived_at,
+    updated_at = :archived_at
+WHERE CASE
+    WHEN :entity_uuid::uuid IS NOT NULL THEN entity_uuid = :entity_uuid::uuid
+    ELSE permanent_id = :permanent_id
+END
+AND NOT archived
+AND updated_at <= :archived_at
+RETURNING permanent_id;
+`)
+
+// The beginning and end of the query used to determine which of a set of entities in messages are archived data
+// objects. Each entity is identified by its UUID if it's one and by its permanent identifier otherwise.
+const (
+	archivedEntitiesPrefix = `
+SELECT requested.entity
+FROM (VALUES `
+	archivedEntitiesSuffix = `) AS requested (entity, entity_uuid)
+WHERE EXISTS (
+    SELECT 1 FROM data_objects
+    WHERE archived
+    AND CASE
+        WHEN requested.entity_uuid IS NOT NULL THEN data_objects.entity_uuid = requested.entity_uuid
+        ELSE data_objects.permanent_id = requested.entity
+    END
+);
+`
+)
+
+// archiveObject archives a registered data object. The permanent identifier of the archived object is returned, or
+// an empty string if the object wasn't archived.
+func archiveObject(ctx context.Context, q queryer, permanentID string, archivedAt time.Time) (string, error) {
+	values := namedArgs{
+		"entity_uuid":  uuidArg(model.ParseUUID(permanentID)),
+		"permanent_id": permanentID,
+		"archived_at":  archivedAt,
+	}
+	rows, err := queryNamed(ctx, q, archiveDataObject, values)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", rows.Err()
+	}
+	var archived string
+	if err := rows.Scan(&archived); err != nil {
+		return "", err
+	}
+	return archived, rows.Close()
+}
+
+// ArchiveObject marks a registered data object as archived at the given time without removing it or its events. The
+// return value is false if the object isn't registered, is already archived or has been updated since the given time.
+// No event is recorded for the object.
+func (r DefaultRecorder) ArchiveObject(ctx context.Context, permanentID string, archivedAt time.Time) (bool, error) {
+	ctx, cancel := r.operationContext(ctx)
+	defer cancel()
+	archived, err := archiveObject(ctx, r.db, permanentID, archivedAt)
+	return archived != "", classifyError(err)
+}
+
+// recordDelete is the function that DefaultRecorder uses to record data objects that were removed from the
+// repository. Removed objects are archived rather than deleted, and a delete event is recorded under the permanent
+// identifier of the archived object. Removals of objects that were never registered or are already archived are
+// logged and skipped, and no event is returned for them.
+func recordDelete(ctx context.Context, tx *sql.Tx, r Recorder, key string, msg *model.Message) (*Event, error) {
+	if msg.Entity == "" {
+		return nil, fmt.Errorf("removal message for path '%s' doesn't identify the data object", msg.Path)
+	}
+	event := newEvent(r, ETDelete, msg)
+	permanentID, err := archiveObject(ctx, tx, msg.Entity, *event.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if permanentID == "" {
+		logger.Log.Infof("skipping the removal of unregistered, archived or recently updated data object %s at '%s'",
+			msg.Entity, msg.Path)
+		return nil, nil
+	}
+
+	rows := []*eventRow{{entity: permanentID, event: event}}
+	if err := insertEvents(ctx, tx, statementsFor(r), rows); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// SetSkipArchivedReads determines whether or not reads and previews of archived data objects are recorded. If they're
+// skipped, the registered data objects are checked before each batch of events is recorded, and the events for
+// archived objects are logged and marked as archived rather than being recorded.
+func (r *DefaultRecorder) SetSkipArchivedReads(skip bool) {
+	r.skipArchivedReads = skip
+}
+
+// SetResurrectArchived determines what happens when a data object is added under the path or UUID of an archived
+// data object. By default, the archived object is left as it is and a new object with its own permanent identifier
+// is registered. If resurrection is enabled, the archived object is restored and updated in place instead.
+func (r *DefaultRecorder) SetResurrectArchived(resurrect bool) {
+	r.resurrectArchived = resurrect
+}
+
+// archiveResurrector is implemented by recorders that may restore archived data objects that are added again.
+type archiveResurrector interface {
+	resurrectsArchived() bool
+}
+
+// resurrectsArchived returns true if archived data objects that are added again are restored.
+func (r DefaultRecorder) resurrectsArchived() bool {
+	return r.resurrectArchived
+}
+
+// resurrectsArchived returns true if a recorder restores archived data objects that are added again.
+func resurrectsArchived(r Recorder) bool {
+	if a, ok := r.(archiveResurrector); ok {
+		return a.resurrectsArchived()
+	}
+	return false
+}
+
+// archivedEntitiesQuery returns the query used to determine which of the given entities are archived data objects,
+// along with its arguments.
+func archivedEntitiesQuery(entities []string) (string, []interface{}) {
+	values := make([]string, len(entities))
+	args := make([]interface{}, 0, len(entities)*2)
+	for i, entity := range entities {
+		values[i] = fmt.Sprintf("($%d::text, $%d::uuid)", i*2+1, i*2+2)
+		args = append(args, entity, uuidArg(model.ParseUUID(entity)))
+	}
+	return archivedEntitiesPrefix + strings.Join(values, ", ") + archivedEntitiesSuffix, args
+}
+
+// archivedEntities returns the set of the given entities that are archived data objects.
+func (r DefaultRecorder) archivedEntities(ctx context.Context, entities []string) (map[string]bool, error) {
+	ctx, cancel := r.operationContext(ctx)
+	defer cancel()
+
+	archived := make(map[string]bool)
+	for len(entities) > 0 {
+		n := len(entities)
+		if n > maxEventsPerInsert {
+			n = maxEventsPerInsert
+		}
+		query, args := archivedEntitiesQuery(entities[:n])
+		rows, err := r.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var entity string
+			if err := rows.Scan(&entity); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			archived[entity] = true
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		entities = entities[n:]
+	}
+	return archived, nil
+}
+
+// dropArchivedReads removes the rows for reads and previews of archived data objects from the rows that the recorder
+// inserts, marking their events as archived. The remaining rows are returned.
+func (r DefaultRecorder) dropArchivedReads(ctx context.Context, rows []*eventRow) ([]*eventRow, error) {
+	var entities []string
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		if row.entity != "" && !seen[row.entity] {
+			seen[row.entity] = true
+			entities = append(entities, row.entity)
+		}
+	}
+	if len(entities) == 0 {
+		return rows, nil
+	}
+	archived, err := r.archivedEntities(ctx, entities)
+	if err != nil || len(archived) == 0 {
+		return rows, err
+	}
+
+	remaining := make([]*eventRow, 0, len(rows))
+	for _, row := range rows {
+		if !archived[row.entity] {
+			remaining = append(remaining, row)
+			continue
+		}
+		logger.Log.Infof("skipping the %s event for archived data object %s at '%s'", row.event.Type, row.entity,
+			row.event.Path)
+		row.event.Archived = true
+		countOperation(row.event.Type, operationArchived, 1)
+	}
+	return remaining, nil
+}
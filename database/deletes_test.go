@@ -0,0 +1,265 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestRecordDelete verifies that a data object that's removed from the repository is archived rather than deleted and
+// that a delete event is recorded under its permanent identifier, that removals of unregistered or archived objects are
+// skipped without an error, and that removals that don't identify the data object are rejected.
+func TestRecordDelete(t *testing.T) {
+	removed := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		entity   string
+		archived bool
+	}{
+		{"registered", "F3579BF9-284B-4B3C-841B-F6E87D3F78EA", true},
+		{"unregistered", "F3579BF9-284B-4B3C-841B-F6E87D3F78EA", false},
+		{"no entity", "", false},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("%s: error opening stub database connection: %s", test.name, err)
+		}
+		r := getTestRecorder(db)
+		msg := getTimestampedMessage(removed)
+		msg.Entity = test.entity
+
+		mock.ExpectBegin()
+		if test.entity == "" {
+			mock.ExpectRollback()
+		} else {
+			archived := sqlmock.NewRows([]string{"permanent_id"})
+			if test.archived {
+				archived.AddRow("fakepid")
+			}
+			mock.ExpectQuery("UPDATE data_objects SET archived = true").
+				WithArgs(removed, model.ParseUUID(test.entity), test.entity).
+				WillReturnRows(archived)
+			if test.archived {
+				// The insert is prepared on the connection pool and then again on the transaction's connection.
+				mock.ExpectPrepare("INSERT INTO event_log")
+				mock.ExpectPrepare("INSERT INTO event_log")
+				mock.ExpectQuery("INSERT INTO event_log").
+					WithArgs("fakepid", msg.Path, ETDelete, &removed, "fakenode").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+			}
+			mock.ExpectCommit()
+		}
+
+		event, err := r.RecordEvent(context.Background(), DeleteKey, msg)
+		if test.entity == "" && err == nil {
+			t.Errorf("%s: expected an error for a removal without an entity", test.name)
+		}
+		if test.entity != "" && err != nil {
+			t.Errorf("%s: error encountered while recording removal: %s", test.name, err)
+		}
+		if test.archived && (event == nil || event.Type != ETDelete || event.ID != 42) {
+			t.Errorf("%s: expected a delete event but got %+v", test.name, event)
+		}
+		if !test.archived && event != nil {
+			t.Errorf("%s: expected no event but got %+v", test.name, event)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+		db.Close()
+	}
+}
+
+// TestArchiveObject verifies that the recorder reports whether or not a data object was archived.
+func TestArchiveObject(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	removed := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("UPDATE data_objects").
+		WithArgs(removed, nil, "fakeid").
+		WillReturnRows(sqlmock.NewRows([]string{"permanent_id"}).AddRow("fakeid"))
+	mock.ExpectQuery("UPDATE data_objects").
+		WithArgs(removed, nil, "otherid").
+		WillReturnRows(sqlmock.NewRows([]string{"permanent_id"}))
+
+	for _, test := range []struct {
+		id       string
+		archived bool
+	}{{"fakeid", true}, {"otherid", false}} {
+		archived, err := r.ArchiveObject(context.Background(), test.id, removed)
+		if err != nil {
+			t.Errorf("%s: error encountered while archiving the data object: %s", test.id, err)
+		}
+		if archived != test.archived {
+			t.Errorf("%s: expected archived to be %t but got %t", test.id, test.archived, archived)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestDeleteDrivers verifies that removals are recorded with each of the supported drivers: the data object remains
+// registered under its last path, and it's marked as archived at the time of the first removal.
+func TestDeleteDrivers(t *testing.T) {
+	const (
+		inside  = "/iplant/home/shared/commons_repo/curated/foo.txt"
+		outside = "/iplant/home/ipcdev/foo.txt"
+	)
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := getTestRecorder(db)
+		ctx := context.Background()
+
+		// Register the object, remove it and then process a redelivered removal.
+		move := getMoveMessage(outside, inside, false, true)
+		removal := getTimestampedMessage(move.Timestamp.ToTime().Add(time.Hour))
+		redelivered := getTimestampedMessage(move.Timestamp.ToTime().Add(2 * time.Hour))
+		for _, req := range []struct {
+			key string
+			msg *model.Message
+		}{{MoveKey, move}, {DeleteKey, removal}, {DeleteKey, redelivered}} {
+			if _, err := r.RecordEvent(ctx, req.key, req.msg); err != nil {
+				t.Fatalf("%s: error encountered while recording %s: %s", driver, req.key, err)
+			}
+		}
+
+		var path string
+		var archived bool
+		var archivedAt time.Time
+		query := "SELECT irods_path, archived, archived_at FROM data_objects WHERE permanent_id = $1"
+		if err := db.QueryRow(query, move.Entity).Scan(&path, &archived, &archivedAt); err != nil {
+			t.Errorf("%s: unable to look up the data object: %s", driver, err)
+		} else if path != inside || !archived || !archivedAt.Equal(*removal.Timestamp.ToTime()) {
+			t.Errorf("%s: unexpected data object: %s (archived: %t at %s)", driver, path, archived, archivedAt)
+		}
+
+		// Removals of objects that were never registered are skipped.
+		unregistered := getTimestampedMessage(*removal.Timestamp.ToTime())
+		unregistered.Entity = "unregistered"
+		if _, err := r.RecordEvent(ctx, DeleteKey, unregistered); err != nil {
+			t.Errorf("%s: error encountered while recording the removal of an unregistered object: %s", driver, err)
+		}
+		db.Close()
+	}
+}
+
+// TestSkipArchivedReads verifies that reads of archived data objects are marked as archived rather than being recorded
+// if that's enabled, and that the other reads in the batch are still recorded.
+func TestSkipArchivedReads(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetSkipArchivedReads(true)
+	archived, current := getTestMessage(), getTestMessage()
+	current.Entity, current.UUID = "fakeid", ""
+	requests := []*EventRequest{{Key: ReadKey, Msg: archived}, {Key: ReadKey, Msg: current}}
+
+	mock.ExpectQuery("SELECT requested.entity").
+		WithArgs(archived.Entity, archived.UUID, current.Entity, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"entity"}).AddRow(archived.Entity))
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare(`INSERT INTO event_log .* VALUES \(\$1, \$2, \$3, \$4, \$5\)`)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO event_log .* VALUES \(\$1, \$2, \$3, \$4, \$5\)`).
+		WithArgs(current.Entity, current.Path, ETRead, current.Timestamp.ToTime(), "fakenode").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectCommit()
+
+	events, err := r.RecordEvents(context.Background(), requests)
+	if err != nil {
+		t.Fatalf("error encountered while recording the reads: %s", err)
+	}
+	if !events[0].Archived || events[0].ID != 0 {
+		t.Errorf("expected the read of the archived object to be skipped but got %+v", events[0])
+	}
+	if events[1].Archived || events[1].ID != 42 {
+		t.Errorf("expected the read of the current object to be recorded but got %+v", events[1])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestArchiveDrivers verifies that removals are recorded as delete events with each of the supported drivers, that
+// later reads of the archived object are skipped, and that adding the object again registers a new object by default
+// and restores the archived object if archived objects are resurrected.
+func TestArchiveDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		for _, resurrect := range []bool{false, true} {
+			db := openTestDatabase(t, driver)
+			r := NewRecorder(db, getKeyNames(), "fakenode")
+			r.SetSkipArchivedReads(true)
+			r.SetResurrectArchived(resurrect)
+			ctx := context.Background()
+			added := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+			addition := getAddMessage(added, 1024, "sha2:checksum")
+
+			// Add the object, remove it and then process a redelivered removal.
+			var events []*Event
+			for _, req := range []struct {
+				key string
+				msg *model.Message
+			}{
+				{AddKey, addition},
+				{DeleteKey, getTimestampedMessage(added.Add(time.Hour))},
+				{DeleteKey, getTimestampedMessage(added.Add(time.Hour))},
+				{ReadKey, getTimestampedMessage(added.Add(2 * time.Hour))},
+			} {
+				event, err := r.RecordEvent(ctx, req.key, req.msg)
+				if err != nil {
+					t.Fatalf("%s: error encountered while recording %s: %s", driver, req.key, err)
+				}
+				events = append(events, event)
+			}
+			if events[1] == nil || events[1].Type != ETDelete {
+				t.Errorf("%s: expected a delete event but got %+v", driver, events[1])
+			}
+			if events[2] != nil {
+				t.Errorf("%s: expected the redelivered removal to be skipped but got %+v", driver, events[2])
+			}
+			if events[3] == nil || !events[3].Archived {
+				t.Errorf("%s: expected the read of the archived object to be skipped but got %+v", driver, events[3])
+			}
+
+			// Add the object again.
+			readdition := getAddMessage(added.Add(3*time.Hour), 2048, "sha2:newchecksum")
+			event, err := r.RecordEvent(ctx, AddKey, readdition)
+			if err != nil {
+				t.Fatalf("%s: error encountered while recording the new addition: %s", driver, err)
+			}
+			current, err := r.FindSystemMetadata(ctx, readdition.UUID, "", "")
+			switch {
+			case err != nil:
+				t.Fatalf("%s: unable to look up the data object: %s", driver, err)
+			case current == nil || current.Archived || *current.Checksum != "sha2:newchecksum":
+				t.Errorf("%s: unexpected data object: %+v", driver, current)
+			case resurrect && (current.PermanentID != addition.Entity || event.Type != ETUpdate):
+				t.Errorf("%s: expected %s to be restored but got %s (%+v)", driver, addition.Entity,
+					current.PermanentID, event)
+			case !resurrect && (current.PermanentID == addition.Entity || event.Type != ETCreate):
+				t.Errorf("%s: expected a new data object but got %s (%+v)", driver, current.PermanentID, event)
+			}
+
+			// The archived object remains resolvable unless it was restored.
+			tombstone, err := r.FindSystemMetadata(ctx, "", addition.Entity, "")
+			if err != nil || tombstone == nil || tombstone.Archived == resurrect {
+				t.Errorf("%s: unexpected original data object: %+v (error: %v)", driver, tombstone, err)
+			}
+			db.Close()
+		}
+	}
+}
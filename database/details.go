@@ -0,0 +1,99 @@
+package database
+
+import (
+	"net"
+	"strings"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// eventDetails describes the details of an event that are taken from the message that produced it. Each detail is nil
+// if it's absent from the message, so that it's stored as a null value. The IP address of the client is only stored in
+// the ip_address column if it can be parsed; otherwise, it's stored as it appears in the message in the ip_address_raw
+// column. The number of bytes read is only stored for read events. The zone is the iRODS zone in which the event
+// occurred, which is taken from the path if the message doesn't include it.
+type eventDetails struct {
+	subject      *string
+	size         *int64
+	checksum     *string
+	ipAddress    *string
+	ipAddressRaw *string
+	userAgent    *string
+	bytesRead    *int64
+	zone         *string
+	resource     *string
+}
+
+// messageDetails returns the details of the event produced by a message. The subject is the qualified name of the
+// user who caused the event.
+func messageDetails(msg *model.Message) *eventDetails {
+	details := &eventDetails{size: msg.Size}
+	if subject := msg.Author.String(); subject != "" {
+		details.subject = &subject
+	}
+	if msg.Checksum != "" {
+		checksum := msg.Checksum
+		details.checksum = &checksum
+	}
+	if zone := msg.EventZone(); zone != "" {
+		details.zone = &zone
+	}
+	if resource := strings.TrimSpace(msg.Resource); resource != "" {
+		details.resource = &resource
+	}
+	return details
+}
+
+// addClient adds the IP address and user agent of the client that caused an event to its details. Either of them may
+// be absent from the message.
+func (d *eventDetails) addClient(msg *model.Message) {
+	if msg.IPAddress != "" {
+		address := msg.IPAddress
+		if ip := net.ParseIP(address); ip != nil {
+			address = ip.String()
+			d.ipAddress = &address
+		} else {
+			d.ipAddressRaw = &address
+		}
+	}
+	if msg.UserAgent != "" {
+		userAgent := msg.UserAgent
+		d.userAgent = &userAgent
+	}
+}
+
+// addReadSize adds the number of bytes that were read from a data object to the details of a read event. It's absent
+// if the message includes neither the number of bytes read nor a byte range that can be measured, in which case the
+// event is presumed to describe a full download.
+func (d *eventDetails) addReadSize(msg *model.Message) {
+	d.bytesRead = msg.ReadSize()
+}
+
+// values returns the values of the message detail columns, in the order in which they appear in inserts.
+func (d *eventDetails) values() []interface{} {
+	return []interface{}{
+		d.subject, d.size, d.checksum, d.ipAddress, d.ipAddressRaw, d.userAgent, d.bytesRead, d.zone, d.resource,
+	}
+}
+
+// hasDetails determines whether or not any of the given rows has message details. The message detail columns are only
+// included in inserts if one does.
+func hasDetails(rows []*eventRow) bool {
+	for _, row := range rows {
+		if row.details != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// SetMessageDetails enables or disables the storage of message details. When it's enabled, the user who caused each
+// event is stored in the subject column of the event log, and the size and checksum of the data object are stored in
+// the file_size and checksum columns if the message includes them. The iRODS zone and resource are stored in the zone
+// and resource columns. The IP address and user agent of the client that read a data object are stored in the
+// ip_address, ip_address_raw and user_agent columns of read events if the message includes them, and the number of
+// bytes read during a partial read is stored in the bytes_read column. The columns are added by schema migrations 10,
+// 18, 19 and 20. Events recorded by custom handlers don't include message details.
+func (r *DefaultRecorder) SetMessageDetails(enabled bool) {
+	r.messageDetails = enabled
+}
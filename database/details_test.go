@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestMessageDetails verifies that the details that are absent from a message are stored as null values.
+func TestMessageDetails(t *testing.T) {
+	size := int64(1024)
+	msg := getTestMessage()
+	msg.Size = &size
+	msg.Checksum = "sha2:fakechecksum"
+	details := messageDetails(msg)
+	if details.subject == nil || *details.subject != "ipcdev#iplant" {
+		t.Errorf("unexpected subject: %v", details.subject)
+	}
+	if details.size == nil || *details.size != size {
+		t.Errorf("unexpected size: %v", details.size)
+	}
+	if details.checksum == nil || *details.checksum != msg.Checksum {
+		t.Errorf("unexpected checksum: %v", details.checksum)
+	}
+
+	msg = getTestMessage()
+	msg.Author = nil
+	details = messageDetails(msg)
+	if details.subject != nil || details.size != nil || details.checksum != nil {
+		t.Errorf("expected every detail to be null: %+v", details)
+	}
+}
+
+// TestZoneDetails verifies that the zone is taken from the message if it's included and from the path otherwise, and
+// that an absent resource is stored as a null value.
+func TestZoneDetails(t *testing.T) {
+	msg := getTestMessage()
+	details := messageDetails(msg)
+	if details.zone == nil || *details.zone != "iplant" || details.resource != nil {
+		t.Errorf("unexpected zone or resource: %v, %v", details.zone, details.resource)
+	}
+
+	msg.Zone, msg.Resource = "partner", "demoResc"
+	details = messageDetails(msg)
+	if details.zone == nil || *details.zone != "partner" || details.resource == nil || *details.resource != "demoResc" {
+		t.Errorf("unexpected zone or resource: %v, %v", details.zone, details.resource)
+	}
+}
+
+// TestClientDetails verifies that client IP addresses are stored in canonical form if they can be parsed and as they
+// appear in the message otherwise, and that absent client details are stored as null values.
+func TestClientDetails(t *testing.T) {
+	tests := []struct {
+		name      string
+		ipAddress string
+		userAgent string
+		expected  []interface{}
+	}{
+		{"IPv4", "192.0.2.1", "curl/7.58.0", []interface{}{"192.0.2.1", nil, "curl/7.58.0"}},
+		{"IPv6", "2001:DB8:0:0::1", "", []interface{}{"2001:db8::1", nil, nil}},
+		{"unparseable", "192.0.2.1:8080", "", []interface{}{nil, "192.0.2.1:8080", nil}},
+		{"absent", "", "", []interface{}{nil, nil, nil}},
+	}
+
+	for _, test := range tests {
+		msg := getTestMessage()
+		msg.IPAddress, msg.UserAgent = test.ipAddress, test.userAgent
+		details := messageDetails(msg)
+		details.addClient(msg)
+		for i, column := range []string{"ip_address", "ip_address_raw", "user_agent"} {
+			actual := details.values()[i+3].(*string)
+			switch expected := test.expected[i]; {
+			case expected == nil && actual != nil:
+				t.Errorf("%s: expected %s to be null but got %s", test.name, column, *actual)
+			case expected != nil && (actual == nil || *actual != expected):
+				t.Errorf("%s: expected %s to be %s but got %v", test.name, column, expected, actual)
+			}
+		}
+	}
+}
+
+// TestReadSizeDetails verifies that the number of bytes read is stored for partial reads and that it's stored as a
+// null value if it can't be determined.
+func TestReadSizeDetails(t *testing.T) {
+	size := int64(1000)
+	bytesRead := int64(64)
+	tests := []struct {
+		name      string
+		bytesRead *int64
+		rng       string
+		expected  interface{}
+	}{
+		{"bytes read", &bytesRead, "", int64(64)},
+		{"byte range", nil, "bytes=0-99", int64(100)},
+		{"open byte range", nil, "bytes=900-", int64(100)},
+		{"unparseable byte range", nil, "bytes=oops", nil},
+		{"full download", nil, "", nil},
+	}
+
+	for _, test := range tests {
+		msg := getTestMessage()
+		msg.Size, msg.BytesRead, msg.Range = &size, test.bytesRead, test.rng
+		details := messageDetails(msg)
+		details.addReadSize(msg)
+		actual := details.values()[6].(*int64)
+		switch expected := test.expected; {
+		case expected == nil && actual != nil:
+			t.Errorf("%s: expected bytes_read to be null but got %d", test.name, *actual)
+		case expected != nil && (actual == nil || *actual != expected):
+			t.Errorf("%s: expected bytes_read to be %d but got %v", test.name, expected, actual)
+		}
+	}
+}
+
+// TestRecordMessageDetails verifies that the details of a message are stored alongside its event when message details
+// are enabled, even if idempotency keys aren't.
+func TestRecordMessageDetails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetMessageDetails(true)
+	size := int64(1024)
+	msg := getTestMessage()
+	msg.Size = &size
+	msg.IPAddress, msg.UserAgent = "192.0.2.1", "curl/7.58.0"
+
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare(`INSERT INTO event_log \(.*, raw_payload, idempotency_key, subject, file_size, checksum, .* \)`)
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").
+		WithArgs(
+			msg.Entity, msg.Path, ETRead, msg.Timestamp.ToTime(), r.GetNodeID(), nil, nil, "ipcdev#iplant", size, nil,
+			"192.0.2.1", nil, "curl/7.58.0", nil, "iplant", nil,
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "idempotency_key"}).AddRow(42, nil))
+	mock.ExpectCommit()
+
+	event, err := r.RecordEvent(context.Background(), ReadKey, msg)
+	if err != nil {
+		t.Fatalf("error encountered while recording event: %s", err)
+	}
+	if event.ID != 42 || event.Duplicate {
+		t.Errorf("unexpected event returned: %+v", event)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestMessageDetailsDrivers verifies that message details, including client addresses, are stored with each of the
+// supported drivers.
+func TestMessageDetailsDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		r.SetMessageDetails(true)
+		ctx := context.Background()
+
+		// Record one event whose message includes a size and one whose message doesn't.
+		size := int64(1024)
+		msgs := []*EventRequest{{Key: ReadKey, Msg: getTestMessage()}, {Key: ReadKey, Msg: getTestMessage()}}
+		msgs[0].Msg.Size = &size
+		msgs[0].Msg.IPAddress, msgs[1].Msg.IPAddress = "192.0.2.1", "unknown"
+		msgs[0].Msg.Range = "bytes=0-65535"
+		if _, err := r.RecordEvents(ctx, msgs); err != nil {
+			t.Fatalf("%s: error encountered while recording events: %s", driver, err)
+		}
+
+		var count int
+		query := "SELECT count(*) FROM event_log WHERE subject = $1 AND file_size = $2"
+		if err := db.QueryRow(query, "ipcdev#iplant", size).Scan(&count); err != nil || count != 1 {
+			t.Errorf("%s: expected 1 event with a size, found %d (error: %v)", driver, count, err)
+		}
+		query = "SELECT count(*) FROM event_log WHERE subject = $1 AND file_size IS NULL AND checksum IS NULL"
+		if err := db.QueryRow(query, "ipcdev#iplant").Scan(&count); err != nil || count != 1 {
+			t.Errorf("%s: expected 1 event without a size, found %d (error: %v)", driver, count, err)
+		}
+		query = "SELECT count(*) FROM event_log WHERE ip_address = '192.0.2.1' OR ip_address_raw = 'unknown'"
+		if err := db.QueryRow(query).Scan(&count); err != nil || count != 2 {
+			t.Errorf("%s: expected 2 events with client addresses, found %d (error: %v)", driver, count, err)
+		}
+		query = "SELECT count(*) FROM event_log WHERE bytes_read = 65536"
+		if err := db.QueryRow(query).Scan(&count); err != nil || count != 1 {
+			t.Errorf("%s: expected 1 partial read, found %d (error: %v)", driver, count, err)
+		}
+		db.Close()
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"testing"
 
@@ -32,6 +33,16 @@ type MockRecorder struct {
 	callMap  *CallMap
 }
 
+// RecordQuarantine does nothing. Quarantining messages isn't part of the dispatch system.
+func (r MockRecorder) RecordQuarantine(ctx context.Context, key string, body []byte, reason string) error {
+	return nil
+}
+
+// RecordFailure does nothing. Recording failures isn't part of the dispatch system.
+func (r MockRecorder) RecordFailure(ctx context.Context, key string, body []byte, reason string, attempts int) error {
+	return nil
+}
+
 // GetNodeID returns the node identifier associated with a mock event recorder.
 func (r MockRecorder) GetNodeID() string {
 	return r.nodeID
@@ -48,8 +59,21 @@ func (r MockRecorder) GetHandlerMap() *HandlerMap {
 }
 
 // RecordEvent records an event in the database if there is a handler for the given routing key.
-func (r MockRecorder) RecordEvent(key string, msg *model.Message) error {
-	return dispatchMessage(r, key, msg)
+func (r MockRecorder) RecordEvent(ctx context.Context, key string, msg *model.Message) (*Event, error) {
+	return dispatchMessage(ctx, nil, r, key, msg)
+}
+
+// RecordEvents records each event in a batch if there is a handler for its routing key.
+func (r MockRecorder) RecordEvents(ctx context.Context, requests []*EventRequest) ([]*Event, error) {
+	events := make([]*Event, len(requests))
+	for i, request := range requests {
+		event, err := dispatchMessage(ctx, nil, r, request.Key, request.Msg)
+		if err != nil {
+			return nil, err
+		}
+		events[i] = event
+	}
+	return events, nil
 }
 
 // newMockRecorder returns a mock event recorder with default settings.
@@ -57,9 +81,11 @@ func newMockRecorder() *MockRecorder {
 	var r MockRecorder
 	r = MockRecorder{
 		handlers: &HandlerMap{
-			RKRead: func(recorder Recorder, key string, msg *model.Message) error {
+			RKRead: func(
+				ctx context.Context, tx *sql.Tx, recorder Recorder, key string, msg *model.Message,
+			) (*Event, error) {
 				r.callMap.Read++
-				return nil
+				return &Event{}, nil
 			},
 		},
 		nodeID:  "some-node",
@@ -71,7 +97,7 @@ func newMockRecorder() *MockRecorder {
 // TestDispatch verifies that messages are dispatched as expected.
 func TestDispatch(t *testing.T) {
 	r := newMockRecorder()
-	r.RecordEvent(RKRead, nil)
+	r.RecordEvent(context.Background(), RKRead, nil)
 
 	// The method to record read events should have been called.
 	if r.callMap.Read != 1 {
@@ -82,7 +108,7 @@ func TestDispatch(t *testing.T) {
 // TestNonDispatch verifies that messages that should not be dispatched are not.
 func TestNonDispatch(t *testing.T) {
 	r := newMockRecorder()
-	r.RecordEvent(RKFake, nil)
+	r.RecordEvent(context.Background(), RKFake, nil)
 
 	// The method to record read events should not have been called.
 	if r.callMap.Read != 0 {
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net"
+
+	"github.com/jackc/pgx"
+	"github.com/lib/pq"
+)
+
+// RetryableError wraps an error that might not occur if the failed operation is attempted again later. Errors that
+// are not wrapped in a RetryableError should be treated as permanent.
+type RetryableError struct {
+	Err error
+}
+
+// Error returns the error message of the wrapped error.
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+// IsRetryable returns true if an error indicates that the failed operation might succeed if it's attempted again.
+func IsRetryable(err error) bool {
+	_, ok := err.(*RetryableError)
+	return ok
+}
+
+// sqlState returns the SQLSTATE code reported by the database server for an error, regardless of which driver reported
+// the error. The second return value is false if the error wasn't reported by the server.
+func sqlState(err error) (string, bool) {
+	switch e := err.(type) {
+	case *pq.Error:
+		return string(e.Code), true
+	case pgx.PgError:
+		return e.Code, true
+	case *pgx.PgError:
+		return e.Code, true
+	}
+	return "", false
+}
+
+// sqlMessage returns the primary error message reported by the database server for an error, or an empty string if
+// the error wasn't reported by the server.
+func sqlMessage(err error) string {
+	switch e := err.(type) {
+	case *pq.Error:
+		return e.Message
+	case pgx.PgError:
+		return e.Message
+	case *pgx.PgError:
+		return e.Message
+	}
+	return ""
+}
+
+// Postgres error classes that indicate transient conditions.
+var retryableErrorClasses = map[string]bool{
+	"08": true, // connection exception
+	"40": true, // transaction rollback, including serialization failures and deadlocks
+	"53": true, // insufficient resources
+	"57": true, // operator intervention, including administrative shutdowns
+	"58": true, // system error
+}
+
+// isTransient determines whether or not an error returned by the database layer represents a transient condition.
+func isTransient(err error) bool {
+	if code, ok := sqlState(err); ok {
+		return len(code) == 5 && retryableErrorClasses[code[:2]]
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+
+	switch err {
+	case driver.ErrBadConn, sql.ErrConnDone, io.EOF, io.ErrUnexpectedEOF, context.DeadlineExceeded, context.Canceled:
+		return true
+	case pgx.ErrDeadConn, pgx.ErrConnBusy:
+		return true
+	}
+
+	return false
+}
+
+// classifyError wraps an error in a RetryableError if it represents a transient condition. Other errors, such as
+// constraint violations and syntax errors, are returned unchanged. Each error is counted by class when it's first
+// classified.
+func classifyError(err error) error {
+	if err == nil || IsRetryable(err) {
+		return err
+	}
+	databaseErrors.Inc(errorClass(err))
+	if isTransient(err) {
+		return &RetryableError{Err: err}
+	}
+	return err
+}
+
+// IsConnectionError returns true if an error indicates that the database couldn't be reached or the connection to the
+// database was lost. Expired contexts aren't treated as connection errors, because an operation may time out while the
+// database is reachable.
+func IsConnectionError(err error) bool {
+	if e, ok := err.(*RetryableError); ok {
+		err = e.Err
+	}
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		return false
+	}
+	return isConnectionReset(err)
+}
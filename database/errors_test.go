@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx"
+	"github.com/lib/pq"
+)
+
+// TestClassifyError verifies that database errors are classified as retryable or permanent correctly.
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"serialization failure", &pq.Error{Code: "40001"}, true},
+		{"deadlock", &pq.Error{Code: "40P01"}, true},
+		{"connection failure", &pq.Error{Code: "08006"}, true},
+		{"admin shutdown", &pq.Error{Code: "57P01"}, true},
+		{"too many connections", &pq.Error{Code: "53300"}, true},
+		{"unique violation", &pq.Error{Code: "23505"}, false},
+		{"foreign key violation", &pq.Error{Code: "23503"}, false},
+		{"syntax error", &pq.Error{Code: "42601"}, false},
+		{"undefined table", &pq.Error{Code: "42P01"}, false},
+		{"pgx serialization failure", pgx.PgError{Code: "40001"}, true},
+		{"pgx unique violation", pgx.PgError{Code: "23505"}, false},
+		{"pgx dead connection", pgx.ErrDeadConn, true},
+		{"bad connection", driver.ErrBadConn, true},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"connection done", sql.ErrConnDone, true},
+		{"unexpected eof", io.ErrUnexpectedEOF, true},
+		{"network error", &net.OpError{Op: "read", Err: fmt.Errorf("connection reset by peer")}, true},
+		{"already retryable", &RetryableError{Err: fmt.Errorf("foo")}, true},
+		{"generic error", fmt.Errorf("something bad happened"), false},
+	}
+
+	for _, test := range tests {
+		err := classifyError(test.err)
+		if IsRetryable(err) != test.retryable {
+			t.Errorf("%s: expected retryable to be %t", test.name, test.retryable)
+		}
+		if err.Error() != test.err.Error() {
+			t.Errorf("%s: the error message changed from `%s` to `%s`", test.name, test.err, err)
+		}
+	}
+
+	// Classifying a nil error should produce a nil error.
+	if classifyError(nil) != nil {
+		t.Error("classifying a nil error should produce a nil error")
+	}
+}
+
+// TestIsConnectionError verifies that connection failures are detected whether or not they've been classified.
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"unclassified", io.EOF, true},
+		{"classified", classifyError(&net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}), true},
+		{"connection exception", classifyError(&pq.Error{Code: "08006"}), true},
+		{"deadlock", classifyError(&pq.Error{Code: "40P01"}), false},
+		{"timeout", classifyError(context.DeadlineExceeded), false},
+		{"permanent", &pq.Error{Code: "23505"}, false},
+	}
+
+	for _, test := range tests {
+		if actual := IsConnectionError(test.err); actual != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// The statement used to store a message whose event could not be recorded in the event_errors table.
+var addEventError = named(`
+INSERT INTO event_errors (routing_key, body, error, attempts, first_failed_at, last_failed_at)
+VALUES (:routing_key, :body, :error, :attempts, :failed_at, :failed_at);
+`)
+
+// The statement used to list the messages in the event_errors table that haven't been replayed successfully, in the
+// order in which they were stored.
+var listPendingEventErrors = named(`
+SELECT id, routing_key, body, error, attempts, first_failed_at, last_failed_at
+FROM event_errors
+WHERE resolved_at IS NULL AND id > :after_id
+ORDER BY id
+LIMIT :limit;
+`)
+
+// The statement used to mark a message in the event_errors table as replayed successfully.
+var resolveEventError = named(`
+UPDATE event_errors SET resolved_at = :resolved_at WHERE id = :id;
+`)
+
+// The statement used to record another failed attempt to record the event for a message in the event_errors table.
+var updateEventError = named(`
+UPDATE event_errors SET error = :error, attempts = attempts + 1, last_failed_at = :failed_at WHERE id = :id;
+`)
+
+// The statement used to remove messages from the event_errors table that were replayed successfully before a given
+// time.
+var pruneEventErrors = named(`
+DELETE FROM event_errors WHERE resolved_at < :before;
+`)
+
+// EventError describes a message whose event could not be recorded. The message is stored so that it can be replayed
+// once the cause of the failure has been addressed.
+type EventError struct {
+	ID            int64
+	Key           string
+	Body          []byte
+	Reason        string
+	Attempts      int
+	FirstFailedAt time.Time
+	LastFailedAt  time.Time
+}
+
+// RecordFailure stores a message whose event could not be recorded, along with the reason for the failure and the
+// number of attempts that were made to record the event, so that the message can be replayed later.
+func (r DefaultRecorder) RecordFailure(
+	ctx context.Context, key string, body []byte, reason string, attempts int,
+) error {
+	ctx, cancel := r.operationContext(ctx)
+	defer cancel()
+	_, err := r.statements.execNamed(ctx, addEventError, namedArgs{
+		"routing_key": key,
+		"body":        body,
+		"error":       reason,
+		"attempts":    attempts,
+		"failed_at":   time.Now(),
+	})
+	return classifyError(err)
+}
+
+// PendingEventErrors returns up to the given number of stored messages that haven't been replayed successfully. Only
+// messages with identifiers greater than the given identifier are returned, so the messages can be listed in pages.
+func PendingEventErrors(ctx context.Context, db *sql.DB, afterID int64, limit int) ([]*EventError, error) {
+	rows, err := queryNamed(ctx, db, listPendingEventErrors, namedArgs{"after_id": afterID, "limit": limit})
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	defer rows.Close()
+
+	var result []*EventError
+	for rows.Next() {
+		e := &EventError{}
+		err := rows.Scan(&e.ID, &e.Key, &e.Body, &e.Reason, &e.Attempts, &e.FirstFailedAt, &e.LastFailedAt)
+		if err != nil {
+			return nil, classifyError(err)
+		}
+		result = append(result, e)
+	}
+	return result, classifyError(rows.Err())
+}
+
+// ResolveEventError marks a stored message as replayed successfully.
+func ResolveEventError(ctx context.Context, db *sql.DB, id int64) error {
+	_, err := execNamed(ctx, db, resolveEventError, namedArgs{"id": id, "resolved_at": time.Now()})
+	return classifyError(err)
+}
+
+// UpdateEventError records another failed attempt to replay a stored message.
+func UpdateEventError(ctx context.Context, db *sql.DB, id int64, reason string) error {
+	_, err := execNamed(ctx, db, updateEventError, namedArgs{"id": id, "error": reason, "failed_at": time.Now()})
+	return classifyError(err)
+}
+
+// PruneEventErrors removes stored messages that were replayed successfully before the given time, returning the
+// number of messages that were removed.
+func PruneEventErrors(ctx context.Context, db *sql.DB, before time.Time) (int64, error) {
+	result, err := execNamed(ctx, db, pruneEventErrors, namedArgs{"before": before})
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestRecordFailure verifies that a message whose event could not be recorded can be stored successfully.
+func TestRecordFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	r := getTestRecorder(db)
+	body := []byte(`{"path": "/foo"}`)
+
+	mock.ExpectPrepare("INSERT INTO event_errors")
+	mock.ExpectExec("INSERT INTO event_errors").
+		WithArgs(ReadKey, body, "connection refused", 3, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err := r.RecordFailure(context.Background(), ReadKey, body, "connection refused", 3); err != nil {
+		t.Fatalf("error encountered while storing failed message: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestPendingEventErrors verifies that stored messages are listed correctly.
+func TestPendingEventErrors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	now := time.Now()
+	columns := []string{"id", "routing_key", "body", "error", "attempts", "first_failed_at", "last_failed_at"}
+	mock.ExpectQuery("SELECT (.+) FROM event_errors").
+		WithArgs(int64(5), 2).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(6, ReadKey, []byte("{}"), "connection refused", 3, now, now).
+			AddRow(8, LegacyReadKey, []byte("{}"), "deadlock detected", 1, now, now))
+
+	pending, err := PendingEventErrors(context.Background(), db, 5, 2)
+	if err != nil {
+		t.Fatalf("error encountered while listing stored messages: %s", err)
+	}
+	if len(pending) != 2 || pending[0].ID != 6 || pending[1].Key != LegacyReadKey || pending[0].Attempts != 3 {
+		t.Errorf("unexpected stored messages: %+v", pending)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestReplayUpdates verifies that the outcomes of replaying stored messages are recorded.
+func TestReplayUpdates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	ctx := context.Background()
+
+	mock.ExpectExec("UPDATE event_errors SET resolved_at").
+		WithArgs(sqlmock.AnyArg(), int64(6)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE event_errors SET error").
+		WithArgs("deadlock detected", sqlmock.AnyArg(), int64(8)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := ResolveEventError(ctx, db, 6); err != nil {
+		t.Errorf("error encountered while resolving stored message: %s", err)
+	}
+	if err := UpdateEventError(ctx, db, 8, "deadlock detected"); err != nil {
+		t.Errorf("error encountered while updating stored message: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestPruneEventErrors verifies that replayed messages are removed once the retention period has elapsed.
+func TestPruneEventErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		expected  int64
+		expectErr bool
+	}{
+		{"pruned", nil, 4, false},
+		{"failed", fmt.Errorf("connection refused"), 0, true},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening stub database connection: %s", err)
+		}
+
+		before := time.Now().Add(-time.Hour)
+		exec := mock.ExpectExec("DELETE FROM event_errors").WithArgs(before)
+		if test.err != nil {
+			exec.WillReturnError(test.err)
+		} else {
+			exec.WillReturnResult(sqlmock.NewResult(0, test.expected))
+		}
+
+		pruned, err := PruneEventErrors(context.Background(), db, before)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+		}
+		if pruned != test.expected {
+			t.Errorf("%s: expected %d pruned messages but got %d", test.name, test.expected, pruned)
+		}
+	}
+}
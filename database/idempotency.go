@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// idempotencyKey returns the key that identifies the event of the given type produced by a message, or nil if the
+// message can't be identified. Events are identified by the object, time and user in the message body if they're
+// all present, so that every copy of a message produces the same key no matter how it was delivered. Otherwise, the
+// identifier assigned to the message by its publisher is used if there is one. Anonymous users don't identify the
+// events because every anonymous read is attributed to the same user, and timestamps that were replaced when the
+// message was received don't identify them because they differ for each copy of the message.
+func idempotencyKey(eventType string, msg *model.Message) *string {
+	var key string
+	switch {
+	case msg.Entity != "" && msg.Timestamp != nil && !msg.TimestampReplaced && msg.Author.String() != "" &&
+		!msg.Author.Anonymous:
+		timestamp := msg.Timestamp.ToTime().UTC().Format(time.RFC3339Nano)
+		key = fmt.Sprintf("event/%s/%s/%s/%s", eventType, msg.Entity, timestamp, msg.Author)
+	case msg.MessageID != "":
+		key = "message-id/" + msg.MessageID
+	default:
+		return nil
+	}
+	return &key
+}
+
+// hasKeys determines whether or not any of the given rows has an idempotency key. The idempotency_key column is only
+// included in inserts if one does.
+func hasKeys(rows []*eventRow) bool {
+	for _, row := range rows {
+		if row.key != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// The beginning of the query used to determine which of a set of idempotency keys belong to events that have already
+// been recorded.
+const recordedKeysPrefix = `SELECT DISTINCT idempotency_key FROM event_log WHERE idempotency_key IN (`
+
+// recordedKeysQuery returns the query used to determine which of the given idempotency keys belong to events that have
+// already been recorded, along with its arguments.
+func recordedKeysQuery(keys []string) (string, []interface{}) {
+	params := make([]string, len(keys))
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		params[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = key
+	}
+	return recordedKeysPrefix + strings.Join(params, ", ") + ")", args
+}
+
+// recordedKeys returns the set of the given idempotency keys that belong to events that have already been recorded.
+func (r DefaultRecorder) recordedKeys(ctx context.Context, keys []string) (map[string]bool, error) {
+	ctx, cancel := r.operationContext(ctx)
+	defer cancel()
+
+	recorded := make(map[string]bool)
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxEventsPerInsert {
+			n = maxEventsPerInsert
+		}
+		query, args := recordedKeysQuery(keys[:n])
+		rows, err := queryContext(ctx, r.db, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			recorded[key] = true
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		keys = keys[n:]
+	}
+	return recorded, nil
+}
+
+// dropRecordedEvents removes the rows whose idempotency keys belong to events that have already been recorded, or to
+// earlier rows, from the rows that the recorder inserts, marking their events as already recorded. The remaining rows
+// are returned. The unique index on the event log includes the time of the event, so it only catches copies of events
+// that are recorded at the same time. Events produced by messages without timestamps are recorded at the time they're
+// received, though, so each copy of the message would otherwise be recorded again.
+func (r DefaultRecorder) dropRecordedEvents(ctx context.Context, rows []*eventRow) ([]*eventRow, error) {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		if row.key != nil && !seen[*row.key] {
+			seen[*row.key] = true
+			keys = append(keys, *row.key)
+		}
+	}
+	if len(keys) == 0 {
+		return rows, nil
+	}
+	recorded, err := r.recordedKeys(ctx, keys)
+	if err != nil {
+		return rows, err
+	}
+
+	remaining := make([]*eventRow, 0, len(rows))
+	for _, row := range rows {
+		if row.key == nil || !recorded[*row.key] {
+			remaining = append(remaining, row)
+			if row.key != nil {
+				recorded[*row.key] = true
+			}
+			continue
+		}
+		markRecorded(row, false)
+		countOperation(row.event.Type, operationSkipped, 1)
+	}
+	return remaining, nil
+}
+
+// markRecorded records whether or not an event was inserted by the current attempt to record it. An event that wasn't
+// inserted because its idempotency key matches an event that was already recorded is marked as both a duplicate and
+// already recorded. The marks are reset by each attempt because a transaction that's attempted again may insert
+// events that were skipped before, or vice versa.
+func markRecorded(row *eventRow, inserted bool) {
+	row.event.Duplicate = !inserted
+	row.event.AlreadyRecorded = !inserted
+}
+
+// scanKeyedEventIDs stores the identifiers returned by a multi-row insert that includes idempotency keys in the
+// corresponding events. The insert doesn't return anything for the events that were skipped because they had already
+// been recorded, so each returned row is matched to the next event with the same key.
+func scanKeyedEventIDs(result *sql.Rows, rows []*eventRow) error {
+	defer result.Close()
+
+	i := 0
+	for result.Next() {
+		var id int64
+		var key sql.NullString
+		if err := result.Scan(&id, &key); err != nil {
+			return err
+		}
+
+		// Skip the events that weren't inserted. Events without keys are always inserted.
+		for i < len(rows) && !sameKey(rows[i].key, key) {
+			if rows[i].key == nil {
+				return fmt.Errorf("unexpected idempotency key returned: %s", key.String)
+			}
+			markRecorded(rows[i], false)
+			i++
+		}
+		if i == len(rows) {
+			return fmt.Errorf("more than %d event identifiers were returned", len(rows))
+		}
+		rows[i].event.ID = id
+		markRecorded(rows[i], true)
+		i++
+	}
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	// The remaining events weren't inserted.
+	for ; i < len(rows); i++ {
+		if rows[i].key == nil {
+			return fmt.Errorf("expected %d event identifiers to be returned", len(rows))
+		}
+		markRecorded(rows[i], false)
+	}
+	return nil
+}
+
+// sameKey determines whether or not an idempotency key returned by an insert matches the key of an event.
+func sameKey(key *string, returned sql.NullString) bool {
+	if key == nil {
+		return !returned.Valid
+	}
+	return returned.Valid && returned.String == *key
+}
+
+// SetIdempotencyKeys enables or disables idempotency keys. When they're enabled, each event is stored along with a key
+// that identifies the message that produced it, and an event whose key matches an event that has already been
+// recorded is skipped rather than being recorded again. Skipped events are returned marked as already recorded.
+// Events produced by messages that can't be identified, and events that are recorded by custom handlers, are recorded
+// as usual.
+func (r *DefaultRecorder) SetIdempotencyKeys(enabled bool) {
+	r.idempotencyKeys = enabled
+}
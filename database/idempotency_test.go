@@ -0,0 +1,270 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestIdempotencyKey verifies that events are identified by the contents of their messages when possible and by the
+// message identifiers otherwise.
+func TestIdempotencyKey(t *testing.T) {
+	ts := model.Timestamp(time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC))
+	author := &model.User{Name: "ipcdev", Zone: "iplant"}
+	anonymous := model.NewAnonymousUser(model.PublicSubject)
+	id := "fake-message"
+	tests := []struct {
+		name     string
+		msg      *model.Message
+		expected string
+	}{
+		{
+			"message contents",
+			&model.Message{Author: author, Entity: "fakeid", Timestamp: &ts, MessageID: id},
+			"event/READ/fakeid/2019-03-01T12:00:00Z/ipcdev#iplant",
+		},
+		{"no entity", &model.Message{Author: author, Timestamp: &ts, MessageID: id}, "message-id/fake-message"},
+		{"no timestamp", &model.Message{Author: author, Entity: "fakeid", MessageID: id}, "message-id/fake-message"},
+		{"no author", &model.Message{Entity: "fakeid", Timestamp: &ts, MessageID: id}, "message-id/fake-message"},
+		{
+			"anonymous author",
+			&model.Message{Author: anonymous, Entity: "fakeid", Timestamp: &ts, MessageID: id},
+			"message-id/fake-message",
+		},
+		{"unidentified", &model.Message{Entity: "fakeid", Timestamp: &ts}, ""},
+	}
+
+	for _, test := range tests {
+		key := idempotencyKey(ETRead, test.msg)
+		if test.expected == "" {
+			if key != nil {
+				t.Errorf("%s: expected no key, got %s", test.name, *key)
+			}
+			continue
+		}
+		if key == nil || *key != test.expected {
+			t.Errorf("%s: expected key %s, got %v", test.name, test.expected, key)
+		}
+	}
+}
+
+// TestRecordIdempotentEvents verifies that events that had already been recorded are skipped and marked, and that
+// they aren't included in the summary tables.
+func TestRecordIdempotentEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetIdempotencyKeys(true)
+	r.SetTrackLastAccessed(true)
+	timestamp := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	msgs := []*model.Message{
+		getTimestampedMessage(timestamp), getTimestampedMessage(timestamp), getTimestampedMessage(timestamp),
+	}
+	msgs[1].Author = &model.User{Name: "other", Zone: "iplant"}
+	msgs[2].Author = nil
+	var keys []interface{}
+	for _, msg := range msgs[:2] {
+		keys = append(keys, *idempotencyKey(ETRead, msg))
+	}
+
+	// The second event was recorded by a concurrent transaction after the keys were checked, and the third can't be
+	// identified.
+	expectCurrentVersions(mock)
+	mock.ExpectQuery("SELECT DISTINCT idempotency_key FROM event_log").
+		WithArgs(keys[0], keys[1]).
+		WillReturnRows(sqlmock.NewRows([]string{"idempotency_key"}))
+	mock.ExpectPrepare(`INSERT INTO event_log \(.*, raw_payload, idempotency_key\)`)
+	mock.ExpectBegin()
+	mock.ExpectQuery("ON CONFLICT \\(idempotency_key, date_logged\\) DO NOTHING").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "idempotency_key"}).AddRow(1, keys[0]).AddRow(3, nil))
+	mock.ExpectExec("INSERT INTO object_access").
+		WithArgs(msgs[0].Entity, timestamp).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	requests := make([]*EventRequest, len(msgs))
+	for i, msg := range msgs {
+		requests[i] = &EventRequest{Key: ReadKey, Msg: msg}
+	}
+	events, err := r.RecordEvents(context.Background(), requests)
+	if err != nil {
+		t.Fatalf("error encountered while recording events: %s", err)
+	}
+	if events[0].ID != 1 || events[0].Duplicate || events[0].AlreadyRecorded {
+		t.Errorf("unexpected first event: %+v", events[0])
+	}
+	if events[1].ID != 0 || !events[1].Duplicate || !events[1].AlreadyRecorded {
+		t.Errorf("expected the second event to be marked as already recorded: %+v", events[1])
+	}
+	if events[2].ID != 3 || events[2].Duplicate || events[2].AlreadyRecorded {
+		t.Errorf("unexpected third event: %+v", events[2])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestRedeliveredMessageID verifies that a redelivered message that's only identified by its message identifier isn't
+// recorded again, even though its event would be recorded at a different time because the message has no timestamp.
+func TestRedeliveredMessageID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetIdempotencyKeys(true)
+	msg := getTestMessage()
+	msg.Timestamp = nil
+	msg.MessageID = "fake-message"
+	key := "message-id/fake-message"
+
+	// The first copy is inserted, and the second is skipped because its key has already been recorded.
+	expectCurrentVersions(mock)
+	mock.ExpectQuery("SELECT DISTINCT idempotency_key FROM event_log").
+		WithArgs(key).
+		WillReturnRows(sqlmock.NewRows([]string{"idempotency_key"}))
+	mock.ExpectPrepare(`INSERT INTO event_log \(.*, raw_payload, idempotency_key\)`)
+	mock.ExpectBegin()
+	mock.ExpectQuery("ON CONFLICT \\(idempotency_key, date_logged\\) DO NOTHING").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "idempotency_key"}).AddRow(1, key))
+	mock.ExpectCommit()
+	expectCurrentVersions(mock)
+	mock.ExpectQuery("SELECT DISTINCT idempotency_key FROM event_log").
+		WithArgs(key).
+		WillReturnRows(sqlmock.NewRows([]string{"idempotency_key"}).AddRow(key))
+
+	first, err := r.RecordEvent(context.Background(), ReadKey, msg)
+	if err != nil {
+		t.Fatalf("error encountered while recording the first copy: %s", err)
+	}
+	second, err := r.RecordEvent(context.Background(), ReadKey, msg)
+	if err != nil {
+		t.Fatalf("error encountered while recording the second copy: %s", err)
+	}
+	if first.ID != 1 || first.AlreadyRecorded {
+		t.Errorf("unexpected first event: %+v", first)
+	}
+	if second.ID != 0 || !second.Duplicate || !second.AlreadyRecorded {
+		t.Errorf("expected the second event to be marked as already recorded: %+v", second)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestReplacedTimestampKey verifies that a timestamp that was replaced when the message was received doesn't identify
+// the event, since it differs for each copy of the message.
+func TestReplacedTimestampKey(t *testing.T) {
+	msg := getTestMessage()
+	msg.MessageID = "fake-message"
+	msg.TimestampReplaced = true
+	if key := idempotencyKey(ETRead, msg); key == nil || *key != "message-id/fake-message" {
+		t.Errorf("expected the event to be identified by its message identifier, got %v", key)
+	}
+}
+
+// TestScanKeyedEventIDs verifies that identifiers that can't be matched to the events that were inserted are reported
+// as errors.
+func TestScanKeyedEventIDs(t *testing.T) {
+	key := "fakekey"
+	tests := []struct {
+		name     string
+		returned *sqlmock.Rows
+	}{
+		{"too many", sqlmock.NewRows([]string{"id", "key"}).AddRow(1, key).AddRow(2, nil).AddRow(3, nil)},
+		{"missing unkeyed event", sqlmock.NewRows([]string{"id", "key"}).AddRow(1, key)},
+		{"unexpected key", sqlmock.NewRows([]string{"id", "key"}).AddRow(1, "otherkey").AddRow(2, nil)},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening stub database connection: %s", err)
+		}
+		mock.ExpectQuery("INSERT INTO event_log").WillReturnRows(test.returned)
+		result, err := db.Query("INSERT INTO event_log")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.name, err)
+		}
+
+		rows := []*eventRow{{event: &Event{}, key: &key}, {event: &Event{}}}
+		if err := scanKeyedEventIDs(result, rows); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		db.Close()
+	}
+}
+
+// TestIdempotencyKeysDrivers verifies that an event is recorded only once in a real database with each of the
+// supported drivers, no matter how many times it's recorded.
+func TestIdempotencyKeysDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		r.SetIdempotencyKeys(true)
+		ctx := context.Background()
+
+		msg := getTestMessage()
+		first, err := r.RecordEvent(ctx, ReadKey, msg)
+		if err != nil {
+			t.Fatalf("%s: error encountered while recording event: %s", driver, err)
+		}
+		events, err := r.RecordEvents(ctx, []*EventRequest{{Key: ReadKey, Msg: msg}, {Key: LegacyReadKey, Msg: msg}})
+		if err != nil {
+			t.Fatalf("%s: error encountered while recording events: %s", driver, err)
+		}
+		if first.AlreadyRecorded || first.ID == 0 {
+			t.Errorf("%s: unexpected first event: %+v", driver, first)
+		}
+		for i, event := range events {
+			if !event.AlreadyRecorded {
+				t.Errorf("%s: expected event %d to be marked as already recorded: %+v", driver, i, event)
+			}
+		}
+
+		var count int
+		if err := db.QueryRow("SELECT count(*) FROM event_log").Scan(&count); err != nil {
+			t.Fatalf("%s: unable to count events: %s", driver, err)
+		}
+		if count != 1 {
+			t.Errorf("%s: expected 1 event to be recorded, got %d", driver, count)
+		}
+		db.Close()
+	}
+}
+
+// TestRedeliveredMessageIDDrivers verifies that a message without a timestamp that's only identified by its message
+// identifier is recorded only once in a real database with each of the supported drivers, no matter how many times
+// it's delivered.
+func TestRedeliveredMessageIDDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		r.SetIdempotencyKeys(true)
+		msg := getTestMessage()
+		msg.Timestamp = nil
+		msg.MessageID = "fake-message"
+
+		for i := 0; i < 3; i++ {
+			if _, err := r.RecordEvent(context.Background(), ReadKey, msg); err != nil {
+				t.Fatalf("%s: error encountered while recording copy %d: %s", driver, i, err)
+			}
+		}
+
+		var count int
+		if err := db.QueryRow("SELECT count(*) FROM event_log").Scan(&count); err != nil {
+			t.Fatalf("%s: unable to count events: %s", driver, err)
+		}
+		if count != 1 {
+			t.Errorf("%s: expected 1 event to be recorded, got %d", driver, count)
+		}
+		db.Close()
+	}
+}
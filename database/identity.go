@@ -0,0 +1,176 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// The expression that extracts the UUID from the permanent identifier of a data object, which is null if the
+// identifier isn't a UUID. The identifier is only cast if it's in one of the forms that model.ParseUUID accepts, so
+// that identifiers that aren't UUIDs don't cause errors.
+const parsedEntityUUID = `
+CASE
+    WHEN permanent_id ~* '^\{?[0-9a-f]{8}(-?[0-9a-f]{4}){3}-?[0-9a-f]{12}\}?$'
+        AND (left(permanent_id, 1) = '{') = (right(permanent_id, 1) = '}')
+        THEN permanent_id::uuid
+END
+`
+
+// The expression that determines the permanent identifier under which a data object in a message is registered: the
+// identifier of the object that's already registered with the same UUID if there is one, and the entity identifier in
+// the message otherwise.
+const registeredPermanentID = `coalesce(
+        (SELECT permanent_id FROM data_objects WHERE entity_uuid = :entity_uuid::uuid), :permanent_id
+    )`
+
+// The statement used to update the stored path of a data object that's identified by its UUID in a message that names
+// a different path, which happens when a move wasn't recorded. The path isn't updated if the object was updated more
+// recently than the message or if the object is archived.
+var updateDataObjectPath = named(`
+UPDATE data_objects SET
+    irods_path = :irods_path,
+    updated_at = :changed_at
+WHERE entity_uuid = :entity_uuid::uuid
+AND irods_path <> :irods_path
+AND NOT archived
+AND updated_at < :changed_at;
+`)
+
+// The statement used to assign each UUID that's found in the permanent identifiers of data objects to one of the
+// objects with that UUID if none of them has it yet. Among duplicates, the UUID is assigned to the object that's in the
+// repository and was updated most recently.
+var assignEntityUUIDs = named(`
+UPDATE data_objects SET entity_uuid = keepers.entity_uuid
+FROM (
+    SELECT DISTINCT ON (parsed.entity_uuid) parsed.permanent_id, parsed.entity_uuid
+    FROM (
+        SELECT permanent_id, archived, updated_at, ` + parsedEntityUUID + ` AS entity_uuid
+        FROM data_objects
+        WHERE entity_uuid IS NULL
+    ) parsed
+    WHERE parsed.entity_uuid IS NOT NULL
+    AND NOT EXISTS (SELECT 1 FROM data_objects d WHERE d.entity_uuid = parsed.entity_uuid)
+    ORDER BY parsed.entity_uuid, parsed.archived, parsed.updated_at DESC, parsed.permanent_id
+) keepers
+WHERE data_objects.permanent_id = keepers.permanent_id;
+`)
+
+// The query that lists the duplicate data objects, which are the objects whose permanent identifiers contain a UUID
+// that's assigned to another object, along with the permanent identifier of the object with the UUID.
+const duplicateDataObjects = `
+SELECT duplicates.permanent_id, keepers.permanent_id AS keeper_id
+FROM (
+    SELECT permanent_id, ` + parsedEntityUUID + ` AS entity_uuid
+    FROM data_objects
+    WHERE entity_uuid IS NULL
+) duplicates
+JOIN data_objects keepers ON keepers.entity_uuid = duplicates.entity_uuid
+`
+
+// The statement used to count the duplicate data objects.
+var countDuplicateDataObjects = named(`
+SELECT count(*) FROM (` + duplicateDataObjects + `) duplicates;
+`)
+
+// The statement used to copy the access policies of duplicate data objects to the objects that they duplicate. The
+// most recent policy for each subject is kept.
+var mergeDuplicateAccessPolicies = named(`
+INSERT INTO access_policies (permanent_id, subject, permission, updated_at)
+SELECT DISTINCT ON (duplicates.keeper_id, p.subject) duplicates.keeper_id, p.subject, p.permission, p.updated_at
+FROM access_policies p
+JOIN (` + duplicateDataObjects + `) duplicates ON duplicates.permanent_id = p.permanent_id
+ORDER BY duplicates.keeper_id, p.subject, p.updated_at DESC
+ON CONFLICT (permanent_id, subject) DO UPDATE SET
+    permission = excluded.permission,
+    updated_at = excluded.updated_at
+WHERE access_policies.updated_at < excluded.updated_at;
+`)
+
+// The statement used to flag the data objects that have duplicates so that their system metadata is synchronized again
+// after the duplicates are merged into them.
+var flagMergedDataObjects = named(`
+UPDATE data_objects SET needs_resync = true
+WHERE permanent_id IN (SELECT keeper_id FROM (` + duplicateDataObjects + `) duplicates);
+`)
+
+// The statement used to remove the access policies of duplicate data objects.
+var deleteDuplicateAccessPolicies = named(`
+DELETE FROM access_policies
+WHERE permanent_id IN (SELECT permanent_id FROM (` + duplicateDataObjects + `) duplicates);
+`)
+
+// The statement used to remove duplicate data objects.
+var deleteDuplicateDataObjects = named(`
+DELETE FROM data_objects
+WHERE permanent_id IN (SELECT permanent_id FROM (` + duplicateDataObjects + `) duplicates);
+`)
+
+// uuidArg returns the argument used to pass a UUID to a statement, which is null if the UUID is empty.
+func uuidArg(uuid string) *string {
+	if uuid == "" {
+		return nil
+	}
+	return &uuid
+}
+
+// syncPath updates the stored path of the data object with the given UUID if the object is registered under a
+// different path. Nothing is updated if the UUID or the path is empty.
+func syncPath(ctx context.Context, q queryer, uuid, path string, changedAt time.Time) error {
+	if uuid == "" || path == "" {
+		return nil
+	}
+	values := namedArgs{"entity_uuid": uuid, "irods_path": path, "changed_at": changedAt}
+	_, err := execNamed(ctx, q, updateDataObjectPath, values)
+	return err
+}
+
+// CountDuplicateObjects returns the number of registered data objects that duplicate other registered objects with the
+// same UUID, which MergeDuplicateObjects would remove.
+func CountDuplicateObjects(ctx context.Context, db *sql.DB) (int64, error) {
+	rows, err := queryNamed(ctx, db, countDuplicateDataObjects, namedArgs{})
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	defer rows.Close()
+
+	var count int64
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return 0, classifyError(err)
+		}
+	}
+	return count, classifyError(rows.Err())
+}
+
+// MergeDuplicateObjects merges registered data objects whose permanent identifiers contain the same UUID, which were
+// registered separately before objects were identified by UUID. Each UUID is assigned to one of the objects with it,
+// and the access policies of the other objects are copied to that object before the other objects are removed. The
+// objects that remain are flagged so that their system metadata is synchronized again. Events in the event log keep
+// the identifiers under which they were recorded. Everything is done in a single transaction, and the number of
+// objects that were removed is returned.
+func MergeDuplicateObjects(ctx context.Context, db *sql.DB) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	defer tx.Rollback()
+
+	statements := []*namedStatement{
+		assignEntityUUIDs, mergeDuplicateAccessPolicies, flagMergedDataObjects, deleteDuplicateAccessPolicies,
+	}
+	for _, statement := range statements {
+		if _, err := execNamed(ctx, tx, statement, namedArgs{}); err != nil {
+			return 0, classifyError(err)
+		}
+	}
+	result, err := execNamed(ctx, tx, deleteDuplicateDataObjects, namedArgs{})
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	return removed, classifyError(tx.Commit())
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestCountDuplicateObjects verifies that the recorder counts the duplicate data objects.
+func TestCountDuplicateObjects(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	count, err := CountDuplicateObjects(context.Background(), db)
+	if err != nil {
+		t.Errorf("error encountered while counting duplicate data objects: %s", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 duplicate data objects but got %d", count)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestMergeDuplicateObjects verifies that duplicate data objects are merged in a single transaction, and that the
+// number of objects that were removed is returned.
+func TestMergeDuplicateObjects(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE data_objects SET entity_uuid").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO access_policies").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE data_objects SET needs_resync").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM access_policies").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM data_objects").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	removed, err := MergeDuplicateObjects(context.Background(), db)
+	if err != nil {
+		t.Errorf("error encountered while merging duplicate data objects: %s", err)
+	}
+	if removed != 2 {
+		t.Errorf("expected 2 data objects to be removed but got %d", removed)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestIdentityDrivers verifies that data objects are identified by UUID with each of the supported drivers: a message
+// that writes the UUID differently and names a different path updates the stored path of the registered object, and
+// duplicate objects with the same UUID are merged into one.
+func TestIdentityDrivers(t *testing.T) {
+	const (
+		registered = "F3579BF9-284B-4B3C-841B-F6E87D3F78EA"
+		duplicate  = "f3579bf9284b4b3c841bf6e87d3f78ea"
+		renamed    = "/iplant/home/shared/commons-repo/curated/renamed.txt"
+	)
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := getTestRecorder(db)
+		ctx := context.Background()
+
+		// Register the object, and then change its metadata at a different path using a different form of the UUID.
+		added := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+		if _, err := r.RecordEvent(ctx, AddKey, getAddMessage(added, 1024, "sha2:checksum")); err != nil {
+			t.Fatalf("%s: error encountered while recording addition: %s", driver, err)
+		}
+		msg := getTimestampedMessage(added.Add(time.Hour))
+		msg.Entity, msg.Path = duplicate, renamed
+		if _, err := r.RecordEvent(ctx, "data-object.metadata.mod", msg); err != nil {
+			t.Fatalf("%s: error encountered while recording metadata change: %s", driver, err)
+		}
+		var path string
+		query := "SELECT irods_path FROM data_objects WHERE permanent_id = $1"
+		if err := db.QueryRow(query, registered).Scan(&path); err != nil {
+			t.Errorf("%s: unable to look up the data object: %s", driver, err)
+		} else if path != renamed {
+			t.Errorf("%s: expected the data object to be at %s but it's at %s", driver, renamed, path)
+		}
+
+		// Register a duplicate the way that it would have been registered before objects were identified by UUID.
+		statements := []string{
+			"INSERT INTO data_objects (permanent_id, irods_path, node_identifier, updated_at)" +
+				" VALUES ('" + duplicate + "', '" + renamed + "', 'fakenode', now())",
+			"INSERT INTO access_policies (permanent_id, subject, permission, updated_at)" +
+				" VALUES ('" + duplicate + "', 'ipcdev#iplant', 'own', now())",
+		}
+		for _, statement := range statements {
+			if _, err := db.Exec(statement); err != nil {
+				t.Fatalf("%s: unable to register the duplicate data object: %s", driver, err)
+			}
+		}
+		if count, err := CountDuplicateObjects(ctx, db); err != nil || count != 1 {
+			t.Errorf("%s: expected 1 duplicate data object but got %d (%v)", driver, count, err)
+		}
+		if removed, err := MergeDuplicateObjects(ctx, db); err != nil || removed != 1 {
+			t.Errorf("%s: expected 1 duplicate data object to be removed but got %d (%v)", driver, removed, err)
+		}
+
+		var permanentID, permission string
+		query = "SELECT permanent_id, permission FROM access_policies WHERE subject = 'ipcdev#iplant'"
+		if err := db.QueryRow(query).Scan(&permanentID, &permission); err != nil {
+			t.Errorf("%s: unable to look up the merged access policy: %s", driver, err)
+		} else if permanentID != registered || permission != "own" {
+			t.Errorf("%s: unexpected access policy: %s for %s", driver, permission, permanentID)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"strings"
+)
+
+// wordsOf splits an AMQP routing key or routing key pattern into its dot-separated words.
+func wordsOf(key string) []string {
+	if key == "" {
+		return []string{}
+	}
+	return strings.Split(key, ".")
+}
+
+// matchRoutingKey determines whether or not a routing key matches a routing key pattern. Patterns use the same syntax
+// as AMQP topic exchange bindings: an asterisk matches exactly one word, and a hash matches zero or more words.
+func matchRoutingKey(pattern, key string) bool {
+	return matchWords(wordsOf(pattern), wordsOf(key))
+}
+
+// MatchRoutingKey determines whether or not a routing key matches a routing key pattern, using the same syntax as the
+// patterns in a HandlerMap.
+func MatchRoutingKey(pattern, key string) bool {
+	return matchRoutingKey(pattern, key)
+}
+
+// matchWords determines whether or not the words of a routing key match the words of a routing key pattern.
+func matchWords(pattern, key []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+
+			// A hash may consume any number of words, so try each possibility.
+			for i := 0; i <= len(key); i++ {
+				if matchWords(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case "*":
+			if len(key) == 0 {
+				return false
+			}
+
+		default:
+			if len(key) == 0 || key[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return len(key) == 0
+}
+
+// specificity describes how specific a routing key pattern is. Patterns with more literal words are more specific.
+// Among patterns with the same number of literal words, single-word wildcards are more specific than hashes.
+type specificity struct {
+	literals int
+	hashes   int
+	stars    int
+}
+
+// patternSpecificity returns the specificity of a routing key pattern.
+func patternSpecificity(pattern string) specificity {
+	var s specificity
+	for _, word := range wordsOf(pattern) {
+		switch word {
+		case "#":
+			s.hashes++
+		case "*":
+			s.stars++
+		default:
+			s.literals++
+		}
+	}
+	return s
+}
+
+// moreSpecific determines whether or not the routing key pattern a is more specific than the routing key pattern b.
+// Ties are broken by comparing the patterns themselves so that the choice doesn't depend on map iteration order.
+func moreSpecific(a, b string) bool {
+	sa, sb := patternSpecificity(a), patternSpecificity(b)
+	switch {
+	case sa.literals != sb.literals:
+		return sa.literals > sb.literals
+	case sa.hashes != sb.hashes:
+		return sa.hashes < sb.hashes
+	case sa.stars != sb.stars:
+		return sa.stars < sb.stars
+	default:
+		return a < b
+	}
+}
+
+// Find returns the handler for a routing key, or nil if there isn't one. The keys in a handler map may be routing key
+// patterns. A handler registered for the exact routing key is preferred. Otherwise, the handler for the most specific
+// matching pattern is used.
+func (h HandlerMap) Find(key string) HandlerFunction {
+	_, f := h.find(key)
+	return f
+}
+
+// find returns the pattern that was used to find the handler for a routing key, along with the handler itself.
+func (h HandlerMap) find(key string) (string, HandlerFunction) {
+	if f := h[key]; f != nil {
+		return key, f
+	}
+
+	// Find the most specific matching pattern.
+	best := ""
+	var handler HandlerFunction
+	for pattern, f := range h {
+		if matchRoutingKey(pattern, key) && (handler == nil || moreSpecific(pattern, best)) {
+			best, handler = pattern, f
+		}
+	}
+	return best, handler
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// TestMatchRoutingKey verifies that routing keys are matched against patterns using AMQP topic semantics.
+func TestMatchRoutingKey(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		key      string
+		expected bool
+	}{
+		{"data-object.open", "data-object.open", true},
+		{"data-object.open", "data-object.open.webdav", false},
+		{"data-object.*", "data-object.open", true},
+		{"data-object.*", "data-object.open.webdav", false},
+		{"data-object.open.*", "data-object.open", false},
+		{"data-object.open.#", "data-object.open", true},
+		{"data-object.open.#", "data-object.open.webdav", true},
+		{"data-object.open.#", "data-object.open.webdav.anonymous", true},
+		{"data-object.#.webdav", "data-object.open.webdav", true},
+		{"data-object.#.webdav", "data-object.webdav", true},
+		{"data-object.#.webdav", "data-object.open.irods", false},
+		{"#", "data-object.open", true},
+		{"*.open", "data-object.open", true},
+		{"*.open", "data-object.add", false},
+	}
+
+	for _, test := range tests {
+		if actual := matchRoutingKey(test.pattern, test.key); actual != test.expected {
+			t.Errorf("%s matches %s: expected %t, got %t", test.pattern, test.key, test.expected, actual)
+		}
+	}
+}
+
+// namedHandler returns a handler function that records its name when it's called.
+func namedHandler(name string, called *string) HandlerFunction {
+	return func(context.Context, *sql.Tx, Recorder, string, *model.Message) (*Event, error) {
+		*called = name
+		return nil, nil
+	}
+}
+
+// TestFindOverlappingPatterns verifies that the most specific matching pattern is chosen when several patterns match
+// the same routing key.
+func TestFindOverlappingPatterns(t *testing.T) {
+	var called string
+	handlers := HandlerMap{
+		"#":                       namedHandler("everything", &called),
+		"data-object.#":           namedHandler("data objects", &called),
+		"data-object.*":           namedHandler("one word", &called),
+		"data-object.open.#":      namedHandler("opens", &called),
+		"data-object.open.webdav": namedHandler("webdav", &called),
+		"data-object.*.webdav":    namedHandler("any webdav", &called),
+	}
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"data-object.open.webdav", "webdav"},
+		{"data-object.add.webdav", "any webdav"},
+		{"data-object.open.irods", "opens"},
+		{"data-object.open", "opens"},
+		{"data-object.add", "one word"},
+		{"data-object.add.irods", "data objects"},
+		{"collection.add", "everything"},
+	}
+
+	for _, test := range tests {
+		called = ""
+		f := handlers.Find(test.key)
+		if f == nil {
+			t.Errorf("%s: no handler found", test.key)
+			continue
+		}
+		f(context.Background(), nil, nil, test.key, nil)
+		if called != test.expected {
+			t.Errorf("%s: expected the %s handler, got the %s handler", test.key, test.expected, called)
+		}
+	}
+}
+
+// TestFindUnmatchedKey verifies that no handler is found for a routing key that doesn't match any pattern.
+func TestFindUnmatchedKey(t *testing.T) {
+	var called string
+	handlers := HandlerMap{"data-object.open.#": namedHandler("opens", &called)}
+	if f := handlers.Find("data-object.add"); f != nil {
+		t.Error("expected no handler to be found")
+	}
+}
+
+// TestDispatchUnmatchedKey verifies that a message whose routing key doesn't match any rule is ignored rather than
+// treated as an error.
+func TestDispatchUnmatchedKey(t *testing.T) {
+	r := newMockRecorder()
+	event, err := dispatchMessage(context.Background(), nil, r, "data-object.add", &model.Message{})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if event != nil {
+		t.Errorf("unexpected event: %+v", event)
+	}
+}
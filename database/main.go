@@ -1,79 +1,384 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/cyverse-de/dataone-indexer/model"
 )
 
-// HandlerFunction represents a function used to handle an incoming message.
-type HandlerFunction func(Recorder, string, *model.Message) error
+// RecorderVersion identifies the revision of the Recorder interface. It's incremented whenever the interface changes
+// in a way that isn't backward compatible so that implementations outside of this package can be checked against it.
+// Version 2 added a context argument to every method that accesses the database. Version 3 added RecordFailure.
+const RecorderVersion = 3
 
-// HandlerMap represents a map from AMQP routing key to message handler function.
+// HandlerFunction represents a function used to handle an incoming message. The function returns the event that was
+// recorded, if any. The context bounds the amount of time that the handler may spend recording the event, and the
+// transaction is the one in which the event is recorded.
+type HandlerFunction func(context.Context, *sql.Tx, Recorder, string, *model.Message) (*Event, error)
+
+// HandlerMap represents a map from AMQP routing key pattern to message handler function.
 type HandlerMap map[string]HandlerFunction
 
+// Event describes a DataONE event that has been recorded in the database. An event that was suppressed because it
+// duplicates a recent event is marked as a duplicate; it isn't stored in the database, so it has no identifier. An
+// event that was skipped because its idempotency key matches an event that had already been recorded is marked as
+// both a duplicate and already recorded. A read that was skipped because its data object is archived is marked as
+// archived; it isn't stored either.
+type Event struct {
+	ID              int64
+	Type            string
+	Path            string
+	NodeID          string
+	Timestamp       *time.Time
+	Duplicate       bool
+	AlreadyRecorded bool
+	Archived        bool
+}
+
+// EventRequest describes a single event to record as part of a batch.
+type EventRequest struct {
+	Key string
+	Msg *model.Message
+}
+
 // Recorder is an interface for recording DataONE events.
 type Recorder interface {
-	RecordEvent(key string, msg *model.Message) error
+	RecordEvent(ctx context.Context, key string, msg *model.Message) (*Event, error)
+	RecordEvents(ctx context.Context, requests []*EventRequest) ([]*Event, error)
+	RecordQuarantine(ctx context.Context, key string, body []byte, reason string) error
+	RecordFailure(ctx context.Context, key string, body []byte, reason string, attempts int) error
 	GetHandlerMap() *HandlerMap
 	GetNodeID() string
 	GetDb() *sql.DB
 }
 
 // Dispatches a message for an arbitrary recorder. The primary reason this task is split into a separate function
-// is to test the dipatch mechanism independently.
-func dispatchMessage(r Recorder, key string, msg *model.Message) error {
-	if f := (*r.GetHandlerMap())[key]; f != nil {
-		return f(r, key, msg)
+// is to test the dipatch mechanism independently. A nil event is returned if there's no handler for the routing key.
+func dispatchMessage(ctx context.Context, tx *sql.Tx, r Recorder, key string, msg *model.Message) (*Event, error) {
+	if f := r.GetHandlerMap().Find(key); f != nil {
+		return f(ctx, tx, r, key, msg)
 	}
-	return nil
+	return nil, nil
 }
 
-// DefaultRecorder is an implementation of the Recorder interface that stores DataONE events in a database.
+// DefaultRecorder is an implementation of the Recorder interface that stores DataONE events in a database. A
+// DefaultRecorder is safe for concurrent use by multiple goroutines: its handler map is never modified after it's
+// created, and each event or batch of events is recorded in its own transaction obtained from the connection pool.
 type DefaultRecorder struct {
-	db       *sql.DB
-	handlers *HandlerMap
-	nodeID   string
+	db          *sql.DB
+	handlers    *HandlerMap
+	eventTypes  map[string]string
+	previewKeys map[string]bool
+	nodeID      string
+	statements  *statementCache
+	isolation   sql.IsolationLevel
+	opTimeout   time.Duration
+	recent      *recentEvents
+	pgx         bool
+
+	rawPayloads       bool
+	rawPayloadMaxSize int
+	createPartitions  bool
+	retry             RetryPolicy
+	rollups           bool
+	lastAccessed      bool
+	idempotencyKeys   bool
+	messageDetails    bool
+	previewEventType  string
+	rightsHolder      string
+	authoritativeNode string
+	skipArchivedReads bool
+	resurrectArchived bool
+
+	collections         map[string]string
+	collectionBatchSize int
 }
 
-// KeyNames represents a mapping from DataONE event type to AMQP routing keys.
+// statementPreparer is implemented by recorders that reuse prepared statements.
+type statementPreparer interface {
+	preparedStatements() *statementCache
+}
+
+// statementsFor returns the statement cache used by a recorder, or nil if the recorder doesn't reuse prepared
+// statements.
+func statementsFor(r Recorder) *statementCache {
+	if p, ok := r.(statementPreparer); ok {
+		return p.preparedStatements()
+	}
+	return nil
+}
+
+// KeyNames represents a mapping from DataONE event type to AMQP routing keys. Each event type may be associated with
+// several routing keys so that events published to different exchanges can be recorded. The routing keys may be
+// patterns that use AMQP topic wildcards, in which case the most specific matching pattern determines how a message is
+// handled. Routing keys that are bound to the queue but have no corresponding handler are ignored by the recorder.
 type KeyNames struct {
-	Read string
+	Read       []string
+	Preview    []string
+	Add        []string
+	Move       []string
+	Delete     []string
+	Metadata   []string
+	Permission []string
+	Modify     []string
+	Replicate  []string
+
+	Synchronization []string
+
+	CollectionMove   []string
+	CollectionDelete []string
 }
 
-// recordReadEvent is the function that DefaultRecorder uses to record file accesses.
-func recordReadEvent(r Recorder, key string, msg *model.Message) error {
+// newEvent returns the event of the given type that should be recorded for a message. The event is recorded under the
+// node identifier in the message if there is one, and under the recorder's node identifier otherwise. It occurred at
+// the time in the message, or at the current time if the message doesn't include one.
+func newEvent(r Recorder, eventType string, msg *model.Message) *Event {
+	return &Event{
+		Type:      eventType,
+		Path:      msg.Path,
+		NodeID:    messageNodeID(r, msg),
+		Timestamp: messageTime(msg),
+	}
+}
 
-	// Begin a transaction.
-	tx, err := r.GetDb().Begin()
-	if err != nil {
-		return err
+// messageNodeID returns the identifier of the member node under which the event produced by a message is recorded.
+func messageNodeID(r Recorder, msg *model.Message) string {
+	if msg.NodeID != "" {
+		return msg.NodeID
 	}
+	return r.GetNodeID()
+}
 
-	// Insert the row into the database.
-	_, err = tx.Exec(addEvent, msg.Entity, msg.Path, ETRead, msg.Timestamp.ToTime(), r.GetNodeID())
-	if err != nil {
-		tx.Rollback()
-		return err
+// messageTime returns the time at which the event produced by a message occurred, which is the time in the message or
+// the current time if the message doesn't include one.
+func messageTime(msg *model.Message) *time.Time {
+	if timestamp := msg.Timestamp.ToTime(); timestamp != nil {
+		return timestamp
+	}
+	now := time.Now()
+	return &now
+}
+
+// maxEventsPerInsert is the maximum number of events to insert with a single statement. This keeps the number of
+// statement parameters well below the limit imposed by PostgreSQL.
+const maxEventsPerInsert = 1000
+
+// eventRow describes a row to insert into the event log. The payload is nil if the message that produced the event
+// isn't stored, the key is nil if the event has no idempotency key, and the details are nil if the details of the
+// message aren't stored.
+type eventRow struct {
+	entity  string
+	event   *Event
+	payload *string
+	key     *string
+	details *eventDetails
+}
+
+// hasPayloads determines whether or not any of the given rows includes the message that produced its event. The
+// raw_payload column is only included in inserts if it does, so that inserts work the same way that they always have
+// when payloads aren't stored.
+func hasPayloads(rows []*eventRow) bool {
+	for _, row := range rows {
+		if row.payload != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// insertChunks splits rows to be inserted into the event log into groups that can each be inserted by one statement.
+func insertChunks(rows []*eventRow) [][]*eventRow {
+	var chunks [][]*eventRow
+	for len(rows) > maxEventsPerInsert {
+		chunks = append(chunks, rows[:maxEventsPerInsert])
+		rows = rows[maxEventsPerInsert:]
 	}
+	if len(rows) > 0 {
+		chunks = append(chunks, rows)
+	}
+	return chunks
+}
 
-	// Commit the transaction.
-	return tx.Commit()
+// insertQuery returns the statement used to insert the given number of rows into the event log, optionally including
+// the raw_payload column. Both the raw_payload and idempotency_key columns are included if the rows have keys, and
+// those columns are included along with the message detail columns if the rows have details.
+func insertQuery(n int, payloads, keys, details bool) string {
+	prefix, suffix, columns := addEventsPrefix, addEventsSuffix, 5
+	switch {
+	case details:
+		prefix, suffix, columns = addDetailedEventsPrefix, addKeyedEventsSuffix, 16
+	case keys:
+		prefix, suffix, columns = addKeyedEventsPrefix, addKeyedEventsSuffix, 7
+	case payloads:
+		prefix, columns = addEventsWithPayloadsPrefix, 6
+	}
+	values := make([]string, n)
+	params := make([]string, columns)
+	for i := range values {
+		for j := range params {
+			params[j] = fmt.Sprintf("$%d", i*columns+j+1)
+		}
+		values[i] = "(" + strings.Join(params, ", ") + ")"
+	}
+	return prefix + strings.Join(values, ", ") + suffix
 }
 
-// buildHandlerMap builds a map from AMQP routing key to handler functions.
-func buildHandlerMap(keyNames *KeyNames) *HandlerMap {
-	return &HandlerMap{
-		keyNames.Read: recordReadEvent,
+// prepareInserts prepares the statements used to insert rows into the event log. Preparing the statements before a
+// transaction begins allows them to be prepared on the connection that the transaction will use, which is usually
+// the most recently used connection in the pool.
+func prepareInserts(ctx context.Context, statements *statementCache, rows []*eventRow) error {
+	if statements == nil {
+		return nil
+	}
+	for _, chunk := range insertChunks(rows) {
+		query := insertQuery(len(chunk), hasPayloads(chunk), hasKeys(chunk), hasDetails(chunk))
+		if _, err := statements.prepared(ctx, query); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-// NewRecorder creates and returns a new DefaultRecorder object.
+// insertEvents inserts rows into the event log using as few statements as possible. The identifier of each new row is
+// stored in the corresponding event, and events that were skipped because they had already been recorded are marked.
+// The statements are prepared using the given cache, which may be nil.
+func insertEvents(ctx context.Context, tx *sql.Tx, statements *statementCache, rows []*eventRow) error {
+	for _, chunk := range insertChunks(rows) {
+		payloads, keys, details := hasPayloads(chunk), hasKeys(chunk), hasDetails(chunk)
+		args := make([]interface{}, 0, len(chunk)*16)
+		for _, row := range chunk {
+			e := row.event
+			args = append(args, row.entity, e.Path, e.Type, e.Timestamp, e.NodeID)
+			if payloads || keys || details {
+				args = append(args, row.payload)
+			}
+			if keys || details {
+				args = append(args, row.key)
+			}
+			if details {
+				args = append(args, row.details.values()...)
+			}
+		}
+
+		// Insert the rows and record their identifiers.
+		result, err := statements.query(ctx, tx, insertQuery(len(chunk), payloads, keys, details), args...)
+		if err != nil {
+			return err
+		}
+		scan := scanEventIDs
+		if keys || details {
+			scan = scanKeyedEventIDs
+		}
+		if err := scan(result, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanEventIDs stores the identifiers returned by a multi-row insert in the corresponding events.
+func scanEventIDs(result *sql.Rows, rows []*eventRow) error {
+	defer result.Close()
+
+	for _, row := range rows {
+		if !result.Next() {
+			if err := result.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("expected %d event identifiers to be returned", len(rows))
+		}
+		if err := result.Scan(&row.event.ID); err != nil {
+			return err
+		}
+	}
+	return result.Err()
+}
+
+// recordReadEvent is the function that DefaultRecorder uses to record file accesses.
+func recordReadEvent(ctx context.Context, tx *sql.Tx, r Recorder, key string, msg *model.Message) (*Event, error) {
+	event := newEvent(r, readEventType(r, msg), msg)
+	rows := []*eventRow{{entity: msg.Entity, event: event}}
+	if err := insertEvents(ctx, tx, statementsFor(r), rows); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// buildHandlerMap builds a map from AMQP routing key to handler functions, along with a map from AMQP routing key to
+// the type of event recorded by the handler. The second map allows events from several messages to be inserted with
+// a single statement.
+func buildHandlerMap(keyNames *KeyNames) (*HandlerMap, map[string]string) {
+	handlers := HandlerMap{}
+	eventTypes := make(map[string]string)
+	for _, key := range keyNames.Read {
+		handlers[key] = recordReadEvent
+		eventTypes[key] = ETRead
+	}
+	for _, key := range keyNames.Preview {
+		handlers[key] = recordPreviewEvent
+	}
+	for _, key := range keyNames.Add {
+		handlers[key] = recordAdd
+	}
+	for _, key := range keyNames.Move {
+		handlers[key] = recordMove
+	}
+	for _, key := range keyNames.Delete {
+		handlers[key] = recordDelete
+	}
+	for _, key := range keyNames.Metadata {
+		handlers[key] = recordMetadataChange
+	}
+	for _, key := range keyNames.Permission {
+		handlers[key] = recordPermissionChange
+	}
+	for _, key := range keyNames.Modify {
+		handlers[key] = recordChecksumChange
+	}
+	for _, key := range keyNames.Replicate {
+		handlers[key] = recordReplication
+	}
+	for _, key := range keyNames.Synchronization {
+		handlers[key] = recordSynchronization
+	}
+	for key, kind := range buildCollectionMap(keyNames) {
+		handlers[key] = collectionHandler(kind)
+	}
+	return &handlers, eventTypes
+}
+
+// buildCollectionMap builds a map from AMQP routing key to the kind of collection message published with that key.
+// DefaultRecorder applies collection messages itself rather than calling their handlers, so that it can update the
+// objects in large collections in several transactions.
+func buildCollectionMap(keyNames *KeyNames) map[string]string {
+	collections := make(map[string]string)
+	for _, key := range keyNames.CollectionMove {
+		collections[key] = collectionMove
+	}
+	for _, key := range keyNames.CollectionDelete {
+		collections[key] = collectionRemoval
+	}
+	return collections
+}
+
+// NewRecorder creates and returns a new DefaultRecorder object. If the database connection pool was opened with the
+// pgx driver, the recorder uses the pgx batch API to insert events.
 func NewRecorder(db *sql.DB, keyNames *KeyNames, nodeID string) *DefaultRecorder {
+	handlers, eventTypes := buildHandlerMap(keyNames)
 	return &DefaultRecorder{
-		db:       db,
-		handlers: buildHandlerMap(keyNames),
-		nodeID:   nodeID,
+		db:          db,
+		handlers:    handlers,
+		eventTypes:  eventTypes,
+		previewKeys: buildPreviewKeys(keyNames),
+		collections: buildCollectionMap(keyNames),
+		nodeID:      nodeID,
+		statements:  newStatementCache(db),
+		pgx:         usesPgx(db),
+		retry:       DefaultRetryPolicy(),
 	}
 }
 
@@ -87,12 +392,289 @@ func (r DefaultRecorder) GetDb() *sql.DB {
 	return r.db
 }
 
+// SetIsolationLevel sets the isolation level of the transactions used to record events.
+func (r *DefaultRecorder) SetIsolationLevel(level sql.IsolationLevel) {
+	r.isolation = level
+}
+
+// SetRetryPolicy sets the policy used to attempt transactions again when they fail because they conflict with
+// concurrent transactions or because the connection to the database is lost.
+func (r *DefaultRecorder) SetRetryPolicy(policy RetryPolicy) {
+	r.retry = policy
+}
+
+// SetOperationTimeout sets the maximum amount of time that each database operation may take. Each attempt to record
+// a batch of events and each attempt to quarantine a message counts as a single operation. A timeout of zero or less
+// means that operations are only limited by the contexts passed to the recorder.
+func (r *DefaultRecorder) SetOperationTimeout(timeout time.Duration) {
+	r.opTimeout = timeout
+}
+
+// SetDuplicateWindow enables the suppression of read events that occur within the given window of an identical read
+// event, meaning one for the same path by the same user. The given number of recently recorded events are remembered
+// in memory. A window of zero or less disables duplicate suppression.
+func (r *DefaultRecorder) SetDuplicateWindow(window time.Duration, cacheSize int) {
+	if window <= 0 {
+		r.recent = nil
+		return
+	}
+	r.recent = newRecentEvents(window, cacheSize)
+}
+
+// operationContext returns the context used for a single database operation.
+func (r DefaultRecorder) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.opTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.opTimeout)
+}
+
+// txOptions returns the options used to begin the transactions in which events are recorded.
+func (r DefaultRecorder) txOptions() *sql.TxOptions {
+	if r.isolation == sql.LevelDefault {
+		return nil
+	}
+	return &sql.TxOptions{Isolation: r.isolation}
+}
+
+// preparedStatements returns the statement cache associated with a DefaultRecorder.
+func (r DefaultRecorder) preparedStatements() *statementCache {
+	return r.statements
+}
+
 // GetHandlerMap returns the handler map assocated with a DefaultHandler.
 func (r DefaultRecorder) GetHandlerMap() *HandlerMap {
 	return r.handlers
 }
 
-// RecordEvent records an event in the database if there is a handler for the given routing key.
-func (r DefaultRecorder) RecordEvent(key string, msg *model.Message) error {
-	return dispatchMessage(r, key, msg)
+// RecordEvent records an event in the database if there is a handler for the given routing key. Errors that might not
+// occur if the event is recorded again later are wrapped in a RetryableError. The recorded event is returned, or nil if
+// there's no handler for the routing key. The event is abandoned if it can't be recorded before the context expires.
+func (r DefaultRecorder) RecordEvent(ctx context.Context, key string, msg *model.Message) (*Event, error) {
+	events, err := r.RecordEvents(ctx, []*EventRequest{{Key: key, Msg: msg}})
+	if err != nil {
+		return nil, err
+	}
+	return events[0], nil
+}
+
+// RecordEvents records a batch of events in a single transaction. Either all of the events are recorded or none of them
+// are: the transaction is rolled back if any event can't be recorded, so the batch can be recorded again cleanly. A
+// transaction that conflicts with a concurrent transaction is attempted again before an error is returned. The returned
+// events correspond to the requests; the event for a request whose routing key has no handler is nil. Events recorded
+// by the default handlers are inserted with a single multi-row statement, or with a single batch of statements if the
+// pgx driver is used. These events are recorded under the current versions of the data objects that their messages
+// identify. Events that duplicate recent events are marked as duplicates and aren't inserted. The message that produced
+// each event is stored alongside it if raw payloads are enabled, and missing partitions of the event log are created if
+// that's enabled. Events that had already been recorded are skipped and marked as such if idempotency keys are enabled,
+// and reads of archived data objects are skipped and marked as such if that's enabled. Collection messages are applied
+// in the same transaction as the events, and their events are nil. Errors are classified in the same way as they are
+// for RecordEvent.
+func (r DefaultRecorder) RecordEvents(ctx context.Context, requests []*EventRequest) ([]*Event, error) {
+	events := make([]*Event, len(requests))
+
+	// Determine how each event will be recorded. The insertion of events whose types are known is deferred so that
+	// they can be inserted together. Collection messages are applied by the recorder because they may update a large
+	// number of data objects. The other events are recorded by their handlers.
+	var rows []*eventRow
+	var handled []int
+	var collections []*collectionRequest
+	duplicates := newDuplicateFilter(r.recent)
+	for i, request := range requests {
+		pattern, f := r.handlers.find(request.Key)
+		if f == nil {
+			continue
+		}
+		if kind, ok := r.collections[pattern]; ok {
+			op, err := collectionOperationFor(r, kind, request.Msg)
+			if err != nil {
+				return nil, classifyError(err)
+			}
+			if op != nil {
+				collections = append(collections, &collectionRequest{op: op})
+			}
+		} else if eventType, ok := r.eventTypeFor(pattern, request.Msg); ok {
+			events[i] = newEvent(r, eventType, request.Msg)
+			if duplicates.suppress(events[i], request.Msg) {
+				events[i].Duplicate = true
+				countOperation(eventType, operationDeduplicated, 1)
+				continue
+			}
+			payload, err := r.rawPayloadFor(request.Key, request.Msg)
+			if err != nil {
+				return nil, err
+			}
+			row := &eventRow{entity: request.Msg.Entity, event: events[i], payload: payload}
+			if r.idempotencyKeys {
+				row.key = idempotencyKey(eventType, request.Msg)
+			}
+			if r.messageDetails {
+				row.details = messageDetails(request.Msg)
+				if r.eventTypes[pattern] == ETRead || r.previewKeys[pattern] {
+					row.details.addClient(request.Msg)
+					row.details.addReadSize(request.Msg)
+				}
+			}
+			rows = append(rows, row)
+		} else {
+			handled = append(handled, i)
+		}
+	}
+
+	// Skip the reads of archived data objects if that's enabled.
+	if r.skipArchivedReads && len(rows) > 0 {
+		var err error
+		if rows, err = r.dropArchivedReads(ctx, rows); err != nil {
+			return nil, classifyError(err)
+		}
+	}
+
+	// Record the events under the current versions of the data objects that they refer to.
+	if len(rows) > 0 {
+		if err := r.resolveCurrentVersions(ctx, rows); err != nil {
+			return nil, classifyError(err)
+		}
+	}
+
+	// Skip the events that had already been recorded if idempotency keys are enabled.
+	if r.idempotencyKeys && hasKeys(rows) {
+		var err error
+		if rows, err = r.dropRecordedEvents(ctx, rows); err != nil {
+			return nil, classifyError(err)
+		}
+	}
+
+	// Don't start a transaction if there's nothing to record.
+	if len(rows) == 0 && len(handled) == 0 && len(collections) == 0 {
+		return events, nil
+	}
+
+	// Record the events, creating any missing partitions of the event log if necessary.
+	start := time.Now()
+	err := r.recordBatch(ctx, requests, events, rows, handled, collections)
+	if err != nil && r.createPartitions && isMissingPartition(err) {
+		if err = r.createMissingPartitions(ctx, rows); err == nil {
+			err = r.recordBatch(ctx, requests, events, rows, handled, collections)
+		}
+	}
+	observeInsert(rows, handledEvents(events, handled), time.Since(start), err)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	duplicates.commit()
+	logCollections(collections)
+	return events, nil
+}
+
+// handledEvents returns the events for the requests with the given indexes.
+func handledEvents(events []*Event, handled []int) []*Event {
+	result := make([]*Event, len(handled))
+	for i, index := range handled {
+		result[i] = events[index]
+	}
+	return result
+}
+
+// recordBatch records the events for a batch of requests. The given rows are inserted by the recorder, the events for
+// the requests with the given indexes are recorded by their handlers and the given collection operations are applied.
+func (r DefaultRecorder) recordBatch(
+	ctx context.Context, requests []*EventRequest, events []*Event, rows []*eventRow, handled []int,
+	collections []*collectionRequest,
+) error {
+
+	// Use the pgx batch API if the events can all be inserted by the recorder. Events that are recorded by their
+	// handlers and collection operations, which update objects until a batch is incomplete, have to be recorded in a
+	// database/sql transaction.
+	if r.pgx && len(handled) == 0 && len(collections) == 0 {
+		return r.sendBatches(ctx, rows)
+	}
+
+	// Prepare the insert statements.
+	if err := prepareInserts(ctx, r.statements, rows); err != nil {
+		return err
+	}
+
+	// Record the events in a single transaction.
+	opts := r.txOptions()
+	return withTransaction(ctx, r.db, opts, r.retry, r.operationContext, func(ctx context.Context, tx *sql.Tx) error {
+
+		// Apply the collection operations, which don't produce events.
+		for _, c := range collections {
+			if err := r.recordCollection(ctx, tx, c); err != nil {
+				return err
+			}
+		}
+
+		// Record the events that have to be recorded by their handlers.
+		for _, i := range handled {
+			request := requests[i]
+			event, err := r.handlers.Find(request.Key)(ctx, tx, r, request.Key, request.Msg)
+			if err != nil {
+				return err
+			}
+			events[i] = event
+		}
+
+		// Insert the remaining events and update the summary tables.
+		if err := insertEvents(ctx, tx, r.statements, rows); err != nil {
+			return err
+		}
+		return r.updateSummaries(ctx, sqlExec(tx), rows)
+	})
+}
+
+// summaryFunc updates the summary tables for a set of rows that were inserted into the event log within a transaction.
+type summaryFunc func(ctx context.Context, exec execFunc, rows []*eventRow) error
+
+// updateSummaries updates the summary tables that are enabled for a set of rows that were inserted into the event
+// log, within the transaction that inserted them.
+func (r DefaultRecorder) updateSummaries(ctx context.Context, exec execFunc, rows []*eventRow) error {
+	rows = insertedRows(rows)
+	if r.rollups {
+		if err := updateRollups(ctx, exec, rows); err != nil {
+			return err
+		}
+	}
+	if r.lastAccessed {
+		if err := updateLastAccessed(ctx, exec, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertedRows returns the rows that were inserted into the event log, omitting those that were skipped because they
+// had already been recorded.
+func insertedRows(rows []*eventRow) []*eventRow {
+	inserted := make([]*eventRow, 0, len(rows))
+	for _, row := range rows {
+		if !row.event.AlreadyRecorded {
+			inserted = append(inserted, row)
+		}
+	}
+	return inserted
+}
+
+// sendBatches inserts rows into the event log using the pgx batch API. The batches are sent again according to the
+// retry policy if the transaction conflicts with a concurrent transaction or the connection is lost.
+func (r DefaultRecorder) sendBatches(ctx context.Context, rows []*eventRow) error {
+	return retryTransient(ctx, r.retry, func() (bool, error) {
+		ctx, cancel := r.operationContext(ctx)
+		defer cancel()
+		return sendBatches(ctx, r.db, r.isolation, rows, r.updateSummaries)
+	})
+}
+
+// RecordQuarantine stores a message that could not be processed along with the reason that it could not be processed
+// so that it can be examined later.
+func (r DefaultRecorder) RecordQuarantine(ctx context.Context, key string, body []byte, reason string) error {
+	ctx, cancel := r.operationContext(ctx)
+	defer cancel()
+	_, err := r.statements.execNamed(ctx, addQuarantinedMessage, namedArgs{
+		"routing_key": key,
+		"body":        body,
+		"error":       reason,
+		"received_at": time.Now(),
+	})
+	return classifyError(err)
 }
@@ -1,9 +1,11 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/cyverse-de/dataone-indexer/model"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
@@ -11,13 +13,40 @@ import (
 
 // Routing keys to use for testing.
 const (
-	ReadKey = "data-object.open"
+	ReadKey       = "data-object.open"
+	LegacyReadKey = "irods.open"
+	PreviewKey    = "data-object.preview"
+	AddKey        = "data-object.add"
+	MoveKey       = "data-object.mv"
+	DeleteKey     = "data-object.rm"
+	MetadataKey   = "data-object.metadata.*"
+	PermissionKey = "data-object.acl.mod"
+	ModifyKey     = "data-object.mod"
+	ReplicateKey  = "data-object.replicated"
+
+	SynchronizationKey = "dataone.synchronization"
+
+	CollectionMoveKey   = "folder.mv"
+	CollectionDeleteKey = "folder.rm"
 )
 
 // getKeyNames defines the structure describing which routing keys correspond to which types of events.
 func getKeyNames() *KeyNames {
 	return &KeyNames{
-		Read: ReadKey,
+		Read:       []string{ReadKey, LegacyReadKey},
+		Preview:    []string{PreviewKey},
+		Add:        []string{AddKey},
+		Move:       []string{MoveKey},
+		Delete:     []string{DeleteKey},
+		Metadata:   []string{MetadataKey},
+		Permission: []string{PermissionKey},
+		Modify:     []string{ModifyKey},
+		Replicate:  []string{ReplicateKey},
+
+		Synchronization: []string{SynchronizationKey},
+
+		CollectionMove:   []string{CollectionMoveKey},
+		CollectionDelete: []string{CollectionDeleteKey},
 	}
 }
 
@@ -31,11 +60,35 @@ func getTestMessage() *model.Message {
 	return &model.Message{
 		Author:    &model.User{Name: "ipcdev", Zone: "iplant"},
 		Entity:    "F3579BF9-284B-4B3C-841B-F6E87D3F78EA",
+		UUID:      "f3579bf9-284b-4b3c-841b-f6e87d3f78ea",
 		Path:      "/iplant/home/shared/commons-repo/curated/foo.txt",
 		Timestamp: model.CurrentTimestamp(),
 	}
 }
 
+// TestEventTimestamp verifies that events are recorded at the time in the message that produced them, or at the
+// current time if the message doesn't include one.
+func TestEventTimestamp(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+
+	r := getTestRecorder(db)
+	msg := getTestMessage()
+	if event := newEvent(r, ETRead, msg); !event.Timestamp.Equal(*msg.Timestamp.ToTime()) {
+		t.Errorf("expected the message timestamp %s but got %s", msg.Timestamp.ToTime(), event.Timestamp)
+	}
+
+	msg.Timestamp = nil
+	before := time.Now()
+	event := newEvent(r, ETRead, msg)
+	if event.Timestamp == nil || event.Timestamp.Before(before) || event.Timestamp.After(time.Now()) {
+		t.Errorf("expected the current time but got %v", event.Timestamp)
+	}
+}
+
 // TestReadEvent verifies that a read event can be recorded successfully.
 func TestReadEvent(t *testing.T) {
 
@@ -50,17 +103,28 @@ func TestReadEvent(t *testing.T) {
 	msg := getTestMessage()
 
 	// Describe the expected database actions.
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare("INSERT INTO event_log")
 	mock.ExpectBegin()
-	mock.ExpectExec("INSERT INTO event_log").
+	mock.ExpectQuery("INSERT INTO event_log").
 		WithArgs(msg.Entity, msg.Path, ETRead, msg.Timestamp.ToTime(), r.GetNodeID()).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
 	mock.ExpectCommit()
 
 	// Record the message.
-	if err := r.RecordEvent(ReadKey, msg); err != nil {
+	event, err := r.RecordEvent(context.Background(), ReadKey, msg)
+	if err != nil {
 		t.Fatalf("error encountered while recording event: %s", err)
 	}
 
+	// Verify that the recorded event was returned.
+	if event == nil {
+		t.Fatal("no event was returned")
+	}
+	if event.ID != 42 || event.Type != ETRead || event.Path != msg.Path || event.NodeID != r.GetNodeID() {
+		t.Errorf("unexpected event returned: %+v", event)
+	}
+
 	// Verify that the expectations were met.
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -81,14 +145,124 @@ func TestErroneousReadEvent(t *testing.T) {
 	msg := getTestMessage()
 
 	// Describe the expected database actions.
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare("INSERT INTO event_log")
 	mock.ExpectBegin()
-	mock.ExpectExec("INSERT INTO event_log").
+	mock.ExpectQuery("INSERT INTO event_log").
 		WithArgs(msg.Entity, msg.Path, ETRead, msg.Timestamp.ToTime(), r.GetNodeID()).
 		WillReturnError(fmt.Errorf("something bad happened"))
 	mock.ExpectRollback()
 
 	// Record the message.
-	if err := r.RecordEvent(ReadKey, msg); err == nil {
+	if _, err := r.RecordEvent(context.Background(), ReadKey, msg); err == nil {
+		t.Fatalf("an error was expected but none was encountered")
+	}
+
+	// Verify that the expectations were met.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestMessageNodeID verifies that an event is recorded under the node identifier in its message if there is one.
+func TestMessageNodeID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	r := getTestRecorder(db)
+	msg := getTestMessage()
+	msg.NodeID = "urn:node:other"
+
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").
+		WithArgs(msg.Entity, msg.Path, ETRead, msg.Timestamp.ToTime(), "urn:node:other").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectCommit()
+
+	event, err := r.RecordEvent(context.Background(), ReadKey, msg)
+	if err != nil {
+		t.Fatalf("error encountered while recording event: %s", err)
+	}
+	if event.NodeID != "urn:node:other" {
+		t.Errorf("expected the event to be recorded under urn:node:other, got %s", event.NodeID)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestRecordEvents verifies that a batch of events is recorded in a single transaction using a single statement, and
+// that requests without handlers are skipped.
+func TestRecordEvents(t *testing.T) {
+
+	// Create the stub database connection.
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	// Prepare to record the messages.
+	r := getTestRecorder(db)
+	msg := getTestMessage()
+	requests := []*EventRequest{
+		{Key: ReadKey, Msg: msg},
+		{Key: "data-object.unknown", Msg: getTestMessage()},
+		{Key: LegacyReadKey, Msg: msg},
+	}
+
+	// Describe the expected database actions.
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare(`INSERT INTO event_log .* VALUES \(\$1, \$2, \$3, \$4, \$5\), \(\$6, \$7, \$8, \$9, \$10\)`)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO event_log .* VALUES \(\$1, \$2, \$3, \$4, \$5\), \(\$6, \$7, \$8, \$9, \$10\)`).
+		WithArgs(
+			msg.Entity, msg.Path, ETRead, msg.Timestamp.ToTime(), r.GetNodeID(),
+			msg.Entity, msg.Path, ETRead, msg.Timestamp.ToTime(), r.GetNodeID(),
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectCommit()
+
+	// Record the messages.
+	events, err := r.RecordEvents(context.Background(), requests)
+	if err != nil {
+		t.Fatalf("error encountered while recording events: %s", err)
+	}
+	if len(events) != 3 || events[0].ID != 1 || events[1] != nil || events[2].ID != 2 {
+		t.Errorf("unexpected events returned: %+v", events)
+	}
+
+	// Verify that the expectations were met.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestErroneousRecordEvents verifies that no events in a batch are recorded if one of them can't be recorded.
+func TestErroneousRecordEvents(t *testing.T) {
+
+	// Create the stub database connection.
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	// Prepare to record the messages.
+	r := getTestRecorder(db)
+	requests := []*EventRequest{{Key: ReadKey, Msg: getTestMessage()}, {Key: ReadKey, Msg: getTestMessage()}}
+
+	// Describe the expected database actions.
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").WillReturnError(fmt.Errorf("something bad happened"))
+	mock.ExpectRollback()
+
+	// Record the messages.
+	if _, err := r.RecordEvents(context.Background(), requests); err == nil {
 		t.Fatalf("an error was expected but none was encountered")
 	}
 
@@ -97,3 +271,88 @@ func TestErroneousReadEvent(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+// TestRecordQuarantine verifies that a message can be quarantined successfully.
+func TestRecordQuarantine(t *testing.T) {
+
+	// Create the stub database connection.
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	// Prepare to quarantine the message.
+	r := getTestRecorder(db)
+	body := []byte("{not json")
+
+	// Describe the expected database actions.
+	mock.ExpectPrepare("INSERT INTO quarantine")
+	mock.ExpectExec("INSERT INTO quarantine").
+		WithArgs(ReadKey, body, "invalid message", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// Quarantine the message.
+	if err := r.RecordQuarantine(context.Background(), ReadKey, body, "invalid message"); err != nil {
+		t.Fatalf("error encountered while quarantining message: %s", err)
+	}
+
+	// Verify that the expectations were met.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestOperationTimeout verifies that a database operation is abandoned once the operation timeout elapses.
+func TestOperationTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	// The insert takes far longer than the operation timeout.
+	mock.ExpectPrepare("INSERT INTO quarantine")
+	mock.ExpectExec("INSERT INTO quarantine").WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetOperationTimeout(10 * time.Millisecond)
+	start := time.Now()
+	if err := r.RecordQuarantine(context.Background(), ReadKey, []byte("{}"), "invalid message"); err == nil {
+		t.Error("an error was expected but none was encountered")
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("the operation wasn't abandoned after the timeout: %s", elapsed)
+	}
+}
+
+// TestHandlerMap verifies that each routing key associated with an event type is mapped to a handler, and that
+// the other kinds of messages are recorded by their own handlers rather than being inserted into the event log
+// together.
+func TestHandlerMap(t *testing.T) {
+	handlers, eventTypes := buildHandlerMap(getKeyNames())
+	for _, key := range []string{ReadKey, LegacyReadKey} {
+		if (*handlers)[key] == nil {
+			t.Errorf("no handler found for routing key %s", key)
+		}
+		if eventTypes[key] != ETRead {
+			t.Errorf("expected routing key %s to be mapped to %s, got %s", key, ETRead, eventTypes[key])
+		}
+	}
+	keys := []string{
+		AddKey, MoveKey, DeleteKey, MetadataKey, PermissionKey, ModifyKey, ReplicateKey, CollectionMoveKey,
+		CollectionDeleteKey, SynchronizationKey,
+	}
+	for _, key := range keys {
+		if (*handlers)[key] == nil {
+			t.Errorf("no handler found for routing key %s", key)
+		}
+		if _, ok := eventTypes[key]; ok {
+			t.Errorf("expected routing key %s to be recorded by its handler", key)
+		}
+	}
+	if (*handlers)[PreviewKey] == nil {
+		t.Errorf("no handler found for routing key %s", PreviewKey)
+	}
+	if len(*handlers) != 13 {
+		t.Errorf("expected 13 handlers but got %d", len(*handlers))
+	}
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// The condition that selects the registered data object that a message refers to. The object is identified by its UUID
+// if the entity identifier is one, which finds the object even if it was registered with the UUID written differently
+// or it was moved without the move being recorded. Otherwise, it's identified by its permanent identifier if it's
+// known, and by the path of the current version of the unarchived object if it isn't.
+const dataObjectCondition = `
+CASE
+    WHEN :entity_uuid::uuid IS NOT NULL THEN entity_uuid = :entity_uuid::uuid
+    WHEN :permanent_id::text = '' THEN irods_path = :irods_path AND NOT archived AND obsoleted_by IS NULL
+    ELSE permanent_id = :permanent_id::text
+END
+`
+
+// The statement used to record that the metadata of a registered data object changed. The object's modification time
+// is moved forward to the time of the change, and it's flagged so that its system metadata is synchronized again.
+var markMetadataStale = named(`
+UPDATE data_objects SET
+    modified_at = greatest(modified_at, :changed_at),
+    needs_resync = true
+WHERE ` + dataObjectCondition + `;
+`)
+
+// markStale flags a registered data object whose metadata changed. The return value indicates whether or not a
+// registered object was found.
+func markStale(ctx context.Context, q queryer, permanentID, path string, changedAt time.Time) (bool, error) {
+	uuid := model.ParseUUID(permanentID)
+	if err := syncPath(ctx, q, uuid, path, changedAt); err != nil {
+		return false, err
+	}
+	values := namedArgs{
+		"entity_uuid":  uuidArg(uuid),
+		"permanent_id": permanentID,
+		"irods_path":   path,
+		"changed_at":   changedAt,
+	}
+	result, err := execNamed(ctx, q, markMetadataStale, values)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// MarkMetadataStale records that the metadata of a registered data object changed at the given time, so that its
+// system metadata is synchronized again. The object is identified by its permanent identifier if it's not empty, and
+// by its path otherwise. The return value is false if the object isn't registered.
+func (r DefaultRecorder) MarkMetadataStale(
+	ctx context.Context, permanentID, path string, changedAt time.Time,
+) (bool, error) {
+	ctx, cancel := r.operationContext(ctx)
+	defer cancel()
+	marked, err := markStale(ctx, r.db, permanentID, path, changedAt)
+	return marked, classifyError(err)
+}
+
+// recordMetadataChange is the function that DefaultRecorder uses to record changes to the metadata of data objects in
+// the repository. Changes to objects that were never registered are skipped. Metadata changes aren't recorded in the
+// event log, so no event is returned.
+func recordMetadataChange(ctx context.Context, tx *sql.Tx, r Recorder, key string, msg *model.Message) (*Event, error) {
+	attributes := strings.Join(msg.Attributes, ", ")
+	logger.Log.Debugf("metadata of '%s' changed (%s): attributes [%s]", msg.Path, key, attributes)
+	marked, err := markStale(ctx, tx, msg.Entity, msg.Path, *messageTime(msg))
+	if err != nil {
+		return nil, err
+	}
+	if !marked {
+		logger.Log.Debugf("skipping the metadata change for unregistered data object at '%s'", msg.Path)
+	}
+	return nil, nil
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestRecordMetadataChange verifies that metadata changes flag registered data objects, whether they're identified by
+// permanent identifier or by path, and that changes to unregistered objects are skipped without an error.
+func TestRecordMetadataChange(t *testing.T) {
+	changed := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		entity   string
+		affected int64
+	}{
+		{"by identifier", "F3579BF9-284B-4B3C-841B-F6E87D3F78EA", 1},
+		{"by path", "", 1},
+		{"unregistered", "F3579BF9-284B-4B3C-841B-F6E87D3F78EA", 0},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("%s: error opening stub database connection: %s", test.name, err)
+		}
+		r := getTestRecorder(db)
+		msg := getTimestampedMessage(changed)
+		msg.Entity = test.entity
+		msg.Attributes = []string{"title", "creator"}
+
+		// Objects identified by UUID have their paths updated before they're flagged.
+		var uuid driver.Value
+		mock.ExpectBegin()
+		if test.entity != "" {
+			uuid = model.ParseUUID(test.entity)
+			mock.ExpectExec("UPDATE data_objects SET irods_path").
+				WithArgs(msg.Path, changed, uuid).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectExec("UPDATE data_objects SET modified_at").
+			WithArgs(changed, uuid, test.entity, msg.Path).
+			WillReturnResult(sqlmock.NewResult(0, test.affected))
+		mock.ExpectCommit()
+
+		event, err := r.RecordEvent(context.Background(), "data-object.metadata.mod", msg)
+		if err != nil {
+			t.Errorf("%s: error encountered while recording metadata change: %s", test.name, err)
+		}
+		if event != nil {
+			t.Errorf("%s: expected no event but got %+v", test.name, event)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+		db.Close()
+	}
+}
+
+// TestMarkMetadataStale verifies that the recorder reports whether or not a registered data object was found.
+func TestMarkMetadataStale(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	changed := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec("UPDATE data_objects").
+		WithArgs(changed, nil, "fakeid", "/foo/bar").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE data_objects").
+		WithArgs(changed, nil, "otherid", "/foo/baz").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	for _, test := range []struct {
+		id     string
+		path   string
+		marked bool
+	}{{"fakeid", "/foo/bar", true}, {"otherid", "/foo/baz", false}} {
+		marked, err := r.MarkMetadataStale(context.Background(), test.id, test.path, changed)
+		if err != nil {
+			t.Errorf("%s: error encountered while marking the metadata as stale: %s", test.id, err)
+		}
+		if marked != test.marked {
+			t.Errorf("%s: expected marked to be %t but got %t", test.id, test.marked, marked)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestMetadataDrivers verifies that metadata changes are recorded with each of the supported drivers, and that the
+// modification time of a data object never moves backwards.
+func TestMetadataDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := getTestRecorder(db)
+		ctx := context.Background()
+
+		// Register the object, then process a change identified by path and an older change identified by entity.
+		added := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+		addition := getAddMessage(added, 1024, "sha2:fakechecksum")
+		byPath := getTimestampedMessage(added.Add(2 * time.Hour))
+		byPath.Entity = ""
+		older := getTimestampedMessage(added.Add(time.Hour))
+		if _, err := r.RecordEvent(ctx, AddKey, addition); err != nil {
+			t.Fatalf("%s: error encountered while recording addition: %s", driver, err)
+		}
+		for _, msg := range []*model.Message{byPath, older} {
+			if _, err := r.RecordEvent(ctx, "data-object.metadata.add", msg); err != nil {
+				t.Fatalf("%s: error encountered while recording metadata change: %s", driver, err)
+			}
+		}
+
+		var modifiedAt time.Time
+		var needsResync bool
+		query := "SELECT modified_at, needs_resync FROM data_objects WHERE permanent_id = $1"
+		if err := db.QueryRow(query, addition.Entity).Scan(&modifiedAt, &needsResync); err != nil {
+			t.Errorf("%s: unable to look up the data object: %s", driver, err)
+		} else if !needsResync || !modifiedAt.Equal(*byPath.Timestamp.ToTime()) {
+			t.Errorf("%s: unexpected data object: modified at %s (needs resync: %t)", driver, modifiedAt, needsResync)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/metrics"
+)
+
+// insertLatencySamples is the number of recent insert latencies retained for each event type.
+const insertLatencySamples = 1000
+
+// Counters describing the database operations performed by the recorder. Operations are counted for each event type,
+// and errors are counted for each class of error.
+var (
+	databaseOperations = metrics.NewCounters("database_operations", "database operations by event type")
+	databaseErrors     = metrics.NewCounters("database_errors", "database errors by class")
+)
+
+// Database operation outcomes, which are counted for each event type. Each batch of events that's recorded
+// successfully is counted once for each event type in the batch, along with the number of rows written for each type,
+// so that the average number of rows written per batch can be determined.
+const (
+	operationBatches      = "batches"
+	operationRows         = "rows"
+	operationDeduplicated = "deduplicated"
+	operationSkipped      = "skipped"
+	operationArchived     = "archived"
+	operationFailed       = "failed"
+)
+
+// countOperation increments the counter for a database operation outcome for an event type.
+func countOperation(eventType, outcome string, delta int64) {
+	databaseOperations.Add(eventType+"/"+outcome, delta)
+}
+
+// The insert latencies for each event type, which are registered when the first event of each type is recorded.
+var (
+	insertLatenciesMutex sync.Mutex
+	insertLatencies      = make(map[string]*metrics.Durations)
+)
+
+// insertLatency returns the insert latencies for an event type.
+func insertLatency(eventType string) *metrics.Durations {
+	insertLatenciesMutex.Lock()
+	defer insertLatenciesMutex.Unlock()
+
+	d, ok := insertLatencies[eventType]
+	if !ok {
+		d = metrics.NewDurations(
+			"database_insert_latency_seconds_"+strings.ToLower(eventType),
+			"database insert latency for "+eventType+" events",
+			insertLatencySamples,
+		)
+		insertLatencies[eventType] = d
+	}
+	return d
+}
+
+// observeInsert records the outcome of an attempt to insert a batch of events. The events that were recorded by their
+// handlers are included if the batch was recorded successfully. Events that were skipped because they had already been
+// recorded are counted separately from the rows that were written.
+func observeInsert(rows []*eventRow, handled []*Event, elapsed time.Duration, err error) {
+	counts := make(map[string]int64)
+	for _, row := range rows {
+		if err == nil && row.event.AlreadyRecorded {
+			countOperation(row.event.Type, operationSkipped, 1)
+			continue
+		}
+		counts[row.event.Type]++
+	}
+	if err == nil {
+		for _, event := range handled {
+			if event != nil {
+				counts[event.Type]++
+			}
+		}
+	}
+
+	for eventType, n := range counts {
+		if err != nil {
+			countOperation(eventType, operationFailed, n)
+			continue
+		}
+		countOperation(eventType, operationBatches, 1)
+		countOperation(eventType, operationRows, n)
+		insertLatency(eventType).Observe(elapsed)
+	}
+}
+
+// Names of the Postgres error classes that are counted separately. Errors in other classes are counted by class code.
+var errorClassNames = map[string]string{
+	"08": "connection_exception",
+	"22": "data_exception",
+	"23": "integrity_constraint_violation",
+	"40": "transaction_rollback",
+	"42": "syntax_error_or_access_rule_violation",
+	"53": "insufficient_resources",
+	"57": "operator_intervention",
+	"58": "system_error",
+}
+
+// errorClass returns the class of an error returned by the database layer, for use as a metric label.
+func errorClass(err error) string {
+	if code, ok := sqlState(err); ok {
+		if len(code) != 5 {
+			return "unknown_sqlstate"
+		}
+		if name, ok := errorClassNames[code[:2]]; ok {
+			return name
+		}
+		return "sqlstate_" + code[:2]
+	}
+
+	switch err {
+	case context.DeadlineExceeded:
+		return "timeout"
+	case context.Canceled:
+		return "canceled"
+	}
+	if _, ok := err.(net.Error); ok {
+		return "network"
+	}
+	return "other"
+}
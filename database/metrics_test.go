@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/metrics"
+	"github.com/jackc/pgx"
+	"github.com/lib/pq"
+)
+
+// TestErrorClass verifies that database errors are labeled by class correctly.
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{&pq.Error{Code: "40P01"}, "transaction_rollback"},
+		{pgx.PgError{Code: "23505"}, "integrity_constraint_violation"},
+		{&pq.Error{Code: "XX000"}, "sqlstate_XX"},
+		{&pq.Error{Code: "bad"}, "unknown_sqlstate"},
+		{context.DeadlineExceeded, "timeout"},
+		{context.Canceled, "canceled"},
+		{&net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}, "network"},
+		{fmt.Errorf("something else"), "other"},
+	}
+
+	for _, test := range tests {
+		if actual := errorClass(test.err); actual != test.expected {
+			t.Errorf("%v: expected %s, got %s", test.err, test.expected, actual)
+		}
+	}
+}
+
+// TestObserveInsert verifies that the outcomes of inserts are counted for each event type.
+func TestObserveInsert(t *testing.T) {
+	before := databaseOperations.Snapshot()
+	rows := []*eventRow{
+		{event: &Event{Type: ETRead}},
+		{event: &Event{Type: ETRead}},
+		{event: &Event{Type: ETCreate}},
+	}
+	observeInsert(rows, []*Event{{Type: ETCreate}, nil}, time.Millisecond, nil)
+	observeInsert(rows[:1], nil, time.Millisecond, fmt.Errorf("connection refused"))
+
+	expected := map[string]int64{
+		"READ/batches":   1,
+		"READ/rows":      2,
+		"READ/failed":    1,
+		"CREATE/batches": 1,
+		"CREATE/rows":    2,
+	}
+	delta := metrics.Delta(before, databaseOperations.Snapshot())
+	for key, value := range expected {
+		if delta[key] != value {
+			t.Errorf("%s: expected %d, got %d", key, value, delta[key])
+		}
+	}
+	if count := insertLatency(ETRead).Count(); count < 1 {
+		t.Errorf("expected at least 1 insert latency observation but got %d", count)
+	}
+}
@@ -0,0 +1,431 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Migration describes a single change to the event database schema. Migrations are applied in order of version, and
+// each one is applied in its own transaction. The backfill, if there is one, is executed before the statements to bring
+// existing rows into line with the schema change; it may refer to the default member node identifier as :node_id.
+type Migration struct {
+	Version     int
+	Description string
+	backfill    *namedStatement
+	statements  string
+}
+
+// migrations lists the changes to the event database schema in the order in which they're applied. Migrations that
+// have been released must never be modified; changes to the schema must be made by adding new migrations. The early
+// migrations tolerate existing tables because the schema was originally created by hand.
+var migrations = []*Migration{
+	{
+		Version:     1,
+		Description: "create the event log",
+		statements: `
+CREATE TABLE IF NOT EXISTS event_log (
+    id bigserial PRIMARY KEY,
+    permanent_id text,
+    irods_path text NOT NULL,
+    event text NOT NULL,
+    date_logged timestamp with time zone NOT NULL,
+    node_identifier text NOT NULL
+);
+
+ALTER TABLE event_log ADD COLUMN IF NOT EXISTS id bigserial;
+
+CREATE INDEX IF NOT EXISTS event_log_date_logged_index ON event_log (date_logged);
+`,
+	},
+	{
+		Version:     2,
+		Description: "create the quarantine table",
+		statements: `
+CREATE TABLE IF NOT EXISTS quarantine (
+    id bigserial PRIMARY KEY,
+    routing_key text NOT NULL,
+    body bytea NOT NULL,
+    error text NOT NULL,
+    received_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS quarantine_received_at_index ON quarantine (received_at);
+`,
+	},
+	{
+		Version:     3,
+		Description: "create the event error table",
+		statements: `
+CREATE TABLE event_errors (
+    id bigserial PRIMARY KEY,
+    routing_key text NOT NULL,
+    body bytea NOT NULL,
+    error text NOT NULL,
+    attempts integer NOT NULL,
+    first_failed_at timestamp with time zone NOT NULL,
+    last_failed_at timestamp with time zone NOT NULL,
+    resolved_at timestamp with time zone
+);
+
+CREATE INDEX event_errors_pending_index ON event_errors (id) WHERE resolved_at IS NULL;
+CREATE INDEX event_errors_resolved_at_index ON event_errors (resolved_at) WHERE resolved_at IS NOT NULL;
+`,
+	},
+	{
+		Version:     4,
+		Description: "add the raw payload column to the event log",
+		statements: `
+ALTER TABLE event_log ADD COLUMN raw_payload jsonb;
+`,
+	},
+	{
+		Version:     5,
+		Description: "add the event log partition helper",
+		statements: `
+CREATE OR REPLACE FUNCTION create_event_log_partition(month timestamp with time zone) RETURNS boolean AS $$
+DECLARE
+    start_time timestamp with time zone := date_trunc('month', month AT TIME ZONE 'UTC') AT TIME ZONE 'UTC';
+    partition_name text := 'event_log_' || to_char(start_time AT TIME ZONE 'UTC', 'YYYY_MM');
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_class WHERE oid = to_regclass('event_log') AND relkind = 'p') THEN
+        RETURN false;
+    END IF;
+    PERFORM pg_advisory_xact_lock(hashtext('event_log_partitions'));
+    EXECUTE format(
+        'CREATE TABLE IF NOT EXISTS %I PARTITION OF event_log FOR VALUES FROM (%L) TO (%L)',
+        partition_name, start_time, start_time + interval '1 month'
+    );
+    RETURN true;
+END;
+$$ LANGUAGE plpgsql;
+`,
+	},
+	{
+		Version:     6,
+		Description: "create the daily event count table",
+		statements: `
+CREATE TABLE event_rollups (
+    permanent_id text NOT NULL,
+    event text NOT NULL,
+    day date NOT NULL,
+    count bigint NOT NULL,
+    PRIMARY KEY (permanent_id, event, day)
+);
+
+CREATE INDEX event_rollups_day_index ON event_rollups (day);
+`,
+	},
+	{
+		Version:     7,
+		Description: "create the object access table",
+		statements: `
+CREATE TABLE object_access (
+    permanent_id text PRIMARY KEY,
+    last_accessed timestamp with time zone NOT NULL
+);
+`,
+	},
+	{
+		Version:     8,
+		Description: "add the idempotency key column to the event log",
+		statements: `
+ALTER TABLE event_log ADD COLUMN idempotency_key text;
+
+CREATE UNIQUE INDEX event_log_idempotency_key_index ON event_log (idempotency_key, date_logged);
+`,
+	},
+	{
+		Version:     9,
+		Description: "index the event log by member node",
+		statements: `
+CREATE INDEX event_log_node_identifier_index ON event_log (node_identifier, date_logged);
+`,
+	},
+	{
+		Version:     10,
+		Description: "add the message detail columns to the event log",
+		statements: `
+ALTER TABLE event_log ADD COLUMN subject text, ADD COLUMN file_size bigint, ADD COLUMN checksum text;
+`,
+	},
+	{
+		Version:     11,
+		Description: "create the data object table",
+		statements: `
+CREATE TABLE data_objects (
+    permanent_id text PRIMARY KEY,
+    irods_path text NOT NULL,
+    node_identifier text NOT NULL,
+    archived boolean NOT NULL DEFAULT false,
+    updated_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX data_objects_irods_path_index ON data_objects (irods_path);
+`,
+	},
+	{
+		Version:     12,
+		Description: "record when data objects are archived",
+		statements: `
+ALTER TABLE data_objects ADD COLUMN archived_at timestamp with time zone;
+`,
+	},
+	{
+		Version:     13,
+		Description: "add the registration columns to the data object table",
+		statements: `
+ALTER TABLE data_objects
+    ADD COLUMN file_size bigint,
+    ADD COLUMN checksum text,
+    ADD COLUMN creator text,
+    ADD COLUMN created_at timestamp with time zone,
+    ADD COLUMN modified_at timestamp with time zone;
+`,
+	},
+	{
+		Version:     14,
+		Description: "record which data objects need to be synchronized again",
+		statements: `
+ALTER TABLE data_objects ADD COLUMN needs_resync boolean NOT NULL DEFAULT false;
+`,
+	},
+	{
+		Version:     15,
+		Description: "create the access policy table",
+		statements: `
+CREATE TABLE access_policies (
+    permanent_id text NOT NULL REFERENCES data_objects (permanent_id),
+    subject text NOT NULL,
+    permission text NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (permanent_id, subject)
+);
+`,
+	},
+	{
+		Version:     16,
+		Description: "record the checksum algorithms and serial versions of data objects",
+		statements: `
+ALTER TABLE data_objects
+    ADD COLUMN checksum_algorithm text,
+    ADD COLUMN serial_version integer NOT NULL DEFAULT 1;
+`,
+	},
+	{
+		Version:     17,
+		Description: "record the UUIDs of data objects",
+		statements: `
+ALTER TABLE data_objects ADD COLUMN entity_uuid uuid;
+
+UPDATE data_objects SET entity_uuid = keepers.entity_uuid
+FROM (
+    SELECT DISTINCT ON (parsed.entity_uuid) parsed.permanent_id, parsed.entity_uuid
+    FROM (
+        SELECT permanent_id, archived, updated_at, CASE
+            WHEN permanent_id ~* '^\{?[0-9a-f]{8}(-?[0-9a-f]{4}){3}-?[0-9a-f]{12}\}?$'
+                AND (left(permanent_id, 1) = '{') = (right(permanent_id, 1) = '}')
+                THEN permanent_id::uuid
+        END AS entity_uuid
+        FROM data_objects
+    ) parsed
+    WHERE parsed.entity_uuid IS NOT NULL
+    ORDER BY parsed.entity_uuid, parsed.archived, parsed.updated_at DESC, parsed.permanent_id
+) keepers
+WHERE data_objects.permanent_id = keepers.permanent_id;
+
+CREATE UNIQUE INDEX data_objects_entity_uuid_index ON data_objects (entity_uuid);
+`,
+	},
+	{
+		Version:     18,
+		Description: "add the client detail columns to the event log",
+		statements: `
+ALTER TABLE event_log ADD COLUMN ip_address inet, ADD COLUMN ip_address_raw text, ADD COLUMN user_agent text;
+`,
+	},
+	{
+		Version:     19,
+		Description: "record the number of bytes read by partial reads",
+		statements: `
+ALTER TABLE event_log ADD COLUMN bytes_read bigint;
+`,
+	},
+	{
+		Version:     20,
+		Description: "record the iRODS zones and resources of events",
+		statements: `
+ALTER TABLE event_log ADD COLUMN zone text, ADD COLUMN resource text;
+`,
+	},
+	{
+		Version:     21,
+		Description: "record the system metadata of data objects",
+		statements: `
+ALTER TABLE data_objects
+    ADD COLUMN rights_holder text,
+    ADD COLUMN authoritative_member_node text,
+    ADD COLUMN system_metadata_pending boolean NOT NULL DEFAULT false;
+`,
+	},
+	{
+		Version:     22,
+		Description: "record the obsolescence chains of data objects",
+		statements: `
+ALTER TABLE data_objects
+    ADD COLUMN obsoletes text REFERENCES data_objects (permanent_id),
+    ADD COLUMN obsoleted_by text REFERENCES data_objects (permanent_id),
+    ADD CONSTRAINT data_objects_obsolescence_check
+        CHECK (obsoletes <> permanent_id AND obsoleted_by <> permanent_id);
+
+CREATE UNIQUE INDEX data_objects_obsoletes_index ON data_objects (obsoletes);
+CREATE UNIQUE INDEX data_objects_obsoleted_by_index ON data_objects (obsoleted_by);
+`,
+	},
+	{
+		Version:     23,
+		Description: "record the status of the replicas of data objects",
+		statements: `
+CREATE TABLE replica_status (
+    permanent_id text NOT NULL,
+    target_node text NOT NULL,
+    status text NOT NULL,
+    failure_reason text,
+    failures integer NOT NULL DEFAULT 0,
+    verified_at timestamp with time zone,
+    updated_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (permanent_id, target_node)
+);
+
+CREATE INDEX replica_status_failed_index ON replica_status (updated_at) WHERE status = 'failed';
+`,
+	},
+	{
+		Version:     24,
+		Description: "flag data objects that the coordinating node failed to synchronize",
+		statements: `
+ALTER TABLE data_objects
+    ADD COLUMN needs_attention boolean NOT NULL DEFAULT false,
+    ADD COLUMN sync_failure text,
+    ADD COLUMN sync_failed_at timestamp with time zone,
+    ADD COLUMN synchronized_at timestamp with time zone;
+
+CREATE INDEX data_objects_needs_attention_index ON data_objects (sync_failed_at) WHERE needs_attention;
+`,
+	},
+	{
+		Version:     25,
+		Description: "require the member node identifier in the event log",
+		backfill: named(`
+UPDATE event_log SET node_identifier = :node_id WHERE node_identifier IS NULL;
+`),
+		statements: `
+ALTER TABLE event_log ALTER COLUMN node_identifier SET NOT NULL;
+`,
+	},
+}
+
+// The statement used to create the table that records which migrations have been applied.
+const createSchemaMigrations = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version integer PRIMARY KEY,
+    description text NOT NULL,
+    applied_at timestamp with time zone NOT NULL DEFAULT now()
+);
+`
+
+// The statement used to determine the current schema version.
+const getSchemaVersion = `
+SELECT coalesce(max(version), 0) FROM schema_migrations;
+`
+
+// The statement used to record that a migration has been applied.
+var addSchemaMigration = named(`
+INSERT INTO schema_migrations (version, description) VALUES (:version, :description);
+`)
+
+// The statement used to make sure that only one instance of the service applies migrations at a time. The lock is
+// released when the transaction ends.
+const lockSchemaMigrations = `
+SELECT pg_advisory_xact_lock(hashtext('schema_migrations'));
+`
+
+// undefinedTable is the Postgres error code reported when a query refers to a table that doesn't exist.
+const undefinedTable = "42P01"
+
+// LatestSchemaVersion returns the schema version required by this version of the service.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version of the event database schema. The version is zero if no migrations have been
+// applied.
+func SchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+	err := queryRowContext(ctx, db, getSchemaVersion).Scan(&version)
+	if code, ok := sqlState(err); ok && code == undefinedTable {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to determine the schema version: %s", err)
+	}
+	return version, nil
+}
+
+// Migrate applies all pending migrations to the event database, returning the migrations that were applied. Several
+// instances of the service may attempt to apply migrations at the same time; each migration is applied only once.
+// Existing events that don't identify a member node are assigned to the given default node.
+func Migrate(ctx context.Context, db *sql.DB, nodeID string) ([]*Migration, error) {
+	if _, err := execContext(ctx, db, createSchemaMigrations); err != nil {
+		return nil, fmt.Errorf("unable to create the schema_migrations table: %s", err)
+	}
+
+	var applied []*Migration
+	for _, m := range migrations {
+		ok, err := applyMigration(ctx, db, m, nodeID)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %s", m.Version, m.Description, err)
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// applyMigration applies a single migration unless it has already been applied. The return value indicates whether or
+// not the migration was applied.
+func applyMigration(ctx context.Context, db *sql.DB, m *Migration, nodeID string) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Check the schema version again once the lock is held in case another instance applied the migration.
+	if _, err := execContext(ctx, tx, lockSchemaMigrations); err != nil {
+		return false, err
+	}
+	var version int
+	if err := queryRowContext(ctx, tx, getSchemaVersion).Scan(&version); err != nil {
+		return false, err
+	}
+	if version >= m.Version {
+		return false, nil
+	}
+
+	// Apply the migration.
+	if m.backfill != nil {
+		if _, err := execNamed(ctx, tx, m.backfill, namedArgs{"node_id": nodeID}); err != nil {
+			return false, err
+		}
+	}
+	if _, err := execContext(ctx, tx, m.statements); err != nil {
+		return false, err
+	}
+	args := namedArgs{"version": m.Version, "description": m.Description}
+	if _, err := execNamed(ctx, tx, addSchemaMigration, args); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
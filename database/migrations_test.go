@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestMigrationOrder verifies that the migrations are listed in order of version without gaps.
+func TestMigrationOrder(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d", i+1, m.Version)
+		}
+	}
+	if LatestSchemaVersion() != len(migrations) {
+		t.Errorf("unexpected latest schema version: %d", LatestSchemaVersion())
+	}
+}
+
+// TestSchemaVersion verifies that the schema version is determined correctly.
+func TestSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   int
+		err       error
+		expected  int
+		expectErr bool
+	}{
+		{"migrated", 2, nil, 2, false},
+		{"no migrations table", 0, &pq.Error{Code: "42P01"}, 0, false},
+		{"other error", 0, &pq.Error{Code: "28P01"}, 0, true},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening stub database connection: %s", err)
+		}
+		query := mock.ExpectQuery("SELECT coalesce")
+		if test.err != nil {
+			query.WillReturnError(test.err)
+		} else {
+			query.WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(test.version))
+		}
+
+		version, err := SchemaVersion(context.Background(), db)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+		}
+		if version != test.expected {
+			t.Errorf("%s: expected version %d, got %d", test.name, test.expected, version)
+		}
+	}
+}
+
+// expectMigration describes the database actions used to apply a migration to a schema at the given version.
+func expectMigration(mock sqlmock.Sqlmock, version int, apply bool) {
+	mock.ExpectBegin()
+	mock.ExpectExec("pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT coalesce").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+	if !apply {
+		mock.ExpectRollback()
+		return
+	}
+	if migrations[version].backfill != nil {
+		mock.ExpectExec("UPDATE").WithArgs("fakenode").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("(CREATE|ALTER) (TABLE|INDEX)").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").
+		WithArgs(version+1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// TestMigrate verifies that only pending migrations are applied.
+func TestMigrate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	// The first migration has already been applied.
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectMigration(mock, 1, false)
+	for version := 1; version < len(migrations); version++ {
+		expectMigration(mock, version, true)
+	}
+
+	applied, err := Migrate(context.Background(), db, "fakenode")
+	if err != nil {
+		t.Fatalf("error encountered while applying migrations: %s", err)
+	}
+	if len(applied) != len(migrations)-1 || applied[0].Version != 2 {
+		t.Errorf("unexpected migrations applied: %v", applied)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestFailedMigration verifies that a failed migration is rolled back and reported.
+func TestFailedMigration(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec("pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT coalesce").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS event_log").WillReturnError(&pq.Error{Code: "42501"})
+	mock.ExpectRollback()
+
+	applied, err := Migrate(context.Background(), db, "fakenode")
+	if err == nil {
+		t.Error("an error was expected but none was encountered")
+	}
+	if len(applied) != 0 {
+		t.Errorf("unexpected migrations applied: %v", applied)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestMemberNodeBackfill verifies that events without member nodes are assigned to the default node before the member
+// node identifier becomes required, including in databases that were already migrated past the member node index.
+func TestMemberNodeBackfill(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	// Every migration but the last has already been applied.
+	last := len(migrations) - 1
+	for range migrations[:last] {
+		expectMigration(mock, last, false)
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec("pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT coalesce").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(last))
+	mock.ExpectExec("UPDATE event_log SET node_identifier = \\$1 WHERE node_identifier IS NULL").
+		WithArgs("urn:node:foo").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("ALTER TABLE event_log ALTER COLUMN node_identifier SET NOT NULL").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").
+		WithArgs(last+1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	applied, err := Migrate(context.Background(), db, "urn:node:foo")
+	if err != nil {
+		t.Fatalf("error encountered while applying migrations: %s", err)
+	}
+	if len(applied) != 1 || applied[0].Version != 25 {
+		t.Errorf("unexpected migrations applied: %v", applied)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// The statement used to update the stored path of a data object that was moved or renamed. The object is registered
+// if it hasn't been seen before. Archived objects remain in the table under the last path at which they were in the
+// repository, along with the time at which they were first archived; the time is cleared if they're moved back into
+// the repository. Updates are only applied if they're at least as recent as the last update, so that a move that's
+// processed out of order doesn't overwrite a more recent one. An object that's already registered with the same UUID
+// is updated under its permanent identifier.
+var moveDataObject = named(`
+INSERT INTO data_objects (permanent_id, entity_uuid, irods_path, node_identifier, archived, archived_at, updated_at)
+VALUES (
+    ` + registeredPermanentID + `, :entity_uuid::uuid, :irods_path, :node_identifier, :archived, :archived_at,
+    :updated_at
+)
+ON CONFLICT (permanent_id) DO UPDATE SET
+    entity_uuid = coalesce(data_objects.entity_uuid, excluded.entity_uuid),
+    irods_path = excluded.irods_path,
+    node_identifier = excluded.node_identifier,
+    archived = excluded.archived,
+    archived_at = CASE WHEN excluded.archived THEN coalesce(data_objects.archived_at, excluded.archived_at) END,
+    updated_at = excluded.updated_at
+WHERE data_objects.updated_at <= excluded.updated_at;
+`)
+
+// recordMove is the function that DefaultRecorder uses to record data objects that were moved or renamed. The stored
+// path of an object that was moved within the repository is updated, an object that was moved into the repository is
+// registered under its new path, and an object that was moved out of the repository is archived. Moves aren't
+// DataONE events, so nothing is added to the event log and no event is returned.
+func recordMove(ctx context.Context, tx *sql.Tx, r Recorder, key string, msg *model.Message) (*Event, error) {
+	if msg.Source == "" || msg.Entity == "" {
+		return nil, fmt.Errorf("move message for path '%s' doesn't identify the data object and its source", msg.Path)
+	}
+
+	// Determine where the object ended up.
+	path, archived := msg.Path, false
+	switch {
+	case msg.InRepository:
+	case msg.SourceInRepository:
+		path, archived = msg.Source, true
+	default:
+		return nil, nil
+	}
+
+	updatedAt := messageTime(msg)
+	var archivedAt *time.Time
+	if archived {
+		archivedAt = updatedAt
+	}
+	values := namedArgs{
+		"entity_uuid":     uuidArg(msg.UUID),
+		"permanent_id":    msg.Entity,
+		"irods_path":      path,
+		"node_identifier": messageNodeID(r, msg),
+		"archived":        archived,
+		"archived_at":     archivedAt,
+		"updated_at":      updatedAt,
+	}
+	if _, err := execNamed(ctx, tx, moveDataObject, values); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// getMoveMessage returns a message describing a move that can be used for testing.
+func getMoveMessage(source, destination string, sourceInRepository, inRepository bool) *model.Message {
+	msg := getTimestampedMessage(time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC))
+	msg.Source = source
+	msg.Path = destination
+	msg.SourceInRepository = sourceInRepository
+	msg.InRepository = inRepository
+	return msg
+}
+
+// TestRecordMove verifies that the stored path of a data object is updated when it's moved within the repository,
+// that it's registered when it's moved into the repository, and that it's archived when it's moved out.
+func TestRecordMove(t *testing.T) {
+	const (
+		inside  = "/iplant/home/shared/commons_repo/curated/foo.txt"
+		renamed = "/iplant/home/shared/commons_repo/curated/bar.txt"
+		outside = "/iplant/home/ipcdev/foo.txt"
+	)
+	moved := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		msg        *model.Message
+		path       string
+		archived   bool
+		archivedAt *time.Time
+	}{
+		{"within", getMoveMessage(inside, renamed, true, true), renamed, false, nil},
+		{"into", getMoveMessage(outside, inside, false, true), inside, false, nil},
+		{"out of", getMoveMessage(inside, outside, true, false), inside, true, &moved},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("%s: error opening stub database connection: %s", test.name, err)
+		}
+		r := getTestRecorder(db)
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO data_objects").
+			WithArgs(
+				test.msg.UUID, test.msg.Entity, test.path, "fakenode", test.archived, test.archivedAt,
+				test.msg.Timestamp.ToTime(),
+			).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		event, err := r.RecordEvent(context.Background(), MoveKey, test.msg)
+		if err != nil {
+			t.Errorf("%s: error encountered while recording move: %s", test.name, err)
+		}
+		if event != nil {
+			t.Errorf("%s: expected no event but got %+v", test.name, event)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+		db.Close()
+	}
+}
+
+// TestRecordMoveOutsideRepository verifies that nothing is recorded for a move that neither starts nor ends in the
+// repository, and that moves that don't identify the data object and its source are rejected.
+func TestRecordMoveOutsideRepository(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+	r := getTestRecorder(db)
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	msg := getMoveMessage("/iplant/home/ipcdev/foo.txt", "/iplant/home/ipcdev/bar.txt", false, false)
+	if _, err := r.RecordEvent(context.Background(), MoveKey, msg); err != nil {
+		t.Errorf("error encountered while recording move: %s", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	msg = getMoveMessage("", "/iplant/home/shared/commons_repo/curated/foo.txt", false, true)
+	if _, err := r.RecordEvent(context.Background(), MoveKey, msg); err == nil {
+		t.Error("expected an error for a move without a source")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestMoveDrivers verifies that moves are recorded with each of the supported drivers, and that a move that's older
+// than the last recorded move of the same object doesn't overwrite it.
+func TestMoveDrivers(t *testing.T) {
+	const (
+		inside  = "/iplant/home/shared/commons_repo/curated/foo.txt"
+		renamed = "/iplant/home/shared/commons_repo/curated/bar.txt"
+		outside = "/iplant/home/ipcdev/foo.txt"
+	)
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := getTestRecorder(db)
+		ctx := context.Background()
+
+		// Move the object into the repository, rename it and then process an older move out of the repository.
+		older := getMoveMessage(inside, outside, true, false)
+		newer := getMoveMessage(inside, renamed, true, true)
+		later := newer.Timestamp.ToTime().Add(time.Hour)
+		newer.Timestamp = (*model.Timestamp)(&later)
+		for _, msg := range []*model.Message{getMoveMessage(outside, inside, false, true), newer, older} {
+			if _, err := r.RecordEvent(ctx, MoveKey, msg); err != nil {
+				t.Fatalf("%s: error encountered while recording move: %s", driver, err)
+			}
+		}
+
+		var path string
+		var archived bool
+		query := "SELECT irods_path, archived FROM data_objects WHERE permanent_id = $1"
+		if err := db.QueryRow(query, newer.Entity).Scan(&path, &archived); err != nil {
+			t.Errorf("%s: unable to look up the data object: %s", driver, err)
+		} else if path != renamed || archived {
+			t.Errorf("%s: unexpected data object: %s (archived: %t)", driver, path, archived)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// namedArgs maps the names of the parameters of a named statement to their values.
+type namedArgs map[string]interface{}
+
+// namedStatement is a statement whose parameters are referred to by name, as in :permanent_id, rather than by
+// position. The names are replaced by positional parameters when the statement is defined, so the same text is sent to
+// the server, and prepared, each time the statement is executed. A parameter that's referred to more than once is
+// bound to a single positional parameter.
+type namedStatement struct {
+	query string
+	names []string
+}
+
+// isNameStart determines whether or not a character can begin the name of a parameter.
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isNameChar determines whether or not a character can appear in the name of a parameter.
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// named defines a statement with named parameters. Type casts such as ::date and colons within quoted strings and
+// identifiers aren't treated as parameters.
+func named(query string) *namedStatement {
+	var b strings.Builder
+	var names []string
+	positions := make(map[string]int)
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			b.WriteString("::")
+			i++
+			continue
+		case c == ':' && i+1 < len(query) && isNameStart(query[i+1]):
+			end := i + 1
+			for end < len(query) && isNameChar(query[end]) {
+				end++
+			}
+			name := query[i+1 : end]
+			if _, ok := positions[name]; !ok {
+				names = append(names, name)
+				positions[name] = len(names)
+			}
+			fmt.Fprintf(&b, "$%d", positions[name])
+			i = end - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return &namedStatement{query: b.String(), names: names}
+}
+
+// bind returns the positional arguments of the statement. Every parameter must have a value.
+func (s *namedStatement) bind(values namedArgs) ([]interface{}, error) {
+	args := make([]interface{}, len(s.names))
+	for i, name := range s.names {
+		value, ok := values[name]
+		if !ok {
+			return nil, fmt.Errorf("no value was provided for the statement parameter :%s", name)
+		}
+		args[i] = value
+	}
+	return args, nil
+}
+
+// execNamed executes a statement with named parameters that doesn't return rows.
+func execNamed(ctx context.Context, q queryer, s *namedStatement, values namedArgs) (sql.Result, error) {
+	args, err := s.bind(values)
+	if err != nil {
+		return nil, err
+	}
+	return execContext(ctx, q, s.query, args...)
+}
+
+// queryNamed executes a statement with named parameters that returns rows.
+func queryNamed(ctx context.Context, q queryer, s *namedStatement, values namedArgs) (*sql.Rows, error) {
+	args, err := s.bind(values)
+	if err != nil {
+		return nil, err
+	}
+	return queryContext(ctx, q, s.query, args...)
+}
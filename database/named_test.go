@@ -0,0 +1,50 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestNamed verifies that named parameters are replaced by positional parameters, and that type casts and quoted
+// strings and identifiers are left unchanged.
+func TestNamed(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+		names    []string
+	}{
+		{"no parameters", "SELECT 1", "SELECT 1", nil},
+		{"parameters", "UPDATE t SET a = :a WHERE id = :id", "UPDATE t SET a = $1 WHERE id = $2", []string{"a", "id"}},
+		{"repeated parameter", "VALUES (:at, :at, :b)", "VALUES ($1, $1, $2)", []string{"at", "b"}},
+		{"type cast", "WHERE day >= :first::date", "WHERE day >= $1::date", []string{"first"}},
+		{"quoted string", "SELECT ':a', :b", "SELECT ':a', $1", []string{"b"}},
+		{"quoted identifier", `INSERT INTO "a:b" VALUES (:c)`, `INSERT INTO "a:b" VALUES ($1)`, []string{"c"}},
+	}
+
+	for _, test := range tests {
+		s := named(test.query)
+		if s.query != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, s.query)
+		}
+		if !reflect.DeepEqual(s.names, test.names) {
+			t.Errorf("%s: expected parameters %v, got %v", test.name, test.names, s.names)
+		}
+	}
+}
+
+// TestNamedBind verifies that the arguments of a statement are ordered by position, and that a missing argument is
+// reported as an error.
+func TestNamedBind(t *testing.T) {
+	s := named("UPDATE t SET a = :a WHERE id = :id")
+	args, err := s.bind(namedArgs{"id": 42, "a": "foo"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"foo", 42}) {
+		t.Errorf("unexpected arguments: %v", args)
+	}
+	if _, err := s.bind(namedArgs{"a": "foo"}); err == nil {
+		t.Error("an error was expected for a missing argument")
+	}
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// The statement used to create the event log as a table that's partitioned by month on date_logged. Only the columns
+// created by the first migration are included so that the remaining migrations can be applied to the table normally.
+// The primary key of a partitioned table has to include the partition key.
+const createPartitionedEventLog = `
+CREATE TABLE IF NOT EXISTS event_log (
+    id bigserial,
+    permanent_id text,
+    irods_path text NOT NULL,
+    event text NOT NULL,
+    date_logged timestamp with time zone NOT NULL,
+    node_identifier text NOT NULL,
+    PRIMARY KEY (id, date_logged)
+) PARTITION BY RANGE (date_logged);
+`
+
+// The statement used to determine whether or not the event log is partitioned.
+const isEventLogPartitioned = `
+SELECT EXISTS (SELECT 1 FROM pg_class WHERE oid = to_regclass('event_log') AND relkind = 'p');
+`
+
+// The statement used to create the partition of the event log for the month containing a given time. The partition
+// isn't created if it already exists or if the event log isn't partitioned.
+var createEventLogPartition = named(`
+SELECT create_event_log_partition(:month);
+`)
+
+// checkViolation is the Postgres error code reported when a row violates a check constraint, which includes rows that
+// don't belong to any partition of a partitioned table.
+const checkViolation = "23514"
+
+// isMissingPartition determines whether or not an error indicates that a row couldn't be inserted into the event log
+// because the partition that it belongs to doesn't exist.
+func isMissingPartition(err error) bool {
+	code, ok := sqlState(err)
+	return ok && code == checkViolation && strings.Contains(sqlMessage(err), "no partition of relation")
+}
+
+// monthStart returns the beginning of the month containing the given time in UTC, which is the lower bound of the
+// partition of the event log that contains the time.
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// CreatePartitionedEventLog creates the event log as a partitioned table if it doesn't exist yet. It has to be called
+// before the migrations are applied to a new database. The return value indicates whether or not the event log is
+// partitioned; an existing event log that isn't partitioned is left unchanged.
+func CreatePartitionedEventLog(ctx context.Context, db *sql.DB) (bool, error) {
+	if _, err := execContext(ctx, db, createPartitionedEventLog); err != nil {
+		return false, err
+	}
+	var partitioned bool
+	if err := queryRowContext(ctx, db, isEventLogPartitioned).Scan(&partitioned); err != nil {
+		return false, err
+	}
+	return partitioned, nil
+}
+
+// CreatePartitions creates the partitions of the event log for the given number of months, starting with the month
+// containing the given time. Partitions that already exist are left unchanged, and nothing is done if the event log
+// isn't partitioned.
+func CreatePartitions(ctx context.Context, db *sql.DB, start time.Time, months int) error {
+	month := monthStart(start)
+	for i := 0; i < months; i++ {
+		args := namedArgs{"month": month.AddDate(0, i, 0)}
+		if _, err := execNamed(ctx, db, createEventLogPartition, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetCreateMissingPartitions enables or disables the creation of missing event log partitions. When it's enabled, a
+// batch of events that can't be inserted because a partition doesn't exist is inserted again after the partitions
+// for all of the events in the batch are created.
+func (r *DefaultRecorder) SetCreateMissingPartitions(enabled bool) {
+	r.createPartitions = enabled
+}
+
+// createMissingPartitions creates the event log partitions for the months in which the given events occurred.
+func (r DefaultRecorder) createMissingPartitions(ctx context.Context, rows []*eventRow) error {
+	created := make(map[time.Time]bool)
+	for _, row := range rows {
+		if row.event.Timestamp == nil {
+			continue
+		}
+		month := monthStart(*row.event.Timestamp)
+		if created[month] {
+			continue
+		}
+
+		opCtx, cancel := r.operationContext(ctx)
+		_, err := r.statements.execNamed(opCtx, createEventLogPartition, namedArgs{"month": month})
+		cancel()
+		if err != nil {
+			return err
+		}
+		created[month] = true
+	}
+	return nil
+}
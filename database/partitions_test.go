@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// The error reported by the database server when a row doesn't belong to any partition of a partitioned table.
+var missingPartitionErr = &pq.Error{
+	Code:    "23514",
+	Message: `no partition of relation "event_log" found for row`,
+}
+
+// TestIsMissingPartition verifies that missing partitions are detected regardless of which driver reports the error.
+func TestIsMissingPartition(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"pq", missingPartitionErr, true},
+		{"pgx", pgx.PgError{Code: "23514", Message: `no partition of relation "event_log" found for row`}, true},
+		{"check constraint", &pq.Error{Code: "23514", Message: `violates check constraint "positive_id"`}, false},
+		{"other error", fmt.Errorf("no partition of relation"), false},
+		{"nil", nil, false},
+	}
+
+	for _, test := range tests {
+		if actual := isMissingPartition(test.err); actual != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, actual)
+		}
+	}
+}
+
+// TestMonthStart verifies that the lower bound of the partition containing a time is determined correctly.
+func TestMonthStart(t *testing.T) {
+	tz := time.FixedZone("MST", -7*60*60)
+	tests := []struct {
+		name     string
+		t        time.Time
+		expected time.Time
+	}{
+		{"middle", time.Date(2019, 6, 15, 12, 30, 0, 0, time.UTC), time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"start", time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"other zone", time.Date(2019, 5, 31, 20, 0, 0, 0, tz), time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		if actual := monthStart(test.t); !actual.Equal(test.expected) {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, actual)
+		}
+	}
+}
+
+// TestCreatePartitions verifies that the partitions for upcoming months are created.
+func TestCreatePartitions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	for _, month := range []time.Month{time.November, time.December, time.January} {
+		year := 2019
+		if month == time.January {
+			year = 2020
+		}
+		mock.ExpectExec("SELECT create_event_log_partition").
+			WithArgs(time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	start := time.Date(2019, 11, 20, 0, 0, 0, 0, time.UTC)
+	if err := CreatePartitions(context.Background(), db, start, 3); err != nil {
+		t.Fatalf("error encountered while creating partitions: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestCreatePartitionedEventLog verifies that the caller is told whether or not the event log is partitioned.
+func TestCreatePartitionedEventLog(t *testing.T) {
+	for _, partitioned := range []bool{true, false} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening stub database connection: %s", err)
+		}
+
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS event_log").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(partitioned))
+
+		actual, err := CreatePartitionedEventLog(context.Background(), db)
+		if err != nil {
+			t.Errorf("%t: error encountered while creating the event log: %s", partitioned, err)
+		}
+		if actual != partitioned {
+			t.Errorf("%t: expected %t, got %t", partitioned, partitioned, actual)
+		}
+	}
+}
+
+// TestCreateMissingPartitions verifies that a missing partition is created and the event is recorded again, but only
+// if the creation of missing partitions is enabled.
+func TestCreateMissingPartitions(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening stub database connection: %s", err)
+		}
+
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		r.SetCreateMissingPartitions(enabled)
+		msg := getTestMessage()
+
+		// The first insert fails because the partition doesn't exist.
+		expectCurrentVersions(mock)
+		mock.ExpectPrepare("INSERT INTO event_log")
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO event_log").WillReturnError(missingPartitionErr)
+		mock.ExpectRollback()
+		if enabled {
+			mock.ExpectPrepare("SELECT create_event_log_partition")
+			mock.ExpectExec("SELECT create_event_log_partition").
+				WithArgs(monthStart(*msg.Timestamp.ToTime())).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectBegin()
+			mock.ExpectQuery("INSERT INTO event_log").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+			mock.ExpectCommit()
+		}
+
+		events, err := r.RecordEvents(context.Background(), []*EventRequest{{Key: ReadKey, Msg: msg}})
+		if !enabled {
+			if err == nil {
+				t.Error("disabled: an error was expected but none was encountered")
+			}
+		} else if err != nil || events[0].ID != 42 {
+			t.Errorf("enabled: unexpected result: %+v, %v", events, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%t: there were unfulfilled expectations: %s", enabled, err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// DefaultRawPayloadMaxSize is the default maximum number of bytes of a message body that are stored alongside the
+// recorded event.
+const DefaultRawPayloadMaxSize = 65536
+
+// rawPayload describes the message that produced an event, as it's stored in the event log. Message bodies that are
+// too large to be stored in their entirety are truncated and stored as strings instead, in which case the payload is
+// marked as truncated and the size of the original body is included.
+type rawPayload struct {
+	RoutingKey string          `json:"routing_key"`
+	Body       json.RawMessage `json:"body"`
+	Truncated  bool            `json:"truncated,omitempty"`
+	Size       int             `json:"size,omitempty"`
+}
+
+// truncateBody returns at most maxSize bytes from the beginning of a message body without splitting a UTF-8 encoded
+// character.
+func truncateBody(body []byte, maxSize int) string {
+	end := maxSize
+	for end > 0 && end < len(body) && !utf8.RuneStart(body[end]) {
+		end--
+	}
+	return string(body[:end])
+}
+
+// encodeRawPayload returns the payload to store for a message that was published with the given routing key.
+func encodeRawPayload(key string, body []byte, maxSize int) (*string, error) {
+	payload := rawPayload{RoutingKey: key, Body: json.RawMessage("null")}
+	if len(body) > maxSize {
+		truncated, err := json.Marshal(truncateBody(body, maxSize))
+		if err != nil {
+			return nil, err
+		}
+		payload.Body = truncated
+		payload.Truncated = true
+		payload.Size = len(body)
+	} else if len(body) > 0 {
+		payload.Body = body
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	result := string(encoded)
+	return &result, nil
+}
+
+// SetRawPayloads enables or disables storing the message that produced each event alongside the event. At most maxSize
+// bytes of each message body are stored.
+func (r *DefaultRecorder) SetRawPayloads(enabled bool, maxSize int) {
+	r.rawPayloads = enabled
+	r.rawPayloadMaxSize = maxSize
+}
+
+// rawPayloadFor returns the payload to store alongside the event for a message, or nil if payloads aren't stored.
+func (r DefaultRecorder) rawPayloadFor(key string, msg *model.Message) (*string, error) {
+	if !r.rawPayloads {
+		return nil, nil
+	}
+	return encodeRawPayload(key, msg.Raw, r.rawPayloadMaxSize)
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestEncodeRawPayload verifies that messages are encoded for storage correctly, and that large message bodies are
+// truncated.
+func TestEncodeRawPayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		maxSize  int
+		expected string
+	}{
+		{"small", `{"path": "/foo"}`, 64, `{"routing_key":"data-object.open","body":{"path":"/foo"}}`},
+		{"empty", "", 64, `{"routing_key":"data-object.open","body":null}`},
+		{
+			"truncated",
+			`{"path": "/foo"}`,
+			8,
+			`{"routing_key":"data-object.open","body":"{\"path\":","truncated":true,"size":16}`,
+		},
+		{
+			"multi-byte character",
+			`{"path": "/é"}`,
+			12,
+			`{"routing_key":"data-object.open","body":"{\"path\": \"/","truncated":true,"size":15}`,
+		},
+	}
+
+	for _, test := range tests {
+		payload, err := encodeRawPayload(ReadKey, []byte(test.body), test.maxSize)
+		if err != nil {
+			t.Errorf("%s: error encountered while encoding payload: %s", test.name, err)
+			continue
+		}
+		if *payload != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, *payload)
+		}
+	}
+}
+
+// TestRecordRawPayload verifies that the message that produced an event is stored alongside the event if raw payloads
+// are enabled.
+func TestRecordRawPayload(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetRawPayloads(true, DefaultRawPayloadMaxSize)
+	msg, err := model.Decode([]byte(`{"entity": "fakeid", "path": "/foo"}`))
+	if err != nil {
+		t.Fatalf("error encountered while decoding message: %s", err)
+	}
+
+	expected := `{"routing_key":"data-object.open","body":{"entity":"fakeid","path":"/foo"}}`
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare("INSERT INTO event_log \\(.*, raw_payload\\)")
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").
+		WithArgs(msg.Entity, msg.Path, ETRead, sqlmock.AnyArg(), r.GetNodeID(), expected).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectCommit()
+
+	if _, err := r.RecordEvent(context.Background(), ReadKey, msg); err != nil {
+		t.Fatalf("error encountered while recording event: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// The statement used to store a permission that was granted on a registered data object. Each user or group has at
+// most one access policy for each object. Changes are only applied if they're at least as recent as the last change,
+// so that a change that's processed out of order doesn't overwrite a more recent one.
+var upsertAccessPolicy = named(`
+INSERT INTO access_policies (permanent_id, subject, permission, updated_at)
+SELECT permanent_id, :subject::text, :permission::text, :changed_at::timestamp with time zone
+FROM data_objects
+WHERE ` + dataObjectCondition + `
+ON CONFLICT (permanent_id, subject) DO UPDATE SET
+    permission = excluded.permission,
+    updated_at = excluded.updated_at
+WHERE access_policies.updated_at <= excluded.updated_at;
+`)
+
+// The statement used to remove a permission that was revoked from a registered data object.
+var deleteAccessPolicy = named(`
+DELETE FROM access_policies
+WHERE subject = :subject::text
+AND updated_at <= :changed_at::timestamp with time zone
+AND permanent_id IN (SELECT permanent_id FROM data_objects WHERE ` + dataObjectCondition + `);
+`)
+
+// updateAccessPolicy stores or removes the access policy of a user or group for a registered data object, and flags
+// the object so that its system metadata is generated again. The return value indicates whether or not a registered
+// object was found.
+func updateAccessPolicy(
+	ctx context.Context, q queryer, permanentID, path, subject, permission string, changedAt time.Time,
+) (bool, error) {
+	found, err := markStale(ctx, q, permanentID, path, changedAt)
+	if err != nil || !found {
+		return found, err
+	}
+
+	statement := upsertAccessPolicy
+	if permission == model.PermissionNull {
+		statement = deleteAccessPolicy
+	}
+	values := namedArgs{
+		"entity_uuid":  uuidArg(model.ParseUUID(permanentID)),
+		"permanent_id": permanentID,
+		"irods_path":   path,
+		"subject":      subject,
+		"permission":   permission,
+		"changed_at":   changedAt,
+	}
+	if _, err := execNamed(ctx, q, statement, values); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UpdateAccessPolicy records that the permission of a user or group on a registered data object changed at the given
+// time. The subject is the qualified name of the user or group, and a permission of model.PermissionNull revokes the
+// subject's access policy. The object is identified by its permanent identifier if it's not empty, and by its path
+// otherwise. The return value is false if the object isn't registered.
+func (r DefaultRecorder) UpdateAccessPolicy(
+	ctx context.Context, permanentID, path, subject, permission string, changedAt time.Time,
+) (bool, error) {
+	ctx, cancel := r.operationContext(ctx)
+	defer cancel()
+	tx, err := r.db.BeginTx(ctx, r.txOptions())
+	if err != nil {
+		return false, classifyError(err)
+	}
+	defer tx.Rollback()
+
+	found, err := updateAccessPolicy(ctx, tx, permanentID, path, subject, permission, changedAt)
+	if err != nil {
+		return false, classifyError(err)
+	}
+	return found, classifyError(tx.Commit())
+}
+
+// recordPermissionChange is the function that DefaultRecorder uses to record changes to the permissions of data
+// objects in the repository. Changes to objects that were never registered are skipped. Permission changes aren't
+// recorded in the event log, so no event is returned.
+func recordPermissionChange(
+	ctx context.Context, tx *sql.Tx, r Recorder, key string, msg *model.Message,
+) (*Event, error) {
+	if msg.Grantee == nil {
+		return nil, fmt.Errorf("permission change message for path '%s' doesn't identify the user or group", msg.Path)
+	}
+	subject := msg.Grantee.String()
+	found, err := updateAccessPolicy(ctx, tx, msg.Entity, msg.Path, subject, msg.Permission, *messageTime(msg))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		logger.Log.Debugf("skipping the permission change for unregistered data object at '%s'", msg.Path)
+	}
+	return nil, nil
+}
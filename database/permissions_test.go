@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// getPermissionMessage returns a message describing a permission change that can be used for testing.
+func getPermissionMessage(changed time.Time, permission string) *model.Message {
+	msg := getTimestampedMessage(changed)
+	msg.Grantee = &model.User{Name: "somegroup", Zone: "iplant"}
+	msg.Permission = permission
+	return msg
+}
+
+// TestRecordPermissionChange verifies that granted permissions are stored, that revoked permissions are removed, that
+// the data object is flagged in both cases, and that changes to unregistered objects are skipped without an error.
+func TestRecordPermissionChange(t *testing.T) {
+	changed := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	object := getTestMessage()
+	tests := []struct {
+		name       string
+		permission string
+		registered bool
+		statement  string
+		args       []driver.Value
+	}{
+		{
+			"grant", model.PermissionRead, true, "INSERT INTO access_policies",
+			[]driver.Value{"somegroup#iplant", model.PermissionRead, changed, object.UUID, object.Entity, object.Path},
+		},
+		{
+			"revocation", model.PermissionNull, true, "DELETE FROM access_policies",
+			[]driver.Value{"somegroup#iplant", changed, object.UUID, object.Entity, object.Path},
+		},
+		{"unregistered", model.PermissionRead, false, "", nil},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("%s: error opening stub database connection: %s", test.name, err)
+		}
+		r := getTestRecorder(db)
+		msg := getPermissionMessage(changed, test.permission)
+
+		var affected int64
+		if test.registered {
+			affected = 1
+		}
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE data_objects SET irods_path").
+			WithArgs(msg.Path, changed, msg.UUID).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE data_objects SET modified_at").
+			WithArgs(changed, msg.UUID, msg.Entity, msg.Path).
+			WillReturnResult(sqlmock.NewResult(0, affected))
+		if test.registered {
+			mock.ExpectExec(test.statement).
+				WithArgs(test.args...).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectCommit()
+
+		event, err := r.RecordEvent(context.Background(), PermissionKey, msg)
+		if err != nil {
+			t.Errorf("%s: error encountered while recording permission change: %s", test.name, err)
+		}
+		if event != nil {
+			t.Errorf("%s: expected no event but got %+v", test.name, event)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+		db.Close()
+	}
+}
+
+// TestUpdateAccessPolicy verifies that the recorder updates access policies in a single transaction.
+func TestUpdateAccessPolicy(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	changed := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE data_objects").
+		WithArgs(changed, nil, "fakeid", "/foo/bar").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO access_policies").
+		WithArgs("ipcdev#iplant", model.PermissionOwn, changed, nil, "fakeid", "/foo/bar").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	found, err := r.UpdateAccessPolicy(
+		context.Background(), "fakeid", "/foo/bar", "ipcdev#iplant", model.PermissionOwn, changed,
+	)
+	if err != nil {
+		t.Errorf("error encountered while updating the access policy: %s", err)
+	}
+	if !found {
+		t.Error("expected the data object to be found")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestPermissionDrivers verifies that permission changes are recorded with each of the supported drivers: a grant
+// followed by a change of level leaves a single access policy, and a later revocation removes it.
+func TestPermissionDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := getTestRecorder(db)
+		ctx := context.Background()
+
+		added := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+		addition := getAddMessage(added, 1024, "sha2:fakechecksum")
+		if _, err := r.RecordEvent(ctx, AddKey, addition); err != nil {
+			t.Fatalf("%s: error encountered while recording addition: %s", driver, err)
+		}
+		grant := getPermissionMessage(added.Add(time.Hour), model.PermissionRead)
+		change := getPermissionMessage(added.Add(2*time.Hour), model.PermissionWrite)
+		change.Entity = ""
+		for _, msg := range []*model.Message{grant, change} {
+			if _, err := r.RecordEvent(ctx, PermissionKey, msg); err != nil {
+				t.Fatalf("%s: error encountered while recording permission change: %s", driver, err)
+			}
+		}
+
+		var permission string
+		query := "SELECT permission FROM access_policies WHERE permanent_id = $1 AND subject = $2"
+		if err := db.QueryRow(query, addition.Entity, "somegroup#iplant").Scan(&permission); err != nil {
+			t.Errorf("%s: unable to look up the access policy: %s", driver, err)
+		} else if permission != model.PermissionWrite {
+			t.Errorf("%s: unexpected permission: %s", driver, permission)
+		}
+
+		revocation := getPermissionMessage(added.Add(3*time.Hour), model.PermissionNull)
+		if _, err := r.RecordEvent(ctx, PermissionKey, revocation); err != nil {
+			t.Fatalf("%s: error encountered while recording revocation: %s", driver, err)
+		}
+		var count int
+		if err := db.QueryRow("SELECT count(*) FROM access_policies").Scan(&count); err != nil {
+			t.Errorf("%s: unable to count the access policies: %s", driver, err)
+		} else if count != 0 {
+			t.Errorf("%s: expected the access policy to be removed but found %d", driver, count)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jackc/pgx/stdlib"
+)
+
+// The types of the parameters of the statement used to add an event to the database. The types have to be specified
+// when a statement that hasn't been prepared is sent to the server as part of a batch.
+var addEventParameterOIDs = []pgtype.OID{
+	pgtype.TextOID, pgtype.TextOID, pgtype.TextOID, pgtype.TimestamptzOID, pgtype.TextOID,
+}
+
+// The types of the parameters of the statement used to add an event to the database along with the message that
+// produced it.
+var addEventWithPayloadParameterOIDs = []pgtype.OID{
+	pgtype.TextOID, pgtype.TextOID, pgtype.TextOID, pgtype.TimestamptzOID, pgtype.TextOID, pgtype.JSONBOID,
+}
+
+// The types of the parameters of the statement used to add an event to the database along with its idempotency key.
+var addKeyedEventParameterOIDs = []pgtype.OID{
+	pgtype.TextOID, pgtype.TextOID, pgtype.TextOID, pgtype.TimestamptzOID, pgtype.TextOID, pgtype.JSONBOID,
+	pgtype.TextOID,
+}
+
+// The types of the parameters of the statement used to add an event to the database along with the details taken from
+// the message that produced it.
+var addDetailedEventParameterOIDs = []pgtype.OID{
+	pgtype.TextOID, pgtype.TextOID, pgtype.TextOID, pgtype.TimestamptzOID, pgtype.TextOID, pgtype.JSONBOID,
+	pgtype.TextOID, pgtype.TextOID, pgtype.Int8OID, pgtype.TextOID, pgtype.InetOID, pgtype.TextOID, pgtype.TextOID,
+	pgtype.Int8OID, pgtype.TextOID, pgtype.TextOID,
+}
+
+// The format of the identifier returned by the statement used to add an event to the database.
+var addEventResultFormats = []int16{pgx.BinaryFormatCode}
+
+// pgxIsolationLevels maps the supported database/sql isolation levels to the corresponding pgx isolation levels.
+var pgxIsolationLevels = map[sql.IsolationLevel]pgx.TxIsoLevel{
+	sql.LevelReadCommitted:  pgx.ReadCommitted,
+	sql.LevelRepeatableRead: pgx.RepeatableRead,
+	sql.LevelSerializable:   pgx.Serializable,
+}
+
+// usesPgx determines whether or not a database connection pool was opened with the pgx driver.
+func usesPgx(db *sql.DB) bool {
+	_, ok := db.Driver().(*stdlib.Driver)
+	return ok
+}
+
+// pgxTxOptions returns the pgx transaction options corresponding to an isolation level.
+func pgxTxOptions(isolation sql.IsolationLevel) *pgx.TxOptions {
+	level, ok := pgxIsolationLevels[isolation]
+	if !ok {
+		return nil
+	}
+	return &pgx.TxOptions{IsoLevel: level}
+}
+
+// sendBatches inserts rows into the event log using the pgx batch API. The inserts are sent to the server in as few
+// round trips as possible, and they're all performed in a single transaction along with the updates to the summary
+// tables. The identifier of each new row is stored in the corresponding event. The first return value indicates
+// whether or not the failure, if any, occurred while the transaction was being committed.
+func sendBatches(
+	ctx context.Context, db *sql.DB, isolation sql.IsolationLevel, rows []*eventRow, summaries summaryFunc,
+) (bool, error) {
+	conn, err := stdlib.AcquireConn(db)
+	if err != nil {
+		return false, err
+	}
+	defer stdlib.ReleaseConn(db, conn)
+
+	tx, err := conn.BeginEx(ctx, pgxTxOptions(isolation))
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Sending too many statements at once can cause a deadlock, so the statements are sent in chunks.
+	for _, chunk := range insertChunks(rows) {
+		if err := sendBatch(ctx, tx, chunk); err != nil {
+			return false, err
+		}
+	}
+	if err := summaries(ctx, pgxExec(tx), rows); err != nil {
+		return false, err
+	}
+	return true, tx.CommitEx(ctx)
+}
+
+// pgxExec returns a function that executes statements within a pgx transaction.
+func pgxExec(tx *pgx.Tx) execFunc {
+	return func(ctx context.Context, query string, args ...interface{}) error {
+		start := time.Now()
+		_, err := tx.ExecEx(ctx, query, nil, args...)
+		logQuery(query, args, time.Since(start), err)
+		return err
+	}
+}
+
+// addEventStatement returns the statement used to add the event in a row to the database when it's sent as part of a
+// batch, along with the types of the statement's parameters and its arguments.
+func addEventStatement(row *eventRow) (*namedStatement, []pgtype.OID, []interface{}, error) {
+	e := row.event
+	values := namedArgs{
+		"permanent_id":    row.entity,
+		"irods_path":      e.Path,
+		"event":           e.Type,
+		"date_logged":     e.Timestamp,
+		"node_identifier": e.NodeID,
+	}
+	var payload, key interface{}
+	if row.payload != nil {
+		payload = *row.payload
+	}
+	if row.key != nil {
+		key = *row.key
+	}
+	stmt, oids := addEvent, addEventParameterOIDs
+	switch {
+	case row.details != nil:
+		values["raw_payload"] = payload
+		values["idempotency_key"] = key
+		columns := []string{
+			"subject", "file_size", "checksum", "ip_address", "ip_address_raw", "user_agent", "bytes_read", "zone",
+			"resource",
+		}
+		for i, value := range row.details.values() {
+			values[columns[i]] = value
+		}
+		stmt, oids = addDetailedEvent, addDetailedEventParameterOIDs
+	case row.key != nil:
+		values["raw_payload"] = payload
+		values["idempotency_key"] = key
+		stmt, oids = addKeyedEvent, addKeyedEventParameterOIDs
+	case row.payload != nil:
+		values["raw_payload"] = row.payload
+		stmt, oids = addEventWithPayload, addEventWithPayloadParameterOIDs
+	}
+	args, err := stmt.bind(values)
+	return stmt, oids, args, err
+}
+
+// sendBatch sends the inserts for a chunk of rows to the server as a single batch within a transaction.
+func sendBatch(ctx context.Context, tx *pgx.Tx, rows []*eventRow) error {
+	batch := tx.BeginBatch()
+	statements := make([]loggedStatement, len(rows))
+	for i, row := range rows {
+		stmt, oids, args, err := addEventStatement(row)
+		if err != nil {
+			batch.Close()
+			return err
+		}
+		batch.Queue(stmt.query, args, oids, addEventResultFormats)
+		statements[i] = loggedStatement{query: stmt.query, args: args}
+	}
+
+	start := time.Now()
+	err := readBatchResults(ctx, batch, rows)
+	logStatements(statements, time.Since(start), err)
+	if err != nil {
+		batch.Close()
+		return err
+	}
+	return batch.Close()
+}
+
+// readBatchResults sends a batch of inserts to the server and records the identifiers of the new rows. Nothing is
+// returned for an event with an idempotency key if it had already been recorded.
+func readBatchResults(ctx context.Context, batch *pgx.Batch, rows []*eventRow) error {
+	if err := batch.Send(ctx, nil); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err := batch.QueryRowResults().Scan(&row.event.ID)
+		if row.key != nil && (err == nil || err == pgx.ErrNoRows) {
+			markRecorded(row, err == nil)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
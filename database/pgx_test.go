@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx"
+)
+
+// TestPgxTxOptions verifies that isolation levels are translated to pgx transaction options correctly.
+func TestPgxTxOptions(t *testing.T) {
+	tests := []struct {
+		level    sql.IsolationLevel
+		expected pgx.TxIsoLevel
+	}{
+		{sql.LevelDefault, ""},
+		{sql.LevelReadCommitted, pgx.ReadCommitted},
+		{sql.LevelRepeatableRead, pgx.RepeatableRead},
+		{sql.LevelSerializable, pgx.Serializable},
+	}
+
+	for _, test := range tests {
+		opts := pgxTxOptions(test.level)
+		var actual pgx.TxIsoLevel
+		if opts != nil {
+			actual = opts.IsoLevel
+		}
+		if actual != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.level, test.expected, actual)
+		}
+	}
+}
+
+// The statements used to create temporary tables that hide the permanent tables during integration tests.
+const createTestTables = `
+CREATE TEMPORARY TABLE event_log (
+    id bigserial PRIMARY KEY,
+    permanent_id text,
+    irods_path text NOT NULL,
+    event text NOT NULL,
+    date_logged timestamp with time zone NOT NULL,
+    node_identifier text NOT NULL,
+    raw_payload jsonb,
+    idempotency_key text,
+    subject text,
+    file_size bigint,
+    checksum text,
+    ip_address inet,
+    ip_address_raw text,
+    user_agent text,
+    bytes_read bigint,
+    zone text,
+    resource text
+);
+
+CREATE UNIQUE INDEX ON event_log (idempotency_key, date_logged);
+
+CREATE TEMPORARY TABLE quarantine (
+    id bigserial PRIMARY KEY,
+    routing_key text NOT NULL,
+    body bytea NOT NULL,
+    error text NOT NULL,
+    received_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE TEMPORARY TABLE event_errors (
+    id bigserial PRIMARY KEY,
+    routing_key text NOT NULL,
+    body bytea NOT NULL,
+    error text NOT NULL,
+    attempts integer NOT NULL,
+    first_failed_at timestamp with time zone NOT NULL,
+    last_failed_at timestamp with time zone NOT NULL,
+    resolved_at timestamp with time zone
+);
+
+CREATE TEMPORARY TABLE event_rollups (
+    permanent_id text NOT NULL,
+    event text NOT NULL,
+    day date NOT NULL,
+    count bigint NOT NULL,
+    PRIMARY KEY (permanent_id, event, day)
+);
+
+CREATE TEMPORARY TABLE object_access (
+    permanent_id text PRIMARY KEY,
+    last_accessed timestamp with time zone NOT NULL
+);
+
+CREATE TEMPORARY TABLE data_objects (
+    permanent_id text PRIMARY KEY,
+    irods_path text NOT NULL,
+    node_identifier text NOT NULL,
+    archived boolean NOT NULL DEFAULT false,
+    archived_at timestamp with time zone,
+    updated_at timestamp with time zone NOT NULL,
+    file_size bigint,
+    checksum text,
+    creator text,
+    created_at timestamp with time zone,
+    modified_at timestamp with time zone,
+    needs_resync boolean NOT NULL DEFAULT false,
+    checksum_algorithm text,
+    serial_version integer NOT NULL DEFAULT 1,
+    entity_uuid uuid UNIQUE,
+    rights_holder text,
+    authoritative_member_node text,
+    system_metadata_pending boolean NOT NULL DEFAULT false,
+    obsoletes text UNIQUE REFERENCES data_objects (permanent_id),
+    obsoleted_by text UNIQUE REFERENCES data_objects (permanent_id),
+    needs_attention boolean NOT NULL DEFAULT false,
+    sync_failure text,
+    sync_failed_at timestamp with time zone,
+    synchronized_at timestamp with time zone,
+    CHECK (obsoletes <> permanent_id AND obsoleted_by <> permanent_id)
+);
+
+CREATE TEMPORARY TABLE access_policies (
+    permanent_id text NOT NULL,
+    subject text NOT NULL,
+    permission text NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (permanent_id, subject)
+);
+
+CREATE TEMPORARY TABLE replica_status (
+    permanent_id text NOT NULL,
+    target_node text NOT NULL,
+    status text NOT NULL,
+    failure_reason text,
+    failures integer NOT NULL DEFAULT 0,
+    verified_at timestamp with time zone,
+    updated_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (permanent_id, target_node)
+);
+`
+
+// openTestDatabase opens a connection to the PostgreSQL database identified by the DATAONE_TEST_DB_URI environment
+// variable using the given driver, skipping the test if the variable isn't set. The connection pool is limited to a
+// single connection on which temporary tables are created, so the permanent tables aren't modified.
+func openTestDatabase(t *testing.T, driver string) *sql.DB {
+	uri := os.Getenv("DATAONE_TEST_DB_URI")
+	if uri == "" {
+		t.Skip("DATAONE_TEST_DB_URI is not set")
+	}
+	db, err := sql.Open(driver, uri)
+	if err != nil {
+		t.Fatalf("%s: error opening database connection: %s", driver, err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(createTestTables); err != nil {
+		db.Close()
+		t.Fatalf("%s: unable to create the temporary tables: %s", driver, err)
+	}
+	return db
+}
+
+// TestRecorderDrivers runs the recorder against a real database with each of the supported drivers.
+func TestRecorderDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		if r.pgx != (driver == "pgx") {
+			t.Errorf("%s: the pgx batch API should be used only with the pgx driver", driver)
+		}
+		ctx := context.Background()
+
+		// Record a batch of events.
+		requests := []*EventRequest{
+			{Key: ReadKey, Msg: getTestMessage()},
+			{Key: "data-object.unknown", Msg: getTestMessage()},
+			{Key: LegacyReadKey, Msg: getTestMessage()},
+		}
+		events, err := r.RecordEvents(ctx, requests)
+		if err != nil {
+			t.Fatalf("%s: error encountered while recording events: %s", driver, err)
+		}
+		if events[0].ID == 0 || events[1] != nil || events[2].ID <= events[0].ID {
+			t.Errorf("%s: unexpected events returned: %+v", driver, events)
+		}
+
+		// Record a single event with its raw payload, quarantine a message and store a failed message.
+		msg := getTestMessage()
+		msg.Raw = []byte(`{"path": "/foo"}`)
+		r.SetRawPayloads(true, DefaultRawPayloadMaxSize)
+		if _, err := r.RecordEvent(ctx, ReadKey, msg); err != nil {
+			t.Errorf("%s: error encountered while recording event: %s", driver, err)
+		}
+		if err := r.RecordQuarantine(ctx, ReadKey, []byte("{not json"), "invalid message"); err != nil {
+			t.Errorf("%s: error encountered while quarantining message: %s", driver, err)
+		}
+		if err := r.RecordFailure(ctx, ReadKey, []byte("{}"), "connection refused", 3); err != nil {
+			t.Errorf("%s: error encountered while storing failed message: %s", driver, err)
+		}
+
+		// Verify that the rows were stored.
+		var count int
+		if err := db.QueryRow("SELECT count(*) FROM event_log").Scan(&count); err != nil || count != 3 {
+			t.Errorf("%s: expected 3 events to be stored, found %d (error: %v)", driver, count, err)
+		}
+		query := "SELECT count(*) FROM event_log WHERE raw_payload->>'routing_key' = $1"
+		if err := db.QueryRow(query, ReadKey).Scan(&count); err != nil || count != 1 {
+			t.Errorf("%s: expected 1 raw payload to be stored, found %d (error: %v)", driver, count, err)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// SetPreviewEventType sets the type of the events recorded for previews of data objects, which are described either
+// by messages with preview routing keys or by read messages with the preview access type. Previews are recorded as
+// read events if the event type is empty.
+func (r *DefaultRecorder) SetPreviewEventType(eventType string) {
+	r.previewEventType = eventType
+}
+
+// previewTyper is implemented by recorders that may record previews under their own event type.
+type previewTyper interface {
+	previewType() string
+}
+
+// previewType returns the type of the events recorded for previews, or an empty string if previews are recorded as
+// read events.
+func (r DefaultRecorder) previewType() string {
+	return r.previewEventType
+}
+
+// previewEventType returns the type of the events that a recorder records for previews.
+func previewEventType(r Recorder) string {
+	if p, ok := r.(previewTyper); ok && p.previewType() != "" {
+		return p.previewType()
+	}
+	return ETRead
+}
+
+// readEventType returns the type of the event that a recorder records for a read message, which depends on whether
+// or not the message describes a preview.
+func readEventType(r Recorder, msg *model.Message) string {
+	if msg.IsPreview() {
+		return previewEventType(r)
+	}
+	return ETRead
+}
+
+// buildPreviewKeys returns the set of routing keys whose messages describe previews.
+func buildPreviewKeys(keyNames *KeyNames) map[string]bool {
+	keys := make(map[string]bool)
+	for _, key := range keyNames.Preview {
+		keys[key] = true
+	}
+	return keys
+}
+
+// eventTypeFor returns the type of the event that the recorder inserts for a message with a routing key that matches
+// the given pattern. The second return value is false if events for the pattern are recorded by their handlers rather
+// than inserted by the recorder.
+func (r DefaultRecorder) eventTypeFor(pattern string, msg *model.Message) (string, bool) {
+	if r.previewKeys[pattern] {
+		return previewEventType(r), true
+	}
+	eventType, ok := r.eventTypes[pattern]
+	if ok && eventType == ETRead {
+		return readEventType(r, msg), true
+	}
+	return eventType, ok
+}
+
+// recordPreviewEvent is the function that DefaultRecorder uses to record previews of data objects that have their own
+// routing keys.
+func recordPreviewEvent(ctx context.Context, tx *sql.Tx, r Recorder, key string, msg *model.Message) (*Event, error) {
+	event := newEvent(r, previewEventType(r), msg)
+	rows := []*eventRow{{entity: msg.Entity, event: event}}
+	if err := insertEvents(ctx, tx, statementsFor(r), rows); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
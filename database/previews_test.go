@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestPreviewEventTypes verifies that previews are recorded under the preview event type if there is one and as read
+// events otherwise, whether they're identified by their routing keys or by their access types.
+func TestPreviewEventTypes(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		accessType string
+		configured string
+		expected   string
+	}{
+		{"download", ReadKey, "", "PREVIEW", ETRead},
+		{"explicit download", ReadKey, model.AccessTypeDownload, "PREVIEW", ETRead},
+		{"preview access type", ReadKey, model.AccessTypePreview, "PREVIEW", "PREVIEW"},
+		{"preview routing key", PreviewKey, "", "PREVIEW", "PREVIEW"},
+		{"preview access type recorded as read", ReadKey, model.AccessTypePreview, "", ETRead},
+		{"preview routing key recorded as read", PreviewKey, "", "", ETRead},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening stub database connection: %s", err)
+		}
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		r.SetPreviewEventType(test.configured)
+		msg := getTestMessage()
+		msg.AccessType = test.accessType
+
+		expectCurrentVersions(mock)
+		mock.ExpectPrepare("INSERT INTO event_log")
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO event_log").
+			WithArgs(msg.Entity, msg.Path, test.expected, msg.Timestamp.ToTime(), r.GetNodeID()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+		mock.ExpectCommit()
+
+		event, err := r.RecordEvent(context.Background(), test.key, msg)
+		if err != nil {
+			t.Errorf("%s: error encountered while recording event: %s", test.name, err)
+		} else if event == nil || event.Type != test.expected {
+			t.Errorf("%s: expected a %s event but got %+v", test.name, test.expected, event)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+		db.Close()
+	}
+}
+
+// TestPreviewHandler verifies that the handler for preview routing keys records previews under the preview event type.
+func TestPreviewHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetPreviewEventType("PREVIEW")
+	msg := getTestMessage()
+
+	mock.ExpectBegin()
+	// The insert is prepared on the connection pool and then again on the transaction's connection.
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectQuery("INSERT INTO event_log").
+		WithArgs(msg.Entity, msg.Path, "PREVIEW", msg.Timestamp.ToTime(), r.GetNodeID()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("unable to begin the transaction: %s", err)
+	}
+	event, err := r.GetHandlerMap().Find(PreviewKey)(context.Background(), tx, r, PreviewKey, msg)
+	if err != nil {
+		t.Errorf("error encountered while recording event: %s", err)
+	} else if event.Type != "PREVIEW" {
+		t.Errorf("expected a preview event but got %+v", event)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
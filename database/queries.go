@@ -11,8 +11,91 @@ const (
 	ETUpdate                = "UPDATE"
 )
 
-// The statement used to add an event to the database.
-const addEvent = `
+// The statement used to add an event to the database. The identifier of the new row is returned.
+var addEvent = named(`
 INSERT INTO event_log (permanent_id, irods_path, event, date_logged, node_identifier)
-VALUES ($1, $2, $3, $4, $5);
+VALUES (:permanent_id, :irods_path, :event, :date_logged, :node_identifier)
+RETURNING id;
+`)
+
+// The statement used to add an event to the database along with the message that produced it. The identifier of the
+// new row is returned.
+var addEventWithPayload = named(`
+INSERT INTO event_log (permanent_id, irods_path, event, date_logged, node_identifier, raw_payload)
+VALUES (:permanent_id, :irods_path, :event, :date_logged, :node_identifier, :raw_payload)
+RETURNING id;
+`)
+
+// The beginning of the statement used to add several events to the database at once. The values for each event are
+// appended as positional parameters, followed by addEventsSuffix. The identifiers of the new rows are returned in the
+// order of the values.
+const addEventsPrefix = `
+INSERT INTO event_log (permanent_id, irods_path, event, date_logged, node_identifier)
+VALUES `
+
+// The beginning of the statement used to add several events to the database at once along with the messages that
+// produced them. It's used in the same way as addEventsPrefix.
+const addEventsWithPayloadsPrefix = `
+INSERT INTO event_log (permanent_id, irods_path, event, date_logged, node_identifier, raw_payload)
+VALUES `
+
+// The end of the statement used to add several events to the database at once.
+const addEventsSuffix = `
+RETURNING id;
 `
+
+// The statement used to add an event to the database along with its idempotency key and the message that produced it,
+// if it's stored. Nothing is inserted if an event with the same idempotency key has already been recorded, in which
+// case no identifier is returned.
+var addKeyedEvent = named(`
+INSERT INTO event_log (permanent_id, irods_path, event, date_logged, node_identifier, raw_payload, idempotency_key)
+VALUES (:permanent_id, :irods_path, :event, :date_logged, :node_identifier, :raw_payload, :idempotency_key)
+ON CONFLICT (idempotency_key, date_logged) DO NOTHING
+RETURNING id;
+`)
+
+// The beginning of the statement used to add several events to the database at once along with their idempotency keys
+// and the messages that produced them. The values for each event are appended, followed by addKeyedEventsSuffix.
+const addKeyedEventsPrefix = `
+INSERT INTO event_log (permanent_id, irods_path, event, date_logged, node_identifier, raw_payload, idempotency_key)
+VALUES `
+
+// The end of the statement used to add several events to the database at once along with their idempotency keys.
+// Events whose idempotency keys match events that have already been recorded are skipped, so the identifiers of the
+// new rows are returned along with their keys in the order of the values.
+const addKeyedEventsSuffix = `
+ON CONFLICT (idempotency_key, date_logged) DO NOTHING
+RETURNING id, idempotency_key;
+`
+
+// The statement used to add an event to the database along with the details taken from the message that produced it,
+// its idempotency key and the message itself. The idempotency key and message may be null. Nothing is inserted if an
+// event with the same idempotency key has already been recorded, in which case no identifier is returned.
+var addDetailedEvent = named(`
+INSERT INTO event_log (
+    permanent_id, irods_path, event, date_logged, node_identifier, raw_payload, idempotency_key,
+    subject, file_size, checksum, ip_address, ip_address_raw, user_agent, bytes_read, zone, resource
+)
+VALUES (
+    :permanent_id, :irods_path, :event, :date_logged, :node_identifier, :raw_payload, :idempotency_key,
+    :subject, :file_size, :checksum, :ip_address, :ip_address_raw, :user_agent, :bytes_read, :zone, :resource
+)
+ON CONFLICT (idempotency_key, date_logged) DO NOTHING
+RETURNING id;
+`)
+
+// The beginning of the statement used to add several events to the database at once along with the details taken from
+// the messages that produced them. It's used in the same way as addKeyedEventsPrefix, and it's followed by
+// addKeyedEventsSuffix.
+const addDetailedEventsPrefix = `
+INSERT INTO event_log (
+    permanent_id, irods_path, event, date_logged, node_identifier, raw_payload, idempotency_key,
+    subject, file_size, checksum, ip_address, ip_address_raw, user_agent, bytes_read, zone, resource
+)
+VALUES `
+
+// The statement used to add a message that could not be processed to the quarantine table.
+var addQuarantinedMessage = named(`
+INSERT INTO quarantine (routing_key, body, error, received_at)
+VALUES (:routing_key, :body, :error, :received_at);
+`)
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// QueryLogSettings describes how the statements executed by the database package are logged. If Enabled is true,
+// every statement is logged at the debug level along with its arguments and duration. Arguments longer than
+// MaxValueLength bytes are elided; zero means that they're never elided. Statements that take at least SlowThreshold
+// are logged at the warn level even if query logging is disabled; zero means that slow statements aren't logged.
+type QueryLogSettings struct {
+	Enabled        bool
+	MaxValueLength int
+	SlowThreshold  time.Duration
+}
+
+// The settings that are currently used to log statements.
+var (
+	queryLogMutex    sync.RWMutex
+	queryLogSettings QueryLogSettings
+)
+
+// SetQueryLogging determines how statements are logged. It applies to every recorder and to the functions in this
+// package that use a database connection directly.
+func SetQueryLogging(settings QueryLogSettings) {
+	queryLogMutex.Lock()
+	defer queryLogMutex.Unlock()
+	queryLogSettings = settings
+}
+
+// currentQueryLogSettings returns the settings that are currently used to log statements.
+func currentQueryLogSettings() QueryLogSettings {
+	queryLogMutex.RLock()
+	defer queryLogMutex.RUnlock()
+	return queryLogSettings
+}
+
+// queryLogLevel returns the level at which a statement that took the given amount of time should be logged. The
+// second return value is false if the statement shouldn't be logged.
+func queryLogLevel(settings QueryLogSettings, elapsed time.Duration) (logrus.Level, bool) {
+	switch {
+	case settings.SlowThreshold > 0 && elapsed >= settings.SlowThreshold:
+		return logrus.WarnLevel, true
+	case settings.Enabled:
+		return logrus.DebugLevel, true
+	default:
+		return logrus.DebugLevel, false
+	}
+}
+
+// compactQuery collapses the whitespace in a statement so that it fits on one line.
+func compactQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// formatValue formats a statement argument for logging. Strings and byte slices longer than the maximum length are
+// elided.
+func formatValue(value interface{}, maxLength int) string {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case *string:
+		if v == nil {
+			return "NULL"
+		}
+		s = *v
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case *time.Time:
+		if v == nil {
+			return "NULL"
+		}
+		return v.Format(time.RFC3339Nano)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+	if maxLength > 0 && len(s) > maxLength {
+		return fmt.Sprintf("%s... (%d bytes)", strconv.Quote(truncateBody([]byte(s), maxLength)), len(s))
+	}
+	return strconv.Quote(s)
+}
+
+// formatArgs formats the arguments of a statement for logging, identifying each by its position.
+func formatArgs(args []interface{}, maxLength int) string {
+	formatted := make([]string, len(args))
+	for i, arg := range args {
+		formatted[i] = fmt.Sprintf("$%d=%s", i+1, formatValue(arg, maxLength))
+	}
+	return "[" + strings.Join(formatted, ", ") + "]"
+}
+
+// loggedStatement is a statement that was executed, along with its arguments.
+type loggedStatement struct {
+	query string
+	args  []interface{}
+}
+
+// format formats the statement for logging.
+func (s loggedStatement) format(maxLength int) string {
+	return fmt.Sprintf("%s with arguments %s", compactQuery(s.query), formatArgs(s.args, maxLength))
+}
+
+// logQuery logs a statement that was executed, along with its arguments, its duration and the error that it produced,
+// if any, according to the current query log settings.
+func logQuery(query string, args []interface{}, elapsed time.Duration, err error) {
+	logStatements([]loggedStatement{{query: query, args: args}}, elapsed, err)
+}
+
+// logStatements logs a group of statements that were sent to the server together in a single message, so that a slow
+// batch of statements is only reported once.
+func logStatements(statements []loggedStatement, elapsed time.Duration, err error) {
+	settings := currentQueryLogSettings()
+	level, ok := queryLogLevel(settings, elapsed)
+	if !ok {
+		return
+	}
+
+	formatted := make([]string, len(statements))
+	for i, s := range statements {
+		formatted[i] = s.format(settings.MaxValueLength)
+	}
+	msg := fmt.Sprintf("executed %s in %s", strings.Join(formatted, "; "), elapsed)
+	if len(statements) != 1 {
+		msg = fmt.Sprintf("executed a batch of %d statements in %s: %s", len(statements), elapsed,
+			strings.Join(formatted, "; "))
+	}
+	if err != nil {
+		msg += ": " + err.Error()
+	}
+	if level == logrus.WarnLevel {
+		logger.Log.Warnf("slow statement: %s", msg)
+		return
+	}
+	logger.Log.Debug(msg)
+}
+
+// queryer is implemented by both database connection pools and transactions.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// execContext executes a statement that doesn't return rows and logs it.
+func execContext(ctx context.Context, q queryer, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := q.ExecContext(ctx, query, args...)
+	logQuery(query, args, time.Since(start), err)
+	return result, err
+}
+
+// queryContext executes a statement that returns rows and logs it.
+func queryContext(ctx context.Context, q queryer, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query, args...)
+	logQuery(query, args, time.Since(start), err)
+	return rows, err
+}
+
+// queryRowContext executes a statement that returns at most one row and logs it. Errors aren't reported until the row
+// is scanned, so they aren't logged.
+func queryRowContext(ctx context.Context, q queryer, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := q.QueryRowContext(ctx, query, args...)
+	logQuery(query, args, time.Since(start), nil)
+	return row
+}
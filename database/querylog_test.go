@@ -0,0 +1,57 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestQueryLogLevel verifies that statements are logged at the debug level when query logging is enabled, and that
+// slow statements are logged at the warn level even when it's disabled.
+func TestQueryLogLevel(t *testing.T) {
+	slowAndEnabled := QueryLogSettings{Enabled: true, SlowThreshold: time.Second}
+	tests := []struct {
+		name     string
+		settings QueryLogSettings
+		elapsed  time.Duration
+		logged   bool
+		level    logrus.Level
+	}{
+		{"disabled", QueryLogSettings{}, time.Hour, false, logrus.DebugLevel},
+		{"enabled", QueryLogSettings{Enabled: true}, time.Millisecond, true, logrus.DebugLevel},
+		{"fast", QueryLogSettings{SlowThreshold: time.Second}, time.Millisecond, false, logrus.DebugLevel},
+		{"slow", QueryLogSettings{SlowThreshold: time.Second}, time.Second, true, logrus.WarnLevel},
+		{"slow and enabled", slowAndEnabled, time.Minute, true, logrus.WarnLevel},
+	}
+
+	for _, test := range tests {
+		level, logged := queryLogLevel(test.settings, test.elapsed)
+		if logged != test.logged || (logged && level != test.level) {
+			t.Errorf("%s: expected %t at %s, got %t at %s", test.name, test.logged, test.level, logged, level)
+		}
+	}
+}
+
+// TestFormatArgs verifies that statement arguments are formatted for logging, and that long values are elided.
+func TestFormatArgs(t *testing.T) {
+	payload := strings.Repeat("x", 20)
+	var missing *string
+	timestamp := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	args := []interface{}{"short", &payload, missing, nil, 42, timestamp, []byte("body")}
+
+	expected := `[$1="short", $2="xxxxxxxxxx"... (20 bytes), $3=NULL, $4=NULL, $5=42, ` +
+		`$6=2019-03-01T12:00:00Z, $7="body"]`
+	if formatted := formatArgs(args, 10); formatted != expected {
+		t.Errorf("expected %s, got %s", expected, formatted)
+	}
+}
+
+// TestCompactQuery verifies that the whitespace in a statement is collapsed.
+func TestCompactQuery(t *testing.T) {
+	compacted := compactQuery(addEvent.query)
+	if strings.Contains(compacted, "\n") || !strings.HasPrefix(compacted, "INSERT INTO event_log (") {
+		t.Errorf("unexpected compacted statement: %s", compacted)
+	}
+}
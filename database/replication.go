@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// The statement used to record the status of the replica of a data object on a target member node. The replica is
+// recorded under the permanent identifier of the registered data object with the same UUID if there is one. The time
+// of the last successful replication is retained when a later replication fails, and failed replications are counted
+// so that the replication monitor can tell how many times a replica has failed. Statuses are only stored if they're
+// at least as recent as the stored status, so that a status that's processed out of order doesn't replace a more
+// recent one. The permanent identifier of the replicated object is returned.
+var storeReplicaStatus = named(`
+INSERT INTO replica_status (permanent_id, target_node, status, failure_reason, failures, verified_at, updated_at)
+VALUES (
+    ` + registeredPermanentID + `, :target_node, :status, :failure_reason, :failures, :verified_at, :updated_at
+)
+ON CONFLICT (permanent_id, target_node) DO UPDATE SET
+    status = excluded.status,
+    failure_reason = excluded.failure_reason,
+    failures = replica_status.failures + excluded.failures,
+    verified_at = coalesce(excluded.verified_at, replica_status.verified_at),
+    updated_at = excluded.updated_at
+WHERE replica_status.updated_at <= excluded.updated_at
+RETURNING permanent_id;
+`)
+
+// The statement used to list the replicas whose last replication failed, starting with the ones that failed first.
+var listFailedReplicas = named(`
+SELECT permanent_id, target_node, status, failure_reason, failures, verified_at, updated_at
+FROM replica_status
+WHERE status = 'failed'
+ORDER BY updated_at, permanent_id, target_node
+LIMIT :limit;
+`)
+
+// Replica describes the status of the replica of a data object on another member node. The verification time is the
+// time of the last successful replication, which is nil if the object has never been replicated to the node, and the
+// failure reason is nil unless the last replication failed. Failures is the number of replications that have failed.
+type Replica struct {
+	PermanentID   string
+	TargetNode    string
+	Status        string
+	FailureReason *string
+	Failures      int
+	VerifiedAt    *time.Time
+	UpdatedAt     time.Time
+}
+
+// replicationEventTypes maps the replication statuses that produce events to the types of the events.
+var replicationEventTypes = map[string]string{
+	model.ReplicationCompleted: ETReplicate,
+	model.ReplicationFailed:    ETReplicationFailed,
+}
+
+// storeReplica stores the status of the replica of a data object as of the given time. The permanent identifier of
+// the replicated object is returned, or an empty string if the replica was updated more recently.
+func storeReplica(ctx context.Context, q queryer, msg *model.Message, updatedAt time.Time) (string, error) {
+	status := msg.ReplicationResult()
+	values := namedArgs{
+		"entity_uuid":    uuidArg(msg.UUID),
+		"permanent_id":   msg.Entity,
+		"target_node":    msg.TargetNode,
+		"status":         status,
+		"failure_reason": nil,
+		"failures":       0,
+		"verified_at":    nil,
+		"updated_at":     updatedAt,
+	}
+	switch status {
+	case model.ReplicationCompleted:
+		values["verified_at"] = updatedAt
+	case model.ReplicationFailed:
+		values["failures"] = 1
+		if msg.FailureReason != "" {
+			values["failure_reason"] = msg.FailureReason
+		}
+	}
+
+	rows, err := queryNamed(ctx, q, storeReplicaStatus, values)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", rows.Err()
+	}
+	var permanentID string
+	if err := rows.Scan(&permanentID); err != nil {
+		return "", err
+	}
+	return permanentID, rows.Close()
+}
+
+// recordReplication is the function that DefaultRecorder uses to record replications of data objects to other member
+// nodes. The status of the replica on the target node is stored in the replica_status table, along with the reason for
+// the failure if the replication failed. A replicate event is recorded for a completed replication and a replication
+// failed event for a failed one, under the permanent identifier of the replicated object. Other statuses are stored
+// without recording an event, and statuses that are older than the stored status are logged and skipped.
+func recordReplication(ctx context.Context, tx *sql.Tx, r Recorder, key string, msg *model.Message) (*Event, error) {
+	switch {
+	case msg.Entity == "":
+		return nil, fmt.Errorf("replication message for path '%s' doesn't identify the data object", msg.Path)
+	case msg.TargetNode == "":
+		return nil, fmt.Errorf("replication message for data object %s doesn't identify the target node", msg.Entity)
+	}
+	event := newEvent(r, ETReplicate, msg)
+	permanentID, err := storeReplica(ctx, tx, msg, *event.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if permanentID == "" {
+		logger.Log.Infof("skipping the replication of data object %s to %s, which was updated more recently",
+			msg.Entity, msg.TargetNode)
+		return nil, nil
+	}
+
+	eventType, ok := replicationEventTypes[msg.ReplicationResult()]
+	if !ok {
+		return nil, nil
+	}
+	if eventType == ETReplicationFailed {
+		logger.Log.Warnf("replication of data object %s to %s failed: %s", permanentID, msg.TargetNode,
+			msg.FailureReason)
+	}
+	event.Type = eventType
+	rows := []*eventRow{{entity: permanentID, event: event}}
+	if err := insertEvents(ctx, tx, statementsFor(r), rows); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// FailedReplicas returns up to the given number of replicas whose last replication failed, starting with the ones
+// that failed first, so that the replication monitor can attempt them again.
+func FailedReplicas(ctx context.Context, db *sql.DB, limit int) ([]*Replica, error) {
+	rows, err := queryNamed(ctx, db, listFailedReplicas, namedArgs{"limit": limit})
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	defer rows.Close()
+
+	var result []*Replica
+	for rows.Next() {
+		replica := &Replica{}
+		err := rows.Scan(
+			&replica.PermanentID, &replica.TargetNode, &replica.Status, &replica.FailureReason, &replica.Failures,
+			&replica.VerifiedAt, &replica.UpdatedAt,
+		)
+		if err != nil {
+			return nil, classifyError(err)
+		}
+		result = append(result, replica)
+	}
+	return result, classifyError(rows.Err())
+}
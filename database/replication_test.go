@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// getReplicationMessage returns a message describing a replication of the test data object to a partner member node.
+func getReplicationMessage(t time.Time, status, reason string) *model.Message {
+	msg := getTimestampedMessage(t)
+	msg.TargetNode = "urn:node:partner"
+	msg.ReplicationStatus = status
+	msg.FailureReason = reason
+	return msg
+}
+
+// TestRecordReplication verifies that the status of a replica is stored along with the reason for a failure, that
+// completed and failed replications produce events under the permanent identifier of the replicated object, that
+// other statuses and stale statuses don't, and that messages that don't identify the replica are rejected.
+func TestRecordReplication(t *testing.T) {
+	replicated := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		status    string
+		reason    string
+		stale     bool
+		eventType string
+	}{
+		{"completed", model.ReplicationCompleted, "", false, ETReplicate},
+		{"no status", "", "", false, ETReplicate},
+		{"failed", model.ReplicationFailed, "checksum mismatch", false, ETReplicationFailed},
+		{"requested", model.ReplicationRequested, "", false, ""},
+		{"stale", model.ReplicationCompleted, "", true, ""},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("%s: error opening stub database connection: %s", test.name, err)
+		}
+		r := getTestRecorder(db)
+		msg := getReplicationMessage(replicated, test.status, test.reason)
+
+		var reason, verified interface{}
+		failures := 0
+		switch msg.ReplicationResult() {
+		case model.ReplicationCompleted:
+			verified = replicated
+		case model.ReplicationFailed:
+			reason, failures = test.reason, 1
+		}
+		stored := sqlmock.NewRows([]string{"permanent_id"})
+		if !test.stale {
+			stored.AddRow("fakepid")
+		}
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO replica_status").
+			WithArgs(msg.UUID, msg.Entity, "urn:node:partner", msg.ReplicationResult(), reason, failures, verified,
+				replicated).
+			WillReturnRows(stored)
+		if test.eventType != "" {
+			// The insert is prepared on the connection pool and then again on the transaction's connection.
+			mock.ExpectPrepare("INSERT INTO event_log")
+			mock.ExpectPrepare("INSERT INTO event_log")
+			mock.ExpectQuery("INSERT INTO event_log").
+				WithArgs("fakepid", msg.Path, test.eventType, &replicated, "fakenode").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+		}
+		mock.ExpectCommit()
+
+		event, err := r.RecordEvent(context.Background(), ReplicateKey, msg)
+		if err != nil {
+			t.Errorf("%s: error encountered while recording the replication: %s", test.name, err)
+		}
+		if test.eventType != "" && (event == nil || event.Type != test.eventType || event.ID != 42) {
+			t.Errorf("%s: expected a %s event but got %+v", test.name, test.eventType, event)
+		}
+		if test.eventType == "" && event != nil {
+			t.Errorf("%s: expected no event but got %+v", test.name, event)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+		db.Close()
+	}
+}
+
+// TestIncompleteReplication verifies that replication messages that don't identify the data object or the target node
+// are rejected without storing anything.
+func TestIncompleteReplication(t *testing.T) {
+	for name, update := range map[string]func(*model.Message){
+		"no entity":      func(msg *model.Message) { msg.Entity, msg.UUID = "", "" },
+		"no target node": func(msg *model.Message) { msg.TargetNode = "" },
+	} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("%s: error opening stub database connection: %s", name, err)
+		}
+		msg := getReplicationMessage(time.Now(), model.ReplicationCompleted, "")
+		update(msg)
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+		if _, err := getTestRecorder(db).RecordEvent(context.Background(), ReplicateKey, msg); err == nil {
+			t.Errorf("%s: expected the replication to be rejected", name)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", name, err)
+		}
+		db.Close()
+	}
+}
+
+// TestFailedReplicas verifies that failed replicas are listed along with the reasons for their failures.
+func TestFailedReplicas(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+
+	verified := time.Date(2019, 2, 1, 12, 0, 0, 0, time.UTC)
+	failed := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	columns := []string{
+		"permanent_id", "target_node", "status", "failure_reason", "failures", "verified_at", "updated_at",
+	}
+	mock.ExpectQuery("SELECT .* FROM replica_status").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("fakepid", "urn:node:partner", model.ReplicationFailed, "timed out", 2, verified, failed).
+			AddRow("otherpid", "urn:node:partner", model.ReplicationFailed, nil, 1, nil, failed))
+
+	replicas, err := FailedReplicas(context.Background(), db, 10)
+	if err != nil {
+		t.Fatalf("unable to list the failed replicas: %s", err)
+	}
+	switch {
+	case len(replicas) != 2:
+		t.Fatalf("expected 2 failed replicas but got %d", len(replicas))
+	case replicas[0].PermanentID != "fakepid" || replicas[0].Failures != 2:
+		t.Errorf("unexpected replica: %+v", replicas[0])
+	case replicas[0].FailureReason == nil || *replicas[0].FailureReason != "timed out":
+		t.Errorf("unexpected failure reason: %v", replicas[0].FailureReason)
+	case replicas[0].VerifiedAt == nil || !replicas[0].VerifiedAt.Equal(verified):
+		t.Errorf("unexpected verification time: %v", replicas[0].VerifiedAt)
+	case replicas[1].FailureReason != nil || replicas[1].VerifiedAt != nil:
+		t.Errorf("unexpected replica: %+v", replicas[1])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestReplicationDrivers verifies that replica statuses are stored with both database drivers, that a failure retains
+// the time of the last successful replication and that a stale status doesn't replace a more recent one.
+func TestReplicationDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := getTestRecorder(db)
+		ctx := context.Background()
+		added := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+		if _, err := r.RecordEvent(ctx, AddKey, getAddMessage(added, 1024, "sha2:checksum")); err != nil {
+			t.Fatalf("%s: error encountered while recording the addition: %s", driver, err)
+		}
+
+		for _, msg := range []*model.Message{
+			getReplicationMessage(added.Add(time.Hour), model.ReplicationCompleted, ""),
+			getReplicationMessage(added.Add(3*time.Hour), model.ReplicationFailed, "checksum mismatch"),
+			getReplicationMessage(added.Add(2*time.Hour), model.ReplicationCompleted, ""),
+		} {
+			if _, err := r.RecordEvent(ctx, ReplicateKey, msg); err != nil {
+				t.Fatalf("%s: error encountered while recording the replication: %s", driver, err)
+			}
+		}
+
+		replicas, err := FailedReplicas(ctx, db, 10)
+		switch {
+		case err != nil:
+			t.Fatalf("%s: unable to list the failed replicas: %s", driver, err)
+		case len(replicas) != 1:
+			t.Fatalf("%s: expected 1 failed replica but got %d", driver, len(replicas))
+		case replicas[0].Failures != 1 || replicas[0].FailureReason == nil:
+			t.Errorf("%s: unexpected replica: %+v", driver, replicas[0])
+		case replicas[0].VerifiedAt == nil || !replicas[0].VerifiedAt.Equal(added.Add(time.Hour)):
+			t.Errorf("%s: unexpected verification time: %v", driver, replicas[0].VerifiedAt)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The subquery used to select a batch of events that were logged before a given time. Events are identified by both
+// identifier and date so that the same statements work whether or not the event log is partitioned.
+const oldEventsBatch = `
+SELECT id, date_logged FROM event_log WHERE date_logged < :before ORDER BY date_logged LIMIT :batch_size
+`
+
+// The statement used to delete a batch of events that were logged before a given time.
+var pruneEvents = named(`
+DELETE FROM event_log WHERE (id, date_logged) IN (` + oldEventsBatch + `);
+`)
+
+// The statement used to move a batch of events that were logged before a given time to an archive table. The table
+// name is formatted into the statement after it's quoted.
+var archiveEvents = `
+WITH moved AS (
+    DELETE FROM event_log WHERE (id, date_logged) IN (` + oldEventsBatch + `)
+    RETURNING *
+)
+INSERT INTO %s SELECT * FROM moved;
+`
+
+// The statement used to create an archive table with the same columns as the event log. The table name is formatted
+// into the statement after it's quoted.
+const createEventArchive = `
+CREATE TABLE IF NOT EXISTS %s (LIKE event_log);
+`
+
+// CreateEventArchive creates a table with the same columns as the event log, to which old events can be moved, if it
+// doesn't exist yet. Columns that are added to the event log after the archive table is created must also be added to
+// the archive table.
+func CreateEventArchive(ctx context.Context, db *sql.DB, table string) error {
+	_, err := execContext(ctx, db, fmt.Sprintf(createEventArchive, pq.QuoteIdentifier(table)))
+	return classifyError(err)
+}
+
+// PruneEvents removes up to the given number of events that were logged before the given time from the event log,
+// returning the number of events that were removed. The events are moved to the archive table if one is specified and
+// deleted otherwise. Events are removed in bounded batches so that each statement holds its locks briefly; callers
+// remove all of the old events by calling PruneEvents until it removes fewer events than the batch size.
+func PruneEvents(ctx context.Context, db *sql.DB, before time.Time, batchSize int, archiveTable string) (int64, error) {
+	stmt := pruneEvents
+	if archiveTable != "" {
+		stmt = named(fmt.Sprintf(archiveEvents, pq.QuoteIdentifier(archiveTable)))
+	}
+	result, err := execNamed(ctx, db, stmt, namedArgs{"before": before, "batch_size": batchSize})
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestPruneEvents verifies that old events are deleted, or moved to the archive table if one is specified, in batches.
+func TestPruneEvents(t *testing.T) {
+	tests := []struct {
+		name         string
+		archiveTable string
+		pattern      string
+	}{
+		{"delete", "", `DELETE FROM event_log WHERE \(id, date_logged\) IN`},
+		{"archive", "event_log_archive", `WITH moved AS .* INSERT INTO "event_log_archive" SELECT \* FROM moved`},
+	}
+
+	cutoff := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening stub database connection: %s", err)
+		}
+
+		mock.ExpectExec(test.pattern).WithArgs(cutoff, 500).WillReturnResult(sqlmock.NewResult(0, 42))
+
+		removed, err := PruneEvents(context.Background(), db, cutoff, 500, test.archiveTable)
+		if err != nil {
+			t.Errorf("%s: error encountered while pruning events: %s", test.name, err)
+		}
+		if removed != 42 {
+			t.Errorf("%s: expected 42 events to be removed but got %d", test.name, removed)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+	}
+}
+
+// TestCreateEventArchive verifies that the archive table name is quoted.
+func TestCreateEventArchive(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS "old ""events""" \(LIKE event_log\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := CreateEventArchive(context.Background(), db, `old "events"`); err != nil {
+		t.Errorf("error encountered while creating the archive table: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
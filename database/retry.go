@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Default settings for attempting database operations again in-process.
+const (
+	DefaultRetryAttempts   = 3
+	DefaultRetryBackoff    = 50 * time.Millisecond
+	DefaultRetryMaxBackoff = time.Second
+)
+
+// RetryPolicy determines how many times the recorder attempts a database operation that fails for a reason that might
+// not occur if the operation is attempted again immediately, and how long it waits between attempts. The delay before
+// each attempt after the first is chosen at random between half of the backoff and the full backoff, which doubles
+// with each attempt up to the maximum backoff.
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy returns the retry policy that the recorder uses unless another one is configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{Attempts: DefaultRetryAttempts, Backoff: DefaultRetryBackoff, MaxBackoff: DefaultRetryMaxBackoff}
+}
+
+// delay returns the amount of time to wait before the given attempt, counting from one.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	backoff := p.Backoff
+	for i := 2; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
+
+// wait waits before the given attempt. The return value is false if the context expired while waiting.
+func (p RetryPolicy) wait(ctx context.Context, attempt int) bool {
+	delay := p.delay(attempt)
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isConnectionReset determines whether or not an error indicates that the connection to the database was lost.
+func isConnectionReset(err error) bool {
+	if code, ok := sqlState(err); ok {
+		return len(code) == 5 && code[:2] == "08"
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	switch err {
+	case driver.ErrBadConn, io.EOF, io.ErrUnexpectedEOF, pgx.ErrDeadConn:
+		return true
+	}
+	return false
+}
+
+// shouldRetry determines whether or not a failed transaction should be attempted again in-process. Conflicts with
+// concurrent transactions are always retried because the transaction is rolled back. A lost connection is only
+// retried if it was lost before the transaction was committed; otherwise, the transaction may have been committed
+// already, and attempting it again could record the same events twice.
+func shouldRetry(err error, committing bool) bool {
+	return isConflict(err) || (!committing && isConnectionReset(err))
+}
+
+// attemptFunc makes a single attempt to perform a transaction. It returns whether or not the failure, if any, occurred
+// while the transaction was being committed.
+type attemptFunc func() (bool, error)
+
+// retryTransient calls a function that performs a transaction until it succeeds, it fails for a reason that shouldn't
+// be retried, the maximum number of attempts is reached, or the context expires.
+func retryTransient(ctx context.Context, policy RetryPolicy, attempt attemptFunc) error {
+	var err error
+	var committing bool
+	for i := 1; ; i++ {
+		if committing, err = attempt(); err == nil || !shouldRetry(err, committing) || ctx.Err() != nil {
+			return err
+		}
+		if i >= policy.Attempts || !policy.wait(ctx, i+1) {
+			return err
+		}
+	}
+}
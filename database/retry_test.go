@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestRetryDelay verifies that the delay between attempts grows exponentially up to the maximum backoff, with jitter.
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{Attempts: 5, Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{2, 100 * time.Millisecond},
+		{3, 200 * time.Millisecond},
+		{4, 300 * time.Millisecond},
+		{5, 300 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			if delay := policy.delay(test.attempt); delay < test.max/2 || delay > test.max {
+				t.Errorf("attempt %d: delay %s is outside of [%s, %s]", test.attempt, delay, test.max/2, test.max)
+			}
+		}
+	}
+
+	if delay := (RetryPolicy{Attempts: 3}).delay(2); delay != 0 {
+		t.Errorf("expected no delay without a backoff but got %s", delay)
+	}
+}
+
+// TestTransientRetry verifies that transactions are attempted again in-process only if they fail for reasons that
+// might not occur if they're attempted again.
+func TestTransientRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		queryErr  error
+		commitErr error
+		attempts  int
+		expectErr bool
+	}{
+		{"deadlock", &pq.Error{Code: "40P01"}, nil, 2, false},
+		{"serialization failure", &pq.Error{Code: "40001"}, nil, 2, false},
+		{"connection reset", io.ErrUnexpectedEOF, nil, 2, false},
+		{"admin shutdown", &pq.Error{Code: "08006"}, nil, 2, false},
+		{"syntax error", &pq.Error{Code: "42601"}, nil, 1, true},
+		{"foreign key violation", &pq.Error{Code: "23503"}, nil, 1, true},
+		{"connection lost during commit", nil, io.EOF, 1, true},
+		{"serialization failure during commit", nil, &pq.Error{Code: "40001"}, 2, false},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening stub database connection: %s", err)
+		}
+
+		// The first attempt fails and the second succeeds.
+		expectCurrentVersions(mock)
+		mock.ExpectPrepare("INSERT INTO event_log")
+		mock.ExpectBegin()
+		if test.queryErr != nil {
+			mock.ExpectQuery("INSERT INTO event_log").WillReturnError(test.queryErr)
+			mock.ExpectRollback()
+		} else {
+			mock.ExpectQuery("INSERT INTO event_log").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(41))
+			mock.ExpectCommit().WillReturnError(test.commitErr)
+		}
+		if test.attempts > 1 {
+			mock.ExpectBegin()
+			mock.ExpectQuery("INSERT INTO event_log").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+			mock.ExpectCommit()
+		}
+
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		r.SetRetryPolicy(RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond})
+		_, err = r.RecordEvent(context.Background(), ReadKey, getTestMessage())
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+	}
+}
+
+// TestRetryCancellation verifies that waiting between attempts stops when the context expires.
+func TestRetryCancellation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	attempts := 0
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Minute, MaxBackoff: time.Minute}
+	start := time.Now()
+	err := retryTransient(ctx, policy, func() (bool, error) {
+		attempts++
+		return false, &pq.Error{Code: "40P01"}
+	})
+	if err == nil {
+		t.Error("an error was expected but none was encountered")
+	}
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt but got %d", attempts)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Minute/2 {
+		t.Errorf("waiting didn't stop when the context expired: %s", elapsed)
+	}
+}
+
+// TestIsConnectionReset verifies that lost connections are detected.
+func TestIsConnectionReset(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{io.EOF, true},
+		{&pq.Error{Code: "08003"}, true},
+		{&pq.Error{Code: "40P01"}, false},
+		{fmt.Errorf("something else"), false},
+	}
+
+	for _, test := range tests {
+		if actual := isConnectionReset(test.err); actual != test.expected {
+			t.Errorf("%v: expected %t, got %t", test.err, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rollupDayFormat is the format of the days in the event_rollups table. Days are determined in UTC.
+const rollupDayFormat = "2006-01-02"
+
+// The beginning and end of the statement used to add counts to the event_rollups table. Rows that already exist have
+// the new counts added to their existing counts.
+const (
+	addRollupsPrefix = `
+INSERT INTO event_rollups (permanent_id, event, day, count) VALUES `
+	addRollupsSuffix = `
+ON CONFLICT (permanent_id, event, day) DO UPDATE SET count = event_rollups.count + EXCLUDED.count;
+`
+)
+
+// The statement used to lock the event_rollups table while it's being rebuilt. Recorders that update the table wait
+// for the rebuild to finish, so events recorded during the rebuild are counted exactly once.
+const lockRollups = `
+LOCK TABLE event_rollups IN EXCLUSIVE MODE;
+`
+
+// The statement used to remove the counts for a range of days from the event_rollups table.
+var deleteRollups = named(`
+DELETE FROM event_rollups WHERE day >= :first::date AND day < :last::date;
+`)
+
+// The statement used to count the events for a range of days in the event log and store the counts in the
+// event_rollups table.
+var rebuildRollups = named(`
+INSERT INTO event_rollups (permanent_id, event, day, count)
+SELECT coalesce(permanent_id, ''), event, (date_logged AT TIME ZONE 'UTC')::date, count(*)
+FROM event_log
+WHERE date_logged >= :first::date::timestamp AT TIME ZONE 'UTC'
+AND date_logged < :last::date::timestamp AT TIME ZONE 'UTC'
+GROUP BY 1, 2, 3;
+`)
+
+// rollupCount is the number of events of one type that were recorded for an object on one day.
+type rollupCount struct {
+	entity    string
+	eventType string
+	day       string
+	count     int64
+}
+
+// rollupCounts counts the events in a set of rows by object, event type and day. The counts are sorted so that
+// concurrent transactions update the same rows of the event_rollups table in the same order, which prevents them from
+// deadlocking. Rows without timestamps are skipped because they can't be inserted into the event log.
+func rollupCounts(rows []*eventRow) []*rollupCount {
+	counts := make(map[rollupCount]int64)
+	for _, row := range rows {
+		if row.event.Timestamp == nil {
+			continue
+		}
+		key := rollupCount{
+			entity:    row.entity,
+			eventType: row.event.Type,
+			day:       row.event.Timestamp.UTC().Format(rollupDayFormat),
+		}
+		counts[key]++
+	}
+
+	result := make([]*rollupCount, 0, len(counts))
+	for key, n := range counts {
+		rc := key
+		rc.count = n
+		result = append(result, &rc)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.entity != b.entity {
+			return a.entity < b.entity
+		}
+		if a.eventType != b.eventType {
+			return a.eventType < b.eventType
+		}
+		return a.day < b.day
+	})
+	return result
+}
+
+// rollupChunks splits rollup counts into groups that can each be added by one statement.
+func rollupChunks(counts []*rollupCount) [][]*rollupCount {
+	var chunks [][]*rollupCount
+	for len(counts) > maxEventsPerInsert {
+		chunks = append(chunks, counts[:maxEventsPerInsert])
+		counts = counts[maxEventsPerInsert:]
+	}
+	if len(counts) > 0 {
+		chunks = append(chunks, counts)
+	}
+	return chunks
+}
+
+// addRollupsQuery returns the statement used to add the given number of counts to the event_rollups table, along
+// with its arguments. The values for each count are appended as positional parameters.
+func addRollupsQuery(counts []*rollupCount) (string, []interface{}) {
+	values := make([]string, len(counts))
+	args := make([]interface{}, 0, len(counts)*4)
+	for i, rc := range counts {
+		values[i] = fmt.Sprintf("($%d, $%d, $%d::date, $%d)", i*4+1, i*4+2, i*4+3, i*4+4)
+		args = append(args, rc.entity, rc.eventType, rc.day, rc.count)
+	}
+	return addRollupsPrefix + strings.Join(values, ", ") + addRollupsSuffix, args
+}
+
+// updateRollups adds the events in a set of rows to the event_rollups table within a transaction.
+func updateRollups(ctx context.Context, exec execFunc, rows []*eventRow) error {
+	for _, chunk := range rollupChunks(rollupCounts(rows)) {
+		query, args := addRollupsQuery(chunk)
+		if err := exec(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetRollups enables or disables maintaining the daily event counts in the event_rollups table. When it's enabled,
+// the counts are updated in the same transaction that records the events. Events that are recorded by custom handlers
+// aren't counted.
+func (r *DefaultRecorder) SetRollups(enabled bool) {
+	r.rollups = enabled
+}
+
+// RebuildRollups regenerates the daily event counts in the event_rollups table for the days from the first day up to
+// but not including the last day, which are determined in UTC, returning the number of rows that were written. The
+// counts are regenerated from the event log in a single transaction.
+func RebuildRollups(ctx context.Context, db *sql.DB, from, to time.Time) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	defer tx.Rollback()
+
+	days := namedArgs{"first": from.UTC().Format(rollupDayFormat), "last": to.UTC().Format(rollupDayFormat)}
+	if _, err := execContext(ctx, tx, lockRollups); err != nil {
+		return 0, classifyError(err)
+	}
+	if _, err := execNamed(ctx, tx, deleteRollups, days); err != nil {
+		return 0, classifyError(err)
+	}
+	result, err := execNamed(ctx, tx, rebuildRollups, days)
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	written, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return written, classifyError(tx.Commit())
+}
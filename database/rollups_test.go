@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestRollupCounts verifies that events are counted by object, event type and UTC day, and that the counts are sorted.
+func TestRollupCounts(t *testing.T) {
+	day := time.Date(2019, 3, 1, 23, 30, 0, 0, time.UTC)
+	nextDay := day.Add(time.Hour)
+	local := day.In(time.FixedZone("EST", -5*3600))
+	rows := []*eventRow{
+		{entity: "b", event: &Event{Type: ETRead, Timestamp: &day}},
+		{entity: "a", event: &Event{Type: ETRead, Timestamp: &nextDay}},
+		{entity: "a", event: &Event{Type: ETRead, Timestamp: &day}},
+		{entity: "a", event: &Event{Type: ETRead, Timestamp: &local}},
+		{entity: "a", event: &Event{Type: ETRead}},
+	}
+	expected := []rollupCount{
+		{"a", ETRead, "2019-03-01", 2},
+		{"a", ETRead, "2019-03-02", 1},
+		{"b", ETRead, "2019-03-01", 1},
+	}
+
+	counts := rollupCounts(rows)
+	if len(counts) != len(expected) {
+		t.Fatalf("expected %d counts, got %d", len(expected), len(counts))
+	}
+	for i, rc := range counts {
+		if *rc != expected[i] {
+			t.Errorf("count %d: expected %+v, got %+v", i, expected[i], *rc)
+		}
+	}
+}
+
+// TestRecordRollups verifies that the daily event counts are updated in the same transaction as the event insert if
+// rollups are enabled.
+func TestRecordRollups(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetRollups(true)
+	msg := getTestMessage()
+	day := msg.Timestamp.ToTime().UTC().Format(rollupDayFormat)
+
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectExec(`INSERT INTO event_rollups .* ON CONFLICT \(permanent_id, event, day\) DO UPDATE`).
+		WithArgs(msg.Entity, ETRead, day, int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, err := r.RecordEvent(context.Background(), ReadKey, msg); err != nil {
+		t.Fatalf("error encountered while recording event: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestRebuildRollups verifies that the daily event counts for a range of days are replaced in a single transaction.
+func TestRebuildRollups(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	from := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec("LOCK TABLE event_rollups").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM event_rollups").
+		WithArgs("2019-03-01", "2019-04-01").
+		WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec("INSERT INTO event_rollups .* FROM event_log").
+		WithArgs("2019-03-01", "2019-04-01").
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectCommit()
+
+	written, err := RebuildRollups(context.Background(), db, from, to)
+	if err != nil {
+		t.Errorf("error encountered while rebuilding rollups: %s", err)
+	}
+	if written != 12 {
+		t.Errorf("expected 12 rows to be written but got %d", written)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestRollupsDrivers maintains and rebuilds the daily event counts in a real database with each of the supported
+// drivers.
+func TestRollupsDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		r.SetRollups(true)
+		ctx := context.Background()
+
+		msg := getTestMessage()
+		requests := []*EventRequest{{Key: ReadKey, Msg: msg}, {Key: LegacyReadKey, Msg: msg}}
+		if _, err := r.RecordEvents(ctx, requests); err != nil {
+			t.Fatalf("%s: error encountered while recording events: %s", driver, err)
+		}
+		if _, err := r.RecordEvent(ctx, ReadKey, msg); err != nil {
+			t.Fatalf("%s: error encountered while recording event: %s", driver, err)
+		}
+
+		// Rebuilding the counts shouldn't change them.
+		day := msg.Timestamp.ToTime().UTC().Truncate(24 * time.Hour)
+		if _, err := RebuildRollups(ctx, db, day, day.AddDate(0, 0, 1)); err != nil {
+			t.Fatalf("%s: error encountered while rebuilding rollups: %s", driver, err)
+		}
+		var count int64
+		query := "SELECT count FROM event_rollups WHERE permanent_id = $1 AND event = $2"
+		if err := db.QueryRow(query, msg.Entity, ETRead).Scan(&count); err != nil {
+			t.Fatalf("%s: unable to look up the daily event count: %s", driver, err)
+		}
+		if count != 3 {
+			t.Errorf("%s: expected a daily event count of 3, got %d", driver, count)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// maxCachedStatements is the maximum number of distinct statements to prepare. Multi-row inserts produce a different
+// statement for each number of rows, so the cache is bounded to keep uncommon batch sizes from accumulating prepared
+// statements on the server. Statements that don't fit in the cache are executed without being prepared.
+const maxCachedStatements = 32
+
+// statementCache prepares each statement once and reuses it for every subsequent execution. It's safe for concurrent
+// use by multiple goroutines.
+//
+// A prepared sql.Stmt isn't tied to a single connection: database/sql prepares it again transparently on any pooled
+// connection that it hasn't been prepared on yet, including connections that replace ones that were lost. The cost is
+// that each statement may be prepared once per connection in the pool, which is bounded by the pool size.
+type statementCache struct {
+	db         *sql.DB
+	mutex      sync.Mutex
+	statements map[string]*sql.Stmt
+}
+
+// newStatementCache creates an empty statement cache for a database connection pool.
+func newStatementCache(db *sql.DB) *statementCache {
+	return &statementCache{db: db, statements: make(map[string]*sql.Stmt)}
+}
+
+// prepared returns the prepared statement for the given query, preparing it if necessary. A nil statement is returned
+// without an error if the cache is full.
+func (c *statementCache) prepared(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if stmt := c.statements[query]; stmt != nil {
+		return stmt, nil
+	}
+	if len(c.statements) >= maxCachedStatements {
+		return nil, nil
+	}
+
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.statements[query] = stmt
+	return stmt, nil
+}
+
+// query executes a query that returns rows within a transaction, using a prepared statement if possible. A nil cache
+// executes the query without preparing it.
+func (c *statementCache) query(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (*sql.Rows, error) {
+	if c == nil {
+		return queryContext(ctx, tx, query, args...)
+	}
+
+	stmt, err := c.prepared(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return queryContext(ctx, tx, query, args...)
+	}
+	start := time.Now()
+	rows, err := tx.StmtContext(ctx, stmt).QueryContext(ctx, args...)
+	logQuery(query, args, time.Since(start), err)
+	return rows, err
+}
+
+// exec executes a statement that doesn't return rows outside of a transaction, using a prepared statement if possible.
+func (c *statementCache) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := c.prepared(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return execContext(ctx, c.db, query, args...)
+	}
+	start := time.Now()
+	result, err := stmt.ExecContext(ctx, args...)
+	logQuery(query, args, time.Since(start), err)
+	return result, err
+}
+
+// execNamed executes a statement with named parameters that doesn't return rows outside of a transaction, using a
+// prepared statement if possible.
+func (c *statementCache) execNamed(ctx context.Context, s *namedStatement, values namedArgs) (sql.Result, error) {
+	args, err := s.bind(values)
+	if err != nil {
+		return nil, err
+	}
+	return c.exec(ctx, s.query, args...)
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+
+	_ "github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestStatementReuse verifies that a statement is only prepared once no matter how many times it's executed.
+func TestStatementReuse(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	// The statement should be prepared once and executed twice.
+	prepare := mock.ExpectPrepare("INSERT INTO quarantine")
+	prepare.ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	prepare.ExpectExec().WillReturnResult(sqlmock.NewResult(2, 1))
+
+	r := getTestRecorder(db)
+	for i := 0; i < 2; i++ {
+		if err := r.RecordQuarantine(context.Background(), ReadKey, []byte("{}"), "invalid message"); err != nil {
+			t.Fatalf("error encountered while quarantining message: %s", err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestStatementCacheLimit verifies that statements aren't prepared once the cache is full.
+func TestStatementCacheLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	// Fill the cache.
+	c := newStatementCache(db)
+	for i := 0; i < maxCachedStatements; i++ {
+		query := fmt.Sprintf("SELECT %d", i)
+		mock.ExpectPrepare(query)
+		if stmt, err := c.prepared(context.Background(), query); err != nil || stmt == nil {
+			t.Fatalf("unable to prepare statement %d: %v", i, err)
+		}
+	}
+
+	// The next statement shouldn't be prepared.
+	if stmt, err := c.prepared(context.Background(), "SELECT 'too many'"); err != nil || stmt != nil {
+		t.Errorf("expected no statement to be prepared, got %v (error: %v)", stmt, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// benchmarkInsert measures the latency of inserting a single event. These benchmarks require a PostgreSQL database
+// containing the event_log table, identified by the DATAONE_TEST_DB_URI environment variable. The events are inserted
+// in transactions that are rolled back, so the database isn't modified.
+func benchmarkInsert(b *testing.B, prepare bool) {
+	uri := os.Getenv("DATAONE_TEST_DB_URI")
+	if uri == "" {
+		b.Skip("DATAONE_TEST_DB_URI is not set")
+	}
+	db, err := sql.Open("postgres", uri)
+	if err != nil {
+		b.Fatalf("error opening database connection: %s", err)
+	}
+	defer db.Close()
+
+	var statements *statementCache
+	if prepare {
+		statements = newStatementCache(db)
+	}
+
+	r := getTestRecorder(db)
+	msg := getTestMessage()
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rows := []*eventRow{{entity: msg.Entity, event: newEvent(r, ETRead, msg)}}
+		if err := prepareInserts(ctx, statements, rows); err != nil {
+			b.Fatalf("unable to prepare statement: %s", err)
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			b.Fatalf("unable to begin transaction: %s", err)
+		}
+		if err := insertEvents(ctx, tx, statements, rows); err != nil {
+			b.Fatalf("unable to insert event: %s", err)
+		}
+		tx.Rollback()
+	}
+}
+
+// BenchmarkUnpreparedInsert measures the latency of inserting an event without a prepared statement.
+func BenchmarkUnpreparedInsert(b *testing.B) {
+	benchmarkInsert(b, false)
+}
+
+// BenchmarkPreparedInsert measures the latency of inserting an event with a prepared statement.
+func BenchmarkPreparedInsert(b *testing.B) {
+	benchmarkInsert(b, true)
+}
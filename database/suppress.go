@@ -0,0 +1,165 @@
+package database
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// DefaultDuplicateCacheSize is the number of recently recorded events to remember for duplicate suppression if no
+// size is specified.
+const DefaultDuplicateCacheSize = 10000
+
+// duplicateKey identifies events that are considered to be duplicates of each other when they occur close together.
+type duplicateKey struct {
+	user      string
+	path      string
+	eventType string
+}
+
+// duplicateEntry records when the most recent event with a given key occurred.
+type duplicateEntry struct {
+	key  duplicateKey
+	time time.Time
+}
+
+// recentEvents remembers when recently recorded events occurred so that repeated events can be suppressed. Once the
+// cache is full, the least recently recorded event is forgotten each time a new one is remembered. It's safe for
+// concurrent use by multiple goroutines.
+type recentEvents struct {
+	window  time.Duration
+	size    int
+	mutex   sync.Mutex
+	entries map[duplicateKey]*list.Element
+	order   *list.List
+}
+
+// newRecentEvents creates a cache that suppresses events occurring within the given window of an identical event.
+func newRecentEvents(window time.Duration, size int) *recentEvents {
+	if size < 1 {
+		size = DefaultDuplicateCacheSize
+	}
+	return &recentEvents{
+		window:  window,
+		size:    size,
+		entries: make(map[duplicateKey]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// eventKey returns the duplicate key for an event. The second return value is false if the event is never suppressed,
+// which is the case for events other than reads and for messages that don't identify the user. Reads by anonymous
+// users are never suppressed because they may have been made by different people.
+func eventKey(event *Event, msg *model.Message) (duplicateKey, bool) {
+	if event.Type != ETRead || msg.Author == nil || msg.Author.Name == "" || msg.Author.Anonymous {
+		return duplicateKey{}, false
+	}
+	user := fmt.Sprintf("%s#%s", msg.Author.Name, msg.Author.Zone)
+	return duplicateKey{user: user, path: event.Path, eventType: event.Type}, true
+}
+
+// eventTime returns the time at which an event occurred, which is the current time if the message has no timestamp.
+func eventTime(event *Event) time.Time {
+	if event.Timestamp != nil {
+		return *event.Timestamp
+	}
+	return time.Now()
+}
+
+// elapsed returns the absolute amount of time between two events. Messages aren't necessarily received in order, so
+// an event may have occurred before the one that was recorded first.
+func elapsed(earlier, later time.Time) time.Duration {
+	if d := later.Sub(earlier); d >= 0 {
+		return d
+	}
+	return earlier.Sub(later)
+}
+
+// last returns the time at which the most recent event with the given key was recorded.
+func (c *recentEvents) last(key duplicateKey) (time.Time, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return element.Value.(*duplicateEntry).time, true
+}
+
+// remember records the time at which an event with the given key was recorded.
+func (c *recentEvents) remember(key duplicateKey, t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*duplicateEntry).time = t
+		c.order.MoveToFront(element)
+		return
+	}
+
+	// Forget the least recently recorded event if the cache is full.
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*duplicateEntry).key)
+	}
+	c.entries[key] = c.order.PushFront(&duplicateEntry{key: key, time: t})
+}
+
+// duplicateFilter decides which events in a single batch are duplicates. Events that aren't suppressed are remembered
+// by the filter so that duplicates within the same batch are detected, but they aren't added to the cache until the
+// batch has been recorded.
+type duplicateFilter struct {
+	cache   *recentEvents
+	pending map[duplicateKey]time.Time
+}
+
+// newDuplicateFilter creates a duplicate filter for a single batch. The cache may be nil, in which case no events are
+// suppressed.
+func newDuplicateFilter(cache *recentEvents) *duplicateFilter {
+	return &duplicateFilter{cache: cache, pending: make(map[duplicateKey]time.Time)}
+}
+
+// suppress determines whether or not an event should be suppressed because an identical event occurred within the
+// duplicate window. The decision is logged at the debug level, along with the time since the prior event.
+func (f *duplicateFilter) suppress(event *Event, msg *model.Message) bool {
+	if f.cache == nil {
+		return false
+	}
+	key, ok := eventKey(event, msg)
+	if !ok {
+		return false
+	}
+	t := eventTime(event)
+
+	// Find the prior event, preferring one from the same batch.
+	prior, ok := f.pending[key]
+	if !ok {
+		prior, ok = f.cache.last(key)
+	}
+	if ok {
+		if d := elapsed(prior, t); d < f.cache.window {
+			logger.Log.Debugf("suppressing duplicate %s event for %s by %s: %s since the prior event", key.eventType,
+				key.path, key.user, d)
+			return true
+		}
+		logger.Log.Debugf("recording %s event for %s by %s: %s since the prior event", key.eventType, key.path,
+			key.user, elapsed(prior, t))
+	}
+	f.pending[key] = t
+	return false
+}
+
+// commit adds the events that weren't suppressed to the cache. It's called once the batch has been recorded.
+func (f *duplicateFilter) commit() {
+	if f.cache == nil {
+		return
+	}
+	for key, t := range f.pending {
+		f.cache.remember(key, t)
+	}
+}
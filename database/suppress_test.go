@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestRecentEventsEviction verifies that the least recently recorded event is forgotten once the cache is full.
+func TestRecentEventsEviction(t *testing.T) {
+	c := newRecentEvents(time.Minute, 2)
+	now := time.Now()
+	keys := []duplicateKey{{user: "a"}, {user: "b"}, {user: "c"}}
+
+	c.remember(keys[0], now)
+	c.remember(keys[1], now)
+	c.remember(keys[0], now)
+	c.remember(keys[2], now)
+
+	if _, ok := c.last(keys[1]); ok {
+		t.Error("the least recently recorded event was not forgotten")
+	}
+	for _, key := range []duplicateKey{keys[0], keys[2]} {
+		if _, ok := c.last(key); !ok {
+			t.Errorf("event %v was forgotten", key)
+		}
+	}
+}
+
+// testRead returns a read event and message for the given user and path that occurred at the given time.
+func testRead(user, path string, t time.Time) (*Event, *model.Message) {
+	msg := &model.Message{Path: path, Timestamp: (*model.Timestamp)(&t)}
+	if user != "" {
+		msg.Author = &model.User{Name: user, Zone: "iplant"}
+	}
+	return &Event{Type: ETRead, Path: path, Timestamp: &t}, msg
+}
+
+// TestDuplicateFilter verifies that only identical read events within the duplicate window are suppressed.
+func TestDuplicateFilter(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name      string
+		user      string
+		path      string
+		eventType string
+		offset    time.Duration
+		expected  bool
+	}{
+		{"identical event", "ipcdev", "/foo", ETRead, time.Second, true},
+		{"earlier event", "ipcdev", "/foo", ETRead, -time.Second, true},
+		{"outside the window", "ipcdev", "/foo", ETRead, time.Minute, false},
+		{"different user", "ipctest", "/foo", ETRead, time.Second, false},
+		{"different path", "ipcdev", "/bar", ETRead, time.Second, false},
+		{"different event type", "ipcdev", "/foo", ETCreate, time.Second, false},
+		{"unknown user", "", "/foo", ETRead, time.Second, false},
+	}
+
+	for _, test := range tests {
+		c := newRecentEvents(10*time.Second, DefaultDuplicateCacheSize)
+		f := newDuplicateFilter(c)
+		if f.suppress(testRead("ipcdev", "/foo", start)) {
+			t.Fatalf("%s: the first event was suppressed", test.name)
+		}
+		f.commit()
+
+		event, msg := testRead(test.user, test.path, start.Add(test.offset))
+		event.Type = test.eventType
+		if actual := newDuplicateFilter(c).suppress(event, msg); actual != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, actual)
+		}
+	}
+}
+
+// TestAnonymousReadsNotSuppressed verifies that reads by anonymous users aren't suppressed, because they may have been
+// made by different people.
+func TestAnonymousReadsNotSuppressed(t *testing.T) {
+	start := time.Now()
+	c := newRecentEvents(10*time.Second, DefaultDuplicateCacheSize)
+	for i := 0; i < 2; i++ {
+		event, msg := testRead("", "/foo", start.Add(time.Duration(i)*time.Second))
+		msg.Author = model.NewAnonymousUser(model.PublicSubject)
+		f := newDuplicateFilter(c)
+		if f.suppress(event, msg) {
+			t.Errorf("anonymous read %d was suppressed", i+1)
+		}
+		f.commit()
+	}
+}
+
+// TestDuplicateReadSuppression verifies that the recorder doesn't insert read events that duplicate recent events,
+// either in the same batch or in an earlier batch, and that events are only remembered once they've been recorded.
+func TestDuplicateReadSuppression(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetDuplicateWindow(time.Minute, DefaultDuplicateCacheSize)
+	msg := getTestMessage()
+
+	// The first attempt fails, so the event isn't remembered. The second attempt records only one of the events.
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").WillReturnError(fmt.Errorf("permission denied"))
+	mock.ExpectRollback()
+	expectCurrentVersions(mock)
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	if _, err := r.RecordEvent(context.Background(), ReadKey, msg); err == nil {
+		t.Fatal("an error was expected but none was encountered")
+	}
+	requests := []*EventRequest{{Key: ReadKey, Msg: msg}, {Key: LegacyReadKey, Msg: msg}}
+	events, err := r.RecordEvents(context.Background(), requests)
+	if err != nil {
+		t.Fatalf("error encountered while recording events: %s", err)
+	}
+	if events[0].Duplicate || events[0].ID != 1 || !events[1].Duplicate || events[1].ID != 0 {
+		t.Errorf("unexpected events returned: %+v, %+v", events[0], events[1])
+	}
+
+	// A later event for the same read is suppressed without accessing the database.
+	event, err := r.RecordEvent(context.Background(), ReadKey, msg)
+	if err != nil {
+		t.Fatalf("error encountered while recording event: %s", err)
+	}
+	if !event.Duplicate {
+		t.Errorf("the event was not suppressed: %+v", event)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
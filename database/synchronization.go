@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// The statement used to flag a data object that the coordinating node failed to synchronize as needing attention,
+// along with the description of the error. The flag isn't set if the object was synchronized or failed to synchronize
+// more recently, so that a failure that's processed out of order doesn't flag an object that has since been
+// synchronized. The permanent identifier and the path of the data object are returned.
+var flagSynchronizationFailure = named(`
+UPDATE data_objects SET
+    needs_attention = true,
+    sync_failure = :sync_failure,
+    sync_failed_at = :synchronized_at
+WHERE permanent_id = ` + registeredPermanentID + `
+AND coalesce(sync_failed_at, '-infinity') <= :synchronized_at
+AND coalesce(synchronized_at, '-infinity') < :synchronized_at
+RETURNING permanent_id, irods_path;
+`)
+
+// The statement used to clear the flag of a data object that the coordinating node synchronized successfully. The flag
+// isn't cleared if the object failed to synchronize more recently. The permanent identifier and the path of the data
+// object are returned.
+var clearSynchronizationFailure = named(`
+UPDATE data_objects SET
+    needs_attention = false,
+    sync_failure = NULL,
+    synchronized_at = :synchronized_at
+WHERE permanent_id = ` + registeredPermanentID + `
+AND coalesce(sync_failed_at, '-infinity') <= :synchronized_at
+AND coalesce(synchronized_at, '-infinity') <= :synchronized_at
+RETURNING permanent_id, irods_path;
+`)
+
+// The statement used to list the data objects that are flagged as needing attention, starting with the ones that
+// failed to synchronize first.
+var listFlaggedObjects = named(`
+SELECT permanent_id, irods_path, sync_failure, sync_failed_at
+FROM data_objects
+WHERE needs_attention
+ORDER BY sync_failed_at, permanent_id
+LIMIT :limit;
+`)
+
+// SynchronizationFailure describes a data object that the coordinating node failed to synchronize and that hasn't been
+// synchronized since. The description is the description of the error reported by the coordinating node, which is nil
+// if it didn't describe the error.
+type SynchronizationFailure struct {
+	PermanentID string
+	Path        string
+	Description *string
+	FailedAt    time.Time
+}
+
+// storeSynchronization flags or clears the flag of the data object in a synchronization message, depending on the
+// status of the synchronization. The permanent identifier and the path of the data object are returned, or empty
+// strings if the object isn't registered or its synchronization status was updated more recently.
+func storeSynchronization(ctx context.Context, q queryer, msg *model.Message, t time.Time) (string, string, error) {
+	statement := clearSynchronizationFailure
+	values := namedArgs{
+		"entity_uuid":     uuidArg(msg.UUID),
+		"permanent_id":    msg.Entity,
+		"synchronized_at": t,
+	}
+	if msg.SynchronizationResult() == model.SynchronizationFailed {
+		statement = flagSynchronizationFailure
+		values["sync_failure"] = nil
+		if msg.FailureReason != "" {
+			values["sync_failure"] = msg.FailureReason
+		}
+	}
+
+	rows, err := queryNamed(ctx, q, statement, values)
+	if err != nil {
+		return "", "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", "", rows.Err()
+	}
+	var permanentID, path string
+	if err := rows.Scan(&permanentID, &path); err != nil {
+		return "", "", err
+	}
+	return permanentID, path, rows.Close()
+}
+
+// recordSynchronization is the function that DefaultRecorder uses to record the synchronization failures that the
+// coordinating node reports. The data object is flagged as needing attention along with the description of the error,
+// and a synchronization failed event is recorded under its permanent identifier at the time reported by the
+// coordinating node. A later message reporting a successful synchronization clears the flag without recording an
+// event. Messages for data objects that aren't registered and messages that are older than the last synchronization
+// status of the data object are logged and skipped.
+func recordSynchronization(
+	ctx context.Context, tx *sql.Tx, r Recorder, key string, msg *model.Message,
+) (*Event, error) {
+	if msg.Entity == "" {
+		return nil, fmt.Errorf("synchronization message for path '%s' doesn't identify the data object", msg.Path)
+	}
+	event := newEvent(r, ETSynchronizationFailed, msg)
+	permanentID, path, err := storeSynchronization(ctx, tx, msg, *event.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case permanentID == "":
+		logger.Log.Infof("skipping the synchronization status of data object %s, which either isn't registered or "+
+			"has a more recent status", msg.Entity)
+		return nil, nil
+	case msg.SynchronizationResult() != model.SynchronizationFailed:
+		logger.Log.Infof("data object %s was synchronized with the coordinating node", permanentID)
+		return nil, nil
+	}
+
+	logger.Log.Warnf("the coordinating node failed to synchronize data object %s: %s", permanentID, msg.FailureReason)
+	event.Path = path
+	rows := []*eventRow{{entity: permanentID, event: event}}
+	if err := insertEvents(ctx, tx, statementsFor(r), rows); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// FlaggedObjects returns up to the given number of data objects that are flagged as needing attention because the
+// coordinating node failed to synchronize them, starting with the ones that failed first.
+func FlaggedObjects(ctx context.Context, db *sql.DB, limit int) ([]*SynchronizationFailure, error) {
+	rows, err := queryNamed(ctx, db, listFlaggedObjects, namedArgs{"limit": limit})
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	defer rows.Close()
+
+	var result []*SynchronizationFailure
+	for rows.Next() {
+		failure := &SynchronizationFailure{}
+		if err := rows.Scan(&failure.PermanentID, &failure.Path, &failure.Description, &failure.FailedAt); err != nil {
+			return nil, classifyError(err)
+		}
+		result = append(result, failure)
+	}
+	return result, classifyError(rows.Err())
+}
@@ -0,0 +1,194 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// getSynchronizationMessage returns a message relayed from the coordinating node that reports the synchronization
+// status of the test data object by its permanent identifier alone.
+func getSynchronizationMessage(t time.Time, status, description string) *model.Message {
+	msg := getTimestampedMessage(t)
+	msg.Path = ""
+	msg.SyncStatus = status
+	msg.FailureReason = description
+	return msg
+}
+
+// TestRecordSynchronization verifies that a synchronization failure flags the data object and records an event under
+// its permanent identifier and stored path, that a successful synchronization clears the flag without recording an
+// event, and that messages for unregistered objects or with stale statuses are skipped.
+func TestRecordSynchronization(t *testing.T) {
+	reported := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	path := "/iplant/home/shared/commons-repo/curated/foo.txt"
+	tests := []struct {
+		name        string
+		status      string
+		description string
+		registered  bool
+		event       bool
+	}{
+		{"failed", model.SynchronizationFailed, "invalid system metadata", true, true},
+		{"no status", "", "", true, true},
+		{"completed", model.SynchronizationCompleted, "", true, false},
+		{"unregistered or stale", model.SynchronizationFailed, "invalid system metadata", false, false},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("%s: error opening stub database connection: %s", test.name, err)
+		}
+		r := getTestRecorder(db)
+		msg := getSynchronizationMessage(reported, test.status, test.description)
+
+		stored := sqlmock.NewRows([]string{"permanent_id", "irods_path"})
+		if test.registered {
+			stored.AddRow("fakepid", path)
+		}
+		mock.ExpectBegin()
+		if msg.SynchronizationResult() == model.SynchronizationFailed {
+			var description interface{}
+			if test.description != "" {
+				description = test.description
+			}
+			mock.ExpectQuery("UPDATE data_objects SET needs_attention = true").
+				WithArgs(description, reported, msg.UUID, msg.Entity).
+				WillReturnRows(stored)
+		} else {
+			mock.ExpectQuery("UPDATE data_objects SET needs_attention = false").
+				WithArgs(reported, msg.UUID, msg.Entity).
+				WillReturnRows(stored)
+		}
+		if test.event {
+			// The insert is prepared on the connection pool and then again on the transaction's connection.
+			mock.ExpectPrepare("INSERT INTO event_log")
+			mock.ExpectPrepare("INSERT INTO event_log")
+			mock.ExpectQuery("INSERT INTO event_log").
+				WithArgs("fakepid", path, ETSynchronizationFailed, &reported, "fakenode").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+		}
+		mock.ExpectCommit()
+
+		event, err := r.RecordEvent(context.Background(), SynchronizationKey, msg)
+		if err != nil {
+			t.Errorf("%s: error encountered while recording the synchronization status: %s", test.name, err)
+		}
+		if test.event && (event == nil || event.Type != ETSynchronizationFailed || event.Path != path) {
+			t.Errorf("%s: expected a synchronization failed event but got %+v", test.name, event)
+		}
+		if !test.event && event != nil {
+			t.Errorf("%s: expected no event but got %+v", test.name, event)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+		db.Close()
+	}
+}
+
+// TestUnidentifiedSynchronization verifies that synchronization messages that don't identify the data object are
+// rejected without updating anything.
+func TestUnidentifiedSynchronization(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+
+	msg := getSynchronizationMessage(time.Now(), "", "invalid system metadata")
+	msg.Entity, msg.UUID = "", ""
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	if _, err := getTestRecorder(db).RecordEvent(context.Background(), SynchronizationKey, msg); err == nil {
+		t.Errorf("expected the synchronization message to be rejected")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestFlaggedObjects verifies that the data objects that need attention are listed along with the descriptions of
+// the errors reported by the coordinating node.
+func TestFlaggedObjects(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+
+	failed := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT .* FROM data_objects WHERE needs_attention").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"permanent_id", "irods_path", "sync_failure", "sync_failed_at"}).
+			AddRow("fakepid", "/iplant/home/foo", "invalid system metadata", failed).
+			AddRow("otherpid", "/iplant/home/bar", nil, failed))
+
+	flagged, err := FlaggedObjects(context.Background(), db, 10)
+	switch {
+	case err != nil:
+		t.Fatalf("unable to list the flagged data objects: %s", err)
+	case len(flagged) != 2:
+		t.Fatalf("expected 2 flagged data objects but got %d", len(flagged))
+	case flagged[0].PermanentID != "fakepid" || !flagged[0].FailedAt.Equal(failed):
+		t.Errorf("unexpected flagged data object: %+v", flagged[0])
+	case flagged[0].Description == nil || *flagged[0].Description != "invalid system metadata":
+		t.Errorf("unexpected description: %v", flagged[0].Description)
+	case flagged[1].Description != nil:
+		t.Errorf("unexpected description: %v", flagged[1].Description)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestSynchronizationDrivers verifies that data objects are flagged and cleared with both database drivers, and that
+// a failure that's processed after a more recent successful synchronization doesn't flag the object again.
+func TestSynchronizationDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := getTestRecorder(db)
+		ctx := context.Background()
+		added := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+		addition := getAddMessage(added, 1024, "sha2:checksum")
+		if _, err := r.RecordEvent(ctx, AddKey, addition); err != nil {
+			t.Fatalf("%s: error encountered while recording the addition: %s", driver, err)
+		}
+
+		record := func(msg *model.Message) *Event {
+			event, err := r.RecordEvent(ctx, SynchronizationKey, msg)
+			if err != nil {
+				t.Fatalf("%s: error encountered while recording the synchronization status: %s", driver, err)
+			}
+			return event
+		}
+		listFlagged := func() []*SynchronizationFailure {
+			flagged, err := FlaggedObjects(ctx, db, 10)
+			if err != nil {
+				t.Fatalf("%s: unable to list the flagged data objects: %s", driver, err)
+			}
+			return flagged
+		}
+
+		event := record(getSynchronizationMessage(added.Add(time.Hour), "", "invalid system metadata"))
+		if event == nil || event.Path != addition.Path {
+			t.Errorf("%s: expected a synchronization failed event but got %+v", driver, event)
+		}
+		if flagged := listFlagged(); len(flagged) != 1 || flagged[0].Description == nil {
+			t.Errorf("%s: expected the data object to be flagged but got %+v", driver, flagged)
+		}
+
+		record(getSynchronizationMessage(added.Add(3*time.Hour), model.SynchronizationCompleted, ""))
+		if event := record(getSynchronizationMessage(added.Add(2*time.Hour), "", "timed out")); event != nil {
+			t.Errorf("%s: expected the stale failure to be skipped but got %+v", driver, event)
+		}
+		if flagged := listFlagged(); len(flagged) != 0 {
+			t.Errorf("%s: expected no flagged data objects but got %+v", driver, flagged)
+		}
+		db.Close()
+	}
+}
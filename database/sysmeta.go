@@ -0,0 +1,485 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// The statement used to look up the system metadata of a data object. An object that's identified by the UUID is
+// preferred over one that's identified by the permanent identifier, which is preferred over the current version of
+// an unarchived object that's registered under the same path. Archived objects registered under the path are only
+// found if they're included, and the most recently updated one is found if no unarchived object is registered there.
+// Only the current version of an object has its UUID.
+var lookupSystemMetadata = named(`
+SELECT
+    permanent_id, entity_uuid, irods_path, node_identifier, file_size, checksum, checksum_algorithm, creator,
+    rights_holder, authoritative_member_node, serial_version, system_metadata_pending, archived, obsoletes,
+    obsoleted_by, created_at, modified_at, updated_at
+FROM data_objects
+WHERE (:entity_uuid::uuid IS NOT NULL AND entity_uuid = :entity_uuid::uuid)
+OR (:permanent_id::text <> '' AND permanent_id = :permanent_id::text)
+OR (irods_path = :irods_path AND (NOT archived OR :include_archived::boolean) AND obsoleted_by IS NULL)
+ORDER BY
+    entity_uuid = :entity_uuid::uuid DESC NULLS LAST,
+    permanent_id = :permanent_id::text DESC,
+    obsoleted_by IS NULL DESC,
+    archived,
+    updated_at DESC
+LIMIT 1;
+`)
+
+// The statement used to store the system metadata of a data object. An object that's stored again, as when a file is
+// overwritten by an upload, keeps its submitter, rights holder, authoritative member node and creation time, but its
+// path, size and checksum are updated, its serial version is incremented, it's flagged so that its system metadata is
+// synchronized again and its modification time is set to the time of the update. The size and checksum are retained
+// if they aren't known, and the system metadata is pending until both of them are. An object that was archived is
+// restored. Updates are only applied if they're at least as recent as the last update, so that an update that's
+// processed out of order doesn't overwrite a more recent one. An object that's already registered with the same UUID
+// is updated under its permanent identifier, even if the UUID is written differently. The permanent identifier of the
+// stored object is returned.
+var storeSystemMetadata = named(`
+INSERT INTO data_objects (
+    permanent_id, entity_uuid, irods_path, node_identifier, file_size, checksum, checksum_algorithm, creator,
+    rights_holder, authoritative_member_node, system_metadata_pending, created_at, updated_at
+) VALUES (
+    ` + registeredPermanentID + `, :entity_uuid::uuid, :irods_path, :node_identifier, :file_size, :checksum,
+    :checksum_algorithm, :creator, :rights_holder, :authoritative_member_node,
+    :file_size::bigint IS NULL OR :checksum::text IS NULL, :updated_at, :updated_at
+)
+ON CONFLICT (permanent_id) DO UPDATE SET
+    entity_uuid = coalesce(data_objects.entity_uuid, excluded.entity_uuid),
+    irods_path = excluded.irods_path,
+    node_identifier = excluded.node_identifier,
+    file_size = coalesce(excluded.file_size, data_objects.file_size),
+    checksum = coalesce(excluded.checksum, data_objects.checksum),
+    checksum_algorithm = coalesce(excluded.checksum_algorithm, data_objects.checksum_algorithm),
+    rights_holder = coalesce(data_objects.rights_holder, excluded.rights_holder),
+    authoritative_member_node = coalesce(data_objects.authoritative_member_node, excluded.authoritative_member_node),
+    system_metadata_pending = coalesce(excluded.file_size, data_objects.file_size) IS NULL
+        OR coalesce(excluded.checksum, data_objects.checksum) IS NULL,
+    serial_version = data_objects.serial_version + 1,
+    needs_resync = true,
+    archived = false,
+    archived_at = NULL,
+    modified_at = excluded.updated_at,
+    updated_at = excluded.updated_at
+WHERE data_objects.updated_at <= excluded.updated_at
+RETURNING permanent_id;
+`)
+
+// The statement used to remove the UUID from the version of a data object that's being obsoleted, so that the new
+// version can take it. The row is locked until the transaction ends, so concurrent updates of the same version are
+// applied one after the other.
+var releaseEntityUUID = named(`
+UPDATE data_objects SET entity_uuid = NULL WHERE permanent_id = :permanent_id;
+`)
+
+// The statement used to store the system metadata of a new version of a data object. Each version can only be
+// obsoleted by one other version, so a transaction that tries to obsolete a version that a concurrent transaction has
+// already obsoleted fails with a unique violation and is attempted again, at which point it obsoletes the new version.
+var insertSuccessor = named(`
+INSERT INTO data_objects (
+    permanent_id, entity_uuid, irods_path, node_identifier, file_size, checksum, checksum_algorithm, creator,
+    rights_holder, authoritative_member_node, system_metadata_pending, obsoletes, created_at, updated_at
+) VALUES (
+    :permanent_id, :entity_uuid::uuid, :irods_path, :node_identifier, :file_size, :checksum, :checksum_algorithm,
+    :creator, :rights_holder, :authoritative_member_node, :file_size::bigint IS NULL OR :checksum::text IS NULL,
+    :obsoletes, :updated_at, :updated_at
+);
+`)
+
+// The statement used to link an obsoleted version of a data object to the version that obsoletes it. The obsoleted
+// version is flagged so that its system metadata is synchronized again.
+var linkPredecessor = named(`
+UPDATE data_objects SET
+    obsoleted_by = :obsoleted_by,
+    needs_resync = true,
+    updated_at = greatest(updated_at, :updated_at)
+WHERE permanent_id = :permanent_id;
+`)
+
+// The statement used to give a new version of a data object the access policies of the version that it obsoletes.
+var copyAccessPolicies = named(`
+INSERT INTO access_policies (permanent_id, subject, permission, updated_at)
+SELECT :successor, subject, permission, updated_at FROM access_policies WHERE permanent_id = :predecessor;
+`)
+
+// SystemMetadata describes the DataONE system metadata of a data object in the repository. The submitter is the
+// qualified name of the user who added the object. The size and checksum are nil if they aren't known yet, in which
+// case the system metadata is marked as pending. A data object that's overwritten is obsoleted by a new version with
+// its own permanent identifier, and each version refers to the ones before and after it.
+type SystemMetadata struct {
+	PermanentID       string
+	EntityUUID        *string
+	Path              string
+	NodeID            string
+	Size              *int64
+	Checksum          *string
+	ChecksumAlgorithm *string
+	Submitter         *string
+	RightsHolder      *string
+	AuthoritativeNode *string
+	SerialVersion     int
+	Pending           bool
+	Archived          bool
+	Obsoletes         *string
+	ObsoletedBy       *string
+	CreatedAt         *time.Time
+	ModifiedAt        *time.Time
+	UpdatedAt         time.Time
+}
+
+// findSystemMetadata returns the system metadata of the data object with the given UUID or permanent identifier, or
+// of the unarchived data object registered under the given path if there's no such object. An archived data object
+// registered under the path is returned if there's no unarchived one and archived objects are included. Nil is
+// returned if there's no matching object.
+func findSystemMetadata(
+	ctx context.Context, q queryer, uuid, permanentID, path string, includeArchived bool,
+) (*SystemMetadata, error) {
+	values := namedArgs{
+		"entity_uuid":      uuidArg(uuid),
+		"permanent_id":     permanentID,
+		"irods_path":       path,
+		"include_archived": includeArchived,
+	}
+	rows, err := queryNamed(ctx, q, lookupSystemMetadata, values)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	sm := &SystemMetadata{}
+	err = rows.Scan(
+		&sm.PermanentID, &sm.EntityUUID, &sm.Path, &sm.NodeID, &sm.Size, &sm.Checksum, &sm.ChecksumAlgorithm,
+		&sm.Submitter, &sm.RightsHolder, &sm.AuthoritativeNode, &sm.SerialVersion, &sm.Pending, &sm.Archived,
+		&sm.Obsoletes, &sm.ObsoletedBy, &sm.CreatedAt, &sm.ModifiedAt, &sm.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return sm, rows.Close()
+}
+
+// putSystemMetadata stores the system metadata of a data object as of the given time. It returns the permanent
+// identifier of the stored object and true if the system metadata was stored, or false if the object was updated
+// more recently.
+func putSystemMetadata(ctx context.Context, q queryer, sm *SystemMetadata, updatedAt time.Time) (string, bool, error) {
+	values := namedArgs{
+		"entity_uuid":               sm.EntityUUID,
+		"permanent_id":              sm.PermanentID,
+		"irods_path":                sm.Path,
+		"node_identifier":           sm.NodeID,
+		"file_size":                 sm.Size,
+		"checksum":                  sm.Checksum,
+		"checksum_algorithm":        sm.ChecksumAlgorithm,
+		"creator":                   sm.Submitter,
+		"rights_holder":             sm.RightsHolder,
+		"authoritative_member_node": sm.AuthoritativeNode,
+		"updated_at":                updatedAt,
+	}
+	rows, err := queryNamed(ctx, q, storeSystemMetadata, values)
+	if err != nil {
+		return "", false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", false, rows.Err()
+	}
+	var permanentID string
+	if err := rows.Scan(&permanentID); err != nil {
+		return "", false, err
+	}
+	return permanentID, true, rows.Close()
+}
+
+// supersede stores the system metadata of a new version of a data object that obsoletes the given version, and links
+// the two versions in both directions. The new version takes the UUID of the obsoleted version and its access
+// policies, and a permanent identifier is generated for it if it doesn't have one. New identifiers are never in use,
+// so the versions of an object always form a chain without cycles.
+func supersede(
+	ctx context.Context, q queryer, predecessor, successor *SystemMetadata, updatedAt time.Time,
+) error {
+	if successor.PermanentID == "" {
+		permanentID, err := newPermanentID()
+		if err != nil {
+			return err
+		}
+		successor.PermanentID = permanentID
+	}
+	if successor.EntityUUID == nil {
+		successor.EntityUUID = predecessor.EntityUUID
+	}
+	successor.Obsoletes = &predecessor.PermanentID
+
+	statements := []struct {
+		statement *namedStatement
+		values    namedArgs
+	}{
+		{releaseEntityUUID, namedArgs{"permanent_id": predecessor.PermanentID}},
+		{insertSuccessor, namedArgs{
+			"permanent_id":              successor.PermanentID,
+			"entity_uuid":               successor.EntityUUID,
+			"irods_path":                successor.Path,
+			"node_identifier":           successor.NodeID,
+			"file_size":                 successor.Size,
+			"checksum":                  successor.Checksum,
+			"checksum_algorithm":        successor.ChecksumAlgorithm,
+			"creator":                   successor.Submitter,
+			"rights_holder":             successor.RightsHolder,
+			"authoritative_member_node": successor.AuthoritativeNode,
+			"obsoletes":                 predecessor.PermanentID,
+			"updated_at":                updatedAt,
+		}},
+		{linkPredecessor, namedArgs{
+			"permanent_id": predecessor.PermanentID,
+			"obsoleted_by": successor.PermanentID,
+			"updated_at":   updatedAt,
+		}},
+		{copyAccessPolicies, namedArgs{"successor": successor.PermanentID, "predecessor": predecessor.PermanentID}},
+	}
+	for _, s := range statements {
+		if _, err := execNamed(ctx, q, s.statement, s.values); err != nil {
+			return err
+		}
+	}
+	predecessor.ObsoletedBy = &successor.PermanentID
+	predecessor.EntityUUID = nil
+	return nil
+}
+
+// versionChange describes how the system metadata of a registered data object changes when new content is written to
+// the object.
+type versionChange int
+
+// The ways in which the system metadata of a registered data object can change.
+const (
+	keepVersion versionChange = iota
+	updateVersion
+	obsoleteVersion
+)
+
+// contentChange determines how the system metadata of a registered data object changes when content with the given
+// checksum, which may be nil if it isn't known, is written to the object at the given time. Archived objects and
+// objects whose checksums aren't known yet are updated in place. Otherwise, content that's older than the last update
+// or has the same checksum is ignored, and new content obsoletes the current version. Content whose checksum isn't
+// known is only treated as new if it was written after the last update, so that redelivered messages are ignored.
+func contentChange(existing *SystemMetadata, checksum *string, writtenAt time.Time) versionChange {
+	switch {
+	case existing.Archived || existing.Checksum == nil:
+		return updateVersion
+	case writtenAt.Before(existing.UpdatedAt):
+		return keepVersion
+	case checksum != nil && *checksum == *existing.Checksum:
+		return keepVersion
+	case checksum == nil && !writtenAt.After(existing.UpdatedAt):
+		return keepVersion
+	}
+	return obsoleteVersion
+}
+
+// successorOf returns the system metadata of a new version of a data object, which is taken from the given system
+// metadata. The new version keeps the rights holder and authoritative member node of the version that it obsoletes,
+// and its submitter if the given system metadata doesn't have one. The permanent identifier is left empty so that a
+// new one is generated.
+func successorOf(predecessor, sm *SystemMetadata) *SystemMetadata {
+	successor := *sm
+	successor.PermanentID = ""
+	if successor.Path == "" {
+		successor.Path = predecessor.Path
+	}
+	if successor.Submitter == nil {
+		successor.Submitter = predecessor.Submitter
+	}
+	if predecessor.RightsHolder != nil {
+		successor.RightsHolder = predecessor.RightsHolder
+	}
+	if predecessor.AuthoritativeNode != nil {
+		successor.AuthoritativeNode = predecessor.AuthoritativeNode
+	}
+	return &successor
+}
+
+// FindSystemMetadata returns the system metadata of the data object with the given UUID or permanent identifier, or
+// of the unarchived data object registered under the given path if there's no such object. Nil is returned if there's
+// no matching object. Any of the arguments may be empty.
+func (r DefaultRecorder) FindSystemMetadata(
+	ctx context.Context, uuid, permanentID, path string,
+) (*SystemMetadata, error) {
+	ctx, cancel := r.operationContext(ctx)
+	defer cancel()
+	sm, err := findSystemMetadata(ctx, r.db, uuid, permanentID, path, false)
+	return sm, classifyError(err)
+}
+
+// StoreSystemMetadata stores the system metadata of a data object as of the given time, creating it if the object
+// isn't registered and updating it otherwise. It returns the permanent identifier of the stored object and true if
+// the system metadata was stored, or false if the object was updated more recently.
+func (r DefaultRecorder) StoreSystemMetadata(
+	ctx context.Context, sm *SystemMetadata, updatedAt time.Time,
+) (string, bool, error) {
+	ctx, cancel := r.operationContext(ctx)
+	defer cancel()
+	permanentID, stored, err := putSystemMetadata(ctx, r.db, sm, updatedAt)
+	return permanentID, stored, classifyError(err)
+}
+
+// SupersedeSystemMetadata stores the system metadata of a new version of a data object that obsoletes the given
+// version, linking the two versions in both directions in a single transaction. The new version takes the UUID and
+// access policies of the obsoleted version, and a permanent identifier is generated for it if it doesn't have one.
+func (r DefaultRecorder) SupersedeSystemMetadata(
+	ctx context.Context, predecessor, successor *SystemMetadata, updatedAt time.Time,
+) error {
+	f := func(ctx context.Context, tx *sql.Tx) error {
+		return supersede(ctx, tx, predecessor, successor, updatedAt)
+	}
+	return classifyError(withTransaction(ctx, r.db, r.txOptions(), r.retry, r.operationContext, f))
+}
+
+// SetSystemMetadataDefaults sets the rights holder and the authoritative member node recorded in the system metadata
+// of new data objects. The submitter is recorded as the rights holder if the rights holder is empty, and the member
+// node that the object's events are recorded under is recorded as the authoritative member node if that's empty.
+func (r *DefaultRecorder) SetSystemMetadataDefaults(rightsHolder, authoritativeNode string) {
+	r.rightsHolder = rightsHolder
+	r.authoritativeNode = authoritativeNode
+}
+
+// The beginning and end of the query used to find the current versions of the data objects identified by a set of
+// entities in messages. Each entity may identify the current version by its UUID or an obsolete version by its
+// permanent identifier, so the obsolescence chain is followed from both of them. Only the entities whose current
+// versions have different permanent identifiers are returned. The depth of the chain is limited in case it contains a
+// cycle.
+const (
+	currentVersionsPrefix = `
+WITH RECURSIVE chain (entity, permanent_id, obsoleted_by, depth) AS (
+    SELECT requested.entity, data_objects.permanent_id, data_objects.obsoleted_by, 0
+    FROM (VALUES `
+	currentVersionsSuffix = `) AS requested (entity, entity_uuid)
+    JOIN data_objects
+    ON data_objects.entity_uuid = requested.entity_uuid OR data_objects.permanent_id = requested.entity
+    UNION ALL
+    SELECT chain.entity, data_objects.permanent_id, data_objects.obsoleted_by, chain.depth + 1
+    FROM chain
+    JOIN data_objects ON data_objects.permanent_id = chain.obsoleted_by
+    WHERE chain.depth < 1000
+)
+SELECT DISTINCT entity, permanent_id
+FROM chain
+WHERE obsoleted_by IS NULL AND permanent_id <> entity;
+`
+)
+
+// currentVersionsQuery returns the query used to find the current versions of the data objects identified by the
+// given entities, along with its arguments.
+func currentVersionsQuery(entities []string) (string, []interface{}) {
+	values := make([]string, len(entities))
+	args := make([]interface{}, 0, len(entities)*2)
+	for i, entity := range entities {
+		values[i] = fmt.Sprintf("($%d::text, $%d::uuid)", i*2+1, i*2+2)
+		args = append(args, entity, uuidArg(model.ParseUUID(entity)))
+	}
+	return currentVersionsPrefix + strings.Join(values, ", ") + currentVersionsSuffix, args
+}
+
+// currentVersions returns the permanent identifiers of the current versions of the data objects identified by the
+// given entities, for the entities that don't already identify their current versions by permanent identifier.
+func (r DefaultRecorder) currentVersions(ctx context.Context, entities []string) (map[string]string, error) {
+	ctx, cancel := r.operationContext(ctx)
+	defer cancel()
+
+	current := make(map[string]string)
+	for len(entities) > 0 {
+		n := len(entities)
+		if n > maxEventsPerInsert {
+			n = maxEventsPerInsert
+		}
+		query, args := currentVersionsQuery(entities[:n])
+		rows, err := queryContext(ctx, r.db, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var entity, permanentID string
+			if err := rows.Scan(&entity, &permanentID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			current[entity] = permanentID
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		entities = entities[n:]
+	}
+	return current, nil
+}
+
+// resolveCurrentVersions records the events in the given rows under the current versions of the data objects that
+// they refer to, so that reads of an object that was overwritten are attributed to the version that was read rather
+// than to the version identified by the message.
+func (r DefaultRecorder) resolveCurrentVersions(ctx context.Context, rows []*eventRow) error {
+	var entities []string
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		if row.entity != "" && !seen[row.entity] {
+			seen[row.entity] = true
+			entities = append(entities, row.entity)
+		}
+	}
+	if len(entities) == 0 {
+		return nil
+	}
+	current, err := r.currentVersions(ctx, entities)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if permanentID, ok := current[row.entity]; ok {
+			row.entity = permanentID
+		}
+	}
+	return nil
+}
+
+// systemMetadataDefaulter is implemented by recorders that have default values for fields of the system metadata.
+type systemMetadataDefaulter interface {
+	systemMetadataDefaults() (string, string)
+}
+
+// systemMetadataDefaults returns the default rights holder and authoritative member node of a DefaultRecorder.
+func (r DefaultRecorder) systemMetadataDefaults() (string, string) {
+	return r.rightsHolder, r.authoritativeNode
+}
+
+// systemMetadataDefaults returns the rights holder and authoritative member node that a recorder records for new data
+// objects, either of which may be empty.
+func systemMetadataDefaults(r Recorder) (string, string) {
+	if d, ok := r.(systemMetadataDefaulter); ok {
+		return d.systemMetadataDefaults()
+	}
+	return "", ""
+}
+
+// newPermanentID generates a permanent identifier for a data object whose messages don't identify it. The identifier
+// is a random UUID URN, so it can't be mistaken for the UUID that iRODS assigns to the object.
+func newPermanentID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("unable to generate a permanent identifier: %s", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
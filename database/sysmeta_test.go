@@ -0,0 +1,425 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// systemMetadataColumns lists the columns returned by the system metadata lookup.
+var systemMetadataColumns = []string{
+	"permanent_id", "entity_uuid", "irods_path", "node_identifier", "file_size", "checksum", "checksum_algorithm",
+	"creator", "rights_holder", "authoritative_member_node", "serial_version", "system_metadata_pending", "archived",
+	"obsoletes", "obsoleted_by", "created_at", "modified_at", "updated_at",
+}
+
+// expectCurrentVersions describes the lookup of the current versions of the data objects that events refer to, which
+// finds that the events already refer to the current versions.
+func expectCurrentVersions(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("WITH RECURSIVE chain").WillReturnRows(sqlmock.NewRows([]string{"entity", "permanent_id"}))
+}
+
+// registeredSystemMetadata returns the system metadata of a registered data object for testing. The object has the
+// given permanent identifier and checksum, which may be nil, and was last updated at the given time.
+func registeredSystemMetadata(permanentID, path string, checksum *string, updated time.Time) *SystemMetadata {
+	size, creator, node := int64(512), "ipcdev#iplant", "fakenode"
+	return &SystemMetadata{
+		PermanentID:       permanentID,
+		Path:              path,
+		NodeID:            node,
+		Size:              &size,
+		Checksum:          checksum,
+		Submitter:         &creator,
+		RightsHolder:      &creator,
+		AuthoritativeNode: &node,
+		SerialVersion:     1,
+		CreatedAt:         &updated,
+		UpdatedAt:         updated,
+	}
+}
+
+// systemMetadataRows returns the result of a system metadata lookup that finds the given system metadata, or nothing
+// if the system metadata is nil.
+func systemMetadataRows(sm *SystemMetadata) *sqlmock.Rows {
+	rows := sqlmock.NewRows(systemMetadataColumns)
+	if sm != nil {
+		rows.AddRow(
+			sm.PermanentID, sm.EntityUUID, sm.Path, sm.NodeID, sm.Size, sm.Checksum, sm.ChecksumAlgorithm,
+			sm.Submitter, sm.RightsHolder, sm.AuthoritativeNode, sm.SerialVersion, sm.Pending, sm.Archived,
+			sm.Obsoletes, sm.ObsoletedBy, sm.CreatedAt, sm.ModifiedAt, sm.UpdatedAt,
+		)
+	}
+	return rows
+}
+
+// generatedPermanentID matches statement arguments that are generated permanent identifiers.
+type generatedPermanentID struct{}
+
+// Match determines whether or not a statement argument is a generated permanent identifier.
+func (generatedPermanentID) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, "urn:uuid:") && len(s) == len("urn:uuid:")+36
+}
+
+// TestNewPermanentID verifies that generated permanent identifiers are random version 4 UUID URNs.
+func TestNewPermanentID(t *testing.T) {
+	first, err := newPermanentID()
+	if err != nil {
+		t.Fatalf("unable to generate a permanent identifier: %s", err)
+	}
+	second, err := newPermanentID()
+	if err != nil {
+		t.Fatalf("unable to generate a permanent identifier: %s", err)
+	}
+	if !(generatedPermanentID{}).Match(first) || first[len("urn:uuid:")+14] != '4' {
+		t.Errorf("unexpected permanent identifier: %s", first)
+	}
+	if first == second {
+		t.Errorf("expected different permanent identifiers but got %s twice", first)
+	}
+}
+
+// TestFindSystemMetadata verifies that the system metadata of a registered data object is returned and that nil is
+// returned for objects that aren't registered.
+func TestFindSystemMetadata(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	path := "/iplant/home/shared/commons-repo/curated/foo.txt"
+	checksum := "md5:checksum"
+	registered := registeredSystemMetadata("fakepid", path, &checksum, time.Now())
+
+	mock.ExpectQuery("SELECT (.+) FROM data_objects").
+		WithArgs(nil, "fakepid", path, false).
+		WillReturnRows(systemMetadataRows(registered))
+	mock.ExpectQuery("SELECT (.+) FROM data_objects").
+		WithArgs(nil, "", path, false).
+		WillReturnRows(systemMetadataRows(nil))
+
+	sm, err := r.FindSystemMetadata(context.Background(), "", "fakepid", path)
+	switch {
+	case err != nil:
+		t.Errorf("unable to look up the system metadata: %s", err)
+	case sm == nil || sm.PermanentID != "fakepid" || sm.Size == nil || *sm.Size != 512 || sm.SerialVersion != 1:
+		t.Errorf("unexpected system metadata: %+v", sm)
+	case sm.RightsHolder == nil || *sm.RightsHolder != "ipcdev#iplant" || sm.ModifiedAt != nil:
+		t.Errorf("unexpected system metadata: %+v", sm)
+	}
+
+	if sm, err := r.FindSystemMetadata(context.Background(), "", "", path); err != nil || sm != nil {
+		t.Errorf("expected no system metadata but got %+v (error: %v)", sm, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestSystemMetadataDrivers verifies that system metadata is created, found and updated with each of the supported
+// drivers, and that it's pending until the size and checksum of the data object are known.
+func TestSystemMetadataDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		ctx := context.Background()
+		created := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+		submitter, checksum := "ipcdev#iplant", "sha2:checksum"
+		sm := &SystemMetadata{
+			PermanentID: "fakepid",
+			Path:        "/iplant/home/shared/commons-repo/curated/foo.txt",
+			NodeID:      "fakenode",
+			Submitter:   &submitter,
+		}
+
+		if _, stored, err := r.StoreSystemMetadata(ctx, sm, created); err != nil || !stored {
+			t.Fatalf("%s: unable to store the system metadata (stored: %t): %v", driver, stored, err)
+		}
+		found, err := r.FindSystemMetadata(ctx, "", "", sm.Path)
+		if err != nil || found == nil || !found.Pending || found.SerialVersion != 1 {
+			t.Errorf("%s: expected pending system metadata but got %+v (error: %v)", driver, found, err)
+		}
+
+		size := int64(1024)
+		sm.Size, sm.Checksum = &size, &checksum
+		if _, stored, err := r.StoreSystemMetadata(ctx, sm, created.Add(-time.Hour)); err != nil || stored {
+			t.Errorf("%s: expected the stale update to be skipped (stored: %t): %v", driver, stored, err)
+		}
+		if _, stored, err := r.StoreSystemMetadata(ctx, sm, created.Add(time.Hour)); err != nil || !stored {
+			t.Errorf("%s: unable to update the system metadata (stored: %t): %v", driver, stored, err)
+		}
+		found, err = r.FindSystemMetadata(ctx, "", "fakepid", "")
+		if err != nil || found == nil || found.Pending || found.SerialVersion != 2 || *found.Submitter != submitter {
+			t.Errorf("%s: unexpected updated system metadata: %+v (error: %v)", driver, found, err)
+		}
+		db.Close()
+	}
+}
+
+// TestContentChange verifies how the system metadata of registered data objects changes when new content is written.
+func TestContentChange(t *testing.T) {
+	updated := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	path := "/iplant/home/shared/commons-repo/curated/foo.txt"
+	oldChecksum, newChecksum := "md5:old", "md5:new"
+	archived := registeredSystemMetadata("fakepid", path, &oldChecksum, updated)
+	archived.Archived = true
+
+	tests := []struct {
+		name     string
+		existing *SystemMetadata
+		checksum *string
+		written  time.Time
+		expected versionChange
+	}{
+		{"pending", registeredSystemMetadata("fakepid", path, nil, updated), &newChecksum, updated, updateVersion},
+		{"archived", archived, &newChecksum, updated.Add(time.Hour), updateVersion},
+		{"new content", registeredSystemMetadata("fakepid", path, &oldChecksum, updated), &newChecksum, updated,
+			obsoleteVersion},
+		{"same content", registeredSystemMetadata("fakepid", path, &oldChecksum, updated), &oldChecksum,
+			updated.Add(time.Hour), keepVersion},
+		{"older content", registeredSystemMetadata("fakepid", path, &oldChecksum, updated), &newChecksum,
+			updated.Add(-time.Second), keepVersion},
+		{"unknown checksum", registeredSystemMetadata("fakepid", path, &oldChecksum, updated), nil,
+			updated.Add(time.Second), obsoleteVersion},
+		{"redelivered", registeredSystemMetadata("fakepid", path, &oldChecksum, updated), nil, updated, keepVersion},
+	}
+
+	for _, test := range tests {
+		if change := contentChange(test.existing, test.checksum, test.written); change != test.expected {
+			t.Errorf("%s: expected %d but got %d", test.name, test.expected, change)
+		}
+	}
+}
+
+// TestConcurrentOverwrites verifies that an overwrite that loses a race to obsolete the current version of a data
+// object is attempted again and obsoletes the version created by the overwrite that won, so that the versions form a
+// chain rather than a branch.
+func TestConcurrentOverwrites(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetRetryPolicy(RetryPolicy{Attempts: 2})
+	written := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	msg := getAddMessage(written, 1024, "sha2:third")
+	oldChecksum, winnerChecksum := "sha2:first", "sha2:second"
+	original := registeredSystemMetadata("fakepid", msg.Path, &oldChecksum, written.Add(-time.Hour))
+	winner := registeredSystemMetadata("urn:uuid:winner", msg.Path, &winnerChecksum, written.Add(-time.Minute))
+	winner.Obsoletes = &original.PermanentID
+
+	// The first attempt fails because the concurrent overwrite already obsoleted the original version.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM data_objects").WillReturnRows(systemMetadataRows(original))
+	mock.ExpectExec("UPDATE data_objects SET entity_uuid = NULL").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO data_objects").WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectRollback()
+
+	// The second attempt finds the version created by the concurrent overwrite and obsoletes it instead.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM data_objects").WillReturnRows(systemMetadataRows(winner))
+	expectSupersede(mock, winner.PermanentID)
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectQuery("INSERT INTO event_log").
+		WithArgs(&generatedPermanentID{}, msg.Path, ETUpdate, &written, "fakenode").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectCommit()
+
+	event, err := r.RecordEvent(context.Background(), AddKey, msg)
+	if err != nil {
+		t.Errorf("error encountered while recording the overwrite: %s", err)
+	}
+	if event == nil || event.Type != ETUpdate {
+		t.Errorf("expected an update event but got %+v", event)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// versionChain returns the permanent identifiers of the versions of a data object, starting with the given version
+// and following the obsoleted_by links. It fails the test if the links don't agree in both directions or form a cycle.
+func versionChain(t *testing.T, db *sql.DB, first string) []string {
+	var chain []string
+	seen := make(map[string]bool)
+	var previous *string
+	for current := &first; current != nil; {
+		if seen[*current] {
+			t.Fatalf("the versions of %s form a cycle: %v", first, chain)
+		}
+		seen[*current] = true
+		chain = append(chain, *current)
+
+		var obsoletes, obsoletedBy *string
+		query := "SELECT obsoletes, obsoleted_by FROM data_objects WHERE permanent_id = $1"
+		if err := db.QueryRow(query, *current).Scan(&obsoletes, &obsoletedBy); err != nil {
+			t.Fatalf("unable to look up version %s: %s", *current, err)
+		}
+		if (previous == nil) != (obsoletes == nil) || (previous != nil && *previous != *obsoletes) {
+			t.Fatalf("version %s obsoletes %v rather than %v", *current, obsoletes, previous)
+		}
+		previous, current = current, obsoletedBy
+	}
+	return chain
+}
+
+// TestObsolescenceChainDrivers verifies that three consecutive overwrites of a data object produce a chain of four
+// versions linked in both directions, with the UUID of the object belonging to the current version.
+func TestObsolescenceChainDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		ctx := context.Background()
+		added := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+		var events []*Event
+		for i, checksum := range []string{"sha2:a", "sha2:b", "sha2:c", "sha2:d"} {
+			msg := getAddMessage(added.Add(time.Duration(i)*time.Millisecond), 1024, checksum)
+			event, err := r.RecordEvent(ctx, AddKey, msg)
+			if err != nil {
+				t.Fatalf("%s: error encountered while recording addition %d: %s", driver, i+1, err)
+			}
+			events = append(events, event)
+		}
+		if events[0] == nil || events[0].Type != ETCreate {
+			t.Errorf("%s: expected a create event but got %+v", driver, events[0])
+		}
+		for i, event := range events[1:] {
+			if event == nil || event.Type != ETUpdate {
+				t.Errorf("%s: expected an update event for overwrite %d but got %+v", driver, i+1, event)
+			}
+		}
+
+		msg := getTestMessage()
+		chain := versionChain(t, db, msg.Entity)
+		if len(chain) != 4 {
+			t.Errorf("%s: expected 4 versions but got %v", driver, chain)
+		}
+		current, err := r.FindSystemMetadata(ctx, msg.UUID, "", "")
+		switch {
+		case err != nil:
+			t.Errorf("%s: unable to look up the current version: %s", driver, err)
+		case current == nil || current.PermanentID != chain[len(chain)-1] || *current.Checksum != "sha2:d":
+			t.Errorf("%s: unexpected current version: %+v", driver, current)
+		}
+		db.Close()
+	}
+}
+
+// TestUpdateRacingReadDrivers verifies that a read of a data object that's recorded at the same time as an overwrite
+// of the object is recorded, whichever transaction wins, and that the overwrite still creates a single new version.
+func TestUpdateRacingReadDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		ctx := context.Background()
+		added := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+		if _, err := r.RecordEvent(ctx, AddKey, getAddMessage(added, 1024, "sha2:a")); err != nil {
+			t.Fatalf("%s: error encountered while recording the addition: %s", driver, err)
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, errs[0] = r.RecordEvent(ctx, AddKey, getAddMessage(added.Add(time.Minute), 2048, "sha2:b"))
+		}()
+		go func() {
+			defer wg.Done()
+			_, errs[1] = r.RecordEvent(ctx, ReadKey, getTimestampedMessage(added.Add(time.Minute)))
+		}()
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				t.Errorf("%s: error encountered while recording concurrent events: %s", driver, err)
+			}
+		}
+
+		var reads int
+		if err := db.QueryRow("SELECT count(*) FROM event_log WHERE event = $1", ETRead).Scan(&reads); err != nil {
+			t.Errorf("%s: unable to count the read events: %s", driver, err)
+		} else if reads != 1 {
+			t.Errorf("%s: expected 1 read event but got %d", driver, reads)
+		}
+		if chain := versionChain(t, db, getTestMessage().Entity); len(chain) != 2 {
+			t.Errorf("%s: expected 2 versions but got %v", driver, chain)
+		}
+		db.Close()
+	}
+}
+
+// TestReadAfterOverwrite verifies that a read of a data object that was overwritten is recorded under the current
+// version of the object rather than under the version identified by the message, along with its last access time.
+func TestReadAfterOverwrite(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetTrackLastAccessed(true)
+	timestamp := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	msg := getTimestampedMessage(timestamp)
+	successor := "urn:uuid:0d7f5a3c-6c1e-4b55-9a7e-3f2d1c0b9a88"
+
+	mock.ExpectQuery("WITH RECURSIVE chain").
+		WithArgs(msg.Entity, msg.UUID).
+		WillReturnRows(sqlmock.NewRows([]string{"entity", "permanent_id"}).AddRow(msg.Entity, successor))
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").
+		WithArgs(successor, msg.Path, ETRead, timestamp, "fakenode").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectExec("INSERT INTO object_access").
+		WithArgs(successor, timestamp).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, err := r.RecordEvent(context.Background(), ReadKey, msg); err != nil {
+		t.Fatalf("error encountered while recording the read: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestReadAfterOverwriteDrivers verifies that a read of a data object that was overwritten is recorded under the
+// current version of the object in a real database with each of the supported drivers.
+func TestReadAfterOverwriteDrivers(t *testing.T) {
+	for _, driver := range []string{"postgres", "pgx"} {
+		db := openTestDatabase(t, driver)
+		r := NewRecorder(db, getKeyNames(), "fakenode")
+		ctx := context.Background()
+		added := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+		for i, checksum := range []string{"sha2:a", "sha2:b"} {
+			msg := getAddMessage(added.Add(time.Duration(i)*time.Minute), 1024, checksum)
+			if _, err := r.RecordEvent(ctx, AddKey, msg); err != nil {
+				t.Fatalf("%s: error encountered while recording addition %d: %s", driver, i+1, err)
+			}
+		}
+		if _, err := r.RecordEvent(ctx, ReadKey, getTimestampedMessage(added.Add(time.Hour))); err != nil {
+			t.Fatalf("%s: error encountered while recording the read: %s", driver, err)
+		}
+
+		chain := versionChain(t, db, getTestMessage().Entity)
+		var permanentID string
+		query := "SELECT permanent_id FROM event_log WHERE event = $1"
+		if err := db.QueryRow(query, ETRead).Scan(&permanentID); err != nil {
+			t.Errorf("%s: unable to look up the read event: %s", driver, err)
+		} else if len(chain) != 2 || permanentID != chain[1] {
+			t.Errorf("%s: expected the read to be recorded under the current version of %v but got %s", driver, chain,
+				permanentID)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Postgres error codes that indicate that a transaction conflicted with a concurrent transaction. A unique violation
+// is included because two workers may try to create the same row at the same time; when the transaction that lost the
+// race is attempted again, it sees the row created by the other transaction.
+var conflictErrorCodes = map[string]bool{
+	"40001": true, // serialization failure
+	"40P01": true, // deadlock detected
+	"23505": true, // unique violation
+}
+
+// isConflict determines whether or not an error indicates that a transaction conflicted with a concurrent transaction.
+func isConflict(err error) bool {
+	code, ok := sqlState(err)
+	return ok && conflictErrorCodes[code]
+}
+
+// isolationLevels maps the names of the supported isolation levels to the corresponding database/sql values.
+var isolationLevels = map[string]sql.IsolationLevel{
+	"default":         sql.LevelDefault,
+	"read-committed":  sql.LevelReadCommitted,
+	"repeatable-read": sql.LevelRepeatableRead,
+	"serializable":    sql.LevelSerializable,
+}
+
+// ParseIsolationLevel returns the transaction isolation level with the given name. The supported names are default,
+// read-committed, repeatable-read and serializable. An empty name selects the database's default isolation level.
+func ParseIsolationLevel(name string) (sql.IsolationLevel, error) {
+	if name == "" {
+		return sql.LevelDefault, nil
+	}
+	level, ok := isolationLevels[name]
+	if !ok {
+		return sql.LevelDefault, fmt.Errorf("unsupported transaction isolation level: %s", name)
+	}
+	return level, nil
+}
+
+// transactionFunc is a function that is called within a database transaction. The context is the one that the
+// transaction was started with.
+type transactionFunc func(context.Context, *sql.Tx) error
+
+// execFunc executes a statement within a transaction. It allows the statements that keep the summary tables up to date
+// to be executed in both database/sql and pgx transactions.
+type execFunc func(ctx context.Context, query string, args ...interface{}) error
+
+// sqlExec returns a function that executes statements within a database/sql transaction.
+func sqlExec(tx *sql.Tx) execFunc {
+	return func(ctx context.Context, query string, args ...interface{}) error {
+		_, err := execContext(ctx, tx, query, args...)
+		return err
+	}
+}
+
+// contextFunc derives the context used for a single operation from a parent context.
+type contextFunc func(context.Context) (context.Context, context.CancelFunc)
+
+// withTransaction calls a function within a database transaction, committing the transaction if the function succeeds
+// and rolling it back otherwise. The transaction is attempted again according to the retry policy if it fails because
+// it conflicted with a concurrent transaction or the connection was lost. Each attempt uses its own context derived
+// from the parent context.
+func withTransaction(
+	ctx context.Context, db *sql.DB, opts *sql.TxOptions, policy RetryPolicy, derive contextFunc, f transactionFunc,
+) error {
+	return retryTransient(ctx, policy, func() (bool, error) {
+		return attemptTransaction(ctx, db, opts, derive, f)
+	})
+}
+
+// attemptTransaction makes a single attempt to call a function within a database transaction. It returns whether or
+// not the failure, if any, occurred while the transaction was being committed.
+func attemptTransaction(
+	ctx context.Context, db *sql.DB, opts *sql.TxOptions, derive contextFunc, f transactionFunc,
+) (bool, error) {
+	ctx, cancel := derive(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return false, err
+	}
+	if err := f(ctx, tx); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
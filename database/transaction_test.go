@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestParseIsolationLevel verifies that isolation level names are parsed correctly.
+func TestParseIsolationLevel(t *testing.T) {
+	tests := []struct {
+		name      string
+		expected  sql.IsolationLevel
+		expectErr bool
+	}{
+		{"", sql.LevelDefault, false},
+		{"default", sql.LevelDefault, false},
+		{"read-committed", sql.LevelReadCommitted, false},
+		{"repeatable-read", sql.LevelRepeatableRead, false},
+		{"serializable", sql.LevelSerializable, false},
+		{"chaotic", sql.LevelDefault, true},
+	}
+
+	for _, test := range tests {
+		level, err := ParseIsolationLevel(test.name)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+		}
+		if level != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, level)
+		}
+	}
+}
+
+// TestTransactionConflictRetry verifies that a transaction that conflicts with a concurrent transaction is rolled back
+// and attempted again.
+func TestTransactionConflictRetry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	msg := getTestMessage()
+
+	// The first attempt fails because of a serialization failure and the second succeeds.
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").WillReturnError(&pq.Error{Code: "40001"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectCommit()
+
+	r := NewRecorder(db, getKeyNames(), "fakenode")
+	r.SetIsolationLevel(sql.LevelSerializable)
+	event, err := r.RecordEvent(context.Background(), ReadKey, msg)
+	if err != nil {
+		t.Fatalf("error encountered while recording event: %s", err)
+	}
+	if event.ID != 42 {
+		t.Errorf("unexpected event ID: %d", event.ID)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestTransactionConflictLimit verifies that a transaction that keeps conflicting with concurrent transactions is only
+// attempted a limited number of times.
+func TestTransactionConflictLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	expectCurrentVersions(mock)
+	mock.ExpectPrepare("INSERT INTO event_log")
+	for i := 0; i < DefaultRetryAttempts; i++ {
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO event_log").WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+	}
+
+	r := getTestRecorder(db)
+	if _, err := r.RecordEvent(context.Background(), ReadKey, getTestMessage()); err == nil {
+		t.Error("an error was expected but none was encountered")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
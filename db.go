@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/spf13/viper"
+)
+
+// defaultDbConnectTimeout is the amount of time to spend trying to connect to the database at startup if no timeout
+// is configured.
+const defaultDbConnectTimeout = 2 * time.Minute
+
+// defaultDbOperationTimeout is the amount of time allowed for each database operation if no timeout is configured.
+const defaultDbOperationTimeout = 10 * time.Second
+
+// dbConnector waits for the database to become reachable, which is common when the service starts before the database
+// does. The delay between attempts grows exponentially until the timeout expires.
+type dbConnector struct {
+	timeout time.Duration
+	backoff *backoff
+	now     func() time.Time
+	sleep   func(time.Duration)
+}
+
+// newDbConnector creates a database connector with the given timeout.
+func newDbConnector(timeout time.Duration) *dbConnector {
+	return &dbConnector{
+		timeout: timeout,
+		backoff: &backoff{initialDelay: time.Second, maxDelay: 30 * time.Second},
+		now:     time.Now,
+		sleep:   time.Sleep,
+	}
+}
+
+// wait pings the database until the ping succeeds or the timeout expires. Each failed attempt is logged along with the
+// underlying error.
+func (c *dbConnector) wait(ping func() error) error {
+	deadline := c.now().Add(c.timeout)
+	for attempt := 1; ; attempt++ {
+		err := ping()
+		if err == nil {
+			if attempt > 1 {
+				logger.Log.Infof("connected to the database after %d attempts", attempt)
+			}
+			return nil
+		}
+
+		// Give up if the timeout has expired.
+		remaining := deadline.Sub(c.now())
+		if remaining <= 0 {
+			return fmt.Errorf("gave up after %d attempts in %s: %s", attempt, c.timeout, err)
+		}
+
+		// Wait before trying again, but not past the deadline.
+		delay := c.backoff.delay(attempt)
+		if delay > remaining {
+			delay = remaining
+		}
+		logger.Log.Errorf("database connection attempt %d failed: %s; trying again in %s", attempt, err, delay)
+		c.sleep(delay)
+	}
+}
+
+// poolSettings describes the limits that apply to the database connection pool. A maximum number of open connections
+// or a maximum lifetime of zero means that there's no limit, while a maximum number of idle connections of zero means
+// that idle connections are closed rather than kept for reuse.
+type poolSettings struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+}
+
+// getPoolSettings extracts the database connection pool settings from the configuration.
+func getPoolSettings(cfg *viper.Viper) (*poolSettings, error) {
+	ps := &poolSettings{
+		maxOpenConns:    cfg.GetInt("db.max-open-conns"),
+		maxIdleConns:    cfg.GetInt("db.max-idle-conns"),
+		connMaxLifetime: cfg.GetDuration("db.conn-max-lifetime"),
+	}
+
+	// Validate the settings.
+	if ps.maxOpenConns < 0 {
+		return nil, fmt.Errorf("db.max-open-conns must not be negative: %d", ps.maxOpenConns)
+	}
+	if ps.maxIdleConns < 0 {
+		return nil, fmt.Errorf("db.max-idle-conns must not be negative: %d", ps.maxIdleConns)
+	}
+	if ps.maxOpenConns > 0 && ps.maxIdleConns > ps.maxOpenConns {
+		return nil, fmt.Errorf(
+			"db.max-idle-conns (%d) must not exceed db.max-open-conns (%d)", ps.maxIdleConns, ps.maxOpenConns,
+		)
+	}
+	if ps.connMaxLifetime < 0 {
+		return nil, fmt.Errorf("db.conn-max-lifetime must not be negative: %s", ps.connMaxLifetime)
+	}
+
+	return ps, nil
+}
+
+// apply applies the connection pool settings to a database connection pool.
+func (ps *poolSettings) apply(db *sql.DB) {
+	db.SetMaxOpenConns(ps.maxOpenConns)
+	db.SetMaxIdleConns(ps.maxIdleConns)
+	db.SetConnMaxLifetime(ps.connMaxLifetime)
+}
+
+// String describes the connection pool settings for logging.
+func (ps *poolSettings) String() string {
+	return fmt.Sprintf(
+		"max-open-conns=%d max-idle-conns=%d conn-max-lifetime=%s",
+		ps.maxOpenConns, ps.maxIdleConns, ps.connMaxLifetime,
+	)
+}
+
+// The supported database drivers.
+const (
+	driverPq  = "pq"
+	driverPgx = "pgx"
+)
+
+// sqlDriverNames maps the name of each supported database driver to the name that it's registered under.
+var sqlDriverNames = map[string]string{
+	driverPq:  "postgres",
+	driverPgx: "pgx",
+}
+
+// pqOnlyParameters lists the connection parameters that are understood by lib/pq but not by pgx. Pgx sends unknown
+// parameters to the server as run-time parameters, which causes the connection to fail.
+var pqOnlyParameters = []string{"binary_parameters", "disable_prepared_binary_result"}
+
+// connectionString adapts a database connection string for a driver so that the same connection string behaves the
+// same way with either driver. Lib/pq requires SSL when no SSL mode is specified, whereas pgx falls back to an
+// unencrypted connection, so the SSL mode is made explicit for pgx. Parameters that only lib/pq understands are also
+// removed or translated. Both URLs and key/value connection strings are supported, but parameters are only removed
+// from URLs.
+func connectionString(driver, dburi string) (string, error) {
+	if driver != driverPgx {
+		return dburi, nil
+	}
+
+	// Key/value connection strings are passed along unchanged apart from the SSL mode.
+	if !strings.HasPrefix(dburi, "postgres://") && !strings.HasPrefix(dburi, "postgresql://") {
+		if !strings.Contains(dburi, "sslmode=") {
+			dburi = strings.TrimSpace(dburi + " sslmode=require")
+		}
+		return dburi, nil
+	}
+
+	u, err := url.Parse(dburi)
+	if err != nil {
+		return "", fmt.Errorf("invalid database URI: %s", err)
+	}
+	query := u.Query()
+	if query.Get("sslmode") == "" {
+		query.Set("sslmode", "require")
+	}
+	if name := query.Get("fallback_application_name"); name != "" && query.Get("application_name") == "" {
+		query.Set("application_name", name)
+	}
+	query.Del("fallback_application_name")
+	for _, name := range pqOnlyParameters {
+		query.Del(name)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// getDbDriver returns the name of the configured database driver.
+func getDbDriver(cfg *viper.Viper) (string, error) {
+	driver := cfg.GetString("db.driver")
+	if _, ok := sqlDriverNames[driver]; !ok {
+		return "", fmt.Errorf("unsupported db.driver value: %s", driver)
+	}
+	return driver, nil
+}
+
+// getDbConnection establishes a connection to the DataONE event database using the given driver, retrying until the
+// database is reachable or the timeout expires. The connection pool settings are applied before the first connection
+// is made.
+func getDbConnection(driver, dburi string, timeout time.Duration, pool *poolSettings) (*sql.DB, error) {
+	dsn, err := connectionString(driver, dburi)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(sqlDriverNames[driver], dsn)
+	if err != nil {
+		return nil, err
+	}
+	pool.apply(db)
+
+	if err := newDbConnector(timeout).wait(db.Ping); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// partitionSettings describes how the partitions of a partitioned event log are maintained.
+type partitionSettings struct {
+	createMissing bool
+	monthsAhead   int
+}
+
+// getPartitionSettings extracts the event log partition settings from the configuration. The return value is nil if
+// the event log isn't partitioned.
+func getPartitionSettings(cfg *viper.Viper) (*partitionSettings, error) {
+	if !cfg.GetBool("db.partitions.enabled") {
+		return nil, nil
+	}
+	monthsAhead := cfg.GetInt("db.partitions.months-ahead")
+	if monthsAhead < 0 {
+		return nil, fmt.Errorf("db.partitions.months-ahead must not be negative: %d", monthsAhead)
+	}
+	return &partitionSettings{createMissing: cfg.GetBool("db.partitions.create-missing"), monthsAhead: monthsAhead}, nil
+}
+
+// createPartitions creates the partitions of the event log for the current month and the configured number of
+// upcoming months.
+func createPartitions(db *sql.DB, partitions *partitionSettings) error {
+	if err := database.CreatePartitions(context.Background(), db, time.Now(), partitions.monthsAhead+1); err != nil {
+		return fmt.Errorf("unable to create the event log partitions: %s", err)
+	}
+	return nil
+}
+
+// migrateSchema applies all pending schema migrations to the event database and logs each migration that was applied.
+// If the event log is partitioned, it's created as a partitioned table before the migrations are applied, and the
+// upcoming partitions are created afterward. Existing events without member nodes are assigned to the given node.
+func migrateSchema(db *sql.DB, partitions *partitionSettings, nodeID string) error {
+	if partitions != nil {
+		partitioned, err := database.CreatePartitionedEventLog(context.Background(), db)
+		if err != nil {
+			return fmt.Errorf("unable to create the partitioned event log: %s", err)
+		}
+		if !partitioned {
+			logger.Log.Warn("db.partitions.enabled is true, but the existing event log isn't partitioned")
+		}
+	}
+
+	applied, err := database.Migrate(context.Background(), db, nodeID)
+	for _, m := range applied {
+		logger.Log.Infof("applied schema migration %d: %s", m.Version, m.Description)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		logger.Log.Info("the database schema is already up to date")
+	}
+
+	if partitions != nil {
+		return createPartitions(db, partitions)
+	}
+	return nil
+}
+
+// checkSchema verifies that the event database schema is at the version required by this service, applying pending
+// migrations first if automatic migrations are enabled. The schema version is logged so that it's clear which version
+// each environment is running.
+func checkSchema(db *sql.DB, autoMigrate bool, partitions *partitionSettings, nodeID string) error {
+	if autoMigrate {
+		if err := migrateSchema(db, partitions, nodeID); err != nil {
+			return err
+		}
+	}
+
+	version, err := database.SchemaVersion(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	required := database.LatestSchemaVersion()
+	logger.Log.Infof("database schema version: %d (required: %d)", version, required)
+
+	// A newer schema is tolerated so that an older version of the service can still run during a rollback.
+	if version < required {
+		return fmt.Errorf(
+			"the database schema version (%d) is older than the required version (%d); run the migrate command "+
+				"or set db.auto-migrate to true",
+			version, required,
+		)
+	}
+	if version > required {
+		logger.Log.Warnf("the database schema version (%d) is newer than the required version (%d)", version, required)
+	}
+	return nil
+}
+
+// getReadDedupSettings extracts the duplicate read suppression window and the number of recent events to remember
+// from the configuration. A window of zero disables duplicate suppression.
+func getReadDedupSettings(cfg *viper.Viper) (time.Duration, int, error) {
+	window := cfg.GetDuration("dataone.read-dedup.window")
+	if window < 0 {
+		return 0, 0, fmt.Errorf("dataone.read-dedup.window must not be negative: %s", window)
+	}
+	size := cfg.GetInt("dataone.read-dedup.cache-size")
+	if size < 1 {
+		return 0, 0, fmt.Errorf("dataone.read-dedup.cache-size must be positive: %d", size)
+	}
+	return window, size, nil
+}
+
+// getRawPayloadSettings determines whether or not the message that produced each event should be stored alongside
+// the event, along with the maximum number of bytes of each message body to store.
+func getRawPayloadSettings(cfg *viper.Viper) (bool, int, error) {
+	enabled := cfg.GetBool("db.store-raw-payload")
+	maxSize := cfg.GetInt("db.raw-payload-max-size")
+	if enabled && maxSize < 1 {
+		return false, 0, fmt.Errorf("db.raw-payload-max-size must be positive: %d", maxSize)
+	}
+	return enabled, maxSize, nil
+}
+
+// getCollectionBatchSize extracts the maximum number of data objects to update with each statement when a collection
+// is moved or removed from the configuration.
+func getCollectionBatchSize(cfg *viper.Viper) (int, error) {
+	batchSize := cfg.GetInt("db.collections.batch-size")
+	if batchSize < 1 {
+		return 0, fmt.Errorf("db.collections.batch-size must be positive: %d", batchSize)
+	}
+	return batchSize, nil
+}
+
+// getQueryLogSettings extracts the settings used to log the statements executed by the recorder from the
+// configuration. A slow query threshold of zero disables slow query logging.
+func getQueryLogSettings(cfg *viper.Viper) (database.QueryLogSettings, error) {
+	settings := database.QueryLogSettings{
+		Enabled:        cfg.GetBool("db.log-queries"),
+		MaxValueLength: cfg.GetInt("db.log-queries-max-value-length"),
+		SlowThreshold:  cfg.GetDuration("db.slow-query-threshold"),
+	}
+	if settings.MaxValueLength < 0 {
+		return settings, fmt.Errorf("db.log-queries-max-value-length must not be negative: %d", settings.MaxValueLength)
+	}
+	if settings.SlowThreshold < 0 {
+		return settings, fmt.Errorf("db.slow-query-threshold must not be negative: %s", settings.SlowThreshold)
+	}
+	return settings, nil
+}
+
+// getDbRetryPolicy extracts the policy used to attempt database transactions again in-process from the configuration.
+func getDbRetryPolicy(cfg *viper.Viper) (database.RetryPolicy, error) {
+	policy := database.RetryPolicy{
+		Attempts:   cfg.GetInt("db.retry.attempts"),
+		Backoff:    cfg.GetDuration("db.retry.backoff"),
+		MaxBackoff: cfg.GetDuration("db.retry.max-backoff"),
+	}
+	if policy.Attempts < 1 {
+		return policy, fmt.Errorf("db.retry.attempts must be positive: %d", policy.Attempts)
+	}
+	if policy.Backoff < 0 {
+		return policy, fmt.Errorf("db.retry.backoff must not be negative: %s", policy.Backoff)
+	}
+	if policy.MaxBackoff < policy.Backoff {
+		return policy, fmt.Errorf("db.retry.max-backoff must be at least db.retry.backoff: %s", policy.MaxBackoff)
+	}
+	return policy, nil
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/spf13/viper"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// newTestDbConnector returns a database connector with a fake clock that advances whenever the connector sleeps.
+func newTestDbConnector(timeout time.Duration) *dbConnector {
+	c := newDbConnector(timeout)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	c.sleep = func(d time.Duration) { now = now.Add(d) }
+	return c
+}
+
+// TestDbConnectorLateConnection verifies that the connector keeps trying until the database becomes reachable.
+func TestDbConnectorLateConnection(t *testing.T) {
+	c := newTestDbConnector(2 * time.Minute)
+
+	attempts := 0
+	err := c.wait(func() error {
+		attempts++
+		if attempts < 4 {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if attempts != 4 {
+		t.Errorf("expected 4 attempts but got %d", attempts)
+	}
+}
+
+// TestDbConnectorTimeout verifies that the connector gives up once the timeout expires and reports the underlying
+// error.
+func TestDbConnectorTimeout(t *testing.T) {
+	c := newTestDbConnector(2 * time.Minute)
+	start := c.now()
+
+	attempts := 0
+	err := c.wait(func() error {
+		attempts++
+		return fmt.Errorf("connection refused")
+	})
+	if err == nil {
+		t.Fatal("an error was expected but none was encountered")
+	}
+	if !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("the underlying error wasn't reported: %s", err)
+	}
+	if attempts < 2 {
+		t.Errorf("expected multiple attempts but got %d", attempts)
+	}
+	if elapsed := c.now().Sub(start); elapsed != 2*time.Minute {
+		t.Errorf("expected to wait for 2m but waited for %s", elapsed)
+	}
+}
+
+// TestGetPoolSettings verifies that the database connection pool settings are loaded and validated correctly.
+func TestGetPoolSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxOpen   int
+		maxIdle   int
+		lifetime  string
+		expectErr bool
+	}{
+		{"defaults", 10, 5, "30m", false},
+		{"unlimited open connections", 0, 5, "30m", false},
+		{"equal limits", 5, 5, "0s", false},
+		{"too many idle connections", 5, 10, "30m", true},
+		{"negative open connections", -1, 0, "30m", true},
+		{"negative idle connections", 10, -1, "30m", true},
+		{"negative lifetime", 10, 5, "-1m", true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("db.max-open-conns", test.maxOpen)
+		cfg.Set("db.max-idle-conns", test.maxIdle)
+		cfg.Set("db.conn-max-lifetime", test.lifetime)
+
+		ps, err := getPoolSettings(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+			continue
+		}
+		if err == nil && (ps.maxOpenConns != test.maxOpen || ps.maxIdleConns != test.maxIdle) {
+			t.Errorf("%s: unexpected settings: %s", test.name, ps)
+		}
+	}
+}
+
+// TestCheckSchema verifies that an out-of-date schema is only accepted if automatic migrations are enabled.
+func TestCheckSchema(t *testing.T) {
+	latest := database.LatestSchemaVersion()
+	tests := []struct {
+		name        string
+		version     int
+		autoMigrate bool
+		expectErr   bool
+	}{
+		{"current", latest, false, false},
+		{"newer", latest + 1, false, false},
+		{"outdated", latest - 1, false, true},
+		{"outdated with auto-migrate", latest - 1, true, false},
+	}
+
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening stub database connection: %s", err)
+		}
+		version := test.version
+		if test.autoMigrate {
+			mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+			for v := 1; v <= latest; v++ {
+				mock.ExpectBegin()
+				mock.ExpectExec("pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT coalesce").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+				if v <= version {
+					mock.ExpectRollback()
+					continue
+				}
+				if v == 25 {
+					mock.ExpectExec("UPDATE event_log SET node_identifier").
+						WithArgs("fakenode").
+						WillReturnResult(sqlmock.NewResult(0, 0))
+				}
+				mock.ExpectExec("(CREATE|ALTER)").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("INSERT INTO schema_migrations").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				version = v
+			}
+		}
+		mock.ExpectQuery("SELECT coalesce").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+
+		err = checkSchema(db, test.autoMigrate, nil, "fakenode")
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", test.name, err)
+		}
+	}
+}
+
+// TestGetReadDedupSettings verifies that the duplicate read suppression settings are loaded and validated correctly.
+func TestGetReadDedupSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		window    time.Duration
+		size      int
+		expectErr bool
+	}{
+		{"disabled", 0, 10000, false},
+		{"enabled", 10 * time.Second, 10000, false},
+		{"negative window", -10 * time.Second, 10000, true},
+		{"empty cache", 10 * time.Second, 0, true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("dataone.read-dedup.window", test.window.String())
+		cfg.Set("dataone.read-dedup.cache-size", test.size)
+
+		window, size, err := getReadDedupSettings(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+			continue
+		}
+		if err == nil && (window != test.window || size != test.size) {
+			t.Errorf("%s: unexpected settings: %s, %d", test.name, window, size)
+		}
+	}
+}
+
+// TestGetRawPayloadSettings verifies that the raw payload settings are loaded and validated correctly.
+func TestGetRawPayloadSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		maxSize   int
+		expectErr bool
+	}{
+		{"disabled", false, 65536, false},
+		{"enabled", true, 65536, false},
+		{"disabled without a size cap", false, 0, false},
+		{"enabled without a size cap", true, 0, true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("db.store-raw-payload", test.enabled)
+		cfg.Set("db.raw-payload-max-size", test.maxSize)
+
+		enabled, maxSize, err := getRawPayloadSettings(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+			continue
+		}
+		if err == nil && (enabled != test.enabled || maxSize != test.maxSize) {
+			t.Errorf("%s: unexpected settings: %t, %d", test.name, enabled, maxSize)
+		}
+	}
+}
+
+// TestGetCollectionBatchSize verifies that the collection batch size is loaded and validated correctly.
+func TestGetCollectionBatchSize(t *testing.T) {
+	tests := []struct {
+		batchSize int
+		expectErr bool
+	}{{1000, false}, {1, false}, {0, true}, {-1, true}}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("db.collections.batch-size", test.batchSize)
+
+		batchSize, err := getCollectionBatchSize(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%d: expected error: %t, got: %v", test.batchSize, test.expectErr, err)
+			continue
+		}
+		if err == nil && batchSize != test.batchSize {
+			t.Errorf("%d: unexpected batch size: %d", test.batchSize, batchSize)
+		}
+	}
+}
+
+// TestGetQueryLogSettings verifies that the query log settings are loaded and validated correctly.
+func TestGetQueryLogSettings(t *testing.T) {
+	tests := []struct {
+		name           string
+		enabled        bool
+		maxValueLength int
+		slowThreshold  string
+		expectErr      bool
+	}{
+		{"defaults", false, 256, "1s", false},
+		{"enabled", true, 256, "1s", false},
+		{"unlimited values", true, 0, "1s", false},
+		{"no slow query logging", false, 256, "0s", false},
+		{"negative value length", true, -1, "1s", true},
+		{"negative threshold", false, 256, "-1s", true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("db.log-queries", test.enabled)
+		cfg.Set("db.log-queries-max-value-length", test.maxValueLength)
+		cfg.Set("db.slow-query-threshold", test.slowThreshold)
+
+		settings, err := getQueryLogSettings(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+			continue
+		}
+		if err == nil && (settings.Enabled != test.enabled || settings.MaxValueLength != test.maxValueLength) {
+			t.Errorf("%s: unexpected settings: %+v", test.name, settings)
+		}
+	}
+}
+
+// TestGetPartitionSettings verifies that the event log partition settings are loaded and validated correctly.
+func TestGetPartitionSettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		monthsAhead int
+		expectNil   bool
+		expectErr   bool
+	}{
+		{"disabled", false, 3, true, false},
+		{"enabled", true, 3, false, false},
+		{"current month only", true, 0, false, false},
+		{"negative months ahead", true, -1, true, true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("db.partitions.enabled", test.enabled)
+		cfg.Set("db.partitions.create-missing", true)
+		cfg.Set("db.partitions.months-ahead", test.monthsAhead)
+
+		partitions, err := getPartitionSettings(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+		}
+		if (partitions == nil) != test.expectNil {
+			t.Errorf("%s: expected nil settings: %t, got: %+v", test.name, test.expectNil, partitions)
+		}
+		if partitions != nil && (!partitions.createMissing || partitions.monthsAhead != test.monthsAhead) {
+			t.Errorf("%s: unexpected settings: %+v", test.name, partitions)
+		}
+	}
+}
+
+// TestGetDbRetryPolicy verifies that the in-process retry policy is loaded and validated correctly.
+func TestGetDbRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		attempts   int
+		backoff    string
+		maxBackoff string
+		expectErr  bool
+	}{
+		{"defaults", 3, "50ms", "1s", false},
+		{"no retries", 1, "0s", "0s", false},
+		{"no attempts", 0, "50ms", "1s", true},
+		{"negative backoff", 3, "-50ms", "1s", true},
+		{"small maximum", 3, "50ms", "10ms", true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("db.retry.attempts", test.attempts)
+		cfg.Set("db.retry.backoff", test.backoff)
+		cfg.Set("db.retry.max-backoff", test.maxBackoff)
+
+		policy, err := getDbRetryPolicy(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+			continue
+		}
+		if err == nil && policy.Attempts != test.attempts {
+			t.Errorf("%s: unexpected policy: %+v", test.name, policy)
+		}
+	}
+}
+
+// TestConnectionString verifies that database connection strings are adapted for each driver correctly.
+func TestConnectionString(t *testing.T) {
+	tests := []struct {
+		name     string
+		driver   string
+		uri      string
+		expected string
+	}{
+		{
+			"pq",
+			driverPq,
+			"postgresql://de@dedb:5432/de?fallback_application_name=indexer",
+			"postgresql://de@dedb:5432/de?fallback_application_name=indexer",
+		},
+		{
+			"pgx with SSL mode",
+			driverPgx,
+			"postgresql://de@dedb:5432/de?sslmode=disable",
+			"postgresql://de@dedb:5432/de?sslmode=disable",
+		},
+		{
+			"pgx without SSL mode",
+			driverPgx,
+			"postgres://de@dedb:5432/de",
+			"postgres://de@dedb:5432/de?sslmode=require",
+		},
+		{
+			"pgx with lib/pq parameters",
+			driverPgx,
+			"postgresql://de@dedb/de?sslmode=disable&binary_parameters=yes&fallback_application_name=indexer",
+			"postgresql://de@dedb/de?application_name=indexer&sslmode=disable",
+		},
+		{
+			"pgx key/value string",
+			driverPgx,
+			"host=dedb dbname=de",
+			"host=dedb dbname=de sslmode=require",
+		},
+	}
+
+	for _, test := range tests {
+		actual, err := connectionString(test.driver, test.uri)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, actual)
+		}
+	}
+}
+
+// TestGetDbDriver verifies that only supported database drivers are accepted.
+func TestGetDbDriver(t *testing.T) {
+	for _, name := range []string{driverPq, driverPgx, "mysql"} {
+		cfg := viper.New()
+		cfg.Set("db.driver", name)
+		driver, err := getDbDriver(cfg)
+		if _, supported := sqlDriverNames[name]; supported != (err == nil) {
+			t.Errorf("%s: unexpected result: %v", name, err)
+		}
+		if err == nil && driver != name {
+			t.Errorf("%s: unexpected driver: %s", name, driver)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// recentMessages is a fixed-size set containing the hashes of recently recorded messages. Once the set is full, the
+// oldest hash is discarded each time a new hash is added. It's safe for concurrent use by multiple goroutines.
+type recentMessages struct {
+	mutex  sync.Mutex
+	hashes map[string]bool
+	ring   []string
+	next   int
+}
+
+// newRecentMessages creates a new set that holds at most the given number of message hashes.
+func newRecentMessages(size int) *recentMessages {
+	if size < 1 {
+		size = 1
+	}
+	return &recentMessages{
+		hashes: make(map[string]bool, size),
+		ring:   make([]string, size),
+	}
+}
+
+// add adds a message hash to the set.
+func (r *recentMessages) add(hash string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.hashes[hash] {
+		return
+	}
+
+	// Evict the oldest hash if the set is full.
+	if oldest := r.ring[r.next]; oldest != "" {
+		delete(r.hashes, oldest)
+	}
+
+	r.ring[r.next] = hash
+	r.hashes[hash] = true
+	r.next = (r.next + 1) % len(r.ring)
+}
+
+// contains returns true if a message hash is in the set.
+func (r *recentMessages) contains(hash string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.hashes[hash]
+}
+
+// messageHash computes a hash that identifies a delivery from its original routing key, body, and timestamp.
+// Redelivered copies of a message have the same hash as the original, including copies that come back from the retry
+// queue or a dead-letter exchange with a different routing key.
+func messageHash(delivery amqp.Delivery) string {
+	h := sha256.New()
+	h.Write([]byte(originalRoutingKey(delivery)))
+	h.Write([]byte{0})
+	h.Write(delivery.Body)
+	h.Write([]byte{0})
+	h.Write([]byte(delivery.Timestamp.UTC().String()))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+// TestRecentMessages verifies that the recent message set holds a limited number of hashes.
+func TestRecentMessages(t *testing.T) {
+	recent := newRecentMessages(2)
+	recent.add("a")
+	recent.add("b")
+	if !recent.contains("a") || !recent.contains("b") {
+		t.Fatal("the set should contain both hashes")
+	}
+
+	// Adding a third hash should evict the oldest one.
+	recent.add("c")
+	if recent.contains("a") {
+		t.Error("the oldest hash should have been evicted")
+	}
+	if !recent.contains("b") || !recent.contains("c") {
+		t.Error("the newest hashes should have been retained")
+	}
+
+	// Adding a hash that's already present should not evict anything.
+	recent.add("c")
+	if !recent.contains("b") {
+		t.Error("adding a duplicate hash should not evict anything")
+	}
+}
+
+// TestMessageHash verifies that redelivered copies of a message have the same hash as the original, including copies
+// that come back from the retry queue or a dead-letter exchange with a different routing key.
+func TestMessageHash(t *testing.T) {
+	original := amqp.Delivery{RoutingKey: "data-object.open", Body: testBody}
+	redelivered := amqp.Delivery{RoutingKey: "data-object.open", Body: testBody, Redelivered: true}
+	retried := amqp.Delivery{
+		RoutingKey: "dataone.events",
+		Headers:    amqp.Table{retryAttemptsHeader: int32(1), retryRoutingKeyHeader: "data-object.open"},
+		Body:       testBody,
+	}
+	deadLettered := amqp.Delivery{
+		RoutingKey: "dataone.events.dead",
+		Headers: amqp.Table{"x-death": []interface{}{
+			amqp.Table{"count": int64(1), "queue": "dataone.events", "routing-keys": []interface{}{"data-object.open"}},
+		}},
+		Body: testBody,
+	}
+	other := amqp.Delivery{RoutingKey: "data-object.open", Body: outOfRootTestBody}
+
+	if messageHash(original) != messageHash(redelivered) {
+		t.Error("a redelivered message should have the same hash as the original")
+	}
+	if messageHash(original) != messageHash(retried) {
+		t.Error("a retried message should have the same hash as the original")
+	}
+	if messageHash(original) != messageHash(deadLettered) {
+		t.Error("a dead-lettered message should have the same hash as the original")
+	}
+	if messageHash(original) == messageHash(other) {
+		t.Error("different messages should have different hashes")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/logger"
+)
+
+// mergeDuplicateObjects merges the data objects that were registered more than once with the same UUID, or counts
+// them if this is a dry run. It returns the number of duplicate objects that were found or removed.
+func mergeDuplicateObjects(ctx context.Context, db *sql.DB, dryRun bool) (int64, error) {
+	if dryRun {
+		return database.CountDuplicateObjects(ctx, db)
+	}
+	return database.MergeDuplicateObjects(ctx, db)
+}
+
+// mergeDuplicates merges the data objects that were registered more than once with the same UUID, or reports the
+// number of duplicate objects if this is a dry run.
+func mergeDuplicates() {
+	cfg := initConfig()
+	db := initDatabase(cfg)
+	defer db.Close()
+
+	start := time.Now()
+	count, err := mergeDuplicateObjects(context.Background(), db, *mergeDryRun)
+	if err != nil {
+		logger.Log.Fatalf("unable to merge duplicate data objects: %s", err)
+	}
+	if *mergeDryRun {
+		logger.Log.Infof("dry run: found %d duplicate data objects", count)
+		return
+	}
+	logger.Log.Infof("merged %d duplicate data objects in %s", count, time.Since(start))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestMergeDuplicateObjects verifies that duplicate data objects are only counted during a dry run, and that they're
+// merged otherwise.
+func TestMergeDuplicateObjects(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	if count, err := mergeDuplicateObjects(context.Background(), db, true); err != nil || count != 2 {
+		t.Errorf("expected 2 duplicate data objects to be found but got %d (%v)", count, err)
+	}
+
+	mock.ExpectBegin()
+	for _, statement := range []string{"UPDATE", "INSERT", "UPDATE", "DELETE"} {
+		mock.ExpectExec(statement).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("DELETE FROM data_objects").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	if count, err := mergeDuplicateObjects(context.Background(), db, false); err != nil || count != 2 {
+		t.Errorf("expected 2 duplicate data objects to be removed but got %d (%v)", count, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
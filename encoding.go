@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// The content encodings that message bodies may have. Bodies without a content encoding aren't compressed.
+const (
+	contentEncodingIdentity = "identity"
+	contentEncodingGzip     = "gzip"
+)
+
+// Default maximum message body sizes. Message bodies are limited to defaultMaxMessageBytes both before and after
+// they're decompressed, and decompression stops once a body reaches defaultMaxDecompressedSize.
+const (
+	defaultMaxMessageBytes     = 1024 * 1024
+	defaultMaxDecompressedSize = 10 * 1024 * 1024
+)
+
+// getMaxMessageBytes returns the maximum size of a message body, which guards against publishers that send enormous
+// bodies on the routing keys that the service consumes.
+func getMaxMessageBytes(cfg *viper.Viper) (int64, error) {
+	maxSize := cfg.GetInt64("amqp.max-message-bytes")
+	if maxSize < 0 {
+		return 0, fmt.Errorf("amqp.max-message-bytes must not be negative: %d", maxSize)
+	}
+	if maxSize == 0 {
+		return defaultMaxMessageBytes, nil
+	}
+	return maxSize, nil
+}
+
+// getMaxDecompressedSize returns the maximum number of bytes that a compressed message body may decompress to, which
+// guards against bodies that are small when they're compressed but enormous when they aren't.
+func getMaxDecompressedSize(cfg *viper.Viper) (int64, error) {
+	maxSize := cfg.GetInt64("dataone.max-decompressed-size")
+	if maxSize < 0 {
+		return 0, fmt.Errorf("dataone.max-decompressed-size must not be negative: %d", maxSize)
+	}
+	if maxSize == 0 {
+		return defaultMaxDecompressedSize, nil
+	}
+	return maxSize, nil
+}
+
+// decodeBody returns the body of a delivery with its content encoding removed. Bodies without a content encoding are
+// returned unchanged. Gzip-compressed bodies are decompressed, and an error is returned if a body can't be
+// decompressed, if it decompresses to more than the maximum number of bytes or if it has any other content encoding.
+func decodeBody(delivery amqp.Delivery, maxSize int64) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(delivery.ContentEncoding)) {
+	case "", contentEncodingIdentity:
+		return delivery.Body, nil
+	case contentEncodingGzip:
+		return gunzip(delivery.Body, maxSize)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %s", delivery.ContentEncoding)
+	}
+}
+
+// gunzip decompresses a gzip-compressed message body, returning an error if it decompresses to more than the maximum
+// number of bytes. The default maximum is used if the maximum isn't positive.
+func gunzip(body []byte, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		maxSize = defaultMaxDecompressedSize
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress the message body: %s", err)
+	}
+	defer reader.Close()
+
+	// Read one byte more than the maximum so that bodies that are too large can be detected.
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress the message body: %s", err)
+	}
+	if int64(len(decompressed)) > maxSize {
+		return nil, fmt.Errorf("the message body decompresses to more than %d bytes", maxSize)
+	}
+	return decompressed, nil
+}
+
+// checkBodySize returns an invalid message error if a message body is larger than the maximum message size, so that
+// the message is rejected without being decoded. The default maximum is used if the maximum isn't positive.
+func (svc *DataoneIndexer) checkBodySize(key, description string, body []byte) error {
+	maxSize := svc.maxMessageBytes
+	if maxSize <= 0 {
+		maxSize = defaultMaxMessageBytes
+	}
+	if int64(len(body)) <= maxSize {
+		return nil
+	}
+	logger.Log.Warnf("rejecting %s of %d bytes with routing key '%s', which exceeds the maximum of %d bytes",
+		description, len(body), key, maxSize)
+	countOutcome(key, outcomeTooLarge)
+	return invalidMessageError("the %s is too large: %d bytes exceeds the maximum of %d bytes", description, len(body),
+		maxSize)
+}
+
+// storedBody returns the body of a delivery in the form in which it's stored in the database when it's quarantined or
+// stored for replay, which is the decoded body if the content encoding can be removed and the body as it was received
+// otherwise. Stored messages don't retain their content encodings, so they have to be decoded to be replayed.
+func (svc *DataoneIndexer) storedBody(delivery amqp.Delivery) []byte {
+	if body, err := decodeBody(delivery, svc.maxDecompressedSize); err == nil {
+		return body
+	}
+	return delivery.Body
+}
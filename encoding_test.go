@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// gzipBody compresses a message body for testing.
+func gzipBody(t *testing.T, body []byte) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(body); err != nil {
+		t.Fatalf("unable to compress the message body: %s", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("unable to compress the message body: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// TestGetMaxDecompressedSize verifies that the default maximum is used if no maximum is configured and that negative
+// maximums are rejected.
+func TestGetMaxDecompressedSize(t *testing.T) {
+	tests := []struct {
+		configured int64
+		expected   int64
+		expectErr  bool
+	}{
+		{0, defaultMaxDecompressedSize, false},
+		{1024, 1024, false},
+		{-1, 0, true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("dataone.max-decompressed-size", test.configured)
+		maxSize, err := getMaxDecompressedSize(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%d: expected error: %t, got: %v", test.configured, test.expectErr, err)
+		}
+		if maxSize != test.expected {
+			t.Errorf("%d: expected %d but got %d", test.configured, test.expected, maxSize)
+		}
+	}
+}
+
+// TestGetMaxMessageBytes verifies that the default maximum is used if no maximum is configured and that negative
+// maximums are rejected.
+func TestGetMaxMessageBytes(t *testing.T) {
+	tests := []struct {
+		configured int64
+		expected   int64
+		expectErr  bool
+	}{
+		{0, defaultMaxMessageBytes, false},
+		{512, 512, false},
+		{-1, 0, true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("amqp.max-message-bytes", test.configured)
+		maxSize, err := getMaxMessageBytes(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%d: expected error: %t, got: %v", test.configured, test.expectErr, err)
+		}
+		if maxSize != test.expected {
+			t.Errorf("%d: expected %d but got %d", test.configured, test.expected, maxSize)
+		}
+	}
+}
+
+// TestDecodeBody verifies that bodies without a content encoding are returned unchanged, that gzip-compressed bodies
+// are decompressed and that bodies that can't be decoded produce errors.
+func TestDecodeBody(t *testing.T) {
+	compressed := gzipBody(t, testBody)
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		maxSize  int64
+		expected []byte
+		problem  string
+	}{
+		{"none", "", testBody, 0, testBody, ""},
+		{"identity", "identity", testBody, 0, testBody, ""},
+		{"gzip", "gzip", compressed, 0, testBody, ""},
+		{"upper case", "GZIP", compressed, 0, testBody, ""},
+		{"exact limit", "gzip", compressed, int64(len(testBody)), testBody, ""},
+		{"over limit", "gzip", compressed, int64(len(testBody)) - 1, nil, "decompresses to more than"},
+		{"not gzip", "gzip", testBody, 0, nil, "unable to decompress"},
+		{"truncated", "gzip", compressed[:len(compressed)-8], 0, nil, "unable to decompress"},
+		{"unsupported", "br", compressed, 0, nil, "unsupported content encoding: br"},
+	}
+
+	for _, test := range tests {
+		body, err := decodeBody(amqp.Delivery{ContentEncoding: test.encoding, Body: test.body}, test.maxSize)
+		if test.problem != "" {
+			if err == nil || !strings.Contains(err.Error(), test.problem) {
+				t.Errorf("%s: expected an error mentioning %q but got %v", test.name, test.problem, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		} else if !bytes.Equal(body, test.expected) {
+			t.Errorf("%s: expected %s but got %s", test.name, test.expected, body)
+		}
+	}
+}
+
+// TestCompressedMessages verifies that gzip-compressed messages are handled exactly like the same messages without
+// compression.
+func TestCompressedMessages(t *testing.T) {
+	bodies := map[string][]byte{
+		"valid":       testBody,
+		"author":      testAuthorBody,
+		"out of root": outOfRootTestBody,
+		"malformed":   malformedTestBody,
+		"invalid":     invalidTestBody,
+	}
+
+	for name, body := range bodies {
+		plainRecorder := &fakeRecorder{}
+		plainErr := newTestService(plainRecorder).processMessage(amqp.Delivery{
+			RoutingKey: "data-object.open",
+			Body:       body,
+		})
+		compressedRecorder := &fakeRecorder{}
+		compressedErr := newTestService(compressedRecorder).processMessage(amqp.Delivery{
+			RoutingKey:      "data-object.open",
+			ContentEncoding: "gzip",
+			Body:            gzipBody(t, body),
+		})
+
+		if (plainErr == nil) != (compressedErr == nil) {
+			t.Errorf("%s: expected error %v but got %v", name, plainErr, compressedErr)
+		}
+		if shouldRequeue(plainErr) != shouldRequeue(compressedErr) {
+			t.Errorf("%s: the errors should have the same requeue classification", name)
+		}
+		if shouldQuarantine(plainErr) != shouldQuarantine(compressedErr) {
+			t.Errorf("%s: the errors should have the same quarantine classification", name)
+		}
+		plainEvents := atomic.LoadInt64(&plainRecorder.events)
+		if compressedEvents := atomic.LoadInt64(&compressedRecorder.events); plainEvents != compressedEvents {
+			t.Errorf("%s: expected %d recorded events but got %d", name, plainEvents, compressedEvents)
+		}
+	}
+}
+
+// TestUndecompressableMessages verifies that messages whose bodies can't be decompressed are rejected as invalid
+// messages so that they're quarantined.
+func TestUndecompressableMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		maxSize  int64
+	}{
+		{"corrupt", "gzip", []byte("not compressed"), 0},
+		{"too large", "gzip", gzipBody(t, testBody), 16},
+		{"unsupported", "deflate", testBody, 0},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{}
+		svc := newTestService(recorder)
+		svc.maxDecompressedSize = test.maxSize
+		key := "data-object.open"
+		before := messageOutcomes.Get(key + "/" + outcomeDecompressFailed)
+
+		err := svc.processMessage(amqp.Delivery{RoutingKey: key, ContentEncoding: test.encoding, Body: test.body})
+		if err == nil || shouldRequeue(err) || !shouldQuarantine(err) {
+			t.Errorf("%s: expected an invalid message error but got %v", test.name, err)
+		}
+		if count := messageOutcomes.Get(key+"/"+outcomeDecompressFailed) - before; count != 1 {
+			t.Errorf("%s: expected 1 decompression failure but got %d", test.name, count)
+		}
+		if events := atomic.LoadInt64(&recorder.events); events != 0 {
+			t.Errorf("%s: expected no recorded events but got %d", test.name, events)
+		}
+	}
+}
+
+// TestStoredBody verifies that messages are stored without compression if they can be decompressed and exactly as
+// they were received otherwise.
+func TestStoredBody(t *testing.T) {
+	svc := newTestService(&fakeRecorder{})
+	corrupt := []byte("not compressed")
+
+	tests := []struct {
+		name     string
+		delivery amqp.Delivery
+		expected []byte
+	}{
+		{"uncompressed", amqp.Delivery{Body: testBody}, testBody},
+		{"compressed", amqp.Delivery{ContentEncoding: "gzip", Body: gzipBody(t, testBody)}, testBody},
+		{"corrupt", amqp.Delivery{ContentEncoding: "gzip", Body: corrupt}, corrupt},
+	}
+
+	for _, test := range tests {
+		if body := svc.storedBody(test.delivery); !bytes.Equal(body, test.expected) {
+			t.Errorf("%s: expected %q but got %q", test.name, test.expected, body)
+		}
+	}
+}
+
+// TestOversizedMessages verifies that message bodies larger than the maximum message size are rejected as invalid
+// messages before they're decoded, whether they're too large as they're received or once they're decompressed.
+func TestOversizedMessages(t *testing.T) {
+	padded := []byte(`{"entity": "fakeid", "path": "/iplant/home/shared/commons_repo/curated/foo.txt", "padding": "` +
+		strings.Repeat("x", 1024) + `"}`)
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		tooLarge bool
+	}{
+		{"small", "", testBody, false},
+		{"small compressed", "gzip", gzipBody(t, testBody), false},
+		{"large", "", padded, true},
+		{"large once decompressed", "gzip", gzipBody(t, padded), true},
+		{"large and corrupt", "gzip", append(append([]byte{}, padded...), 0), true},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{}
+		svc := newTestService(recorder)
+		svc.maxMessageBytes = 512
+		key := "data-object.open"
+		tooLarge := messageOutcomes.Get(key + "/" + outcomeTooLarge)
+		decompressFailed := messageOutcomes.Get(key + "/" + outcomeDecompressFailed)
+
+		err := svc.processMessage(amqp.Delivery{RoutingKey: key, ContentEncoding: test.encoding, Body: test.body})
+		if !test.tooLarge {
+			if err != nil || atomic.LoadInt64(&recorder.events) != 1 {
+				t.Errorf("%s: expected the event to be recorded (error: %v)", test.name, err)
+			}
+			continue
+		}
+		if err == nil || shouldRequeue(err) || !shouldQuarantine(err) {
+			t.Errorf("%s: expected an invalid message error but got %v", test.name, err)
+		} else if !strings.Contains(err.Error(), "too large") {
+			t.Errorf("%s: the error does not mention the size: %s", test.name, err)
+		}
+		if count := messageOutcomes.Get(key+"/"+outcomeTooLarge) - tooLarge; count != 1 {
+			t.Errorf("%s: expected 1 oversized message but got %d", test.name, count)
+		}
+		if count := messageOutcomes.Get(key+"/"+outcomeDecompressFailed) - decompressFailed; count != 0 {
+			t.Errorf("%s: expected no decompression failures but got %d", test.name, count)
+		}
+		if events := atomic.LoadInt64(&recorder.events); events != 0 {
+			t.Errorf("%s: expected no recorded events but got %d", test.name, events)
+		}
+	}
+}
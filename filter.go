@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// headerRule describes the values that are rejected or required for a single AMQP message header.
+type headerRule struct {
+	reject  map[string]bool
+	require map[string]bool
+}
+
+// headerFilter maps lower-case AMQP header names to the rules applied to them. Header names are compared without
+// regard to case because viper converts all configuration keys to lower case.
+type headerFilter map[string]*headerRule
+
+// toStringSet converts a list of values from the configuration to a set of strings. A missing list produces an empty
+// set.
+func toStringSet(value interface{}) (map[string]bool, error) {
+	if value == nil {
+		return nil, nil
+	}
+	values, err := cast.ToStringSliceE(value)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(values))
+	for _, v := range values {
+		result[v] = true
+	}
+	return result, nil
+}
+
+// getHeaderFilter extracts the header filter from the configuration. Each header name maps to a block that can
+// contain a list of rejected values and a list of required values:
+//
+//	amqp:
+//	  filter:
+//	    headers:
+//	      origin:
+//	        reject: [qa-bot]
+//
+// A message is filtered if it has a rejected value for any header or if it lacks a required value for any header.
+func getHeaderFilter(cfg *viper.Viper) (headerFilter, error) {
+	filter := make(headerFilter)
+	for name, value := range cfg.GetStringMap("amqp.filter.headers") {
+		settings, err := cast.ToStringMapE(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter settings for header %s: %s", name, err)
+		}
+
+		rule := &headerRule{}
+		if rule.reject, err = toStringSet(settings["reject"]); err != nil {
+			return nil, fmt.Errorf("invalid rejected values for header %s: %s", name, err)
+		}
+		if rule.require, err = toStringSet(settings["require"]); err != nil {
+			return nil, fmt.Errorf("invalid required values for header %s: %s", name, err)
+		}
+		filter[strings.ToLower(name)] = rule
+	}
+	return filter, nil
+}
+
+// check determines whether or not a message should be filtered based on its headers. If the message should be
+// filtered, the name of the header that caused it to be filtered is returned along with true.
+func (f headerFilter) check(headers amqp.Table) (string, bool) {
+	if len(f) == 0 {
+		return "", false
+	}
+
+	// Convert the header values to strings, keyed by lower-case header name.
+	values := make(map[string]string, len(headers))
+	for name, value := range headers {
+		values[strings.ToLower(name)] = fmt.Sprint(value)
+	}
+
+	for name, rule := range f {
+		value, present := values[name]
+		if present && rule.reject[value] {
+			return name, true
+		}
+		if len(rule.require) > 0 && (!present || !rule.require[value]) {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// getTestHeaderFilter returns a header filter that can be used for testing.
+func getTestHeaderFilter(t *testing.T) headerFilter {
+	cfg := viper.New()
+	cfg.Set("amqp.filter.headers", map[string]interface{}{
+		"origin": map[string]interface{}{
+			"reject": []string{"qa-bot", "load-test"},
+		},
+		"Environment": map[string]interface{}{
+			"require": []string{"prod"},
+		},
+	})
+
+	filter, err := getHeaderFilter(cfg)
+	if err != nil {
+		t.Fatalf("unable to load the header filter: %s", err)
+	}
+	return filter
+}
+
+// TestHeaderFilter verifies that messages are filtered based on their headers.
+func TestHeaderFilter(t *testing.T) {
+	filter := getTestHeaderFilter(t)
+
+	tests := []struct {
+		name     string
+		headers  amqp.Table
+		filtered bool
+		header   string
+	}{
+		{"accepted", amqp.Table{"origin": "de", "environment": "prod"}, false, ""},
+		{"rejected origin", amqp.Table{"origin": "qa-bot", "environment": "prod"}, true, "origin"},
+		{"header name case", amqp.Table{"Origin": "load-test", "Environment": "prod"}, true, "origin"},
+		{"missing required header", amqp.Table{"origin": "de"}, true, "environment"},
+		{"wrong required value", amqp.Table{"environment": "qa"}, true, "environment"},
+		{"no headers", nil, true, "environment"},
+	}
+
+	for _, test := range tests {
+		header, filtered := filter.check(test.headers)
+		if filtered != test.filtered {
+			t.Errorf("%s: expected filtered to be %t", test.name, test.filtered)
+		}
+		if header != test.header {
+			t.Errorf("%s: expected header `%s` but got `%s`", test.name, test.header, header)
+		}
+	}
+}
+
+// TestEmptyHeaderFilter verifies that no messages are filtered when no header rules are configured.
+func TestEmptyHeaderFilter(t *testing.T) {
+	filter, err := getHeaderFilter(viper.New())
+	if err != nil {
+		t.Fatalf("unable to load the header filter: %s", err)
+	}
+	if _, filtered := filter.check(amqp.Table{"origin": "qa-bot"}); filtered {
+		t.Error("no messages should be filtered when no rules are configured")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/spf13/viper"
+)
+
+// The ways in which message consumption can be paused while the database is unhealthy.
+const (
+	// healthPauseStop stops receiving deliveries until the database is healthy again. Deliveries that the broker has
+	// already sent remain unacknowledged, so the broker sends no more than the prefetch count.
+	healthPauseStop = "stop"
+
+	// healthPauseNack continues receiving deliveries but returns each one to the queue without processing it.
+	healthPauseNack = "nack"
+)
+
+// dbHealthy is published via expvar so that the database health can be monitored. It's 1 when the database is healthy
+// and 0 otherwise.
+var dbHealthy = expvar.NewInt("database_healthy")
+
+// healthSettings describes how the health of the database is monitored.
+type healthSettings struct {
+	interval time.Duration
+	timeout  time.Duration
+	pause    string
+}
+
+// getHealthSettings extracts the database health check settings from the configuration. The return value is nil if
+// health checks are disabled.
+func getHealthSettings(cfg *viper.Viper, manualAck bool) (*healthSettings, error) {
+	if !cfg.GetBool("db.health.enabled") {
+		return nil, nil
+	}
+
+	hs := &healthSettings{
+		interval: cfg.GetDuration("db.health.interval"),
+		timeout:  cfg.GetDuration("db.health.timeout"),
+		pause:    cfg.GetString("db.health.pause"),
+	}
+
+	// Validate the settings.
+	if hs.interval <= 0 {
+		return nil, fmt.Errorf("db.health.interval must be positive: %s", hs.interval)
+	}
+	if hs.timeout <= 0 {
+		return nil, fmt.Errorf("db.health.timeout must be positive: %s", hs.timeout)
+	}
+	switch hs.pause {
+	case healthPauseStop:
+	case healthPauseNack:
+		if !manualAck {
+			return nil, fmt.Errorf("db.health.pause can only be %s when amqp.manual-ack is true", healthPauseNack)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported db.health.pause value: %s", hs.pause)
+	}
+
+	return hs, nil
+}
+
+// healthChecker periodically pings the database and keeps track of whether or not it's reachable. Message consumption
+// is paused while the database is unhealthy, because every event would fail to be recorded. It's safe for concurrent
+// use by multiple goroutines.
+type healthChecker struct {
+	ping     func(context.Context) error
+	interval time.Duration
+	timeout  time.Duration
+	pause    string
+	mutex    sync.Mutex
+	healthy  bool
+	changed  chan struct{}
+}
+
+// newHealthChecker creates a health checker for a database. The database is assumed to be healthy initially, since
+// the service doesn't start until it can connect to the database.
+func newHealthChecker(db *sql.DB, hs *healthSettings) *healthChecker {
+	dbHealthy.Set(1)
+	return &healthChecker{
+		ping:     db.PingContext,
+		interval: hs.interval,
+		timeout:  hs.timeout,
+		pause:    hs.pause,
+		healthy:  true,
+		changed:  make(chan struct{}),
+	}
+}
+
+// state returns whether or not the database is healthy along with a channel that's closed when the state changes.
+func (h *healthChecker) state() (bool, <-chan struct{}) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.healthy, h.changed
+}
+
+// check pings the database once and records the result. Transitions between the healthy and unhealthy states are
+// logged.
+func (h *healthChecker) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	err := h.ping(ctx)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	healthy := err == nil
+	if healthy == h.healthy {
+		return
+	}
+	if healthy {
+		logger.Log.Info("the database is reachable again; resuming message consumption")
+		dbHealthy.Set(1)
+	} else {
+		logger.Log.Errorf("database health check failed; pausing message consumption (%s): %s", h.pause, err)
+		dbHealthy.Set(0)
+	}
+
+	// Notify anything waiting for the state to change.
+	h.healthy = healthy
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// run checks the health of the database at the configured interval until the stop channel is closed.
+func (h *healthChecker) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			h.check()
+		}
+	}
+}
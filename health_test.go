@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// TestGetHealthSettings verifies that the database health check settings are loaded and validated correctly.
+func TestGetHealthSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		interval  string
+		timeout   string
+		pause     string
+		manualAck bool
+		expectNil bool
+		expectErr bool
+	}{
+		{"disabled", false, "10s", "5s", "stop", false, true, false},
+		{"stop", true, "10s", "5s", "stop", false, false, false},
+		{"nack", true, "10s", "5s", "nack", true, false, false},
+		{"nack without manual acknowledgements", true, "10s", "5s", "nack", false, true, true},
+		{"unsupported pause", true, "10s", "5s", "panic", false, true, true},
+		{"zero interval", true, "0s", "5s", "stop", false, true, true},
+		{"zero timeout", true, "10s", "0s", "stop", false, true, true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("db.health.enabled", test.enabled)
+		cfg.Set("db.health.interval", test.interval)
+		cfg.Set("db.health.timeout", test.timeout)
+		cfg.Set("db.health.pause", test.pause)
+
+		hs, err := getHealthSettings(cfg, test.manualAck)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+		}
+		if (hs == nil) != test.expectNil {
+			t.Errorf("%s: expected nil settings: %t, got: %+v", test.name, test.expectNil, hs)
+		}
+	}
+}
+
+// newTestHealthChecker returns a health checker whose pings fail while the given flag is set.
+func newTestHealthChecker(pause string, failing *int32) *healthChecker {
+	return &healthChecker{
+		ping: func(context.Context) error {
+			if atomic.LoadInt32(failing) != 0 {
+				return fmt.Errorf("connection refused")
+			}
+			return nil
+		},
+		interval: time.Millisecond,
+		timeout:  time.Second,
+		pause:    pause,
+		healthy:  true,
+		changed:  make(chan struct{}),
+	}
+}
+
+// TestHealthTransitions verifies that the health checker reports transitions between the healthy and unhealthy
+// states.
+func TestHealthTransitions(t *testing.T) {
+	var failing int32 = 1
+	h := newTestHealthChecker(healthPauseStop, &failing)
+
+	_, changed := h.state()
+	h.check()
+	healthy, _ := h.state()
+	if healthy {
+		t.Error("the database should be unhealthy")
+	}
+	select {
+	case <-changed:
+	default:
+		t.Error("the transition to unhealthy was not reported")
+	}
+
+	// The state doesn't change if the ping fails again.
+	_, changed = h.state()
+	h.check()
+	select {
+	case <-changed:
+		t.Error("a transition was reported without a change in health")
+	default:
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	h.check()
+	if healthy, _ := h.state(); !healthy {
+		t.Error("the database should be healthy")
+	}
+}
+
+// TestPausedConsumption verifies that no deliveries are received while the database is unhealthy, and that
+// consumption resumes once the database is healthy again.
+func TestPausedConsumption(t *testing.T) {
+	var failing int32 = 1
+	recorder := &fakeRecorder{}
+	session, messages := newFakeSession()
+	svc := newTestService(recorder)
+	svc.newSession = func() (*amqpSession, error) {
+		return session, nil
+	}
+	svc.health = newTestHealthChecker(healthPauseStop, &failing)
+	svc.health.check()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.processMessages()
+	}()
+
+	// The delivery shouldn't be received while the database is unhealthy.
+	delivery := amqp.Delivery{RoutingKey: "data-object.open", Body: testBody}
+	select {
+	case messages <- delivery:
+		t.Fatal("a delivery was received while the database was unhealthy")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The delivery should be received once the database recovers.
+	atomic.StoreInt32(&failing, 0)
+	svc.health.check()
+	select {
+	case messages <- delivery:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumption did not resume after the database recovered")
+	}
+
+	close(messages)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message processing did not stop")
+	}
+	if atomic.LoadInt64(&recorder.events) != 1 {
+		t.Errorf("expected 1 recorded event but got %d", recorder.events)
+	}
+}
+
+// TestRejectedConsumption verifies that deliveries are returned to the queue without being processed while the
+// database is unhealthy if consumption is paused by negatively acknowledging deliveries.
+func TestRejectedConsumption(t *testing.T) {
+	var failing int32 = 1
+	recorder := &fakeRecorder{}
+	acknowledger := &fakeAcknowledger{}
+	session, messages := newFakeSession()
+	svc := newTestService(recorder)
+	svc.manualAck = true
+	svc.newSession = func() (*amqpSession, error) {
+		return session, nil
+	}
+	svc.health = newTestHealthChecker(healthPauseNack, &failing)
+	svc.health.check()
+
+	go func() {
+		messages <- amqp.Delivery{Acknowledger: acknowledger, RoutingKey: "data-object.open", Body: testBody}
+		close(messages)
+	}()
+
+	if err := runProcessMessages(t, svc); err == nil {
+		t.Error("an error was expected but none was encountered")
+	}
+	if atomic.LoadInt64(&recorder.events) != 0 {
+		t.Errorf("expected no recorded events but got %d", recorder.events)
+	}
+	if acknowledger.requeues != 1 {
+		t.Errorf("expected 1 requeued delivery but got %d", acknowledger.requeues)
+	}
+}
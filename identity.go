@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/streadway/amqp"
+)
+
+// version is the version of the service. It's set at build time using the linker's -X option.
+var version = "unknown"
+
+// clientIdentity describes this instance of the service so that its connections and consumers can be identified in
+// the RabbitMQ management interface.
+type clientIdentity struct {
+	hostname string
+	version  string
+}
+
+// getClientIdentity determines the identity of this instance of the service.
+func getClientIdentity() *clientIdentity {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+	return &clientIdentity{hostname: hostname, version: version}
+}
+
+// connectionName returns the name that the service advertises to the AMQP broker for each connection.
+func (id *clientIdentity) connectionName() string {
+	return fmt.Sprintf("dataone-indexer/%s/%s", id.hostname, id.version)
+}
+
+// consumerTag returns the tag used to identify the service's consumer.
+func (id *clientIdentity) consumerTag() string {
+	return fmt.Sprintf("dataone-indexer@%s", id.hostname)
+}
+
+// clientProperties returns the properties that the service advertises to the AMQP broker for each connection.
+func (id *clientIdentity) clientProperties() amqp.Table {
+	return amqp.Table{
+		"product":         "dataone-indexer",
+		"version":         id.version,
+		"connection_name": id.connectionName(),
+	}
+}
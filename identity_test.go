@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+// TestClientIdentity verifies that the connection name and consumer tag identify the service instance.
+func TestClientIdentity(t *testing.T) {
+	if name := testIdentity.connectionName(); name != "dataone-indexer/testhost/1.2.3" {
+		t.Errorf("unexpected connection name: %s", name)
+	}
+	if tag := testIdentity.consumerTag(); tag != "dataone-indexer@testhost" {
+		t.Errorf("unexpected consumer tag: %s", tag)
+	}
+
+	properties := testIdentity.clientProperties()
+	if properties["connection_name"] != testIdentity.connectionName() {
+		t.Errorf("unexpected client properties: %v", properties)
+	}
+	if err := properties.Validate(); err != nil {
+		t.Errorf("invalid client properties: %s", err)
+	}
+}
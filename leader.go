@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/spf13/viper"
+)
+
+// Default leader election settings.
+const (
+	defaultLeaderRetryInterval = 10 * time.Second
+	defaultLeaderCheckInterval = 5 * time.Second
+)
+
+// The leadership states published via expvar.
+const (
+	leadershipDisabled = "disabled"
+	leadershipStandby  = "standby"
+	leadershipLeader   = "leader"
+)
+
+// leadership is published via expvar so that monitoring can tell the active replica apart from the standby replica.
+var leadership = expvar.NewString("leadership")
+
+// errLeadershipLost indicates that message processing stopped because this instance lost the leadership lock.
+var errLeadershipLost = fmt.Errorf("the leadership lock was lost")
+
+// leaderSettings describes how replicas of the service decide which one of them consumes messages.
+type leaderSettings struct {
+	lockKey       int64
+	retryInterval time.Duration
+	checkInterval time.Duration
+}
+
+// getLeaderSettings extracts the leader election settings from the configuration. The return value is nil if leader
+// election is disabled.
+func getLeaderSettings(cfg *viper.Viper) (*leaderSettings, error) {
+	if !cfg.GetBool("db.leader-election.enabled") {
+		return nil, nil
+	}
+
+	retryInterval, err := getPositiveDuration(cfg, "db.leader-election.retry-interval", defaultLeaderRetryInterval)
+	if err != nil {
+		return nil, err
+	}
+	checkInterval, err := getPositiveDuration(cfg, "db.leader-election.check-interval", defaultLeaderCheckInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	return &leaderSettings{
+		lockKey:       cfg.GetInt64("db.leader-election.lock-key"),
+		retryInterval: retryInterval,
+		checkInterval: checkInterval,
+	}, nil
+}
+
+// leaderElection determines whether or not this instance of the service is the leader, which is the only instance
+// that consumes messages. The leader holds a session-level Postgres advisory lock on a dedicated connection, so the
+// lock is released automatically if the leader's database session ends. It's safe for concurrent use by multiple
+// goroutines.
+type leaderElection struct {
+	db            *sql.DB
+	lockKey       int64
+	retryInterval time.Duration
+	checkInterval time.Duration
+	mutex         sync.Mutex
+	conn          *sql.Conn
+	lost          chan struct{}
+}
+
+// newLeaderElection creates a leader election for a database.
+func newLeaderElection(db *sql.DB, ls *leaderSettings) *leaderElection {
+	return &leaderElection{
+		db:            db,
+		lockKey:       ls.lockKey,
+		retryInterval: ls.retryInterval,
+		checkInterval: ls.checkInterval,
+	}
+}
+
+// lostChannel returns a channel that's closed when this instance loses the leadership lock.
+func (l *leaderElection) lostChannel() <-chan struct{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.lost
+}
+
+// leading returns true if this instance currently holds the leadership lock.
+func (l *leaderElection) leading() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.conn != nil
+}
+
+// tryAcquire makes a single attempt to acquire the leadership lock. The return value is true if the lock was acquired.
+func (l *leaderElection) tryAcquire() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.checkInterval)
+	defer cancel()
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var acquired bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.lockKey).Scan(&acquired)
+	if err != nil || !acquired {
+		conn.Close()
+		return false, err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.conn = conn
+	l.lost = make(chan struct{})
+	return true, nil
+}
+
+// acquire waits until this instance acquires the leadership lock, attempting to acquire it at the retry interval. Once
+// the lock is acquired, the connection holding it is checked at the check interval until the stop channel is closed.
+// The return value is false if the stop channel was closed before the lock was acquired.
+func (l *leaderElection) acquire(stop <-chan struct{}) bool {
+	leadership.Set(leadershipStandby)
+	ticker := time.NewTicker(l.retryInterval)
+	defer ticker.Stop()
+
+	for logged := false; ; {
+		acquired, err := l.tryAcquire()
+		if err != nil {
+			logger.Log.Warnf("unable to attempt to acquire leadership lock %d: %s", l.lockKey, err)
+		}
+		if acquired {
+			logger.Log.Infof("acquired leadership lock %d; consuming messages", l.lockKey)
+			leadership.Set(leadershipLeader)
+			go l.monitor(stop)
+			return true
+		}
+		if !logged && err == nil {
+			logger.Log.Infof(
+				"leadership lock %d is held by another instance; standing by and retrying every %s",
+				l.lockKey, l.retryInterval,
+			)
+			logged = true
+		}
+
+		select {
+		case <-stop:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// monitor checks the connection holding the leadership lock at the check interval. If the connection is lost, the
+// lock has been released, so the lost channel is closed.
+func (l *leaderElection) monitor(stop <-chan struct{}) {
+	ticker := time.NewTicker(l.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := l.check(); err != nil {
+				logger.Log.Errorf("lost leadership lock %d; stopping message consumption: %s", l.lockKey, err)
+				l.abandon()
+				return
+			}
+		}
+	}
+}
+
+// check verifies that the connection holding the leadership lock is still usable.
+func (l *leaderElection) check() error {
+	l.mutex.Lock()
+	conn := l.conn
+	l.mutex.Unlock()
+	if conn == nil {
+		return fmt.Errorf("no connection holds the lock")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.checkInterval)
+	defer cancel()
+	var one int
+	return conn.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
+// abandon closes the connection that held the leadership lock after the lock was lost and reports the loss.
+func (l *leaderElection) abandon() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.conn == nil {
+		return
+	}
+	l.conn.Close()
+	l.conn = nil
+	leadership.Set(leadershipStandby)
+	close(l.lost)
+}
+
+// release releases the leadership lock if this instance holds it.
+func (l *leaderElection) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.conn == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.checkInterval)
+	defer cancel()
+	if _, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.lockKey); err != nil {
+		logger.Log.Warnf("unable to release leadership lock %d: %s", l.lockKey, err)
+	}
+	l.conn.Close()
+	l.conn = nil
+	leadership.Set(leadershipStandby)
+}
+
+// processMessagesAsLeader processes incoming AMQP messages while this instance holds the leadership lock, standing by
+// whenever another instance holds it. If leader election is disabled, messages are always processed.
+func (svc *DataoneIndexer) processMessagesAsLeader() error {
+	if svc.leader == nil {
+		return svc.processMessages()
+	}
+	defer svc.leader.release()
+
+	for {
+		if !svc.leader.acquire(svc.stop) {
+			return nil
+		}
+		if err := svc.processMessages(); err != errLeadershipLost {
+			return err
+		}
+	}
+}
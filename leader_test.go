@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestGetLeaderSettings verifies that leader election settings are loaded and validated correctly.
+func TestGetLeaderSettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		enabled       bool
+		retryInterval string
+		expectErr     bool
+		expectNil     bool
+	}{
+		{"disabled", false, "10s", false, true},
+		{"enabled", true, "10s", false, false},
+		{"default interval", true, "", false, false},
+		{"negative interval", true, "-1s", true, false},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("db.leader-election.enabled", test.enabled)
+		cfg.Set("db.leader-election.lock-key", 42)
+		cfg.Set("db.leader-election.retry-interval", test.retryInterval)
+		ls, err := getLeaderSettings(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+			continue
+		}
+		if test.expectErr {
+			continue
+		}
+		if (ls == nil) != test.expectNil {
+			t.Errorf("%s: expected nil settings: %t, got: %+v", test.name, test.expectNil, ls)
+			continue
+		}
+		if ls != nil && (ls.lockKey != 42 || ls.checkInterval != defaultLeaderCheckInterval) {
+			t.Errorf("%s: unexpected settings: %+v", test.name, ls)
+		}
+	}
+}
+
+// TestLeaderElection verifies that an instance stands by while another instance holds the leadership lock, takes
+// over once the lock is available, and reports the loss of the lock if its connection fails.
+func TestLeaderElection(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+
+	// The lock is held by another instance for the first attempt.
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(false))
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(true))
+	mock.ExpectQuery("SELECT 1").WillReturnError(fmt.Errorf("connection reset by peer"))
+
+	l := newLeaderElection(db, &leaderSettings{
+		lockKey:       42,
+		retryInterval: time.Millisecond,
+		checkInterval: 10 * time.Millisecond,
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	if !l.acquire(stop) {
+		t.Fatal("the leadership lock wasn't acquired")
+	}
+	if state := leadership.Value(); state != leadershipLeader {
+		t.Errorf("expected the leadership state to be %s, got %s", leadershipLeader, state)
+	}
+
+	// The failed check causes the lock to be abandoned.
+	select {
+	case <-l.lostChannel():
+	case <-time.After(time.Second):
+		t.Fatal("the loss of the leadership lock wasn't reported")
+	}
+	if state := leadership.Value(); state != leadershipStandby {
+		t.Errorf("expected the leadership state to be %s, got %s", leadershipStandby, state)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestLeaderElectionStop verifies that an instance standing by stops waiting for the leadership lock when the service
+// shuts down.
+func TestLeaderElectionStop(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(false))
+
+	l := newLeaderElection(db, &leaderSettings{lockKey: 42, retryInterval: time.Minute, checkInterval: time.Second})
+	stop := make(chan struct{})
+	close(stop)
+	if l.acquire(stop) {
+		t.Error("the leadership lock shouldn't have been acquired")
+	}
+}
+
+// TestLeadershipLost verifies that message processing stops, without reconnecting, when the leadership lock is lost.
+func TestLeadershipLost(t *testing.T) {
+	session, messages := newFakeSession()
+	svc := newTestService(&fakeRecorder{})
+	svc.reconnect = true
+	svc.stop = make(chan struct{})
+	svc.leader = &leaderElection{lost: make(chan struct{})}
+	svc.newSession = func() (*amqpSession, error) {
+		return session, nil
+	}
+
+	close(svc.leader.lost)
+	close(messages)
+	if err := runProcessMessages(t, svc); err != errLeadershipLost {
+		t.Errorf("expected %s, got: %v", errLeadershipLost, err)
+	}
+}
@@ -0,0 +1,1462 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/configurate"
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// newFakeSession returns an AMQP session that isn't associated with a broker connection, along with the channel
+// used to send deliveries to it.
+func newFakeSession() (*amqpSession, chan amqp.Delivery) {
+	messages := make(chan amqp.Delivery)
+	return &amqpSession{
+		queue:      "dataone.events",
+		messages:   messages,
+		connClosed: make(chan *amqp.Error, 1),
+		chClosed:   make(chan *amqp.Error, 1),
+		cancelled:  make(chan string, 1),
+	}, messages
+}
+
+// runProcessMessages runs processMessages in the background and returns the error it produces, failing the test if
+// it doesn't return within a reasonable amount of time.
+func runProcessMessages(t *testing.T, svc *DataoneIndexer) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.processMessages()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("message processing did not stop")
+	}
+	return nil
+}
+
+// TestShutdownOnClosedDeliveryChannel verifies that message processing stops with an error when the delivery channel
+// closes and reconnection is disabled.
+func TestShutdownOnClosedDeliveryChannel(t *testing.T) {
+	session, messages := newFakeSession()
+	svc := &DataoneIndexer{
+		newSession: func() (*amqpSession, error) {
+			return session, nil
+		},
+	}
+
+	close(messages)
+	if err := runProcessMessages(t, svc); err == nil {
+		t.Error("an error was expected but none was encountered")
+	}
+}
+
+// TestShutdownOnConnectionLoss verifies that message processing stops with an error when the connection is lost and
+// reconnection is disabled.
+func TestShutdownOnConnectionLoss(t *testing.T) {
+	session, _ := newFakeSession()
+	svc := &DataoneIndexer{
+		newSession: func() (*amqpSession, error) {
+			return session, nil
+		},
+	}
+
+	session.connClosed <- &amqp.Error{Code: amqp.ConnectionForced, Reason: "broker shutting down"}
+	if err := runProcessMessages(t, svc); err == nil {
+		t.Error("an error was expected but none was encountered")
+	}
+}
+
+// TestConsumerCancellation verifies that a consumer cancellation is reported with the consumer tag and queue name.
+func TestConsumerCancellation(t *testing.T) {
+	session, messages := newFakeSession()
+	svc := &DataoneIndexer{
+		newSession: func() (*amqpSession, error) {
+			return session, nil
+		},
+	}
+
+	// The AMQP library reports the cancellation and then closes the delivery channel.
+	session.cancelled <- "ctag-dataone-indexer-1"
+	close(messages)
+
+	err := runProcessMessages(t, svc)
+	if err == nil {
+		t.Fatal("an error was expected but none was encountered")
+	}
+	if !strings.Contains(err.Error(), "ctag-dataone-indexer-1") || !strings.Contains(err.Error(), "dataone.events") {
+		t.Errorf("the error message does not name the consumer tag and queue: %s", err)
+	}
+}
+
+// TestReconnect verifies that a new session is established when the delivery channel closes and reconnection is
+// enabled, and that message processing stops if the connection can't be restored.
+func TestReconnect(t *testing.T) {
+	attempts := 0
+	svc := &DataoneIndexer{
+		reconnect: true,
+		newSession: func() (*amqpSession, error) {
+			attempts++
+			if attempts > 2 {
+				return nil, fmt.Errorf("broker unavailable")
+			}
+			session, messages := newFakeSession()
+			close(messages)
+			return session, nil
+		},
+	}
+
+	if err := runProcessMessages(t, svc); err == nil {
+		t.Error("an error was expected but none was encountered")
+	}
+	if svc.reconnects != 1 {
+		t.Errorf("expected 1 reconnect but got %d", svc.reconnects)
+	}
+}
+
+// fakeRecorder is an event recorder that records the number of events it receives and returns a configured error.
+type fakeRecorder struct {
+	err           error
+	batchErr      error
+	quarantineErr error
+	failureErr    error
+	block         bool
+	duplicate     bool
+	skipped       bool
+	archived      bool
+	panics        int64
+	events        int64
+	batches       int64
+	quarantined   int64
+	failures      int64
+}
+
+// RecordEvent counts the event and returns the configured error. If the recorder is configured to block, it waits for
+// the context to expire instead. If the recorder is configured to panic, it panics for that many events first.
+func (r *fakeRecorder) RecordEvent(ctx context.Context, key string, msg *model.Message) (*database.Event, error) {
+	id := atomic.AddInt64(&r.events, 1)
+	if atomic.AddInt64(&r.panics, -1) >= 0 {
+		panic("unexpected event")
+	}
+	if r.block {
+		<-ctx.Done()
+		return nil, &database.RetryableError{Err: ctx.Err()}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.duplicate || r.skipped {
+		return &database.Event{
+			Type:            database.ETRead,
+			Path:            msg.Path,
+			NodeID:          r.GetNodeID(),
+			Duplicate:       true,
+			AlreadyRecorded: r.skipped,
+		}, nil
+	}
+	if r.archived {
+		return &database.Event{Type: database.ETRead, Path: msg.Path, NodeID: r.GetNodeID(), Archived: true}, nil
+	}
+	return &database.Event{ID: id, Type: database.ETRead, Path: msg.Path, NodeID: r.GetNodeID()}, nil
+}
+
+// RecordEvents counts the batch and returns the configured batch error. Otherwise, each event in the batch is
+// recorded individually.
+func (r *fakeRecorder) RecordEvents(ctx context.Context, requests []*database.EventRequest) ([]*database.Event, error) {
+	atomic.AddInt64(&r.batches, 1)
+	if r.batchErr != nil {
+		return nil, r.batchErr
+	}
+	events := make([]*database.Event, len(requests))
+	for i, request := range requests {
+		event, err := r.RecordEvent(ctx, request.Key, request.Msg)
+		if err != nil {
+			return nil, err
+		}
+		events[i] = event
+	}
+	return events, nil
+}
+
+// RecordQuarantine counts the quarantined message and returns the configured error.
+func (r *fakeRecorder) RecordQuarantine(ctx context.Context, key string, body []byte, reason string) error {
+	atomic.AddInt64(&r.quarantined, 1)
+	return r.quarantineErr
+}
+
+// RecordFailure counts the stored message and returns the configured error.
+func (r *fakeRecorder) RecordFailure(ctx context.Context, key string, body []byte, reason string, attempts int) error {
+	atomic.AddInt64(&r.failures, 1)
+	return r.failureErr
+}
+
+// unusedHandler is a placeholder for handlers that the fake recorder never calls.
+func unusedHandler(context.Context, *sql.Tx, database.Recorder, string, *model.Message) (*database.Event, error) {
+	return nil, nil
+}
+
+// GetHandlerMap returns a handler map that only contains rules for the routing keys used in these tests.
+func (r *fakeRecorder) GetHandlerMap() *database.HandlerMap {
+	return &database.HandlerMap{
+		"data-object.open": unusedHandler, "data-object.mv": unusedHandler, "data-object.acl.mod": unusedHandler,
+		"dataone.synchronization": unusedHandler,
+	}
+}
+
+// GetNodeID returns a fake node ID.
+func (r *fakeRecorder) GetNodeID() string {
+	return "fakenode"
+}
+
+// GetDb always returns nil. No database connection is needed for these tests.
+func (r *fakeRecorder) GetDb() *sql.DB {
+	return nil
+}
+
+// fakeAcknowledger records acknowledgements of deliveries.
+type fakeAcknowledger struct {
+	acks      int
+	multiples int
+	nacks     int
+	requeues  int
+}
+
+// Ack records an acknowledgement.
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acks++
+	if multiple {
+		a.multiples++
+	}
+	return nil
+}
+
+// Nack records a negative acknowledgement.
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacks++
+	if requeue {
+		a.requeues++
+	}
+	return nil
+}
+
+// Reject records a rejection, which is equivalent to a negative acknowledgement of a single delivery.
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// newTestService returns a DataONE indexer service that uses the given recorder.
+func newTestService(recorder database.Recorder) *DataoneIndexer {
+	return &DataoneIndexer{
+		rootDirs: []string{"/iplant/home/shared/commons_repo/curated"},
+		recorder: recorder,
+	}
+}
+
+// Message bodies used for testing.
+var (
+	testBody          = []byte(`{"entity": "fakeid", "path": "/iplant/home/shared/commons_repo/curated/foo.txt"}`)
+	outOfRootTestBody = []byte(`{"entity": "fakeid", "path": "/iplant/home/shared/foo.txt"}`)
+	malformedTestBody = []byte(`{"entity": "fakeid", "path":`)
+	invalidTestBody   = []byte(`{"entity": "fake id", "path": "foo.txt"}`)
+	testAuthorBody    = []byte(
+		`{"author": {"name": "nobody", "zone": "nowhere"}, "path": "/iplant/home/shared/commons_repo/curated/foo.txt"}`,
+	)
+)
+
+// TestErrorClassification verifies that processing failures are classified as permanent or transient correctly.
+func TestErrorClassification(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        []byte
+		recorderErr error
+		expectErr   bool
+		requeue     bool
+	}{
+		{"success", testBody, nil, false, false},
+		{"outside of repository", outOfRootTestBody, nil, false, false},
+		{"malformed body", malformedTestBody, nil, true, false},
+		{"transient database error", testBody, &database.RetryableError{Err: fmt.Errorf("deadlock")}, true, true},
+		{"permanent database error", testBody, fmt.Errorf("unique violation"), true, false},
+	}
+
+	for _, test := range tests {
+		svc := newTestService(&fakeRecorder{err: test.recorderErr})
+		err := svc.processMessage(amqp.Delivery{RoutingKey: "data-object.open", Body: test.body})
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+		}
+		if shouldRequeue(err) != test.requeue {
+			t.Errorf("%s: expected requeue to be %t", test.name, test.requeue)
+		}
+	}
+}
+
+// TestRepositoryRoot verifies that the most specific repository root containing a path is found.
+func TestRepositoryRoot(t *testing.T) {
+	roots := []string{"/repo", "/repo/curated/", "/other"}
+	tests := []struct {
+		path     string
+		expected string
+		found    bool
+	}{
+		{"/repo/foo.txt", "/repo", true},
+		{"/repo/curated/foo.txt", "/repo/curated/", true},
+		{"/repo/curated/nested/deeper/foo.txt", "/repo/curated/", true},
+		{"/repo/curated_metadata/foo.txt", "/repo", true},
+		{"/repo/curated-staging/foo.txt", "/repo", true},
+		{"/other/foo.txt", "/other", true},
+		{"/other-staging/foo.txt", "", false},
+		{"/repository/foo.txt", "", false},
+		{"/repo", "/repo", true},
+		{"/repo/curated", "/repo/curated/", true},
+		{"/", "", false},
+	}
+
+	for _, test := range tests {
+		root, found := repositoryRoot(test.path, roots)
+		if root != test.expected || found != test.found {
+			t.Errorf("%s: expected (%q, %t), got (%q, %t)", test.path, test.expected, test.found, root, found)
+		}
+	}
+}
+
+// TestCanonicalMessagePaths verifies that the paths in messages are converted to canonical form before they're
+// compared with the repository roots, so that messy paths are recorded consistently.
+func TestCanonicalMessagePaths(t *testing.T) {
+	svc := newTestService(&fakeRecorder{})
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/iplant/home/shared/commons_repo/curated/foo.txt/", "/iplant/home/shared/commons_repo/curated/foo.txt"},
+		{"/iplant/home/shared//commons_repo/curated/foo.txt", "/iplant/home/shared/commons_repo/curated/foo.txt"},
+		{"/iplant/home/shared/commons_repo/./curated/foo.txt", "/iplant/home/shared/commons_repo/curated/foo.txt"},
+		{"//iplant/home/shared/commons_repo/curated//./foo.txt/", "/iplant/home/shared/commons_repo/curated/foo.txt"},
+		{"/iplant/home/shared/commons_repo/curated/../foo.txt", ""},
+	}
+
+	for _, test := range tests {
+		body := []byte(fmt.Sprintf(`{"entity": "fakeid", "path": "%s"}`, test.path))
+		_, msg, err := svc.prepareMessage(amqp.Delivery{RoutingKey: "data-object.open", Body: body})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.path, err)
+			continue
+		}
+		switch {
+		case test.expected == "" && msg != nil:
+			t.Errorf("%s: expected the message to be outside of the repository but got %s", test.path, msg.Path)
+		case test.expected != "" && msg == nil:
+			t.Errorf("%s: expected the message to be in the repository", test.path)
+		case test.expected != "" && msg.Path != test.expected:
+			t.Errorf("%s: expected path %s but got %s", test.path, test.expected, msg.Path)
+		}
+	}
+}
+
+// TestNormalizedMessagePaths verifies that paths written with composed characters and paths written with combining
+// characters are recorded as the same path, and that both are found in a repository root written either way.
+func TestNormalizedMessagePaths(t *testing.T) {
+	composed, decomposed := "/iplant/home/shared/caf\u00e9", "/iplant/home/shared/cafe\u0301"
+	for _, root := range []string{composed, decomposed} {
+		svc := newTestService(&fakeRecorder{})
+		svc.rootDirs = []string{model.CanonicalPath(root)}
+		for _, dir := range []string{composed, decomposed} {
+			body := []byte(fmt.Sprintf(`{"entity": "fakeid", "path": "%s/r\u00e9sum\u00e9.txt"}`, dir))
+			_, msg, err := svc.prepareMessage(amqp.Delivery{RoutingKey: "data-object.open", Body: body})
+			if err != nil || msg == nil {
+				t.Errorf("%q in %q: expected the message to be in the repository (error: %v)", dir, root, err)
+			} else if msg.Path != composed+"/r\u00e9sum\u00e9.txt" {
+				t.Errorf("%q in %q: expected the composed path but got %q", dir, root, msg.Path)
+			}
+		}
+	}
+}
+
+// TestInRoot verifies that paths are only contained in repository roots at path boundaries, and that trailing slashes
+// in the roots don't matter.
+func TestInRoot(t *testing.T) {
+	tests := []struct {
+		path     string
+		root     string
+		expected bool
+	}{
+		{"/iplant/home/shared/commons_repo/curated/foo.txt", "/iplant/home/shared/commons_repo/curated", true},
+		{"/iplant/home/shared/commons_repo/curated/foo.txt", "/iplant/home/shared/commons_repo/curated/", true},
+		{"/iplant/home/shared/commons_repo/curated-staging/f.txt", "/iplant/home/shared/commons_repo/curated", false},
+		{"/iplant/home/shared/commons_repo/curated-staging/f.txt", "/iplant/home/shared/commons_repo/curated/", false},
+		{"/iplant/home/shared/commons_repo/curated", "/iplant/home/shared/commons_repo/curated", true},
+		{"/iplant/home/shared/commons_repo/curated", "/iplant/home/shared/commons_repo/curated/", true},
+		{"/iplant/home/shared/commons_repo/curated/a/b/c.txt", "/iplant/home/shared/commons_repo/curated", true},
+		{"/iplant/home/shared/commons_repo", "/iplant/home/shared/commons_repo/curated", false},
+		{"/iplant/home/shared/foo.txt", "/", true},
+	}
+
+	for _, test := range tests {
+		if actual := inRoot(test.path, test.root); actual != test.expected {
+			t.Errorf("%s in %s: expected %t but got %t", test.path, test.root, test.expected, actual)
+		}
+	}
+}
+
+// TestMoveMessages verifies that moves are accepted if either the source or the destination is in the repository, and
+// that the service records which of them are.
+func TestMoveMessages(t *testing.T) {
+	const (
+		inside  = "/iplant/home/shared/commons_repo/curated/foo.txt"
+		renamed = "/iplant/home/shared/commons_repo/curated/bar.txt"
+		outside = "/iplant/home/ipcdev/foo.txt"
+		away    = "/iplant/home/ipcdev/bar.txt"
+	)
+	tests := []struct {
+		name        string
+		source      string
+		destination string
+		accepted    bool
+	}{
+		{"within", inside, renamed, true},
+		{"into", outside, inside, true},
+		{"out of", inside, outside, true},
+		{"outside", outside, away, false},
+	}
+
+	svc := newTestService(&fakeRecorder{})
+	for _, test := range tests {
+		body := []byte(fmt.Sprintf(
+			`{"entity": "fakeid", "old-path": "%s", "new-path": "%s"}`, test.source, test.destination,
+		))
+		_, msg, err := svc.prepareMessage(amqp.Delivery{RoutingKey: "data-object.mv", Body: body})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if !test.accepted {
+			if msg != nil {
+				t.Errorf("%s: expected the move to be ignored", test.name)
+			}
+			continue
+		}
+		if msg == nil {
+			t.Errorf("%s: expected the move to be accepted", test.name)
+			continue
+		}
+		if msg.Source != test.source || msg.Path != test.destination {
+			t.Errorf("%s: unexpected source and destination: %s and %s", test.name, msg.Source, msg.Path)
+		}
+		if msg.SourceInRepository != (test.source == inside) || msg.InRepository != (test.destination != outside) {
+			t.Errorf("%s: unexpected repository flags: %t and %t", test.name, msg.SourceInRepository, msg.InRepository)
+		}
+	}
+}
+
+// TestIgnoredGrantees verifies that permission changes for ignored users and groups are discarded, whether they're
+// ignored by name or by qualified name.
+func TestIgnoredGrantees(t *testing.T) {
+	tests := []struct {
+		name     string
+		grantee  string
+		accepted bool
+	}{
+		{"ignored by name", `{"name": "rodsadmin", "zone": "iplant"}`, false},
+		{"ignored by qualified name", `{"name": "de-irods", "zone": "iplant"}`, false},
+		{"ignored in another zone", `{"name": "de-irods", "zone": "other"}`, true},
+		{"not ignored", `{"name": "ipcdev", "zone": "iplant"}`, true},
+	}
+
+	svc := newTestService(&fakeRecorder{})
+	svc.ignoredGrantees = map[string]bool{"rodsadmin": true, "de-irods#iplant": true}
+	for _, test := range tests {
+		body := []byte(fmt.Sprintf(
+			`{"entity": "fakeid", "path": "/iplant/home/shared/commons_repo/curated/foo.txt", "user": %s,`+
+				` "permission": "read"}`,
+			test.grantee,
+		))
+		_, msg, err := svc.prepareMessage(amqp.Delivery{RoutingKey: "data-object.acl.mod", Body: body})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if (msg != nil) != test.accepted {
+			t.Errorf("%s: expected accepted to be %t", test.name, test.accepted)
+		}
+	}
+}
+
+// TestRootNodeID verifies that messages are recorded under the member node identifier assigned to the repository root
+// that contains them, if there is one.
+func TestRootNodeID(t *testing.T) {
+	svc := newTestService(&fakeRecorder{})
+	svc.rootDirs = append(svc.rootDirs, "/iplant/home/shared/commons_repo/other")
+	svc.rootNodeIDs = map[string]string{"/iplant/home/shared/commons_repo/other": "urn:node:other"}
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/iplant/home/shared/commons_repo/curated/foo.txt", ""},
+		{"/iplant/home/shared/commons_repo/other/foo.txt", "urn:node:other"},
+	}
+
+	for _, test := range tests {
+		body := []byte(fmt.Sprintf(`{"entity": "fakeid", "path": "%s"}`, test.path))
+		_, msg, err := svc.prepareMessage(amqp.Delivery{RoutingKey: "data-object.open", Body: body})
+		if err != nil || msg == nil {
+			t.Errorf("%s: unable to prepare message: %v", test.path, err)
+			continue
+		}
+		if msg.NodeID != test.expected {
+			t.Errorf("%s: expected node identifier %q, got %q", test.path, test.expected, msg.NodeID)
+		}
+	}
+}
+
+// TestUnmatchedRoutingKey verifies that messages with routing keys that don't match any of the recorder's rules are
+// counted and acknowledged without being recorded.
+func TestUnmatchedRoutingKey(t *testing.T) {
+	recorder := &fakeRecorder{}
+	svc := newTestService(recorder)
+	before := unmatchedMessages.Get("data-object.add")
+
+	if err := svc.processMessage(amqp.Delivery{RoutingKey: "data-object.add", Body: testBody}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if events := atomic.LoadInt64(&recorder.events); events != 0 {
+		t.Errorf("expected no recorded events but got %d", events)
+	}
+	if count := unmatchedMessages.Get("data-object.add") - before; count != 1 {
+		t.Errorf("expected 1 unmatched message but got %d", count)
+	}
+}
+
+// TestReadKeyAliases verifies that several routing keys can be configured for read events, either as a list or as a
+// comma-separated string, and that each of them is recorded as a read.
+func TestReadKeyAliases(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected []string
+	}{
+		{"default", "", []string{"data-object.open", "data-object.get", "data-object.download"}},
+		{
+			"comma-separated",
+			"dataone:\n  amqp-routing-keys:\n    read: data-object.open, data-object.fetch\n",
+			[]string{"data-object.open", "data-object.fetch"},
+		},
+	}
+
+	for _, test := range tests {
+		cfg, err := configurate.InitDefaultsR(bytes.NewBufferString(test.config), defaultConfig)
+		if err != nil {
+			t.Fatalf("%s: unable to load the configuration: %s", test.name, err)
+		}
+		keyNames := getRoutingKeys(cfg)
+		if !reflect.DeepEqual(keyNames.Read, test.expected) {
+			t.Errorf("%s: expected read keys %v but got %v", test.name, test.expected, keyNames.Read)
+		}
+		db, _, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening stub database connection: %s", err)
+		}
+		handlers := database.NewRecorder(db, keyNames, "fakenode").GetHandlerMap()
+		for _, key := range test.expected {
+			if handlers.Find(key) == nil {
+				t.Errorf("%s: no handler found for routing key %s", test.name, key)
+			}
+		}
+		db.Close()
+	}
+}
+
+// TestMessageOutcomes verifies that the outcome of processing each message is counted under its routing key.
+func TestMessageOutcomes(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		body        []byte
+		recorderErr error
+		outcome     string
+	}{
+		{"recorded", "data-object.open", testBody, nil, outcomeRecorded},
+		{"outside of repository", "data-object.open", outOfRootTestBody, nil, outcomeOutOfRoot},
+		{"malformed body", "data-object.open", malformedTestBody, nil, outcomeDecodeFailed},
+		{"invalid message", "data-object.open", invalidTestBody, nil, outcomeInvalid},
+		{"unsupported version", "data-object.open", []byte(`{"version": 99}`), nil, outcomeUnsupportedVersion},
+		{"database error", "data-object.open", testBody, fmt.Errorf("unique violation"), outcomeRecordFailed},
+		{"no recorder rule", "data-object.add", testBody, nil, outcomeUnmatched},
+	}
+
+	for _, test := range tests {
+		svc := newTestService(&fakeRecorder{err: test.recorderErr})
+		received := messageOutcomes.Get(test.key + "/" + outcomeReceived)
+		before := messageOutcomes.Get(test.key + "/" + test.outcome)
+
+		svc.processMessage(amqp.Delivery{RoutingKey: test.key, Body: test.body})
+		if count := messageOutcomes.Get(test.key+"/"+outcomeReceived) - received; count != 1 {
+			t.Errorf("%s: expected 1 received message but got %d", test.name, count)
+		}
+		if count := messageOutcomes.Get(test.key+"/"+test.outcome) - before; count != 1 {
+			t.Errorf("%s: expected 1 %s message but got %d", test.name, test.outcome, count)
+		}
+	}
+}
+
+// TestContentTypes verifies that messages are decoded according to their content types, that messages without content
+// types are decoded as JSON, and that messages with unsupported content types are rejected as invalid messages.
+func TestContentTypes(t *testing.T) {
+	tests := []struct {
+		contentType string
+		supported   bool
+	}{
+		{"", true},
+		{"application/json; charset=utf-8", true},
+		{"application/x-protobuf", false},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{}
+		svc := newTestService(recorder)
+		key := "data-object.open"
+		before := messageOutcomes.Get(key + "/" + outcomeUnsupportedContentType)
+
+		err := svc.processMessage(amqp.Delivery{RoutingKey: key, ContentType: test.contentType, Body: testBody})
+		if test.supported {
+			if err != nil || atomic.LoadInt64(&recorder.events) != 1 {
+				t.Errorf("%q: expected the event to be recorded (error: %v)", test.contentType, err)
+			}
+			continue
+		}
+		if err == nil || shouldRequeue(err) || !shouldQuarantine(err) {
+			t.Errorf("%q: expected an invalid message error but got %v", test.contentType, err)
+		} else if !strings.Contains(err.Error(), "unsupported content type: "+test.contentType) {
+			t.Errorf("%q: the error does not mention the content type: %s", test.contentType, err)
+		}
+		if count := messageOutcomes.Get(key+"/"+outcomeUnsupportedContentType) - before; count != 1 {
+			t.Errorf("%q: expected 1 unsupported content type but got %d", test.contentType, count)
+		}
+	}
+}
+
+// TestMessageValidation verifies that messages missing required fields are rejected as invalid messages that list
+// every problem, and that the author is only required if the service is configured to require it.
+func TestMessageValidation(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          []byte
+		requireAuthor bool
+		problems      []string
+	}{
+		{"valid", testAuthorBody, true, nil},
+		{"author not required", testBody, false, nil},
+		{"no author", testBody, true, []string{"the author is missing"}},
+		{
+			"invalid fields",
+			invalidTestBody,
+			false,
+			[]string{"the path is not absolute", "the entity identifier is malformed"},
+		},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{}
+		svc := newTestService(recorder)
+		svc.validation = []*keyRequirements{
+			{[]string{"data-object.open"}, model.Requirements{Author: test.requireAuthor}},
+		}
+
+		err := svc.processMessage(amqp.Delivery{RoutingKey: "data-object.open", Body: test.body})
+		if test.problems == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: an error was expected but none was encountered", test.name)
+			continue
+		}
+		if shouldRequeue(err) || !shouldQuarantine(err) {
+			t.Errorf("%s: the error should be classified as an invalid message: %s", test.name, err)
+		}
+		for _, problem := range test.problems {
+			if !strings.Contains(err.Error(), problem) {
+				t.Errorf("%s: the error does not mention '%s': %s", test.name, problem, err)
+			}
+		}
+		if events := atomic.LoadInt64(&recorder.events); events != 0 {
+			t.Errorf("%s: expected no recorded events but got %d", test.name, events)
+		}
+	}
+}
+
+// TestWorkerPool verifies that all deliveries received before the delivery channel closes are processed by the worker
+// pool before message processing stops.
+func TestWorkerPool(t *testing.T) {
+	recorder := &fakeRecorder{}
+	session, messages := newFakeSession()
+	svc := newTestService(recorder)
+	svc.workers = 4
+	svc.newSession = func() (*amqpSession, error) {
+		return session, nil
+	}
+
+	// Send the deliveries and close the delivery channel.
+	go func() {
+		for i := 0; i < 25; i++ {
+			messages <- amqp.Delivery{RoutingKey: "data-object.open", Body: testBody}
+		}
+		close(messages)
+	}()
+
+	if err := runProcessMessages(t, svc); err == nil {
+		t.Error("an error was expected but none was encountered")
+	}
+	if events := atomic.LoadInt64(&recorder.events); events != 25 {
+		t.Errorf("expected 25 recorded events but got %d", events)
+	}
+}
+
+// TestPanicRecovery verifies that the service keeps processing messages after a message can't be decoded or causes a
+// panic, and that the offending messages are rejected without being requeued.
+func TestPanicRecovery(t *testing.T) {
+	recorder := &fakeRecorder{panics: 1}
+	session, messages := newFakeSession()
+	svc := newTestService(recorder)
+	svc.newSession = func() (*amqpSession, error) {
+		return session, nil
+	}
+
+	// Send a malformed message and a message that causes a panic, followed by messages that can be recorded.
+	go func() {
+		messages <- amqp.Delivery{RoutingKey: "data-object.open", Body: malformedTestBody}
+		for i := 0; i < 3; i++ {
+			messages <- amqp.Delivery{RoutingKey: "data-object.open", Body: testBody}
+		}
+		close(messages)
+	}()
+
+	if err := runProcessMessages(t, svc); err == nil {
+		t.Error("an error was expected but none was encountered")
+	}
+	if events := atomic.LoadInt64(&recorder.events); events != 3 {
+		t.Errorf("expected 3 events to be recorded but got %d", events)
+	}
+	if processed := atomic.LoadInt64(&svc.processed); processed != 4 {
+		t.Errorf("expected 4 processed messages but got %d", processed)
+	}
+}
+
+// TestRecoverMessagePanic verifies that a panic while processing a message is reported as a permanent error.
+func TestRecoverMessagePanic(t *testing.T) {
+	svc := newTestService(&fakeRecorder{panics: 1})
+	err := svc.processMessage(amqp.Delivery{RoutingKey: "data-object.open", Body: testBody})
+	if err == nil {
+		t.Fatal("an error was expected but none was encountered")
+	}
+	if shouldRequeue(err) || shouldQuarantine(err) {
+		t.Errorf("the error should be permanent: %s", err)
+	}
+	if !strings.Contains(err.Error(), "unexpected event") {
+		t.Errorf("the error does not describe the panic: %s", err)
+	}
+}
+
+// TestRedeliveryDeduplication verifies that a redelivered copy of a message that was already recorded doesn't produce
+// a second row in the database.
+func TestRedeliveryDeduplication(t *testing.T) {
+
+	// Create the stub database connection.
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+
+	// Create the service.
+	keyNames := &database.KeyNames{Read: []string{"data-object.open"}}
+	svc := newTestService(database.NewRecorder(db, keyNames, "fakenode"))
+	svc.recent = newRecentMessages(10)
+
+	// Exactly one row should be inserted.
+	mock.ExpectQuery("WITH RECURSIVE chain").WillReturnRows(sqlmock.NewRows([]string{"entity", "permanent_id"}))
+	mock.ExpectPrepare("INSERT INTO event_log")
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO event_log").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	// Process the original message and the redelivered copy.
+	original := amqp.Delivery{RoutingKey: "data-object.open", Body: testBody}
+	redelivered := original
+	redelivered.Redelivered = true
+	for _, delivery := range []amqp.Delivery{original, redelivered} {
+		if err := svc.processMessage(delivery); err != nil {
+			t.Fatalf("error encountered while processing message: %s", err)
+		}
+	}
+
+	// Verify that the expectations were met.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestQuarantine verifies that invalid messages are quarantined and acknowledged, and that they're rejected if they
+// can't be quarantined.
+func TestQuarantine(t *testing.T) {
+	tests := []struct {
+		name          string
+		quarantineErr error
+		acks          int
+		nacks         int
+	}{
+		{"quarantined", nil, 1, 0},
+		{"quarantine failed", fmt.Errorf("database unavailable"), 0, 1},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{quarantineErr: test.quarantineErr}
+		svc := newTestService(recorder)
+		svc.manualAck = true
+		svc.quarantine = true
+
+		acknowledger := &fakeAcknowledger{}
+		session, _ := newFakeSession()
+		svc.handleDelivery(session, amqp.Delivery{Acknowledger: acknowledger, Body: malformedTestBody})
+		if recorder.quarantined != 1 {
+			t.Errorf("%s: expected 1 quarantined message but got %d", test.name, recorder.quarantined)
+		}
+		if acknowledger.acks != test.acks || acknowledger.nacks != test.nacks {
+			t.Errorf(
+				"%s: expected %d acks and %d nacks but got %d and %d",
+				test.name, test.acks, test.nacks, acknowledger.acks, acknowledger.nacks,
+			)
+		}
+	}
+}
+
+// TestStoreFailure verifies that messages whose events could not be recorded are stored for replay when the service
+// gives up on them, and that they're acknowledged only if they were stored.
+func TestStoreFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		enabled    bool
+		err        error
+		failureErr error
+		attempts   int32
+		failures   int64
+		acks       int
+		nacks      int
+	}{
+		{"permanent failure", true, fmt.Errorf("value too long"), nil, 0, 1, 1, 0},
+		{"retries exhausted", true, &database.RetryableError{Err: fmt.Errorf("timeout")}, nil, 4, 1, 1, 0},
+		{"storage failed", true, fmt.Errorf("value too long"), fmt.Errorf("database unavailable"), 0, 1, 0, 1},
+		{"disabled", false, fmt.Errorf("value too long"), nil, 0, 0, 0, 1},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{err: test.err, failureErr: test.failureErr}
+		svc := newTestService(recorder)
+		svc.manualAck = true
+		svc.maxAttempts = 5
+		svc.eventErrors = test.enabled
+
+		acknowledger := &fakeAcknowledger{}
+		session, _ := newFakeSession()
+		delivery := amqp.Delivery{
+			Acknowledger: acknowledger,
+			Headers:      amqp.Table{retryAttemptsHeader: test.attempts},
+			RoutingKey:   "data-object.open",
+			Body:         testBody,
+		}
+		svc.handleDelivery(session, delivery)
+
+		if recorder.failures != test.failures {
+			t.Errorf("%s: expected %d stored messages but got %d", test.name, test.failures, recorder.failures)
+		}
+		if acknowledger.acks != test.acks || acknowledger.nacks != test.nacks {
+			t.Errorf(
+				"%s: expected %d acks and %d nacks but got %d and %d",
+				test.name, test.acks, test.nacks, acknowledger.acks, acknowledger.nacks,
+			)
+		}
+	}
+}
+
+// TestQuarantineDisabled verifies that invalid messages aren't quarantined when quarantining is disabled.
+func TestQuarantineDisabled(t *testing.T) {
+	recorder := &fakeRecorder{}
+	svc := newTestService(recorder)
+	svc.manualAck = true
+
+	acknowledger := &fakeAcknowledger{}
+	session, _ := newFakeSession()
+	svc.handleDelivery(session, amqp.Delivery{Acknowledger: acknowledger, Body: malformedTestBody})
+	if recorder.quarantined != 0 {
+		t.Errorf("expected no quarantined messages but got %d", recorder.quarantined)
+	}
+	if acknowledger.nacks != 1 || acknowledger.requeues != 0 {
+		t.Error("the message should have been rejected without being requeued")
+	}
+}
+
+// TestGracefulDrain verifies that deliveries that have already been received are processed when the service shuts
+// down, and that message processing stops without an error.
+func TestGracefulDrain(t *testing.T) {
+	recorder := &fakeRecorder{}
+	session, messages := newFakeSession()
+	svc := newTestService(recorder)
+	svc.workers = 2
+	svc.stop = make(chan struct{})
+	svc.newSession = func() (*amqpSession, error) {
+		return session, nil
+	}
+
+	// Request a shutdown, then simulate the deliveries that remain after the consumer is cancelled.
+	close(svc.stop)
+	go func() {
+		for i := 0; i < 5; i++ {
+			messages <- amqp.Delivery{RoutingKey: "data-object.open", Body: testBody}
+		}
+		close(messages)
+	}()
+
+	if err := runProcessMessages(t, svc); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if events := atomic.LoadInt64(&recorder.events); events != 5 {
+		t.Errorf("expected 5 recorded events but got %d", events)
+	}
+}
+
+// fakePublisher records the events that it's asked to publish and returns a configured error.
+type fakePublisher struct {
+	err    error
+	events []*database.Event
+}
+
+// publish records the event and returns the configured error.
+func (p *fakePublisher) publish(event *database.Event) error {
+	p.events = append(p.events, event)
+	return p.err
+}
+
+// close does nothing.
+func (p *fakePublisher) close() {}
+
+// TestPublishRecordedEvent verifies that recorded events are published, and that publishing failures don't cause
+// messages to be rejected.
+func TestPublishRecordedEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"success", nil},
+		{"failure", fmt.Errorf("broker unavailable")},
+	}
+
+	for _, test := range tests {
+		publisher := &fakePublisher{err: test.err}
+		svc := newTestService(&fakeRecorder{})
+		svc.publisher = publisher
+
+		delivery := amqp.Delivery{RoutingKey: "data-object.open", Body: testBody}
+		if err := svc.processMessage(delivery); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		if len(publisher.events) != 1 {
+			t.Fatalf("%s: expected 1 published event but got %d", test.name, len(publisher.events))
+		}
+		if publisher.events[0].NodeID != "fakenode" {
+			t.Errorf("%s: unexpected event published: %+v", test.name, publisher.events[0])
+		}
+	}
+}
+
+// TestRecordedEventLog verifies that the identifier of each recorded event is logged along with the message that
+// produced it at the configured level.
+func TestRecordedEventLog(t *testing.T) {
+	tests := []struct {
+		level    string
+		expected int
+	}{
+		{recordedLogInfo, 1},
+		{recordedLogDebug, 0},
+		{recordedLogOff, 0},
+	}
+
+	// Capture log messages at the info level.
+	var buf bytes.Buffer
+	logrus.SetOutput(&buf)
+	defer logrus.SetOutput(os.Stderr)
+
+	for _, test := range tests {
+		buf.Reset()
+		svc := newTestService(&fakeRecorder{})
+		svc.recordedLogLevel = test.level
+
+		if err := svc.processMessage(amqp.Delivery{RoutingKey: "data-object.open", Body: testAuthorBody}); err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.level, err)
+		}
+
+		var lines []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var fields map[string]interface{}
+			if json.Unmarshal([]byte(line), &fields) == nil && fields["msg"] == "recorded event" {
+				lines = append(lines, fields)
+			}
+		}
+		if len(lines) != test.expected {
+			t.Fatalf("%s: expected %d log messages but got %d", test.level, test.expected, len(lines))
+		}
+		for _, fields := range lines {
+			user, key := fields["user"], fields["routing-key"]
+			if user != "nobody#nowhere" || key != "data-object.open" || fields["event-id"] == nil {
+				t.Errorf("%s: unexpected log fields: %v", test.level, fields)
+			}
+		}
+	}
+}
+
+// TestDuplicateEvent verifies that messages whose events were suppressed as duplicates are acknowledged and counted,
+// but that the suppressed events aren't published.
+func TestDuplicateEvent(t *testing.T) {
+	publisher := &fakePublisher{}
+	svc := newTestService(&fakeRecorder{duplicate: true})
+	svc.publisher = publisher
+	before := messageOutcomes.Get("data-object.open/" + outcomeDeduplicated)
+
+	delivery := amqp.Delivery{RoutingKey: "data-object.open", Body: testBody}
+	if err := svc.processMessage(delivery); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if len(publisher.events) != 0 {
+		t.Errorf("expected no published events but got %d", len(publisher.events))
+	}
+	if count := messageOutcomes.Get("data-object.open/"+outcomeDeduplicated) - before; count != 1 {
+		t.Errorf("expected 1 deduplicated message but got %d", count)
+	}
+}
+
+// TestAlreadyRecordedEvent verifies that messages whose events were skipped because they had already been recorded
+// are acknowledged and counted separately from other duplicates, and that the skipped events aren't published.
+func TestAlreadyRecordedEvent(t *testing.T) {
+	publisher := &fakePublisher{}
+	svc := newTestService(&fakeRecorder{skipped: true})
+	svc.publisher = publisher
+	skipped := messageOutcomes.Get("data-object.open/" + outcomeAlreadyRecorded)
+	deduplicated := messageOutcomes.Get("data-object.open/" + outcomeDeduplicated)
+
+	delivery := amqp.Delivery{RoutingKey: "data-object.open", Body: testBody, MessageId: "fake-id"}
+	if err := svc.processMessage(delivery); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if len(publisher.events) != 0 {
+		t.Errorf("expected no published events but got %d", len(publisher.events))
+	}
+	if count := messageOutcomes.Get("data-object.open/"+outcomeAlreadyRecorded) - skipped; count != 1 {
+		t.Errorf("expected 1 already recorded message but got %d", count)
+	}
+	if count := messageOutcomes.Get("data-object.open/"+outcomeDeduplicated) - deduplicated; count != 0 {
+		t.Errorf("expected no deduplicated messages but got %d", count)
+	}
+}
+
+// TestArchivedObjectRead verifies that messages whose reads were skipped because their data objects are archived are
+// acknowledged and counted separately from duplicates, and that the skipped events aren't published.
+func TestArchivedObjectRead(t *testing.T) {
+	publisher := &fakePublisher{}
+	svc := newTestService(&fakeRecorder{archived: true})
+	svc.publisher = publisher
+	archived := messageOutcomes.Get("data-object.open/" + outcomeArchivedObject)
+	recorded := messageOutcomes.Get("data-object.open/" + outcomeRecorded)
+
+	delivery := amqp.Delivery{RoutingKey: "data-object.open", Body: testBody}
+	if err := svc.processMessage(delivery); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if len(publisher.events) != 0 {
+		t.Errorf("expected no published events but got %d", len(publisher.events))
+	}
+	if count := messageOutcomes.Get("data-object.open/"+outcomeArchivedObject) - archived; count != 1 {
+		t.Errorf("expected 1 read of an archived object but got %d", count)
+	}
+	if count := messageOutcomes.Get("data-object.open/"+outcomeRecorded) - recorded; count != 0 {
+		t.Errorf("expected no recorded messages but got %d", count)
+	}
+}
+
+// TestMessageTimeout verifies that a message is requeued if its event can't be recorded before the message timeout
+// elapses.
+func TestMessageTimeout(t *testing.T) {
+	svc := newTestService(&fakeRecorder{block: true})
+	svc.timeout = 10 * time.Millisecond
+
+	delivery := amqp.Delivery{RoutingKey: "data-object.open", Body: testBody}
+	err := svc.processMessage(delivery)
+	if err == nil {
+		t.Fatal("an error was expected but none was encountered")
+	}
+	if !shouldRequeue(err) {
+		t.Errorf("the message should have been requeued: %s", err)
+	}
+}
+
+// TestServiceCancellation verifies that in-flight operations are abandoned when the service context is cancelled.
+func TestServiceCancellation(t *testing.T) {
+	svc := newTestService(&fakeRecorder{block: true})
+	svc.timeout = time.Minute
+	svc.ctx, svc.cancel = context.WithCancel(context.Background())
+	svc.cancel()
+
+	delivery := amqp.Delivery{RoutingKey: "data-object.open", Body: testBody}
+	if err := svc.processMessage(delivery); err == nil || !shouldRequeue(err) {
+		t.Errorf("the message should have been requeued: %v", err)
+	}
+}
+
+// fakeAmqpPublisher records the messages that it's asked to publish and returns a configured error.
+type fakeAmqpPublisher struct {
+	err       error
+	keys      []string
+	published []amqp.Publishing
+}
+
+// Publish records the message and returns the configured error.
+func (p *fakeAmqpPublisher) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	p.keys = append(p.keys, key)
+	p.published = append(p.published, msg)
+	return p.err
+}
+
+// TestDelayedRetry verifies that messages that fail with transient errors are published to the retry queue until the
+// maximum number of retries is reached.
+func TestDelayedRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		attempts    interface{}
+		publishErr  error
+		quarantine  bool
+		published   int
+		acks        int
+		requeues    int
+		quarantined int64
+	}{
+		{"first failure", nil, nil, false, 1, 1, 0, 0},
+		{"later failure", int32(3), nil, false, 1, 1, 0, 0},
+		{"too many retries", int32(4), nil, false, 0, 0, 0, 0},
+		{"too many retries with quarantine", int32(4), nil, true, 0, 1, 0, 1},
+		{"publish failure", nil, fmt.Errorf("channel closed"), false, 1, 0, 1, 0},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{err: &database.RetryableError{Err: fmt.Errorf("database unavailable")}}
+		svc := newTestService(recorder)
+		svc.manualAck = true
+		svc.quarantine = test.quarantine
+		svc.retry = &retrySettings{queue: "dataone.events.retry", ttl: time.Second, maxAttempts: 5}
+
+		publisher := &fakeAmqpPublisher{err: test.publishErr}
+		session, _ := newFakeSession()
+		session.publisher = publisher
+
+		headers := amqp.Table{}
+		if test.attempts != nil {
+			headers[retryAttemptsHeader] = test.attempts
+			headers[retryRoutingKeyHeader] = "data-object.open"
+		}
+		acknowledger := &fakeAcknowledger{}
+		delivery := amqp.Delivery{
+			Acknowledger: acknowledger,
+			Headers:      headers,
+			RoutingKey:   "data-object.open",
+			Body:         testBody,
+		}
+		svc.handleDelivery(session, delivery)
+
+		if len(publisher.published) != test.published {
+			t.Errorf("%s: expected %d publications but got %d", test.name, test.published, len(publisher.published))
+		}
+		if acknowledger.acks != test.acks || acknowledger.requeues != test.requeues {
+			t.Errorf(
+				"%s: expected %d acks and %d requeues but got %d and %d",
+				test.name, test.acks, test.requeues, acknowledger.acks, acknowledger.requeues,
+			)
+		}
+		if recorder.quarantined != test.quarantined {
+			t.Errorf("%s: expected %d quarantined but got %d", test.name, test.quarantined, recorder.quarantined)
+		}
+	}
+}
+
+// TestMessageLag verifies that the processing lag is computed from the AMQP timestamp property, falling back to the
+// timestamp in the message body, and that messages without timestamps are excluded.
+func TestMessageLag(t *testing.T) {
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	bodyTime := model.Timestamp(now.Add(-time.Minute))
+	zeroTime := model.Timestamp(time.Time{})
+
+	tests := []struct {
+		name      string
+		property  time.Time
+		timestamp *model.Timestamp
+		expected  time.Duration
+		ok        bool
+	}{
+		{"property", now.Add(-5 * time.Second), &bodyTime, 5 * time.Second, true},
+		{"body", time.Time{}, &bodyTime, time.Minute, true},
+		{"clock skew", now.Add(time.Second), nil, 0, true},
+		{"no timestamps", time.Time{}, nil, 0, false},
+		{"zero body timestamp", time.Time{}, &zeroTime, 0, false},
+	}
+
+	for _, test := range tests {
+		delivery := amqp.Delivery{Timestamp: test.property}
+		lag, ok := messageLag(delivery, &model.Message{Timestamp: test.timestamp}, now)
+		if ok != test.ok || lag != test.expected {
+			t.Errorf("%s: expected (%s, %t) but got (%s, %t)", test.name, test.expected, test.ok, lag, ok)
+		}
+	}
+}
+
+// TestResolveTimestamp verifies that timestamps that can't be parsed are replaced with the AMQP timestamp property,
+// falling back to the current time, and that timestamps that were parsed are kept.
+func TestResolveTimestamp(t *testing.T) {
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	published := now.Add(-time.Minute)
+	bodyTime := now.Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		property time.Time
+		format   string
+		expected *time.Time
+	}{
+		{"parsed", published, model.TimestampRFC3339, &bodyTime},
+		{"no timestamp", published, "", nil},
+		{"unparseable", published, model.TimestampUnparseable, &published},
+		{"unparseable without property", time.Time{}, model.TimestampUnparseable, &now},
+	}
+
+	for _, test := range tests {
+		msg := &model.Message{TimestampFormat: test.format}
+		if test.format != "" && test.format != model.TimestampUnparseable {
+			timestamp := bodyTime
+			msg.Timestamp = (*model.Timestamp)(&timestamp)
+		}
+		resolveTimestamp(amqp.Delivery{Timestamp: test.property}, msg, now)
+		switch {
+		case test.expected == nil && msg.Timestamp != nil:
+			t.Errorf("%s: expected no timestamp but got %s", test.name, msg.Timestamp.ToTime())
+		case test.expected != nil && msg.Timestamp == nil:
+			t.Errorf("%s: expected timestamp %s but got none", test.name, test.expected)
+		case test.expected != nil && !msg.Timestamp.ToTime().Equal(*test.expected):
+			t.Errorf("%s: expected timestamp %s but got %s", test.name, test.expected, msg.Timestamp.ToTime())
+		}
+		if replaced := test.expected != nil && test.expected.Equal(now); msg.TimestampReplaced != replaced {
+			t.Errorf("%s: expected the timestamp to be marked as replaced: %t", test.name, replaced)
+		}
+	}
+}
+
+// TestClampTimestamp verifies that timestamps further in the future than the maximum clock skew are replaced with the
+// current time and counted, and that other timestamps are kept.
+func TestClampTimestamp(t *testing.T) {
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		offset  time.Duration
+		skew    time.Duration
+		clamped bool
+	}{
+		{"past", -time.Hour, time.Minute, false},
+		{"within skew", time.Minute, time.Minute, false},
+		{"beyond skew", time.Minute + time.Second, time.Minute, true},
+		{"hours ahead", 3 * time.Hour, time.Minute, true},
+		{"default skew", 4 * time.Minute, 0, false},
+		{"beyond default skew", 6 * time.Minute, 0, true},
+	}
+
+	for _, test := range tests {
+		original := now.Add(test.offset)
+		timestamp := original
+		msg := &model.Message{Path: "/iplant/home/shared/commons_repo/curated/foo.txt"}
+		msg.Timestamp = (*model.Timestamp)(&timestamp)
+		key := "data-object.open." + test.name
+		clampTimestamp(key, msg, now, test.skew)
+
+		switch {
+		case test.clamped && !msg.Timestamp.ToTime().Equal(now):
+			t.Errorf("%s: expected the timestamp to be clamped but got %s", test.name, msg.Timestamp.ToTime())
+		case !test.clamped && !msg.Timestamp.ToTime().Equal(original):
+			t.Errorf("%s: expected timestamp %s but got %s", test.name, original, msg.Timestamp.ToTime())
+		}
+		if msg.TimestampReplaced != test.clamped {
+			t.Errorf("%s: expected the timestamp to be marked as replaced: %t", test.name, test.clamped)
+		}
+		expected := int64(0)
+		if test.clamped {
+			expected = 1
+		}
+		if count := futureTimestamps.Get(key); count != expected {
+			t.Errorf("%s: expected %d clamped timestamps but got %d", test.name, expected, count)
+		}
+	}
+
+	// Messages without timestamps are left alone.
+	msg := &model.Message{}
+	clampTimestamp("data-object.open", msg, now, time.Minute)
+	if msg.Timestamp != nil {
+		t.Errorf("expected no timestamp but got %s", msg.Timestamp.ToTime())
+	}
+}
+
+// TestFutureMessageTimestamps verifies that events for messages with timestamps in the future are recorded at the
+// current time.
+func TestFutureMessageTimestamps(t *testing.T) {
+	svc := newTestService(&fakeRecorder{})
+	future := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	body := []byte(`{"entity": "fakeid", "path": "/iplant/home/shared/commons_repo/curated/foo.txt", ` +
+		`"timestamp": "` + future + `"}`)
+
+	before := time.Now()
+	_, msg, err := svc.prepareMessage(amqp.Delivery{RoutingKey: "data-object.open", Body: body})
+	if err != nil || msg == nil {
+		t.Fatalf("expected the message to be recorded (error: %v)", err)
+	}
+	if recorded := *msg.Timestamp.ToTime(); recorded.Before(before) || recorded.After(time.Now()) {
+		t.Errorf("expected the current time but got %s", recorded)
+	}
+}
+
+// TestRedeliveryCap verifies that messages that fail with transient errors are returned to the main queue with an
+// updated attempt count when delayed retries are disabled, and that the service gives up on them once the maximum
+// number of attempts is reached.
+func TestRedeliveryCap(t *testing.T) {
+	tests := []struct {
+		name      string
+		attempts  int32
+		published int
+		acks      int
+		nacks     int
+		requeues  int
+	}{
+		{"first failure", 0, 1, 1, 0, 0},
+		{"last retry", 3, 1, 1, 0, 0},
+		{"too many attempts", 4, 0, 0, 1, 0},
+	}
+
+	for _, test := range tests {
+		svc := newTestService(&fakeRecorder{err: &database.RetryableError{Err: fmt.Errorf("database unavailable")}})
+		svc.manualAck = true
+		svc.maxAttempts = 5
+		svc.deadLetter = true
+
+		publisher := &fakeAmqpPublisher{}
+		session, _ := newFakeSession()
+		session.publisher = publisher
+
+		acknowledger := &fakeAcknowledger{}
+		delivery := amqp.Delivery{
+			Acknowledger: acknowledger,
+			Headers:      amqp.Table{retryAttemptsHeader: test.attempts},
+			RoutingKey:   "data-object.open",
+			Body:         testBody,
+		}
+		svc.handleDelivery(session, delivery)
+
+		if len(publisher.published) != test.published {
+			t.Errorf("%s: expected %d publications but got %d", test.name, test.published, len(publisher.published))
+		}
+		if test.published > 0 {
+			if publisher.keys[0] != "dataone.events" {
+				t.Errorf("%s: the message was published with routing key %s", test.name, publisher.keys[0])
+			}
+			if attempts := retryAttempts(publisher.published[0].Headers); attempts != int(test.attempts)+1 {
+				t.Errorf("%s: expected %d attempts but got %d", test.name, test.attempts+1, attempts)
+			}
+		}
+		a := acknowledger
+		if a.acks != test.acks || a.nacks != test.nacks || a.requeues != test.requeues {
+			t.Errorf(
+				"%s: expected %d acks, %d nacks and %d requeues but got %d, %d and %d",
+				test.name, test.acks, test.nacks, test.requeues, a.acks, a.nacks, a.requeues,
+			)
+		}
+	}
+}
+
+// TestGetQueuePurge verifies that the queue is only purged if a purge is requested and confirmed, either on the command
+// line or in the configuration.
+func TestGetQueuePurge(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested bool
+		confirmed bool
+		allowed   bool
+		expected  bool
+		expectErr bool
+	}{
+		{"not requested", false, false, false, false, false},
+		{"confirmed", true, true, false, true, false},
+		{"allowed", true, false, true, true, false},
+		{"unconfirmed", true, false, false, false, true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("amqp.queue.allow-purge", test.allowed)
+		purge, err := getQueuePurge(cfg, test.requested, test.confirmed)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+		}
+		if purge != test.expected {
+			t.Errorf("%s: expected purge to be %t but got %t", test.name, test.expected, purge)
+		}
+	}
+}
+
+// TestPurgeAtStartup verifies that the queue is purged at startup when the purge is requested and allowed by the
+// configuration, and that message processing continues normally afterwards.
+func TestPurgeAtStartup(t *testing.T) {
+	recorder := &fakeRecorder{}
+	session, messages := newFakeSession()
+	svc := newTestService(recorder)
+	cfg := viper.New()
+	cfg.Set("amqp.queue.allow-purge", true)
+	purge, err := getQueuePurge(cfg, true, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	svc.purge = purge
+	svc.newSession = func() (*amqpSession, error) {
+		return session, nil
+	}
+
+	go func() {
+		messages <- amqp.Delivery{RoutingKey: "data-object.open", Body: testBody}
+		close(messages)
+	}()
+
+	if err := runProcessMessages(t, svc); err == nil {
+		t.Error("an error was expected but none was encountered")
+	}
+	if events := atomic.LoadInt64(&recorder.events); events != 1 {
+		t.Errorf("expected 1 recorded event but got %d", events)
+	}
+	if svc.purge {
+		t.Error("the queue was not purged at startup")
+	}
+}
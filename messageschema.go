@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/schema"
+	"github.com/spf13/viper"
+)
+
+// messageSchema is the JSON Schema that message bodies are validated against before they're decoded, along with the
+// patterns of the routing keys whose messages aren't validated.
+type messageSchema struct {
+	schema  *schema.Schema
+	skipped []string
+}
+
+// getMessageSchema loads the message schema named in the configuration. The return value is nil if no schema is
+// configured, in which case message bodies aren't validated.
+func getMessageSchema(cfg *viper.Viper) (*messageSchema, error) {
+	path := cfg.GetString("dataone.message-schema")
+	if path == "" {
+		return nil, nil
+	}
+	s, err := schema.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &messageSchema{schema: s, skipped: getStringList(cfg, "dataone.message-schema-skipped-keys")}, nil
+}
+
+// check validates a message body against the schema unless validation is skipped for the routing key. A description
+// of every violation is returned, joined into a single string, along with true if the body is invalid.
+func (ms *messageSchema) check(key string, body []byte) (string, bool) {
+	for _, pattern := range ms.skipped {
+		if database.MatchRoutingKey(pattern, key) {
+			return "", false
+		}
+	}
+	violations := ms.schema.Validate(body)
+	if len(violations) == 0 {
+		return "", false
+	}
+	return strings.Join(violations, "; "), true
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// A message schema that requires the author of each message.
+const testMessageSchema = `{
+  "type": "object",
+  "required": ["author", "path"],
+  "properties": {"entity": {"type": "string"}, "path": {"type": "string", "pattern": "^/"}}
+}`
+
+// writeTestMessageSchema writes a message schema to a temporary directory, returning the path to the schema and the
+// directory, which the caller must remove.
+func writeTestMessageSchema(t *testing.T, contents string) (string, string) {
+	dir, err := ioutil.TempDir("", "message-schema")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory: %s", err)
+	}
+	path := filepath.Join(dir, "message.json")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unable to write the message schema: %s", err)
+	}
+	return path, dir
+}
+
+// TestGetMessageSchema verifies that the message schema is only loaded if one is configured, and that schemas that
+// can't be loaded produce errors.
+func TestGetMessageSchema(t *testing.T) {
+	valid, dir := writeTestMessageSchema(t, testMessageSchema)
+	defer os.RemoveAll(dir)
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := ioutil.WriteFile(invalid, []byte(`{"type": "text"}`), 0644); err != nil {
+		t.Fatalf("unable to write the message schema: %s", err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		expectNil bool
+		expectErr bool
+	}{
+		{"unset", "", true, false},
+		{"valid", valid, false, false},
+		{"invalid", invalid, true, true},
+		{"missing", filepath.Join(dir, "missing.json"), true, true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("dataone.message-schema", test.path)
+		ms, err := getMessageSchema(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+		}
+		if (ms == nil) != test.expectNil {
+			t.Errorf("%s: expected nil schema: %t, got: %+v", test.name, test.expectNil, ms)
+		}
+	}
+}
+
+// TestMessageSchemaValidation verifies that messages that don't match the message schema are rejected as invalid
+// messages that list every violation before they're decoded, and that messages with skipped routing keys aren't
+// validated.
+func TestMessageSchemaValidation(t *testing.T) {
+	path, dir := writeTestMessageSchema(t, testMessageSchema)
+	defer os.RemoveAll(dir)
+	cfg := viper.New()
+	cfg.Set("dataone.message-schema", path)
+	cfg.Set("dataone.message-schema-skipped-keys", []string{"data-object.metadata.*"})
+	ms, err := getMessageSchema(cfg)
+	if err != nil {
+		t.Fatalf("unable to load the message schema: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		key        string
+		body       []byte
+		violations []string
+	}{
+		{"valid", "data-object.open", testAuthorBody, nil},
+		{"skipped", "data-object.metadata.add", testBody, nil},
+		{
+			"invalid",
+			"data-object.open",
+			[]byte(`{"entity": 42, "path": "/iplant/home/shared/commons_repo/curated/foo.txt"}`),
+			[]string{"/: missing required property author", "/entity: expected string but got integer"},
+		},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{}
+		svc := newTestService(recorder)
+		svc.schema = ms
+		before := messageOutcomes.Get(test.key + "/" + outcomeSchemaViolation)
+
+		err := svc.processMessage(amqp.Delivery{RoutingKey: test.key, Body: test.body})
+		if test.violations == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: an error was expected but none was encountered", test.name)
+			continue
+		}
+		if shouldRequeue(err) || !shouldQuarantine(err) {
+			t.Errorf("%s: the error should be classified as an invalid message: %s", test.name, err)
+		}
+		if !strings.Contains(err.Error(), strings.Join(test.violations, "; ")) {
+			t.Errorf("%s: the error does not list the violations: %s", test.name, err)
+		}
+		if count := messageOutcomes.Get(test.key+"/"+outcomeSchemaViolation) - before; count != 1 {
+			t.Errorf("%s: expected 1 schema violation but got %d", test.name, count)
+		}
+		if events := atomic.LoadInt64(&recorder.events); events != 0 {
+			t.Errorf("%s: expected no recorded events but got %d", test.name, events)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package metrics
+
+import (
+	"expvar"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+)
+
+// Counters is a named set of counters that are published via expvar and included in periodic summaries. It's safe
+// for concurrent use by multiple goroutines.
+type Counters struct {
+	name        string
+	description string
+	values      *expvar.Map
+}
+
+// The registries of all counter sets and durations, used to generate summaries.
+var (
+	registryMutex     sync.Mutex
+	registry          []*Counters
+	durationsRegistry []*Durations
+)
+
+// NewCounters creates and registers a new set of counters. The name is used to publish the counters via expvar, so it
+// must be unique. The description is used in summary log messages.
+func NewCounters(name, description string) *Counters {
+	c := &Counters{
+		name:        name,
+		description: description,
+		values:      expvar.NewMap(name),
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry = append(registry, c)
+
+	return c
+}
+
+// Inc increments the counter with the given key.
+func (c *Counters) Inc(key string) {
+	c.values.Add(key, 1)
+}
+
+// Add adds a value to the counter with the given key.
+func (c *Counters) Add(key string, delta int64) {
+	c.values.Add(key, delta)
+}
+
+// Get returns the current value of the counter with the given key.
+func (c *Counters) Get(key string) int64 {
+	if v, ok := c.values.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// Snapshot returns the current values of all of the counters in the set.
+func (c *Counters) Snapshot() map[string]int64 {
+	result := make(map[string]int64)
+	c.values.Do(func(kv expvar.KeyValue) {
+		if v, ok := kv.Value.(*expvar.Int); ok {
+			result[kv.Key] = v.Value()
+		}
+	})
+	return result
+}
+
+// Delta returns the differences between two snapshots of a set of counters. Counters that haven't changed are
+// omitted.
+func Delta(previous, current map[string]int64) map[string]int64 {
+	result := make(map[string]int64)
+	for key, value := range current {
+		if d := value - previous[key]; d != 0 {
+			result[key] = d
+		}
+	}
+	return result
+}
+
+// Format formats a set of counter values for inclusion in a log message, sorted by key.
+func Format(values map[string]int64) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key + "=" + strconv.FormatInt(values[key], 10)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Durations keeps track of the most recent observations of a duration, such as the time that messages spend waiting to
+// be processed, so that percentiles can be reported. The percentiles are published via expvar in seconds and included
+// in periodic summaries. It's safe for concurrent use by multiple goroutines.
+type Durations struct {
+	mutex       sync.Mutex
+	name        string
+	description string
+	samples     []time.Duration
+	next        int
+	count       int64
+}
+
+// NewDurations creates and registers a new set of duration observations that retains the given number of the most
+// recent observations. The name is used to publish the percentiles via expvar, so it must be unique. The description
+// is used in summary log messages.
+func NewDurations(name, description string, size int) *Durations {
+	if size < 1 {
+		size = 1
+	}
+	d := &Durations{
+		name:        name,
+		description: description,
+		samples:     make([]time.Duration, 0, size),
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return d.seconds()
+	}))
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	durationsRegistry = append(durationsRegistry, d)
+
+	return d
+}
+
+// Observe records an observation, replacing the oldest observation if the maximum number of observations has been
+// reached.
+func (d *Durations) Observe(value time.Duration) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if len(d.samples) < cap(d.samples) {
+		d.samples = append(d.samples, value)
+	} else {
+		d.samples[d.next] = value
+		d.next = (d.next + 1) % len(d.samples)
+	}
+	d.count++
+}
+
+// Count returns the total number of observations that have been recorded.
+func (d *Durations) Count() int64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.count
+}
+
+// Percentiles returns the given percentiles of the retained observations, using the nearest-rank method. Zero is
+// returned for every percentile if there are no observations.
+func (d *Durations) Percentiles(percentiles ...float64) []time.Duration {
+	d.mutex.Lock()
+	sorted := append([]time.Duration(nil), d.samples...)
+	d.mutex.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	result := make([]time.Duration, len(percentiles))
+	if len(sorted) == 0 {
+		return result
+	}
+	for i, p := range percentiles {
+		rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		if rank >= len(sorted) {
+			rank = len(sorted) - 1
+		}
+		result[i] = sorted[rank]
+	}
+	return result
+}
+
+// The percentiles that are published and reported for each set of durations.
+var (
+	reportedPercentiles    = []float64{50, 95, 99, 100}
+	reportedPercentileKeys = []string{"p50", "p95", "p99", "max"}
+)
+
+// seconds returns the reported percentiles in seconds along with the number of observations, for publication via
+// expvar.
+func (d *Durations) seconds() map[string]interface{} {
+	result := map[string]interface{}{"count": d.Count()}
+	for i, value := range d.Percentiles(reportedPercentiles...) {
+		result[reportedPercentileKeys[i]] = value.Seconds()
+	}
+	return result
+}
+
+// Summary formats the reported percentiles for inclusion in a log message.
+func (d *Durations) Summary() string {
+	values := d.Percentiles(reportedPercentiles...)
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = reportedPercentileKeys[i] + "=" + value.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+// StartReporter starts a goroutine that logs the changes in every registered set of counters and the percentiles of
+// every registered set of durations at the given interval. Sets of counters and durations that haven't changed during
+// the interval are omitted. No summaries are logged if the interval is zero or negative.
+func StartReporter(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		previous := make(map[string]map[string]int64)
+		previousCounts := make(map[string]int64)
+		for range time.Tick(interval) {
+			registryMutex.Lock()
+			counters := append([]*Counters(nil), registry...)
+			durations := append([]*Durations(nil), durationsRegistry...)
+			registryMutex.Unlock()
+
+			for _, c := range counters {
+				current := c.Snapshot()
+				if delta := Delta(previous[c.name], current); len(delta) > 0 {
+					logger.Log.Infof("%s in the last %s: %s", c.description, interval, Format(delta))
+				}
+				previous[c.name] = current
+			}
+
+			for _, d := range durations {
+				count := d.Count()
+				if count != previousCounts[d.name] {
+					logger.Log.Infof("%s: %s", d.description, d.Summary())
+				}
+				previousCounts[d.name] = count
+			}
+		}
+	}()
+}
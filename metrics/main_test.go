@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestCounters verifies that counters can be incremented and retrieved.
+func TestCounters(t *testing.T) {
+	c := NewCounters("test_counters", "test counters")
+	c.Inc("foo")
+	c.Inc("foo")
+	c.Add("bar", 5)
+
+	if v := c.Get("foo"); v != 2 {
+		t.Errorf("expected foo to be 2 but got %d", v)
+	}
+	if v := c.Get("baz"); v != 0 {
+		t.Errorf("expected baz to be 0 but got %d", v)
+	}
+
+	expected := map[string]int64{"foo": 2, "bar": 5}
+	if actual := c.Snapshot(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v but got %v", expected, actual)
+	}
+}
+
+// TestDelta verifies that the differences between snapshots are computed correctly.
+func TestDelta(t *testing.T) {
+	previous := map[string]int64{"foo": 2, "bar": 5}
+	current := map[string]int64{"foo": 2, "bar": 7, "baz": 1}
+
+	expected := map[string]int64{"bar": 2, "baz": 1}
+	if actual := Delta(previous, current); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v but got %v", expected, actual)
+	}
+
+	// A missing previous snapshot should produce the current values.
+	if actual := Delta(nil, current); !reflect.DeepEqual(actual, current) {
+		t.Errorf("expected %v but got %v", current, actual)
+	}
+}
+
+// TestFormat verifies that counter values are formatted in a consistent order.
+func TestFormat(t *testing.T) {
+	actual := Format(map[string]int64{"foo": 2, "bar": 7})
+	if actual != "bar=7 foo=2" {
+		t.Errorf("unexpected formatted counters: %s", actual)
+	}
+}
+
+// TestDurations verifies that percentiles are computed over the most recent observations.
+func TestDurations(t *testing.T) {
+	d := NewDurations("test_durations", "test durations", 10)
+
+	// There are no observations yet.
+	if actual := d.Percentiles(50); actual[0] != 0 {
+		t.Errorf("expected 0 with no observations but got %s", actual[0])
+	}
+
+	// Record more observations than the set retains. Only 11s through 20s should be retained.
+	for i := 1; i <= 20; i++ {
+		d.Observe(time.Duration(i) * time.Second)
+	}
+	if count := d.Count(); count != 20 {
+		t.Errorf("expected 20 observations but got %d", count)
+	}
+
+	expected := []time.Duration{15 * time.Second, 20 * time.Second, 11 * time.Second}
+	if actual := d.Percentiles(50, 95, 0); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v but got %v", expected, actual)
+	}
+
+	// The summary should include each reported percentile.
+	if summary := d.Summary(); summary != "p50=15s p95=20s p99=20s max=20s" {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+}
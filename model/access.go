@@ -0,0 +1,19 @@
+package model
+
+import (
+	"strings"
+)
+
+// The access types that distinguish the ways in which data objects are read. Some clients send the same messages when
+// a user previews the beginning of a file as they do when the user downloads it, so messages may include the access
+// type. Messages without an access type are treated as downloads.
+const (
+	AccessTypeDownload = "download"
+	AccessTypePreview  = "preview"
+)
+
+// IsPreview determines whether or not the message describes a preview of a data object rather than a download. The
+// access type isn't case sensitive.
+func (msg *Message) IsPreview() bool {
+	return strings.EqualFold(strings.TrimSpace(msg.AccessType), AccessTypePreview)
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestAccessTypeVersions(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       []byte
+		accessType string
+		preview    bool
+	}{
+		{
+			"version 1 preview",
+			[]byte(`{"entity": "fakeid", "path": "/foo/bar", "accessType": "preview"}`),
+			AccessTypePreview,
+			true,
+		},
+		{
+			"version 1 download",
+			[]byte(`{"entity": "fakeid", "path": "/foo/bar", "accessType": "download"}`),
+			AccessTypeDownload,
+			false,
+		},
+		{
+			"version 1 without access type",
+			[]byte(`{"entity": "fakeid", "path": "/foo/bar"}`),
+			"",
+			false,
+		},
+		{
+			"version 2 preview",
+			[]byte(`{"version": 2, "entity": {"id": "fakeid", "path": "/foo/bar"}, "accessType": " Preview "}`),
+			" Preview ",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode(test.body)
+		if err != nil {
+			t.Errorf("%s: error encountered while decoding message: %s", test.name, err)
+			continue
+		}
+		if msg.AccessType != test.accessType {
+			t.Errorf("%s: expected access type %q but got %q", test.name, test.accessType, msg.AccessType)
+		}
+		if msg.IsPreview() != test.preview {
+			t.Errorf("%s: expected preview: %t", test.name, test.preview)
+		}
+	}
+}
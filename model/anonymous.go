@@ -0,0 +1,28 @@
+package model
+
+import (
+	"strings"
+)
+
+// AnonymousUsername is the name that iRODS reports for users who read public data objects without logging in.
+const AnonymousUsername = "anonymous"
+
+// PublicSubject is the DataONE subject that represents every user, including users who haven't logged in.
+const PublicSubject = "public"
+
+// NewAnonymousUser returns a user that stands in for the anonymous users who read public data objects. The subject
+// is used as the name of the user. Anonymous users don't belong to a zone, so the qualified name is the subject
+// alone.
+func NewAnonymousUser(subject string) *User {
+	return &User{Name: subject, Anonymous: true}
+}
+
+// IsAnonymous determines whether or not a user is anonymous. Messages describing reads of public data objects either
+// don't identify the user or identify the user as the anonymous user in some zone.
+func (u *User) IsAnonymous() bool {
+	if u == nil || u.Anonymous {
+		return true
+	}
+	name := strings.TrimSpace(u.Name)
+	return name == "" || strings.EqualFold(name, AnonymousUsername)
+}
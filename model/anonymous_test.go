@@ -0,0 +1,44 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestIsAnonymous(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     *User
+		expected bool
+	}{
+		{"missing", nil, true},
+		{"no name", &User{Zone: "iplant"}, true},
+		{"anonymous", &User{Name: "anonymous", Zone: "iplant"}, true},
+		{"upper case", &User{Name: " Anonymous ", Zone: "iplant"}, true},
+		{"substituted", NewAnonymousUser(PublicSubject), true},
+		{"authenticated", &User{Name: "ipcdev", Zone: "iplant"}, false},
+		{"public without substitution", &User{Name: "public", Zone: "iplant"}, false},
+	}
+
+	for _, test := range tests {
+		if actual := test.user.IsAnonymous(); actual != test.expected {
+			t.Errorf("%s: expected anonymous: %t, got: %t", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestAnonymousUserValidation(t *testing.T) {
+	user := NewAnonymousUser(PublicSubject)
+	if user.String() != "public" {
+		t.Errorf("unexpected qualified name: %s", user.String())
+	}
+
+	msg := &Message{Author: user, Entity: "fakeid", Path: "/foo/bar"}
+	if err := msg.Validate(Requirements{Author: true}); err != nil {
+		t.Errorf("reads by anonymous users should be accepted: %s", err)
+	}
+
+	msg.Author = NewAnonymousUser("")
+	if err := msg.Validate(Requirements{Author: true}); err == nil {
+		t.Error("anonymous users without subjects should be rejected")
+	}
+}
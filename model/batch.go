@@ -0,0 +1,73 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// batchProbe contains the field that identifies batch messages, which describe the same event for several data
+// objects at once, as when a user downloads several files together. Each element of the entities array describes one
+// data object. In version 1 of the message format, the elements have the same entity, path, size and checksum fields
+// that single messages have at the top level. In version 2, the elements have the same form as the entity object.
+type batchProbe struct {
+	Entities []json.RawMessage `json:"entities"`
+}
+
+// version1BatchEntity describes a data object in a version 1 batch message.
+type version1BatchEntity struct {
+	Entity   string `json:"entity"`
+	Path     string `json:"path"`
+	Size     *int64 `json:"size,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// decodeBatch expands a batch message into a message for each data object in the batch, which shares every field but
+// the ones that describe the data object with the batch message. The messages are stored in the batch message. Nothing
+// is changed if the message isn't a batch message, and an error is returned if a batch message contains no data
+// objects or one of its data objects can't be decoded.
+func decodeBatch(version int, body []byte, msg *Message) error {
+	var probe batchProbe
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return err
+	}
+	if probe.Entities == nil {
+		return nil
+	}
+	if len(probe.Entities) == 0 {
+		return fmt.Errorf("the batch message doesn't contain any data objects")
+	}
+
+	msg.Batch = make([]*Message, len(probe.Entities))
+	for i, raw := range probe.Entities {
+		part := *msg
+		part.Batch = nil
+		part.Attributes = append([]string(nil), msg.Attributes...)
+		part.Entity, part.Path, part.Size, part.Checksum, part.Source = "", "", nil, "", ""
+		if version == Version1 {
+			var entity version1BatchEntity
+			if err := json.Unmarshal(raw, &entity); err != nil {
+				return fmt.Errorf("unable to decode data object %d of the batch: %s", i+1, err)
+			}
+			part.Entity, part.Path, part.Size, part.Checksum = entity.Entity, entity.Path, entity.Size, entity.Checksum
+		} else {
+			var entity version2Entity
+			if err := json.Unmarshal(raw, &entity); err != nil {
+				return fmt.Errorf("unable to decode data object %d of the batch: %s", i+1, err)
+			}
+			part.Entity, part.Path, part.Size, part.Checksum = entity.ID, entity.Path, entity.Size, entity.Checksum
+			part.Source = entity.Source
+		}
+		part.canonicalize()
+		msg.Batch[i] = &part
+	}
+	return nil
+}
+
+// Expand returns the messages describing each of the events that a message describes. This is the message itself
+// unless it's a batch message.
+func (msg *Message) Expand() []*Message {
+	if len(msg.Batch) > 0 {
+		return msg.Batch
+	}
+	return []*Message{msg}
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"testing"
+)
+
+// Batch message fixtures for each version of the message format. Each of them describes reads of the same two data
+// objects.
+var (
+	version1BatchFixture = []byte(`{
+  "author": {"name": "ipcdev", "zone": "iplant"},
+  "entities": [
+    {"entity": "5b6c32b6-7c45-11e8-a0b0-008cfa5ae621", "path": "/iplant/home/shared/commons_repo/curated//a.csv"},
+    {"entity": "6c7d43c7-7c45-11e8-a0b0-008cfa5ae621", "path": "/iplant/home/ipcdev/b.csv", "size": 42}
+  ],
+  "ipAddress": "192.0.2.1",
+  "timestamp": "2018-07-04.10:20:12"
+}`)
+	version2BatchFixture = []byte(`{
+  "version": 2,
+  "author": {"username": "ipcdev", "zone": "iplant"},
+  "entities": [
+    {"id": "5b6c32b6-7c45-11e8-a0b0-008cfa5ae621", "path": "/iplant/home/shared/commons_repo/curated//a.csv"},
+    {"id": "6c7d43c7-7c45-11e8-a0b0-008cfa5ae621", "path": "/iplant/home/ipcdev/b.csv", "size": 42}
+  ],
+  "ipAddress": "192.0.2.1",
+  "timestamp": "2018-07-04.10:20:12"
+}`)
+)
+
+func TestBatchVersions(t *testing.T) {
+	for name, body := range map[string][]byte{"version 1": version1BatchFixture, "version 2": version2BatchFixture} {
+		msg, err := Decode(body)
+		if err != nil {
+			t.Fatalf("%s: unable to decode the message: %s", name, err)
+		}
+		parts := msg.Expand()
+		if len(parts) != 2 || len(msg.Batch) != 2 {
+			t.Fatalf("%s: expected 2 messages in the batch but got %d", name, len(parts))
+		}
+
+		expectedPaths := []string{"/iplant/home/shared/commons_repo/curated/a.csv", "/iplant/home/ipcdev/b.csv"}
+		for i, part := range parts {
+			if part.Path != expectedPaths[i] {
+				t.Errorf("%s: expected path %s but got %s", name, expectedPaths[i], part.Path)
+			}
+			if part.Author == nil || part.Author.Name != "ipcdev" || part.IPAddress != "192.0.2.1" {
+				t.Errorf("%s: the shared fields weren't copied: %+v", name, part)
+			}
+			if part.Timestamp == nil || !sameTimestamp(part.Timestamp, msg.Timestamp) {
+				t.Errorf("%s: expected timestamp %v but got %v", name, msg.Timestamp, part.Timestamp)
+			}
+			if part.UUID == "" || len(part.Batch) != 0 || part.Version != msg.Version {
+				t.Errorf("%s: unexpected message in the batch: %+v", name, part)
+			}
+		}
+		if parts[0].Size != nil || parts[1].Size == nil || *parts[1].Size != 42 {
+			t.Errorf("%s: unexpected sizes: %v, %v", name, parts[0].Size, parts[1].Size)
+		}
+	}
+}
+
+func TestSingleMessageExpansion(t *testing.T) {
+	msg, err := Decode(version1Fixture)
+	if err != nil {
+		t.Fatalf("unable to decode the message: %s", err)
+	}
+	if parts := msg.Expand(); len(msg.Batch) != 0 || len(parts) != 1 || parts[0] != msg {
+		t.Errorf("expected the message to expand to itself but got %v", parts)
+	}
+}
+
+func TestInvalidBatches(t *testing.T) {
+	bodies := map[string][]byte{
+		"empty":            []byte(`{"author": {"name": "ipcdev", "zone": "iplant"}, "entities": []}`),
+		"not objects":      []byte(`{"author": {"name": "ipcdev", "zone": "iplant"}, "entities": ["/iplant/home"]}`),
+		"not an array":     []byte(`{"author": {"name": "ipcdev", "zone": "iplant"}, "entities": {"path": "/a"}}`),
+		"mistyped version": []byte(`{"version": 2, "entities": [{"id": "fakeid", "path": 42}]}`),
+	}
+	for name, body := range bodies {
+		if _, err := Decode(body); err == nil {
+			t.Errorf("%s: expected an error but the message was decoded", name)
+		}
+	}
+}
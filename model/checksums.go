@@ -0,0 +1,51 @@
+package model
+
+import (
+	"strings"
+)
+
+// The names of the checksum algorithms that iRODS uses, in the form used by DataONE.
+const (
+	ChecksumMD5    = "MD5"
+	ChecksumSHA1   = "SHA-1"
+	ChecksumSHA256 = "SHA-256"
+	ChecksumSHA512 = "SHA-512"
+)
+
+// checksumPrefixes maps the prefixes that iRODS adds to checksums to the algorithms that produced them.
+var checksumPrefixes = map[string]string{
+	"md5":    ChecksumMD5,
+	"sha1":   ChecksumSHA1,
+	"sha2":   ChecksumSHA256,
+	"sha256": ChecksumSHA256,
+	"sha512": ChecksumSHA512,
+}
+
+// ParseChecksum splits a checksum in the form that iRODS reports it into the algorithm and the value. iRODS reports
+// MD5 checksums without a prefix and prefixes other checksums with the algorithm, as in sha2:value. Prefixes that
+// aren't recognized are returned verbatim as the algorithm. Empty checksums have no algorithm.
+func ParseChecksum(checksum string) (string, string) {
+	if checksum == "" {
+		return "", ""
+	}
+	i := strings.Index(checksum, ":")
+	if i <= 0 {
+		return ChecksumMD5, checksum
+	}
+	prefix, value := checksum[:i], checksum[i+1:]
+	if algorithm, ok := checksumPrefixes[strings.ToLower(prefix)]; ok {
+		return algorithm, value
+	}
+	return prefix, value
+}
+
+// IsKnownChecksumAlgorithm determines whether or not a checksum algorithm returned by ParseChecksum is one that iRODS
+// is known to use.
+func IsKnownChecksumAlgorithm(algorithm string) bool {
+	for _, known := range checksumPrefixes {
+		if algorithm == known {
+			return true
+		}
+	}
+	return false
+}
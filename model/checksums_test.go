@@ -0,0 +1,46 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestParseChecksum(t *testing.T) {
+	tests := []struct {
+		checksum  string
+		algorithm string
+		digest    string
+		known     bool
+	}{
+		{"d41d8cd98f00b204e9800998ecf8427e", ChecksumMD5, "d41d8cd98f00b204e9800998ecf8427e", true},
+		{"sha2:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", ChecksumSHA256,
+			"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", true},
+		{"SHA512:fakedigest", ChecksumSHA512, "fakedigest", true},
+		{"blake3:fakedigest", "blake3", "fakedigest", false},
+		{":fakedigest", ChecksumMD5, ":fakedigest", true},
+	}
+
+	for _, test := range tests {
+		algorithm, digest := ParseChecksum(test.checksum)
+		if algorithm != test.algorithm || digest != test.digest {
+			t.Errorf("%s: expected %s and %s but got %s and %s", test.checksum, test.algorithm, test.digest,
+				algorithm, digest)
+		}
+		if known := IsKnownChecksumAlgorithm(algorithm); known != test.known {
+			t.Errorf("%s: expected known to be %t but got %t", test.checksum, test.known, known)
+		}
+	}
+
+	if algorithm, digest := ParseChecksum(""); algorithm != "" || digest != "" {
+		t.Errorf("expected no algorithm or digest for an empty checksum but got %s and %s", algorithm, digest)
+	}
+}
+
+func TestDecodeChecksum(t *testing.T) {
+	msg, err := Decode([]byte(`{"entity": "fakeid", "path": "/foo/bar", "checksum": "sha2:fakedigest"}`))
+	if err != nil {
+		t.Fatalf("error encountered while decoding message: %s", err)
+	}
+	if msg.Checksum != "sha2:fakedigest" || msg.Algorithm != ChecksumSHA256 || msg.Digest != "fakedigest" {
+		t.Errorf("unexpected checksum: %s (%s, %s)", msg.Checksum, msg.Algorithm, msg.Digest)
+	}
+}
@@ -0,0 +1,77 @@
+package model
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// ContentTypeJSON is the content type of messages serialized as JSON, which are decoded by Decode. Messages without a
+// content type are assumed to be serialized as JSON.
+const ContentTypeJSON = "application/json"
+
+// Decoder converts a serialized message to a structure.
+type Decoder func(body []byte) (*Message, error)
+
+// The decoders for each supported content type, keyed by media type.
+var (
+	decodersMutex   sync.RWMutex
+	contentDecoders = map[string]Decoder{
+		ContentTypeJSON: Decode,
+	}
+)
+
+// UnsupportedContentTypeError is returned for messages whose content type has no decoder.
+type UnsupportedContentTypeError struct {
+	ContentType string
+}
+
+// Error returns the error message.
+func (e *UnsupportedContentTypeError) Error() string {
+	return fmt.Sprintf("unsupported content type: %s", e.ContentType)
+}
+
+// MediaType returns the media type in a content type, in lower case and without any parameters, so that content
+// types such as "application/json; charset=utf-8" select the same decoder as "application/json". An empty content
+// type is treated as JSON.
+func MediaType(contentType string) string {
+	if strings.TrimSpace(contentType) == "" {
+		return ContentTypeJSON
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+// RegisterDecoder registers the decoder used for messages with the given content type, replacing any decoder that's
+// already registered for its media type.
+func RegisterDecoder(contentType string, decoder Decoder) {
+	decodersMutex.Lock()
+	defer decodersMutex.Unlock()
+	contentDecoders[MediaType(contentType)] = decoder
+}
+
+// DecoderFor returns the decoder for messages with the given content type. Messages with content types that have no
+// decoder produce an *UnsupportedContentTypeError.
+func DecoderFor(contentType string) (Decoder, error) {
+	decodersMutex.RLock()
+	defer decodersMutex.RUnlock()
+	decoder, ok := contentDecoders[MediaType(contentType)]
+	if !ok {
+		return nil, &UnsupportedContentTypeError{ContentType: contentType}
+	}
+	return decoder, nil
+}
+
+// DecodeContent converts a serialized message with the given content type to a structure using the decoder registered
+// for the content type.
+func DecodeContent(contentType string, body []byte) (*Message, error) {
+	decoder, err := DecoderFor(contentType)
+	if err != nil {
+		return nil, err
+	}
+	return decoder(body)
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestMediaType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    string
+	}{
+		{"", ContentTypeJSON},
+		{"  ", ContentTypeJSON},
+		{"application/json", ContentTypeJSON},
+		{"Application/JSON; charset=utf-8", ContentTypeJSON},
+		{"application/x-protobuf", "application/x-protobuf"},
+		{"not a media type;", "not a media type;"},
+	}
+
+	for _, test := range tests {
+		if actual := MediaType(test.contentType); actual != test.expected {
+			t.Errorf("%q: expected %s but got %s", test.contentType, test.expected, actual)
+		}
+	}
+}
+
+func TestDecodeContent(t *testing.T) {
+	body := []byte(`{"entity": "fakeid", "path": "/foo/bar"}`)
+	for _, contentType := range []string{"", "application/json", "application/json; charset=utf-8"} {
+		msg, err := DecodeContent(contentType, body)
+		if err != nil {
+			t.Errorf("%q: error encountered while decoding message: %s", contentType, err)
+			continue
+		}
+		if msg.Entity != "fakeid" || msg.Path != "/foo/bar" {
+			t.Errorf("%q: unexpected message: %+v", contentType, msg)
+		}
+	}
+
+	_, err := DecodeContent("application/x-protobuf", body)
+	if e, ok := err.(*UnsupportedContentTypeError); !ok || e.ContentType != "application/x-protobuf" {
+		t.Errorf("expected an unsupported content type error but got %v", err)
+	}
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	const contentType = "application/x-test"
+	RegisterDecoder(contentType, func(body []byte) (*Message, error) {
+		return &Message{Entity: string(body)}, nil
+	})
+	defer func() {
+		decodersMutex.Lock()
+		delete(contentDecoders, contentType)
+		decodersMutex.Unlock()
+	}()
+
+	msg, err := DecodeContent("application/x-test; version=1", []byte("fakeid"))
+	if err != nil {
+		t.Fatalf("error encountered while decoding message: %s", err)
+	}
+	if msg.Entity != "fakeid" {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+}
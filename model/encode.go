@@ -0,0 +1,72 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Encode converts a message to serialized JSON that Decode converts back to the same message. Messages are encoded in
+// version 2 of the message format, which is the only version that can describe every field, and the version field is
+// always included. The paths, the permission level and the replication and synchronization fields are written in
+// canonical form. Fields that aren't part of the serialized message, such as the message ID and the fields that Decode
+// derives from other fields, aren't encoded.
+func Encode(msg *Message) ([]byte, error) {
+	v2 := version2Message{
+		Version: Version2,
+		Entity: &version2Entity{
+			ID:       msg.Entity,
+			Path:     CanonicalPath(msg.Path),
+			Size:     msg.Size,
+			Checksum: msg.Checksum,
+			Source:   CanonicalPath(msg.Source),
+		},
+		Timestamp:  msg.Timestamp,
+		IPAddress:  msg.IPAddress,
+		UserAgent:  msg.UserAgent,
+		AccessType: msg.AccessType,
+		BytesRead:  msg.BytesRead,
+		Range:      msg.Range,
+		Zone:       msg.Zone,
+		Resource:   msg.Resource,
+
+		TargetNode:        strings.TrimSpace(msg.TargetNode),
+		ReplicationStatus: CanonicalReplicationStatus(msg.ReplicationStatus),
+		FailureReason:     msg.FailureReason,
+		SyncStatus:        CanonicalSynchronizationStatus(msg.SyncStatus),
+	}
+	if msg.Author != nil {
+		v2.Author = &version2User{Username: msg.Author.Name, Zone: msg.Author.Zone}
+	}
+	if msg.ChangesPermission() {
+		v2.Permission = &version2Permission{Level: CanonicalPermission(msg.Permission)}
+		if msg.Grantee != nil {
+			v2.Permission.User = &version2User{Username: msg.Grantee.Name, Zone: msg.Grantee.Zone}
+		}
+	}
+	for _, attribute := range attributeNames(msg.Attributes) {
+		v2.Metadata = append(v2.Metadata, version2Metadatum{Attribute: attribute})
+	}
+	return json.Marshal(v2)
+}
+
+// newMessage returns a message describing an event for a data object that occurred at the given time.
+func newMessage(author *User, entity, path string, t time.Time) *Message {
+	t = t.UTC()
+	return &Message{Author: author, Entity: entity, Path: CanonicalPath(path), Timestamp: (*Timestamp)(&t)}
+}
+
+// NewReadMessage returns a message describing a read of a data object. The IP address and user agent of the client
+// and the access type may be set in the returned message if they're known.
+func NewReadMessage(author *User, entity, path string, t time.Time) *Message {
+	return newMessage(author, entity, path, t)
+}
+
+// NewAddMessage returns a message describing the addition of a data object with the given size and checksum. The
+// checksum is in the form that iRODS reports it, and may be empty if it isn't known.
+func NewAddMessage(author *User, entity, path string, size int64, checksum string, t time.Time) *Message {
+	msg := newMessage(author, entity, path, t)
+	msg.Size = &size
+	msg.Checksum = checksum
+	return msg
+}
@@ -0,0 +1,222 @@
+package model
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+type randomMessage struct {
+	msg *Message
+}
+
+func randomString(r *rand.Rand) string {
+	const alphabet = `abcXYZ019 -_.#"\é/{}`
+	runes := []rune(alphabet)
+	n := r.Intn(8)
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteRune(runes[r.Intn(len(runes))])
+	}
+	return b.String()
+}
+
+func randomPath(r *rand.Rand) string {
+	n := r.Intn(4)
+	if n == 0 {
+		return ""
+	}
+	segments := make([]string, n)
+	for i := range segments {
+		segments[i] = "seg" + strings.Repeat("x", r.Intn(3)) + string(rune('a'+r.Intn(26)))
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+func randomUser(r *rand.Rand) *User {
+	if r.Intn(3) == 0 {
+		return nil
+	}
+	return &User{Name: randomString(r), Zone: randomString(r)}
+}
+
+func (randomMessage) Generate(r *rand.Rand, size int) reflect.Value {
+	msg := &Message{
+		Author:    randomUser(r),
+		Entity:    randomString(r),
+		Path:      randomPath(r),
+		Checksum:  randomString(r),
+		Source:    randomPath(r),
+		IPAddress: randomString(r),
+		UserAgent: randomString(r),
+		Zone:      randomString(r),
+		Resource:  randomString(r),
+	}
+	if r.Intn(2) == 0 {
+		msg.AccessType = []string{AccessTypeDownload, AccessTypePreview, randomString(r)}[r.Intn(3)]
+	}
+	if r.Intn(2) == 0 {
+		bytesRead := r.Int63()
+		msg.BytesRead = &bytesRead
+	}
+	if r.Intn(2) == 0 {
+		msg.Range = []string{"bytes=0-65535", "bytes=-512", randomString(r)}[r.Intn(3)]
+	}
+	if r.Intn(2) == 0 {
+		t := time.Unix(r.Int63n(4102444800), r.Int63n(int64(time.Second))).UTC()
+		msg.Timestamp = (*Timestamp)(&t)
+	}
+	if r.Intn(2) == 0 {
+		size := r.Int63()
+		msg.Size = &size
+	}
+	if r.Intn(2) == 0 {
+		statuses := []string{ReplicationCompleted, ReplicationFailed, ReplicationQueued}
+		msg.TargetNode = strings.TrimSpace("urn:node:" + randomString(r))
+		msg.ReplicationStatus = statuses[r.Intn(len(statuses))]
+		msg.FailureReason = randomString(r)
+	}
+	if r.Intn(2) == 0 {
+		msg.SyncStatus = []string{SynchronizationFailed, SynchronizationCompleted}[r.Intn(2)]
+	}
+	if r.Intn(2) == 0 {
+		levels := []string{PermissionNull, PermissionRead, PermissionWrite, PermissionOwn}
+		msg.Grantee = randomUser(r)
+		msg.Permission = levels[r.Intn(len(levels))]
+	}
+	for i := r.Intn(3); i > 0; i-- {
+		msg.Attributes = append(msg.Attributes, randomString(r))
+	}
+	return reflect.ValueOf(randomMessage{msg: msg})
+}
+
+func sameTimestamp(a, b *Timestamp) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return time.Time(*a).Equal(time.Time(*b))
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	roundTrips := func(rm randomMessage) bool {
+		body, err := Encode(rm.msg)
+		if err != nil {
+			t.Logf("unable to encode %+v: %s", rm.msg, err)
+			return false
+		}
+		decoded, err := Decode(body)
+		if err != nil {
+			t.Logf("unable to decode %s: %s", body, err)
+			return false
+		}
+		expected, actual := *rm.msg, *decoded
+		if !sameTimestamp(expected.Timestamp, actual.Timestamp) {
+			t.Logf("expected timestamp %v but got %v (%s)", expected.Timestamp, actual.Timestamp, body)
+			return false
+		}
+		expected.Timestamp, actual.Timestamp = nil, nil
+		expected.Attributes = attributeNames(expected.Attributes)
+		expected.UUID = ParseUUID(expected.Entity)
+		expected.Algorithm, expected.Digest = ParseChecksum(expected.Checksum)
+		expected.Version, expected.Raw = Version2, body
+		if rm.msg.Timestamp != nil {
+			expected.TimestampFormat = TimestampReference
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Logf("expected %+v but got %+v (%s)", expected, actual, body)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(roundTrips, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEncodeIsStable(t *testing.T) {
+	reencodes := func(rm randomMessage) bool {
+		first, err := Encode(rm.msg)
+		if err != nil {
+			return false
+		}
+		decoded, err := Decode(first)
+		if err != nil {
+			return false
+		}
+		second, err := Encode(decoded)
+		return err == nil && string(first) == string(second)
+	}
+	if err := quick.Check(reencodes, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEncodeCanonicalizes(t *testing.T) {
+	body, err := Encode(&Message{Path: "/foo//bar/", Source: "/foo/./baz", Permission: "modify object"})
+	if err != nil {
+		t.Fatalf("unable to encode the message: %s", err)
+	}
+	msg, err := Decode(body)
+	if err != nil {
+		t.Fatalf("unable to decode %s: %s", body, err)
+	}
+	if msg.Path != "/foo/bar" || msg.Source != "/foo/baz" || msg.Permission != PermissionWrite {
+		t.Errorf("expected canonical fields but got %+v", msg)
+	}
+}
+
+func TestTimestampMarshalJSON(t *testing.T) {
+	tests := []struct {
+		t        time.Time
+		expected string
+	}{
+		{time.Date(2017, 10, 6, 15, 7, 37, 0, time.UTC), `"2017-10-06.15:07:37"`},
+		{time.Date(2017, 10, 6, 15, 7, 37, 250000000, time.UTC), `"2017-10-06.15:07:37.25"`},
+		{time.Date(2017, 10, 6, 8, 7, 37, 0, time.FixedZone("MST", -7*60*60)), `"2017-10-06.15:07:37"`},
+	}
+
+	for _, test := range tests {
+		body, err := Timestamp(test.t).MarshalJSON()
+		if err != nil {
+			t.Errorf("unable to encode %s: %s", test.t, err)
+		} else if string(body) != test.expected {
+			t.Errorf("expected %s but got %s", test.expected, body)
+		}
+	}
+}
+
+func TestMessageConstructors(t *testing.T) {
+	author := &User{Name: "nobody", Zone: "nowhere"}
+	when := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	read := NewReadMessage(author, "fakeid", "/foo//bar", when)
+	read.IPAddress = "192.0.2.1"
+	add := NewAddMessage(author, "fakeid", "/foo/bar", 42, "sha2:abc", when)
+
+	for name, msg := range map[string]*Message{"read": read, "add": add} {
+		body, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("%s: unable to encode the message: %s", name, err)
+		}
+		decoded, err := Decode(body)
+		if err != nil {
+			t.Fatalf("%s: unable to decode %s: %s", name, body, err)
+		}
+		if err := decoded.Validate(Requirements{Author: true}); err != nil {
+			t.Errorf("%s: the decoded message is invalid: %s", name, err)
+		}
+		if decoded.Path != "/foo/bar" || !sameTimestamp(decoded.Timestamp, (*Timestamp)(&when)) {
+			t.Errorf("%s: unexpected path or timestamp: %s", name, body)
+		}
+	}
+
+	if read.Size != nil || read.IPAddress != "192.0.2.1" {
+		t.Errorf("unexpected read message: %+v", read)
+	}
+	if add.Size == nil || *add.Size != 42 || add.Checksum != "sha2:abc" {
+		t.Errorf("unexpected add message: %+v", add)
+	}
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+)
+
+// uuidDigits matches the digits of a UUID: 32 hexadecimal digits, optionally split into groups of 8, 4, 4, 4 and 12
+// digits by hyphens.
+const uuidDigits = `[0-9a-f]{8}(-?[0-9a-f]{4}){3}-?[0-9a-f]{12}`
+
+// uuidPattern matches the forms in which UUIDs are written, which may also be enclosed in braces.
+var uuidPattern = regexp.MustCompile(`(?i)^(` + uuidDigits + `|\{` + uuidDigits + `\})$`)
+
+// ParseUUID returns the canonical form of an entity identifier that's a UUID, which is the lower case form with the
+// digits split into groups by hyphens. iRODS assigns a UUID to each data object that doesn't change when the object is
+// moved or renamed, but UUIDs may be written in different forms, so the canonical form is used to compare them. An
+// empty string is returned if the identifier isn't a UUID.
+func ParseUUID(entity string) string {
+	if !uuidPattern.MatchString(entity) {
+		return ""
+	}
+	digits := strings.ToLower(strings.Trim(strings.Replace(entity, "-", "", -1), "{}"))
+	return digits[:8] + "-" + digits[8:12] + "-" + digits[12:16] + "-" + digits[16:20] + "-" + digits[20:]
+}
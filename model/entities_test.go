@@ -0,0 +1,53 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestParseUUID(t *testing.T) {
+	const canonical = "f3579bf9-284b-4b3c-841b-f6e87d3f78ea"
+	tests := []struct {
+		entity   string
+		expected string
+	}{
+		{canonical, canonical},
+		{"F3579BF9-284B-4B3C-841B-F6E87D3F78EA", canonical},
+		{"f3579bf9284b4b3c841bf6e87d3f78ea", canonical},
+		{"{F3579BF9-284B-4B3C-841B-F6E87D3F78EA}", canonical},
+		{"{f3579bf9-284b-4b3c-841b-f6e87d3f78ea", ""},
+		{"f3579bf9-284b-4b3c-841b-f6e87d3f78e", ""},
+		{"g3579bf9-284b-4b3c-841b-f6e87d3f78ea", ""},
+		{"fakeid", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if actual := ParseUUID(test.entity); actual != test.expected {
+			t.Errorf("%s: expected %q but got %q", test.entity, test.expected, actual)
+		}
+	}
+}
+
+func TestDecodeUUID(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{`{"entity": "F3579BF9-284B-4B3C-841B-F6E87D3F78EA", "path": "/foo/bar"}`,
+			"f3579bf9-284b-4b3c-841b-f6e87d3f78ea"},
+		{`{"entity": {"id": "F3579BF9-284B-4B3C-841B-F6E87D3F78EA", "path": "/foo/bar"}}`,
+			"f3579bf9-284b-4b3c-841b-f6e87d3f78ea"},
+		{`{"entity": "fakeid", "path": "/foo/bar"}`, ""},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode([]byte(test.body))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.body, err)
+			continue
+		}
+		if msg.UUID != test.expected {
+			t.Errorf("%s: expected UUID %q but got %q", test.body, test.expected, msg.UUID)
+		}
+	}
+}
@@ -1,14 +1,34 @@
 package model
 
 import (
-	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
 	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
 )
 
-// User represents an iRODS qualified username.
+// User represents an iRODS qualified username. Anonymous users are the stand-ins for users who read public data
+// objects without logging in, which the service substitutes for the users in the messages; they aren't part of the
+// serialized message.
 type User struct {
-	Name string `json:"name"`
-	Zone string `json:"zone"`
+	Name      string `json:"name"`
+	Zone      string `json:"zone"`
+	Anonymous bool   `json:"-"`
+}
+
+// String returns the qualified username in the form used by iRODS, or an empty string if there's no user. Anonymous
+// users don't have zones, so their names are returned alone.
+func (u *User) String() string {
+	if u == nil || u.Name == "" {
+		return ""
+	}
+	if u.Anonymous {
+		return u.Name
+	}
+	return u.Name + "#" + u.Zone
 }
 
 // Timestamp represents the time an event occurred.
@@ -23,7 +43,9 @@ func CurrentTimestamp() *Timestamp {
 	return (*Timestamp)(&t)
 }
 
-// UnmarshalJSON converts serialized JSON to a structure.
+// UnmarshalJSON converts serialized JSON to a structure. Timestamps in any of the formats accepted by ParseTimestamp
+// are converted. Timestamps that can't be parsed are left unchanged rather than being reported as errors, so that the
+// rest of the message can still be decoded.
 func (ts *Timestamp) UnmarshalJSON(value []byte) error {
 
 	// Handle the null constant.
@@ -32,9 +54,17 @@ func (ts *Timestamp) UnmarshalJSON(value []byte) error {
 	}
 
 	// Parse the timestamp.
-	t, err := time.Parse(ReferenceTime, string(value))
-	*ts = Timestamp(t)
-	return err
+	if t, _, ok := ParseTimestamp(value); ok {
+		*ts = Timestamp(t)
+	}
+	return nil
+}
+
+// MarshalJSON converts a timestamp to serialized JSON in the reference format, in UTC. Fractional seconds are included
+// if the timestamp has any so that the timestamp is parsed into exactly the same time.
+func (ts Timestamp) MarshalJSON() ([]byte, error) {
+	layout := ReferenceTime[:len(ReferenceTime)-1] + ".999999999\""
+	return []byte(time.Time(ts).UTC().Format(layout)), nil
 }
 
 // ToTime conversts a timestamp to a time pointer.
@@ -42,19 +72,233 @@ func (ts *Timestamp) ToTime() *time.Time {
 	return (*time.Time)(ts)
 }
 
-// Message represents an event message sent from iRODS.
+// Message represents an event message sent from iRODS. The field names are the ones used by version 1 of the message
+// format, and fields tagged with "-" aren't part of the serialized message.
 type Message struct {
-	Author    *User      `json:"author"`
-	Entity    string     `json:"entity"`
-	Path      string     `json:"path"`
-	Timestamp *Timestamp `json:"timestamp,omitempty"`
+	Author *User  `json:"author"`
+	Entity string `json:"entity"`
+
+	// UUID is the canonical form of the entity identifier if it's a UUID, which it usually is since iRODS assigns it.
+	UUID string `json:"-"`
+
+	// Path is the path of the data object, or the destination of a move. Messages relayed from the coordinating node
+	// identify the data object by its permanent identifier and may omit the path.
+	Path string `json:"path"`
+
+	// Timestamp is the time of the event, and TimestampFormat is the format in which it was written, if it's present.
+	// TimestampReplaced is true if the timestamp was replaced with the time at which the message was received, which
+	// differs for each copy of the message.
+	Timestamp         *Timestamp `json:"timestamp,omitempty"`
+	TimestampFormat   string     `json:"-"`
+	TimestampReplaced bool       `json:"-"`
+
+	// Size and Checksum are only included in some messages, such as the ones sent when data objects are added or
+	// modified; the size is nil and the checksum is empty if they're absent. The checksum is retained in the form that
+	// iRODS reports it, and Algorithm and Digest are taken from it when the message is decoded.
+	Size      *int64 `json:"size,omitempty"`
+	Checksum  string `json:"checksum,omitempty"`
+	Algorithm string `json:"-"`
+	Digest    string `json:"-"`
+
+	// Source is the source of a move or rename.
+	Source string `json:"old-path,omitempty"`
+
+	// Grantee and Permission are the user or group whose permission changed and the new permission level.
+	Grantee    *User  `json:"user,omitempty"`
+	Permission string `json:"permission,omitempty"`
+
+	// Attributes lists the names of the attributes that changed in a metadata change, if they're known.
+	Attributes []string `json:"-"`
+
+	// IPAddress and UserAgent describe the client that read a data object; they're empty if they're absent. AccessType
+	// distinguishes previews from downloads, and partial reads may include BytesRead or the requested HTTP byte Range.
+	IPAddress  string `json:"ipAddress,omitempty"`
+	UserAgent  string `json:"userAgent,omitempty"`
+	AccessType string `json:"accessType,omitempty"`
+	BytesRead  *int64 `json:"bytesRead,omitempty"`
+	Range      string `json:"range,omitempty"`
+
+	// Zone and Resource are the iRODS zone in which the event occurred and the resource that holds the data object.
+	Zone     string `json:"zone,omitempty"`
+	Resource string `json:"resource,omitempty"`
+
+	// TargetNode and ReplicationStatus describe a replication to another member node. FailureReason describes a failed
+	// replication or synchronization.
+	TargetNode        string `json:"targetNode,omitempty"`
+	ReplicationStatus string `json:"replicationStatus,omitempty"`
+	FailureReason     string `json:"failureReason,omitempty"`
+
+	// SyncStatus is the status of a synchronization reported by the coordinating node.
+	SyncStatus string `json:"synchronizationStatus,omitempty"`
+
+	// Version is the version of the format in which the message was serialized, and Raw is the serialized message,
+	// which is stored alongside the recorded event.
+	Version int    `json:"-"`
+	Raw     []byte `json:"-"`
+
+	// MessageID is the identifier assigned by the publisher, if any.
+	MessageID string `json:"-"`
+
+	// NodeID is the member node under which the event is recorded if it isn't the recorder's default node.
+	NodeID string `json:"-"`
+
+	// Batch contains a message for each data object in a batch message, which describes the same event for several
+	// data objects.
+	Batch []*Message `json:"-"`
+
+	// InRepository and SourceInRepository record whether or not the path and the source are in the repository.
+	InRepository       bool `json:"-"`
+	SourceInRepository bool `json:"-"`
 }
 
-// Decode converts a serialized JSON message to a structure.
+// Decode converts a serialized JSON message to a structure. Messages in every supported version of the message format
+// are decoded into the same structure, and the version of the format is recorded in the message. Messages in versions
+// that aren't supported produce an *UnsupportedVersionError. The paths and the permission level in the decoded message
+// are in canonical form, the canonical UUID is taken from the entity identifier and the checksum is split into its
+// algorithm and digest. The timestamp is parsed in whichever format it's in, and the format is recorded in the message.
+// The timestamp is nil if it can't be parsed, and the format is TimestampUnparseable. Batch messages are expanded into
+// a message for each of their data objects, which is decoded in the same way.
 func Decode(body []byte) (*Message, error) {
-	var msg Message
-	if err := json.Unmarshal(body, &msg); err != nil {
+	version, err := detectVersion(body)
+	if err != nil {
+		return nil, err
+	}
+	decode, ok := decoders[version]
+	if !ok {
+		return nil, &UnsupportedVersionError{Version: version}
+	}
+	msg, err := decode(body)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Timestamp, msg.TimestampFormat, err = decodeTimestamp(body); err != nil {
 		return nil, err
 	}
-	return &msg, nil
+	msg.canonicalize()
+	msg.Version = version
+	msg.Raw = body
+	if err := decodeBatch(version, body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// canonicalize converts the paths, the permission level and the replication fields in a decoded message to canonical
+// form, and derives the UUID, checksum algorithm and digest from the fields that they're taken from.
+func (msg *Message) canonicalize() {
+	msg.Path = CanonicalPath(msg.Path)
+	msg.Source = CanonicalPath(msg.Source)
+	if msg.ChangesPermission() {
+		msg.Permission = CanonicalPermission(msg.Permission)
+	}
+	msg.UUID = ParseUUID(msg.Entity)
+	msg.Algorithm, msg.Digest = ParseChecksum(msg.Checksum)
+	msg.TargetNode = strings.TrimSpace(msg.TargetNode)
+	msg.ReplicationStatus = CanonicalReplicationStatus(msg.ReplicationStatus)
+	msg.SyncStatus = CanonicalSynchronizationStatus(msg.SyncStatus)
+}
+
+// CanonicalPath returns the canonical form of an iRODS path, so that the same data object is always identified by the
+// same path. Doubled slashes, trailing slashes and . and .. elements are removed, and the path is converted to Unicode
+// normalization form C, so that names written with composed characters and names written with combining characters
+// refer to the same data object. An empty path is returned unchanged so that it can be reported as missing.
+func CanonicalPath(p string) string {
+	if p == "" {
+		return p
+	}
+	return norm.NFC.String(path.Clean(p))
+}
+
+// Requirements describes the optional fields that must be present in a message for it to be valid. Messages that
+// identify data objects by their entity identifiers alone, such as the ones relayed from the coordinating node, may
+// omit the path if the requirements allow it.
+type Requirements struct {
+	Author         bool
+	Source         bool
+	IdentifierOnly bool
+}
+
+// ValidationError describes every problem that was found in a message that was decoded successfully.
+type ValidationError struct {
+	Problems []string
+}
+
+// Error returns the error message.
+func (e *ValidationError) Error() string {
+	return "invalid message: " + strings.Join(e.Problems, "; ")
+}
+
+// isWellFormedEntity determines whether or not an entity identifier is well formed. Identifiers are assigned by iRODS
+// and are usually UUIDs, but only whitespace and control characters are rejected so that other identifiers are still
+// accepted.
+func isWellFormedEntity(entity string) bool {
+	return strings.IndexFunc(entity, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || r == unicode.ReplacementChar
+	}) < 0
+}
+
+// Validate checks that a decoded message contains the fields needed to record an event for it. The path must be an
+// absolute path unless the requirements allow messages that only identify the data object. The entity identifier must
+// be well formed if it's present, and the author and the source must be present if the requirements say so. The source
+// of a move must also be an absolute path, and moves must identify the data object. Permission changes must identify
+// the user or group by name and zone and name a known permission level. The returned error is a *ValidationError
+// listing every problem that was found.
+func (msg *Message) Validate(req Requirements) error {
+	var problems []string
+	switch {
+	case msg.Path == "" && req.IdentifierOnly:
+		if msg.Entity == "" {
+			problems = append(problems, "the entity identifier is required when the path is missing")
+		}
+	case msg.Path == "":
+		problems = append(problems, "the path is missing")
+	case !strings.HasPrefix(msg.Path, "/"):
+		problems = append(problems, fmt.Sprintf("the path is not absolute: %q", msg.Path))
+	}
+	if !isWellFormedEntity(msg.Entity) {
+		problems = append(problems, fmt.Sprintf("the entity identifier is malformed: %q", msg.Entity))
+	}
+	if msg.Source == "" && req.Source {
+		problems = append(problems, "the source path is missing")
+	}
+	if msg.Source != "" {
+		if !strings.HasPrefix(msg.Source, "/") {
+			problems = append(problems, fmt.Sprintf("the source path is not absolute: %q", msg.Source))
+		}
+		if msg.Entity == "" {
+			problems = append(problems, "the entity identifier is required for moves")
+		}
+	}
+	if msg.ChangesPermission() {
+		switch {
+		case msg.Grantee == nil || msg.Grantee.Name == "" || msg.Grantee.Zone == "":
+			problems = append(problems, "permission changes must identify the user or group with a name and a zone")
+		case !isPermissionLevel(msg.Permission):
+			problems = append(problems, fmt.Sprintf("the permission level is unknown: %q", msg.Permission))
+		}
+	}
+	if msg.ReplicationStatus != "" && !isReplicationStatus(msg.ReplicationStatus) {
+		problems = append(problems, fmt.Sprintf("the replication status is unknown: %q", msg.ReplicationStatus))
+	}
+	if msg.SyncStatus != "" && !isSynchronizationStatus(msg.SyncStatus) {
+		problems = append(problems, fmt.Sprintf("the synchronization status is unknown: %q", msg.SyncStatus))
+	}
+	if msg.BytesRead != nil && *msg.BytesRead < 0 {
+		problems = append(problems, fmt.Sprintf("the number of bytes read is negative: %d", *msg.BytesRead))
+	}
+	if req.Author {
+		switch {
+		case msg.Author == nil:
+			problems = append(problems, "the author is missing")
+		case msg.Author.Anonymous && msg.Author.Name != "":
+			// Anonymous users don't belong to zones.
+		case msg.Author.Name == "" || msg.Author.Zone == "":
+			problems = append(problems, "the author must have both a name and a zone")
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
 }
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"reflect"
 	"testing"
 	"time"
 )
@@ -102,3 +103,255 @@ func TestExtraFields(t *testing.T) {
 
 	validateCommonFields(t, msg)
 }
+
+func TestRawMessage(t *testing.T) {
+	msg, err := Decode(extraFields)
+	if err != nil {
+		t.Fatalf("error encountered while decoding message: %s", err)
+	}
+
+	if string(msg.Raw) != string(extraFields) {
+		t.Errorf("expected the serialized message to be retained but got `%s`", msg.Raw)
+	}
+}
+
+// Messages in the form published by the Discovery Environment's iRODS rules.
+var (
+	deOpenMessage = []byte(`{
+  "author": {"name": "ipcdev", "zone": "iplant"},
+  "entity": "5b6c32b6-7c45-11e8-a0b0-008cfa5ae621",
+  "path": "/iplant/home/shared/commons_repo/curated/example/data.csv",
+  "timestamp": "2018-07-04.10:21:35"
+}`)
+	deAddMessage = []byte(`{
+  "author": {"name": "ipcdev", "zone": "iplant"},
+  "creator": {"name": "ipcdev", "zone": "iplant"},
+  "entity": "5b6c32b6-7c45-11e8-a0b0-008cfa5ae621",
+  "path": "/iplant/home/shared/commons_repo/curated/example/data.csv",
+  "size": 5242880,
+  "checksum": "sha2:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+  "type": "generated",
+  "timestamp": "2018-07-04.10:20:12"
+}`)
+	deEmptyAddMessage = []byte(`{
+  "author": {"name": "ipcdev", "zone": "iplant"},
+  "creator": {"name": "ipcdev", "zone": "iplant"},
+  "entity": "6d3b0ac4-7c45-11e8-a0b0-008cfa5ae621",
+  "path": "/iplant/home/shared/commons_repo/curated/example/empty.txt",
+  "size": 0,
+  "type": "generated",
+  "timestamp": "2018-07-04.10:20:48"
+}`)
+)
+
+func TestMessageDetails(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      []byte
+		size      *int64
+		checksum  string
+		timestamp string
+	}{
+		{"open", deOpenMessage, nil, "", "2018-07-04T10:21:35Z"},
+		{
+			"add",
+			deAddMessage,
+			func() *int64 { size := int64(5242880); return &size }(),
+			"sha2:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+			"2018-07-04T10:20:12Z",
+		},
+		{"empty file", deEmptyAddMessage, new(int64), "", "2018-07-04T10:20:48Z"},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode(test.body)
+		if err != nil {
+			t.Errorf("%s: error encountered while decoding message: %s", test.name, err)
+			continue
+		}
+		if msg.Author.String() != "ipcdev#iplant" {
+			t.Errorf("%s: expected author `ipcdev#iplant` but got `%s`", test.name, msg.Author)
+		}
+		switch {
+		case test.size == nil && msg.Size != nil:
+			t.Errorf("%s: expected no size but got %d", test.name, *msg.Size)
+		case test.size != nil && msg.Size == nil:
+			t.Errorf("%s: expected a size of %d but got none", test.name, *test.size)
+		case test.size != nil && *msg.Size != *test.size:
+			t.Errorf("%s: expected a size of %d but got %d", test.name, *test.size, *msg.Size)
+		}
+		if msg.Checksum != test.checksum {
+			t.Errorf("%s: expected checksum `%s` but got `%s`", test.name, test.checksum, msg.Checksum)
+		}
+		if msg.Timestamp == nil {
+			t.Errorf("%s: no timestamp extracted from message", test.name)
+		} else if actual := msg.Timestamp.ToTime().Format(time.RFC3339); actual != test.timestamp {
+			t.Errorf("%s: expected timestamp `%s` but got `%s`", test.name, test.timestamp, actual)
+		}
+		if err := msg.Validate(Requirements{Author: true}); err != nil {
+			t.Errorf("%s: unexpected validation error: %s", test.name, err)
+		}
+	}
+}
+
+func TestCanonicalPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/iplant/home/shared/foo.txt", "/iplant/home/shared/foo.txt"},
+		{"/iplant/home/shared/foo/", "/iplant/home/shared/foo"},
+		{"/iplant/home/shared/foo//", "/iplant/home/shared/foo"},
+		{"/iplant//home///shared/foo.txt", "/iplant/home/shared/foo.txt"},
+		{"/iplant/home/./shared/./foo.txt", "/iplant/home/shared/foo.txt"},
+		{"/iplant/home/shared/bar/../foo.txt", "/iplant/home/shared/foo.txt"},
+		{"//iplant/home/shared/foo.txt/", "/iplant/home/shared/foo.txt"},
+		{"/iplant/home/shared/caf\u00e9.txt", "/iplant/home/shared/caf\u00e9.txt"},
+		{"/iplant/home/shared/cafe\u0301.txt", "/iplant/home/shared/caf\u00e9.txt"},
+		{"/iplant/home/shared/cafe\u0301//", "/iplant/home/shared/caf\u00e9"},
+		{"/", "/"},
+		{"foo/bar/", "foo/bar"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if actual := CanonicalPath(test.path); actual != test.expected {
+			t.Errorf("%q: expected %q but got %q", test.path, test.expected, actual)
+		}
+	}
+}
+
+func TestDecodeCanonicalPath(t *testing.T) {
+	msg, err := Decode([]byte(`{"entity": "fakeid", "path": "/foo//./bar/"}`))
+	if err != nil {
+		t.Fatalf("error encountered while decoding message: %s", err)
+	}
+	if msg.Path != "/foo/bar" {
+		t.Errorf("expected path `/foo/bar` but got `%s`", msg.Path)
+	}
+
+	msg, err = Decode([]byte(`{"entity": "fakeid", "path": "/foo/cafe\u0301", "old-path": "/bar/cafe\u0301"}`))
+	if err != nil {
+		t.Fatalf("error encountered while decoding message: %s", err)
+	}
+	if msg.Path != "/foo/caf\u00e9" || msg.Source != "/bar/caf\u00e9" {
+		t.Errorf("expected composed paths but got `%s` and `%s`", msg.Path, msg.Source)
+	}
+}
+
+func TestUserString(t *testing.T) {
+	var missing *User
+	tests := []struct {
+		user     *User
+		expected string
+	}{
+		{&User{Name: "nobody", Zone: "nowhere"}, "nobody#nowhere"},
+		{&User{Zone: "nowhere"}, ""},
+		{missing, ""},
+	}
+
+	for _, test := range tests {
+		if actual := test.user.String(); actual != test.expected {
+			t.Errorf("expected `%s` but got `%s`", test.expected, actual)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	author := &User{Name: "nobody", Zone: "nowhere"}
+	tests := []struct {
+		name     string
+		msg      *Message
+		req      Requirements
+		problems []string
+	}{
+		{"valid", &Message{Author: author, Entity: "fakeid", Path: "/foo/bar"}, Requirements{Author: true}, nil},
+		{"no entity", &Message{Path: "/foo/bar"}, Requirements{}, nil},
+		{"no author allowed", &Message{Entity: "fakeid", Path: "/foo/bar"}, Requirements{}, nil},
+		{"no path", &Message{Entity: "fakeid"}, Requirements{}, []string{"the path is missing"}},
+		{
+			"relative path",
+			&Message{Entity: "fakeid", Path: "foo/bar"},
+			Requirements{},
+			[]string{`the path is not absolute: "foo/bar"`},
+		},
+		{
+			"malformed entity",
+			&Message{Entity: "fake id", Path: "/foo/bar"},
+			Requirements{},
+			[]string{`the entity identifier is malformed: "fake id"`},
+		},
+		{
+			"no author",
+			&Message{Entity: "fakeid", Path: "/foo/bar"},
+			Requirements{Author: true},
+			[]string{"the author is missing"},
+		},
+		{
+			"no zone",
+			&Message{Author: &User{Name: "nobody"}, Path: "/foo/bar"},
+			Requirements{Author: true},
+			[]string{"the author must have both a name and a zone"},
+		},
+		{
+			"relative source",
+			&Message{Entity: "fakeid", Path: "/foo/bar", Source: "foo/baz"},
+			Requirements{},
+			[]string{`the source path is not absolute: "foo/baz"`},
+		},
+		{
+			"permission change without grantee",
+			&Message{Entity: "fakeid", Path: "/foo/bar", Permission: PermissionRead},
+			Requirements{},
+			[]string{"permission changes must identify the user or group with a name and a zone"},
+		},
+		{
+			"unknown permission level",
+			&Message{Entity: "fakeid", Path: "/foo/bar", Grantee: author, Permission: "admin"},
+			Requirements{},
+			[]string{`the permission level is unknown: "admin"`},
+		},
+		{
+			"revocation",
+			&Message{Entity: "fakeid", Path: "/foo/bar", Grantee: author, Permission: PermissionNull},
+			Requirements{},
+			nil,
+		},
+		{
+			"no source",
+			&Message{Entity: "fakeid", Path: "/foo/bar"},
+			Requirements{Source: true},
+			[]string{"the source path is missing"},
+		},
+		{
+			"move without entity",
+			&Message{Path: "/foo/bar", Source: "/foo/baz"},
+			Requirements{},
+			[]string{"the entity identifier is required for moves"},
+		},
+		{
+			"every problem",
+			&Message{Entity: "fake\nid"},
+			Requirements{Author: true},
+			[]string{"the path is missing", `the entity identifier is malformed: "fake\nid"`, "the author is missing"},
+		},
+	}
+
+	for _, test := range tests {
+		err := test.msg.Validate(test.req)
+		if test.problems == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+			}
+			continue
+		}
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("%s: expected a validation error but got %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(verr.Problems, test.problems) {
+			t.Errorf("%s: expected problems %q but got %q", test.name, test.problems, verr.Problems)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"strings"
+)
+
+// The permission levels that can be granted on a data object. PermissionNull is the level that iRODS reports when a
+// permission is revoked.
+const (
+	PermissionNull  = "null"
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+	PermissionOwn   = "own"
+)
+
+// permissionLevels maps the names that iRODS uses for permission levels to the levels themselves. Some versions of
+// iRODS use the longer names in permission change messages.
+var permissionLevels = map[string]string{
+	"null":          PermissionNull,
+	"read":          PermissionRead,
+	"read object":   PermissionRead,
+	"write":         PermissionWrite,
+	"modify object": PermissionWrite,
+	"own":           PermissionOwn,
+}
+
+// CanonicalPermission returns the permission level with the given name. Names that aren't recognized are returned in
+// lower case so that they can be reported as invalid.
+func CanonicalPermission(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if level, ok := permissionLevels[name]; ok {
+		return level
+	}
+	return name
+}
+
+// isPermissionLevel determines whether or not a name is a canonical permission level.
+func isPermissionLevel(name string) bool {
+	level, ok := permissionLevels[name]
+	return ok && level == name
+}
+
+// ChangesPermission determines whether or not the message describes a change to the permissions of a data object.
+func (msg *Message) ChangesPermission() bool {
+	return msg.Grantee != nil || msg.Permission != ""
+}
+
+// RevokesPermission determines whether or not the message describes the revocation of a permission.
+func (msg *Message) RevokesPermission() bool {
+	return msg.ChangesPermission() && msg.Permission == PermissionNull
+}
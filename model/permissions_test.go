@@ -0,0 +1,80 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestCanonicalPermission(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"read", PermissionRead},
+		{" Read Object ", PermissionRead},
+		{"modify object", PermissionWrite},
+		{"own", PermissionOwn},
+		{"null", PermissionNull},
+		{"Admin", "admin"},
+	}
+
+	for _, test := range tests {
+		if actual := CanonicalPermission(test.name); actual != test.expected {
+			t.Errorf("%s: expected %s but got %s", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestPermissionVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    []byte
+		revokes bool
+	}{
+		{
+			"version 1 grant",
+			[]byte(`{"entity": "fakeid", "path": "/foo/bar", "user": {"name": "ipcdev", "zone": "iplant"},` +
+				` "permission": "read object"}`),
+			false,
+		},
+		{
+			"version 1 revocation",
+			[]byte(`{"entity": "fakeid", "path": "/foo/bar", "user": {"name": "ipcdev", "zone": "iplant"},` +
+				` "permission": "null"}`),
+			true,
+		},
+		{
+			"version 2 grant",
+			[]byte(`{"version": 2, "entity": {"id": "fakeid", "path": "/foo/bar"},` +
+				` "permission": {"user": {"username": "ipcdev", "zone": "iplant"}, "level": "READ"}}`),
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode(test.body)
+		if err != nil {
+			t.Errorf("%s: error encountered while decoding message: %s", test.name, err)
+			continue
+		}
+		if !msg.ChangesPermission() || msg.Grantee.String() != "ipcdev#iplant" {
+			t.Errorf("%s: unexpected grantee: %s", test.name, msg.Grantee)
+		}
+		if msg.RevokesPermission() != test.revokes {
+			t.Errorf("%s: expected revocation to be %t for permission %s", test.name, test.revokes, msg.Permission)
+		}
+		if !test.revokes && msg.Permission != PermissionRead {
+			t.Errorf("%s: unexpected permission: %s", test.name, msg.Permission)
+		}
+		if err := msg.Validate(Requirements{}); err != nil {
+			t.Errorf("%s: unexpected validation error: %s", test.name, err)
+		}
+	}
+
+	msg, err := Decode([]byte(`{"entity": "fakeid", "path": "/foo/bar"}`))
+	if err != nil {
+		t.Fatalf("error encountered while decoding message: %s", err)
+	}
+	if msg.ChangesPermission() {
+		t.Error("expected a message without a grantee not to change permissions")
+	}
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+// rangeUnit is the only unit that byte ranges are expressed in.
+const rangeUnit = "bytes="
+
+// ReadSize returns the number of bytes that were read from a data object, or nil if it isn't known. The number of
+// bytes read is taken from the message if it's included. Otherwise, it's computed from the byte range, which is in the
+// form of an HTTP Range header. Ranges that extend to the end of the data object can only be measured if the message
+// includes the size of the data object, and ranges that can't be parsed are treated as though they were absent.
+func (msg *Message) ReadSize() *int64 {
+	if msg.BytesRead != nil {
+		bytesRead := *msg.BytesRead
+		return &bytesRead
+	}
+	if bytesRead, ok := rangeSize(msg.Range, msg.Size); ok {
+		return &bytesRead
+	}
+	return nil
+}
+
+// rangeSize returns the total number of bytes in a byte range with one or more parts. The size of the data object
+// may be nil if it isn't known. The second return value is false if the size of the range can't be determined.
+func rangeSize(header string, size *int64) (int64, bool) {
+	header = strings.TrimSpace(header)
+	if !strings.HasPrefix(strings.ToLower(header), rangeUnit) {
+		return 0, false
+	}
+	var total int64
+	for _, part := range strings.Split(header[len(rangeUnit):], ",") {
+		n, ok := rangePartSize(strings.TrimSpace(part), size)
+		if !ok {
+			return 0, false
+		}
+		total += n
+	}
+	return total, true
+}
+
+// rangePartSize returns the number of bytes in one part of a byte range, which is either the first and last positions
+// of the range, the first position alone for ranges that extend to the end of the data object, or the number of bytes
+// at the end of the data object. Ranges that extend past the end of the data object are truncated if its size is known.
+func rangePartSize(part string, size *int64) (int64, bool) {
+	dash := strings.Index(part, "-")
+	if dash < 0 {
+		return 0, false
+	}
+	first, last := part[:dash], part[dash+1:]
+
+	// The range may consist of the number of bytes at the end of the data object.
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		if size != nil && n > *size {
+			n = *size
+		}
+		return n, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, false
+	}
+	end := int64(-1)
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, false
+		}
+	}
+	switch {
+	case size != nil && (end < 0 || end >= *size):
+		if start >= *size {
+			return 0, true
+		}
+		end = *size - 1
+	case end < 0:
+		return 0, false
+	}
+	return end - start + 1, true
+}
@@ -0,0 +1,94 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestReadSize(t *testing.T) {
+	size := int64(1000)
+	bytesRead := int64(4096)
+	noBytes := int64(0)
+
+	// The expected size is negative if the read size isn't known.
+	tests := []struct {
+		name      string
+		bytesRead *int64
+		rng       string
+		size      *int64
+		expected  int64
+	}{
+		{"no range information", nil, "", &size, -1},
+		{"bytes read", &bytesRead, "bytes=0-9", &size, 4096},
+		{"no bytes read", &noBytes, "", &size, 0},
+		{"closed range", nil, "bytes=0-99", nil, 100},
+		{"upper case unit", nil, "Bytes=10-19", nil, 10},
+		{"open range", nil, "bytes=900-", &size, 100},
+		{"open range without size", nil, "bytes=900-", nil, -1},
+		{"suffix range", nil, "bytes=-300", &size, 300},
+		{"long suffix range", nil, "bytes=-3000", &size, 1000},
+		{"range past the end", nil, "bytes=500-1999", &size, 500},
+		{"range after the end", nil, "bytes=2000-2999", &size, 0},
+		{"multiple ranges", nil, "bytes=0-49, 100-149,-10", &size, 110},
+		{"reversed range", nil, "bytes=20-10", &size, -1},
+		{"malformed range", nil, "bytes=ten-twenty", &size, -1},
+		{"missing dash", nil, "bytes=100", &size, -1},
+		{"other unit", nil, "items=0-9", &size, -1},
+	}
+
+	for _, test := range tests {
+		msg := &Message{BytesRead: test.bytesRead, Range: test.rng, Size: test.size}
+		actual := msg.ReadSize()
+		switch {
+		case test.expected < 0 && actual != nil:
+			t.Errorf("%s: expected no read size but got %d", test.name, *actual)
+		case test.expected >= 0 && actual == nil:
+			t.Errorf("%s: expected %d bytes but got no read size", test.name, test.expected)
+		case test.expected >= 0 && *actual != test.expected:
+			t.Errorf("%s: expected %d bytes but got %d", test.name, test.expected, *actual)
+		}
+	}
+}
+
+func TestRangeVersions(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      []byte
+		bytesRead int64
+		rng       string
+	}{
+		{
+			"version 1",
+			[]byte(`{"entity": "fakeid", "path": "/foo/bar", "bytesRead": 65536, "range": "bytes=0-65535"}`),
+			65536,
+			"bytes=0-65535",
+		},
+		{
+			"version 2",
+			[]byte(`{"version": 2, "entity": {"id": "fakeid", "path": "/foo/bar"}, "bytesRead": 512, ` +
+				`"range": "bytes=-512"}`),
+			512,
+			"bytes=-512",
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode(test.body)
+		if err != nil {
+			t.Errorf("%s: error encountered while decoding message: %s", test.name, err)
+			continue
+		}
+		if msg.BytesRead == nil || *msg.BytesRead != test.bytesRead || msg.Range != test.rng {
+			t.Errorf("%s: unexpected range information: %v, %q", test.name, msg.BytesRead, msg.Range)
+		}
+	}
+}
+
+func TestNegativeBytesRead(t *testing.T) {
+	msg, err := Decode([]byte(`{"entity": "fakeid", "path": "/foo/bar", "bytesRead": -1}`))
+	if err != nil {
+		t.Fatalf("error encountered while decoding message: %s", err)
+	}
+	if err := msg.Validate(Requirements{}); err == nil {
+		t.Error("a negative number of bytes read should be rejected")
+	}
+}
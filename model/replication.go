@@ -0,0 +1,39 @@
+package model
+
+import (
+	"strings"
+)
+
+// The statuses that replicas of data objects on other member nodes may have, which are the DataONE replication
+// statuses. Replication messages without a status describe completed replications.
+const (
+	ReplicationQueued      = "queued"
+	ReplicationRequested   = "requested"
+	ReplicationCompleted   = "completed"
+	ReplicationFailed      = "failed"
+	ReplicationInvalidated = "invalidated"
+)
+
+// isReplicationStatus determines whether or not a replication status is one of the known statuses.
+func isReplicationStatus(status string) bool {
+	switch status {
+	case ReplicationQueued, ReplicationRequested, ReplicationCompleted, ReplicationFailed, ReplicationInvalidated:
+		return true
+	}
+	return false
+}
+
+// CanonicalReplicationStatus returns the canonical form of a replication status, which is lower case without
+// surrounding whitespace. An empty status is returned unchanged.
+func CanonicalReplicationStatus(status string) string {
+	return strings.ToLower(strings.TrimSpace(status))
+}
+
+// ReplicationResult returns the status of the replica that the message describes, which is the completed status if
+// the message doesn't include one.
+func (msg *Message) ReplicationResult() string {
+	if msg.ReplicationStatus == "" {
+		return ReplicationCompleted
+	}
+	return msg.ReplicationStatus
+}
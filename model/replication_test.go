@@ -0,0 +1,57 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestReplicationFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   []byte
+		target string
+		status string
+		result string
+		reason string
+	}{
+		{
+			"version 1",
+			[]byte(`{"entity": "fakeid", "path": "/iplant/home/foo", "targetNode": "urn:node:partner"}`),
+			"urn:node:partner",
+			"",
+			ReplicationCompleted,
+			"",
+		},
+		{
+			"version 2",
+			[]byte(`{"version": 2, "entity": {"id": "fakeid", "path": "/iplant/home/foo"},
+				"targetNode": " urn:node:partner ", "replicationStatus": " FAILED ",
+				"failureReason": "checksum mismatch"}`),
+			"urn:node:partner",
+			ReplicationFailed,
+			ReplicationFailed,
+			"checksum mismatch",
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode(test.body)
+		if err != nil {
+			t.Fatalf("%s: unable to decode the message: %s", test.name, err)
+		}
+		if msg.TargetNode != test.target || msg.ReplicationStatus != test.status || msg.FailureReason != test.reason {
+			t.Errorf("%s: unexpected replication fields: %+v", test.name, msg)
+		}
+		if result := msg.ReplicationResult(); result != test.result {
+			t.Errorf("%s: expected result %q but got %q", test.name, test.result, result)
+		}
+	}
+}
+
+func TestReplicationStatusValidation(t *testing.T) {
+	for status, valid := range map[string]bool{"": true, ReplicationCompleted: true, "replicated": false} {
+		msg := &Message{Path: "/iplant/home/foo", ReplicationStatus: status}
+		if err := msg.Validate(Requirements{}); (err == nil) != valid {
+			t.Errorf("%q: expected valid to be %t but got %v", status, valid, err)
+		}
+	}
+}
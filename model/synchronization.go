@@ -0,0 +1,32 @@
+package model
+
+import (
+	"strings"
+)
+
+// The statuses of the synchronization of data objects with the coordinating node. Synchronization messages without a
+// status describe failed synchronizations, since the coordinating node only notifies member nodes of failures.
+const (
+	SynchronizationFailed    = "failed"
+	SynchronizationCompleted = "completed"
+)
+
+// isSynchronizationStatus determines whether or not a synchronization status is one of the known statuses.
+func isSynchronizationStatus(status string) bool {
+	return status == SynchronizationFailed || status == SynchronizationCompleted
+}
+
+// CanonicalSynchronizationStatus returns the canonical form of a synchronization status, which is lower case without
+// surrounding whitespace. An empty status is returned unchanged.
+func CanonicalSynchronizationStatus(status string) string {
+	return strings.ToLower(strings.TrimSpace(status))
+}
+
+// SynchronizationResult returns the status of the synchronization that the message describes, which is the failed
+// status if the message doesn't include one.
+func (msg *Message) SynchronizationResult() string {
+	if msg.SyncStatus == "" {
+		return SynchronizationFailed
+	}
+	return msg.SyncStatus
+}
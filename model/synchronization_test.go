@@ -0,0 +1,64 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestSynchronizationFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   []byte
+		status string
+		result string
+		reason string
+	}{
+		{
+			"version 1",
+			[]byte(`{"entity": "fakepid", "failureReason": "invalid system metadata"}`),
+			"",
+			SynchronizationFailed,
+			"invalid system metadata",
+		},
+		{
+			"version 2",
+			[]byte(`{"version": 2, "entity": {"id": "fakepid"}, "synchronizationStatus": " Completed "}`),
+			SynchronizationCompleted,
+			SynchronizationCompleted,
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode(test.body)
+		if err != nil {
+			t.Fatalf("%s: unable to decode the message: %s", test.name, err)
+		}
+		if msg.Entity != "fakepid" || msg.SyncStatus != test.status || msg.FailureReason != test.reason {
+			t.Errorf("%s: unexpected synchronization fields: %+v", test.name, msg)
+		}
+		if result := msg.SynchronizationResult(); result != test.result {
+			t.Errorf("%s: expected result %q but got %q", test.name, test.result, result)
+		}
+	}
+}
+
+func TestSynchronizationValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		msg   *Message
+		req   Requirements
+		valid bool
+	}{
+		{"identifier only", &Message{Entity: "fakepid"}, Requirements{IdentifierOnly: true}, true},
+		{"path required", &Message{Entity: "fakepid"}, Requirements{}, false},
+		{"no identifier", &Message{}, Requirements{IdentifierOnly: true}, false},
+		{"relative path", &Message{Entity: "fakepid", Path: "foo"}, Requirements{IdentifierOnly: true}, false},
+		{"unknown status", &Message{Entity: "fakepid", SyncStatus: "stuck"}, Requirements{IdentifierOnly: true}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.msg.Validate(test.req); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid to be %t but got %v", test.name, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// The formats in which timestamps are found in messages. The format of the timestamp in each decoded message is
+// recorded so that the services that produce timestamps in each format can be tracked down.
+const (
+	TimestampReference   = "reference"
+	TimestampRFC3339     = "rfc3339"
+	TimestampEpochMillis = "epoch-millis"
+	TimestampIRODS       = "irods"
+	TimestampUnparseable = "unparseable"
+)
+
+// timestampLayouts lists the layouts of the timestamps that are written as strings, in the order in which they're
+// tried. Fractional seconds are accepted by every layout.
+var timestampLayouts = []struct {
+	format string
+	layout string
+}{
+	{TimestampReference, ReferenceTime[1 : len(ReferenceTime)-1]},
+	{TimestampRFC3339, time.RFC3339},
+	{TimestampIRODS, "2006-01-02 15:04:05"},
+}
+
+// ParseTimestamp parses the serialized JSON value of a timestamp in any of the formats in which timestamps are found in
+// messages. Timestamps may be written in the reference format, in RFC 3339 format or in the format used by iRODS, or
+// as the number of milliseconds since the epoch, either as a number or as a string. Timestamps without time zones are
+// in UTC, and the parsed time is always in UTC. The format of the timestamp is returned along with the parsed time. The
+// third return value is false if the timestamp isn't in any of the known formats.
+func ParseTimestamp(value []byte) (time.Time, string, bool) {
+	value = bytes.TrimSpace(value)
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		s = string(value)
+	}
+
+	// Try each of the layouts in turn.
+	for _, l := range timestampLayouts {
+		if t, err := time.Parse(l.layout, s); err == nil {
+			return t.UTC(), l.format, true
+		}
+	}
+
+	// Fall back to the number of milliseconds since the epoch.
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, 0).Add(time.Duration(ms) * time.Millisecond).UTC(), TimestampEpochMillis, true
+	}
+	return time.Time{}, TimestampUnparseable, false
+}
+
+// timestampProbe contains the serialized timestamp of a message, which is at the top level of the message in every
+// version of the message format.
+type timestampProbe struct {
+	Timestamp json.RawMessage `json:"timestamp"`
+}
+
+// decodeTimestamp parses the timestamp of a serialized message, returning nil and an empty format if the message
+// doesn't include a timestamp. Timestamps that can't be parsed are reported as unparseable rather than as errors.
+func decodeTimestamp(body []byte) (*Timestamp, string, error) {
+	var probe timestampProbe
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil, "", err
+	}
+	if len(probe.Timestamp) == 0 || string(probe.Timestamp) == "null" {
+		return nil, "", nil
+	}
+	t, format, ok := ParseTimestamp(probe.Timestamp)
+	if !ok {
+		return nil, format, nil
+	}
+	return (*Timestamp)(&t), format, nil
+}
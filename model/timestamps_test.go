@@ -0,0 +1,73 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	expected := time.Date(2017, 10, 6, 15, 7, 37, 0, time.UTC)
+	tests := []struct {
+		value  string
+		format string
+		time   time.Time
+	}{
+		{`"2017-10-06.15:07:37"`, TimestampReference, expected},
+		{`"2017-10-06T15:07:37Z"`, TimestampRFC3339, expected},
+		{`"2017-10-06T08:07:37-07:00"`, TimestampRFC3339, expected},
+		{`"2017-10-06T15:07:37.250Z"`, TimestampRFC3339, expected.Add(250 * time.Millisecond)},
+		{`"2017-10-06 15:07:37"`, TimestampIRODS, expected},
+		{`1507302457000`, TimestampEpochMillis, expected},
+		{`"1507302457250"`, TimestampEpochMillis, expected.Add(250 * time.Millisecond)},
+	}
+
+	for _, test := range tests {
+		actual, format, ok := ParseTimestamp([]byte(test.value))
+		if !ok {
+			t.Errorf("%s: unable to parse timestamp", test.value)
+			continue
+		}
+		if format != test.format {
+			t.Errorf("%s: expected format %s but got %s", test.value, test.format, format)
+		}
+		if !actual.Equal(test.time) || actual.Location() != time.UTC {
+			t.Errorf("%s: expected %s but got %s", test.value, test.time, actual)
+		}
+	}
+
+	for _, value := range []string{`"yesterday"`, `"2017-10-06"`, `true`, `""`} {
+		if _, format, ok := ParseTimestamp([]byte(value)); ok || format != TimestampUnparseable {
+			t.Errorf("%s: expected the timestamp to be unparseable but got format %s", value, format)
+		}
+	}
+}
+
+func TestDecodeTimestampFormat(t *testing.T) {
+	tests := []struct {
+		body      string
+		format    string
+		timestamp bool
+	}{
+		{`{"entity": "fakeid", "path": "/foo/bar", "timestamp": "2017-10-06.15:07:37"}`, TimestampReference, true},
+		{`{"entity": "fakeid", "path": "/foo/bar", "timestamp": 1507302457000}`, TimestampEpochMillis, true},
+		{`{"entity": {"id": "fakeid", "path": "/foo/bar"}, "timestamp": "2017-10-06T15:07:37Z"}`, TimestampRFC3339,
+			true},
+		{`{"entity": "fakeid", "path": "/foo/bar", "timestamp": "yesterday"}`, TimestampUnparseable, false},
+		{`{"entity": "fakeid", "path": "/foo/bar", "timestamp": null}`, "", false},
+		{`{"entity": "fakeid", "path": "/foo/bar"}`, "", false},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode([]byte(test.body))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.body, err)
+			continue
+		}
+		if msg.TimestampFormat != test.format {
+			t.Errorf("%s: expected format %q but got %q", test.body, test.format, msg.TimestampFormat)
+		}
+		if (msg.Timestamp != nil) != test.timestamp {
+			t.Errorf("%s: unexpected timestamp: %v", test.body, msg.Timestamp)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Versions of the message format. Version 1 messages have no version field; the entity is a string containing the
+// identifier of the data object, and the path, size and checksum are top-level fields. Version 1 move messages have
+// old-path and new-path fields instead of a path. Version 2 messages nest the details of the data object, including
+// the source of a move, in an entity object and identify the user with the username and zone fields of the author. A
+// message without a version field is a version 2 message if its entity is an object.
+const (
+	Version1 = 1
+	Version2 = 2
+)
+
+// UnsupportedVersionError indicates that a message was serialized in a version of the message format that can't be
+// decoded, such as a version introduced after this version of the indexer was released.
+type UnsupportedVersionError struct {
+	Version int
+}
+
+// Error returns the error message.
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("unsupported message format version: %d", e.Version)
+}
+
+// decoders maps each supported version of the message format to the function used to decode it.
+var decoders = map[int]func([]byte) (*Message, error){
+	Version1: decodeVersion1,
+	Version2: decodeVersion2,
+}
+
+// versionProbe contains the fields used to determine the version of the format of a message.
+type versionProbe struct {
+	Version *int            `json:"version"`
+	Entity  json.RawMessage `json:"entity"`
+}
+
+// detectVersion determines the version of the format in which a message was serialized. The version field is used if
+// it's present. Otherwise, the version is inferred from the structure of the message.
+func detectVersion(body []byte) (int, error) {
+	var probe versionProbe
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return 0, err
+	}
+	switch {
+	case probe.Version != nil:
+		return *probe.Version, nil
+	case bytes.HasPrefix(bytes.TrimSpace(probe.Entity), []byte("{")):
+		return Version2, nil
+	default:
+		return Version1, nil
+	}
+}
+
+// version1Metadatum is an AVU in a version 1 message. Only the attribute name is used.
+type version1Metadatum struct {
+	Attribute string `json:"attribute"`
+}
+
+// version1Extras contains the fields of version 1 messages that aren't decoded directly into a Message: the
+// destination of a move, and the AVUs named in a metadata change. Modifications name both the old and new AVUs.
+type version1Extras struct {
+	NewPath      string             `json:"new-path"`
+	Metadatum    *version1Metadatum `json:"metadatum"`
+	NewMetadatum *version1Metadatum `json:"new-metadatum"`
+}
+
+// decodeVersion1 decodes a message in version 1 of the message format.
+func decodeVersion1(body []byte) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+	var extras version1Extras
+	if err := json.Unmarshal(body, &extras); err != nil {
+		return nil, err
+	}
+	if msg.Path == "" {
+		msg.Path = extras.NewPath
+	}
+	var attributes []string
+	for _, m := range []*version1Metadatum{extras.Metadatum, extras.NewMetadatum} {
+		if m != nil {
+			attributes = append(attributes, m.Attribute)
+		}
+	}
+	msg.Attributes = attributeNames(attributes)
+	return &msg, nil
+}
+
+// version2User is the author of a version 2 message.
+type version2User struct {
+	Username string `json:"username"`
+	Zone     string `json:"zone"`
+}
+
+// version2Entity describes the data object in a version 2 message.
+type version2Entity struct {
+	ID       string `json:"id"`
+	Path     string `json:"path"`
+	Size     *int64 `json:"size,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+	Source   string `json:"source,omitempty"`
+}
+
+// version2Metadatum is an AVU in a version 2 message. Only the attribute name is used.
+type version2Metadatum struct {
+	Attribute string `json:"attribute"`
+}
+
+// version2Permission describes a change to the permissions of a data object in a version 2 message.
+type version2Permission struct {
+	User  *version2User `json:"user"`
+	Level string        `json:"level"`
+}
+
+// version2Message is a message in version 2 of the message format. Metadata changes list the AVUs that changed, and
+// permission changes describe the new permission. The IP address and user agent of the client, the access type, the
+// number of bytes read or the byte range, the zone and resource, and the replication and synchronization fields are in
+// the same fields as they are in version 1. The version is only used when messages are encoded.
+type version2Message struct {
+	Version    int                 `json:"version,omitempty"`
+	Author     *version2User       `json:"author,omitempty"`
+	Entity     *version2Entity     `json:"entity"`
+	Metadata   []version2Metadatum `json:"metadata,omitempty"`
+	Permission *version2Permission `json:"permission,omitempty"`
+	Timestamp  *Timestamp          `json:"timestamp,omitempty"`
+	IPAddress  string              `json:"ipAddress,omitempty"`
+	UserAgent  string              `json:"userAgent,omitempty"`
+	AccessType string              `json:"accessType,omitempty"`
+	BytesRead  *int64              `json:"bytesRead,omitempty"`
+	Range      string              `json:"range,omitempty"`
+	Zone       string              `json:"zone,omitempty"`
+	Resource   string              `json:"resource,omitempty"`
+
+	TargetNode        string `json:"targetNode,omitempty"`
+	ReplicationStatus string `json:"replicationStatus,omitempty"`
+	FailureReason     string `json:"failureReason,omitempty"`
+	SyncStatus        string `json:"synchronizationStatus,omitempty"`
+}
+
+// decodeVersion2 decodes a message in version 2 of the message format.
+func decodeVersion2(body []byte) (*Message, error) {
+	var v2 version2Message
+	if err := json.Unmarshal(body, &v2); err != nil {
+		return nil, err
+	}
+	msg := &Message{
+		Timestamp:  v2.Timestamp,
+		IPAddress:  v2.IPAddress,
+		UserAgent:  v2.UserAgent,
+		AccessType: v2.AccessType,
+		BytesRead:  v2.BytesRead,
+		Range:      v2.Range,
+		Zone:       v2.Zone,
+		Resource:   v2.Resource,
+
+		TargetNode:        v2.TargetNode,
+		ReplicationStatus: v2.ReplicationStatus,
+		FailureReason:     v2.FailureReason,
+		SyncStatus:        v2.SyncStatus,
+	}
+	if v2.Author != nil {
+		msg.Author = &User{Name: v2.Author.Username, Zone: v2.Author.Zone}
+	}
+	if v2.Entity != nil {
+		msg.Entity = v2.Entity.ID
+		msg.Path = v2.Entity.Path
+		msg.Size = v2.Entity.Size
+		msg.Checksum = v2.Entity.Checksum
+		msg.Source = v2.Entity.Source
+	}
+	if v2.Permission != nil {
+		msg.Permission = v2.Permission.Level
+		if v2.Permission.User != nil {
+			msg.Grantee = &User{Name: v2.Permission.User.Username, Zone: v2.Permission.User.Zone}
+		}
+	}
+	attributes := make([]string, len(v2.Metadata))
+	for i, m := range v2.Metadata {
+		attributes[i] = m.Attribute
+	}
+	msg.Attributes = attributeNames(attributes)
+	return msg, nil
+}
+
+// attributeNames returns the distinct, non-empty attribute names in a list, in the order in which they first appear,
+// or nil if there aren't any.
+func attributeNames(attributes []string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, attribute := range attributes {
+		if attribute != "" && !seen[attribute] {
+			names = append(names, attribute)
+			seen[attribute] = true
+		}
+	}
+	return names
+}
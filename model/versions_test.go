@@ -0,0 +1,274 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Fixtures for each version of the message format. Each of them describes the same event.
+var (
+	version1Fixture = []byte(`{
+  "author": {"name": "ipcdev", "zone": "iplant"},
+  "entity": "5b6c32b6-7c45-11e8-a0b0-008cfa5ae621",
+  "path": "/iplant/home/shared/commons_repo/curated/example/data.csv",
+  "size": 5242880,
+  "checksum": "sha2:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+  "timestamp": "2018-07-04.10:20:12"
+}`)
+	explicitVersion1Fixture = []byte(`{
+  "version": 1,
+  "author": {"name": "ipcdev", "zone": "iplant"},
+  "entity": "5b6c32b6-7c45-11e8-a0b0-008cfa5ae621",
+  "path": "/iplant/home/shared/commons_repo/curated/example/data.csv",
+  "size": 5242880,
+  "checksum": "sha2:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+  "timestamp": "2018-07-04.10:20:12"
+}`)
+	version2Fixture = []byte(`{
+  "version": 2,
+  "author": {"username": "ipcdev", "zone": "iplant"},
+  "entity": {
+    "id": "5b6c32b6-7c45-11e8-a0b0-008cfa5ae621",
+    "path": "/iplant/home/shared/commons_repo/curated/example/data.csv",
+    "size": 5242880,
+    "checksum": "sha2:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+  },
+  "timestamp": "2018-07-04.10:20:12"
+}`)
+	unversionedVersion2Fixture = []byte(`{
+  "author": {"username": "ipcdev", "zone": "iplant"},
+  "entity": {
+    "id": "5b6c32b6-7c45-11e8-a0b0-008cfa5ae621",
+    "path": "/iplant/home/shared/commons_repo/curated/example/data.csv",
+    "size": 5242880,
+    "checksum": "sha2:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+  },
+  "timestamp": "2018-07-04.10:20:12"
+}`)
+	version3Fixture = []byte(`{
+  "version": 3,
+  "actor": {"id": "ipcdev#iplant"},
+  "object": {"id": "5b6c32b6-7c45-11e8-a0b0-008cfa5ae621"}
+}`)
+)
+
+func TestVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    []byte
+		version int
+	}{
+		{"version 1", version1Fixture, Version1},
+		{"explicit version 1", explicitVersion1Fixture, Version1},
+		{"version 2", version2Fixture, Version2},
+		{"unversioned version 2", unversionedVersion2Fixture, Version2},
+	}
+
+	expectedTime := time.Date(2018, 7, 4, 10, 20, 12, 0, time.UTC)
+	for _, test := range tests {
+		msg, err := Decode(test.body)
+		if err != nil {
+			t.Errorf("%s: error encountered while decoding message: %s", test.name, err)
+			continue
+		}
+		if msg.Version != test.version {
+			t.Errorf("%s: expected version %d but got %d", test.name, test.version, msg.Version)
+		}
+		if msg.Author.String() != "ipcdev#iplant" {
+			t.Errorf("%s: expected author `ipcdev#iplant` but got `%s`", test.name, msg.Author)
+		}
+		if msg.Entity != "5b6c32b6-7c45-11e8-a0b0-008cfa5ae621" {
+			t.Errorf("%s: unexpected entity ID `%s`", test.name, msg.Entity)
+		}
+		if msg.Path != "/iplant/home/shared/commons_repo/curated/example/data.csv" {
+			t.Errorf("%s: unexpected path `%s`", test.name, msg.Path)
+		}
+		if msg.Size == nil || *msg.Size != 5242880 {
+			t.Errorf("%s: unexpected size %v", test.name, msg.Size)
+		}
+		if msg.Checksum != "sha2:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=" {
+			t.Errorf("%s: unexpected checksum `%s`", test.name, msg.Checksum)
+		}
+		if msg.Timestamp == nil || !msg.Timestamp.ToTime().Equal(expectedTime) {
+			t.Errorf("%s: unexpected timestamp %v", test.name, msg.Timestamp.ToTime())
+		}
+		if string(msg.Raw) != string(test.body) {
+			t.Errorf("%s: expected the serialized message to be retained", test.name)
+		}
+	}
+}
+
+func TestUnsupportedVersions(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        []byte
+		unsupported bool
+	}{
+		{"future version", version3Fixture, true},
+		{"version 0", []byte(`{"version": 0, "entity": "fakeid", "path": "/foo/bar"}`), true},
+		{"version 1 with an entity object", []byte(`{"version": 1, "entity": {"id": "fakeid"}}`), false},
+		{"non-numeric version", []byte(`{"version": "2", "entity": "fakeid", "path": "/foo/bar"}`), false},
+		{"syntax error", []byte(`{"entity": "fakeid", "path":`), false},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode(test.body)
+		if err == nil {
+			t.Errorf("%s: expected an error but decoded %+v", test.name, msg)
+			continue
+		}
+		if _, ok := err.(*UnsupportedVersionError); ok != test.unsupported {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+	}
+}
+
+func TestMoveVersions(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{
+			"version 1",
+			[]byte(`{"author": {"name": "ipcdev", "zone": "iplant"}, "entity": "fakeid", "old-path": "/foo/bar/",` +
+				` "new-path": "/foo//baz"}`),
+		},
+		{
+			"version 2",
+			[]byte(`{"author": {"username": "ipcdev", "zone": "iplant"},` +
+				` "entity": {"id": "fakeid", "source": "/foo/bar/", "path": "/foo//baz"}}`),
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode(test.body)
+		if err != nil {
+			t.Errorf("%s: error encountered while decoding message: %s", test.name, err)
+			continue
+		}
+		if msg.Source != "/foo/bar" || msg.Path != "/foo/baz" {
+			t.Errorf("%s: unexpected source and destination: %s and %s", test.name, msg.Source, msg.Path)
+		}
+		if err := msg.Validate(Requirements{Author: true}); err != nil {
+			t.Errorf("%s: unexpected validation error: %s", test.name, err)
+		}
+	}
+}
+
+func TestRemovalVersions(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{
+			"version 1",
+			[]byte(`{"author": {"name": "ipcdev", "zone": "iplant"}, "entity": "fakeid", "path": "/foo//bar/"}`),
+		},
+		{
+			"version 2",
+			[]byte(`{"version": 2, "author": {"username": "ipcdev", "zone": "iplant"},` +
+				` "entity": {"id": "fakeid", "path": "/foo//bar/"}}`),
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode(test.body)
+		if err != nil {
+			t.Errorf("%s: error encountered while decoding message: %s", test.name, err)
+			continue
+		}
+		if msg.Entity != "fakeid" || msg.Path != "/foo/bar" || msg.Source != "" {
+			t.Errorf("%s: unexpected entity and paths: %s, %s and %s", test.name, msg.Entity, msg.Path, msg.Source)
+		}
+		if err := msg.Validate(Requirements{Author: true}); err != nil {
+			t.Errorf("%s: unexpected validation error: %s", test.name, err)
+		}
+	}
+}
+
+func TestMetadataVersions(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       []byte
+		attributes []string
+	}{
+		{
+			"version 1 addition",
+			[]byte(`{"entity": "fakeid", "path": "/foo/bar", "metadatum": {"attribute": "title", "value": "Foo"}}`),
+			[]string{"title"},
+		},
+		{
+			"version 1 modification",
+			[]byte(`{"entity": "fakeid", "path": "/foo/bar", "metadatum": {"attribute": "title"},` +
+				` "new-metadatum": {"attribute": "dc:title"}}`),
+			[]string{"title", "dc:title"},
+		},
+		{
+			"version 1 without attributes",
+			[]byte(`{"entity": "fakeid", "path": "/foo/bar"}`),
+			nil,
+		},
+		{
+			"version 2",
+			[]byte(`{"version": 2, "entity": {"id": "fakeid", "path": "/foo/bar"},` +
+				` "metadata": [{"attribute": "title"}, {"attribute": ""}, {"attribute": "creator"},` +
+				` {"attribute": "title"}]}`),
+			[]string{"title", "creator"},
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode(test.body)
+		if err != nil {
+			t.Errorf("%s: error encountered while decoding message: %s", test.name, err)
+			continue
+		}
+		if msg.Path != "/foo/bar" {
+			t.Errorf("%s: unexpected path: %s", test.name, msg.Path)
+		}
+		if !reflect.DeepEqual(msg.Attributes, test.attributes) {
+			t.Errorf("%s: expected attributes %v but got %v", test.name, test.attributes, msg.Attributes)
+		}
+	}
+}
+
+func TestClientVersions(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      []byte
+		ipAddress string
+		userAgent string
+	}{
+		{
+			"version 1",
+			[]byte(`{"entity": "fakeid", "path": "/foo/bar", "ipAddress": "192.0.2.1", "userAgent": "curl/7.58.0"}`),
+			"192.0.2.1",
+			"curl/7.58.0",
+		},
+		{
+			"version 1 without client",
+			[]byte(`{"entity": "fakeid", "path": "/foo/bar"}`),
+			"",
+			"",
+		},
+		{
+			"version 2",
+			[]byte(`{"version": 2, "entity": {"id": "fakeid", "path": "/foo/bar"}, "ipAddress": "2001:db8::1",` +
+				` "userAgent": "Mozilla/5.0"}`),
+			"2001:db8::1",
+			"Mozilla/5.0",
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode(test.body)
+		if err != nil {
+			t.Errorf("%s: error encountered while decoding message: %s", test.name, err)
+			continue
+		}
+		if msg.IPAddress != test.ipAddress || msg.UserAgent != test.userAgent {
+			t.Errorf("%s: unexpected client: %s, %s", test.name, msg.IPAddress, msg.UserAgent)
+		}
+	}
+}
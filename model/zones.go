@@ -0,0 +1,27 @@
+package model
+
+import (
+	"strings"
+)
+
+// PathZone returns the iRODS zone that contains a path, which is the first component of every absolute iRODS path.
+// An empty string is returned if the path isn't absolute.
+func PathZone(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		return ""
+	}
+	zone := strings.TrimPrefix(p, "/")
+	if i := strings.Index(zone, "/"); i >= 0 {
+		zone = zone[:i]
+	}
+	return zone
+}
+
+// EventZone returns the iRODS zone in which the event described by the message occurred. The zone is taken from the
+// message if it's included, and from the path of the data object otherwise.
+func (msg *Message) EventZone() string {
+	if zone := strings.TrimSpace(msg.Zone); zone != "" {
+		return zone
+	}
+	return PathZone(msg.Path)
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestPathZone(t *testing.T) {
+	tests := map[string]string{
+		"/iplant/home/shared/foo.txt": "iplant",
+		"/iplant":                     "iplant",
+		"/":                           "",
+		"iplant/home":                 "",
+		"":                            "",
+	}
+
+	for p, expected := range tests {
+		if zone := PathZone(p); zone != expected {
+			t.Errorf("%q: expected zone %q but got %q", p, expected, zone)
+		}
+	}
+}
+
+func TestEventZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     []byte
+		zone     string
+		resource string
+	}{
+		{
+			"version 1",
+			[]byte(`{"entity": "fakeid", "path": "/iplant/home/foo", "zone": "partner", "resource": "demoResc"}`),
+			"partner",
+			"demoResc",
+		},
+		{
+			"version 2",
+			[]byte(`{"version": 2, "entity": {"id": "fakeid", "path": "/iplant/home/foo"}, "zone": " partner "}`),
+			"partner",
+			"",
+		},
+		{
+			"from the path",
+			[]byte(`{"entity": "fakeid", "path": "/iplant/home/foo"}`),
+			"iplant",
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		msg, err := Decode(test.body)
+		if err != nil {
+			t.Errorf("%s: error encountered while decoding message: %s", test.name, err)
+			continue
+		}
+		if zone := msg.EventZone(); zone != test.zone {
+			t.Errorf("%s: expected zone %q but got %q", test.name, test.zone, zone)
+		}
+		if msg.Resource != test.resource {
+			t.Errorf("%s: expected resource %q but got %q", test.name, test.resource, msg.Resource)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/spf13/viper"
+)
+
+// getMinReadFraction returns the smallest fraction of a data object that a partial read must retrieve for its event
+// to be recorded. Zero means that every partial read is recorded.
+func getMinReadFraction(cfg *viper.Viper) (float64, error) {
+	fraction := cfg.GetFloat64("dataone.partial-reads.min-fraction")
+	if fraction < 0 || fraction > 1 {
+		return 0, fmt.Errorf("dataone.partial-reads.min-fraction must be between 0 and 1: %g", fraction)
+	}
+	return fraction, nil
+}
+
+// isSmallRead determines whether or not a message describes a read that retrieved less than the given fraction of a
+// data object. Reads are only considered small if both the number of bytes read and the size of the data object are
+// known, so reads without range information are always recorded.
+func isSmallRead(msg *model.Message, fraction float64) bool {
+	if fraction <= 0 || msg.Size == nil || *msg.Size <= 0 {
+		return false
+	}
+	bytesRead := msg.ReadSize()
+	return bytesRead != nil && float64(*bytesRead) < fraction*float64(*msg.Size)
+}
+
+// suppressSmallRead returns true if the message describes a partial read that's too small to be recorded.
+func (svc *DataoneIndexer) suppressSmallRead(key string, msg *model.Message) bool {
+	if !isSmallRead(msg, svc.minReadFraction) {
+		return false
+	}
+	logger.Log.Debugf("suppressing partial read of %d of %d bytes of '%s' with routing key '%s'",
+		*msg.ReadSize(), *msg.Size, msg.Path, key)
+	countOutcome(key, outcomePartialRead)
+	return true
+}
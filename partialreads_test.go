@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// TestGetMinReadFraction verifies that every partial read is recorded by default and that fractions outside of the
+// range from zero to one are rejected.
+func TestGetMinReadFraction(t *testing.T) {
+	tests := []struct {
+		configured interface{}
+		expected   float64
+		expectErr  bool
+	}{
+		{nil, 0, false},
+		{0.25, 0.25, false},
+		{1, 1, false},
+		{-0.5, 0, true},
+		{1.5, 0, true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		if test.configured != nil {
+			cfg.Set("dataone.partial-reads.min-fraction", test.configured)
+		}
+		fraction, err := getMinReadFraction(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%v: expected error: %t, got: %v", test.configured, test.expectErr, err)
+		}
+		if fraction != test.expected {
+			t.Errorf("%v: expected %g but got %g", test.configured, test.expected, fraction)
+		}
+	}
+}
+
+// TestIsSmallRead verifies that reads are only considered small if both the number of bytes read and the size of the
+// data object are known.
+func TestIsSmallRead(t *testing.T) {
+	size := int64(20000)
+	empty := int64(0)
+	bytesRead := int64(64)
+	tests := []struct {
+		name      string
+		size      *int64
+		bytesRead *int64
+		rng       string
+		fraction  float64
+		expected  bool
+	}{
+		{"small read", &size, &bytesRead, "", 0.1, true},
+		{"small range", &size, nil, "bytes=0-1999", 0.5, true},
+		{"large range", &size, nil, "bytes=0-9999", 0.5, false},
+		{"full download", &size, nil, "", 0.5, false},
+		{"unknown size", nil, &bytesRead, "", 0.5, false},
+		{"empty data object", &empty, &bytesRead, "", 0.5, false},
+		{"suppression disabled", &size, &bytesRead, "", 0, false},
+	}
+
+	for _, test := range tests {
+		msg := &model.Message{Size: test.size, BytesRead: test.bytesRead, Range: test.rng}
+		if small := isSmallRead(msg, test.fraction); small != test.expected {
+			t.Errorf("%s: expected small: %t, got: %t", test.name, test.expected, small)
+		}
+	}
+}
+
+// TestSuppressedPartialReads verifies that partial reads that are too small are acknowledged without being recorded,
+// and that reads without range information are still recorded.
+func TestSuppressedPartialReads(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		suppressed bool
+	}{
+		{"full download", `"size": 20000`, false},
+		{"small partial read", `"size": 20000, "range": "bytes=0-65"`, true},
+		{"large partial read", `"size": 20000, "bytesRead": 15000`, false},
+		{"partial read of unknown size", `"bytesRead": 10`, false},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{}
+		svc := newTestService(recorder)
+		svc.minReadFraction = 0.5
+		key := "data-object.open"
+		before := messageOutcomes.Get(key + "/" + outcomePartialRead)
+		body := []byte(`{"entity": "fakeid", "path": "/iplant/home/shared/commons_repo/curated/foo.txt", ` +
+			test.body + `}`)
+
+		if err := svc.processMessage(amqp.Delivery{RoutingKey: key, Body: body}); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		expected := int64(0)
+		if test.suppressed {
+			expected = 1
+		}
+		if count := messageOutcomes.Get(key+"/"+outcomePartialRead) - before; count != expected {
+			t.Errorf("%s: expected %d suppressed partial reads but got %d", test.name, expected, count)
+		}
+		if events := atomic.LoadInt64(&recorder.events); events != 1-expected {
+			t.Errorf("%s: expected %d recorded events but got %d", test.name, 1-expected, events)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/metrics"
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/spf13/viper"
+)
+
+// The ways in which previews of data objects may be handled. Previews are recorded as read events by default, which is
+// how they were recorded before they could be distinguished from downloads.
+const (
+	previewModeRead   = "read"
+	previewModeRecord = "record"
+	previewModeDrop   = "drop"
+)
+
+// defaultPreviewEventType is the type of the events recorded for previews when they're recorded under their own type.
+const defaultPreviewEventType = "PREVIEW"
+
+// The categories of read accesses that are counted in the periodic summary.
+const (
+	accessDownload       = "download"
+	accessPreview        = "preview"
+	accessPreviewDropped = "preview-dropped"
+)
+
+// readAccesses counts read accesses by category so that previews can be told apart from downloads.
+var readAccesses = metrics.NewCounters("read_accesses", "read accesses by category")
+
+// previewSettings describes how previews of data objects are handled. Previews are messages with one of the preview
+// routing keys or read messages with the preview access type.
+type previewSettings struct {
+	mode      string
+	eventType string
+	keys      []string
+}
+
+// getPreviewSettings loads the settings that describe how previews of data objects are handled.
+func getPreviewSettings(cfg *viper.Viper) (*previewSettings, error) {
+	settings := &previewSettings{
+		mode:      strings.ToLower(strings.TrimSpace(cfg.GetString("dataone.previews.mode"))),
+		eventType: strings.TrimSpace(cfg.GetString("dataone.previews.event-type")),
+		keys:      getRoutingKeys(cfg).Preview,
+	}
+	switch settings.mode {
+	case "":
+		settings.mode = previewModeRead
+	case previewModeRead, previewModeDrop:
+	case previewModeRecord:
+		if settings.eventType == "" {
+			settings.eventType = defaultPreviewEventType
+		}
+		if settings.eventType == database.ETRead {
+			return nil, fmt.Errorf("dataone.previews.event-type must not be %s when previews are recorded separately",
+				database.ETRead)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported preview mode: %s", settings.mode)
+	}
+	return settings, nil
+}
+
+// recordedEventType returns the type of the events recorded for previews, or an empty string if previews are recorded
+// as read events or not recorded at all.
+func (p *previewSettings) recordedEventType() string {
+	if p == nil || p.mode != previewModeRecord {
+		return ""
+	}
+	return p.eventType
+}
+
+// isPreview determines whether or not a message with the given routing key describes a preview.
+func (p *previewSettings) isPreview(key string, msg *model.Message) bool {
+	if msg.IsPreview() {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	for _, pattern := range p.keys {
+		if database.MatchRoutingKey(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropPreview returns true if the message describes a preview and previews are dropped rather than recorded.
+func (svc *DataoneIndexer) dropPreview(key string, msg *model.Message) bool {
+	if svc.previews == nil || svc.previews.mode != previewModeDrop || !svc.previews.isPreview(key, msg) {
+		return false
+	}
+	logger.Log.Debugf("dropping preview of '%s' with routing key '%s'", msg.Path, key)
+	readAccesses.Inc(accessPreviewDropped)
+	countOutcome(key, outcomePreviewDropped)
+	return true
+}
+
+// countReadAccess counts a recorded event in the read access summaries if it describes a download or a preview.
+func (svc *DataoneIndexer) countReadAccess(key string, msg *model.Message, event *database.Event) {
+	preview := svc.previews.isPreview(key, msg)
+	switch {
+	case preview && (event.Type == database.ETRead || event.Type == svc.previews.recordedEventType()):
+		readAccesses.Inc(accessPreview)
+	case event.Type == database.ETRead:
+		readAccesses.Inc(accessDownload)
+	default:
+		return
+	}
+	countReader(msg)
+}
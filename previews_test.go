@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// previewTestBody is the body of a read message with the preview access type.
+var previewTestBody = []byte(
+	`{"entity": "fakeid", "path": "/iplant/home/shared/commons_repo/curated/foo.txt", "accessType": "preview"}`,
+)
+
+// TestGetPreviewSettings verifies that previews are recorded as read events by default, that the preview event type
+// is only used when previews are recorded separately and that invalid settings are rejected.
+func TestGetPreviewSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		eventType string
+		expected  *previewSettings
+		problem   string
+	}{
+		{"default", "", "", &previewSettings{mode: previewModeRead}, ""},
+		{"read", "read", "PREVIEW", &previewSettings{mode: previewModeRead, eventType: "PREVIEW"}, ""},
+		{"record", "Record", "VIEW", &previewSettings{mode: previewModeRecord, eventType: "VIEW"}, ""},
+		{"record default", "record", "", &previewSettings{mode: previewModeRecord, eventType: "PREVIEW"}, ""},
+		{"drop", "drop", "", &previewSettings{mode: previewModeDrop}, ""},
+		{"record as read", "record", "READ", nil, "must not be READ"},
+		{"unsupported", "ignore", "", nil, "unsupported preview mode: ignore"},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("dataone.previews.mode", test.mode)
+		cfg.Set("dataone.previews.event-type", test.eventType)
+		cfg.Set("dataone.amqp-routing-keys", map[string]interface{}{"preview": "data-object.preview"})
+		settings, err := getPreviewSettings(cfg)
+		if test.problem != "" {
+			if err == nil || !strings.Contains(err.Error(), test.problem) {
+				t.Errorf("%s: expected an error mentioning %q but got %v", test.name, test.problem, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		test.expected.keys = []string{"data-object.preview"}
+		if !reflect.DeepEqual(settings, test.expected) {
+			t.Errorf("%s: expected %+v but got %+v", test.name, test.expected, settings)
+		}
+	}
+}
+
+// TestRecordedEventType verifies that the preview event type is only passed to the recorder when previews are recorded
+// separately.
+func TestRecordedEventType(t *testing.T) {
+	tests := []struct {
+		settings *previewSettings
+		expected string
+	}{
+		{nil, ""},
+		{&previewSettings{mode: previewModeRead, eventType: "PREVIEW"}, ""},
+		{&previewSettings{mode: previewModeRecord, eventType: "PREVIEW"}, "PREVIEW"},
+		{&previewSettings{mode: previewModeDrop, eventType: "PREVIEW"}, ""},
+	}
+
+	for _, test := range tests {
+		if eventType := test.settings.recordedEventType(); eventType != test.expected {
+			t.Errorf("%+v: expected %q but got %q", test.settings, test.expected, eventType)
+		}
+	}
+}
+
+// TestDroppedPreviews verifies that previews are acknowledged without being recorded when previews are dropped, whether
+// they're identified by their routing keys or by their access types, and that downloads are still recorded.
+func TestDroppedPreviews(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		body    []byte
+		dropped bool
+	}{
+		{"download", "data-object.open", testBody, false},
+		{"preview access type", "data-object.open", previewTestBody, true},
+		{"preview routing key", "data-object.preview", testBody, true},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{}
+		svc := newTestService(recorder)
+		svc.previews = &previewSettings{mode: previewModeDrop, keys: []string{"data-object.preview"}}
+		outcomes := messageOutcomes.Get(test.key + "/" + outcomePreviewDropped)
+		dropped := readAccesses.Get(accessPreviewDropped)
+
+		if err := svc.processMessage(amqp.Delivery{RoutingKey: test.key, Body: test.body}); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		expected := int64(0)
+		if test.dropped {
+			expected = 1
+		}
+		if count := messageOutcomes.Get(test.key+"/"+outcomePreviewDropped) - outcomes; count != expected {
+			t.Errorf("%s: expected %d dropped previews but got %d", test.name, expected, count)
+		}
+		if count := readAccesses.Get(accessPreviewDropped) - dropped; count != expected {
+			t.Errorf("%s: expected %d dropped previews in the summary but got %d", test.name, expected, count)
+		}
+		if events := atomic.LoadInt64(&recorder.events); events != 1-expected {
+			t.Errorf("%s: expected %d recorded events but got %d", test.name, 1-expected, events)
+		}
+	}
+}
+
+// TestReadAccessSummary verifies that recorded previews and downloads are counted separately even when previews are
+// recorded as read events.
+func TestReadAccessSummary(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     []byte
+		category string
+	}{
+		{"download", testBody, accessDownload},
+		{"preview", previewTestBody, accessPreview},
+	}
+
+	for _, test := range tests {
+		svc := newTestService(&fakeRecorder{})
+		before := readAccesses.Get(test.category)
+		if err := svc.processMessage(amqp.Delivery{RoutingKey: "data-object.open", Body: test.body}); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		if count := readAccesses.Get(test.category) - before; count != 1 {
+			t.Errorf("%s: expected 1 %s access but got %d", test.name, test.category, count)
+		}
+	}
+}
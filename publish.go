@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// defaultConfirmTimeout is the amount of time to wait for the broker to confirm a publication if no timeout is
+// configured.
+const defaultConfirmTimeout = 10 * time.Second
+
+// eventPublisher is an interface for announcing that DataONE events have been recorded.
+type eventPublisher interface {
+	publish(event *database.Event) error
+	close()
+}
+
+// recordedEventMessage is the body of the message published when a DataONE event has been recorded.
+type recordedEventMessage struct {
+	EventType string     `json:"event_type"`
+	Path      string     `json:"path"`
+	NodeID    string     `json:"node_id"`
+	EventID   int64      `json:"event_id"`
+	Timestamp *time.Time `json:"timestamp"`
+}
+
+// newRecordedEventMessage returns the message body to publish for a recorded event.
+func newRecordedEventMessage(event *database.Event) *recordedEventMessage {
+	return &recordedEventMessage{
+		EventType: event.Type,
+		Path:      event.Path,
+		NodeID:    event.NodeID,
+		EventID:   event.ID,
+		Timestamp: event.Timestamp,
+	}
+}
+
+// amqpEventPublisher publishes recorded event messages to an AMQP exchange using publisher confirms. It uses its own
+// connection so that publishing problems never interfere with message consumption. The connection is established
+// when the first message is published and re-established after any failure. Publications are serialized so that
+// each confirmation can be matched to the message it belongs to.
+type amqpEventPublisher struct {
+	mutex          sync.Mutex
+	dialer         *amqpDialer
+	exchange       string
+	exchangeType   string
+	routingKey     string
+	confirmTimeout time.Duration
+	conn           *amqp.Connection
+	ch             *amqp.Channel
+	confirms       chan amqp.Confirmation
+}
+
+// newEventPublisher returns a publisher for recorded event messages, or nil if publishing is disabled.
+func newEventPublisher(cfg *viper.Viper, dialer *amqpDialer) (*amqpEventPublisher, error) {
+	if !cfg.GetBool("amqp.publish.enabled") {
+		return nil, nil
+	}
+
+	// Load the publisher settings.
+	exchange := cfg.GetString("amqp.publish.exchange.name")
+	if exchange == "" {
+		return nil, fmt.Errorf("amqp.publish.exchange.name must be set when publishing is enabled")
+	}
+	confirmTimeout, err := getPositiveDuration(cfg, "amqp.publish.confirm-timeout", defaultConfirmTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &amqpEventPublisher{
+		dialer:         dialer,
+		exchange:       exchange,
+		exchangeType:   cfg.GetString("amqp.publish.exchange.type"),
+		routingKey:     cfg.GetString("amqp.publish.routing-key"),
+		confirmTimeout: confirmTimeout,
+	}, nil
+}
+
+// connect establishes the publisher's connection, declares the outbound exchange and places the channel in confirm
+// mode. The caller must hold the publisher's mutex.
+func (p *amqpEventPublisher) connect() error {
+	conn, err := p.dialer.dialOnce()
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		closeAmqpConnection(conn)
+		return err
+	}
+
+	err = ch.ExchangeDeclare(
+		p.exchange,     // exchange name
+		p.exchangeType, // exchange type
+		true,           // durable
+		false,          // auto-delete flag
+		false,          // internal flag
+		false,          // no-wait flag
+		nil,            // arguments
+	)
+	if err != nil {
+		closeAmqpConnection(conn)
+		return fmt.Errorf("unable to declare the exchange '%s': %s", p.exchange, err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		closeAmqpConnection(conn)
+		return fmt.Errorf("unable to enable publisher confirms: %s", err)
+	}
+
+	// Only one publication is outstanding at a time, so a single buffered slot is enough to keep the AMQP library
+	// from blocking while it delivers a confirmation.
+	p.conn = conn
+	p.ch = ch
+	p.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	return nil
+}
+
+// reset discards the publisher's connection so that a new one is established for the next publication. The caller
+// must hold the publisher's mutex.
+func (p *amqpEventPublisher) reset() {
+	if p.conn != nil {
+		closeAmqpConnection(p.conn)
+	}
+	p.conn = nil
+	p.ch = nil
+	p.confirms = nil
+}
+
+// publish publishes a recorded event message and waits for the broker to confirm it.
+func (p *amqpEventPublisher) publish(event *database.Event) error {
+	body, err := json.Marshal(newRecordedEventMessage(event))
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Establish the connection if necessary.
+	if p.ch == nil {
+		if err := p.connect(); err != nil {
+			return fmt.Errorf("unable to connect the publisher: %s", err)
+		}
+	}
+
+	// Publish the message.
+	err = p.ch.Publish(
+		p.exchange,   // exchange name
+		p.routingKey, // routing key
+		false,        // mandatory flag
+		false,        // immediate flag
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Timestamp:    time.Now(),
+			Body:         body,
+		},
+	)
+	if err != nil {
+		p.reset()
+		return err
+	}
+
+	// Wait for the confirmation. The connection is discarded if none arrives because a late confirmation would
+	// otherwise be mistaken for the confirmation of the next publication.
+	select {
+	case confirmation, ok := <-p.confirms:
+		if !ok {
+			p.reset()
+			return fmt.Errorf("the channel was closed before the publication was confirmed")
+		}
+		if !confirmation.Ack {
+			return fmt.Errorf("the broker did not accept the publication")
+		}
+		return nil
+	case <-time.After(p.confirmTimeout):
+		p.reset()
+		return fmt.Errorf("the publication was not confirmed within %s", p.confirmTimeout)
+	}
+}
+
+// close closes the publisher's connection.
+func (p *amqpEventPublisher) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.reset()
+	logger.Log.Info("closed the event publisher connection")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/spf13/viper"
+)
+
+// TestRecordedEventMessage verifies that recorded event messages are encoded as expected.
+func TestRecordedEventMessage(t *testing.T) {
+	timestamp := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	event := &database.Event{
+		ID:        42,
+		Type:      database.ETRead,
+		Path:      "/iplant/home/shared/commons_repo/curated/foo.txt",
+		NodeID:    "fakenode",
+		Timestamp: &timestamp,
+	}
+
+	body, err := json.Marshal(newRecordedEventMessage(event))
+	if err != nil {
+		t.Fatalf("unable to encode the message: %s", err)
+	}
+
+	expected := `{"event_type":"READ","path":"/iplant/home/shared/commons_repo/curated/foo.txt",` +
+		`"node_id":"fakenode","event_id":42,"timestamp":"2018-01-02T03:04:05Z"}`
+	if string(body) != expected {
+		t.Errorf("expected %s but got %s", expected, body)
+	}
+}
+
+// TestEventPublisherSettings verifies that the publisher is only created when publishing is enabled, and that its
+// settings are validated.
+func TestEventPublisherSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		enabled  bool
+		valid    bool
+	}{
+		{"disabled", map[string]interface{}{}, false, true},
+		{"enabled", map[string]interface{}{"amqp.publish.enabled": true}, true, true},
+		{
+			"no exchange",
+			map[string]interface{}{"amqp.publish.enabled": true, "amqp.publish.exchange.name": ""},
+			false, false,
+		},
+		{
+			"negative timeout",
+			map[string]interface{}{"amqp.publish.enabled": true, "amqp.publish.confirm-timeout": "-1s"},
+			false, false,
+		},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("amqp.publish.exchange.name", "dataone")
+		for key, value := range test.settings {
+			cfg.Set(key, value)
+		}
+
+		publisher, err := newEventPublisher(cfg, &amqpDialer{})
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: an error was expected but none was encountered", test.name)
+		}
+		if test.enabled != (publisher != nil) {
+			t.Errorf("%s: expected enabled to be %t", test.name, test.enabled)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// rateLimiter is a token bucket used to limit the rate at which events are recorded. The bucket holds up to one
+// second's worth of tokens, so short bursts are allowed as long as the average rate stays within the limit. A
+// rateLimiter is safe for concurrent use by multiple goroutines.
+type rateLimiter struct {
+	mutex    sync.Mutex
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+// newRateLimiter creates a rate limiter that allows the given number of events per second.
+func newRateLimiter(rate float64) *rateLimiter {
+	capacity := math.Max(1, math.Ceil(rate))
+	return &rateLimiter{
+		rate:     rate,
+		capacity: capacity,
+		tokens:   capacity,
+		last:     time.Now(),
+		now:      time.Now,
+		sleep:    time.Sleep,
+	}
+}
+
+// getRateLimiter returns the rate limiter described by the configuration, or nil if the rate is unlimited.
+func getRateLimiter(cfg *viper.Viper) (*rateLimiter, error) {
+	rate := cfg.GetFloat64("dataone.max-events-per-second")
+	if rate < 0 {
+		return nil, fmt.Errorf("dataone.max-events-per-second must not be negative: %g", rate)
+	}
+	if rate == 0 {
+		return nil, nil
+	}
+	return newRateLimiter(rate), nil
+}
+
+// reserve takes a token from the bucket and returns the amount of time that the caller must wait before using it.
+// The bucket may go into debt so that callers waiting concurrently are spaced evenly rather than all waking at once.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Add the tokens that have accumulated since the last reservation.
+	now := l.now()
+	l.tokens = math.Min(l.capacity, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	// Take a token.
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// wait blocks until the caller is permitted to record an event.
+func (l *rateLimiter) wait() {
+	if delay := l.reserve(); delay > 0 {
+		l.sleep(delay)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// TestRateLimiter verifies that the rate limiter allows an initial burst and then spaces events evenly.
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	var delays []time.Duration
+	limiter := newRateLimiter(2)
+	limiter.last = now
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(d time.Duration) { delays = append(delays, d) }
+
+	// The first two events fit in the bucket. The next two must wait for tokens to accumulate.
+	for i := 0; i < 4; i++ {
+		limiter.wait()
+	}
+	expected := []time.Duration{500 * time.Millisecond, time.Second}
+	if len(delays) != len(expected) {
+		t.Fatalf("expected delays %v but got %v", expected, delays)
+	}
+	for i := range expected {
+		if delays[i] != expected[i] {
+			t.Errorf("expected delays %v but got %v", expected, delays)
+		}
+	}
+
+	// After enough time passes, the bucket should be full again.
+	now = now.Add(time.Minute)
+	delays = nil
+	limiter.wait()
+	limiter.wait()
+	if len(delays) != 0 {
+		t.Errorf("expected no delays after the bucket refilled but got %v", delays)
+	}
+}
+
+// TestGetRateLimiter verifies that a rate of zero means unlimited and that negative rates are rejected.
+func TestGetRateLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		limited bool
+		valid   bool
+	}{
+		{"unlimited", 0, false, true},
+		{"limited", 100, true, true},
+		{"fractional", 0.5, true, true},
+		{"negative", -1, false, false},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("dataone.max-events-per-second", test.rate)
+		limiter, err := getRateLimiter(cfg)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: an error was expected but none was encountered", test.name)
+		}
+		if test.limited != (limiter != nil) {
+			t.Errorf("%s: expected limited to be %t", test.name, test.limited)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// replaySettings describes how messages stored in the event_errors table are replayed.
+type replaySettings struct {
+	retention time.Duration
+	batchSize int
+}
+
+// getReplaySettings loads the settings used to replay messages stored in the event_errors table. A retention period of
+// zero means that replayed messages are never removed from the table.
+func getReplaySettings(cfg *viper.Viper) (*replaySettings, error) {
+	retention := cfg.GetDuration("dataone.event-errors.retention")
+	if retention < 0 {
+		return nil, fmt.Errorf("dataone.event-errors.retention must not be negative")
+	}
+	batchSize := cfg.GetInt("dataone.event-errors.batch-size")
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("dataone.event-errors.batch-size must be positive")
+	}
+	return &replaySettings{retention: retention, batchSize: batchSize}, nil
+}
+
+// replayErrors processes each message stored in the event_errors table that hasn't been replayed successfully. Messages
+// that are processed successfully are marked as resolved; the others remain in the table with an updated error
+// message and attempt count. The return values are the numbers of messages that were resolved and that failed again.
+func (svc *DataoneIndexer) replayErrors(ctx context.Context, batchSize int) (int, int, error) {
+	var resolved, failed int
+	var afterID int64
+	for {
+		pending, err := database.PendingEventErrors(ctx, svc.db, afterID, batchSize)
+		if err != nil {
+			return resolved, failed, fmt.Errorf("unable to list stored messages: %s", err)
+		}
+
+		for _, e := range pending {
+			afterID = e.ID
+			delivery := amqp.Delivery{RoutingKey: e.Key, Body: e.Body}
+			if procErr := svc.processMessage(delivery); procErr != nil {
+				logger.Log.Errorf("unable to replay stored message %d: %s", e.ID, procErr)
+				if err := database.UpdateEventError(ctx, svc.db, e.ID, procErr.Error()); err != nil {
+					return resolved, failed, fmt.Errorf("unable to update stored message %d: %s", e.ID, err)
+				}
+				failed++
+				continue
+			}
+			if err := database.ResolveEventError(ctx, svc.db, e.ID); err != nil {
+				return resolved, failed, fmt.Errorf("unable to resolve stored message %d: %s", e.ID, err)
+			}
+			resolved++
+		}
+
+		if len(pending) < batchSize {
+			return resolved, failed, nil
+		}
+	}
+}
+
+// pruneErrors removes messages that were replayed successfully more than the retention period ago from the
+// event_errors table.
+func (svc *DataoneIndexer) pruneErrors(ctx context.Context, retention time.Duration) error {
+	if retention <= 0 {
+		return nil
+	}
+	pruned, err := database.PruneEventErrors(ctx, svc.db, time.Now().Add(-retention))
+	if err != nil {
+		return fmt.Errorf("unable to prune stored messages: %s", err)
+	}
+	logger.Log.Infof("removed %d replayed messages older than %s", pruned, retention)
+	return nil
+}
+
+// replay replays the messages stored in the event_errors table and removes messages that were replayed successfully
+// once the retention period has elapsed.
+func replay() {
+	svc := initService()
+	defer svc.db.Close()
+
+	settings, err := getReplaySettings(svc.cfg)
+	if err != nil {
+		logger.Log.Fatalf("invalid event error settings: %s", err)
+	}
+
+	resolved, failed, err := svc.replayErrors(svc.ctx, settings.batchSize)
+	logger.Log.Infof("replayed stored messages: %d resolved, %d failed", resolved, failed)
+	if err != nil {
+		logger.Log.Fatalf("unable to replay stored messages: %s", err)
+	}
+	if err := svc.pruneErrors(svc.ctx, settings.retention); err != nil {
+		logger.Log.Fatalf("%s", err)
+	}
+	if svc.publisher != nil {
+		svc.publisher.close()
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestGetReplaySettings verifies that the settings used to replay stored messages are loaded and validated correctly.
+func TestGetReplaySettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention string
+		batchSize int
+		expectErr bool
+	}{
+		{"defaults", "720h", 100, false},
+		{"no pruning", "0s", 100, false},
+		{"negative retention", "-1h", 100, true},
+		{"zero batch size", "720h", 0, true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("dataone.event-errors.retention", test.retention)
+		cfg.Set("dataone.event-errors.batch-size", test.batchSize)
+
+		settings, err := getReplaySettings(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+		}
+		if err == nil && settings.batchSize != test.batchSize {
+			t.Errorf("%s: expected batch size %d, got %d", test.name, test.batchSize, settings.batchSize)
+		}
+	}
+}
+
+// TestReplayErrors verifies that stored messages are replayed in pages, and that the outcome of each replay is
+// recorded.
+func TestReplayErrors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	recorder := &fakeRecorder{}
+	svc := newTestService(recorder)
+	svc.db = db
+
+	// The first page contains a message that can be recorded and one that can't be decoded.
+	now := time.Now()
+	columns := []string{"id", "routing_key", "body", "error", "attempts", "first_failed_at", "last_failed_at"}
+	mock.ExpectQuery("SELECT (.+) FROM event_errors").
+		WithArgs(int64(0), 2).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(3, "data-object.open", testBody, "connection refused", 5, now, now).
+			AddRow(7, "data-object.open", malformedTestBody, "connection refused", 5, now, now))
+	mock.ExpectExec("UPDATE event_errors SET resolved_at").
+		WithArgs(sqlmock.AnyArg(), int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE event_errors SET error").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// The second page is empty.
+	mock.ExpectQuery("SELECT (.+) FROM event_errors").
+		WithArgs(int64(7), 2).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	resolved, failed, err := svc.replayErrors(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("error encountered while replaying stored messages: %s", err)
+	}
+	if resolved != 1 || failed != 1 {
+		t.Errorf("expected 1 resolved and 1 failed message but got %d and %d", resolved, failed)
+	}
+	if recorder.events != 1 {
+		t.Errorf("expected 1 recorded event but got %d", recorder.events)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestPruneErrors verifies that replayed messages are only pruned if a retention period is configured.
+func TestPruneErrors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	svc := newTestService(&fakeRecorder{})
+	svc.db = db
+
+	mock.ExpectExec("DELETE FROM event_errors").WillReturnResult(sqlmock.NewResult(0, 2))
+	if err := svc.pruneErrors(context.Background(), 0); err != nil {
+		t.Errorf("error encountered without a retention period: %s", err)
+	}
+	if err := svc.pruneErrors(context.Background(), time.Hour); err != nil {
+		t.Errorf("error encountered while pruning replayed messages: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
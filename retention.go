@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/spf13/viper"
+)
+
+// Default retention settings.
+const (
+	defaultRetentionInterval  = 24 * time.Hour
+	defaultRetentionBatchSize = 1000
+)
+
+// retentionBatchTimeout is the amount of time allowed for removing each batch of old events.
+const retentionBatchTimeout = time.Minute
+
+// retentionSettings describes how old events are removed from the event log.
+type retentionSettings struct {
+	maxAge       time.Duration
+	interval     time.Duration
+	batchSize    int
+	archiveTable string
+}
+
+// getRetentionSettings returns the retention settings described by the configuration, or nil if old events are
+// retained indefinitely, which is the case unless a maximum age is configured.
+func getRetentionSettings(cfg *viper.Viper) (*retentionSettings, error) {
+	maxAge := cfg.GetDuration("db.retention.max-age")
+	if maxAge < 0 {
+		return nil, fmt.Errorf("db.retention.max-age must not be negative: %s", maxAge)
+	}
+	if maxAge == 0 {
+		return nil, nil
+	}
+
+	// Load the interval between runs.
+	interval, err := getPositiveDuration(cfg, "db.retention.interval", defaultRetentionInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the batch size.
+	batchSize := cfg.GetInt("db.retention.batch-size")
+	if batchSize < 0 {
+		return nil, fmt.Errorf("db.retention.batch-size must not be negative: %d", batchSize)
+	}
+	if batchSize == 0 {
+		batchSize = defaultRetentionBatchSize
+	}
+
+	return &retentionSettings{
+		maxAge:       maxAge,
+		interval:     interval,
+		batchSize:    batchSize,
+		archiveTable: cfg.GetString("db.retention.archive-table"),
+	}, nil
+}
+
+// removeOldEvents removes the events that are older than the maximum age from the event log in batches, stopping
+// early if the stop channel is closed. It returns the number of events that were removed.
+func (svc *DataoneIndexer) removeOldEvents(rs *retentionSettings) (int64, error) {
+	parent := svc.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	cutoff := time.Now().Add(-rs.maxAge)
+	var total int64
+	for {
+		ctx, cancel := context.WithTimeout(parent, retentionBatchTimeout)
+		removed, err := database.PruneEvents(ctx, svc.db, cutoff, rs.batchSize, rs.archiveTable)
+		cancel()
+		total += removed
+		if err != nil || removed < int64(rs.batchSize) {
+			return total, err
+		}
+
+		select {
+		case <-svc.stop:
+			return total, nil
+		default:
+		}
+	}
+}
+
+// enforceRetention removes old events once and logs the outcome. Only the leader removes events if leader election
+// is enabled.
+func (svc *DataoneIndexer) enforceRetention(rs *retentionSettings) {
+	if svc.leader != nil && !svc.leader.leading() {
+		return
+	}
+
+	disposition := "deleted"
+	if rs.archiveTable != "" {
+		disposition = fmt.Sprintf("moved to %s", rs.archiveTable)
+	}
+	start := time.Now()
+	removed, err := svc.removeOldEvents(rs)
+	if err != nil {
+		logger.Log.Errorf(
+			"unable to remove events older than %s; %d events %s in %s: %s",
+			rs.maxAge, removed, disposition, time.Since(start), err,
+		)
+		return
+	}
+	logger.Log.Infof("%d events older than %s %s in %s", removed, rs.maxAge, disposition, time.Since(start))
+}
+
+// runRetention removes old events when the service starts and at the configured interval afterwards until the stop
+// channel is closed.
+func (svc *DataoneIndexer) runRetention(rs *retentionSettings) {
+	svc.enforceRetention(rs)
+	ticker := time.NewTicker(rs.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-svc.stop:
+			return
+		case <-ticker.C:
+			svc.enforceRetention(rs)
+		}
+	}
+}
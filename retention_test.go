@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// TestGetRetentionSettings verifies that retention settings are loaded and validated correctly.
+func TestGetRetentionSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxAge    string
+		batchSize int
+		expectErr bool
+		expectNil bool
+		expected  int
+	}{
+		{"unset", "", 100, false, true, 0},
+		{"zero", "0s", 100, false, true, 0},
+		{"enabled", "2160h", 100, false, false, 100},
+		{"default batch size", "2160h", 0, false, false, defaultRetentionBatchSize},
+		{"negative batch size", "2160h", -1, true, false, 0},
+		{"negative maximum age", "-1h", 100, true, false, 0},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("db.retention.max-age", test.maxAge)
+		cfg.Set("db.retention.batch-size", test.batchSize)
+		rs, err := getRetentionSettings(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+			continue
+		}
+		if test.expectErr {
+			continue
+		}
+		if (rs == nil) != test.expectNil {
+			t.Errorf("%s: expected nil settings: %t, got: %+v", test.name, test.expectNil, rs)
+			continue
+		}
+		if rs != nil && rs.batchSize != test.expected {
+			t.Errorf("%s: expected batch size %d, got %d", test.name, test.expected, rs.batchSize)
+		}
+		if rs != nil && rs.interval != defaultRetentionInterval {
+			t.Errorf("%s: expected the default interval, got %s", test.name, rs.interval)
+		}
+	}
+}
+
+// TestRemoveOldEvents verifies that old events are removed in batches until a batch removes fewer events than the
+// batch size.
+func TestRemoveOldEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database connection: %s", err)
+	}
+	defer db.Close()
+
+	for _, removed := range []int64{10, 10, 3} {
+		mock.ExpectExec("DELETE FROM event_log").
+			WithArgs(sqlmock.AnyArg(), 10).
+			WillReturnResult(sqlmock.NewResult(0, removed))
+	}
+
+	svc := newTestService(&fakeRecorder{})
+	svc.db = db
+	svc.stop = make(chan struct{})
+	removed, err := svc.removeOldEvents(&retentionSettings{maxAge: time.Hour, batchSize: 10})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if removed != 23 {
+		t.Errorf("expected 23 events to be removed but got %d", removed)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
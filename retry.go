@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// Headers used to keep track of messages that are being retried.
+const (
+	retryAttemptsHeader   = "x-dataone-retry-attempts"
+	retryRoutingKeyHeader = "x-dataone-routing-key"
+)
+
+// amqpPublisher is the subset of the AMQP channel interface used to publish messages.
+type amqpPublisher interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// retrySettings describes the topology used to retry messages after a delay. Messages that fail with transient
+// errors are published to the retry queue, which holds them until the message TTL expires and then dead-letters them
+// back to the main queue through the default exchange.
+type retrySettings struct {
+	exchange    string
+	queue       string
+	ttl         time.Duration
+	maxAttempts int
+}
+
+// getRetrySettings returns the delayed retry settings from the configuration, or nil if delayed retries are disabled.
+func getRetrySettings(cfg *viper.Viper) (*retrySettings, error) {
+	if !cfg.GetBool("amqp.retry.enabled") {
+		return nil, nil
+	}
+
+	rs := &retrySettings{
+		exchange:    cfg.GetString("amqp.retry.exchange"),
+		queue:       cfg.GetString("amqp.retry.queue"),
+		ttl:         cfg.GetDuration("amqp.retry.ttl"),
+		maxAttempts: cfg.GetInt("amqp.retry.max-attempts"),
+	}
+	if rs.queue == "" {
+		return nil, fmt.Errorf("amqp.retry.queue must be set when delayed retries are enabled")
+	}
+	if rs.ttl < time.Millisecond {
+		return nil, fmt.Errorf("amqp.retry.ttl must be at least 1ms: %s", rs.ttl)
+	}
+	if rs.maxAttempts <= 0 {
+		return nil, fmt.Errorf("amqp.retry.max-attempts must be positive: %d", rs.maxAttempts)
+	}
+	return rs, nil
+}
+
+// queueArguments returns the arguments used to declare the retry queue.
+func (rs *retrySettings) queueArguments(mainQueue string) amqp.Table {
+	return amqp.Table{
+		"x-message-ttl":             int64(rs.ttl / time.Millisecond),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": mainQueue,
+	}
+}
+
+// declareRetryTopology declares the retry queue, along with the retry exchange if one is configured. Expired messages
+// are routed back to the main queue by name so that they aren't delivered to other queues bound to the main exchange.
+func declareRetryTopology(ch *amqp.Channel, rs *retrySettings, mainQueue string) error {
+	_, err := ch.QueueDeclare(
+		rs.queue,                     // queue name
+		true,                         // queue durable
+		false,                        // queue auto-delete flag
+		false,                        // queue exclusive flag
+		false,                        // queue no-wait flag
+		rs.queueArguments(mainQueue), // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("unable to declare the retry queue '%s': %s", rs.queue, err)
+	}
+
+	// Messages are published directly to the retry queue through the default exchange unless an exchange is named.
+	if rs.exchange == "" {
+		return nil
+	}
+
+	err = ch.ExchangeDeclare(
+		rs.exchange, // exchange name
+		"direct",    // exchange type
+		true,        // durable
+		false,       // auto-delete flag
+		false,       // internal flag
+		false,       // no-wait flag
+		nil,         // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("unable to declare the retry exchange '%s': %s", rs.exchange, err)
+	}
+
+	logger.Log.Infof("binding key '%s' in exchange '%s' to queue '%s'", rs.queue, rs.exchange, rs.queue)
+	err = ch.QueueBind(
+		rs.queue,    // queue name
+		rs.queue,    // routing key
+		rs.exchange, // exchange name
+		false,       // no-wait flag
+		nil,         // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("unable to bind the retry queue '%s': %s", rs.queue, err)
+	}
+
+	return nil
+}
+
+// headerInt converts an integer header value to an int. Zero is returned if the value isn't an integer.
+func headerInt(value interface{}) int {
+	switch v := value.(type) {
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+// retryAttempts returns the number of times that a message has already been retried.
+func retryAttempts(headers amqp.Table) int {
+	return headerInt(headers[retryAttemptsHeader])
+}
+
+// deathCount returns the total number of times that a message has been dead-lettered according to the x-death header
+// that RabbitMQ adds to dead-lettered messages.
+func deathCount(headers amqp.Table) int {
+	deaths, ok := headers["x-death"].([]interface{})
+	if !ok {
+		return 0
+	}
+
+	total := 0
+	for _, death := range deaths {
+		if table, ok := death.(amqp.Table); ok {
+			total += headerInt(table["count"])
+		}
+	}
+	return total
+}
+
+// deliveryAttempts returns the number of times that processing a message has already failed. The count is taken from
+// the retry header that the service adds when it republishes a message, the x-death header, or the x-delivery-count
+// header that quorum queues add to redelivered messages, whichever is largest.
+func deliveryAttempts(headers amqp.Table) int {
+	attempts := retryAttempts(headers)
+	if count := deathCount(headers); count > attempts {
+		attempts = count
+	}
+	if count := headerInt(headers["x-delivery-count"]); count > attempts {
+		attempts = count
+	}
+	return attempts
+}
+
+// originalRoutingKey returns the routing key that a message was originally published with. Messages that come back
+// from the retry queue carry their original routing key in a header. Messages that were dead-lettered by RabbitMQ
+// carry it in the x-death header instead, whose last entry describes the first time the message was dead-lettered.
+func originalRoutingKey(delivery amqp.Delivery) string {
+	if key, ok := delivery.Headers[retryRoutingKeyHeader].(string); ok && key != "" {
+		return key
+	}
+	if deaths, ok := delivery.Headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+		if table, ok := deaths[len(deaths)-1].(amqp.Table); ok {
+			if keys, ok := table["routing-keys"].([]interface{}); ok && len(keys) > 0 {
+				if key, ok := keys[0].(string); ok && key != "" {
+					return key
+				}
+			}
+		}
+	}
+	return delivery.RoutingKey
+}
+
+// publish publishes a copy of a delivery to the retry queue, recording the number of attempts and the original routing
+// key in its headers.
+func (rs *retrySettings) publish(publisher amqpPublisher, delivery amqp.Delivery, attempts int) error {
+	return republish(publisher, rs.exchange, rs.queue, delivery, attempts)
+}
+
+// republish publishes a copy of a delivery, recording the number of attempts and the original routing key in its
+// headers.
+func republish(publisher amqpPublisher, exchange, key string, delivery amqp.Delivery, attempts int) error {
+	headers := amqp.Table{}
+	for name, value := range delivery.Headers {
+		headers[name] = value
+	}
+	headers[retryAttemptsHeader] = int32(attempts)
+	headers[retryRoutingKeyHeader] = originalRoutingKey(delivery)
+
+	return publisher.Publish(
+		exchange, // exchange name
+		key,      // routing key
+		false,    // mandatory flag
+		false,    // immediate flag
+		amqp.Publishing{
+			Headers:         headers,
+			ContentType:     delivery.ContentType,
+			ContentEncoding: delivery.ContentEncoding,
+			DeliveryMode:    amqp.Persistent,
+			CorrelationId:   delivery.CorrelationId,
+			MessageId:       delivery.MessageId,
+			Timestamp:       delivery.Timestamp,
+			Type:            delivery.Type,
+			AppId:           delivery.AppId,
+			Body:            delivery.Body,
+		},
+	)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// TestGetRetrySettings verifies that the delayed retry settings are validated.
+func TestGetRetrySettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		enabled  bool
+		valid    bool
+	}{
+		{"disabled", map[string]interface{}{"amqp.retry.enabled": false}, false, true},
+		{"enabled", map[string]interface{}{}, true, true},
+		{"no queue", map[string]interface{}{"amqp.retry.queue": ""}, false, false},
+		{"no ttl", map[string]interface{}{"amqp.retry.ttl": "0s"}, false, false},
+		{"no attempts", map[string]interface{}{"amqp.retry.max-attempts": 0}, false, false},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("amqp.retry.enabled", true)
+		cfg.Set("amqp.retry.queue", "dataone.events.retry")
+		cfg.Set("amqp.retry.ttl", "30s")
+		cfg.Set("amqp.retry.max-attempts", 5)
+		for key, value := range test.settings {
+			cfg.Set(key, value)
+		}
+
+		rs, err := getRetrySettings(cfg)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: an error was expected but none was encountered", test.name)
+		}
+		if test.enabled != (rs != nil) {
+			t.Errorf("%s: expected enabled to be %t", test.name, test.enabled)
+		}
+	}
+}
+
+// TestRetryQueueArguments verifies that the retry queue expires messages back to the main queue.
+func TestRetryQueueArguments(t *testing.T) {
+	rs := &retrySettings{queue: "dataone.events.retry", ttl: 30 * time.Second, maxAttempts: 5}
+	args := rs.queueArguments("dataone.events")
+	if args["x-message-ttl"] != int64(30000) {
+		t.Errorf("unexpected message TTL: %v", args["x-message-ttl"])
+	}
+	if args["x-dead-letter-exchange"] != "" || args["x-dead-letter-routing-key"] != "dataone.events" {
+		t.Errorf("unexpected dead-letter arguments: %v", args)
+	}
+	if err := args.Validate(); err != nil {
+		t.Errorf("invalid queue arguments: %s", err)
+	}
+}
+
+// TestRetryPublish verifies that retried messages record the number of attempts and the original routing key.
+func TestRetryPublish(t *testing.T) {
+	rs := &retrySettings{exchange: "dataone.retry", queue: "dataone.events.retry", ttl: time.Second, maxAttempts: 5}
+	publisher := &fakeAmqpPublisher{}
+	delivery := amqp.Delivery{
+		RoutingKey: "data-object.open",
+		Headers:    amqp.Table{"foo": "bar"},
+		Body:       testBody,
+	}
+
+	if err := rs.publish(publisher, delivery, 1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(publisher.published) != 1 || publisher.keys[0] != "dataone.events.retry" {
+		t.Fatalf("unexpected publications: %v", publisher.keys)
+	}
+
+	// The retried copy should carry the original routing key and the number of attempts.
+	retried := amqp.Delivery{RoutingKey: "dataone.events", Headers: publisher.published[0].Headers}
+	if key := originalRoutingKey(retried); key != "data-object.open" {
+		t.Errorf("expected the original routing key but got %s", key)
+	}
+	if attempts := retryAttempts(retried.Headers); attempts != 1 {
+		t.Errorf("expected 1 attempt but got %d", attempts)
+	}
+	if retried.Headers["foo"] != "bar" {
+		t.Error("the original headers were not preserved")
+	}
+	if len(delivery.Headers) != 1 {
+		t.Error("the original delivery headers were modified")
+	}
+}
+
+// TestOriginalRoutingKey verifies that the original routing key is taken from the retry header or the x-death header
+// if either is present, and that the delivery's routing key is used otherwise.
+func TestOriginalRoutingKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  amqp.Table
+		expected string
+	}{
+		{"no headers", nil, "dataone.events"},
+		{"retry header", amqp.Table{retryRoutingKeyHeader: "data-object.open"}, "data-object.open"},
+		{
+			"x-death",
+			amqp.Table{"x-death": []interface{}{
+				amqp.Table{"queue": "dataone.events.retry", "routing-keys": []interface{}{"dataone.events.retry"}},
+				amqp.Table{"queue": "dataone.events", "routing-keys": []interface{}{"data-object.add"}},
+			}},
+			"data-object.add",
+		},
+		{"invalid x-death", amqp.Table{"x-death": []interface{}{amqp.Table{"routing-keys": "foo"}}}, "dataone.events"},
+	}
+
+	for _, test := range tests {
+		delivery := amqp.Delivery{RoutingKey: "dataone.events", Headers: test.headers}
+		if actual := originalRoutingKey(delivery); actual != test.expected {
+			t.Errorf("%s: expected %s but got %s", test.name, test.expected, actual)
+		}
+	}
+}
+
+// TestDeliveryAttempts verifies that previous attempts are counted using the retry, x-death and x-delivery-count
+// headers.
+func TestDeliveryAttempts(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  amqp.Table
+		expected int
+	}{
+		{"no headers", nil, 0},
+		{"retry header", amqp.Table{retryAttemptsHeader: int32(2)}, 2},
+		{
+			"x-death",
+			amqp.Table{"x-death": []interface{}{
+				amqp.Table{"count": int64(2), "reason": "expired", "queue": "dataone.events.retry"},
+				amqp.Table{"count": int64(1), "reason": "rejected", "queue": "dataone.events"},
+			}},
+			3,
+		},
+		{"x-delivery-count", amqp.Table{"x-delivery-count": int64(4)}, 4},
+		{
+			"largest count",
+			amqp.Table{retryAttemptsHeader: int32(3), "x-delivery-count": int64(1)},
+			3,
+		},
+		{"invalid x-death", amqp.Table{"x-death": "foo"}, 0},
+	}
+
+	for _, test := range tests {
+		if actual := deliveryAttempts(test.headers); actual != test.expected {
+			t.Errorf("%s: expected %d but got %d", test.name, test.expected, actual)
+		}
+	}
+}
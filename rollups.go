@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/logger"
+)
+
+// rollupDateFormat is the format of the dates accepted by the rebuild-rollups command.
+const rollupDateFormat = "2006-01-02"
+
+// parseRollupRange parses the first and last days whose event counts should be rebuilt, which are both included in
+// the range. The returned times are the start of the first day and the start of the day after the last day in UTC.
+func parseRollupRange(from, to string) (time.Time, time.Time, error) {
+	first, err := time.Parse(rollupDateFormat, from)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid first day '%s': expected YYYY-MM-DD", from)
+	}
+	last, err := time.Parse(rollupDateFormat, to)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid last day '%s': expected YYYY-MM-DD", to)
+	}
+	if last.Before(first) {
+		return time.Time{}, time.Time{}, fmt.Errorf("the last day (%s) is before the first day (%s)", to, from)
+	}
+	return first, last.AddDate(0, 0, 1), nil
+}
+
+// rebuildRollups regenerates the daily event counts for a range of days from the event log.
+func rebuildRollups() {
+	cfg := initConfig()
+	if !cfg.GetBool("db.rollups.enabled") {
+		logger.Log.Fatalf("daily event counts are disabled; set db.rollups.enabled to rebuild them")
+	}
+	first, end, err := parseRollupRange(*rollupsFrom, *rollupsTo)
+	if err != nil {
+		logger.Log.Fatalf("invalid date range: %s", err)
+	}
+
+	db := initDatabase(cfg)
+	defer db.Close()
+
+	start := time.Now()
+	written, err := database.RebuildRollups(context.Background(), db, first, end)
+	if err != nil {
+		logger.Log.Fatalf("unable to rebuild the daily event counts: %s", err)
+	}
+	logger.Log.Infof(
+		"rebuilt the daily event counts from %s through %s: %d rows written in %s",
+		*rollupsFrom, *rollupsTo, written, time.Since(start),
+	)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseRollupRange verifies that the range of days to rebuild is parsed and validated correctly.
+func TestParseRollupRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      string
+		to        string
+		expectErr bool
+		end       time.Time
+	}{
+		{"single day", "2019-03-01", "2019-03-01", false, time.Date(2019, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{"month", "2019-03-01", "2019-03-31", false, time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"reversed", "2019-03-02", "2019-03-01", true, time.Time{}},
+		{"invalid first day", "03/01/2019", "2019-03-01", true, time.Time{}},
+		{"invalid last day", "2019-03-01", "2019-03-32", true, time.Time{}},
+	}
+
+	for _, test := range tests {
+		first, end, err := parseRollupRange(test.from, test.to)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+			continue
+		}
+		if test.expectErr {
+			continue
+		}
+		if first.Format(rollupDateFormat) != test.from || first.Location() != time.UTC {
+			t.Errorf("%s: unexpected first day: %s", test.name, first)
+		}
+		if !end.Equal(test.end) {
+			t.Errorf("%s: expected the range to end at %s, got %s", test.name, test.end, end)
+		}
+	}
+}
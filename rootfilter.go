@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/metrics"
+	"github.com/spf13/viper"
+)
+
+// outOfRootMessages counts the messages that were discarded because they're outside of the repository roots, by
+// routing key.
+var outOfRootMessages = metrics.NewCounters("out_of_root_messages", "messages outside of the repository roots")
+
+// Default settings for monitoring the repository root filter.
+const (
+	defaultRootFilterSummaryInterval = time.Hour
+	defaultRootFilterWarningWindow   = time.Hour
+)
+
+// rootFilterSettings describes how the messages discarded by the repository root filter are reported.
+type rootFilterSettings struct {
+	summaryInterval time.Duration
+	warningWindow   time.Duration
+}
+
+// getRootFilterSettings extracts the repository root filter reporting settings from the configuration.
+func getRootFilterSettings(cfg *viper.Viper) (*rootFilterSettings, error) {
+	summaryInterval, err := getPositiveDuration(
+		cfg, "dataone.root-filter.summary-interval", defaultRootFilterSummaryInterval,
+	)
+	if err != nil {
+		return nil, err
+	}
+	warningWindow, err := getPositiveDuration(cfg, "dataone.root-filter.warning-window", defaultRootFilterWarningWindow)
+	if err != nil {
+		return nil, err
+	}
+	return &rootFilterSettings{summaryInterval: summaryInterval, warningWindow: warningWindow}, nil
+}
+
+// rootFilterMonitor keeps track of how many of the messages that reach the repository root filter are discarded by it.
+// A summary of the discarded messages is logged periodically, and a warning is logged if every message in a window is
+// discarded, which usually means that the repository roots are misconfigured. It's safe for concurrent use by multiple
+// goroutines.
+type rootFilterMonitor struct {
+	roots           string
+	summaryInterval time.Duration
+	warningWindow   time.Duration
+	mutex           sync.Mutex
+	summarySkipped  int64
+	windowSeen      int64
+	windowSkipped   int64
+}
+
+// newRootFilterMonitor creates a monitor for the filter that discards messages outside of the given repository roots.
+func newRootFilterMonitor(rootDirs []string, rs *rootFilterSettings) *rootFilterMonitor {
+	return &rootFilterMonitor{
+		roots:           strings.Join(rootDirs, ", "),
+		summaryInterval: rs.summaryInterval,
+		warningWindow:   rs.warningWindow,
+	}
+}
+
+// observe records whether or not a message that reached the repository root filter was discarded by it.
+func (m *rootFilterMonitor) observe(skipped bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.windowSeen++
+	if skipped {
+		m.summarySkipped++
+		m.windowSkipped++
+	}
+}
+
+// summarize logs the number of messages that were discarded since the last summary and starts counting again. Nothing
+// is logged if no messages were discarded. The number of discarded messages is returned.
+func (m *rootFilterMonitor) summarize() int64 {
+	m.mutex.Lock()
+	skipped := m.summarySkipped
+	m.summarySkipped = 0
+	m.mutex.Unlock()
+
+	if skipped > 0 {
+		logger.Log.Infof("skipped %d events outside of %s in the last %s", skipped, m.roots, m.summaryInterval)
+	}
+	return skipped
+}
+
+// checkWindow logs a warning if every message that reached the repository root filter since the last check was
+// discarded, and starts counting again. The return value indicates whether or not the warning was logged.
+func (m *rootFilterMonitor) checkWindow() bool {
+	m.mutex.Lock()
+	seen, skipped := m.windowSeen, m.windowSkipped
+	m.windowSeen, m.windowSkipped = 0, 0
+	m.mutex.Unlock()
+
+	if seen == 0 || skipped < seen {
+		return false
+	}
+	logger.Log.Warnf(
+		"all %d messages received in the last %s were outside of the repository roots (%s); "+
+			"dataone.repository-roots may be misconfigured",
+		seen, m.warningWindow, m.roots,
+	)
+	return true
+}
+
+// run logs summaries and checks for windows in which every message was discarded at the configured intervals until
+// the stop channel is closed.
+func (m *rootFilterMonitor) run(stop <-chan struct{}) {
+	summaryTicker := time.NewTicker(m.summaryInterval)
+	defer summaryTicker.Stop()
+	warningTicker := time.NewTicker(m.warningWindow)
+	defer warningTicker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-summaryTicker.C:
+			m.summarize()
+		case <-warningTicker.C:
+			m.checkWindow()
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// TestGetRootFilterSettings verifies that the repository root filter reporting settings are loaded and validated
+// correctly.
+func TestGetRootFilterSettings(t *testing.T) {
+	tests := []struct {
+		name            string
+		summaryInterval string
+		warningWindow   string
+		expectSummary   time.Duration
+		expectWindow    time.Duration
+		expectErr       bool
+	}{
+		{"configured", "30m", "2h", 30 * time.Minute, 2 * time.Hour, false},
+		{"defaults", "0s", "0s", defaultRootFilterSummaryInterval, defaultRootFilterWarningWindow, false},
+		{"negative summary interval", "-1m", "1h", 0, 0, true},
+		{"negative warning window", "1h", "-1m", 0, 0, true},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("dataone.root-filter.summary-interval", test.summaryInterval)
+		cfg.Set("dataone.root-filter.warning-window", test.warningWindow)
+
+		rs, err := getRootFilterSettings(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		if rs.summaryInterval != test.expectSummary || rs.warningWindow != test.expectWindow {
+			t.Errorf("%s: unexpected settings: %+v", test.name, rs)
+		}
+	}
+}
+
+// TestRootFilterSummary verifies that each summary reports the messages that were discarded since the previous one.
+func TestRootFilterSummary(t *testing.T) {
+	m := newRootFilterMonitor([]string{"/iplant/home/shared"}, &rootFilterSettings{time.Hour, time.Hour})
+	for _, skipped := range []bool{true, false, true, true} {
+		m.observe(skipped)
+	}
+	if skipped := m.summarize(); skipped != 3 {
+		t.Errorf("expected 3 skipped messages but got %d", skipped)
+	}
+	if skipped := m.summarize(); skipped != 0 {
+		t.Errorf("expected no skipped messages after the summary but got %d", skipped)
+	}
+}
+
+// TestRootFilterWarning verifies that a warning is logged only for windows in which every message was discarded.
+func TestRootFilterWarning(t *testing.T) {
+	tests := []struct {
+		name     string
+		observed []bool
+		expected bool
+	}{
+		{"no messages", nil, false},
+		{"all skipped", []bool{true, true, true}, true},
+		{"some recorded", []bool{true, false, true}, false},
+		{"none skipped", []bool{false, false}, false},
+	}
+
+	for _, test := range tests {
+		m := newRootFilterMonitor([]string{"/iplant/home/shared"}, &rootFilterSettings{time.Hour, time.Hour})
+		for _, skipped := range test.observed {
+			m.observe(skipped)
+		}
+		if warned := m.checkWindow(); warned != test.expected {
+			t.Errorf("%s: expected warning: %t, got: %t", test.name, test.expected, warned)
+		}
+		if warned := m.checkWindow(); warned {
+			t.Errorf("%s: expected the window to be reset after the check", test.name)
+		}
+	}
+}
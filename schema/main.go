@@ -0,0 +1,639 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// unsupportedKeywords lists the JSON Schema keywords that aren't implemented. Schemas that use them are rejected when
+// they're loaded rather than having the constraints that they describe silently ignored. Keywords that aren't listed
+// here or implemented, such as title, description and format, are accepted and ignored.
+var unsupportedKeywords = map[string]bool{
+	"additionalItems":       true,
+	"contains":              true,
+	"contentEncoding":       true,
+	"contentMediaType":      true,
+	"dependencies":          true,
+	"dependentRequired":     true,
+	"dependentSchemas":      true,
+	"else":                  true,
+	"if":                    true,
+	"maxProperties":         true,
+	"minProperties":         true,
+	"multipleOf":            true,
+	"patternProperties":     true,
+	"propertyNames":         true,
+	"then":                  true,
+	"unevaluatedItems":      true,
+	"unevaluatedProperties": true,
+	"uniqueItems":           true,
+	"$anchor":               true,
+	"$dynamicRef":           true,
+	"$recursiveRef":         true,
+}
+
+// The types that may appear in the type keyword.
+var knownTypes = map[string]bool{
+	"array": true, "boolean": true, "integer": true, "null": true, "number": true, "object": true, "string": true,
+}
+
+// Schema is a compiled JSON Schema that can be used to validate JSON documents. The keywords that are supported are
+// type, enum, const, properties, required, additionalProperties, items (with a single schema), minItems, maxItems,
+// minLength, maxLength, pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum (as numbers), allOf, anyOf,
+// oneOf, not and $ref (with references to other parts of the same schema only). Patterns use Go regular expression
+// syntax.
+type Schema struct {
+	root *node
+}
+
+// node is a compiled schema or subschema. A nil limit or pattern doesn't constrain anything.
+type node struct {
+	always           *bool
+	ref              *node
+	types            []string
+	enum             []interface{}
+	hasConst         bool
+	constant         interface{}
+	properties       map[string]*node
+	required         []string
+	additional       *node
+	items            *node
+	minItems         *int
+	maxItems         *int
+	minLength        *int
+	maxLength        *int
+	pattern          *regexp.Regexp
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	allOf            []*node
+	anyOf            []*node
+	oneOf            []*node
+	not              *node
+}
+
+// Load reads and compiles the JSON Schema in a file.
+func Load(path string) (*Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Compile(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON Schema in %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// Compile compiles a serialized JSON Schema. An error is returned if the schema isn't valid JSON, if it uses keywords
+// that aren't supported or if any keyword has an invalid value.
+func Compile(data []byte) (*Schema, error) {
+	doc, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler{doc: doc, refs: make(map[string]*node)}
+	root, err := c.compile(doc, "#")
+	if err != nil {
+		return nil, err
+	}
+
+	// References that only lead to other references would never be resolved.
+	for ref, n := range c.refs {
+		for steps := 0; n.ref != nil; steps++ {
+			if steps > len(c.refs) {
+				return nil, fmt.Errorf("circular reference: %s", ref)
+			}
+			n = n.ref
+		}
+	}
+	return &Schema{root: root}, nil
+}
+
+// Validate validates a serialized JSON document against the schema, returning a description of every violation that
+// was found. The location of each violation is given as a JSON pointer. No violations are returned if the document is
+// valid.
+func (s *Schema) Validate(data []byte) []string {
+	doc, err := decode(data)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid JSON: %s", err)}
+	}
+	return s.root.validate(doc, "")
+}
+
+// decode decodes a JSON document, retaining numbers in their original form so that integers can be distinguished from
+// other numbers.
+func decode(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after the end of the document")
+	}
+	return doc, nil
+}
+
+// compiler compiles the subschemas of a schema document. Referenced subschemas are compiled once, so that recursive
+// references work.
+type compiler struct {
+	doc  interface{}
+	refs map[string]*node
+}
+
+// compile compiles the subschema at the given location in the schema document.
+func (c *compiler) compile(value interface{}, path string) (*node, error) {
+	n := &node{}
+	if err := c.compileInto(n, value, path); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// resolve returns the compiled subschema identified by a reference to another part of the same schema document.
+func (c *compiler) resolve(ref string) (*node, error) {
+	if n, ok := c.refs[ref]; ok {
+		return n, nil
+	}
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported reference to another document: %s", ref)
+	}
+	value, err := lookup(c.doc, ref[1:])
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve reference %s: %s", ref, err)
+	}
+
+	// Register the node before compiling it in case it refers to itself.
+	n := &node{}
+	c.refs[ref] = n
+	if err := c.compileInto(n, value, ref); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// lookup returns the value in a JSON document that a JSON pointer identifies.
+func lookup(doc interface{}, pointer string) (interface{}, error) {
+	if pointer == "" {
+		return doc, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("not a JSON pointer: %s", pointer)
+	}
+	value := doc
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		switch v := value.(type) {
+		case map[string]interface{}:
+			child, ok := v[token]
+			if !ok {
+				return nil, fmt.Errorf("no member named %s", token)
+			}
+			value = child
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("no element at index %s", token)
+			}
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("no member named %s", token)
+		}
+	}
+	return value, nil
+}
+
+// compileInto compiles the subschema at the given location in the schema document into an existing node.
+func (c *compiler) compileInto(n *node, value interface{}, path string) (err error) {
+	if b, ok := value.(bool); ok {
+		n.always = &b
+		return nil
+	}
+	keywords, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: a schema must be an object or a boolean", path)
+	}
+
+	// Reject the keywords that aren't supported, in a predictable order.
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if unsupportedKeywords[name] {
+			return fmt.Errorf("%s: unsupported keyword: %s", path, name)
+		}
+	}
+
+	// References replace the rest of the schema.
+	if ref, ok := keywords["$ref"]; ok {
+		s, ok := ref.(string)
+		if !ok {
+			return fmt.Errorf("%s: $ref must be a string", path)
+		}
+		n.ref, err = c.resolve(s)
+		return err
+	}
+
+	for _, name := range names {
+		value := keywords[name]
+		at := path + "/" + name
+		switch name {
+		case "type":
+			n.types, err = compileTypes(value, at)
+		case "enum":
+			values, ok := value.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s: must be an array", at)
+			}
+			n.enum = values
+		case "const":
+			n.hasConst, n.constant = true, value
+		case "properties":
+			n.properties, err = c.compileProperties(value, at)
+		case "required":
+			n.required, err = compileStrings(value, at)
+		case "additionalProperties":
+			n.additional, err = c.compile(value, at)
+		case "items":
+			if _, ok := value.([]interface{}); ok {
+				return fmt.Errorf("%s: lists of item schemas aren't supported", at)
+			}
+			n.items, err = c.compile(value, at)
+		case "minItems":
+			n.minItems, err = compileCount(value, at)
+		case "maxItems":
+			n.maxItems, err = compileCount(value, at)
+		case "minLength":
+			n.minLength, err = compileCount(value, at)
+		case "maxLength":
+			n.maxLength, err = compileCount(value, at)
+		case "pattern":
+			n.pattern, err = compilePattern(value, at)
+		case "minimum":
+			n.minimum, err = compileNumber(value, at)
+		case "maximum":
+			n.maximum, err = compileNumber(value, at)
+		case "exclusiveMinimum":
+			n.exclusiveMinimum, err = compileNumber(value, at)
+		case "exclusiveMaximum":
+			n.exclusiveMaximum, err = compileNumber(value, at)
+		case "allOf":
+			n.allOf, err = c.compileList(value, at)
+		case "anyOf":
+			n.anyOf, err = c.compileList(value, at)
+		case "oneOf":
+			n.oneOf, err = c.compileList(value, at)
+		case "not":
+			n.not, err = c.compile(value, at)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileTypes compiles the value of the type keyword, which may be a single type or a list of types.
+func compileTypes(value interface{}, path string) ([]string, error) {
+	types := []string{}
+	if s, ok := value.(string); ok {
+		types = append(types, s)
+	} else {
+		var err error
+		if types, err = compileStrings(value, path); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range types {
+		if !knownTypes[t] {
+			return nil, fmt.Errorf("%s: unknown type: %s", path, t)
+		}
+	}
+	return types, nil
+}
+
+// compileStrings compiles a keyword whose value is a list of strings.
+func compileStrings(value interface{}, path string) ([]string, error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: must be an array of strings", path)
+	}
+	result := make([]string, len(values))
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: must be an array of strings", path)
+		}
+		result[i] = s
+	}
+	return result, nil
+}
+
+// compileCount compiles a keyword whose value is a non-negative integer.
+func compileCount(value interface{}, path string) (*int, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("%s: must be a non-negative integer", path)
+	}
+	count, err := strconv.Atoi(number.String())
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("%s: must be a non-negative integer", path)
+	}
+	return &count, nil
+}
+
+// compileNumber compiles a keyword whose value is a number.
+func compileNumber(value interface{}, path string) (*float64, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("%s: must be a number", path)
+	}
+	f, err := number.Float64()
+	if err != nil {
+		return nil, fmt.Errorf("%s: must be a number", path)
+	}
+	return &f, nil
+}
+
+// compilePattern compiles a keyword whose value is a regular expression.
+func compilePattern(value interface{}, path string) (*regexp.Regexp, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s: must be a string", path)
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid pattern: %s", path, err)
+	}
+	return re, nil
+}
+
+// compileProperties compiles the value of the properties keyword, which maps property names to schemas.
+func (c *compiler) compileProperties(value interface{}, path string) (map[string]*node, error) {
+	properties, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: must be an object", path)
+	}
+	result := make(map[string]*node, len(properties))
+	for name, property := range properties {
+		n, err := c.compile(property, path+"/"+name)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = n
+	}
+	return result, nil
+}
+
+// compileList compiles a keyword whose value is a non-empty list of schemas.
+func (c *compiler) compileList(value interface{}, path string) ([]*node, error) {
+	values, ok := value.([]interface{})
+	if !ok || len(values) == 0 {
+		return nil, fmt.Errorf("%s: must be a non-empty array of schemas", path)
+	}
+	result := make([]*node, len(values))
+	for i, v := range values {
+		n, err := c.compile(v, fmt.Sprintf("%s/%d", path, i))
+		if err != nil {
+			return nil, err
+		}
+		result[i] = n
+	}
+	return result, nil
+}
+
+// location formats a JSON pointer for inclusion in a violation. The root of the document is shown as a single slash.
+func location(pointer string) string {
+	if pointer == "" {
+		return "/"
+	}
+	return pointer
+}
+
+// child returns the JSON pointer to a member of the value at the given location.
+func child(pointer, name string) string {
+	return pointer + "/" + strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
+
+// typeOf returns the JSON Schema type of a decoded JSON value. Numbers without fractional parts are integers.
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+// hasType determines whether or not a decoded JSON value has one of the given types. Integers are also numbers.
+func hasType(value interface{}, types []string) bool {
+	actual := typeOf(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// equal determines whether or not two decoded JSON values are equal. Numbers are compared by value.
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		bn, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, aerr := a.Float64()
+		bf, berr := bn.Float64()
+		return aerr == nil && berr == nil && af == bf
+	case []interface{}:
+		bs, ok := b.([]interface{})
+		if !ok || len(a) != len(bs) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], bs[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bm, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(bm) {
+			return false
+		}
+		for key, value := range a {
+			other, ok := bm[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// validate validates a decoded JSON value at the given location against a compiled schema, returning a description
+// of every violation that was found.
+func (n *node) validate(value interface{}, pointer string) []string {
+	if n.ref != nil {
+		return n.ref.validate(value, pointer)
+	}
+	if n.always != nil {
+		if *n.always {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: no value is allowed", location(pointer))}
+	}
+
+	var violations []string
+	violate := func(format string, args ...interface{}) {
+		violations = append(violations, location(pointer)+": "+fmt.Sprintf(format, args...))
+	}
+
+	// A value of the wrong type can't be checked any further.
+	if len(n.types) > 0 && !hasType(value, n.types) {
+		violate("expected %s but got %s", strings.Join(n.types, " or "), typeOf(value))
+		return violations
+	}
+
+	if n.enum != nil {
+		found := false
+		for _, allowed := range n.enum {
+			found = found || equal(value, allowed)
+		}
+		if !found {
+			violate("value isn't one of the allowed values")
+		}
+	}
+	if n.hasConst && !equal(value, n.constant) {
+		violate("value isn't the required value")
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		violations = append(violations, n.validateObject(v, pointer)...)
+	case []interface{}:
+		if n.minItems != nil && len(v) < *n.minItems {
+			violate("expected at least %d items but got %d", *n.minItems, len(v))
+		}
+		if n.maxItems != nil && len(v) > *n.maxItems {
+			violate("expected at most %d items but got %d", *n.maxItems, len(v))
+		}
+		if n.items != nil {
+			for i, item := range v {
+				violations = append(violations, n.items.validate(item, fmt.Sprintf("%s/%d", pointer, i))...)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if n.minLength != nil && length < *n.minLength {
+			violate("expected at least %d characters but got %d", *n.minLength, length)
+		}
+		if n.maxLength != nil && length > *n.maxLength {
+			violate("expected at most %d characters but got %d", *n.maxLength, length)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(v) {
+			violate("value doesn't match the pattern %s", n.pattern)
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if n.minimum != nil && f < *n.minimum {
+			violate("expected at least %g but got %s", *n.minimum, v)
+		}
+		if n.maximum != nil && f > *n.maximum {
+			violate("expected at most %g but got %s", *n.maximum, v)
+		}
+		if n.exclusiveMinimum != nil && f <= *n.exclusiveMinimum {
+			violate("expected more than %g but got %s", *n.exclusiveMinimum, v)
+		}
+		if n.exclusiveMaximum != nil && f >= *n.exclusiveMaximum {
+			violate("expected less than %g but got %s", *n.exclusiveMaximum, v)
+		}
+	}
+
+	// Apply the subschemas.
+	for _, s := range n.allOf {
+		violations = append(violations, s.validate(value, pointer)...)
+	}
+	if n.anyOf != nil && countMatches(n.anyOf, value, pointer) == 0 {
+		violate("value doesn't match any of the schemas in anyOf")
+	}
+	if n.oneOf != nil {
+		if matches := countMatches(n.oneOf, value, pointer); matches != 1 {
+			violate("value matches %d of the schemas in oneOf but must match exactly one", matches)
+		}
+	}
+	if n.not != nil && len(n.not.validate(value, pointer)) == 0 {
+		violate("value matches the schema in not")
+	}
+	return violations
+}
+
+// validateObject validates the members of a JSON object against a compiled schema, returning a description of every
+// violation that was found. Required properties are reported in the order in which they're listed in the schema, and
+// the other properties are checked in lexical order.
+func (n *node) validateObject(object map[string]interface{}, pointer string) []string {
+	var violations []string
+	for _, name := range n.required {
+		if _, ok := object[name]; !ok {
+			violations = append(violations, fmt.Sprintf("%s: missing required property %s", location(pointer), name))
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s, ok := n.properties[name]
+		if !ok {
+			s = n.additional
+		}
+		if s != nil && s.always != nil && !*s.always {
+			violations = append(violations, fmt.Sprintf("%s: unexpected property %s", location(pointer), name))
+		} else if s != nil {
+			violations = append(violations, s.validate(object[name], child(pointer, name))...)
+		}
+	}
+	return violations
+}
+
+// countMatches returns the number of the given schemas that a value matches.
+func countMatches(schemas []*node, value interface{}, pointer string) int {
+	matches := 0
+	for _, s := range schemas {
+		if len(s.validate(value, pointer)) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
@@ -0,0 +1,202 @@
+package schema
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// A schema resembling one that describes version 1 messages.
+const messageSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "data object message",
+  "type": "object",
+  "required": ["author", "entity", "path"],
+  "properties": {
+    "author": {"$ref": "#/definitions/user"},
+    "entity": {"type": "string", "minLength": 1},
+    "path": {"type": "string", "pattern": "^/"},
+    "size": {"type": "integer", "minimum": 0},
+    "timestamp": {"type": ["string", "integer"]},
+    "tags": {"type": "array", "items": {"enum": ["a", "b"]}, "maxItems": 2}
+  },
+  "definitions": {
+    "user": {
+      "type": "object",
+      "required": ["name", "zone"],
+      "properties": {"name": {"type": "string"}, "zone": {"type": "string"}},
+      "additionalProperties": false
+    }
+  }
+}`
+
+// TestValidate verifies that documents are validated against a schema and that every violation is reported.
+func TestValidate(t *testing.T) {
+	s, err := Compile([]byte(messageSchema))
+	if err != nil {
+		t.Fatalf("unable to compile the schema: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		violations []string
+	}{
+		{
+			"valid",
+			`{"author": {"name": "ipcdev", "zone": "iplant"}, "entity": "fakeid", "path": "/foo", "size": 3,` +
+				` "timestamp": 1551441600000, "tags": ["a"]}`,
+			nil,
+		},
+		{
+			"empty object",
+			`{}`,
+			[]string{
+				"/: missing required property author",
+				"/: missing required property entity",
+				"/: missing required property path",
+			},
+		},
+		{
+			"wrong types",
+			`{"author": "ipcdev", "entity": 42, "path": "/foo", "size": 1.5, "timestamp": true}`,
+			[]string{
+				"/author: expected object but got string",
+				"/entity: expected string but got integer",
+				"/size: expected integer but got number",
+				"/timestamp: expected string or integer but got boolean",
+			},
+		},
+		{
+			"constraints",
+			`{"author": {"name": "ipcdev", "zone": "iplant", "role": "x"}, "entity": "", "path": "foo", "size": -1,` +
+				` "tags": ["a", "c", "b"]}`,
+			[]string{
+				"/author: unexpected property role",
+				"/entity: expected at least 1 characters but got 0",
+				"/path: value doesn't match the pattern ^/",
+				"/size: expected at least 0 but got -1",
+				"/tags: expected at most 2 items but got 3",
+				"/tags/1: value isn't one of the allowed values",
+			},
+		},
+		{
+			"not an object",
+			`[]`,
+			[]string{"/: expected object but got array"},
+		},
+		{
+			"invalid JSON",
+			`{"entity": `,
+			[]string{"invalid JSON: unexpected EOF"},
+		},
+	}
+
+	for _, test := range tests {
+		if actual := s.Validate([]byte(test.body)); !reflect.DeepEqual(actual, test.violations) {
+			t.Errorf("%s: expected violations %q but got %q", test.name, test.violations, actual)
+		}
+	}
+}
+
+// TestCombinators verifies that allOf, anyOf, oneOf and not are applied. Keywords that only apply to objects are
+// satisfied by values of other types.
+func TestCombinators(t *testing.T) {
+	s, err := Compile([]byte(`{
+  "allOf": [{"type": "object"}],
+  "anyOf": [{"required": ["path"]}, {"required": ["entity"]}],
+  "oneOf": [{"required": ["size"]}, {"required": ["checksum"]}],
+  "not": {"required": ["version"], "properties": {"version": {"const": 3}}}
+}`))
+	if err != nil {
+		t.Fatalf("unable to compile the schema: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		violations []string
+	}{
+		{"valid", `{"path": "/foo", "size": 3, "version": 1}`, nil},
+		{"anyOf", `{"size": 3}`, []string{"/: value doesn't match any of the schemas in anyOf"}},
+		{
+			"oneOf",
+			`{"path": "/foo", "size": 3, "checksum": "sha2:foo"}`,
+			[]string{"/: value matches 2 of the schemas in oneOf but must match exactly one"},
+		},
+		{"not", `{"path": "/foo", "size": 3, "version": 3.0}`, []string{"/: value matches the schema in not"}},
+		{"allOf", `"foo"`, []string{
+			"/: expected object but got string",
+			"/: value matches 2 of the schemas in oneOf but must match exactly one",
+			"/: value matches the schema in not",
+		}},
+	}
+
+	for _, test := range tests {
+		if actual := s.Validate([]byte(test.body)); !reflect.DeepEqual(actual, test.violations) {
+			t.Errorf("%s: expected violations %q but got %q", test.name, test.violations, actual)
+		}
+	}
+}
+
+// TestRecursiveReferences verifies that schemas can refer to themselves.
+func TestRecursiveReferences(t *testing.T) {
+	children := `{"type": "array", "items": {"$ref": "#"}}`
+	s, err := Compile([]byte(`{"type": "object", "properties": {"children": ` + children + `}}`))
+	if err != nil {
+		t.Fatalf("unable to compile the schema: %s", err)
+	}
+	expected := []string{"/children/0/children/0: expected object but got integer"}
+	if actual := s.Validate([]byte(`{"children": [{"children": [1]}]}`)); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected violations %q but got %q", expected, actual)
+	}
+}
+
+// TestInvalidSchemas verifies that schemas that can't be enforced are rejected.
+func TestInvalidSchemas(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{"invalid JSON", `{"type": `},
+		{"not a schema", `"object"`},
+		{"unsupported keyword", `{"properties": {"path": {"patternProperties": {}}}}`},
+		{"unknown type", `{"type": "text"}`},
+		{"invalid pattern", `{"pattern": "("}`},
+		{"negative length", `{"minLength": -1}`},
+		{"tuple items", `{"items": [{"type": "string"}]}`},
+		{"empty anyOf", `{"anyOf": []}`},
+		{"missing reference", `{"$ref": "#/definitions/missing"}`},
+		{"remote reference", `{"$ref": "http://example.com/schema.json"}`},
+		{"circular reference", `{"definitions": {"a": {"$ref": "#/definitions/b"}, "b": {"$ref": "#/definitions/a"}},` +
+			` "$ref": "#/definitions/a"}`},
+	}
+
+	for _, test := range tests {
+		if _, err := Compile([]byte(test.schema)); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+// TestLoad verifies that schemas are loaded from files.
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schema")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "message.json")
+	if err := ioutil.WriteFile(path, []byte(messageSchema), 0644); err != nil {
+		t.Fatalf("unable to write the schema: %s", err)
+	}
+	if _, err := Load(path); err != nil {
+		t.Errorf("unable to load the schema: %s", err)
+	}
+	if _, err := Load(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("expected an error for a missing schema file")
+	}
+}
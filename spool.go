@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// The names of the files in the spool directory. New messages are appended to the spool file. When the spool is
+// drained, the spool file is renamed to the draining file so that messages can continue to be spooled while the
+// draining file is being processed. Messages that can't be recorded yet remain in the draining file, which is always
+// processed before the spool file so that messages are recorded in the order in which they were spooled.
+const (
+	spoolFileName    = "spool.jsonl"
+	drainingFileName = "draining.jsonl"
+)
+
+// Default spool settings.
+const (
+	defaultSpoolMaxSize       = 100 * 1024 * 1024
+	defaultSpoolDrainInterval = 30 * time.Second
+)
+
+// spoolSize is published via expvar so that the amount of data waiting in the spool can be monitored.
+var spoolSize = expvar.NewInt("spool_size_bytes")
+
+// errSpoolFull indicates that a message couldn't be spooled because the spool has reached its maximum size.
+var errSpoolFull = fmt.Errorf("the spool is full")
+
+// spoolSettings describes where messages are spooled while the database is unavailable.
+type spoolSettings struct {
+	directory     string
+	maxSize       int64
+	drainInterval time.Duration
+}
+
+// getSpoolSettings returns the spool settings described by the configuration, or nil if spooling is disabled.
+func getSpoolSettings(cfg *viper.Viper) (*spoolSettings, error) {
+	if !cfg.GetBool("dataone.spool.enabled") {
+		return nil, nil
+	}
+
+	// Load the spool directory.
+	directory := cfg.GetString("dataone.spool.directory")
+	if directory == "" {
+		return nil, fmt.Errorf("dataone.spool.directory must be specified when spooling is enabled")
+	}
+
+	// Load the maximum size.
+	maxSize := cfg.GetInt64("dataone.spool.max-size")
+	if maxSize < 0 {
+		return nil, fmt.Errorf("dataone.spool.max-size must not be negative: %d", maxSize)
+	}
+	if maxSize == 0 {
+		maxSize = defaultSpoolMaxSize
+	}
+
+	// Load the drain interval.
+	drainInterval, err := getPositiveDuration(cfg, "dataone.spool.drain-interval", defaultSpoolDrainInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	return &spoolSettings{directory: directory, maxSize: maxSize, drainInterval: drainInterval}, nil
+}
+
+// spoolEntry is a single spooled message, which is stored as one line of JSON. The body is stored exactly as it was
+// received, and the AMQP timestamp and message ID are retained, so that a redelivered copy of the message can be
+// recognized once the spooled copy has been recorded. The content type and content encoding are retained so that the
+// body is decoded in the same way.
+type spoolEntry struct {
+	RoutingKey      string    `json:"routing_key"`
+	Timestamp       time.Time `json:"timestamp"`
+	Body            []byte    `json:"body"`
+	MessageID       string    `json:"message_id,omitempty"`
+	ContentType     string    `json:"content_type,omitempty"`
+	ContentEncoding string    `json:"content_encoding,omitempty"`
+}
+
+// messageSpool stores messages whose events couldn't be recorded because the database was unavailable in files on the
+// local disk, so that they can be acknowledged and recorded once the database is available again. It's safe for
+// concurrent use by multiple goroutines.
+type messageSpool struct {
+	directory     string
+	maxSize       int64
+	drainInterval time.Duration
+	mutex         sync.Mutex
+	size          int64
+	draining      sync.Mutex
+}
+
+// openSpool opens the spool in a directory, creating the directory if it doesn't exist. Messages that were spooled
+// before the service was last stopped are retained.
+func openSpool(ss *spoolSettings) (*messageSpool, error) {
+	if err := os.MkdirAll(ss.directory, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create the spool directory: %s", err)
+	}
+	s := &messageSpool{directory: ss.directory, maxSize: ss.maxSize, drainInterval: ss.drainInterval}
+	if err := s.updateSize(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// path returns the path to a file in the spool directory.
+func (s *messageSpool) path(name string) string {
+	return filepath.Join(s.directory, name)
+}
+
+// updateSize determines the amount of data in the spool from the sizes of the spool files.
+func (s *messageSpool) updateSize() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var size int64
+	for _, name := range []string{spoolFileName, drainingFileName} {
+		info, err := os.Stat(s.path(name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to determine the size of the spool: %s", err)
+		}
+		size += info.Size()
+	}
+	s.size = size
+	spoolSize.Set(size)
+	return nil
+}
+
+// append adds a message to the end of the spool. The spool file is synced before append returns so that the delivery
+// can be acknowledged safely.
+func (s *messageSpool) append(delivery amqp.Delivery) error {
+	entry := spoolEntry{
+		RoutingKey:      originalRoutingKey(delivery),
+		Timestamp:       delivery.Timestamp,
+		Body:            delivery.Body,
+		MessageID:       delivery.MessageId,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.size+int64(len(line)) > s.maxSize {
+		return errSpoolFull
+	}
+	f, err := os.OpenFile(s.path(spoolFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(line)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	s.size += int64(len(line))
+	spoolSize.Set(s.size)
+	return nil
+}
+
+// drain passes each spooled message to a function in the order in which the messages were spooled. Draining stops at
+// the first message for which the function returns an error, and that message and the ones after it remain in the
+// spool. The return value is the number of messages that were removed from the spool.
+func (s *messageSpool) drain(record func(spoolEntry) error) (int, error) {
+	s.draining.Lock()
+	defer s.draining.Unlock()
+	defer s.updateSize()
+
+	drained := 0
+	for {
+
+		// Start draining the spool file unless messages from an earlier drain remain.
+		draining := s.path(drainingFileName)
+		if _, err := os.Stat(draining); os.IsNotExist(err) {
+			s.mutex.Lock()
+			err = os.Rename(s.path(spoolFileName), draining)
+			s.mutex.Unlock()
+			if os.IsNotExist(err) {
+				return drained, nil
+			}
+			if err != nil {
+				return drained, fmt.Errorf("unable to drain the spool: %s", err)
+			}
+		} else if err != nil {
+			return drained, fmt.Errorf("unable to drain the spool: %s", err)
+		}
+
+		n, err := drainFile(draining, record)
+		drained += n
+		if err != nil {
+			return drained, err
+		}
+	}
+}
+
+// drainFile passes each message in a spool file to a function and removes the file once every message has been
+// handled. If the function returns an error, the messages that were handled are removed from the file.
+func drainFile(path string, record func(spoolEntry) error) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("unable to open the spool file: %s", err)
+	}
+	defer f.Close()
+
+	drained := 0
+	var offset int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return drained, fmt.Errorf("unable to read the spool file: %s", err)
+		}
+
+		// Entries that can't be decoded were most likely truncated when the service stopped while spooling them.
+		var entry spoolEntry
+		if decodeErr := json.Unmarshal(line, &entry); decodeErr != nil {
+			logger.Log.Errorf("discarding spooled message that can't be decoded (%s): %s", line, decodeErr)
+		} else if recordErr := record(entry); recordErr != nil {
+			if err := keepRemaining(f, path, offset); err != nil {
+				logger.Log.Errorf("unable to remove drained messages from the spool: %s", err)
+			}
+			return drained, recordErr
+		} else {
+			drained++
+		}
+		offset += int64(len(line))
+	}
+
+	f.Close()
+	if err := os.Remove(path); err != nil {
+		return drained, fmt.Errorf("unable to remove the drained spool file: %s", err)
+	}
+	return drained, nil
+}
+
+// keepRemaining replaces a spool file with the part of the file that begins at an offset.
+func keepRemaining(f *os.File, path string, offset int64) error {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, f)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// spoolMessage spools a message whose event couldn't be recorded because the database couldn't be reached or the
+// circuit breaker is open. The return value indicates whether or not the message was spooled. Failures are logged
+// rather than returned so that the delivery is handled as if spooling were disabled.
+func (svc *DataoneIndexer) spoolMessage(delivery amqp.Delivery, err error) bool {
+	if svc.spool == nil || !(database.IsConnectionError(err) || isCircuitOpen(err)) {
+		return false
+	}
+	if spoolErr := svc.spool.append(delivery); spoolErr != nil {
+		logger.Log.Errorf("unable to spool message (%s): %s", delivery.Body, spoolErr)
+		return false
+	}
+	logger.Log.Warnf("the database is unavailable; spooled message for path '%s': %s", messagePath(delivery), err)
+	countOutcome(originalRoutingKey(delivery), outcomeSpooled)
+	return true
+}
+
+// recordSpooled records the event for a spooled message. A spooled copy is treated as a redelivery so that it isn't
+// recorded if a redelivered copy of the original message was recorded already. An error is returned if the message
+// should remain in the spool because the database still can't record it. Messages that can never be recorded are
+// stored for replay if storing failed recordings is enabled and discarded otherwise.
+func (svc *DataoneIndexer) recordSpooled(entry spoolEntry) error {
+	delivery := amqp.Delivery{
+		RoutingKey:      entry.RoutingKey,
+		Timestamp:       entry.Timestamp,
+		Body:            entry.Body,
+		MessageId:       entry.MessageID,
+		ContentType:     entry.ContentType,
+		ContentEncoding: entry.ContentEncoding,
+		Redelivered:     true,
+	}
+	key, msg, err := svc.prepareMessage(delivery)
+	if err == nil && msg != nil {
+		msgs := msg.Expand()
+		ctx, cancel := svc.messageContext()
+		events, recordErr := svc.recordEvents(ctx, key, msgs)
+		cancel()
+		if recordErr == nil {
+			for i, m := range msgs {
+				svc.eventRecorded(delivery, m, events[i])
+			}
+			return nil
+		}
+		if database.IsRetryable(recordErr) {
+			return recordErr
+		}
+		err = &processingError{
+			msg:       fmt.Sprintf("unable to record spooled message (%s): %s", delivery.Body, recordErr),
+			recording: true,
+		}
+		countOutcome(key, outcomeRecordFailed)
+	}
+
+	if err != nil && !svc.storeFailure(delivery, err, 1) {
+		logger.Log.Errorf("discarding spooled message: %s", err)
+	}
+	return nil
+}
+
+// drainSpool records the events for spooled messages unless the database is known to be unhealthy or the circuit
+// breaker is open.
+func (svc *DataoneIndexer) drainSpool() {
+	if svc.health != nil {
+		if healthy, _ := svc.health.state(); !healthy {
+			return
+		}
+	}
+	if svc.breaker != nil {
+		if state, _ := svc.breaker.status(); state == circuitOpen {
+			return
+		}
+	}
+
+	drained, err := svc.spool.drain(svc.recordSpooled)
+	if drained > 0 {
+		logger.Log.Infof("drained %d messages from the spool", drained)
+	}
+	if err != nil {
+		logger.Log.Warnf("stopped draining the spool: %s", err)
+	}
+}
+
+// runSpool drains the spool when the service starts and at the configured interval afterwards until the stop channel
+// is closed.
+func (svc *DataoneIndexer) runSpool() {
+	svc.drainSpool()
+	ticker := time.NewTicker(svc.spool.drainInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-svc.stop:
+			return
+		case <-ticker.C:
+			svc.drainSpool()
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// newTestSpool opens a spool in a new temporary directory. The returned function removes the directory.
+func newTestSpool(t *testing.T, maxSize int64) (*messageSpool, func()) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatalf("unable to create the spool directory: %s", err)
+	}
+	s, err := openSpool(&spoolSettings{directory: dir, maxSize: maxSize, drainInterval: defaultSpoolDrainInterval})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unable to open the spool: %s", err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+// spoolTestBody returns a distinct message body for testing.
+func spoolTestBody(n int) []byte {
+	return []byte(fmt.Sprintf(`{"entity": "%d", "path": "/iplant/home/shared/commons_repo/curated/%d.txt"}`, n, n))
+}
+
+// TestGetSpoolSettings verifies that spool settings are loaded and validated correctly.
+func TestGetSpoolSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		directory string
+		maxSize   int64
+		expectErr bool
+		expectNil bool
+		expected  int64
+	}{
+		{"disabled", false, "", 0, false, true, 0},
+		{"enabled", true, "/tmp/spool", 1024, false, false, 1024},
+		{"default size", true, "/tmp/spool", 0, false, false, defaultSpoolMaxSize},
+		{"no directory", true, "", 1024, true, false, 0},
+		{"negative size", true, "/tmp/spool", -1, true, false, 0},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("dataone.spool.enabled", test.enabled)
+		cfg.Set("dataone.spool.directory", test.directory)
+		cfg.Set("dataone.spool.max-size", test.maxSize)
+		ss, err := getSpoolSettings(cfg)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: expected error: %t, got: %v", test.name, test.expectErr, err)
+			continue
+		}
+		if test.expectErr {
+			continue
+		}
+		if (ss == nil) != test.expectNil {
+			t.Errorf("%s: expected nil settings: %t, got: %+v", test.name, test.expectNil, ss)
+			continue
+		}
+		if ss != nil && ss.maxSize != test.expected {
+			t.Errorf("%s: expected maximum size %d, got %d", test.name, test.expected, ss.maxSize)
+		}
+		if ss != nil && ss.drainInterval != defaultSpoolDrainInterval {
+			t.Errorf("%s: expected the default drain interval, got %s", test.name, ss.drainInterval)
+		}
+	}
+}
+
+// TestSpoolOrder verifies that spooled messages are drained in the order in which they were spooled, even if a drain
+// stops partway through and more messages are spooled before the next one.
+func TestSpoolOrder(t *testing.T) {
+	s, cleanup := newTestSpool(t, defaultSpoolMaxSize)
+	defer cleanup()
+
+	for i := 1; i <= 3; i++ {
+		if err := s.append(amqp.Delivery{RoutingKey: "data-object.open", Body: spoolTestBody(i)}); err != nil {
+			t.Fatalf("unable to spool message %d: %s", i, err)
+		}
+	}
+
+	// The first drain stops at the second message.
+	var drained []string
+	drained1, err := s.drain(func(entry spoolEntry) error {
+		if string(entry.Body) == string(spoolTestBody(2)) {
+			return fmt.Errorf("database unavailable")
+		}
+		drained = append(drained, string(entry.Body))
+		return nil
+	})
+	if drained1 != 1 || err == nil {
+		t.Errorf("expected 1 message to be drained with an error, got %d, %v", drained1, err)
+	}
+
+	// More messages are spooled before the next drain, which drains everything.
+	if err := s.append(amqp.Delivery{RoutingKey: "data-object.open", Body: spoolTestBody(4)}); err != nil {
+		t.Fatalf("unable to spool message 4: %s", err)
+	}
+	drained2, err := s.drain(func(entry spoolEntry) error {
+		if entry.RoutingKey != "data-object.open" {
+			t.Errorf("unexpected routing key: %s", entry.RoutingKey)
+		}
+		drained = append(drained, string(entry.Body))
+		return nil
+	})
+	if drained2 != 3 || err != nil {
+		t.Errorf("expected 3 messages to be drained without an error, got %d, %v", drained2, err)
+	}
+
+	for i, body := range drained {
+		if expected := string(spoolTestBody(i + 1)); body != expected {
+			t.Errorf("message %d: expected %s, got %s", i, expected, body)
+		}
+	}
+	if s.size != 0 {
+		t.Errorf("expected the spool to be empty but it contains %d bytes", s.size)
+	}
+}
+
+// TestSpoolFull verifies that messages aren't spooled once the spool reaches its maximum size.
+func TestSpoolFull(t *testing.T) {
+	s, cleanup := newTestSpool(t, 250)
+	defer cleanup()
+
+	if err := s.append(amqp.Delivery{Body: spoolTestBody(1)}); err != nil {
+		t.Fatalf("unable to spool the first message: %s", err)
+	}
+	if err := s.append(amqp.Delivery{Body: spoolTestBody(2)}); err != errSpoolFull {
+		t.Errorf("expected the spool to be full, got: %v", err)
+	}
+}
+
+// TestSpoolDelivery verifies that messages are spooled and acknowledged only if the database couldn't be reached, and
+// that spooled messages are recorded once it can be reached again.
+func TestSpoolDelivery(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		spooled int
+		acks    int
+		nacks   int
+	}{
+		{"connection refused", &database.RetryableError{Err: io.ErrUnexpectedEOF}, 1, 1, 0},
+		{"deadlock", &database.RetryableError{Err: fmt.Errorf("deadlock")}, 0, 0, 1},
+	}
+
+	for _, test := range tests {
+		s, cleanup := newTestSpool(t, defaultSpoolMaxSize)
+		recorder := &fakeRecorder{err: test.err}
+		svc := newTestService(recorder)
+		svc.manualAck = true
+		svc.spool = s
+
+		acknowledger := &fakeAcknowledger{}
+		session, _ := newFakeSession()
+		delivery := amqp.Delivery{Acknowledger: acknowledger, RoutingKey: "data-object.open", Body: testBody}
+		svc.handleDelivery(session, delivery)
+		if acknowledger.acks != test.acks || acknowledger.nacks != test.nacks {
+			t.Errorf(
+				"%s: expected %d acks and %d nacks but got %d and %d",
+				test.name, test.acks, test.nacks, acknowledger.acks, acknowledger.nacks,
+			)
+		}
+
+		// The spooled message is recorded once the database is available again.
+		recorder.err = nil
+		svc.drainSpool()
+		if expected := int64(1 + test.spooled); recorder.events != expected {
+			t.Errorf("%s: expected %d recording attempts but got %d", test.name, expected, recorder.events)
+		}
+		if s.size != 0 {
+			t.Errorf("%s: expected the spool to be empty but it contains %d bytes", test.name, s.size)
+		}
+		cleanup()
+	}
+}
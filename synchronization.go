@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/spf13/viper"
+)
+
+// getSynchronizationKeys returns the routing keys of the messages that the coordinating node bridge publishes to
+// report the synchronization status of data objects.
+func getSynchronizationKeys(cfg *viper.Viper) []string {
+	return getRoutingKeys(cfg).Synchronization
+}
+
+// isSynchronizationKey determines whether or not a routing key is used for messages that report the synchronization
+// status of data objects.
+func (svc *DataoneIndexer) isSynchronizationKey(key string) bool {
+	for _, pattern := range svc.syncKeys {
+		if database.MatchRoutingKey(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// identifiedOnly returns true if a message identifies a data object without a path, which is only allowed for
+// synchronization messages. These messages can't be checked against the repository roots, but only registered data
+// objects can be synchronized, so the recorder skips the ones that aren't in the repository.
+func (svc *DataoneIndexer) identifiedOnly(key string, msg *model.Message) bool {
+	return msg.Path == "" && svc.isSynchronizationKey(key)
+}
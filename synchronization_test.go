@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cyverse-de/configurate"
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/streadway/amqp"
+)
+
+// TestGetSynchronizationKeys verifies that synchronization messages have a routing key by default and that the key can
+// be replaced.
+func TestGetSynchronizationKeys(t *testing.T) {
+	tests := map[string]string{
+		"": "dataone.synchronization",
+		"dataone:\n  amqp-routing-keys:\n    synchronization: cn.sync\n": "cn.sync",
+	}
+
+	for config, expected := range tests {
+		cfg, err := configurate.InitDefaultsR(bytes.NewBufferString(config), defaultConfig)
+		if err != nil {
+			t.Fatalf("%q: unable to load the configuration: %s", config, err)
+		}
+		keys := getSynchronizationKeys(cfg)
+		if len(keys) != 1 || keys[0] != expected {
+			t.Errorf("%q: expected [%s] but got %v", config, expected, keys)
+		}
+	}
+}
+
+// TestSynchronizationMessages verifies that synchronization messages may identify data objects without paths even
+// when zones are filtered, and that other messages without paths are still rejected.
+func TestSynchronizationMessages(t *testing.T) {
+	const relayed = `{"entity": "fakepid", "timestamp": "2019-03-01.12:00:00", "failureReason": "invalid checksum"}`
+	tests := []struct {
+		name     string
+		key      string
+		body     string
+		accepted bool
+	}{
+		{"identifier only", "dataone.synchronization", relayed, true},
+		{"with a path", "dataone.synchronization", string(testBody), true},
+		{"out of root", "dataone.synchronization", string(outOfRootTestBody), false},
+		{"no identifier", "dataone.synchronization", `{"failureReason": "invalid checksum"}`, false},
+		{"other key", "data-object.open", relayed, false},
+	}
+
+	for _, test := range tests {
+		svc := newTestService(&fakeRecorder{})
+		svc.syncKeys = []string{"dataone.synchronization"}
+		svc.validation = []*keyRequirements{{svc.syncKeys, model.Requirements{IdentifierOnly: true}}}
+		svc.acceptedZones = map[string]bool{"iplant": true}
+
+		_, msg, err := svc.prepareMessage(amqp.Delivery{RoutingKey: test.key, Body: []byte(test.body)})
+		switch {
+		case test.accepted && (err != nil || msg == nil):
+			t.Errorf("%s: expected the message to be accepted (error: %v)", test.name, err)
+		case test.accepted && (!msg.InRepository || msg.NodeID != ""):
+			t.Errorf("%s: unexpected message: %+v", test.name, msg)
+		case !test.accepted && msg != nil:
+			t.Errorf("%s: expected the message to be discarded or rejected but got %+v", test.name, msg)
+		}
+	}
+}
+
+// TestAuthorlessMessages verifies that the default configuration accepts synchronization and replication messages
+// without authors, since they aren't published on behalf of users, while still requiring authors for other messages.
+func TestAuthorlessMessages(t *testing.T) {
+	const replicated = `{"entity": "fakeid", "path": "/iplant/home/shared/commons_repo/curated/foo.txt",
+		"targetNode": "urn:node:partner"}`
+	const relayed = `{"entity": "fakepid", "timestamp": "2019-03-01.12:00:00", "failureReason": "invalid checksum"}`
+	tests := []struct {
+		name     string
+		key      string
+		body     string
+		accepted bool
+	}{
+		{"synchronization", "dataone.synchronization", relayed, true},
+		{"replication", "data-object.replicated", replicated, true},
+		{"read", "data-object.open", string(testBody), false},
+	}
+
+	cfg, err := configurate.InitDefaultsR(bytes.NewBufferString(""), defaultConfig)
+	if err != nil {
+		t.Fatalf("unable to load the configuration: %s", err)
+	}
+	for _, test := range tests {
+		svc := newTestService(&fakeRecorder{})
+		svc.validation = getKeyRequirements(cfg)
+		svc.syncKeys = getSynchronizationKeys(cfg)
+
+		_, _, err := svc.prepareMessage(amqp.Delivery{RoutingKey: test.key, Body: []byte(test.body)})
+		switch {
+		case test.accepted && err != nil:
+			t.Errorf("%s: expected the message to be accepted: %s", test.name, err)
+		case !test.accepted && (err == nil || !strings.Contains(err.Error(), "the author is missing")):
+			t.Errorf("%s: expected the message to be rejected for missing its author (error: %v)", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/cyverse-de/dataone-indexer/logger"
+)
+
+// loggedKeys is a set of the routing keys that have already been logged. It's safe for concurrent use by multiple
+// goroutines.
+type loggedKeys struct {
+	mutex sync.Mutex
+	keys  map[string]bool
+}
+
+// add adds a routing key to the set, returning true if the key wasn't already in the set.
+func (l *loggedKeys) add(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.keys[key] {
+		return false
+	}
+	if l.keys == nil {
+		l.keys = make(map[string]bool)
+	}
+	l.keys[key] = true
+	return true
+}
+
+// unmatchedKeys contains the routing keys of the messages that were ignored because no recorder rule matched them.
+var unmatchedKeys = &loggedKeys{}
+
+// logUnmatchedKey logs a message whose routing key matches the subscription bindings but none of the recorder's rules.
+// A warning is only logged for the first such message with each routing key, so that a busy key that's bound to the
+// queue by mistake doesn't flood the log; later messages are only logged at the debug level.
+func logUnmatchedKey(key string, body []byte) {
+	if unmatchedKeys.add(key) {
+		logger.Log.Warnf("ignoring messages with routing key '%s', which has no recorder rule; further messages "+
+			"with this key are only counted", key)
+		return
+	}
+	logger.Log.Debugf("ignoring message with no recorder rule for routing key '%s': %s", key, body)
+}
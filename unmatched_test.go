@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestLoggedKeys verifies that each routing key is only added to the set of logged keys once.
+func TestLoggedKeys(t *testing.T) {
+	keys := &loggedKeys{}
+	for i, expected := range []bool{true, false, false} {
+		if added := keys.add("data-object.stat"); added != expected {
+			t.Errorf("attempt %d: expected added: %t, got: %t", i+1, expected, added)
+		}
+	}
+	if !keys.add("data-object.touch") {
+		t.Error("a different routing key should be logged")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/cyverse-de/dataone-indexer/database"
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/spf13/viper"
+)
+
+// keyRequirements associates a class of routing keys with the requirements that messages with those keys must meet.
+type keyRequirements struct {
+	keys []string
+	req  model.Requirements
+}
+
+// getKeyRequirements builds the table of requirements for each class of routing key. Messages that describe the
+// actions of users must identify the user unless dataone.validation.require-author is disabled, and moves must also
+// identify their sources. Replication and synchronization messages aren't published on behalf of users, so they never
+// need authors, and synchronization messages may identify data objects by their permanent identifiers alone, since
+// the coordinating node doesn't know their paths.
+func getKeyRequirements(cfg *viper.Viper) []*keyRequirements {
+	keyNames := getRoutingKeys(cfg)
+	user := model.Requirements{Author: cfg.GetBool("dataone.validation.require-author")}
+	move := user
+	move.Source = true
+	return []*keyRequirements{
+		{keyNames.Read, user},
+		{keyNames.Preview, user},
+		{keyNames.Add, user},
+		{keyNames.Move, move},
+		{keyNames.Delete, user},
+		{keyNames.Metadata, user},
+		{keyNames.Permission, user},
+		{keyNames.Modify, user},
+		{keyNames.Replicate, model.Requirements{}},
+		{keyNames.Synchronization, model.Requirements{IdentifierOnly: true}},
+		{keyNames.CollectionMove, move},
+		{keyNames.CollectionDelete, user},
+	}
+}
+
+// messageRequirements returns the requirements that a message with the given routing key must meet. The first class
+// of routing keys that includes the key determines the requirements. Messages with keys that don't belong to any class
+// only have to meet the requirements that apply to every message.
+func (svc *DataoneIndexer) messageRequirements(key string) model.Requirements {
+	for _, class := range svc.validation {
+		for _, pattern := range class.keys {
+			if database.MatchRoutingKey(pattern, key) {
+				return class.req
+			}
+		}
+	}
+	return model.Requirements{}
+}
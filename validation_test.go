@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cyverse-de/configurate"
+	"github.com/cyverse-de/dataone-indexer/model"
+)
+
+// TestMessageRequirements verifies that each class of routing key in the default configuration has its own
+// requirements, and that the author requirement can be disabled for the messages that describe the actions of users.
+func TestMessageRequirements(t *testing.T) {
+	user := model.Requirements{Author: true}
+	move := model.Requirements{Author: true, Source: true}
+	tests := map[string]model.Requirements{
+		"data-object.open":         user,
+		"data-object.download":     user,
+		"data-object.preview":      user,
+		"data-object.add":          user,
+		"data-object.mv":           move,
+		"data-object.rm":           user,
+		"data-object.metadata.add": user,
+		"data-object.acl.mod":      user,
+		"data-object.mod":          user,
+		"data-object.replicated":   {},
+		"dataone.synchronization":  {IdentifierOnly: true},
+		"folder.mv":                move,
+		"folder.rm":                user,
+		"data-object.unknown":      {},
+	}
+
+	configs := map[string]bool{"": true, "dataone:\n  validation:\n    require-author: false\n": false}
+	for config, author := range configs {
+		cfg, err := configurate.InitDefaultsR(bytes.NewBufferString(config), defaultConfig)
+		if err != nil {
+			t.Fatalf("%q: unable to load the configuration: %s", config, err)
+		}
+		svc := newTestService(&fakeRecorder{})
+		svc.validation = getKeyRequirements(cfg)
+
+		for key, expected := range tests {
+			if expected.Author {
+				expected.Author = author
+			}
+			if req := svc.messageRequirements(key); req != expected {
+				t.Errorf("%q: %s: expected %+v but got %+v", config, key, expected, req)
+			}
+		}
+	}
+}
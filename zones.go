@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/cyverse-de/dataone-indexer/logger"
+	"github.com/cyverse-de/dataone-indexer/metrics"
+	"github.com/cyverse-de/dataone-indexer/model"
+	"github.com/spf13/viper"
+)
+
+// foreignZones counts the messages that were skipped because their events occurred in zones that aren't accepted.
+var foreignZones = metrics.NewCounters("foreign_zones", "messages skipped by zone")
+
+// getAcceptedZones returns the iRODS zones whose events are recorded. Zone names are case sensitive, as they are in
+// iRODS. An empty set means that events from every zone are recorded.
+func getAcceptedZones(cfg *viper.Viper) map[string]bool {
+	zones := make(map[string]bool)
+	for _, zone := range getStringList(cfg, "dataone.accepted-zones") {
+		zones[zone] = true
+	}
+	return zones
+}
+
+// isAcceptedZone determines whether or not the event described by a message occurred in one of the accepted zones.
+// Every zone is accepted if no zones are listed.
+func isAcceptedZone(msg *model.Message, accepted map[string]bool) bool {
+	return len(accepted) == 0 || accepted[msg.EventZone()]
+}
+
+// skipForeignZone returns true if the event described by a message occurred in a zone that isn't accepted, which can
+// happen in federated iRODS deployments because paths in partner zones may match the repository roots. Messages that
+// only identify the data object don't describe iRODS events, so they're never skipped.
+func (svc *DataoneIndexer) skipForeignZone(key string, msg *model.Message) bool {
+	if svc.identifiedOnly(key, msg) || isAcceptedZone(msg, svc.acceptedZones) {
+		return false
+	}
+	zone := msg.EventZone()
+	logger.Log.Debugf("skipping event for '%s' in zone '%s' with routing key '%s'", msg.Path, zone, key)
+	foreignZones.Inc(zone)
+	countOutcome(key, outcomeForeignZone)
+	return true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/streadway/amqp"
+)
+
+// TestGetAcceptedZones verifies that the accepted zones may be listed in either of the supported forms.
+func TestGetAcceptedZones(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected []string
+	}{
+		{nil, nil},
+		{[]string{}, nil},
+		{"iplant, partner", []string{"iplant", "partner"}},
+		{[]interface{}{"iplant"}, []string{"iplant"}},
+	}
+
+	for _, test := range tests {
+		cfg := viper.New()
+		if test.value != nil {
+			cfg.Set("dataone.accepted-zones", test.value)
+		}
+		zones := getAcceptedZones(cfg)
+		if len(zones) != len(test.expected) {
+			t.Errorf("%v: expected %v but got %v", test.value, test.expected, zones)
+		}
+		for _, zone := range test.expected {
+			if !zones[zone] {
+				t.Errorf("%v: zone %s should be accepted", test.value, zone)
+			}
+		}
+	}
+}
+
+// TestForeignZones verifies that events from zones that aren't accepted are skipped and counted, that the zone is
+// taken from the path if the message doesn't include it, and that every zone is accepted if none are listed.
+func TestForeignZones(t *testing.T) {
+	const path = `"path": "/iplant/home/shared/commons_repo/curated/foo.txt"`
+	iplant, partner := map[string]bool{"iplant": true}, map[string]bool{"partner": true}
+	tests := []struct {
+		name     string
+		accepted map[string]bool
+		body     string
+		skipped  bool
+	}{
+		{"accepted zone", iplant, `{"entity": "fakeid", ` + path + `, "zone": "iplant"}`, false},
+		{"foreign zone", iplant, `{"entity": "fakeid", ` + path + `, "zone": "partner"}`, true},
+		{"zone from the path", iplant, `{"entity": "fakeid", ` + path + `}`, false},
+		{"path in a foreign zone", partner, `{"entity": "fakeid", ` + path + `}`, true},
+		{"every zone", map[string]bool{}, `{"entity": "fakeid", ` + path + `, "zone": "partner"}`, false},
+	}
+
+	for _, test := range tests {
+		recorder := &fakeRecorder{}
+		svc := newTestService(recorder)
+		svc.acceptedZones = test.accepted
+		key := "data-object.open"
+		before := messageOutcomes.Get(key + "/" + outcomeForeignZone)
+
+		if err := svc.processMessage(amqp.Delivery{RoutingKey: key, Body: []byte(test.body)}); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		expected := int64(0)
+		if test.skipped {
+			expected = 1
+		}
+		if count := messageOutcomes.Get(key+"/"+outcomeForeignZone) - before; count != expected {
+			t.Errorf("%s: expected %d skipped messages but got %d", test.name, expected, count)
+		}
+		if events := atomic.LoadInt64(&recorder.events); events != 1-expected {
+			t.Errorf("%s: expected %d recorded events but got %d", test.name, 1-expected, events)
+		}
+	}
+}